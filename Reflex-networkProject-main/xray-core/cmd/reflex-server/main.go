@@ -0,0 +1,152 @@
+// Command reflex-server is a Reflex server on its own, for a single-purpose
+// endpoint without the rest of Xray: the inbound of package inbound,
+// embedded with a dialer that connects to every destination directly.
+//
+//	reflex-server -config server.json
+//
+// The config is JSON, comments allowed:
+//
+//	{
+//	  "listen": "0.0.0.0:443",
+//	  "logLevel": "warning",
+//	  "settings": {
+//	    "clients": [{ "id": "uuid-string" }],
+//	    "fallback": { "dest": 80 }
+//	  }
+//	}
+//
+// settings are those of a Reflex inbound in an Xray config, so one can be
+// moved between the two as it is. Connections that are not Reflex go to the
+// fallback, or to the decoy it serves, as they would in Xray. On SIGINT or
+// SIGTERM the server stops accepting and drains its sessions, see
+// drainTimeout.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	xerrors "github.com/xtls/xray-core/common/errors"
+	"github.com/xtls/xray-core/common/log"
+	"github.com/xtls/xray-core/infra/conf"
+	json_reader "github.com/xtls/xray-core/infra/conf/json"
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/proxy/reflex/inbound"
+)
+
+var (
+	configFile = flag.String("config", "config.json", "Path of the server's JSON config.")
+	test       = flag.Bool("test", false, "Check the config and exit.")
+)
+
+// serverConfig is the JSON config of the server.
+type serverConfig struct {
+	Listen   string                    `json:"listen"`   // host:port
+	LogLevel string                    `json:"logLevel"` // "debug", "info", "warning" (default), "error" or "none"
+	Settings *conf.ReflexInboundConfig `json:"settings"`
+}
+
+// severities are the log levels of serverConfig.LogLevel.
+var severities = map[string]log.Severity{
+	"debug":   log.Severity_Debug,
+	"info":    log.Severity_Info,
+	"warning": log.Severity_Warning,
+	"error":   log.Severity_Error,
+	"none":    log.Severity_Unknown,
+}
+
+// loadConfig reads the config at path and builds the inbound's.
+func loadConfig(path string) (*serverConfig, *reflex.InboundConfig, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	var c serverConfig
+	if err := json.NewDecoder(&json_reader.Reader{Reader: f}).Decode(&c); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if c.Listen == "" {
+		return nil, nil, errors.New(`"listen" is required`)
+	}
+	if c.LogLevel == "" {
+		c.LogLevel = "warning"
+	}
+	if _, ok := severities[strings.ToLower(c.LogLevel)]; !ok {
+		return nil, nil, errors.New(`"logLevel" must be "debug", "info", "warning", "error" or "none"`)
+	}
+	if c.Settings == nil {
+		return nil, nil, errors.New(`"settings" is required`)
+	}
+	settings, err := c.Settings.Build()
+	if err != nil {
+		return nil, nil, err
+	}
+	return &c, settings.(*reflex.InboundConfig), nil
+}
+
+func main() {
+	flag.Parse()
+	c, settings, err := loadConfig(*configFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "reflex-server:", err)
+		os.Exit(23)
+	}
+	if *test {
+		fmt.Println("Configuration OK.")
+		return
+	}
+	log.ReplaceWithSeverityLogger(severities[strings.ToLower(c.LogLevel)])
+
+	// Sessions are not served under the signal's context: they are ended
+	// by draining, not cut off.
+	ctx := context.Background()
+	stopped, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	h, err := inbound.NewWithDialer(ctx, settings, inbound.DirectDialer)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "reflex-server:", err)
+		os.Exit(23)
+	}
+	ln, err := net.Listen("tcp", c.Listen)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "reflex-server:", err)
+		os.Exit(1)
+	}
+	fmt.Println("reflex-server: listening on", ln.Addr())
+	go func() {
+		<-stopped.Done()
+		_ = ln.Close()
+	}()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if stopped.Err() != nil {
+				break
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			fmt.Fprintln(os.Stderr, "reflex-server:", err)
+			os.Exit(1)
+		}
+		go func() {
+			defer conn.Close()
+			if err := h.Serve(ctx, conn); err != nil {
+				xerrors.LogInfoInner(ctx, err, "reflex-server: connection ends")
+			}
+		}()
+	}
+	fmt.Println("reflex-server: draining sessions")
+	_ = h.Close()
+}
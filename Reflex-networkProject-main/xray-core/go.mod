@@ -3,6 +3,7 @@ module github.com/xtls/xray-core
 go 1.25
 
 require (
+	filippo.io/edwards25519 v1.1.0
 	github.com/cloudflare/circl v1.6.2
 	github.com/ghodss/yaml v1.0.1-0.20220118164431-d8423dcdf344
 	github.com/golang/mock v1.7.0-rc.1
@@ -1,22 +1,196 @@
 package conf
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/xtls/xray-core/common/errors"
+	"github.com/xtls/xray-core/common/uuid"
 	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/proxy/reflex/carrier"
 	"google.golang.org/protobuf/proto"
 )
 
 // ReflexUserConfig mirrors the JSON structure for a single Reflex client.
 type ReflexUserConfig struct {
-	Id     string `json:"id"`
-	Policy string `json:"policy"`
+	Id       string `json:"id"`
+	Policy   string `json:"policy"`
+	PSK      string `json:"psk"` // base64, enables PSK-only handshakes
+	Level    uint32 `json:"level"`
+	Expiry   int64  `json:"expiry"`   // unix seconds; 0 never expires
+	Email    string `json:"email"`    // names the user in stats and access logs; defaults to the id
+	Quota    uint64 `json:"quota"`    // bytes of traffic, both directions; 0 is unlimited
+	Morphing string `json:"morphing"` // "full", "padding-only" or "off"; empty keeps the inbound's
 }
 
-// ReflexFallbackConfig mirrors the JSON structure for Reflex fallback.
+// ReflexFallbackConfig mirrors the JSON structure for Reflex fallback. Dest
+// is a port on 127.0.0.1, or "host:port" with an IPv6 host in brackets, as in
+// "[::1]:80". Jitter is the most, in milliseconds, each chunk of a response
+// is delayed by.
 type ReflexFallbackConfig struct {
-	Dest uint32 `json:"dest"`
+	Dest   json.RawMessage    `json:"dest"`
+	Decoy  *ReflexDecoyConfig `json:"decoy"` // served by the inbound itself on dest
+	Jitter uint32             `json:"jitter"`
+}
+
+// ReflexDecoyConfig builds the cover site from a real website. Mode is
+// "proxy" (the default) or "mirror"; times are in seconds.
+type ReflexDecoyConfig struct {
+	Origin   string `json:"origin"`
+	Mode     string `json:"mode"`
+	CacheTTL uint32 `json:"cacheTtl"`
+	Refresh  uint32 `json:"refresh"`
+	MaxPages uint32 `json:"maxPages"`
+}
+
+// ReflexCoverFrontConfig is one Host and path an HTTP handshake may be sent
+// to. Clients rotate among all of them.
+type ReflexCoverFrontConfig struct {
+	Host string `json:"host"`
+	Path string `json:"path"`
+}
+
+// ReflexStrategyConfig names a wire strategy (see reflex.RegisterStrategy)
+// applied to every connection, e.g. {"name": "split", "args": {"max": "40"}}.
+type ReflexStrategyConfig struct {
+	Name string            `json:"name"`
+	Args map[string]string `json:"args"`
+}
+
+// ReflexConcurrentLoginConfig limits how many distinct source IPs one user
+// may handshake from within Window seconds. Action is "allow", "alert",
+// "limit" or "block".
+type ReflexConcurrentLoginConfig struct {
+	MaxSources uint32 `json:"maxSources"`
+	Window     uint32 `json:"window"`
+	Action     string `json:"action"`
+}
+
+// ReflexProfileSwitchConfig is one step of a policy's profile schedule.
+type ReflexProfileSwitchConfig struct {
+	Profile      string `json:"profile"`
+	AfterBytes   uint64 `json:"afterBytes"`
+	AfterSeconds uint32 `json:"afterSeconds"`
+	OnThrottle   bool   `json:"onThrottle"`
+}
+
+// ReflexPolicyConfig is one entry of the policy section. A rule with a name
+// applies to users whose "policy" matches it; a rule without one applies to
+// users of its level.
+// Example:
+//
+//	{ "level": 0, "profiles": ["http2-api"], "maxBandwidth": 1048576,
+//	  "destinations": ["example.com:443", "10.0.0.0/8"], "sessionTtl": 3600 }
+type ReflexPolicyConfig struct {
+	Name         string                       `json:"name"`
+	Level        uint32                       `json:"level"`
+	Profiles     []string                     `json:"profiles"`
+	MaxBandwidth uint64                       `json:"maxBandwidth"`
+	Features     []string                     `json:"features"`
+	Destinations []string                     `json:"destinations"`
+	SessionTTL   uint32                       `json:"sessionTtl"`
+	WriteTimeout uint32                       `json:"writeTimeout"` // seconds a frame write may take, 0 for the default
+	Switches     []*ReflexProfileSwitchConfig `json:"switches"`
+	Tier         string                       `json:"tier"`
+	Tags         []string                     `json:"tags"`
+}
+
+// ReflexPolicyServerConfig delegates policy decisions to a gRPC PolicyService.
+// Timeout is in milliseconds and CacheTTL in seconds. Without FailOpen an
+// unreachable service denies every handshake.
+type ReflexPolicyServerConfig struct {
+	Address  string `json:"address"`
+	Timeout  uint32 `json:"timeout"`
+	CacheTTL uint32 `json:"cacheTtl"`
+	FailOpen bool   `json:"failOpen"`
+}
+
+// ReflexPortHoppingConfig makes the active port follow a shared secret and
+// the clock. The inbound's "port" must cover basePort to basePort+portCount-1,
+// and its clients' outbounds take the same settings. Interval is in seconds.
+type ReflexPortHoppingConfig struct {
+	Secret    string `json:"secret"`
+	BasePort  uint32 `json:"basePort"`
+	PortCount uint32 `json:"portCount"`
+	Interval  uint32 `json:"interval"`
+}
+
+// build checks the settings of the Reflex inbound or outbound named by who.
+func (ph *ReflexPortHoppingConfig) build(who string) (*reflex.PortHopping, error) {
+	if ph.Secret == "" {
+		return nil, errors.New(who, ` "settings.portHopping.secret" is required`)
+	}
+	if ph.PortCount == 0 || ph.BasePort > 65535 || ph.BasePort+ph.PortCount > 65536 {
+		return nil, errors.New(who, ` "settings.portHopping" range is invalid`)
+	}
+	return &reflex.PortHopping{
+		Secret:    ph.Secret,
+		BasePort:  ph.BasePort,
+		PortCount: ph.PortCount,
+		Interval:  ph.Interval,
+	}, nil
+}
+
+// ReflexLimitsConfig caps the resources of the whole inbound. MaxSessions
+// bounds concurrent connections, HandshakesPerSecond new connections and
+// MaxBufferedBytes the frame payloads in flight. Action is "close" (default),
+// "fallback" or "queue".
+type ReflexLimitsConfig struct {
+	MaxSessions         uint32 `json:"maxSessions"`
+	HandshakesPerSecond uint32 `json:"handshakesPerSecond"`
+	MaxBufferedBytes    uint64 `json:"maxBufferedBytes"`
+	Action              string `json:"action"`
+}
+
+// ReflexCaptureConfig writes the shaped wire traffic of the listed users, or
+// of every user if Users is empty, to a pcap file. HeadersOnly keeps packet
+// sizes and timing but drops the payload.
+type ReflexCaptureConfig struct {
+	Path        string   `json:"path"`
+	Users       []string `json:"users"`
+	HeadersOnly bool     `json:"headersOnly"`
+}
+
+// ReflexLogSamplingConfig bounds how many replay rejections, probe hits and
+// profile choices are logged: Burst of each kind per Interval seconds; the
+// rest are only counted.
+type ReflexLogSamplingConfig struct {
+	Burst    uint32 `json:"burst"`
+	Interval uint32 `json:"interval"`
+}
+
+// ReflexCarriersConfig serves Reflex over carriers other than TCP, for
+// networks that throttle long TCP flows but let UDP through. The inbound
+// listens for them itself, besides its "port".
+type ReflexCarriersConfig struct {
+	QUIC *ReflexQUICCarrierConfig `json:"quic"`
+	UDP  *ReflexUDPCarrierConfig  `json:"udp"`
+}
+
+// ReflexQUICCarrierConfig serves every Reflex connection as a stream of a
+// QUIC connection. Listen is a UDP host:port; the certificate and key are
+// PEM files.
+type ReflexQUICCarrierConfig struct {
+	Listen          string `json:"listen"`
+	CertificateFile string `json:"certificateFile"`
+	KeyFile         string `json:"keyFile"`
+}
+
+// ReflexUDPCarrierConfig serves every Reflex connection as a flow of
+// datagrams with forward error correction: after every FECData datagrams
+// FECParity more are sent, from which lost ones are rebuilt. FECData 0 is
+// the default of 8 and 2. Listen is a UDP host:port.
+type ReflexUDPCarrierConfig struct {
+	Listen    string `json:"listen"`
+	FECData   uint32 `json:"fecData"`
+	FECParity uint32 `json:"fecParity"`
 }
 
 // ReflexInboundConfig is the JSON-level inbound config for Reflex.
+// To spread users over several ports, give the inbound a port list or range
+// ("port": "443,8443,2053-2083"); every port feeds the same handler and users.
 // Example:
 // {
 //   "protocol": "reflex",
@@ -28,30 +202,370 @@ type ReflexFallbackConfig struct {
 //   }
 // }
 type ReflexInboundConfig struct {
-	Clients  []*ReflexUserConfig     `json:"clients"`
-	Fallback *ReflexFallbackConfig   `json:"fallback"`
+	Clients             []*ReflexUserConfig          `json:"clients"`
+	Fallback            *ReflexFallbackConfig        `json:"fallback"`
+	RetryCookie         bool                         `json:"retryCookie"`
+	ConcurrentLogin     *ReflexConcurrentLoginConfig `json:"concurrentLogin"`
+	Policies            []*ReflexPolicyConfig        `json:"policies"`
+	PolicyServer        *ReflexPolicyServerConfig    `json:"policyServer"`
+	DefaultPolicy       string                       `json:"defaultPolicy"`
+	DenyByDefault       bool                         `json:"denyByDefault"`
+	DrainTimeout        uint32                       `json:"drainTimeout"` // seconds
+	HandoffSocket       string                       `json:"handoffSocket"`
+	PortHopping         *ReflexPortHoppingConfig     `json:"portHopping"`
+	HealthPath          string                       `json:"healthPath"`
+	StateFile           string                       `json:"stateFile"` // replay and login state across restarts
+	Limits              *ReflexLimitsConfig          `json:"limits"`
+	Capture             *ReflexCaptureConfig         `json:"capture"`
+	FeedbackPath        string                       `json:"feedbackPath"` // classifier verdicts and per-profile statistics
+	CoverFronts         []*ReflexCoverFrontConfig    `json:"coverFronts"`  // Host and path pairs HTTP handshakes may use
+	Strategy            *ReflexStrategyConfig        `json:"strategy"`
+	LeakageAudit        bool                         `json:"leakageAudit"`  // measure what each session's wire shape reveals
+	KeyLog              string                       `json:"keyLog"`        // session keys for decoding captures; test environments only
+	TranscriptDir       string                       `json:"transcriptDir"` // redacted per-session transcripts for bug reports
+	Pacing              bool                         `json:"pacing"`        // keep morphing gaps on a schedule and pace the socket
+	VLESSInbound        string                       `json:"vlessInbound"`  // tag of a VLESS inbound that serves VLESS clients of this port
+	LogSampling         *ReflexLogSamplingConfig     `json:"logSampling"`
+	HandshakeIDs        string                       `json:"handshakeIds"`        // "static", "both" or "one-time"
+	ResumeRotation      uint32                       `json:"resumeRotation"`      // seconds between resumption key rotations
+	DestinationProfiles map[string]string            `json:"destinationProfiles"` // destination domain to profile, e.g. {"googlevideo.com": "youtube"}
+	UsageInterval       uint32                       `json:"usageInterval"`       // seconds between usage reports to clients that ask for them
+	Morphing            string                       `json:"morphing"`            // "full", "padding-only" or "off"
+	ServerKey           string                       `json:"serverKey"`           // base64 Ed25519 seed the server signs its handshakes with, see "xray reflex keygen"
+	HealthListen        string                       `json:"healthListen"`        // address healthPath is served on, apart from the public port
+	RequireSealed       bool                         `json:"requireSealed"`       // refuse handshakes that carry the user ID in the clear
+	Carriers            *ReflexCarriersConfig        `json:"carriers"`
 }
 
 // Build implements Buildable and converts JSON config into protobuf InboundConfig.
 func (c *ReflexInboundConfig) Build() (proto.Message, error) {
-	cfg := &reflex.InboundConfig{}
+	cfg := &reflex.InboundConfig{
+		RetryCookie:         c.RetryCookie,
+		DefaultPolicy:       c.DefaultPolicy,
+		DenyByDefault:       c.DenyByDefault,
+		DrainTimeout:        c.DrainTimeout,
+		HandoffSocket:       c.HandoffSocket,
+		HealthPath:          c.HealthPath,
+		StateFile:           c.StateFile,
+		FeedbackPath:        c.FeedbackPath,
+		LeakageAudit:        c.LeakageAudit,
+		KeyLog:              c.KeyLog,
+		TranscriptDir:       c.TranscriptDir,
+		Pacing:              c.Pacing,
+		VlessInbound:        c.VLESSInbound,
+		HandshakeIds:        c.HandshakeIDs,
+		ResumeRotation:      c.ResumeRotation,
+		DestinationProfiles: c.DestinationProfiles,
+		UsageInterval:       c.UsageInterval,
+		Morphing:            c.Morphing,
+		ServerKey:           c.ServerKey,
+		HealthListen:        c.HealthListen,
+		RequireSealed:       c.RequireSealed,
+	}
+
+	if _, err := reflex.ParseIDMode(c.HandshakeIDs); err != nil {
+		return nil, errors.New(`Reflex "settings.handshakeIds" must be "static", "both" or "one-time"`)
+	}
+	if _, err := reflex.ParseMorphingMode(c.Morphing); err != nil {
+		return nil, errors.New(`Reflex "settings.morphing" must be "full", "padding-only" or "off"`)
+	}
+	if c.ServerKey != "" {
+		if _, err := reflex.ParseServerKey(c.ServerKey); err != nil {
+			return nil, errors.New(`Reflex "settings.serverKey" must be a private key of "xray reflex keygen"`).Base(err)
+		}
+	}
+	if c.RequireSealed && c.ServerKey == "" {
+		return nil, errors.New(`Reflex "settings.requireSealed" needs "settings.serverKey" to open handshakes with`)
+	}
+	if _, err := reflex.NewDestinationProfiles(c.DestinationProfiles); err != nil {
+		return nil, errors.New(`Reflex "settings.destinationProfiles" maps domains to profile names`).Base(err)
+	}
+
+	if c.HealthPath != "" && !strings.HasPrefix(c.HealthPath, "/") {
+		return nil, errors.New(`Reflex "settings.healthPath" must start with "/"`)
+	}
+	if c.HealthPath != "" && c.HealthListen == "" {
+		return nil, errors.New(`Reflex "settings.healthPath" needs "settings.healthListen", it is not served on the public port`)
+	}
+	if c.FeedbackPath != "" && !strings.HasPrefix(c.FeedbackPath, "/") {
+		return nil, errors.New(`Reflex "settings.feedbackPath" must start with "/"`)
+	}
+	for _, f := range c.CoverFronts {
+		if f == nil || f.Host == "" || !strings.HasPrefix(f.Path, "/") {
+			return nil, errors.New(`Reflex "settings.coverFronts" entries need a host and a path starting with "/"`)
+		}
+		cfg.CoverFronts = append(cfg.CoverFronts, &reflex.CoverFront{Host: f.Host, Path: f.Path})
+	}
+	if st := c.Strategy; st != nil {
+		if _, err := reflex.NewStrategy(st.Name, st.Args); err != nil {
+			return nil, errors.New(`Reflex "settings.strategy" is invalid`).Base(err)
+		}
+		cfg.Strategy = &reflex.WireStrategy{Name: st.Name, Args: st.Args}
+	}
 
 	for _, u := range c.Clients {
 		if u == nil {
 			continue
 		}
+		if _, err := reflex.ParseMorphingMode(u.Morphing); err != nil {
+			return nil, errors.New(`Reflex client "morphing" must be "full", "padding-only" or "off"`)
+		}
 		cfg.Clients = append(cfg.Clients, &reflex.User{
-			Id:     u.Id,
-			Policy: u.Policy,
+			Id:       u.Id,
+			Policy:   u.Policy,
+			Psk:      u.PSK,
+			Level:    u.Level,
+			Expiry:   u.Expiry,
+			Email:    u.Email,
+			Quota:    u.Quota,
+			Morphing: u.Morphing,
 		})
 	}
 
 	if c.Fallback != nil {
+		var dest string
+		var port uint32
+		if err := json.Unmarshal(c.Fallback.Dest, &port); err == nil {
+			dest = strconv.FormatUint(uint64(port), 10)
+		} else if err := json.Unmarshal(c.Fallback.Dest, &dest); err != nil {
+			return nil, errors.New(`Reflex "settings.fallback.dest" must be a port or "host:port"`)
+		}
+		host, port, err := reflex.ParseFallbackDest(dest)
+		if err != nil {
+			return nil, errors.New(`Reflex "settings.fallback.dest"`).Base(err)
+		}
 		cfg.Fallback = &reflex.Fallback{
-			Dest: c.Fallback.Dest,
+			Dest:    port,
+			Address: host,
+			Jitter:  c.Fallback.Jitter,
+		}
+		if d := c.Fallback.Decoy; d != nil {
+			if d.Origin == "" {
+				return nil, errors.New(`Reflex "settings.fallback.decoy.origin" is required`)
+			}
+			if d.Mode != "" && d.Mode != "proxy" && d.Mode != "mirror" {
+				return nil, errors.New(`Reflex "settings.fallback.decoy.mode" must be "proxy" or "mirror"`)
+			}
+			cfg.Fallback.Decoy = &reflex.Decoy{
+				Origin:   d.Origin,
+				Mode:     d.Mode,
+				CacheTtl: d.CacheTTL,
+				Refresh:  d.Refresh,
+				MaxPages: d.MaxPages,
+			}
+		}
+	}
+
+	if c.ConcurrentLogin != nil {
+		cfg.ConcurrentLogin = &reflex.ConcurrentLogin{
+			MaxSources: c.ConcurrentLogin.MaxSources,
+			Window:     c.ConcurrentLogin.Window,
+			Action:     c.ConcurrentLogin.Action,
+		}
+	}
+
+	// Policies are compiled here so that mistakes fail config loading.
+	for _, p := range c.Policies {
+		if p == nil {
+			continue
+		}
+		pc := &reflex.PolicyConfig{
+			Name:         p.Name,
+			Level:        p.Level,
+			Profiles:     p.Profiles,
+			MaxBandwidth: p.MaxBandwidth,
+			Features:     p.Features,
+			Destinations: p.Destinations,
+			SessionTtl:   p.SessionTTL,
+			WriteTimeout: p.WriteTimeout,
+			Tier:         p.Tier,
+			Tags:         p.Tags,
+		}
+		for _, sw := range p.Switches {
+			if sw == nil {
+				continue
+			}
+			pc.Switches = append(pc.Switches, &reflex.ProfileSwitchConfig{
+				Profile:      sw.Profile,
+				AfterBytes:   sw.AfterBytes,
+				AfterSeconds: sw.AfterSeconds,
+				OnThrottle:   sw.OnThrottle,
+			})
+		}
+		if _, err := reflex.PolicyRuleFromConfig(pc); err != nil {
+			return nil, err
+		}
+		cfg.Policies = append(cfg.Policies, pc)
+	}
+
+	if c.DefaultPolicy != "" {
+		found := false
+		for _, p := range cfg.Policies {
+			found = found || p.Name == c.DefaultPolicy
+		}
+		if !found {
+			return nil, errors.New(`Reflex "settings.defaultPolicy" names undefined policy "` + c.DefaultPolicy + `"`)
+		}
+	}
+
+	if c.PolicyServer != nil {
+		cfg.PolicyServer = &reflex.PolicyServer{
+			Address:  c.PolicyServer.Address,
+			Timeout:  c.PolicyServer.Timeout,
+			CacheTtl: c.PolicyServer.CacheTTL,
+			FailOpen: c.PolicyServer.FailOpen,
+		}
+	}
+
+	if ph := c.PortHopping; ph != nil {
+		hopping, err := ph.build("Reflex")
+		if err != nil {
+			return nil, err
+		}
+		cfg.PortHopping = hopping
+	}
+	if cs := c.Carriers; cs != nil {
+		if c.PortHopping != nil {
+			return nil, errors.New(`Reflex "settings.carriers" cannot be used with "settings.portHopping"`)
+		}
+		cfg.Carriers = &reflex.Carriers{}
+		if q := cs.QUIC; q != nil {
+			if q.Listen == "" || q.CertificateFile == "" || q.KeyFile == "" {
+				return nil, errors.New(`Reflex "settings.carriers.quic" needs "listen", "certificateFile" and "keyFile"`)
+			}
+			cfg.Carriers.Quic = &reflex.QUICCarrier{
+				Listen:          q.Listen,
+				CertificateFile: q.CertificateFile,
+				KeyFile:         q.KeyFile,
+			}
+		}
+		if u := cs.UDP; u != nil {
+			if u.Listen == "" {
+				return nil, errors.New(`Reflex "settings.carriers.udp.listen" is required`)
+			}
+			if _, err := carrier.FECOf(u.FECData, u.FECParity); err != nil {
+				return nil, errors.New(`Reflex "settings.carriers.udp" FEC group is invalid`).Base(err)
+			}
+			cfg.Carriers.Udp = &reflex.UDPCarrier{
+				Listen:    u.Listen,
+				FecData:   u.FECData,
+				FecParity: u.FECParity,
+			}
+		}
+	}
+	if l := c.Limits; l != nil {
+		if _, err := reflex.ParseLimitAction(l.Action); err != nil {
+			return nil, errors.New(`Reflex "settings.limits.action" must be "close", "fallback" or "queue"`)
+		}
+		cfg.Limits = &reflex.ResourceLimits{
+			MaxSessions:         l.MaxSessions,
+			HandshakesPerSecond: l.HandshakesPerSecond,
+			MaxBufferedBytes:    l.MaxBufferedBytes,
+			Action:              l.Action,
+		}
+	}
+	if cp := c.Capture; cp != nil {
+		if cp.Path == "" {
+			return nil, errors.New(`Reflex "settings.capture.path" is required`)
+		}
+		cfg.Capture = &reflex.Capture{
+			Path:        cp.Path,
+			Users:       cp.Users,
+			HeadersOnly: cp.HeadersOnly,
+		}
+	}
+	if ls := c.LogSampling; ls != nil {
+		cfg.LogSampling = &reflex.LogSampling{
+			Burst:    ls.Burst,
+			Interval: ls.Interval,
 		}
 	}
 
 	return cfg, nil
 }
 
+// ReflexOutboundConfig is the JSON settings of a Reflex outbound: the server
+// and the id of the user to connect as, as written by "xray reflex migrate".
+// Morphing is how much of the server's profile shapes what the client sends.
+// With Mux the connections share one session as its streams. ServerKey, the
+// server's public identity key, authenticates the server and seals the
+// handshake to it, so the user ID is not sent in the clear. PSK is the
+// user's pre-shared key, if the server has one for them, and OneTime sends
+// one-time IDs in place of the UUID. With PortHopping, as on the server,
+// Port may be left out. Carrier "quic" connects to the server's QUIC
+// carrier, on Port over UDP, with ServerName as its certificate's name, and
+// "udp" to its UDP carrier, with the server's FECData and FECParity.
+type ReflexOutboundConfig struct {
+	Address   *Address `json:"address"`
+	Port      uint16   `json:"port"`
+	ID        string   `json:"id"`
+	Morphing  string   `json:"morphing"` // "full", "padding-only" or "off"
+	Mux       bool     `json:"mux"`
+	ServerKey string   `json:"serverKey"` // base64 Ed25519 public key, see "xray reflex keygen"
+	PSK       string   `json:"psk"`       // base64, as in the server's "clients"
+	OneTime   bool     `json:"oneTime"`   // the server's "handshakeIds" must be "both" or "one-time"
+
+	PortHopping *ReflexPortHoppingConfig `json:"portHopping"`
+	Carrier     string                   `json:"carrier"`    // "tcp" (default) or "quic", see the server's "carriers"
+	ServerName  string                   `json:"serverName"` // not checked with serverKey, which authenticates the server
+	FECData     uint32                   `json:"fecData"`
+	FECParity   uint32                   `json:"fecParity"`
+}
+
+// Build implements Buildable.
+func (c *ReflexOutboundConfig) Build() (proto.Message, error) {
+	if c.Address == nil || (c.Port == 0 && c.PortHopping == nil) {
+		return nil, errors.New(`Reflex outbound "settings.address" and "settings.port" are required`)
+	}
+	if _, err := uuid.ParseString(c.ID); err != nil {
+		return nil, errors.New(`Reflex outbound "settings.id" must be a UUID`).Base(err)
+	}
+	if _, err := reflex.ParseMorphingMode(c.Morphing); err != nil {
+		return nil, errors.New(`Reflex outbound "settings.morphing" must be "full", "padding-only" or "off"`)
+	}
+	if c.ServerKey != "" {
+		if _, err := reflex.ParseServerPublicKey(c.ServerKey); err != nil {
+			return nil, errors.New(`Reflex outbound "settings.serverKey" must be a public key of "xray reflex keygen"`).Base(err)
+		}
+	}
+	if c.PSK != "" {
+		if psk, err := base64.StdEncoding.DecodeString(c.PSK); err != nil || len(psk) < reflex.MinPSKSize {
+			return nil, errors.New(`Reflex outbound "settings.psk" must be base64 of at least `, reflex.MinPSKSize, ` bytes`)
+		}
+	}
+	switch c.Carrier {
+	case "", reflex.CarrierTCP, reflex.CarrierQUIC:
+	case reflex.CarrierUDP:
+		if _, err := carrier.FECOf(c.FECData, c.FECParity); err != nil {
+			return nil, errors.New(`Reflex outbound "settings.fecData" and "settings.fecParity" are invalid`).Base(err)
+		}
+	default:
+		return nil, errors.New(`Reflex outbound "settings.carrier" must be "tcp", "quic" or "udp"`)
+	}
+	if c.Carrier != "" && c.Carrier != reflex.CarrierTCP && c.PortHopping != nil {
+		return nil, errors.New(`Reflex outbound "settings.carrier" cannot be used with "settings.portHopping"`)
+	}
+	config := &reflex.OutboundConfig{
+		Address:    c.Address.String(),
+		Port:       uint32(c.Port),
+		Id:         c.ID,
+		Morphing:   c.Morphing,
+		Mux:        c.Mux,
+		ServerKey:  c.ServerKey,
+		Psk:        c.PSK,
+		OneTime:    c.OneTime,
+		Carrier:    c.Carrier,
+		ServerName: c.ServerName,
+		FecData:    c.FECData,
+		FecParity:  c.FECParity,
+	}
+	if ph := c.PortHopping; ph != nil {
+		hopping, err := ph.build("Reflex outbound")
+		if err != nil {
+			return nil, err
+		}
+		config.PortHopping = hopping
+	}
+	return config, nil
+}
+
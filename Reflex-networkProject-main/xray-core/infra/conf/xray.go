@@ -45,6 +45,7 @@ var (
 		"trojan":      func() interface{} { return new(TrojanClientConfig) },
 		"dns":         func() interface{} { return new(DNSOutboundConfig) },
 		"wireguard":   func() interface{} { return &WireGuardConfig{IsClient: true} },
+		"reflex":      func() interface{} { return new(ReflexOutboundConfig) },
 	}, "protocol", "settings")
 )
 
@@ -33,5 +33,7 @@ var CmdAPI = &base.Command{
 		cmdOnlineStats,
 		cmdOnlineStatsIpList,
 		cmdGetAllOnlineUsers,
+		cmdKickUsers,
+		cmdRevokeResumption,
 	},
 }
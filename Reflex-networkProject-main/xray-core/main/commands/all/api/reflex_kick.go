@@ -0,0 +1,64 @@
+package api
+
+import (
+	"fmt"
+
+	reflexService "github.com/xtls/xray-core/proxy/reflex/command"
+
+	"github.com/xtls/xray-core/main/commands/base"
+)
+
+var cmdKickUsers = &base.Command{
+	CustomFlags: true,
+	UsageLine:   "{{.Exec}} api kick [--server=127.0.0.1:8080] [-tag=tag] [-reason=code] <email1> [email2]...",
+	Short:       "Disconnect the live Reflex sessions of users",
+	Long: `
+Disconnect every live session of the given users on Reflex inbounds. The
+clients are told the reason code; the users stay configured and may
+connect again. Requires ReflexService in the API services.
+Arguments:
+	-s, -server
+		The API server address. Default 127.0.0.1:8080
+	-t, -timeout
+		Timeout seconds to call API. Default 3
+	-tag
+		Inbound tag. By default every Reflex inbound
+	-reason
+		Reason code sent to the clients, such as "abuse" or "payment"
+Example:
+    {{.Exec}} {{.LongName}} --server=127.0.0.1:8080 -reason=payment "xray@love.com" ...
+`,
+	Run: executeKickUsers,
+}
+
+func executeKickUsers(cmd *base.Command, args []string) {
+	setSharedFlags(cmd)
+	var tag, reason string
+	cmd.Flag.StringVar(&tag, "tag", "", "")
+	cmd.Flag.StringVar(&reason, "reason", "", "")
+	cmd.Flag.Parse(args)
+	emails := cmd.Flag.Args()
+	if len(emails) < 1 {
+		base.Fatalf("no user specified")
+	}
+
+	conn, ctx, close := dialAPIServer()
+	defer close()
+	client := reflexService.NewReflexServiceClient(conn)
+
+	var sessions uint32
+	for _, email := range emails {
+		resp, err := client.KickUser(ctx, &reflexService.KickUserRequest{
+			Email:  email,
+			Reason: reason,
+			Tag:    tag,
+		})
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		fmt.Println("kick user:", email, resp.Sessions, "session(s)")
+		sessions += resp.Sessions
+	}
+	fmt.Println("Disconnected", sessions, "session(s) in total.")
+}
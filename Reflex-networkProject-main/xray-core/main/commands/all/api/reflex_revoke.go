@@ -0,0 +1,62 @@
+package api
+
+import (
+	"fmt"
+
+	reflexService "github.com/xtls/xray-core/proxy/reflex/command"
+
+	"github.com/xtls/xray-core/main/commands/base"
+)
+
+var cmdRevokeResumption = &base.Command{
+	CustomFlags: true,
+	UsageLine:   "{{.Exec}} api revokeresume [--server=127.0.0.1:8080] [-tag=tag] <email1> [email2]...",
+	Short:       "Stop the live Reflex sessions of users from being resumed",
+	Long: `
+Revoke the resumption keys of every live session of the given users on
+Reflex inbounds: the sessions go on on their current connections, but can
+no longer be resumed on another one or given more bonding lanes. Use it
+with "api kick" to cut off a compromised client. Requires ReflexService in
+the API services.
+Arguments:
+	-s, -server
+		The API server address. Default 127.0.0.1:8080
+	-t, -timeout
+		Timeout seconds to call API. Default 3
+	-tag
+		Inbound tag. By default every Reflex inbound
+Example:
+    {{.Exec}} {{.LongName}} --server=127.0.0.1:8080 "xray@love.com" ...
+`,
+	Run: executeRevokeResumption,
+}
+
+func executeRevokeResumption(cmd *base.Command, args []string) {
+	setSharedFlags(cmd)
+	var tag string
+	cmd.Flag.StringVar(&tag, "tag", "", "")
+	cmd.Flag.Parse(args)
+	emails := cmd.Flag.Args()
+	if len(emails) < 1 {
+		base.Fatalf("no user specified")
+	}
+
+	conn, ctx, close := dialAPIServer()
+	defer close()
+	client := reflexService.NewReflexServiceClient(conn)
+
+	var sessions uint32
+	for _, email := range emails {
+		resp, err := client.RevokeResumption(ctx, &reflexService.RevokeResumptionRequest{
+			Email: email,
+			Tag:   tag,
+		})
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		fmt.Println("revoke resumption:", email, resp.Sessions, "session(s)")
+		sessions += resp.Sessions
+	}
+	fmt.Println("Revoked", sessions, "session(s) in total.")
+}
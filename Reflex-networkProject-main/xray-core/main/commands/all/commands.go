@@ -3,6 +3,7 @@ package all
 import (
 	"github.com/xtls/xray-core/main/commands/all/api"
 	"github.com/xtls/xray-core/main/commands/all/convert"
+	"github.com/xtls/xray-core/main/commands/all/reflex"
 	"github.com/xtls/xray-core/main/commands/all/tls"
 	"github.com/xtls/xray-core/main/commands/base"
 )
@@ -13,6 +14,7 @@ func init() {
 		api.CmdAPI,
 		convert.CmdConvert,
 		tls.CmdTLS,
+		reflex.CmdReflex,
 		cmdUUID,
 		cmdX25519,
 		cmdWG,
@@ -0,0 +1,163 @@
+package reflex
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/xtls/xray-core/main/commands/base"
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/proxy/reflex/capture"
+	"github.com/xtls/xray-core/proxy/reflex/decode"
+	"github.com/xtls/xray-core/proxy/reflex/frame"
+)
+
+// cmdDecode is the reflex decode command
+var cmdDecode = &base.Command{
+	UsageLine: "{{.Exec}} reflex decode {-key <hex> | -keylog <file>} [-client <host:port>] [-payload] <capture.pcap>",
+	Short:     "Decode the frames of a captured Reflex session",
+	Long: `
+Read a pcap capture of Reflex traffic, such as one written by the capture
+setting of a Reflex inbound, find the sessions the given keys belong to and
+print their handshake and frame sequence in both directions.
+
+Arguments:
+
+	-key
+		The 32-byte session key, hex encoded.
+
+	-keylog
+		A key log written by the keyLog setting of a Reflex inbound or by
+		a client; every session it has a key for is decoded.
+
+	-client
+		Only decode the connection from this client address. By default
+		every connection is tried and those the key does not confirm are
+		skipped.
+
+	-payload
+		Also print a hex dump of every frame payload.
+`,
+}
+
+func init() {
+	cmdDecode.Run = executeDecode // break init loop
+}
+
+var (
+	decodeKey     = cmdDecode.Flag.String("key", "", "")
+	decodeKeyLog  = cmdDecode.Flag.String("keylog", "", "")
+	decodeClient  = cmdDecode.Flag.String("client", "", "")
+	decodePayload = cmdDecode.Flag.Bool("payload", false, "")
+)
+
+func executeDecode(cmd *base.Command, args []string) {
+	if cmdDecode.Flag.NArg() < 1 {
+		base.Fatalf("capture file not specified")
+	}
+	var decodeStream func(*capture.Stream) (*decode.Session, error)
+	switch {
+	case *decodeKey != "":
+		key, err := hex.DecodeString(*decodeKey)
+		if err != nil || len(key) != 32 {
+			base.Fatalf("key must be 32 hex encoded bytes")
+		}
+		decodeStream = func(s *capture.Stream) (*decode.Session, error) { return decode.Decode(s, key) }
+	case *decodeKeyLog != "":
+		f, err := os.Open(*decodeKeyLog)
+		if err != nil {
+			base.Fatalf("Failed to open key log: %s", err)
+		}
+		keys, err := reflex.ReadKeyLog(f)
+		_ = f.Close()
+		if err != nil {
+			base.Fatalf("Failed to read key log: %s", err)
+		}
+		decodeStream = func(s *capture.Stream) (*decode.Session, error) { return decode.DecodeKeyLog(s, keys) }
+	default:
+		base.Fatalf("neither key nor key log specified")
+	}
+	f, err := os.Open(cmdDecode.Flag.Arg(0))
+	if err != nil {
+		base.Fatalf("Failed to open capture: %s", err)
+	}
+	streams, err := capture.ReadStreams(f)
+	_ = f.Close()
+	if err != nil {
+		base.Fatalf("Failed to read capture: %s", err)
+	}
+
+	decoded := 0
+	for _, stream := range streams {
+		if *decodeClient != "" && stream.Client.String() != *decodeClient {
+			continue
+		}
+		s, err := decodeStream(stream)
+		if err != nil {
+			if *decodeClient != "" {
+				base.Fatalf("Failed to decode %s: %s", stream.Client, err)
+			}
+			continue
+		}
+		if !s.KeyConfirmed && *decodeClient == "" {
+			continue
+		}
+		decoded++
+		printSession(s)
+	}
+	if decoded == 0 {
+		base.Fatalf("no session in the capture matches the keys")
+	}
+}
+
+func printSession(s *decode.Session) {
+	fmt.Printf("session %s -> %s\n", s.Client, s.Server)
+	hs := s.Handshake
+	if s.Sealed {
+		fmt.Printf("  sealed handshake, %d bytes padding\n", len(hs.Padding))
+	} else {
+		fmt.Printf("  user %s, timestamp %s, %d bytes padding\n", uuid.UUID(hs.UserID), time.Unix(hs.Timestamp, 0).UTC().Format(time.RFC3339), len(hs.Padding))
+	}
+	if s.Front != "" {
+		fmt.Printf("  posted to %s\n", s.Front)
+	}
+	switch {
+	case s.Status == 0:
+		fmt.Println("  no answer from the server")
+	case s.Retry:
+		fmt.Println("  server asked for a retry")
+	case s.Grant != nil:
+		fmt.Printf("  granted profile %q, features [%s]\n", s.Grant.Profile, strings.Join(s.Grant.Features, " "))
+	case s.Status != http.StatusOK:
+		fmt.Printf("  server answered %d\n", s.Status)
+	}
+
+	var start time.Time
+	if len(s.Frames) > 0 {
+		start = s.Frames[0].At
+	}
+	for _, f := range s.Frames {
+		dir := "S>C"
+		if f.FromClient {
+			dir = "C>S"
+		}
+		fmt.Printf("  %+10.6fs %s %-18s %6d bytes (%d on the wire)\n", f.At.Sub(start).Seconds(), dir, frame.TypeName(f.Type), len(f.Payload), f.WireSize)
+		if *decodePayload && len(f.Payload) > 0 {
+			for _, line := range strings.SplitAfter(strings.TrimSuffix(hex.Dump(f.Payload), "\n"), "\n") {
+				fmt.Printf("      %s", line)
+			}
+			fmt.Println()
+		}
+	}
+	if errors.Is(s.Err, decode.ErrWrongKey) {
+		fmt.Println("  the key does not match this session")
+	} else if s.Err != nil {
+		fmt.Printf("  decoding stopped: %s\n", s.Err)
+	}
+}
@@ -0,0 +1,32 @@
+package reflex
+
+import (
+	"fmt"
+
+	"github.com/xtls/xray-core/main/commands/base"
+	"github.com/xtls/xray-core/proxy/reflex"
+)
+
+// cmdKeygen is the reflex keygen command
+var cmdKeygen = &base.Command{
+	UsageLine: "{{.Exec}} reflex keygen",
+	Short:     "Generate a Reflex server identity key",
+	Long: `
+Generate an Ed25519 identity key pair for a Reflex server. The private key
+goes in the serverKey setting of the inbound, which then signs every
+handshake it answers; the public key goes in the serverKey setting of the
+clients' outbounds, which then refuse servers that cannot sign with it.
+`,
+}
+
+func init() {
+	cmdKeygen.Run = executeKeygen // break init loop
+}
+
+func executeKeygen(cmd *base.Command, args []string) {
+	private, public, err := reflex.GenerateServerKey()
+	if err != nil {
+		base.Fatalf("failed to generate key: %s", err)
+	}
+	fmt.Printf("Private key: %s\nPublic key: %s\n", private, public)
+}
@@ -0,0 +1,65 @@
+package reflex
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/xtls/xray-core/main/commands/base"
+	"github.com/xtls/xray-core/proxy/reflex/migrate"
+)
+
+// cmdMigrate is the reflex migrate command
+var cmdMigrate = &base.Command{
+	UsageLine: "{{.Exec}} reflex migrate [-o <file>] [config.json]",
+	Short:     "Convert VLESS configuration to Reflex",
+	Long: `
+Convert a VLESS inbound or outbound block, or every VLESS inbound and
+outbound of a whole configuration, to Reflex. Clients, the default
+fallback and the server and user of outbounds are carried over, and
+streamSettings, TLS included, are kept. Settings Reflex has no equivalent
+for are dropped, with a warning on stderr for each.
+
+The configuration is read from the given file, or from stdin.
+
+Arguments:
+
+	-o
+		Write the converted configuration to this file instead of stdout.
+`,
+}
+
+func init() {
+	cmdMigrate.Run = executeMigrate // break init loop
+}
+
+var migrateOutput = cmdMigrate.Flag.String("o", "", "")
+
+func executeMigrate(cmd *base.Command, args []string) {
+	var in []byte
+	var err error
+	if cmdMigrate.Flag.NArg() > 0 {
+		in, err = os.ReadFile(cmdMigrate.Flag.Arg(0))
+	} else {
+		in, err = io.ReadAll(os.Stdin)
+	}
+	if err != nil {
+		base.Fatalf("Failed to read configuration: %s", err)
+	}
+	result, err := migrate.Convert(in)
+	if err != nil {
+		base.Fatalf("Failed to convert: %s", err)
+	}
+	for _, w := range result.Warnings {
+		fmt.Fprintln(os.Stderr, "warning:", w)
+	}
+	out := append(result.Config, '\n')
+	if *migrateOutput == "" {
+		_, err = os.Stdout.Write(out)
+	} else {
+		err = os.WriteFile(*migrateOutput, out, 0o600)
+	}
+	if err != nil {
+		base.Fatalf("Failed to write configuration: %s", err)
+	}
+}
@@ -0,0 +1,96 @@
+package reflex
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/google/uuid"
+
+	"github.com/xtls/xray-core/main/commands/base"
+	"github.com/xtls/xray-core/proxy/reflex/probe"
+)
+
+// cmdProbe is the reflex probe command
+var cmdProbe = &base.Command{
+	UsageLine: "{{.Exec}} reflex probe -decoy <host:port> [-user <uuid>] [-timeout <duration>] <host:port>",
+	Short:     "Probe a Reflex server like a censor would",
+	Long: `
+Send known censor probes (random bytes, TLS and HTTP probes, replayed and
+truncated handshakes) to a Reflex server and to its decoy site, and report
+every probe the server answers differently from the decoy. The command
+exits with status 1 if any probe tells the two apart.
+
+Arguments:
+
+	-decoy
+		The address of the decoy site the server falls back to.
+
+	-user
+		A user of the server, so the replayed handshake replays a valid
+		one. A random user is used by default.
+
+	-timeout
+		How long to wait for each response. Default 5s.
+`,
+}
+
+func init() {
+	cmdProbe.Run = executeProbe // break init loop
+}
+
+var (
+	probeDecoy   = cmdProbe.Flag.String("decoy", "", "")
+	probeUser    = cmdProbe.Flag.String("user", "", "")
+	probeTimeout = cmdProbe.Flag.Duration("timeout", probe.DefaultTimeout, "")
+)
+
+func executeProbe(cmd *base.Command, args []string) {
+	if cmdProbe.Flag.NArg() < 1 {
+		base.Fatalf("server not specified")
+	}
+	if *probeDecoy == "" {
+		base.Fatalf("decoy not specified")
+	}
+	var user uuid.UUID
+	if *probeUser != "" {
+		u, err := uuid.Parse(*probeUser)
+		if err != nil {
+			base.Fatalf("invalid user: %s", err)
+		}
+		user = u
+	}
+	host, _, err := net.SplitHostPort(*probeDecoy)
+	if err != nil {
+		base.Fatalf("invalid decoy: %s", err)
+	}
+
+	probes, err := probe.Probes(host, user)
+	if err != nil {
+		base.Fatalf("Failed to build probes: %s", err)
+	}
+	results, err := probe.Run(&probe.Options{
+		Server:  cmdProbe.Flag.Arg(0),
+		Decoy:   *probeDecoy,
+		Timeout: *probeTimeout,
+	}, probes)
+	if err != nil {
+		base.Fatalf("Failed to probe: %s", err)
+	}
+
+	distinguishable := 0
+	for _, r := range results {
+		if !r.Distinguishable() {
+			fmt.Printf("%-24s ok\n", r.Probe)
+			continue
+		}
+		distinguishable++
+		fmt.Printf("%-24s DISTINGUISHABLE: %s\n", r.Probe, r.Difference)
+		fmt.Printf("%-24s   server: %s after %v, %d bytes\n", "", r.Server.End, r.Server.Elapsed, len(r.Server.Data))
+		fmt.Printf("%-24s   decoy:  %s after %v, %d bytes\n", "", r.Decoy.End, r.Decoy.Elapsed, len(r.Decoy.Data))
+	}
+	fmt.Println("-------------------")
+	fmt.Printf("%d of %d probes tell the server apart from the decoy\n", distinguishable, len(results))
+	if distinguishable > 0 {
+		base.SetExitStatus(1)
+	}
+}
@@ -0,0 +1,69 @@
+package reflex
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/xtls/xray-core/main/commands/base"
+	"github.com/xtls/xray-core/proxy/reflex/pt"
+)
+
+// cmdPT is the reflex pt command
+var cmdPT = &base.Command{
+	UsageLine: "{{.Exec}} reflex pt",
+	Short:     "Run Reflex as a Pluggable Transport",
+	Long: `
+Run Reflex as a managed Pluggable Transport, for Tor and other PT-aware
+applications. It is configured by the parent process through the TOR_PT_*
+environment variables and reports to it on stdout; it is not meant to be
+run by hand. In torrc:
+
+	ClientTransportPlugin reflex exec /usr/bin/xray reflex pt
+	Bridge reflex 203.0.113.1:443 id=<uuid>
+
+on clients, where the bridge takes "id", "psk" and "one-time=1", and
+
+	ServerTransportPlugin reflex exec /usr/bin/xray reflex pt
+	ServerTransportListenAddr reflex 0.0.0.0:443
+	ServerTransportOptions reflex id=<uuid> fallback=80
+
+on bridges. Without an id the bridge makes one up; the bridge line for it
+is written to reflex_bridgeline.txt in the transport's state directory.
+`,
+}
+
+func init() {
+	cmdPT.Run = executePT // break init loop
+}
+
+func executePT(cmd *base.Command, args []string) {
+	out := pt.NewOutput(os.Stdout)
+	env, err := pt.ReadEnv(os.Getenv, out)
+	if err != nil {
+		base.Fatalf("%s", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if env.IsServer() {
+		_, err = pt.StartServer(ctx, env, out)
+	} else {
+		_, err = pt.StartClient(env, out)
+	}
+	if err != nil {
+		base.Fatalf("%s", err)
+	}
+
+	done := make(chan os.Signal, 1)
+	signal.Notify(done, os.Interrupt, syscall.SIGTERM)
+	if env.ExitOnStdinClose {
+		go func() {
+			_, _ = io.Copy(io.Discard, os.Stdin)
+			done <- syscall.SIGTERM
+		}()
+	}
+	// Live connections are not waited for: the parent is going away.
+	<-done
+}
@@ -0,0 +1,20 @@
+package reflex
+
+import (
+	"github.com/xtls/xray-core/main/commands/base"
+)
+
+// CmdReflex holds all reflex sub commands
+var CmdReflex = &base.Command{
+	UsageLine: "{{.Exec}} reflex",
+	Short:     "Reflex tools",
+	Long: `{{.Exec}} {{.LongName}} provides tools for Reflex servers.
+`,
+	Commands: []*base.Command{
+		cmdProbe,
+		cmdDecode,
+		cmdMigrate,
+		cmdPT,
+		cmdKeygen,
+	},
+}
@@ -48,6 +48,7 @@ import (
 	_ "github.com/xtls/xray-core/proxy/vmess/inbound"
 	_ "github.com/xtls/xray-core/proxy/vmess/outbound"
 	_ "github.com/xtls/xray-core/proxy/wireguard"
+	_ "github.com/xtls/xray-core/proxy/reflex/command"
 	_ "github.com/xtls/xray-core/proxy/reflex/inbound"
 	_ "github.com/xtls/xray-core/proxy/reflex/outbound"
 
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/xtls/xray-core/common/errors"
+	"github.com/xtls/xray-core/core"
+	"github.com/xtls/xray-core/features/inbound"
+	"github.com/xtls/xray-core/proxy"
+)
+
+// reloadable is implemented by inbound proxies that can apply new settings
+// without dropping established connections.
+type reloadable interface {
+	Reload(ctx context.Context, config proto.Message) error
+}
+
+// reloadInbounds re-reads the config files and hands the settings of every
+// tagged inbound to its running proxy, if the proxy supports reloading.
+// Adding or removing inbounds and changing listeners still needs a restart.
+func reloadInbounds(server core.Server) error {
+	instance, ok := server.(*core.Instance)
+	if !ok {
+		return errors.New("server does not support reloading")
+	}
+	configFiles := getConfigFilePath(false)
+	c, err := core.LoadConfig(getConfigFormat(), configFiles)
+	if err != nil {
+		return errors.New("failed to load config files: [", configFiles.String(), "]").Base(err)
+	}
+
+	ctx := context.Background()
+	im := instance.GetFeature(inbound.ManagerType()).(inbound.Manager)
+	for _, ic := range c.Inbound {
+		if ic.Tag == "" || ic.ProxySettings == nil {
+			continue
+		}
+		handler, err := im.GetHandler(ctx, ic.Tag)
+		if err != nil {
+			errors.LogWarningInner(ctx, err, "inbound ", ic.Tag, " is not running, restart to add it")
+			continue
+		}
+		gi, ok := handler.(proxy.GetInbound)
+		if !ok {
+			continue
+		}
+		r, ok := gi.GetInbound().(reloadable)
+		if !ok {
+			continue
+		}
+		settings, err := ic.ProxySettings.GetInstance()
+		if err != nil {
+			return errors.New("failed to read settings of inbound ", ic.Tag).Base(err)
+		}
+		if err := r.Reload(ctx, settings); err != nil {
+			return errors.New("failed to reload inbound ", ic.Tag).Base(err)
+		}
+		errors.LogInfo(ctx, "reloaded inbound ", ic.Tag)
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
@@ -105,8 +106,16 @@ func executeRun(cmd *base.Command, args []string) {
 
 	{
 		osSignals := make(chan os.Signal, 1)
-		signal.Notify(osSignals, os.Interrupt, syscall.SIGTERM)
-		<-osSignals
+		signal.Notify(osSignals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+		// SIGHUP reloads the settings of inbounds that support it.
+		for sig := range osSignals {
+			if sig != syscall.SIGHUP {
+				break
+			}
+			if err := reloadInbounds(server); err != nil {
+				errors.LogWarningInner(context.Background(), err, "failed to reload config")
+			}
+		}
 	}
 }
 
@@ -0,0 +1,101 @@
+package reflex
+
+import (
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/xtls/xray-core/common/protocol"
+)
+
+// AsAccount implements protocol.AsAccount, so that protocol.User.ToMemoryUser
+// works for Reflex users as it does for other protocols.
+func (a *Account) AsAccount() (protocol.Account, error) {
+	account := &MemoryAccount{
+		Id:     a.Id,
+		Policy: a.Policy,
+		Quota:  a.Quota,
+	}
+	if a.Morphing != "" {
+		mode, err := ParseMorphingMode(a.Morphing)
+		if err != nil {
+			return nil, fmt.Errorf("reflex: client %s: %w", a.Id, err)
+		}
+		account.Morphing = &mode
+	}
+	if a.Psk != "" {
+		psk, err := base64.StdEncoding.DecodeString(a.Psk)
+		if err != nil {
+			return nil, fmt.Errorf("reflex: invalid psk for client %s: %w", a.Id, err)
+		}
+		if len(psk) < MinPSKSize {
+			return nil, fmt.Errorf("reflex: psk for client %s must be at least %d bytes", a.Id, MinPSKSize)
+		}
+		account.PSK = psk
+	}
+	if a.Expiry > 0 {
+		account.Expiry = time.Unix(a.Expiry, 0)
+	}
+	return account, nil
+}
+
+// MemoryAccount implements protocol.Account for Reflex.
+type MemoryAccount struct {
+	Id     string
+	Policy string    // name of the policy rule that applies to the user
+	PSK    []byte    // pre-shared key for PSK-only handshakes; nil disables them
+	Expiry time.Time // handshakes are refused from then on; zero never expires
+	Quota  uint64    // bytes of traffic the user may move, both directions; zero is unlimited
+	// Morphing, if set, overrides the morphing mode of the inbound for the
+	// user's sessions.
+	Morphing *MorphingMode
+}
+
+// Equals implements protocol.Account.
+func (a *MemoryAccount) Equals(account protocol.Account) bool {
+	reflexAccount, ok := account.(*MemoryAccount)
+	if !ok {
+		return false
+	}
+	return a.Id == reflexAccount.Id
+}
+
+// Expired reports whether the account has expired at now.
+func (a *MemoryAccount) Expired(now time.Time) bool {
+	return !a.Expiry.IsZero() && !now.Before(a.Expiry)
+}
+
+// Secret returns the key that in-session challenges are answered with: the
+// PSK if the user has one, otherwise the raw UUID bytes.
+func (a *MemoryAccount) Secret() []byte {
+	if a.PSK != nil {
+		return a.PSK
+	}
+	id, err := uuid.Parse(a.Id)
+	if err != nil {
+		return []byte(a.Id)
+	}
+	return id[:]
+}
+
+// ToProto implements protocol.Account.
+func (a *MemoryAccount) ToProto() proto.Message {
+	account := &Account{
+		Id:     a.Id,
+		Policy: a.Policy,
+		Quota:  a.Quota,
+	}
+	if a.PSK != nil {
+		account.Psk = base64.StdEncoding.EncodeToString(a.PSK)
+	}
+	if !a.Expiry.IsZero() {
+		account.Expiry = a.Expiry.Unix()
+	}
+	if a.Morphing != nil {
+		account.Morphing = a.Morphing.String()
+	}
+	return account
+}
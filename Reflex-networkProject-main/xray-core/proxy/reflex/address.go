@@ -0,0 +1,85 @@
+package reflex
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// DefaultFallbackHost is the host a fallback without an address is dialed at.
+const DefaultFallbackHost = "127.0.0.1"
+
+// Target returns the host:port the fallback is dialed at, with an IPv6 host
+// in brackets.
+func (f *Fallback) Target() string {
+	host := unbracket(f.GetAddress())
+	if host == "" {
+		host = DefaultFallbackHost
+	}
+	return net.JoinHostPort(host, strconv.FormatUint(uint64(f.GetDest()), 10))
+}
+
+// ParseFallbackDest parses the destination of a fallback: a port, which
+// leaves the host to DefaultFallbackHost, or host:port, where an IPv6 host
+// is written in brackets as in "[::1]:80".
+func ParseFallbackDest(dest string) (host string, port uint32, err error) {
+	p := dest
+	if !isPort(dest) {
+		h, ps, err := net.SplitHostPort(dest)
+		if err != nil {
+			return "", 0, errors.New("reflex: invalid fallback destination " + dest + ", expected a port or host:port with an IPv6 host in brackets")
+		}
+		if h == "" {
+			return "", 0, errors.New("reflex: empty host in fallback destination " + dest)
+		}
+		host, p = h, ps
+	}
+	n, err := strconv.ParseUint(p, 10, 16)
+	if err != nil || n == 0 {
+		return "", 0, errors.New("reflex: invalid port in fallback destination " + dest)
+	}
+	return host, uint32(n), nil
+}
+
+// SourceHost returns the host part of a peer address, the key logins,
+// retry cookies and interference are tracked by. An IPv4 peer accepted on a
+// dual-stack socket shows up as an IPv4-mapped IPv6 address; it is keyed by
+// its IPv4 address, as it would be on an IPv4 socket, and the zone of a
+// link-local peer is dropped.
+func SourceHost(addr string) string {
+	host := addr
+	if h, _, err := net.SplitHostPort(addr); err == nil {
+		host = h
+	}
+	host = unbracket(host)
+	if i := strings.IndexByte(host, '%'); i >= 0 && net.ParseIP(host[:i]) != nil {
+		host = host[:i]
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			return ip4.String()
+		}
+		return ip.String()
+	}
+	return host
+}
+
+func unbracket(host string) string {
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		return host[1 : len(host)-1]
+	}
+	return host
+}
+
+func isPort(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,34 @@
+package reflex
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// PolicyAudit records one policy decision: who asked for what, which rule
+// answered and what was granted or why it was denied.
+type PolicyAudit struct {
+	Subject PolicySubject
+	Request *PolicyReq
+	Grant   *PolicyGrant // nil when denied
+	Err     error        // reason for a denial
+}
+
+// String formats the record as key=value pairs for the structured log.
+// Request and grant are encoded as JSON.
+func (a *PolicyAudit) String() string {
+	var b strings.Builder
+	b.WriteString("reflex policy: user=" + a.Subject.UserID)
+	b.WriteString(" policy=" + strconv.Quote(a.Subject.Policy))
+	b.WriteString(" level=" + strconv.Itoa(int(a.Subject.Level)))
+	req, _ := json.Marshal(a.Request)
+	b.WriteString(" request=" + string(req))
+	if a.Err != nil {
+		b.WriteString(" decision=deny reason=" + strconv.Quote(a.Err.Error()))
+		return b.String()
+	}
+	grant, _ := json.Marshal(a.Grant)
+	b.WriteString(" decision=grant rule=" + a.Grant.Rule + " grant=" + string(grant))
+	return b.String()
+}
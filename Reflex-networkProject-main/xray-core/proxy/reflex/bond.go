@@ -0,0 +1,433 @@
+package reflex
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// FeatureBonding is the policy feature that lets a session's frames be
+// spread over several connections, see Bond. Both peers bond a session
+// once it is granted.
+const FeatureBonding = "bonding"
+
+// BondMagic ("RFXB") attaches another connection to an established session
+// as one more lane of its Bond. The request has the layout of a resumption,
+// see ResumeMagic, but is authenticated with "reflex-bond" instead of
+// "reflex-resume", and the connections the session already runs on stay.
+// There is no server response.
+const BondMagic uint32 = 0x52465842
+
+// VerifyBond checks the MAC of a bonding request in constant time.
+func VerifyBond(key, body, mac []byte) bool {
+	return len(key) == 32 && hmac.Equal(attachMAC(key, bondLabel, body), mac)
+}
+
+const (
+	// bondQueue is how many records a lane holds, both for writing and
+	// read but not yet returned.
+	bondQueue = 32
+	// bondGapTimeout bounds how long a bond waits for a missing record
+	// while a lane that may still carry it stays silent.
+	bondGapTimeout = 5 * time.Second
+)
+
+// Bond spreads the records of a session over several connections, its
+// lanes, so that the session gets more throughput on networks that throttle
+// each flow and survives the reset of any one connection. Every record
+// written goes on the lane with the least backlog; records read are put
+// back in the order the peer sealed them, which their counters give. Records
+// lost with a lane are skipped.
+//
+// A Bond reads and writes records for the session it was made for: every
+// Write must be one whole record, as Session writes them, and Read returns
+// the merged records for Session.ReadFrame. It implements net.Conn so that
+// it can take the place of the connection the session was established on.
+type Bond struct {
+	s       *Session
+	primary io.ReadWriter
+	closing chan struct{} // closed by Close; lanes write what they hold and end
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	lanes    []*bondLane
+	turn     int       // rotates the lane ties are broken in favour of
+	next     uint64    // counter of the record Read returns next
+	gapSince time.Time // when Read began waiting for a missing record
+	cur      []byte    // rest of the record being read
+	deadline time.Time // read deadline
+	err      error     // why the last lane was dropped
+	closed   bool
+}
+
+type bondLane struct {
+	conn    io.ReadWriter
+	writes  chan []byte
+	backlog atomic.Int64 // bytes queued for writing
+	done    chan struct{}
+
+	// Guarded by Bond.mu.
+	pending []bondRecord // read, in order, not yet returned
+	dead    bool
+}
+
+type bondRecord struct {
+	counter uint64
+	raw     []byte
+}
+
+// NewBond bonds s with conn, the connection it was established on, as the
+// first lane; r reads conn, and may hold what was read past the handshake.
+// The session must not read or write conn other than through the Bond from
+// now on.
+func NewBond(s *Session, conn io.ReadWriter, r io.Reader) *Bond {
+	b := &Bond{s: s, primary: conn, closing: make(chan struct{})}
+	b.cond = sync.NewCond(&b.mu)
+	s.mu.Lock()
+	if s.readSeen {
+		b.next = s.readNonceCount + 1
+	}
+	s.mu.Unlock()
+	b.Add(conn, r)
+	return b
+}
+
+// Add makes conn, read through r, one more lane of the bond. The returned
+// channel is closed once the lane is dropped, because it failed or the bond
+// was closed; conn is closed then, if it can be.
+func (b *Bond) Add(conn io.ReadWriter, r io.Reader) <-chan struct{} {
+	l := &bondLane{conn: conn, writes: make(chan []byte, bondQueue), done: make(chan struct{})}
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		b.drop(l, net.ErrClosed)
+		return l.done
+	}
+	b.lanes = append(b.lanes, l)
+	b.mu.Unlock()
+	go b.readLane(l, r)
+	go b.writeLane(l)
+	return l.done
+}
+
+// Lanes returns the number of lanes the bond runs on.
+func (b *Bond) Lanes() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := 0
+	for _, l := range b.lanes {
+		if !l.dead {
+			n++
+		}
+	}
+	return n
+}
+
+func (b *Bond) readLane(l *bondLane, r io.Reader) {
+	for {
+		readRecord, writeRecord := b.s.records()
+		rec, err := readRecord(r, b.s.aead.NonceSize(), b.s.overhead())
+		if err != nil {
+			b.drop(l, err)
+			return
+		}
+		var raw bytes.Buffer
+		if err := writeRecord(&raw, rec); err != nil {
+			b.drop(l, err)
+			return
+		}
+		b.mu.Lock()
+		for len(l.pending) >= bondQueue && !l.dead && !b.closed {
+			b.cond.Wait()
+		}
+		if l.dead || b.closed {
+			b.mu.Unlock()
+			return
+		}
+		l.pending = append(l.pending, bondRecord{counter: binary.BigEndian.Uint64(rec.Nonce[4:12]), raw: raw.Bytes()})
+		b.cond.Broadcast()
+		b.mu.Unlock()
+	}
+}
+
+func (b *Bond) writeLane(l *bondLane) {
+	write := func(rec []byte) bool {
+		_, err := l.conn.Write(rec)
+		l.backlog.Add(-int64(len(rec)))
+		if err != nil {
+			b.drop(l, err)
+		}
+		return err == nil
+	}
+	for {
+		select {
+		case rec := <-l.writes:
+			if !write(rec) {
+				return
+			}
+		case <-l.done:
+			return
+		case <-b.closing:
+			for {
+				select {
+				case rec := <-l.writes:
+					if !write(rec) {
+						return
+					}
+				default:
+					b.drop(l, net.ErrClosed)
+					return
+				}
+			}
+		}
+	}
+}
+
+// drop takes l out of the bond for err. Records it has read are still
+// returned.
+func (b *Bond) drop(l *bondLane, err error) {
+	b.mu.Lock()
+	if l.dead {
+		b.mu.Unlock()
+		return
+	}
+	l.dead = true
+	b.err = err
+	b.cond.Broadcast()
+	b.mu.Unlock()
+	close(l.done)
+	if closer, ok := l.conn.(io.Closer); ok {
+		_ = closer.Close()
+	}
+}
+
+func (b *Bond) wake() {
+	b.mu.Lock()
+	b.cond.Broadcast()
+	b.mu.Unlock()
+}
+
+// Read implements io.Reader. It fails once every lane has been dropped and
+// all they read has been returned.
+func (b *Bond) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for len(b.cur) == 0 {
+		if b.closed {
+			return 0, net.ErrClosed
+		}
+		if !b.deadline.IsZero() && !time.Now().Before(b.deadline) {
+			return 0, os.ErrDeadlineExceeded
+		}
+		raw, live := b.nextRecord()
+		if raw != nil {
+			b.cur = raw
+			break
+		}
+		if live == 0 {
+			if b.err == nil {
+				return 0, io.EOF
+			}
+			return 0, b.err
+		}
+		b.cond.Wait()
+	}
+	n := copy(p, b.cur)
+	b.cur = b.cur[n:]
+	return n, nil
+}
+
+// nextRecord returns the next record to read, if it can be told yet, and
+// the number of live lanes. The record with the lowest counter is next when
+// it is the one expected or when no live lane can still carry a lower one:
+// a lane carries records in the order they were sealed, so one that has a
+// record pending will not carry a lower one. A session in OrderingTolerant
+// gets the lowest record pending without waiting.
+func (b *Bond) nextRecord() ([]byte, int) {
+	var min *bondLane
+	live, silent := 0, 0
+	// A tolerant session sorts late records out itself, see SetOrdering.
+	tolerant := b.s.Ordering() == OrderingTolerant
+	lanes := b.lanes[:0]
+	for _, l := range b.lanes {
+		for !tolerant && len(l.pending) > 0 && l.pending[0].counter < b.next {
+			// Arrived after it was given up for lost.
+			l.pending = l.pending[1:]
+		}
+		if l.dead && len(l.pending) == 0 {
+			continue
+		}
+		lanes = append(lanes, l)
+		if !l.dead {
+			live++
+			if len(l.pending) == 0 {
+				silent++
+			}
+		}
+		if len(l.pending) > 0 && (min == nil || l.pending[0].counter < min.pending[0].counter) {
+			min = l
+		}
+	}
+	b.lanes = lanes
+	if min == nil {
+		b.gapSince = time.Time{}
+		return nil, live
+	}
+	rec := min.pending[0]
+	if !tolerant && rec.counter != b.next && silent > 0 {
+		if b.gapSince.IsZero() {
+			b.gapSince = time.Now()
+			time.AfterFunc(bondGapTimeout, b.wake)
+		}
+		if time.Since(b.gapSince) < bondGapTimeout {
+			return nil, live
+		}
+	}
+	b.gapSince = time.Time{}
+	min.pending = min.pending[1:]
+	b.next = max(b.next, rec.counter+1)
+	b.cond.Broadcast()
+	return rec.raw, live
+}
+
+// Write implements io.Writer. p must be one whole record.
+func (b *Bond) Write(p []byte) (int, error) {
+	rec := append([]byte(nil), p...)
+	for {
+		l, err := b.pick()
+		if err != nil {
+			return 0, err
+		}
+		l.backlog.Add(int64(len(rec)))
+		select {
+		case l.writes <- rec:
+			return len(p), nil
+		case <-l.done:
+			l.backlog.Add(-int64(len(rec)))
+		}
+	}
+}
+
+// pick returns the live lane with the least backlog.
+func (b *Bond) pick() (*bondLane, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil, net.ErrClosed
+	}
+	b.turn++
+	var best *bondLane
+	for i := range b.lanes {
+		l := b.lanes[(b.turn+i)%len(b.lanes)]
+		if !l.dead && (best == nil || l.backlog.Load() < best.backlog.Load()) {
+			best = l
+		}
+	}
+	if best == nil {
+		if b.err == nil {
+			return nil, io.ErrClosedPipe
+		}
+		return nil, b.err
+	}
+	return best, nil
+}
+
+// Close closes the bond. What was written is still sent before the lanes
+// are closed.
+func (b *Bond) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+	close(b.closing)
+	b.cond.Broadcast()
+	return nil
+}
+
+// LocalAddr returns the local address of the first lane, if it has one.
+func (b *Bond) LocalAddr() net.Addr {
+	if c, ok := b.primary.(net.Conn); ok {
+		return c.LocalAddr()
+	}
+	return nil
+}
+
+// RemoteAddr returns the remote address of the first lane, if it has one.
+func (b *Bond) RemoteAddr() net.Addr {
+	if c, ok := b.primary.(net.Conn); ok {
+		return c.RemoteAddr()
+	}
+	return nil
+}
+
+// SetDeadline sets the read and write deadlines.
+func (b *Bond) SetDeadline(t time.Time) error {
+	_ = b.SetWriteDeadline(t)
+	return b.SetReadDeadline(t)
+}
+
+// SetReadDeadline sets the deadline for Read.
+func (b *Bond) SetReadDeadline(t time.Time) error {
+	b.mu.Lock()
+	b.deadline = t
+	b.cond.Broadcast()
+	b.mu.Unlock()
+	if !t.IsZero() {
+		time.AfterFunc(time.Until(t), b.wake)
+	}
+	return nil
+}
+
+// SetWriteDeadline sets the write deadline of every lane that has one.
+// Write itself only queues, so it is the lanes' writes that time out and
+// drop the lanes.
+func (b *Bond) SetWriteDeadline(t time.Time) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, l := range b.lanes {
+		if c, ok := l.conn.(interface{ SetWriteDeadline(time.Time) error }); ok {
+			_ = c.SetWriteDeadline(t)
+		}
+	}
+	return nil
+}
+
+// bond returns the session's bond, if it is bonded.
+func (c *ClientConn) bond() (*Bond, bool) {
+	conn, _ := c.transport()
+	b, ok := conn.(*Bond)
+	return b, ok
+}
+
+// AddLane attaches conn, a new connection to the server the session was
+// established with, to a bonded session as one more lane, see Bond.
+func (c *ClientConn) AddLane(conn io.ReadWriter) error {
+	b, ok := c.bond()
+	if !ok {
+		return errors.New("reflex: session is not bonded")
+	}
+	conn, err := c.attach(conn, BondMagic, bondLabel)
+	if err != nil {
+		return err
+	}
+	b.Add(conn, bufio.NewReader(conn))
+	return nil
+}
+
+// Lanes returns the number of connections the session runs on.
+func (c *ClientConn) Lanes() int {
+	if b, ok := c.bond(); ok {
+		return b.Lanes()
+	}
+	return 1
+}
@@ -0,0 +1,175 @@
+package reflex
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// LimitQueueTimeout is how long LimitQueue waits for capacity before giving
+// up on the connection.
+const LimitQueueTimeout = 5 * time.Second
+
+// limitRetry bounds how long a queued caller sleeps before checking the
+// handshake rate again; released capacity wakes it earlier.
+const limitRetry = 50 * time.Millisecond
+
+// LimitAction is what the inbound does with work beyond a ResourceBudget cap.
+type LimitAction int
+
+const (
+	// LimitClose closes the connection.
+	LimitClose LimitAction = iota
+	// LimitFallback serves new connections from the fallback, like a
+	// non-Reflex client. Sessions over the buffer budget are closed.
+	LimitFallback
+	// LimitQueue waits up to LimitQueueTimeout for capacity, then closes.
+	LimitQueue
+)
+
+// ParseLimitAction maps a config string to a LimitAction. The empty string
+// means LimitClose.
+func ParseLimitAction(s string) (LimitAction, error) {
+	switch s {
+	case "", "close":
+		return LimitClose, nil
+	case "fallback":
+		return LimitFallback, nil
+	case "queue":
+		return LimitQueue, nil
+	}
+	return LimitClose, errors.New("reflex: unknown limit action " + s)
+}
+
+// ResourceBudget caps the resources of a whole inbound, so that a node under
+// attack degrades predictably instead of running out of memory. Zero caps
+// are unlimited, and a nil *ResourceBudget admits everything.
+type ResourceBudget struct {
+	maxSessions         int   // connections admitted at once
+	handshakesPerSecond int   // connections admitted per second
+	maxBufferedBytes    int64 // frame payloads being processed at once
+	action              LimitAction
+
+	mu       sync.Mutex
+	sessions int
+	buffered int64
+	tokens   float64
+	last     time.Time
+	wake     chan struct{} // closed and replaced whenever capacity is released
+}
+
+// NewResourceBudget returns a budget with the given caps.
+func NewResourceBudget(maxSessions, handshakesPerSecond int, maxBufferedBytes int64, action LimitAction) *ResourceBudget {
+	return &ResourceBudget{
+		maxSessions:         maxSessions,
+		handshakesPerSecond: handshakesPerSecond,
+		maxBufferedBytes:    maxBufferedBytes,
+		action:              action,
+		tokens:              float64(handshakesPerSecond),
+		last:                time.Now(),
+		wake:                make(chan struct{}),
+	}
+}
+
+// Action returns what is done with work beyond the caps.
+func (l *ResourceBudget) Action() LimitAction {
+	if l == nil {
+		return LimitClose
+	}
+	return l.action
+}
+
+// Admit reserves a session slot and a handshake for a new connection and
+// reports whether it may proceed. An admitted connection must call Release
+// when it ends.
+func (l *ResourceBudget) Admit() bool {
+	if l == nil {
+		return true
+	}
+	return l.wait(func(now time.Time) bool {
+		if l.maxSessions > 0 && l.sessions >= l.maxSessions {
+			return false
+		}
+		if l.handshakesPerSecond > 0 {
+			rate := float64(l.handshakesPerSecond)
+			l.tokens = min(rate, l.tokens+now.Sub(l.last).Seconds()*rate)
+			l.last = now
+			if l.tokens < 1 {
+				return false
+			}
+			l.tokens--
+		}
+		l.sessions++
+		return true
+	})
+}
+
+// Release frees the slot of an admitted connection.
+func (l *ResourceBudget) Release() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	l.sessions--
+	l.wakeLocked()
+	l.mu.Unlock()
+}
+
+// Reserve reserves n bytes of the buffer budget and reports whether they
+// were granted. Granted bytes must be returned with Free.
+func (l *ResourceBudget) Reserve(n int) bool {
+	if l == nil {
+		return true
+	}
+	return l.wait(func(time.Time) bool {
+		// A single frame larger than the whole budget is let through alone.
+		if l.maxBufferedBytes > 0 && l.buffered > 0 && l.buffered+int64(n) > l.maxBufferedBytes {
+			return false
+		}
+		l.buffered += int64(n)
+		return true
+	})
+}
+
+// Free returns n reserved bytes to the buffer budget.
+func (l *ResourceBudget) Free(n int) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	l.buffered -= int64(n)
+	l.wakeLocked()
+	l.mu.Unlock()
+}
+
+// wait runs try under the lock until it succeeds. Unless the action is
+// LimitQueue it is tried once.
+func (l *ResourceBudget) wait(try func(now time.Time) bool) bool {
+	deadline := time.Now().Add(LimitQueueTimeout)
+	for {
+		l.mu.Lock()
+		now := time.Now()
+		if try(now) {
+			l.mu.Unlock()
+			return true
+		}
+		wake := l.wake
+		l.mu.Unlock()
+
+		remaining := deadline.Sub(now)
+		if l.action != LimitQueue || remaining <= 0 {
+			return false
+		}
+		timer := time.NewTimer(min(remaining, limitRetry))
+		select {
+		case <-wake:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+func (l *ResourceBudget) wakeLocked() {
+	close(l.wake)
+	l.wake = make(chan struct{})
+}
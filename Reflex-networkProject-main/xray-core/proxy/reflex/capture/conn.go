@@ -0,0 +1,119 @@
+package capture
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// maxPending bounds the segments a connection holds while undecided. A
+// connection that is still undecided beyond that is not captured.
+const maxPending = 64
+
+// ErrNotTCP is returned by Wrap for connections without TCP addresses.
+var ErrNotTCP = errors.New("reflex: only TCP connections can be captured")
+
+// Conn records the traffic of a connection. Segments are held back until
+// Select decides whether the connection is captured, so that it can be
+// selected once its user is known and still include its handshake.
+type Conn struct {
+	net.Conn
+	w             *Writer
+	local, remote *net.TCPAddr
+
+	mu       sync.Mutex
+	decided  bool
+	selected bool
+	pending  []*segment
+	localSeq uint32 // next sequence number sent by the local end
+	peerSeq  uint32 // next sequence number sent by the peer
+}
+
+// Wrap returns conn recording into w.
+func (w *Writer) Wrap(conn net.Conn) (*Conn, error) {
+	local, ok1 := conn.LocalAddr().(*net.TCPAddr)
+	remote, ok2 := conn.RemoteAddr().(*net.TCPAddr)
+	if !ok1 || !ok2 {
+		return nil, ErrNotTCP
+	}
+	c := &Conn{Conn: conn, w: w, local: local, remote: remote, localSeq: 1000, peerSeq: 1}
+	// The connection is established already; make up its handshake.
+	now := time.Now()
+	c.pending = []*segment{
+		{at: now, src: remote, dst: local, seq: c.peerSeq - 1, flags: flagSYN},
+		{at: now, src: local, dst: remote, seq: c.localSeq - 1, ack: c.peerSeq, flags: flagSYN | flagACK},
+		{at: now, src: remote, dst: local, seq: c.peerSeq, ack: c.localSeq, flags: flagACK},
+	}
+	return c, nil
+}
+
+// Read implements net.Conn and records what the peer sent.
+func (c *Conn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.record(false, b[:n])
+	}
+	return n, err
+}
+
+// Write implements net.Conn and records what was sent.
+func (c *Conn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if n > 0 {
+		c.record(true, b[:n])
+	}
+	return n, err
+}
+
+// Select decides whether the connection is captured. Held back segments are
+// written or dropped; later calls have no effect.
+func (c *Conn) Select(capture bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.decided {
+		return
+	}
+	c.decided = true
+	c.selected = capture
+	if capture {
+		for _, s := range c.pending {
+			_ = c.w.writePacket(s)
+		}
+	}
+	c.pending = nil
+}
+
+func (c *Conn) record(sent bool, b []byte) {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.decided && !c.selected {
+		return
+	}
+	for len(b) > 0 {
+		n := min(len(b), maxSegment)
+		s := &segment{at: now, flags: flagPSH | flagACK, size: n}
+		if sent {
+			s.src, s.dst, s.seq, s.ack = c.local, c.remote, c.localSeq, c.peerSeq
+			c.localSeq += uint32(n)
+		} else {
+			s.src, s.dst, s.seq, s.ack = c.remote, c.local, c.peerSeq, c.localSeq
+			c.peerSeq += uint32(n)
+		}
+		if !c.w.headersOnly {
+			s.payload = append([]byte(nil), b[:n]...)
+		}
+		b = b[n:]
+
+		if c.decided {
+			_ = c.w.writePacket(s)
+			continue
+		}
+		if len(c.pending) == maxPending {
+			c.decided, c.selected, c.pending = true, false, nil
+			return
+		}
+		c.pending = append(c.pending, s)
+	}
+}
@@ -0,0 +1,133 @@
+// Package capture writes what a Reflex connection puts on the wire to a pcap
+// file, so that researchers can run their own DPI tools against exactly the
+// shaped traffic the network sees.
+//
+// Packets are synthesized from the bytes a connection reads and writes: each
+// Write becomes one TCP segment and each Read one segment from the peer, with
+// a made-up handshake in front. Segment boundaries therefore follow the
+// application, not the kernel, but sizes, order and timing are the real ones.
+// The link type is raw IP, so IPv4 and IPv6 connections share a file.
+//
+// ReadStreams reads such files, or tcpdump captures of Reflex traffic, back
+// into reassembled TCP streams.
+package capture
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// linkTypeRaw is LINKTYPE_RAW: packets start with an IPv4 or IPv6 header.
+const linkTypeRaw = 101
+
+// snapLen is the largest packet a file may hold.
+const snapLen = 0xFFFF
+
+// maxSegment keeps the synthesized IPv6 payload length and IPv4 total length
+// within 16 bits.
+const maxSegment = snapLen - 60
+
+// TCP flags used in synthesized segments.
+const (
+	flagSYN = 0x02
+	flagPSH = 0x08
+	flagACK = 0x10
+)
+
+// Writer appends packets to a pcap stream. It is safe for concurrent use.
+type Writer struct {
+	mu          sync.Mutex
+	w           io.Writer
+	headersOnly bool
+}
+
+// NewWriter writes the pcap file header to w. With headersOnly, packets are
+// truncated after the TCP header: the file keeps sizes and timing but no
+// payload.
+func NewWriter(w io.Writer, headersOnly bool) (*Writer, error) {
+	var hdr [24]byte
+	binary.LittleEndian.PutUint32(hdr[0:], 0xa1b2c3d4) // microsecond timestamps
+	binary.LittleEndian.PutUint16(hdr[4:], 2)
+	binary.LittleEndian.PutUint16(hdr[6:], 4)
+	binary.LittleEndian.PutUint32(hdr[16:], snapLen)
+	binary.LittleEndian.PutUint32(hdr[20:], linkTypeRaw)
+	if _, err := w.Write(hdr[:]); err != nil {
+		return nil, err
+	}
+	return &Writer{w: w, headersOnly: headersOnly}, nil
+}
+
+// segment is one TCP segment of a connection.
+type segment struct {
+	at       time.Time
+	src, dst *net.TCPAddr
+	seq, ack uint32
+	flags    byte
+	size     int
+	payload  []byte // nil when only headers are written
+}
+
+// writePacket encodes s as an IP packet.
+func (w *Writer) writePacket(s *segment) error {
+	tcp := make([]byte, 20)
+	binary.BigEndian.PutUint16(tcp[0:], uint16(s.src.Port))
+	binary.BigEndian.PutUint16(tcp[2:], uint16(s.dst.Port))
+	binary.BigEndian.PutUint32(tcp[4:], s.seq)
+	binary.BigEndian.PutUint32(tcp[8:], s.ack)
+	tcp[12] = 5 << 4
+	tcp[13] = s.flags
+	binary.BigEndian.PutUint16(tcp[14:], 0xFFFF) // window
+
+	var ip []byte
+	if src4, dst4 := s.src.IP.To4(), s.dst.IP.To4(); src4 != nil && dst4 != nil {
+		ip = make([]byte, 20)
+		ip[0] = 0x45
+		binary.BigEndian.PutUint16(ip[2:], uint16(20+len(tcp)+s.size))
+		ip[8] = 64 // TTL
+		ip[9] = 6  // TCP
+		copy(ip[12:], src4)
+		copy(ip[16:], dst4)
+		binary.BigEndian.PutUint16(ip[10:], checksum(ip))
+	} else {
+		ip = make([]byte, 40)
+		ip[0] = 0x60
+		binary.BigEndian.PutUint16(ip[4:], uint16(len(tcp)+s.size))
+		ip[6] = 6 // TCP
+		ip[7] = 64
+		copy(ip[8:], s.src.IP.To16())
+		copy(ip[24:], s.dst.IP.To16())
+	}
+
+	orig := len(ip) + len(tcp) + s.size
+	captured := len(ip) + len(tcp) + len(s.payload)
+	var rec [16]byte
+	binary.LittleEndian.PutUint32(rec[0:], uint32(s.at.Unix()))
+	binary.LittleEndian.PutUint32(rec[4:], uint32(s.at.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(rec[8:], uint32(captured))
+	binary.LittleEndian.PutUint32(rec[12:], uint32(orig))
+
+	pkt := make([]byte, 0, len(rec)+captured)
+	pkt = append(append(append(append(pkt, rec[:]...), ip...), tcp...), s.payload...)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, err := w.w.Write(pkt)
+	return err
+}
+
+// checksum is the Internet checksum of b.
+func checksum(b []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(b[i:]))
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum > 0xFFFF {
+		sum = sum>>16 + sum&0xFFFF
+	}
+	return ^uint16(sum)
+}
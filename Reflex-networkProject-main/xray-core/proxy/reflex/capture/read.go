@@ -0,0 +1,280 @@
+package capture
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sort"
+	"time"
+)
+
+// Link types ReadStreams understands besides linkTypeRaw, so that captures
+// taken with tcpdump on an interface can be read as well.
+const (
+	linkTypeNull     = 0   // BSD loopback
+	linkTypeEthernet = 1   // Ethernet II, optionally 802.1Q tagged
+	linkTypeLinuxSLL = 113 // Linux cooked capture
+)
+
+// ErrNotPcap is returned by ReadStreams for input that is not a pcap file.
+var ErrNotPcap = errors.New("reflex: not a pcap file")
+
+// Stream is one TCP connection read back from a pcap file.
+type Stream struct {
+	// Client is the end that sent the SYN, or the first packet if the
+	// capture missed it.
+	Client, Server *net.TCPAddr
+	ToServer       *Flow
+	ToClient       *Flow
+}
+
+// Flow is what one end of a stream sent, reassembled in sequence order.
+type Flow struct {
+	Data []byte
+	// Truncated is set when packets were captured without all of their
+	// payload, as in headers-only captures; Data is then incomplete.
+	Truncated bool
+	// Gap is set when a segment is missing; Data ends before it.
+	Gap bool
+
+	marks []mark
+}
+
+// mark records when the bytes of Data up to end were captured.
+type mark struct {
+	end int
+	at  time.Time
+}
+
+// TimeAt returns when the byte at offset off of Data was captured.
+func (f *Flow) TimeAt(off int) time.Time {
+	i := sort.Search(len(f.marks), func(i int) bool { return f.marks[i].end > off })
+	if i == len(f.marks) {
+		if i == 0 {
+			return time.Time{}
+		}
+		i--
+	}
+	return f.marks[i].at
+}
+
+// tcpSegment is a TCP segment as read from a capture.
+type tcpSegment struct {
+	at        time.Time
+	seq       uint32
+	syn       bool
+	payload   []byte
+	truncated bool
+}
+
+// flowReader collects the segments of one direction.
+type flowReader struct {
+	src, dst *net.TCPAddr
+	isn      uint32
+	synSeen  bool
+	segments []*tcpSegment
+}
+
+// ReadStreams reads a pcap file and returns its TCP connections in the order
+// they first appear.
+func ReadStreams(r io.Reader) ([]*Stream, error) {
+	var hdr [24]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, ErrNotPcap
+	}
+	var order binary.ByteOrder
+	nano := false
+	switch binary.LittleEndian.Uint32(hdr[:]) {
+	case 0xa1b2c3d4:
+		order = binary.LittleEndian
+	case 0xa1b23c4d:
+		order, nano = binary.LittleEndian, true
+	case 0xd4c3b2a1:
+		order = binary.BigEndian
+	case 0x4d3cb2a1:
+		order, nano = binary.BigEndian, true
+	default:
+		return nil, ErrNotPcap
+	}
+	linkType := order.Uint32(hdr[20:]) & 0x0FFFFFFF
+
+	flows := make(map[string]*flowReader)
+	var firstSeen []*flowReader
+	for {
+		var rec [16]byte
+		if _, err := io.ReadFull(r, rec[:]); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		frac := time.Duration(order.Uint32(rec[4:]))
+		if !nano {
+			frac *= time.Microsecond
+		}
+		at := time.Unix(int64(order.Uint32(rec[0:])), int64(frac))
+		capLen, origLen := order.Uint32(rec[8:]), order.Uint32(rec[12:])
+		if capLen > 1<<18 {
+			return nil, errors.New("reflex: oversized pcap record")
+		}
+		pkt := make([]byte, capLen)
+		if _, err := io.ReadFull(r, pkt); err != nil {
+			return nil, err
+		}
+
+		ip, ok := stripLink(linkType, pkt)
+		if !ok {
+			continue
+		}
+		src, dst, s, ok := parseTCP(ip)
+		if !ok {
+			continue
+		}
+		s.at = at
+		s.truncated = origLen > capLen
+		key := src.String() + ">" + dst.String()
+		f := flows[key]
+		if f == nil {
+			f = &flowReader{src: src, dst: dst}
+			flows[key] = f
+			firstSeen = append(firstSeen, f)
+		}
+		if s.syn {
+			f.isn, f.synSeen = s.seq+1, true
+		}
+		if len(s.payload) > 0 || s.truncated {
+			f.segments = append(f.segments, s)
+		}
+	}
+
+	var streams []*Stream
+	paired := make(map[*flowReader]bool)
+	for _, f := range firstSeen {
+		if paired[f] {
+			continue
+		}
+		back := flows[f.dst.String()+">"+f.src.String()]
+		client, server := f, back
+		if back != nil && back.synSeen && !f.synSeen {
+			client, server = back, f
+		}
+		s := &Stream{Client: client.src, Server: client.dst, ToServer: client.assemble(), ToClient: &Flow{}}
+		paired[client] = true
+		if server != nil {
+			s.ToClient = server.assemble()
+			paired[server] = true
+		}
+		streams = append(streams, s)
+	}
+	return streams, nil
+}
+
+// stripLink returns the IP packet carried in a link-layer frame.
+func stripLink(linkType uint32, pkt []byte) ([]byte, bool) {
+	switch linkType {
+	case linkTypeRaw:
+		return pkt, true
+	case linkTypeNull:
+		if len(pkt) < 4 {
+			return nil, false
+		}
+		return pkt[4:], true
+	case linkTypeEthernet:
+		if len(pkt) < 14 {
+			return nil, false
+		}
+		etherType, rest := binary.BigEndian.Uint16(pkt[12:]), pkt[14:]
+		if etherType == 0x8100 && len(rest) >= 4 {
+			etherType, rest = binary.BigEndian.Uint16(rest[2:]), rest[4:]
+		}
+		return rest, etherType == 0x0800 || etherType == 0x86DD
+	case linkTypeLinuxSLL:
+		if len(pkt) < 16 {
+			return nil, false
+		}
+		return pkt[16:], true
+	}
+	return nil, false
+}
+
+// parseTCP decodes the IPv4 or IPv6 and TCP headers of ip. IPv6 extension
+// headers are not followed.
+func parseTCP(ip []byte) (src, dst *net.TCPAddr, s *tcpSegment, ok bool) {
+	if len(ip) < 1 {
+		return nil, nil, nil, false
+	}
+	var tcp []byte
+	switch ip[0] >> 4 {
+	case 4:
+		ihl := int(ip[0]&0x0F) * 4
+		if len(ip) < 20 || ihl < 20 || len(ip) < ihl || ip[9] != 6 {
+			return nil, nil, nil, false
+		}
+		if total := int(binary.BigEndian.Uint16(ip[2:])); total >= ihl && total < len(ip) {
+			ip = ip[:total] // drop Ethernet trailer padding
+		}
+		src = &net.TCPAddr{IP: net.IP(append([]byte(nil), ip[12:16]...))}
+		dst = &net.TCPAddr{IP: net.IP(append([]byte(nil), ip[16:20]...))}
+		tcp = ip[ihl:]
+	case 6:
+		if len(ip) < 40 || ip[6] != 6 {
+			return nil, nil, nil, false
+		}
+		if total := 40 + int(binary.BigEndian.Uint16(ip[4:])); total < len(ip) {
+			ip = ip[:total]
+		}
+		src = &net.TCPAddr{IP: net.IP(append([]byte(nil), ip[8:24]...))}
+		dst = &net.TCPAddr{IP: net.IP(append([]byte(nil), ip[24:40]...))}
+		tcp = ip[40:]
+	default:
+		return nil, nil, nil, false
+	}
+	if len(tcp) < 20 {
+		return nil, nil, nil, false
+	}
+	off := int(tcp[12]>>4) * 4
+	if off < 20 || len(tcp) < off {
+		return nil, nil, nil, false
+	}
+	src.Port = int(binary.BigEndian.Uint16(tcp[0:]))
+	dst.Port = int(binary.BigEndian.Uint16(tcp[2:]))
+	s = &tcpSegment{
+		seq:     binary.BigEndian.Uint32(tcp[4:]),
+		syn:     tcp[13]&flagSYN != 0,
+		payload: tcp[off:],
+	}
+	return src, dst, s, true
+}
+
+// assemble puts the segments of f in sequence order, dropping
+// retransmitted bytes.
+func (f *flowReader) assemble() *Flow {
+	flow := &Flow{}
+	if len(f.segments) == 0 {
+		return flow
+	}
+	isn := f.isn
+	if !f.synSeen {
+		isn = f.segments[0].seq
+	}
+	offset := func(s *tcpSegment) int64 { return int64(int32(s.seq - isn)) }
+	sort.SliceStable(f.segments, func(i, j int) bool { return offset(f.segments[i]) < offset(f.segments[j]) })
+	for _, s := range f.segments {
+		off := offset(s)
+		if off > int64(len(flow.Data)) {
+			flow.Gap = true
+			break
+		}
+		flow.Truncated = flow.Truncated || s.truncated
+		if end := off + int64(len(s.payload)); end > int64(len(flow.Data)) {
+			flow.Data = append(flow.Data, s.payload[int64(len(flow.Data))-off:]...)
+			flow.marks = append(flow.marks, mark{end: len(flow.Data), at: s.at})
+		}
+		if s.truncated {
+			// Whatever follows would be appended at the wrong offset.
+			break
+		}
+	}
+	return flow
+}
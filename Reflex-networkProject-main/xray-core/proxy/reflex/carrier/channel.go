@@ -0,0 +1,214 @@
+package carrier
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+)
+
+// ChannelMagic ("RFXM") opens a connection that carries several independent
+// Reflex connections, e.g. different users or devices behind one gateway
+// appliance sharing a single fronted TCP connection.
+//
+// After the magic the stream is a sequence of chunks:
+//
+//	channelID (2) | length (2) | data
+//
+// Every channel is a complete Reflex connection (handshake, then frames). The
+// client allocates channel IDs; the first chunk for an unknown ID opens the
+// channel on the server. A zero-length chunk closes the channel.
+const ChannelMagic uint32 = 0x5246584D
+
+// maxChunkSize is the largest data length a chunk header can carry.
+const maxChunkSize = 0xFFFF
+
+type channelMux struct {
+	conn    net.Conn
+	writeMu sync.Mutex
+
+	mu       sync.Mutex
+	channels map[uint16]net.Conn // our end of each channel's pipe
+	nextID   uint16
+	closed   bool
+}
+
+func newChannelMux(conn net.Conn) *channelMux {
+	return &channelMux{
+		conn:     conn,
+		channels: make(map[uint16]net.Conn),
+	}
+}
+
+// channelConn is the application's end of a channel. It reports the
+// addresses of the shared connection.
+type channelConn struct {
+	net.Conn
+	local, remote net.Addr
+}
+
+func (c *channelConn) LocalAddr() net.Addr  { return c.local }
+func (c *channelConn) RemoteAddr() net.Addr { return c.remote }
+
+// open registers channel id and starts pumping its outgoing data.
+func (m *channelMux) open(id uint16) (net.Conn, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil, errors.New("reflex: channel connection closed")
+	}
+	if _, found := m.channels[id]; found {
+		return nil, errors.New("reflex: channel already open")
+	}
+	appEnd, muxEnd := net.Pipe()
+	m.channels[id] = muxEnd
+	go m.pump(id, muxEnd)
+	return &channelConn{Conn: appEnd, local: m.conn.LocalAddr(), remote: m.conn.RemoteAddr()}, nil
+}
+
+// pump copies data written by the application into chunks for channel id.
+func (m *channelMux) pump(id uint16, muxEnd net.Conn) {
+	b := make([]byte, maxChunkSize)
+	for {
+		n, err := muxEnd.Read(b)
+		if n > 0 {
+			if werr := m.writeChunk(id, b[:n]); werr != nil {
+				break
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	if m.forget(id) {
+		_ = m.writeChunk(id, nil)
+	}
+}
+
+func (m *channelMux) writeChunk(id uint16, data []byte) error {
+	chunk := make([]byte, 4+len(data))
+	binary.BigEndian.PutUint16(chunk[0:2], id)
+	binary.BigEndian.PutUint16(chunk[2:4], uint16(len(data)))
+	copy(chunk[4:], data)
+	m.writeMu.Lock()
+	defer m.writeMu.Unlock()
+	_, err := m.conn.Write(chunk)
+	return err
+}
+
+// forget removes channel id and reports whether it was still registered.
+func (m *channelMux) forget(id uint16) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	muxEnd, found := m.channels[id]
+	if found {
+		delete(m.channels, id)
+		_ = muxEnd.Close()
+	}
+	return found
+}
+
+// readLoop delivers incoming chunks to their channels. onNew is called for
+// chunks addressed to channels that are not open yet; if it is nil such
+// chunks are dropped.
+func (m *channelMux) readLoop(r io.Reader, onNew func(id uint16) (net.Conn, error)) error {
+	defer m.closeAll()
+	var header [4]byte
+	data := make([]byte, maxChunkSize)
+	for {
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return err
+		}
+		id := binary.BigEndian.Uint16(header[0:2])
+		n := int(binary.BigEndian.Uint16(header[2:4]))
+		if _, err := io.ReadFull(r, data[:n]); err != nil {
+			return err
+		}
+
+		m.mu.Lock()
+		muxEnd, found := m.channels[id]
+		m.mu.Unlock()
+
+		if n == 0 {
+			m.forget(id)
+			continue
+		}
+		if !found {
+			if onNew == nil {
+				continue
+			}
+			if _, err := onNew(id); err != nil {
+				continue
+			}
+			m.mu.Lock()
+			muxEnd = m.channels[id]
+			m.mu.Unlock()
+		}
+		if _, err := muxEnd.Write(data[:n]); err != nil {
+			m.forget(id)
+		}
+	}
+}
+
+func (m *channelMux) closeAll() {
+	m.mu.Lock()
+	m.closed = true
+	channels := m.channels
+	m.channels = make(map[uint16]net.Conn)
+	m.mu.Unlock()
+	for _, muxEnd := range channels {
+		_ = muxEnd.Close()
+	}
+}
+
+// ServeChannels demultiplexes a channel connection whose ChannelMagic has
+// already been consumed, running handle in its own goroutine for every
+// channel the client opens. r is read instead of conn so that bytes already
+// buffered by the caller are not lost. It blocks until the connection fails.
+func ServeChannels(r io.Reader, conn net.Conn, handle func(net.Conn)) error {
+	m := newChannelMux(conn)
+	return m.readLoop(r, func(id uint16) (net.Conn, error) {
+		c, err := m.open(id)
+		if err != nil {
+			return nil, err
+		}
+		go handle(c)
+		return c, nil
+	})
+}
+
+// ChannelClient opens Reflex connections as channels of one shared connection.
+type ChannelClient struct {
+	m *channelMux
+}
+
+// NewChannelClient writes ChannelMagic to conn and starts reading replies.
+func NewChannelClient(conn net.Conn) (*ChannelClient, error) {
+	var magic [4]byte
+	binary.BigEndian.PutUint32(magic[:], ChannelMagic)
+	if _, err := conn.Write(magic[:]); err != nil {
+		return nil, err
+	}
+	c := &ChannelClient{m: newChannelMux(conn)}
+	go func() {
+		_ = c.m.readLoop(conn, nil)
+	}()
+	return c, nil
+}
+
+// Open allocates a new channel. Run a complete Reflex handshake on the
+// returned connection as if it were a fresh TCP connection.
+func (c *ChannelClient) Open() (net.Conn, error) {
+	c.m.mu.Lock()
+	c.m.nextID++
+	id := c.m.nextID
+	c.m.mu.Unlock()
+	return c.m.open(id)
+}
+
+// Close closes the shared connection and every channel on it.
+func (c *ChannelClient) Close() error {
+	c.m.closeAll()
+	return c.m.conn.Close()
+}
@@ -0,0 +1,358 @@
+package carrier
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// FEC sets the forward error correction of a datagram carrier. After every
+// Data datagrams Parity more are sent, and any Data datagrams of such a group
+// give back all of its data, so the session goes on through moderate loss
+// without waiting for retransmissions, whose timing is itself a pattern.
+// Parity 0 sends no parity.
+type FEC struct {
+	Data   int
+	Parity int
+}
+
+// DefaultFEC recovers from the loss of up to two datagrams in ten.
+var DefaultFEC = FEC{Data: 8, Parity: 2}
+
+// FECOf returns the FEC of data and parity shards a config sets, with 0
+// data for DefaultFEC.
+func FECOf(data, parity uint32) (FEC, error) {
+	if data == 0 {
+		return DefaultFEC, nil
+	}
+	f := FEC{Data: int(data), Parity: int(parity)}
+	if data > maxShards || parity > maxShards {
+		return f, errors.New("reflex: invalid FEC group size")
+	}
+	return f, f.validate()
+}
+
+func (f FEC) validate() error {
+	if f.Data <= 0 || f.Parity < 0 || f.Data+f.Parity > maxShards {
+		return errors.New("reflex: invalid FEC group size")
+	}
+	return nil
+}
+
+// Every datagram of an FECConn starts with
+//
+//	group (4, big endian) | kind (1) | index (1) | count (1)
+//
+// where index is the shard's coding row, see codingRow, and count the number
+// of data shards of the group, known once its parity is sent. A data shard
+// is the length (2, big endian) of what was written followed by it; parity
+// covers the data shards padded with zeros to the longest of them.
+const (
+	fecHeaderSize = 7
+	fecData       = 0
+	fecParity     = 1
+)
+
+const (
+	// MaxDatagramSize is the largest UDP payload.
+	MaxDatagramSize = 65507
+	// fecFlushDelay bounds how long the parity of a group that is not full
+	// waits for more data.
+	fecFlushDelay = 20 * time.Millisecond
+	// fecGroups is how many of the latest groups are kept for recovery.
+	fecGroups = 64
+	// fecQueue is how many datagrams are held for reading; more are dropped
+	// as a full socket buffer would.
+	fecQueue = 1024
+)
+
+// FECConn carries a byte stream over a connection that keeps datagram
+// boundaries, such as a connected UDP socket, with forward error correction.
+// Every Write is sent as one datagram and read back whole by the peer, or
+// not at all, so a Reflex session that writes one record per Write, as
+// Session does, loses whole records only. Records recovered from parity
+// arrive after later ones, so a session over an FECConn should be granted
+// reflex.FeatureReorderTolerant; and TLS framing mode keeps records small
+// enough for a datagram.
+type FECConn struct {
+	conn net.Conn
+	fec  FEC
+
+	wmu    sync.Mutex
+	group  uint32   // group being written
+	shards [][]byte // data shards of the group written so far
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	groups   map[uint32]*fecGroup
+	newest   uint32
+	queue    [][]byte // datagrams read, not yet returned
+	cur      []byte   // rest of the datagram being read
+	deadline time.Time
+	err      error
+	closed   bool
+}
+
+type fecGroup struct {
+	shards    map[int][]byte // by coding row
+	count     int            // data shards, 0 until parity arrives
+	delivered map[int]bool
+}
+
+// NewFECConn runs conn, which must keep datagram boundaries, as an FECConn.
+func NewFECConn(conn net.Conn, fec FEC) (*FECConn, error) {
+	if err := fec.validate(); err != nil {
+		return nil, err
+	}
+	c := &FECConn{conn: conn, fec: fec, groups: make(map[uint32]*fecGroup)}
+	c.cond = sync.NewCond(&c.mu)
+	go c.readLoop()
+	return c, nil
+}
+
+// MaxWrite is the largest Write that fits in a datagram.
+func (c *FECConn) MaxWrite() int {
+	return MaxDatagramSize - fecHeaderSize - 2
+}
+
+// Write implements io.Writer. p is sent as one datagram.
+func (c *FECConn) Write(p []byte) (int, error) {
+	if len(p) > c.MaxWrite() {
+		return 0, errors.New("reflex: write larger than a datagram")
+	}
+	shard := make([]byte, 2+len(p))
+	binary.BigEndian.PutUint16(shard, uint16(len(p)))
+	copy(shard[2:], p)
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if err := c.send(fecData, len(c.shards), 0, shard); err != nil {
+		return 0, err
+	}
+	c.shards = append(c.shards, shard)
+	if len(c.shards) == c.fec.Data {
+		return len(p), c.flushLocked()
+	}
+	if len(c.shards) == 1 && c.fec.Parity > 0 {
+		group := c.group
+		time.AfterFunc(fecFlushDelay, func() { c.flush(group) })
+	}
+	return len(p), nil
+}
+
+// send sends one shard of the group being written. c.wmu must be held.
+func (c *FECConn) send(kind, index, count int, body []byte) error {
+	b := make([]byte, fecHeaderSize+len(body))
+	binary.BigEndian.PutUint32(b, c.group)
+	b[4], b[5], b[6] = byte(kind), byte(index), byte(count)
+	copy(b[fecHeaderSize:], body)
+	_, err := c.conn.Write(b)
+	return err
+}
+
+// flush sends the parity of group if it is still being written.
+func (c *FECConn) flush(group uint32) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.group == group && len(c.shards) > 0 {
+		_ = c.flushLocked()
+	}
+}
+
+// flushLocked sends the parity of the group being written and starts the
+// next. c.wmu must be held.
+func (c *FECConn) flushLocked() error {
+	data := c.shards
+	c.shards = nil
+	defer func() { c.group++ }()
+	if c.fec.Parity == 0 {
+		return nil
+	}
+	size := 0
+	for _, shard := range data {
+		size = max(size, len(shard))
+	}
+	padded := make([][]byte, len(data))
+	for i, shard := range data {
+		padded[i] = make([]byte, size)
+		copy(padded[i], shard)
+	}
+	for j := 0; j < c.fec.Parity; j++ {
+		index := len(data) + j
+		if err := c.send(fecParity, index, len(data), encodeParity(padded, index)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *FECConn) readLoop() {
+	b := make([]byte, MaxDatagramSize)
+	for {
+		n, err := c.conn.Read(b)
+		if err != nil {
+			c.mu.Lock()
+			c.err = err
+			c.cond.Broadcast()
+			c.mu.Unlock()
+			return
+		}
+		if n < fecHeaderSize {
+			continue
+		}
+		id := binary.BigEndian.Uint32(b)
+		kind, index, count := b[4], int(b[5]), int(b[6])
+		body := append([]byte(nil), b[fecHeaderSize:n]...)
+		c.mu.Lock()
+		c.receive(id, kind, index, count, body)
+		c.mu.Unlock()
+	}
+}
+
+// receive takes in one shard. c.mu must be held.
+func (c *FECConn) receive(id uint32, kind byte, index, count int, body []byte) {
+	if id > c.newest {
+		c.newest = id
+		for old := range c.groups {
+			if c.newest-old >= fecGroups {
+				delete(c.groups, old)
+			}
+		}
+	}
+	if c.newest-id >= fecGroups {
+		return
+	}
+	if kind != fecData && (kind != fecParity || count == 0 || index < count) {
+		return
+	}
+	g := c.groups[id]
+	if g == nil {
+		g = &fecGroup{shards: make(map[int][]byte), delivered: make(map[int]bool)}
+		c.groups[id] = g
+	}
+	if _, dup := g.shards[index]; dup {
+		return
+	}
+	g.shards[index] = body
+	if kind == fecParity {
+		g.count = count
+	} else if !g.delivered[index] {
+		g.delivered[index] = true
+		c.deliver(body)
+	}
+	if g.count > 0 && len(g.delivered) < g.count && len(g.shards) >= g.count {
+		c.recover(g)
+	}
+}
+
+// recover delivers the data shards of g that were lost. c.mu must be held.
+func (c *FECConn) recover(g *fecGroup) {
+	size := 0
+	for _, shard := range g.shards {
+		size = max(size, len(shard))
+	}
+	shards := make(map[int][]byte, len(g.shards))
+	for index, shard := range g.shards {
+		padded := make([]byte, size)
+		copy(padded, shard)
+		shards[index] = padded
+	}
+	data, err := reconstruct(shards, g.count)
+	if err != nil {
+		return
+	}
+	for i, shard := range data {
+		if g.delivered[i] {
+			continue
+		}
+		g.delivered[i] = true
+		c.deliver(shard)
+	}
+}
+
+// deliver queues the data of shard for reading. c.mu must be held.
+func (c *FECConn) deliver(shard []byte) {
+	if len(shard) < 2 || len(c.queue) >= fecQueue {
+		return
+	}
+	n := int(binary.BigEndian.Uint16(shard))
+	if n == 0 || 2+n > len(shard) {
+		return
+	}
+	c.queue = append(c.queue, shard[2:2+n])
+	c.cond.Broadcast()
+}
+
+// Read implements io.Reader. The data of one datagram is never returned
+// together with that of another.
+func (c *FECConn) Read(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.cur) == 0 {
+		if c.closed {
+			return 0, net.ErrClosed
+		}
+		if !c.deadline.IsZero() && !time.Now().Before(c.deadline) {
+			return 0, os.ErrDeadlineExceeded
+		}
+		if len(c.queue) > 0 {
+			c.cur, c.queue = c.queue[0], c.queue[1:]
+			break
+		}
+		if c.err != nil {
+			return 0, c.err
+		}
+		c.cond.Wait()
+	}
+	n := copy(p, c.cur)
+	c.cur = c.cur[n:]
+	return n, nil
+}
+
+// Close closes the underlying connection. Parity of the group being written
+// is not sent.
+func (c *FECConn) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	c.cond.Broadcast()
+	c.mu.Unlock()
+	return c.conn.Close()
+}
+
+// LocalAddr implements net.Conn.
+func (c *FECConn) LocalAddr() net.Addr { return c.conn.LocalAddr() }
+
+// RemoteAddr implements net.Conn.
+func (c *FECConn) RemoteAddr() net.Addr { return c.conn.RemoteAddr() }
+
+// SetDeadline implements net.Conn.
+func (c *FECConn) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.SetWriteDeadline(t)
+}
+
+// SetReadDeadline implements net.Conn.
+func (c *FECConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.deadline = t
+	c.cond.Broadcast()
+	c.mu.Unlock()
+	if !t.IsZero() {
+		time.AfterFunc(time.Until(t), func() {
+			c.mu.Lock()
+			c.cond.Broadcast()
+			c.mu.Unlock()
+		})
+	}
+	return nil
+}
+
+// SetWriteDeadline implements net.Conn.
+func (c *FECConn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
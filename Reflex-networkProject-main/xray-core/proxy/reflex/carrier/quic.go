@@ -0,0 +1,157 @@
+// Package carrier provides alternative carriers for Reflex connections, for
+// networks where long-lived TCP flows are throttled but UDP survives.
+package carrier
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/features/routing"
+	"github.com/xtls/xray-core/proxy"
+	"github.com/xtls/xray-core/transport/internet/stat"
+)
+
+// QUICKeepAlivePeriod keeps idle carrier connections from being dropped by NATs.
+const QUICKeepAlivePeriod = 15 * time.Second
+
+func quicConfig() *quic.Config {
+	return &quic.Config{
+		KeepAlivePeriod: QUICKeepAlivePeriod,
+	}
+}
+
+// quicTLSConfig clones conf and defaults the ALPN to HTTP/3 so the carrier
+// looks like ordinary QUIC web traffic.
+func quicTLSConfig(conf *tls.Config) *tls.Config {
+	conf = conf.Clone()
+	if len(conf.NextProtos) == 0 {
+		conf.NextProtos = []string{"h3"}
+	}
+	return conf
+}
+
+// QUICConn adapts one bidirectional QUIC stream to net.Conn. Every Reflex
+// connection (handshake followed by session frames) gets its own stream, so
+// the inbound handles it exactly like a TCP connection.
+type QUICConn struct {
+	*quic.Stream
+	conn *quic.Conn
+}
+
+// LocalAddr implements net.Conn.
+func (c *QUICConn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+// RemoteAddr implements net.Conn.
+func (c *QUICConn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// Close closes both directions of the stream. quic.Stream.Close only closes
+// the send side.
+func (c *QUICConn) Close() error {
+	c.Stream.CancelRead(0)
+	return c.Stream.Close()
+}
+
+// QUICListener accepts QUIC connections and hands every incoming stream to a
+// handler in its own goroutine.
+type QUICListener struct {
+	ln     *quic.Listener
+	handle func(net.Conn)
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// ListenQUIC starts a QUIC carrier listener on addr. tlsConf must carry a
+// server certificate.
+func ListenQUIC(addr string, tlsConf *tls.Config, handle func(net.Conn)) (*QUICListener, error) {
+	ln, err := quic.ListenAddr(addr, quicTLSConfig(tlsConf), quicConfig())
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	l := &QUICListener{
+		ln:     ln,
+		handle: handle,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	go l.acceptConns()
+	return l, nil
+}
+
+// Addr returns the listener's UDP address.
+func (l *QUICListener) Addr() net.Addr {
+	return l.ln.Addr()
+}
+
+// Close stops accepting and closes the underlying socket.
+func (l *QUICListener) Close() error {
+	l.cancel()
+	return l.ln.Close()
+}
+
+func (l *QUICListener) acceptConns() {
+	for {
+		conn, err := l.ln.Accept(l.ctx)
+		if err != nil {
+			return
+		}
+		go l.acceptStreams(conn)
+	}
+}
+
+func (l *QUICListener) acceptStreams(conn *quic.Conn) {
+	for {
+		stream, err := conn.AcceptStream(l.ctx)
+		if err != nil {
+			_ = conn.CloseWithError(0, "")
+			return
+		}
+		go l.handle(&QUICConn{Stream: stream, conn: conn})
+	}
+}
+
+// InboundHandler returns a handler for ListenQUIC that runs every stream
+// through inbound, as if it had arrived over TCP.
+func InboundHandler(ctx context.Context, inbound proxy.Inbound, dispatcher routing.Dispatcher) func(net.Conn) {
+	return func(c net.Conn) {
+		defer c.Close()
+		_ = inbound.Process(ctx, xnet.Network_TCP, stat.Connection(c), dispatcher)
+	}
+}
+
+// QUICDialer holds one QUIC connection to a Reflex server and opens a new
+// stream for every Reflex connection.
+type QUICDialer struct {
+	conn *quic.Conn
+}
+
+// DialQUIC connects to a QUIC carrier listener.
+func DialQUIC(ctx context.Context, addr string, tlsConf *tls.Config) (*QUICDialer, error) {
+	conn, err := quic.DialAddr(ctx, addr, quicTLSConfig(tlsConf), quicConfig())
+	if err != nil {
+		return nil, err
+	}
+	return &QUICDialer{conn: conn}, nil
+}
+
+// Open opens a new stream to carry one Reflex connection.
+func (d *QUICDialer) Open(ctx context.Context) (net.Conn, error) {
+	stream, err := d.conn.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &QUICConn{Stream: stream, conn: d.conn}, nil
+}
+
+// Close closes the QUIC connection and all its streams.
+func (d *QUICDialer) Close() error {
+	return d.conn.CloseWithError(0, "")
+}
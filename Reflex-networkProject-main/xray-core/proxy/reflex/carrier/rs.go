@@ -0,0 +1,127 @@
+package carrier
+
+import "errors"
+
+// Reed–Solomon erasure coding over GF(2^8), for FECConn. The code is
+// systematic: a group of n data shards goes out as is, followed by parity
+// shards that are rows of a Cauchy matrix times the data shards. Every n×n
+// submatrix of the identity stacked on a Cauchy matrix is invertible, so any
+// n shards of a group, data or parity, give back all its data shards.
+
+var gfExp [510]byte
+var gfLog [256]byte
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		gfExp[i] = byte(x)
+		gfLog[x] = byte(i)
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11d
+		}
+	}
+	for i := 255; i < len(gfExp); i++ {
+		gfExp[i] = gfExp[i-255]
+	}
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+func gfInv(a byte) byte {
+	return gfExp[255-int(gfLog[a])]
+}
+
+// maxShards bounds data plus parity shards of a group: the Cauchy points
+// must be distinct field elements.
+const maxShards = 256
+
+// codingRow returns the coefficients of shard index of a group of n data
+// shards: a unit row for a data shard, a Cauchy row 1/(x+y) with x = index
+// and y the data shard's index for a parity shard.
+func codingRow(index, n int) []byte {
+	row := make([]byte, n)
+	if index < n {
+		row[index] = 1
+		return row
+	}
+	for i := range row {
+		row[i] = gfInv(byte(index) ^ byte(i))
+	}
+	return row
+}
+
+// mulAdd adds c times src to dst.
+func mulAdd(dst, src []byte, c byte) {
+	if c == 0 {
+		return
+	}
+	for i, b := range src {
+		dst[i] ^= gfMul(c, b)
+	}
+}
+
+// encodeParity returns parity shard index (n <= index) of data, n shards of
+// equal length.
+func encodeParity(data [][]byte, index int) []byte {
+	parity := make([]byte, len(data[0]))
+	for i, c := range codingRow(index, len(data)) {
+		mulAdd(parity, data[i], c)
+	}
+	return parity
+}
+
+// reconstruct returns the n data shards of a group from n of its shards of
+// equal length, by index.
+func reconstruct(shards map[int][]byte, n int) ([][]byte, error) {
+	if len(shards) < n {
+		return nil, errors.New("reflex: too few shards to recover the group")
+	}
+	// Solve rows × data = shards by Gauss-Jordan elimination on the rows
+	// of the shards at hand.
+	rows := make([][]byte, 0, n)
+	values := make([][]byte, 0, n)
+	for index, shard := range shards {
+		if len(rows) == n {
+			break
+		}
+		rows = append(rows, codingRow(index, n))
+		values = append(values, append([]byte(nil), shard...))
+	}
+	for col := 0; col < n; col++ {
+		pivot := -1
+		for r := col; r < n; r++ {
+			if rows[r][col] != 0 {
+				pivot = r
+				break
+			}
+		}
+		if pivot < 0 {
+			return nil, errors.New("reflex: shards do not determine the group")
+		}
+		rows[col], rows[pivot] = rows[pivot], rows[col]
+		values[col], values[pivot] = values[pivot], values[col]
+		if c := gfInv(rows[col][col]); c != 1 {
+			for i := range rows[col] {
+				rows[col][i] = gfMul(c, rows[col][i])
+			}
+			for i := range values[col] {
+				values[col][i] = gfMul(c, values[col][i])
+			}
+		}
+		for r := 0; r < n; r++ {
+			if r == col || rows[r][col] == 0 {
+				continue
+			}
+			c := rows[r][col]
+			mulAdd(rows[r], rows[col], c)
+			mulAdd(values[r], values[col], c)
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,366 @@
+package carrier
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// UDPIdleTimeout is how long a UDP carrier connection may go without a
+// datagram from its peer before the listener drops it.
+const UDPIdleTimeout = 2 * time.Minute
+
+// udpPeerQueue is how many datagrams from one peer are held for reading.
+const udpPeerQueue = 256
+
+// Before a UDP listener keeps any state for an address, the address must
+// show that it receives what is sent to it, so that a spoofed source gets
+// neither a connection nor more bytes sent to it than it sent:
+//
+//	client: hello  (1) | padding, udpHelloSize in all
+//	server: cookie (1) | cookie (udpCookieSize)
+//	client: echo   (1) | cookie
+//	server: accept (1)
+//
+// The cookie is a MAC of the address and the time, see cookieFor, so the
+// server keeps nothing until the echo. The client sends hello, and echo,
+// again until it has an answer, and FEC datagrams after accept; an echo
+// that comes again gets accept again. These datagrams are told apart from
+// FEC datagrams by their first byte, which FEC datagrams take only past
+// group 0xfc000000, after billions of datagrams; later ones are dropped.
+const (
+	udpHello  = 0xfc
+	udpCookie = 0xfd
+	udpEcho   = 0xfe
+	udpAccept = 0xff
+
+	udpHelloSize    = 64
+	udpCookieSize   = 16
+	udpCookieWindow = 30 * time.Second // a cookie stays valid for one to two of these
+	// udpRetryInterval is how long the client waits for an answer to
+	// hello or echo before sending it again.
+	udpRetryInterval = 250 * time.Millisecond
+	// UDPHandshakeTimeout bounds DialUDP's exchange of cookies.
+	UDPHandshakeTimeout = 10 * time.Second
+)
+
+// UDPListener is a datagram carrier listener: every address that sends to it
+// becomes a connection with forward error correction, see FECConn, handed
+// to a handler in its own goroutine.
+type UDPListener struct {
+	pc     net.PacketConn
+	handle func(net.Conn)
+	fec    FEC
+	secret []byte // of the cookies, see cookieFor
+
+	mu     sync.Mutex
+	peers  map[string]*udpPeer
+	closed bool
+}
+
+// ListenUDP starts a UDP carrier listener on addr. Both ends of a carrier
+// connection must use the same FEC settings.
+func ListenUDP(addr string, fec FEC, handle func(net.Conn)) (*UDPListener, error) {
+	if err := fec.validate(); err != nil {
+		return nil, err
+	}
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		_ = pc.Close()
+		return nil, err
+	}
+	l := &UDPListener{pc: pc, handle: handle, fec: fec, secret: secret, peers: make(map[string]*udpPeer)}
+	go l.readLoop()
+	return l, nil
+}
+
+// Addr returns the listener's UDP address.
+func (l *UDPListener) Addr() net.Addr {
+	return l.pc.LocalAddr()
+}
+
+// Close stops accepting, closes every connection and the socket.
+func (l *UDPListener) Close() error {
+	l.mu.Lock()
+	l.closed = true
+	peers := l.peers
+	l.peers = make(map[string]*udpPeer)
+	l.mu.Unlock()
+	for _, p := range peers {
+		_ = p.Close()
+	}
+	return l.pc.Close()
+}
+
+func (l *UDPListener) readLoop() {
+	b := make([]byte, MaxDatagramSize)
+	for {
+		n, addr, err := l.pc.ReadFrom(b)
+		if err != nil {
+			return
+		}
+		l.mu.Lock()
+		if l.closed {
+			l.mu.Unlock()
+			return
+		}
+		p := l.peers[addr.String()]
+		l.mu.Unlock()
+		d := b[:n]
+		switch {
+		case n == 0:
+		case p == nil:
+			l.admit(addr, d)
+		case d[0] == udpEcho:
+			// Our accept was lost.
+			_, _ = l.pc.WriteTo([]byte{udpAccept}, addr)
+		case d[0] >= udpHello:
+			// A hello sent again.
+		default:
+			select {
+			case p.in <- append([]byte(nil), d...):
+			default:
+			}
+		}
+	}
+}
+
+// admit answers a datagram from addr, which has no connection: hello with
+// a cookie, and echo of a valid cookie by opening the connection. Anything
+// else is dropped.
+func (l *UDPListener) admit(addr net.Addr, d []byte) {
+	switch {
+	case len(d) >= udpHelloSize && d[0] == udpHello:
+		_, _ = l.pc.WriteTo(append([]byte{udpCookie}, l.cookieFor(addr, time.Now())...), addr)
+	case len(d) == 1+udpCookieSize && d[0] == udpEcho && l.validCookie(addr, d[1:]):
+		l.mu.Lock()
+		if l.closed || l.peers[addr.String()] != nil {
+			l.mu.Unlock()
+			return
+		}
+		p := &udpPeer{l: l, addr: addr, in: make(chan []byte, udpPeerQueue), done: make(chan struct{}), wake: make(chan struct{})}
+		l.peers[addr.String()] = p
+		l.mu.Unlock()
+		_, _ = l.pc.WriteTo([]byte{udpAccept}, addr)
+		go l.serve(p)
+	}
+}
+
+// cookieFor returns the cookie of addr in the window of now.
+func (l *UDPListener) cookieFor(addr net.Addr, now time.Time) []byte {
+	mac := hmac.New(sha256.New, l.secret)
+	_ = binary.Write(mac, binary.BigEndian, now.UnixNano()/int64(udpCookieWindow))
+	mac.Write([]byte(addr.String()))
+	return mac.Sum(nil)[:udpCookieSize]
+}
+
+// validCookie reports whether cookie is that of addr in this window or the
+// last.
+func (l *UDPListener) validCookie(addr net.Addr, cookie []byte) bool {
+	now := time.Now()
+	return hmac.Equal(cookie, l.cookieFor(addr, now)) || hmac.Equal(cookie, l.cookieFor(addr, now.Add(-udpCookieWindow)))
+}
+
+func (l *UDPListener) serve(p *udpPeer) {
+	defer p.Close()
+	conn, err := NewFECConn(p, l.fec)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	l.handle(conn)
+}
+
+// udpPeer is the listener's datagram connection to one address.
+type udpPeer struct {
+	l    *UDPListener
+	addr net.Addr
+	in   chan []byte
+	done chan struct{}
+	once sync.Once
+
+	mu            sync.Mutex
+	readDeadline  time.Time
+	writeDeadline time.Time
+	wake          chan struct{} // closed, and replaced, when readDeadline is
+}
+
+func (p *udpPeer) Read(b []byte) (int, error) {
+	idle := time.NewTimer(UDPIdleTimeout)
+	defer idle.Stop()
+	for {
+		p.mu.Lock()
+		deadline, wake := p.readDeadline, p.wake
+		p.mu.Unlock()
+		var expired <-chan time.Time
+		if !deadline.IsZero() {
+			wait := time.Until(deadline)
+			if wait <= 0 {
+				return 0, os.ErrDeadlineExceeded
+			}
+			t := time.NewTimer(wait)
+			defer t.Stop()
+			expired = t.C
+		}
+		select {
+		case d := <-p.in:
+			return copy(b, d), nil
+		case <-p.done:
+			return 0, io.EOF
+		case <-idle.C:
+			_ = p.Close()
+			return 0, os.ErrDeadlineExceeded
+		case <-expired:
+			return 0, os.ErrDeadlineExceeded
+		case <-wake:
+		}
+	}
+}
+
+// Write sends b as one datagram. The socket is shared by all peers and a
+// datagram is sent or dropped without waiting, so the write deadline is
+// only checked before it.
+func (p *udpPeer) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	deadline := p.writeDeadline
+	p.mu.Unlock()
+	if !deadline.IsZero() && !time.Now().Before(deadline) {
+		return 0, os.ErrDeadlineExceeded
+	}
+	select {
+	case <-p.done:
+		return 0, net.ErrClosed
+	default:
+	}
+	return p.l.pc.WriteTo(b, p.addr)
+}
+
+// Close forgets the peer; a later datagram from it opens a new connection.
+func (p *udpPeer) Close() error {
+	p.once.Do(func() {
+		close(p.done)
+		p.l.mu.Lock()
+		if p.l.peers[p.addr.String()] == p {
+			delete(p.l.peers, p.addr.String())
+		}
+		p.l.mu.Unlock()
+	})
+	return nil
+}
+
+func (p *udpPeer) LocalAddr() net.Addr  { return p.l.pc.LocalAddr() }
+func (p *udpPeer) RemoteAddr() net.Addr { return p.addr }
+
+func (p *udpPeer) SetDeadline(t time.Time) error {
+	_ = p.SetWriteDeadline(t)
+	return p.SetReadDeadline(t)
+}
+
+// The socket is shared by all peers, so deadlines are kept by the peer.
+func (p *udpPeer) SetReadDeadline(t time.Time) error {
+	p.mu.Lock()
+	p.readDeadline = t
+	close(p.wake)
+	p.wake = make(chan struct{})
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *udpPeer) SetWriteDeadline(t time.Time) error {
+	p.mu.Lock()
+	p.writeDeadline = t
+	p.mu.Unlock()
+	return nil
+}
+
+// DialUDP connects to a UDP carrier listener: it shows the listener that
+// it receives at its address, see udpHello, within UDPHandshakeTimeout.
+func DialUDP(ctx context.Context, addr string, fec FEC) (*FECConn, error) {
+	if err := fec.validate(); err != nil {
+		return nil, err
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if err := udpHandshake(ctx, conn); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	c, err := NewFECConn(&udpClientConn{Conn: conn}, fec)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// udpHandshake exchanges hello, cookie, echo and accept over conn.
+func udpHandshake(ctx context.Context, conn net.Conn) error {
+	ctx, cancel := context.WithTimeout(ctx, UDPHandshakeTimeout)
+	defer cancel()
+	stop := context.AfterFunc(ctx, func() { _ = conn.SetReadDeadline(time.Now()) })
+	defer stop()
+
+	msg := make([]byte, udpHelloSize)
+	if _, err := rand.Read(msg); err != nil {
+		return err
+	}
+	msg[0] = udpHello
+	b := make([]byte, MaxDatagramSize)
+	for ctx.Err() == nil {
+		if _, err := conn.Write(msg); err != nil {
+			return err
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(udpRetryInterval))
+		for ctx.Err() == nil {
+			n, err := conn.Read(b)
+			if err != nil {
+				var ne net.Error
+				if errors.As(err, &ne) && ne.Timeout() {
+					break // send msg again
+				}
+				return err
+			}
+			switch {
+			case n == 1+udpCookieSize && b[0] == udpCookie && msg[0] == udpHello:
+				msg = append([]byte{udpEcho}, b[1:n]...)
+			case n == 1 && b[0] == udpAccept && msg[0] == udpEcho:
+				_ = conn.SetReadDeadline(time.Time{})
+				return nil
+			default:
+				continue
+			}
+			break
+		}
+	}
+	return errors.New("reflex: UDP carrier handshake timed out")
+}
+
+// udpClientConn drops the answers to hello and echo sent again that come
+// after accept.
+type udpClientConn struct {
+	net.Conn
+}
+
+func (c *udpClientConn) Read(b []byte) (int, error) {
+	for {
+		n, err := c.Conn.Read(b)
+		if err != nil || n == 0 || b[0] < udpHello {
+			return n, err
+		}
+	}
+}
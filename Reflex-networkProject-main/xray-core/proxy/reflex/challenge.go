@@ -0,0 +1,83 @@
+package reflex
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"io"
+	"time"
+)
+
+// ChallengeSize is the length of the random payload of a CHALLENGE frame.
+const ChallengeSize = 32
+
+// ChallengeTimeout is how long the server waits for a CHALLENGE_RESPONSE
+// before terminating the session.
+const ChallengeTimeout = 10 * time.Second
+
+// NewChallenge returns a fresh random challenge.
+func NewChallenge() ([]byte, error) {
+	c := make([]byte, ChallengeSize)
+	if _, err := io.ReadFull(rand.Reader, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// ChallengeResponse returns the answer to challenge: an HMAC keyed with the
+// user secret (the PSK if the user has one, otherwise the 16 UUID bytes).
+// Holding the session key alone is not enough to answer, so a challenge
+// re-authenticates the user behind a session.
+func ChallengeResponse(secret, challenge []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("reflex-challenge"))
+	mac.Write(challenge)
+	return mac.Sum(nil)
+}
+
+// VerifyChallengeResponse reports whether response answers challenge in
+// constant time.
+func VerifyChallengeResponse(secret, challenge, response []byte) bool {
+	return hmac.Equal(ChallengeResponse(secret, challenge), response)
+}
+
+// AnswerChallenge writes the CHALLENGE_RESPONSE for a CHALLENGE frame read
+// from the server.
+func AnswerChallenge(s *Session, w io.Writer, secret []byte, challenge *Frame) error {
+	return s.WriteFrame(w, FrameTypeChallengeResponse, ChallengeResponse(secret, challenge.Payload))
+}
+
+// Anomaly thresholds used by AnomalyDetector.
+const (
+	MaxUnknownFrames      = 8  // frames of a type the server does not know
+	MaxControlFrameStreak = 64 // control frames in a row without any data
+)
+
+// AnomalyDetector applies simple heuristics to the frames of one session.
+// A real client only sends frame types it knows the server understands and
+// interleaves control frames with data; anything else suggests a probe or a
+// session key in the wrong hands.
+type AnomalyDetector struct {
+	unknown       int
+	controlStreak int
+}
+
+// Observe accounts for f and reports whether the session now looks anomalous.
+func (d *AnomalyDetector) Observe(f *Frame) bool {
+	switch {
+	case carriesData(f.Type):
+		d.controlStreak = 0
+	case IsControlFrame(f.Type):
+		d.controlStreak++
+	case f.Type == FrameTypeChallengeResponse, f.Type == FrameTypePolicyRequest, f.Type == FrameTypeProfileSwitch, f.Type == FrameTypeClose, f.Type == FrameTypeAddr,
+		f.Type == FrameTypeStreamOpen, f.Type == FrameTypeStreamClose:
+	default:
+		d.unknown++
+	}
+	return d.unknown > MaxUnknownFrames || d.controlStreak > MaxControlFrameStreak
+}
+
+// Reset clears the counters, e.g. after the session passed a challenge.
+func (d *AnomalyDetector) Reset() {
+	*d = AnomalyDetector{}
+}
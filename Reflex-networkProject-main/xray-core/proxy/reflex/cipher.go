@@ -0,0 +1,133 @@
+package reflex
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"runtime"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/sys/cpu"
+)
+
+// Cipher is the AEAD that seals the frames of a session. Clients list the
+// ones they would rather use in PolicyReq.Ciphers and the server picks one
+// for the grant, see ChooseCipher; a grant without one, as older servers
+// send, means ChaCha20-Poly1305.
+type Cipher uint8
+
+const (
+	CipherChaCha20Poly1305 Cipher = iota
+	CipherAES256GCM
+)
+
+// String returns the name of c in policy requests and grants.
+func (c Cipher) String() string {
+	switch c {
+	case CipherChaCha20Poly1305:
+		return "chacha20-poly1305"
+	case CipherAES256GCM:
+		return "aes-256-gcm"
+	}
+	return "unknown"
+}
+
+// ParseCipher maps a name from a policy request or grant to a Cipher. The
+// empty string means ChaCha20-Poly1305.
+func ParseCipher(name string) (Cipher, error) {
+	switch name {
+	case "", "chacha20-poly1305":
+		return CipherChaCha20Poly1305, nil
+	case "aes-256-gcm":
+		return CipherAES256GCM, nil
+	}
+	return CipherChaCha20Poly1305, errors.New("reflex: unknown cipher " + name)
+}
+
+// HasAESHardware reports whether this machine has instructions for both AES
+// and the GHASH of GCM: AES-NI and PCLMULQDQ on amd64, the ARMv8 crypto
+// extensions (the NEON AES and PMULL instructions) on arm64, CPACF on
+// s390x. Without them AES-GCM is several times slower than
+// ChaCha20-Poly1305, and not constant-time. It is detected once, at startup.
+var HasAESHardware = hasAESHardware()
+
+func hasAESHardware() bool {
+	switch runtime.GOARCH {
+	case "amd64":
+		return cpu.X86.HasAES && cpu.X86.HasPCLMULQDQ
+	case "arm64":
+		return cpu.ARM64.HasAES && cpu.ARM64.HasPMULL
+	case "s390x":
+		return cpu.S390X.HasAES && cpu.S390X.HasAESGCM
+	}
+	return false
+}
+
+// PreferredCipher returns the cipher that is fastest on this machine.
+func PreferredCipher() Cipher {
+	if HasAESHardware {
+		return CipherAES256GCM
+	}
+	return CipherChaCha20Poly1305
+}
+
+// OfferedCiphers returns what a client on this machine lists in
+// PolicyReq.Ciphers, or nil when it has no reason to leave the default.
+func OfferedCiphers() []string {
+	if c := PreferredCipher(); c != CipherChaCha20Poly1305 {
+		return []string{c.String()}
+	}
+	return nil
+}
+
+// ChooseCipher is the server side of the negotiation: preferred, the
+// server's own choice, if the client offered it, and ChaCha20-Poly1305,
+// which every peer speaks, otherwise.
+func ChooseCipher(offered []string, preferred Cipher) Cipher {
+	if preferred != CipherChaCha20Poly1305 && contains(offered, preferred.String()) {
+		return preferred
+	}
+	return CipherChaCha20Poly1305
+}
+
+// newAEAD returns the AEAD of c keyed with the 32-byte key.
+func newAEAD(c Cipher, key []byte) (cipher.AEAD, error) {
+	switch c {
+	case CipherChaCha20Poly1305:
+		return chacha20poly1305.New(key)
+	case CipherAES256GCM:
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		return cipher.NewGCM(block)
+	}
+	return nil, errors.New("reflex: unknown cipher")
+}
+
+// SetCipher switches the AEAD of the session, and of every key epoch, to c.
+// Both peers must switch before the first frame, which they do when the
+// grant names c; setting the cipher the session already uses does nothing.
+func (s *Session) SetCipher(c Cipher) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c == s.cipher {
+		return nil
+	}
+	if s.writeNonceCount != 0 || s.readSeen {
+		return errors.New("reflex: cipher must be set before the first frame")
+	}
+	aead, err := newAEAD(c, s.key)
+	if err != nil {
+		return err
+	}
+	s.aead, s.cipher = aead, c
+	return s.initEpochs()
+}
+
+// Cipher returns the AEAD the session is sealed with.
+func (s *Session) Cipher() Cipher {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cipher
+}
@@ -0,0 +1,580 @@
+package reflex
+
+import (
+	"bufio"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/curve25519"
+
+	"github.com/xtls/xray-core/proxy/reflex/handshake"
+)
+
+// ClientOptions configures ClientHandshake.
+type ClientOptions struct {
+	UserID [16]byte
+	// ServerKey, if set, is the server's public identity key: the
+	// handshake is sealed to it, see SealHandshake, and fails unless the
+	// server signed it with the key, see SignHandshake. Nil accepts any
+	// server, and sends the user ID in the clear.
+	ServerKey ed25519.PublicKey
+	// Secret answers server challenges: the user's PSK if one is configured,
+	// otherwise the 16 UUID bytes.
+	Secret []byte
+	// Policy is the profile, bandwidth and features the client asks for.
+	// Nil asks for the server's default.
+	Policy *PolicyReq
+	// Cookie is echoed from a previous RetryError, if any.
+	Cookie []byte
+	// ClockOffset is added to the local clock for the handshake timestamp
+	// (see ClockOffset).
+	ClockOffset time.Duration
+	// Fragment splits the handshake into several writes, see
+	// WriteFragmented. Nil sends it in one write.
+	Fragment *FragmentConfig
+	// Fronts, if set, sends the handshake as an HTTP POST to one of these
+	// Host and path pairs, picked anew for every connection, instead of
+	// with the magic number.
+	Fronts []*CoverFront
+	// Strategy, if set, shapes every write to and read from conn, see
+	// Strategy. conn must then be a net.Conn.
+	Strategy Strategy
+	// OnInterference, if set, is told about signs of interference on the
+	// session, so the client can switch carrier or preset.
+	OnInterference func(*InterferenceEvent)
+	// KeyLogWriter, if set, receives the session key in key log format,
+	// see WriteKeyLog. It is meant for test environments only.
+	KeyLogWriter io.Writer
+	// RefuseProfiles opts out of the profiles the server offers, see
+	// OfferProfile; they are dropped unread.
+	RefuseProfiles bool
+	// AcceptProfile, if set, is asked about every offered profile that
+	// passed validation, e.g. to persist it; returning false drops it. Nil
+	// keeps them all.
+	AcceptProfile func(*TrafficProfile) bool
+	// Transcript, if set, receives a redacted transcript of the session,
+	// see SessionTranscript.
+	Transcript io.Writer
+	// Stats, if set, adds up the statistics of the session with those of
+	// the other sessions it is set for. Every session keeps its own as
+	// well, see ClientConn.Stats.
+	Stats *ClientStats
+	// Power, if set, switches the session to low-power mode while the
+	// device asks for it, see PowerMode.
+	Power *PowerMode
+	// UpstreamProxy, if set, is the URL of an HTTP proxy ("http://") that
+	// takes CONNECT requests or of a SOCKS5 proxy ("socks5://") the
+	// dialers reach the server through, with the user info of the URL as
+	// credentials. The handshake runs inside the proxied connection.
+	// ClientHandshake, which is given its connection, ignores it.
+	UpstreamProxy string
+	// Migrate re-attaches a session whose connection failed, e.g. because
+	// the client's address changed, to a new connection to the same server
+	// with ResumeMagic, so that it and the tunnels it carries go on. Only
+	// the sessions the dialers make migrate by themselves; others can be
+	// moved with ClientConn.Resume.
+	Migrate bool
+	// Lanes is the number of connections the dialers spread a session
+	// over, see Bond; more than one asks the server for FeatureBonding.
+	// ClientHandshake only asks, and bonds the session if it is granted;
+	// the other connections can then be added with ClientConn.AddLane.
+	Lanes int
+	// Pacing keeps the gaps between shaped frames on a schedule and paces
+	// the socket, see Session.SetPacing.
+	Pacing bool
+	// Morphing is how much of the profile the server pushes shapes the
+	// DATA frames the client sends, see MorphingMode.
+	Morphing MorphingMode
+	// OneTimeID sends OneTimeID(Secret, timestamp) in place of UserID, so
+	// that a captured handshake does not identify the user after its step.
+	// The server must accept one-time IDs, see IDMode.
+	OneTimeID bool
+	// DestinationProfiles, if set, shapes what a tunnel sends with the
+	// profile its destination maps to, until the server pushes one.
+	DestinationProfiles *DestinationProfiles
+	// UsageReports asks the server for FeatureUsageReports; the reports
+	// are kept for ClientConn.Usage and passed to OnUsage, if set.
+	UsageReports bool
+	OnUsage      func(*UsageReport)
+	// Mux asks the server for FeatureMux, so that the session can carry
+	// streams, see NewMux.
+	Mux bool
+	// UDP asks the server for FeatureUDP, so that the session can carry
+	// datagrams, see WriteDatagram.
+	UDP bool
+}
+
+// maxOfferedProfiles bounds the profiles a client keeps from offers.
+const maxOfferedProfiles = 32
+
+// RetryError is returned by ClientHandshake when the server asked for a
+// stateless retry. Reconnect and pass Cookie in ClientOptions.
+type RetryError struct {
+	Cookie []byte
+}
+
+func (e *RetryError) Error() string {
+	return "reflex: server requested retry with cookie"
+}
+
+// ErrHandshakeRejected is returned when the server refused the handshake,
+// e.g. because the user is unknown or its policy request was denied.
+var ErrHandshakeRejected = errors.New("reflex: handshake rejected")
+
+// ClientConn is the client end of an established session. Grant is what the
+// server actually granted, which may be narrower than what was requested; it
+// is replaced whenever the server sends a new POLICY_GRANT.
+type ClientConn struct {
+	Session *Session
+	Grant   *PolicyGrant
+	// Profile is the traffic profile last announced by the server.
+	Profile string
+	// CloseReason is set once the server announced that it is closing the
+	// session; no new requests should be started on it.
+	CloseReason string
+
+	connMu  sync.Mutex // guards conn and reader, which Resume replaces
+	conn    io.ReadWriter
+	reader  *bufio.Reader
+	secret  []byte
+	monitor *InterferenceMonitor           // nil unless OnInterference is set
+	shape   atomic.Pointer[TrafficProfile] // nil until the server pushes a shape
+
+	refuseProfiles bool
+	acceptProfile  func(*TrafficProfile) bool
+	mu             sync.Mutex
+	offered        map[string]*TrafficProfile // profiles kept from offers, by name
+
+	stats        sessionCounters
+	power        *PowerMode
+	lowKeepAlive atomic.Bool // whether the connection has the low-power keepalive period
+
+	resumeMu    sync.Mutex                                  // serializes migrations
+	redial      func(ctx context.Context) (net.Conn, error) // nil unless the session migrates by itself
+	strategy    Strategy
+	ended       atomic.Bool // closed by either peer; never resumed
+	unconfirmed atomic.Bool // resumed, and nothing read on the new connection yet
+
+	resumeRotation atomic.Uint32 // of the grant in force, see PolicyGrant.ResumeRotation
+
+	usage   atomic.Pointer[UsageReport] // the last usage report, if any
+	onUsage func(*UsageReport)
+}
+
+// ClientHandshake performs a magic-number handshake over conn and returns
+// the session along with the granted policy.
+func ClientHandshake(conn io.ReadWriter, opts *ClientOptions) (*ClientConn, error) {
+	if opts.Strategy != nil {
+		nc, ok := conn.(net.Conn)
+		if !ok {
+			return nil, errors.New("reflex: a strategy needs a net.Conn")
+		}
+		conn = NewStrategyConn(nc, opts.Strategy)
+	}
+	var priv, pub [32]byte
+	if _, err := rand.Read(priv[:]); err != nil {
+		return nil, err
+	}
+	curve25519.ScalarBaseMult(&pub, &priv)
+
+	var nonce [16]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return nil, err
+	}
+
+	var policyReq []byte
+	if opts.Policy != nil || opts.Lanes > 1 || opts.UsageReports || opts.Mux || opts.UDP || HasAESHardware {
+		var req PolicyReq
+		if opts.Policy != nil {
+			req = *opts.Policy
+		}
+		if req.Version == 0 {
+			req.Version = PolicyVersion
+		}
+		if opts.Lanes > 1 && !contains(req.Features, FeatureBonding) {
+			req.Features = append(append([]string(nil), req.Features...), FeatureBonding)
+		}
+		if opts.UsageReports && !contains(req.Features, FeatureUsageReports) {
+			req.Features = append(append([]string(nil), req.Features...), FeatureUsageReports)
+		}
+		if opts.Mux && !contains(req.Features, FeatureMux) {
+			req.Features = append(append([]string(nil), req.Features...), FeatureMux)
+		}
+		if opts.UDP && !contains(req.Features, FeatureUDP) {
+			req.Features = append(append([]string(nil), req.Features...), FeatureUDP)
+		}
+		if req.Ciphers == nil {
+			req.Ciphers = OfferedCiphers()
+		}
+		policyReq = req.Marshal()
+	}
+	padding := NewHandshakePadding()
+	if opts.Cookie != nil {
+		padding = CookiePadding(opts.Cookie)
+	}
+
+	hello := &handshake.Client{
+		PublicKey: pub,
+		UserID:    opts.UserID,
+		Timestamp: time.Now().Add(opts.ClockOffset).Unix(),
+		Nonce:     nonce,
+		PolicyReq: policyReq,
+		Padding:   padding,
+	}
+	if opts.OneTimeID {
+		secret := opts.Secret
+		if secret == nil {
+			secret = opts.UserID[:]
+		}
+		hello.UserID = OneTimeID(secret, hello.Timestamp)
+	}
+	body, msg := hello.MarshalBody(), hello.Marshal()
+	if opts.ServerKey != nil {
+		sealed, err := SealHandshake(hello, priv, opts.ServerKey)
+		if err != nil {
+			return nil, err
+		}
+		body, msg = sealed.MarshalBody(), sealed.Marshal()
+	}
+	if len(opts.Fronts) > 0 {
+		msg = httpHandshake(PickFront(opts.Fronts), body, opts.ServerKey != nil)
+	}
+	start := time.Now()
+	var err error
+	if opts.Fragment != nil {
+		err = WriteFragmented(conn, msg, *opts.Fragment)
+	} else {
+		_, err = conn.Write(msg)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	httpResp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		return nil, err
+	}
+	rtt := time.Since(start)
+	respBody, err := io.ReadAll(httpResp.Body)
+	_ = httpResp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, ErrHandshakeRejected
+	}
+	var resp HandshakeResponse
+	if err := json.Unmarshal(respBody, &resp); err != nil {
+		return nil, errors.New("reflex: malformed handshake response")
+	}
+	if resp.RetryCookie != nil {
+		return nil, &RetryError{Cookie: resp.RetryCookie}
+	}
+
+	var shared [32]byte
+	curve25519.ScalarMult(&shared, &priv, &resp.PublicKey)
+	transcript := NewTranscript()
+	transcript.Write(body)
+	transcript.Write(resp.PublicKey[:])
+	sum := transcript.Sum()
+	sessionKey := DeriveSessionKey(shared, nonce[:], sum)
+	if !VerifyKeyConfirmation(sessionKey, sum, resp.KeyConfirm) {
+		return nil, errors.New("reflex: key confirmation failed")
+	}
+	if opts.ServerKey != nil && !VerifyHandshakeSignature(opts.ServerKey, sum, resp.ServerSignature) {
+		return nil, errors.New("reflex: server authentication failed")
+	}
+	if opts.KeyLogWriter != nil {
+		if err := WriteKeyLog(opts.KeyLogWriter, nonce[:], sessionKey); err != nil {
+			return nil, err
+		}
+	}
+
+	grant, err := ParsePolicyGrant(resp.PolicyGrant)
+	if err != nil {
+		return nil, err
+	}
+	if err := grant.Enforceable(); err != nil {
+		return nil, err
+	}
+	session, err := NewClientSession(sessionKey)
+	if err != nil {
+		return nil, err
+	}
+	session.SetPolicyVersion(grant.Version)
+	if c, _ := ParseCipher(grant.Cipher); c != CipherChaCha20Poly1305 {
+		if err := session.SetCipher(c); err != nil {
+			return nil, err
+		}
+	}
+	session.SetTLSRecords(grant.HasFeature(FeatureTLSRecords))
+	session.SetKeyCommitment(grant.HasFeature(FeatureKeyCommitment))
+	session.SetPacing(opts.Pacing)
+	session.SetMorphing(opts.Morphing)
+	if err := session.SetMaskedLengths(grant.HasFeature(FeatureMaskedLengths)); err != nil {
+		return nil, err
+	}
+	if grant.HasFeature(FeatureReorderTolerant) {
+		_ = session.SetOrdering(OrderingTolerant, DefaultReorderWindow)
+	}
+	session.SetTimestamps(grant.HasFeature(FeatureTimestamps))
+	if opts.Transcript != nil {
+		transcript := NewSessionTranscript(opts.Transcript)
+		transcript.Attach(session)
+		transcript.Event(TranscriptStart, grant.Profile)
+	}
+
+	c := &ClientConn{
+		Session: session,
+		Grant:   grant,
+		Profile: grant.Profile,
+		conn:    conn,
+		reader:  reader,
+		secret:  opts.Secret,
+
+		strategy:       opts.Strategy,
+		refuseProfiles: opts.RefuseProfiles,
+		acceptProfile:  opts.AcceptProfile,
+		power:          opts.Power,
+		onUsage:        opts.OnUsage,
+	}
+	c.resumeRotation.Store(grant.ResumeRotation)
+	if grant.HasFeature(FeatureBonding) {
+		bond := NewBond(session, conn, reader)
+		c.conn, c.reader = bond, bufio.NewReader(bond)
+	}
+	c.stats.started = start
+	c.stats.handshakeRTT = rtt
+	if opts.Stats != nil {
+		c.stats.aggregate = opts.Stats
+		opts.Stats.add(&c.stats)
+	}
+	if opts.OnInterference != nil {
+		remote := ""
+		if a, ok := conn.(interface{ RemoteAddr() net.Addr }); ok {
+			remote = a.RemoteAddr().String()
+		}
+		c.monitor = NewInterferenceMonitor(remote, opts.OnInterference)
+	}
+	return c, nil
+}
+
+// WriteFrame writes one frame to the server. DATA, STREAM_DATA and DATAGRAM
+// frames are shaped with the profile the server pushed, if any, see Shape,
+// unless the session is in low-power mode. It may be called concurrently
+// with ReadFrame.
+func (c *ClientConn) WriteFrame(frameType uint8, payload []byte) error {
+	low := c.lowPower()
+	var err error
+	for {
+		conn, _ := c.transport()
+		if shape := c.shape.Load(); shape != nil && carriesData(frameType) && !low {
+			err = WriteFrameWithMorphing(c.Session, conn, frameType, payload, shape)
+		} else {
+			if p := c.Session.getPacer(); p != nil && carriesData(frameType) {
+				// Unshaped data must not keep to the rate of shaped frames.
+				p.setRate(conn, 0, 0)
+			}
+			err = c.Session.WriteFrame(conn, frameType, payload)
+		}
+		if err == nil || !c.migrate(conn) {
+			break
+		}
+	}
+	if err == nil && carriesData(frameType) {
+		c.stats.countSent(len(payload))
+	}
+	return err
+}
+
+// WriteFrom writes n bytes read from r to the server as DATA frames, see
+// Session.WriteFrameFrom; a negative n writes until r ends. The frames are
+// not shaped, since a profile's packet sizes would cut them.
+func (c *ClientConn) WriteFrom(r io.Reader, n int64) (int64, error) {
+	conn, _ := c.transport()
+	return c.Session.writeFrameFrom(conn, r, n, c.stats.countSent)
+}
+
+// Shape returns the profile DATA frames to the server are shaped with, or nil
+// while they are sent as they are. The server sets it with a PROFILE_UPDATE
+// frame and adjusts it with PADDING_CTRL and TIMING_CTRL frames.
+func (c *ClientConn) Shape() *TrafficProfile {
+	return c.shape.Load()
+}
+
+// Close tells the server that the client is done with the session and
+// closes the connection, if it can be closed.
+func (c *ClientConn) Close() error {
+	c.stats.end()
+	c.ended.Store(true)
+	conn, _ := c.transport()
+	_ = CloseSession(c.Session, conn, "")
+	if closer, ok := conn.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// RenewPolicy asks the server for a new grant. The answer is applied to
+// Grant by ReadFrame when it arrives; if the server denies the request it
+// closes the session instead.
+func (c *ClientConn) RenewPolicy(req *PolicyReq) error {
+	renewed := *req
+	if renewed.Version == 0 {
+		renewed.Version = PolicyVersion
+	}
+	conn, _ := c.transport()
+	return RequestPolicy(c.Session, conn, &renewed)
+}
+
+// ReadFrame returns the next frame that is meant for the application.
+// Policy grants, challenges, profile switches, updates and offers, morphing
+// control frames, usage reports and CLOSE frames are handled internally.
+func (c *ClientConn) ReadFrame() (*Frame, error) {
+	for {
+		conn, reader := c.transport()
+		f, err := c.Session.ReadFrame(reader)
+		if err != nil && c.migrate(conn) {
+			continue
+		}
+		if err != nil {
+			c.stats.end()
+			if c.monitor != nil {
+				c.monitor.Failed(err)
+			}
+			return nil, err
+		}
+		c.unconfirmed.Store(false)
+		switch f.Type {
+		case FrameTypePolicyGrant:
+			grant, err := ParsePolicyGrant(f.Payload)
+			if err != nil {
+				return nil, err
+			}
+			if err := grant.Enforceable(); err != nil {
+				return nil, err
+			}
+			c.Grant = grant
+			c.Profile = grant.Profile
+			c.Session.SetPolicyVersion(grant.Version)
+			c.resumeRotation.Store(grant.ResumeRotation)
+		case FrameTypeChallenge:
+			if err := AnswerChallenge(c.Session, conn, c.secret, f); err != nil {
+				return nil, err
+			}
+		case FrameTypeProfileSwitch:
+			c.Profile = string(f.Payload)
+			if profile := c.switchedProfile(c.Profile); profile != nil {
+				c.shape.Store(profile)
+			}
+		case FrameTypeProfileOffer:
+			c.keepOffer(f.Payload)
+		case FrameTypeProfileUpdate:
+			profile, err := ParseProfileUpdate(f.Payload)
+			if err != nil {
+				return nil, err
+			}
+			c.Profile = profile.Name
+			c.shape.Store(profile)
+		case FrameTypePaddingCtrl, FrameTypeTimingCtrl:
+			if isEchoOnly(f.Type, f.Payload) {
+				continue
+			}
+			shape := c.shape.Load()
+			if shape == nil {
+				// No shape yet: the override applies to frames that are
+				// otherwise sent as they are.
+				shape = &TrafficProfile{Name: c.Profile}
+				c.shape.Store(shape)
+			}
+			ApplyControlFrame(shape, f.Type, f.Payload)
+		case FrameTypeUsage:
+			report, err := ParseUsageReport(f.Payload)
+			if err != nil {
+				return nil, err
+			}
+			c.usage.Store(report)
+			if c.onUsage != nil {
+				c.onUsage(report)
+			}
+		case FrameTypeClose:
+			c.ended.Store(true)
+			c.CloseReason = string(f.Payload)
+		default:
+			if carriesData(f.Type) {
+				c.stats.countReceived(len(f.Payload))
+				if c.monitor != nil {
+					c.monitor.Received(len(f.Payload))
+				}
+			}
+			return f, nil
+		}
+	}
+}
+
+// keepOffer validates an offered profile and keeps it unless the client
+// refuses it. Offers that fail validation are dropped; the session goes on.
+// Built-in profiles cannot be redefined.
+func (c *ClientConn) keepOffer(payload []byte) {
+	if c.refuseProfiles {
+		return
+	}
+	profile, err := ParseProfileUpdate(payload)
+	if err != nil || profile.Name == "" || Profiles[profile.Name] != nil {
+		return
+	}
+	c.mu.Lock()
+	_, known := c.offered[profile.Name]
+	full := len(c.offered) >= maxOfferedProfiles
+	c.mu.Unlock()
+	if (full && !known) || (c.acceptProfile != nil && !c.acceptProfile(profile)) {
+		return
+	}
+	c.mu.Lock()
+	if c.offered == nil {
+		c.offered = make(map[string]*TrafficProfile)
+	}
+	c.offered[profile.Name] = profile
+	c.mu.Unlock()
+}
+
+func (c *ClientConn) offeredProfile(name string) *TrafficProfile {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.offered[name]
+}
+
+// switchedProfile returns the profile a PROFILE_SWITCH to name shapes with:
+// the one the server offered by that name, or else a copy of the built-in
+// one, so that control frames override the session's shape only.
+func (c *ClientConn) switchedProfile(name string) *TrafficProfile {
+	if profile := c.offeredProfile(name); profile != nil {
+		return profile
+	}
+	if builtin := Profiles[name]; builtin != nil {
+		return &TrafficProfile{Name: builtin.Name, PacketSizes: builtin.PacketSizes, Delays: builtin.Delays}
+	}
+	return nil
+}
+
+// OfferedProfiles returns the profiles kept from the server's offers, by
+// name.
+func (c *ClientConn) OfferedProfiles() map[string]*TrafficProfile {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	profiles := make(map[string]*TrafficProfile, len(c.offered))
+	for name, p := range c.offered {
+		profiles[name] = p
+	}
+	return profiles
+}
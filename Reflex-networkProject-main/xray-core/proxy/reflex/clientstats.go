@@ -0,0 +1,133 @@
+package reflex
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// TrafficStats are the traffic statistics of one client session. Bytes are
+// DATA payload bytes, as the application sent and received them.
+type TrafficStats struct {
+	Started        time.Time
+	HandshakeRTT   time.Duration // from sending the handshake to its response
+	BytesSent      uint64
+	BytesReceived  uint64
+	FramesSent     uint64
+	FramesReceived uint64
+	Path           PathQuality // delay estimates, when FeatureTimestamps is granted
+}
+
+// sessionCounters counts a session's traffic, see TrafficStats.
+type sessionCounters struct {
+	started      time.Time
+	handshakeRTT time.Duration
+	sent         atomic.Uint64
+	received     atomic.Uint64
+	framesSent   atomic.Uint64
+	framesRecv   atomic.Uint64
+	ended        atomic.Bool
+	aggregate    *ClientStats // nil unless ClientOptions.Stats is set
+}
+
+func (s *sessionCounters) countSent(n int) {
+	s.sent.Add(uint64(n))
+	s.framesSent.Add(1)
+	if s.aggregate != nil {
+		s.aggregate.sent.Add(uint64(n))
+	}
+}
+
+func (s *sessionCounters) countReceived(n int) {
+	s.received.Add(uint64(n))
+	s.framesRecv.Add(1)
+	if s.aggregate != nil {
+		s.aggregate.received.Add(uint64(n))
+	}
+}
+
+// end takes the session out of the active ones, once.
+func (s *sessionCounters) end() {
+	if s.ended.CompareAndSwap(false, true) && s.aggregate != nil {
+		s.aggregate.active.Add(-1)
+	}
+}
+
+// Stats returns the session's statistics so far.
+func (c *ClientConn) Stats() TrafficStats {
+	return TrafficStats{
+		Started:        c.stats.started,
+		HandshakeRTT:   c.stats.handshakeRTT,
+		BytesSent:      c.stats.sent.Load(),
+		BytesReceived:  c.stats.received.Load(),
+		FramesSent:     c.stats.framesSent.Load(),
+		FramesReceived: c.stats.framesRecv.Load(),
+		Path:           c.Session.PathQuality(),
+	}
+}
+
+// ClientStats adds up the statistics of every session made with the
+// ClientOptions it is set in, e.g. for an application to show speed and
+// usage. It is safe for concurrent use; the zero value is ready to use.
+type ClientStats struct {
+	sessions  atomic.Int64
+	active    atomic.Int64
+	sent      atomic.Uint64
+	received  atomic.Uint64
+	handshake atomic.Int64 // total handshake RTT, in nanoseconds
+}
+
+// ClientStatsSnapshot is the state of a ClientStats at one point.
+type ClientStatsSnapshot struct {
+	Sessions      int64 // sessions established so far
+	Active        int64 // sessions neither closed nor failed yet
+	BytesSent     uint64
+	BytesReceived uint64
+	HandshakeRTT  time.Duration // average over all sessions
+}
+
+func (s *ClientStats) add(c *sessionCounters) {
+	s.sessions.Add(1)
+	s.active.Add(1)
+	s.handshake.Add(int64(c.handshakeRTT))
+}
+
+// Snapshot returns the statistics so far.
+func (s *ClientStats) Snapshot() ClientStatsSnapshot {
+	snap := ClientStatsSnapshot{
+		Sessions:      s.sessions.Load(),
+		Active:        s.active.Load(),
+		BytesSent:     s.sent.Load(),
+		BytesReceived: s.received.Load(),
+	}
+	if snap.Sessions > 0 {
+		snap.HandshakeRTT = time.Duration(s.handshake.Load() / snap.Sessions)
+	}
+	return snap
+}
+
+// Watch calls update every interval until ctx is done, with a snapshot and
+// the send and receive rates since the previous call, in bytes per second.
+func (s *ClientStats) Watch(ctx context.Context, interval time.Duration, update func(snap ClientStatsSnapshot, sendRate, receiveRate float64)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	last, lastAt := s.Snapshot(), time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			snap := s.Snapshot()
+			elapsed := now.Sub(lastAt).Seconds()
+			update(snap, float64(snap.BytesSent-last.BytesSent)/elapsed, float64(snap.BytesReceived-last.BytesReceived)/elapsed)
+			last, lastAt = snap, now
+		}
+	}
+}
+
+// Usage returns the last usage report of the server, if the session asked
+// for them with ClientOptions.UsageReports and one arrived.
+func (c *ClientConn) Usage() (*UsageReport, bool) {
+	r := c.usage.Load()
+	return r, r != nil
+}
@@ -0,0 +1,21 @@
+package reflex
+
+import (
+	"net/http"
+	"time"
+)
+
+// MaxClockSkew is how far a handshake timestamp may be from the server clock.
+const MaxClockSkew = 5 * time.Minute
+
+// ClockOffset returns how far the server clock is ahead of local, based on
+// the Date header of any server response (including a 403 for a rejected
+// timestamp). Clients add the offset to time.Now() for their next handshake.
+// The header has one-second resolution, which is ample for MaxClockSkew.
+func ClockOffset(dateHeader string, local time.Time) (time.Duration, error) {
+	serverTime, err := http.ParseTime(dateHeader)
+	if err != nil {
+		return 0, err
+	}
+	return serverTime.Sub(local.Truncate(time.Second)), nil
+}
@@ -0,0 +1,51 @@
+package reflex
+
+import (
+	"io"
+	"time"
+)
+
+// CloseReasonShutdown is sent in the CLOSE frame when the server drains its
+// sessions before shutting down or removing the inbound.
+const CloseReasonShutdown = "server shutting down"
+
+// CloseReasonUserRemoved is sent when the user of the session was removed,
+// by a reload or the API, and its sessions are terminated.
+const CloseReasonUserRemoved = "user removed"
+
+// CloseReasonExpired is sent when the session outlives the lifetime granted
+// by its policy.
+const CloseReasonExpired = "session expired"
+
+// CloseReasonOverloaded is sent when a session is ended because the inbound
+// ran out of its ResourceBudget for buffered frames.
+const CloseReasonOverloaded = "server overloaded"
+
+// CloseReasonQuota is sent when the user of the session used up the traffic
+// quota of its account, see UsageReport.
+const CloseReasonQuota = "quota exhausted"
+
+// CloseReasonKicked is sent when an administrator ends the sessions of a
+// user, see KickReason.
+const CloseReasonKicked = "kicked"
+
+// KickReason returns the reason sent when the sessions of a user are ended
+// by an administrator for the given reason code, such as "abuse" or
+// "payment"; the client sees "kicked: abuse".
+func KickReason(code string) string {
+	if code == "" {
+		return CloseReasonKicked
+	}
+	return CloseReasonKicked + ": " + code
+}
+
+// DefaultDrainTimeout is how long in-flight streams may continue after the
+// CLOSE frame before the connection is closed.
+const DefaultDrainTimeout = 10 * time.Second
+
+// CloseSession tells the peer that the session is ending, with a short
+// human-readable reason. The sender keeps serving frames already in flight
+// but the peer must not start anything new.
+func CloseSession(s *Session, w io.Writer, reason string) error {
+	return s.WriteFrame(w, FrameTypeClose, []byte(reason))
+}
@@ -0,0 +1,121 @@
+// Package command is the commander service of Reflex inbounds, through
+// which panels manage live sessions, for instance to disconnect a user
+// right away on abuse or a payment lapse.
+package command
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/xtls/xray-core/common"
+	"github.com/xtls/xray-core/core"
+	feature_inbound "github.com/xtls/xray-core/features/inbound"
+	"github.com/xtls/xray-core/proxy"
+)
+
+// maxReasonSize bounds the reason code, which is sent in a CLOSE frame.
+const maxReasonSize = 64
+
+// reflexInbound is a Reflex inbound, see inbound.Handler.
+type reflexInbound interface {
+	KickUser(email, reason string) int
+	RevokeResumption(email string) int
+}
+
+type service struct {
+	UnimplementedReflexServiceServer
+	inbounds feature_inbound.Manager
+}
+
+// NewService returns the service, finding Reflex inbounds in inbounds.
+func NewService(inbounds feature_inbound.Manager) ReflexServiceServer {
+	return &service{inbounds: inbounds}
+}
+
+// KickUser terminates every live session of a user, on the inbound with the
+// given tag or on every Reflex inbound.
+func (s *service) KickUser(ctx context.Context, request *KickUserRequest) (*KickUserResponse, error) {
+	if request.Email == "" {
+		return nil, status.Error(codes.InvalidArgument, "reflex: email is required")
+	}
+	if len(request.Reason) > maxReasonSize {
+		return nil, status.Error(codes.InvalidArgument, "reflex: reason is too long")
+	}
+	targets, err := s.targets(ctx, request.Tag)
+	if err != nil {
+		return nil, err
+	}
+	var sessions uint32
+	for _, in := range targets {
+		sessions += uint32(in.KickUser(request.Email, request.Reason))
+	}
+	return &KickUserResponse{Sessions: sessions}, nil
+}
+
+// RevokeResumption stops the live sessions of a user from being resumed,
+// on the inbound with the given tag or on every Reflex inbound.
+func (s *service) RevokeResumption(ctx context.Context, request *RevokeResumptionRequest) (*RevokeResumptionResponse, error) {
+	if request.Email == "" {
+		return nil, status.Error(codes.InvalidArgument, "reflex: email is required")
+	}
+	targets, err := s.targets(ctx, request.Tag)
+	if err != nil {
+		return nil, err
+	}
+	var sessions uint32
+	for _, in := range targets {
+		sessions += uint32(in.RevokeResumption(request.Email))
+	}
+	return &RevokeResumptionResponse{Sessions: sessions}, nil
+}
+
+// targets returns the Reflex inbound with the given tag, or every Reflex
+// inbound if tag is empty.
+func (s *service) targets(ctx context.Context, tag string) ([]reflexInbound, error) {
+	if tag != "" {
+		handler, err := s.inbounds.GetHandler(ctx, tag)
+		if err != nil {
+			return nil, status.Error(codes.NotFound, "reflex: no inbound "+tag)
+		}
+		in := asReflexInbound(handler)
+		if in == nil {
+			return nil, status.Error(codes.InvalidArgument, "reflex: inbound "+tag+" is not a Reflex inbound")
+		}
+		return []reflexInbound{in}, nil
+	}
+	var targets []reflexInbound
+	for _, handler := range s.inbounds.ListHandlers(ctx) {
+		if in := asReflexInbound(handler); in != nil {
+			targets = append(targets, in)
+		}
+	}
+	return targets, nil
+}
+
+func asReflexInbound(handler feature_inbound.Handler) reflexInbound {
+	gi, ok := handler.(proxy.GetInbound)
+	if !ok {
+		return nil
+	}
+	in, _ := gi.GetInbound().(reflexInbound)
+	return in
+}
+
+func (s *service) Register(server *grpc.Server) {
+	RegisterReflexServiceServer(server, s)
+}
+
+func init() {
+	common.Must(common.RegisterConfig((*Config)(nil), func(ctx context.Context, cfg interface{}) (interface{}, error) {
+		s := &service{}
+		if err := core.RequireFeatures(ctx, func(m feature_inbound.Manager) {
+			s.inbounds = m
+		}); err != nil {
+			return nil, err
+		}
+		return s, nil
+	}))
+}
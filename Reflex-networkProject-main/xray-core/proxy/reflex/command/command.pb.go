@@ -0,0 +1,335 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        v6.33.5
+// source: proxy/reflex/command/command.proto
+
+package command
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type KickUserRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Email         string                 `protobuf:"bytes,1,opt,name=email,proto3" json:"email,omitempty"`   // ایمیل کاربری که همهٔ سشن‌های زنده‌اش بسته می‌شود
+	Reason        string                 `protobuf:"bytes,2,opt,name=reason,proto3" json:"reason,omitempty"` // کد دلیل، مثلاً "abuse" یا "payment"، که در فریم CLOSE به کلاینت گفته می‌شود
+	Tag           string                 `protobuf:"bytes,3,opt,name=tag,proto3" json:"tag,omitempty"`       // تگ inbound؛ خالی یعنی همهٔ inboundهای Reflex
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *KickUserRequest) Reset() {
+	*x = KickUserRequest{}
+	mi := &file_proxy_reflex_command_command_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *KickUserRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*KickUserRequest) ProtoMessage() {}
+
+func (x *KickUserRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proxy_reflex_command_command_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use KickUserRequest.ProtoReflect.Descriptor instead.
+func (*KickUserRequest) Descriptor() ([]byte, []int) {
+	return file_proxy_reflex_command_command_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *KickUserRequest) GetEmail() string {
+	if x != nil {
+		return x.Email
+	}
+	return ""
+}
+
+func (x *KickUserRequest) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+func (x *KickUserRequest) GetTag() string {
+	if x != nil {
+		return x.Tag
+	}
+	return ""
+}
+
+type KickUserResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Sessions      uint32                 `protobuf:"varint,1,opt,name=sessions,proto3" json:"sessions,omitempty"` // تعداد سشن‌های بسته‌شده
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *KickUserResponse) Reset() {
+	*x = KickUserResponse{}
+	mi := &file_proxy_reflex_command_command_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *KickUserResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*KickUserResponse) ProtoMessage() {}
+
+func (x *KickUserResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proxy_reflex_command_command_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use KickUserResponse.ProtoReflect.Descriptor instead.
+func (*KickUserResponse) Descriptor() ([]byte, []int) {
+	return file_proxy_reflex_command_command_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *KickUserResponse) GetSessions() uint32 {
+	if x != nil {
+		return x.Sessions
+	}
+	return 0
+}
+
+type RevokeResumptionRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Email         string                 `protobuf:"bytes,1,opt,name=email,proto3" json:"email,omitempty"` // ایمیل کاربری که سشن‌های زنده‌اش دیگر resume یا bond نمی‌شوند
+	Tag           string                 `protobuf:"bytes,2,opt,name=tag,proto3" json:"tag,omitempty"`     // تگ inbound؛ خالی یعنی همهٔ inboundهای Reflex
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RevokeResumptionRequest) Reset() {
+	*x = RevokeResumptionRequest{}
+	mi := &file_proxy_reflex_command_command_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RevokeResumptionRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RevokeResumptionRequest) ProtoMessage() {}
+
+func (x *RevokeResumptionRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proxy_reflex_command_command_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RevokeResumptionRequest.ProtoReflect.Descriptor instead.
+func (*RevokeResumptionRequest) Descriptor() ([]byte, []int) {
+	return file_proxy_reflex_command_command_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *RevokeResumptionRequest) GetEmail() string {
+	if x != nil {
+		return x.Email
+	}
+	return ""
+}
+
+func (x *RevokeResumptionRequest) GetTag() string {
+	if x != nil {
+		return x.Tag
+	}
+	return ""
+}
+
+type RevokeResumptionResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Sessions      uint32                 `protobuf:"varint,1,opt,name=sessions,proto3" json:"sessions,omitempty"` // تعداد سشن‌هایی که کلید resume آن‌ها باطل شد
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RevokeResumptionResponse) Reset() {
+	*x = RevokeResumptionResponse{}
+	mi := &file_proxy_reflex_command_command_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RevokeResumptionResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RevokeResumptionResponse) ProtoMessage() {}
+
+func (x *RevokeResumptionResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proxy_reflex_command_command_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RevokeResumptionResponse.ProtoReflect.Descriptor instead.
+func (*RevokeResumptionResponse) Descriptor() ([]byte, []int) {
+	return file_proxy_reflex_command_command_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *RevokeResumptionResponse) GetSessions() uint32 {
+	if x != nil {
+		return x.Sessions
+	}
+	return 0
+}
+
+type Config struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Config) Reset() {
+	*x = Config{}
+	mi := &file_proxy_reflex_command_command_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Config) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Config) ProtoMessage() {}
+
+func (x *Config) ProtoReflect() protoreflect.Message {
+	mi := &file_proxy_reflex_command_command_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Config.ProtoReflect.Descriptor instead.
+func (*Config) Descriptor() ([]byte, []int) {
+	return file_proxy_reflex_command_command_proto_rawDescGZIP(), []int{4}
+}
+
+var File_proxy_reflex_command_command_proto protoreflect.FileDescriptor
+
+const file_proxy_reflex_command_command_proto_rawDesc = "" +
+	"\n" +
+	"\"proxy/reflex/command/command.proto\x12\x14reflex.proxy.command\"Q\n" +
+	"\x0fKickUserRequest\x12\x14\n" +
+	"\x05email\x18\x01 \x01(\tR\x05email\x12\x16\n" +
+	"\x06reason\x18\x02 \x01(\tR\x06reason\x12\x10\n" +
+	"\x03tag\x18\x03 \x01(\tR\x03tag\".\n" +
+	"\x10KickUserResponse\x12\x1a\n" +
+	"\bsessions\x18\x01 \x01(\rR\bsessions\"A\n" +
+	"\x17RevokeResumptionRequest\x12\x14\n" +
+	"\x05email\x18\x01 \x01(\tR\x05email\x12\x10\n" +
+	"\x03tag\x18\x02 \x01(\tR\x03tag\"6\n" +
+	"\x18RevokeResumptionResponse\x12\x1a\n" +
+	"\bsessions\x18\x01 \x01(\rR\bsessions\"\b\n" +
+	"\x06Config2\xdd\x01\n" +
+	"\rReflexService\x12Y\n" +
+	"\bKickUser\x12%.reflex.proxy.command.KickUserRequest\x1a&.reflex.proxy.command.KickUserResponse\x12q\n" +
+	"\x10RevokeResumption\x12-.reflex.proxy.command.RevokeResumptionRequest\x1a..reflex.proxy.command.RevokeResumptionResponseB0Z.github.com/xtls/xray-core/proxy/reflex/commandb\x06proto3"
+
+var (
+	file_proxy_reflex_command_command_proto_rawDescOnce sync.Once
+	file_proxy_reflex_command_command_proto_rawDescData []byte
+)
+
+func file_proxy_reflex_command_command_proto_rawDescGZIP() []byte {
+	file_proxy_reflex_command_command_proto_rawDescOnce.Do(func() {
+		file_proxy_reflex_command_command_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_proxy_reflex_command_command_proto_rawDesc), len(file_proxy_reflex_command_command_proto_rawDesc)))
+	})
+	return file_proxy_reflex_command_command_proto_rawDescData
+}
+
+var file_proxy_reflex_command_command_proto_msgTypes = make([]protoimpl.MessageInfo, 5)
+var file_proxy_reflex_command_command_proto_goTypes = []any{
+	(*KickUserRequest)(nil),          // 0: reflex.proxy.command.KickUserRequest
+	(*KickUserResponse)(nil),         // 1: reflex.proxy.command.KickUserResponse
+	(*RevokeResumptionRequest)(nil),  // 2: reflex.proxy.command.RevokeResumptionRequest
+	(*RevokeResumptionResponse)(nil), // 3: reflex.proxy.command.RevokeResumptionResponse
+	(*Config)(nil),                   // 4: reflex.proxy.command.Config
+}
+var file_proxy_reflex_command_command_proto_depIdxs = []int32{
+	0, // 0: reflex.proxy.command.ReflexService.KickUser:input_type -> reflex.proxy.command.KickUserRequest
+	2, // 1: reflex.proxy.command.ReflexService.RevokeResumption:input_type -> reflex.proxy.command.RevokeResumptionRequest
+	1, // 2: reflex.proxy.command.ReflexService.KickUser:output_type -> reflex.proxy.command.KickUserResponse
+	3, // 3: reflex.proxy.command.ReflexService.RevokeResumption:output_type -> reflex.proxy.command.RevokeResumptionResponse
+	2, // [2:4] is the sub-list for method output_type
+	0, // [0:2] is the sub-list for method input_type
+	0, // [0:0] is the sub-list for extension type_name
+	0, // [0:0] is the sub-list for extension extendee
+	0, // [0:0] is the sub-list for field type_name
+}
+
+func init() { file_proxy_reflex_command_command_proto_init() }
+func file_proxy_reflex_command_command_proto_init() {
+	if File_proxy_reflex_command_command_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_proxy_reflex_command_command_proto_rawDesc), len(file_proxy_reflex_command_command_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   5,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_proxy_reflex_command_command_proto_goTypes,
+		DependencyIndexes: file_proxy_reflex_command_command_proto_depIdxs,
+		MessageInfos:      file_proxy_reflex_command_command_proto_msgTypes,
+	}.Build()
+	File_proxy_reflex_command_command_proto = out.File
+	file_proxy_reflex_command_command_proto_goTypes = nil
+	file_proxy_reflex_command_command_proto_depIdxs = nil
+}
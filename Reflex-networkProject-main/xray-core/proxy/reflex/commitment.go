@@ -0,0 +1,71 @@
+package reflex
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+)
+
+// FeatureKeyCommitment is the policy feature that makes every frame of a
+// session commit to the session key, see SetKeyCommitment.
+const FeatureKeyCommitment = "key-commitment"
+
+// keyCommitmentSize is what a frame grows by when it commits to its key.
+const keyCommitmentSize = sha256.Size
+
+// SetKeyCommitment switches key commitment on or off. ChaCha20-Poly1305
+// does not commit to its key: a ciphertext can be made to open under two
+// chosen keys, which matters wherever a receiver tries several keys, such as
+// PSKs, and learns which one worked. With key commitment every record's
+// ciphertext is followed by
+//
+//	SHA-256("reflex-key-commitment" || key || nonce)
+//
+// with the key of the record's epoch, see Rekey, which the receiver checks
+// before opening the ciphertext, so a record opens under one key only. The
+// value differs from record to record, so it is no
+// more recognizable than the ciphertext. Both peers must switch before the
+// first frame, which they do when FeatureKeyCommitment is granted.
+func (s *Session) SetKeyCommitment(on bool) {
+	s.mu.Lock()
+	s.keyCommitment = on
+	s.mu.Unlock()
+}
+
+func (s *Session) commitsToKey() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.keyCommitment
+}
+
+// overhead returns how much longer than its plaintext a record's
+// ciphertext is.
+func (s *Session) overhead() int {
+	if s.commitsToKey() {
+		return s.aead.Overhead() + keyCommitmentSize
+	}
+	return s.aead.Overhead()
+}
+
+// commitmentOf returns the key commitment of the record sealed under key
+// with nonce.
+func commitmentOf(key, nonce []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte("reflex-key-commitment"))
+	h.Write(key)
+	h.Write(nonce)
+	return h.Sum(nil)
+}
+
+// openCommitted checks the key commitment at the end of ciphertext and
+// returns the ciphertext without it.
+func openCommitted(key, nonce, ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) < keyCommitmentSize {
+		return nil, errors.New("reflex: ciphertext too short")
+	}
+	sealed, commitment := ciphertext[:len(ciphertext)-keyCommitmentSize], ciphertext[len(ciphertext)-keyCommitmentSize:]
+	if !hmac.Equal(commitment, commitmentOf(key, nonce)) {
+		return nil, errors.New("reflex: key commitment mismatch")
+	}
+	return sealed, nil
+}
@@ -23,8 +23,14 @@ const (
 
 type User struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
-	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`         // UUID کاربر
-	Policy        string                 `protobuf:"bytes,2,opt,name=policy,proto3" json:"policy,omitempty"` // سیاست ترافیک (مثلاً "mimic-http2-api")
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`             // UUID کاربر
+	Policy        string                 `protobuf:"bytes,2,opt,name=policy,proto3" json:"policy,omitempty"`     // سیاست ترافیک (مثلاً "mimic-http2-api")
+	Psk           string                 `protobuf:"bytes,3,opt,name=psk,proto3" json:"psk,omitempty"`           // کلید از پیش مشترک (base64) برای handshake بدون X25519
+	Level         uint32                 `protobuf:"varint,4,opt,name=level,proto3" json:"level,omitempty"`      // سطح کاربر برای انتخاب قانون سیاست
+	Expiry        int64                  `protobuf:"varint,5,opt,name=expiry,proto3" json:"expiry,omitempty"`    // زمان انقضای حساب (ثانیهٔ یونیکس)؛ صفر یعنی بدون انقضا
+	Email         string                 `protobuf:"bytes,6,opt,name=email,proto3" json:"email,omitempty"`       // شناسهٔ کاربر در آمار، لاگ دسترسی و مدیریت کاربران؛ خالی یعنی همان UUID
+	Quota         uint64                 `protobuf:"varint,7,opt,name=quota,proto3" json:"quota,omitempty"`      // سهمیهٔ ترافیک کاربر به بایت (هر دو جهت)؛ صفر یعنی نامحدود
+	Morphing      string                 `protobuf:"bytes,8,opt,name=morphing,proto3" json:"morphing,omitempty"` // حالت morphing برای این کاربر: "full"، "padding-only" یا "off"؛ خالی یعنی همان حالت inbound
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -73,9 +79,57 @@ func (x *User) GetPolicy() string {
 	return ""
 }
 
+func (x *User) GetPsk() string {
+	if x != nil {
+		return x.Psk
+	}
+	return ""
+}
+
+func (x *User) GetLevel() uint32 {
+	if x != nil {
+		return x.Level
+	}
+	return 0
+}
+
+func (x *User) GetExpiry() int64 {
+	if x != nil {
+		return x.Expiry
+	}
+	return 0
+}
+
+func (x *User) GetEmail() string {
+	if x != nil {
+		return x.Email
+	}
+	return ""
+}
+
+func (x *User) GetQuota() uint64 {
+	if x != nil {
+		return x.Quota
+	}
+	return 0
+}
+
+func (x *User) GetMorphing() string {
+	if x != nil {
+		return x.Morphing
+	}
+	return ""
+}
+
+// حساب کاربر Reflex برای protocol.User؛ AsAccount آن را به MemoryAccount تبدیل می‌کند
 type Account struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
-	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"` // UUID کاربر
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`             // UUID کاربر
+	Policy        string                 `protobuf:"bytes,2,opt,name=policy,proto3" json:"policy,omitempty"`     // نام قانون سیاستی که برای کاربر اعمال می‌شود
+	Psk           string                 `protobuf:"bytes,3,opt,name=psk,proto3" json:"psk,omitempty"`           // کلید از پیش مشترک (base64)
+	Expiry        int64                  `protobuf:"varint,4,opt,name=expiry,proto3" json:"expiry,omitempty"`    // زمان انقضا (ثانیهٔ یونیکس)؛ صفر یعنی بدون انقضا
+	Quota         uint64                 `protobuf:"varint,5,opt,name=quota,proto3" json:"quota,omitempty"`      // سهمیهٔ ترافیک به بایت؛ صفر یعنی نامحدود
+	Morphing      string                 `protobuf:"bytes,6,opt,name=morphing,proto3" json:"morphing,omitempty"` // حالت morphing کاربر؛ خالی یعنی همان حالت inbound
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -117,12 +171,78 @@ func (x *Account) GetId() string {
 	return ""
 }
 
+func (x *Account) GetPolicy() string {
+	if x != nil {
+		return x.Policy
+	}
+	return ""
+}
+
+func (x *Account) GetPsk() string {
+	if x != nil {
+		return x.Psk
+	}
+	return ""
+}
+
+func (x *Account) GetExpiry() int64 {
+	if x != nil {
+		return x.Expiry
+	}
+	return 0
+}
+
+func (x *Account) GetQuota() uint64 {
+	if x != nil {
+		return x.Quota
+	}
+	return 0
+}
+
+func (x *Account) GetMorphing() string {
+	if x != nil {
+		return x.Morphing
+	}
+	return ""
+}
+
 type InboundConfig struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	Clients       []*User                `protobuf:"bytes,1,rep,name=clients,proto3" json:"clients,omitempty"`
-	Fallback      *Fallback              `protobuf:"bytes,2,opt,name=fallback,proto3" json:"fallback,omitempty"`
-	unknownFields protoimpl.UnknownFields
-	sizeCache     protoimpl.SizeCache
+	state               protoimpl.MessageState `protogen:"open.v1"`
+	Clients             []*User                `protobuf:"bytes,1,rep,name=clients,proto3" json:"clients,omitempty"`
+	Fallback            *Fallback              `protobuf:"bytes,2,opt,name=fallback,proto3" json:"fallback,omitempty"`
+	RetryCookie         bool                   `protobuf:"varint,3,opt,name=retry_cookie,json=retryCookie,proto3" json:"retry_cookie,omitempty"` // پیش از کار X25519، کوکی retry بخواه
+	ConcurrentLogin     *ConcurrentLogin       `protobuf:"bytes,4,opt,name=concurrent_login,json=concurrentLogin,proto3" json:"concurrent_login,omitempty"`
+	Policies            []*PolicyConfig        `protobuf:"bytes,5,rep,name=policies,proto3" json:"policies,omitempty"`
+	PolicyServer        *PolicyServer          `protobuf:"bytes,6,opt,name=policy_server,json=policyServer,proto3" json:"policy_server,omitempty"`
+	DefaultPolicy       string                 `protobuf:"bytes,7,opt,name=default_policy,json=defaultPolicy,proto3" json:"default_policy,omitempty"`    // قانونی که برای کلاینت بدون PolicyReq اعمال می‌شود
+	DenyByDefault       bool                   `protobuf:"varint,8,opt,name=deny_by_default,json=denyByDefault,proto3" json:"deny_by_default,omitempty"` // رد handshake با PolicyReq خالی، نامعتبر یا ناشناخته
+	DrainTimeout        uint32                 `protobuf:"varint,9,opt,name=drain_timeout,json=drainTimeout,proto3" json:"drain_timeout,omitempty"`      // مهلت (ثانیه) سشن‌ها پس از فریم CLOSE هنگام خاموشی؛ صفر یعنی پیش‌فرض
+	HandoffSocket       string                 `protobuf:"bytes,10,opt,name=handoff_socket,json=handoffSocket,proto3" json:"handoff_socket,omitempty"`   // مسیر سوکت یونیکس برای انتقال سشن‌ها به پروسهٔ جدید هنگام ری‌استارت
+	PortHopping         *PortHopping           `protobuf:"bytes,11,opt,name=port_hopping,json=portHopping,proto3" json:"port_hopping,omitempty"`
+	HealthPath          string                 `protobuf:"bytes,12,opt,name=health_path,json=healthPath,proto3" json:"health_path,omitempty"` // مسیر GET برای health check توسط load balancer، مثلاً "/healthz"؛ روی health_listen سرو می‌شود
+	StateFile           string                 `protobuf:"bytes,13,opt,name=state_file,json=stateFile,proto3" json:"state_file,omitempty"`    // فایل نگهداری کش replay و مسدودسازی ورود بین ری‌استارت‌ها؛ خالی یعنی غیرفعال
+	Limits              *ResourceLimits        `protobuf:"bytes,14,opt,name=limits,proto3" json:"limits,omitempty"`
+	Capture             *Capture               `protobuf:"bytes,15,opt,name=capture,proto3" json:"capture,omitempty"`
+	FeedbackPath        string                 `protobuf:"bytes,16,opt,name=feedback_path,json=feedbackPath,proto3" json:"feedback_path,omitempty"` // مسیر HTTP برای ثبت نظر طبقه‌بند خارجی (POST) و دیدن آمار هر پروفایل (GET)؛ باید حدس‌زدنی نباشد
+	CoverFronts         []*CoverFront          `protobuf:"bytes,17,rep,name=cover_fronts,json=coverFronts,proto3" json:"cover_fronts,omitempty"`    // هندشیک HTTP فقط به این دامنه‌ها و مسیرها پذیرفته می‌شود؛ خالی یعنی هر POST
+	Strategy            *WireStrategy          `protobuf:"bytes,18,opt,name=strategy,proto3" json:"strategy,omitempty"`
+	LeakageAudit        bool                   `protobuf:"varint,19,opt,name=leakage_audit,json=leakageAudit,proto3" json:"leakage_audit,omitempty"`   // اندازه‌گیری همبستگی اندازه و زمان‌بندی داده با ترافیک روی سیم در هر سشن
+	KeyLog              string                 `protobuf:"bytes,20,opt,name=key_log,json=keyLog,proto3" json:"key_log,omitempty"`                      // فایل ثبت کلید سشن‌ها به سبک SSLKEYLOGFILE برای رمزگشایی ضبط‌ها؛ فقط برای محیط آزمایش
+	TranscriptDir       string                 `protobuf:"bytes,21,opt,name=transcript_dir,json=transcriptDir,proto3" json:"transcript_dir,omitempty"` // پوشهٔ ثبت رونوشت بدون محتوای هر سشن (نوع، اندازه و زمان فریم‌ها) برای گزارش خطا
+	Pacing              bool                   `protobuf:"varint,22,opt,name=pacing,proto3" json:"pacing,omitempty"`                                   // اعمال فاصلهٔ بسته‌های morphing با زمان‌بندی ثابت و SO_MAX_PACING_RATE سوکت به‌جای sleep
+	VlessInbound        string                 `protobuf:"bytes,23,opt,name=vless_inbound,json=vlessInbound,proto3" json:"vless_inbound,omitempty"`    // تگ یک inbound از نوع VLESS؛ در دورهٔ مهاجرت کلاینت‌های VLESS همین پورت به آن سپرده می‌شوند
+	LogSampling         *LogSampling           `protobuf:"bytes,24,opt,name=log_sampling,json=logSampling,proto3" json:"log_sampling,omitempty"`
+	HandshakeIds        string                 `protobuf:"bytes,25,opt,name=handshake_ids,json=handshakeIds,proto3" json:"handshake_ids,omitempty"`                                                                                                // شناسهٔ کاربر در handshake: "static" (پیش‌فرض، UUID)، "both" یا "one-time" (کد چرخان از کلید کاربر و زمان)
+	ResumeRotation      uint32                 `protobuf:"varint,26,opt,name=resume_rotation,json=resumeRotation,proto3" json:"resume_rotation,omitempty"`                                                                                         // هر چند ثانیه کلید resume و bonding سشن‌ها عوض شود تا کلید دزدیده‌شده زود بی‌اعتبار شود؛ صفر یعنی هرگز
+	DestinationProfiles map[string]string      `protobuf:"bytes,27,rep,name=destination_profiles,json=destinationProfiles,proto3" json:"destination_profiles,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"` // دامنهٔ مقصد (با زیردامنه‌ها) به نام پروفایل؛ وقتی مقصد یک جریان از SNI یا Host معلوم شود با همان پروفایل شکل می‌گیرد، مثلاً googlevideo.com → youtube
+	UsageInterval       uint32                 `protobuf:"varint,28,opt,name=usage_interval,json=usageInterval,proto3" json:"usage_interval,omitempty"`                                                                                            // کلاینت‌هایی که ویژگی usage-reports را بخواهند حداکثر هر چند ثانیه مصرف و سهمیهٔ باقی‌مانده را در فریم USAGE می‌گیرند؛ صفر یعنی ۶۰
+	Morphing            string                 `protobuf:"bytes,29,opt,name=morphing,proto3" json:"morphing,omitempty"`                                                                                                                            // شکل‌دهی فریم‌های داده: "full" (پیش‌فرض، padding و تأخیر)، "padding-only" (بدون تأخیر) یا "off" (برای لینک‌های پرسرعت و مطمئن مثل سرور به سرور)
+	ServerKey           string                 `protobuf:"bytes,30,opt,name=server_key,json=serverKey,proto3" json:"server_key,omitempty"`                                                                                                         // کلید خصوصی هویت سرور (seed از نوع Ed25519، به base64)؛ هر handshake با آن امضا می‌شود تا کلاینتِ دارای کلید عمومی، سرور را احراز کند و MITM ممکن نباشد؛ handshakeهای مهروموم‌شده (RFXS) نیز با آن باز می‌شوند
+	HealthListen        string                 `protobuf:"bytes,31,opt,name=health_listen,json=healthListen,proto3" json:"health_listen,omitempty"`                                                                                                // آدرس شنود جداگانه برای health_path، مثلاً "127.0.0.1:9090"؛ وضعیت سرور روی پورت عمومی سرو نمی‌شود و health_path بدون آن پذیرفته نیست
+	RequireSealed       bool                   `protobuf:"varint,32,opt,name=require_sealed,json=requireSealed,proto3" json:"require_sealed,omitempty"`                                                                                            // فقط handshakeهای مهروموم‌شده پذیرفته شوند و هر handshake با شناسهٔ کاربر آشکار (magic، HTTP یا PSK) رد شود؛ به server_key نیاز دارد
+	Carriers            *Carriers              `protobuf:"bytes,33,opt,name=carriers,proto3" json:"carriers,omitempty"`
+	unknownFields       protoimpl.UnknownFields
+	sizeCache           protoimpl.SizeCache
 }
 
 func (x *InboundConfig) Reset() {
@@ -169,27 +289,246 @@ func (x *InboundConfig) GetFallback() *Fallback {
 	return nil
 }
 
-type Fallback struct {
+func (x *InboundConfig) GetRetryCookie() bool {
+	if x != nil {
+		return x.RetryCookie
+	}
+	return false
+}
+
+func (x *InboundConfig) GetConcurrentLogin() *ConcurrentLogin {
+	if x != nil {
+		return x.ConcurrentLogin
+	}
+	return nil
+}
+
+func (x *InboundConfig) GetPolicies() []*PolicyConfig {
+	if x != nil {
+		return x.Policies
+	}
+	return nil
+}
+
+func (x *InboundConfig) GetPolicyServer() *PolicyServer {
+	if x != nil {
+		return x.PolicyServer
+	}
+	return nil
+}
+
+func (x *InboundConfig) GetDefaultPolicy() string {
+	if x != nil {
+		return x.DefaultPolicy
+	}
+	return ""
+}
+
+func (x *InboundConfig) GetDenyByDefault() bool {
+	if x != nil {
+		return x.DenyByDefault
+	}
+	return false
+}
+
+func (x *InboundConfig) GetDrainTimeout() uint32 {
+	if x != nil {
+		return x.DrainTimeout
+	}
+	return 0
+}
+
+func (x *InboundConfig) GetHandoffSocket() string {
+	if x != nil {
+		return x.HandoffSocket
+	}
+	return ""
+}
+
+func (x *InboundConfig) GetPortHopping() *PortHopping {
+	if x != nil {
+		return x.PortHopping
+	}
+	return nil
+}
+
+func (x *InboundConfig) GetHealthPath() string {
+	if x != nil {
+		return x.HealthPath
+	}
+	return ""
+}
+
+func (x *InboundConfig) GetStateFile() string {
+	if x != nil {
+		return x.StateFile
+	}
+	return ""
+}
+
+func (x *InboundConfig) GetLimits() *ResourceLimits {
+	if x != nil {
+		return x.Limits
+	}
+	return nil
+}
+
+func (x *InboundConfig) GetCapture() *Capture {
+	if x != nil {
+		return x.Capture
+	}
+	return nil
+}
+
+func (x *InboundConfig) GetFeedbackPath() string {
+	if x != nil {
+		return x.FeedbackPath
+	}
+	return ""
+}
+
+func (x *InboundConfig) GetCoverFronts() []*CoverFront {
+	if x != nil {
+		return x.CoverFronts
+	}
+	return nil
+}
+
+func (x *InboundConfig) GetStrategy() *WireStrategy {
+	if x != nil {
+		return x.Strategy
+	}
+	return nil
+}
+
+func (x *InboundConfig) GetLeakageAudit() bool {
+	if x != nil {
+		return x.LeakageAudit
+	}
+	return false
+}
+
+func (x *InboundConfig) GetKeyLog() string {
+	if x != nil {
+		return x.KeyLog
+	}
+	return ""
+}
+
+func (x *InboundConfig) GetTranscriptDir() string {
+	if x != nil {
+		return x.TranscriptDir
+	}
+	return ""
+}
+
+func (x *InboundConfig) GetPacing() bool {
+	if x != nil {
+		return x.Pacing
+	}
+	return false
+}
+
+func (x *InboundConfig) GetVlessInbound() string {
+	if x != nil {
+		return x.VlessInbound
+	}
+	return ""
+}
+
+func (x *InboundConfig) GetLogSampling() *LogSampling {
+	if x != nil {
+		return x.LogSampling
+	}
+	return nil
+}
+
+func (x *InboundConfig) GetHandshakeIds() string {
+	if x != nil {
+		return x.HandshakeIds
+	}
+	return ""
+}
+
+func (x *InboundConfig) GetResumeRotation() uint32 {
+	if x != nil {
+		return x.ResumeRotation
+	}
+	return 0
+}
+
+func (x *InboundConfig) GetDestinationProfiles() map[string]string {
+	if x != nil {
+		return x.DestinationProfiles
+	}
+	return nil
+}
+
+func (x *InboundConfig) GetUsageInterval() uint32 {
+	if x != nil {
+		return x.UsageInterval
+	}
+	return 0
+}
+
+func (x *InboundConfig) GetMorphing() string {
+	if x != nil {
+		return x.Morphing
+	}
+	return ""
+}
+
+func (x *InboundConfig) GetServerKey() string {
+	if x != nil {
+		return x.ServerKey
+	}
+	return ""
+}
+
+func (x *InboundConfig) GetHealthListen() string {
+	if x != nil {
+		return x.HealthListen
+	}
+	return ""
+}
+
+func (x *InboundConfig) GetRequireSealed() bool {
+	if x != nil {
+		return x.RequireSealed
+	}
+	return false
+}
+
+func (x *InboundConfig) GetCarriers() *Carriers {
+	if x != nil {
+		return x.Carriers
+	}
+	return nil
+}
+
+// حامل‌های جایگزین TCP برای شبکه‌هایی که جریان‌های TCP طولانی را کند می‌کنند ولی UDP می‌گذرد؛ inbound خودش روی آن‌ها گوش می‌دهد
+type Carriers struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
-	Dest          uint32                 `protobuf:"varint,1,opt,name=dest,proto3" json:"dest,omitempty"` // پورت مقصد fallback (مثلاً 80)
+	Quic          *QUICCarrier           `protobuf:"bytes,1,opt,name=quic,proto3" json:"quic,omitempty"`
+	Udp           *UDPCarrier            `protobuf:"bytes,2,opt,name=udp,proto3" json:"udp,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *Fallback) Reset() {
-	*x = Fallback{}
+func (x *Carriers) Reset() {
+	*x = Carriers{}
 	mi := &file_proxy_reflex_config_proto_msgTypes[3]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *Fallback) String() string {
+func (x *Carriers) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*Fallback) ProtoMessage() {}
+func (*Carriers) ProtoMessage() {}
 
-func (x *Fallback) ProtoReflect() protoreflect.Message {
+func (x *Carriers) ProtoReflect() protoreflect.Message {
 	mi := &file_proxy_reflex_config_proto_msgTypes[3]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -201,41 +540,49 @@ func (x *Fallback) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use Fallback.ProtoReflect.Descriptor instead.
-func (*Fallback) Descriptor() ([]byte, []int) {
+// Deprecated: Use Carriers.ProtoReflect.Descriptor instead.
+func (*Carriers) Descriptor() ([]byte, []int) {
 	return file_proxy_reflex_config_proto_rawDescGZIP(), []int{3}
 }
 
-func (x *Fallback) GetDest() uint32 {
+func (x *Carriers) GetQuic() *QUICCarrier {
 	if x != nil {
-		return x.Dest
+		return x.Quic
 	}
-	return 0
+	return nil
 }
 
-type OutboundConfig struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	Address       string                 `protobuf:"bytes,1,opt,name=address,proto3" json:"address,omitempty"`
-	Port          uint32                 `protobuf:"varint,2,opt,name=port,proto3" json:"port,omitempty"`
-	Id            string                 `protobuf:"bytes,3,opt,name=id,proto3" json:"id,omitempty"` // UUID کلاینت
-	unknownFields protoimpl.UnknownFields
-	sizeCache     protoimpl.SizeCache
+func (x *Carriers) GetUdp() *UDPCarrier {
+	if x != nil {
+		return x.Udp
+	}
+	return nil
 }
 
-func (x *OutboundConfig) Reset() {
-	*x = OutboundConfig{}
+// هر اتصال Reflex یک stream از اتصال QUIC مشترک کلاینت است
+type QUICCarrier struct {
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	Listen          string                 `protobuf:"bytes,1,opt,name=listen,proto3" json:"listen,omitempty"`                                          // آدرس UDP، مثلاً "0.0.0.0:443"
+	CertificateFile string                 `protobuf:"bytes,2,opt,name=certificate_file,json=certificateFile,proto3" json:"certificate_file,omitempty"` // گواهی TLS به PEM؛ ALPN پیش‌فرض "h3" است
+	KeyFile         string                 `protobuf:"bytes,3,opt,name=key_file,json=keyFile,proto3" json:"key_file,omitempty"`
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *QUICCarrier) Reset() {
+	*x = QUICCarrier{}
 	mi := &file_proxy_reflex_config_proto_msgTypes[4]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *OutboundConfig) String() string {
+func (x *QUICCarrier) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*OutboundConfig) ProtoMessage() {}
+func (*QUICCarrier) ProtoMessage() {}
 
-func (x *OutboundConfig) ProtoReflect() protoreflect.Message {
+func (x *QUICCarrier) ProtoReflect() protoreflect.Message {
 	mi := &file_proxy_reflex_config_proto_msgTypes[4]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -247,51 +594,1221 @@ func (x *OutboundConfig) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use OutboundConfig.ProtoReflect.Descriptor instead.
-func (*OutboundConfig) Descriptor() ([]byte, []int) {
+// Deprecated: Use QUICCarrier.ProtoReflect.Descriptor instead.
+func (*QUICCarrier) Descriptor() ([]byte, []int) {
 	return file_proxy_reflex_config_proto_rawDescGZIP(), []int{4}
 }
 
-func (x *OutboundConfig) GetAddress() string {
+func (x *QUICCarrier) GetListen() string {
 	if x != nil {
-		return x.Address
+		return x.Listen
 	}
 	return ""
 }
 
-func (x *OutboundConfig) GetPort() uint32 {
+func (x *QUICCarrier) GetCertificateFile() string {
 	if x != nil {
-		return x.Port
+		return x.CertificateFile
 	}
-	return 0
+	return ""
 }
 
-func (x *OutboundConfig) GetId() string {
+func (x *QUICCarrier) GetKeyFile() string {
 	if x != nil {
-		return x.Id
+		return x.KeyFile
 	}
 	return ""
 }
 
-var File_proxy_reflex_config_proto protoreflect.FileDescriptor
+// هر اتصال Reflex یک جریان دیتاگرام با تصحیح خطای پیشرو (FEC) است؛ نشانی مبدأ پیش از ساخت هر وضعیتی با کوکی بررسی می‌شود
+type UDPCarrier struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Listen        string                 `protobuf:"bytes,1,opt,name=listen,proto3" json:"listen,omitempty"`                         // آدرس UDP، مثلاً "0.0.0.0:443"
+	FecData       uint32                 `protobuf:"varint,2,opt,name=fec_data,json=fecData,proto3" json:"fec_data,omitempty"`       // شمار دیتاگرام‌های داده در هر گروه FEC؛ 0 یعنی پیش‌فرض (۸ داده و ۲ توازن)
+	FecParity     uint32                 `protobuf:"varint,3,opt,name=fec_parity,json=fecParity,proto3" json:"fec_parity,omitempty"` // شمار دیتاگرام‌های توازن در هر گروه؛ 0 یعنی بدون توازن
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
 
-const file_proxy_reflex_config_proto_rawDesc = "" +
-	"\n" +
-	"\x19proxy/reflex/config.proto\x12\freflex.proxy\".\n" +
-	"\x04User\x12\x0e\n" +
-	"\x02id\x18\x01 \x01(\tR\x02id\x12\x16\n" +
-	"\x06policy\x18\x02 \x01(\tR\x06policy\"\x19\n" +
+func (x *UDPCarrier) Reset() {
+	*x = UDPCarrier{}
+	mi := &file_proxy_reflex_config_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UDPCarrier) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UDPCarrier) ProtoMessage() {}
+
+func (x *UDPCarrier) ProtoReflect() protoreflect.Message {
+	mi := &file_proxy_reflex_config_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UDPCarrier.ProtoReflect.Descriptor instead.
+func (*UDPCarrier) Descriptor() ([]byte, []int) {
+	return file_proxy_reflex_config_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *UDPCarrier) GetListen() string {
+	if x != nil {
+		return x.Listen
+	}
+	return ""
+}
+
+func (x *UDPCarrier) GetFecData() uint32 {
+	if x != nil {
+		return x.FecData
+	}
+	return 0
+}
+
+func (x *UDPCarrier) GetFecParity() uint32 {
+	if x != nil {
+		return x.FecParity
+	}
+	return 0
+}
+
+// محدودیت لاگ رویدادهای پرتکرار (replay، probe، انتخاب پروفایل)؛ بقیه فقط شمرده می‌شوند
+type LogSampling struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Burst         uint32                 `protobuf:"varint,1,opt,name=burst,proto3" json:"burst,omitempty"`       // تعداد رویدادهای لاگ‌شده از هر نوع در هر بازه، 0 یعنی پیش‌فرض
+	Interval      uint32                 `protobuf:"varint,2,opt,name=interval,proto3" json:"interval,omitempty"` // طول بازه به ثانیه، 0 یعنی پیش‌فرض
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LogSampling) Reset() {
+	*x = LogSampling{}
+	mi := &file_proxy_reflex_config_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LogSampling) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LogSampling) ProtoMessage() {}
+
+func (x *LogSampling) ProtoReflect() protoreflect.Message {
+	mi := &file_proxy_reflex_config_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LogSampling.ProtoReflect.Descriptor instead.
+func (*LogSampling) Descriptor() ([]byte, []int) {
+	return file_proxy_reflex_config_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *LogSampling) GetBurst() uint32 {
+	if x != nil {
+		return x.Burst
+	}
+	return 0
+}
+
+func (x *LogSampling) GetInterval() uint32 {
+	if x != nil {
+		return x.Interval
+	}
+	return 0
+}
+
+// سرویس gRPC خارجی برای تصمیم‌گیری سیاست
+type PolicyServer struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Address       string                 `protobuf:"bytes,1,opt,name=address,proto3" json:"address,omitempty"`                    // مثلاً "127.0.0.1:10086"
+	Timeout       uint32                 `protobuf:"varint,2,opt,name=timeout,proto3" json:"timeout,omitempty"`                   // میلی‌ثانیه، 0 یعنی پیش‌فرض
+	CacheTtl      uint32                 `protobuf:"varint,3,opt,name=cache_ttl,json=cacheTtl,proto3" json:"cache_ttl,omitempty"` // ثانیه، 0 یعنی پیش‌فرض
+	FailOpen      bool                   `protobuf:"varint,4,opt,name=fail_open,json=failOpen,proto3" json:"fail_open,omitempty"` // در صورت در دسترس نبودن سرویس از قوانین محلی استفاده شود
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PolicyServer) Reset() {
+	*x = PolicyServer{}
+	mi := &file_proxy_reflex_config_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PolicyServer) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PolicyServer) ProtoMessage() {}
+
+func (x *PolicyServer) ProtoReflect() protoreflect.Message {
+	mi := &file_proxy_reflex_config_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PolicyServer.ProtoReflect.Descriptor instead.
+func (*PolicyServer) Descriptor() ([]byte, []int) {
+	return file_proxy_reflex_config_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *PolicyServer) GetAddress() string {
+	if x != nil {
+		return x.Address
+	}
+	return ""
+}
+
+func (x *PolicyServer) GetTimeout() uint32 {
+	if x != nil {
+		return x.Timeout
+	}
+	return 0
+}
+
+func (x *PolicyServer) GetCacheTtl() uint32 {
+	if x != nil {
+		return x.CacheTtl
+	}
+	return 0
+}
+
+func (x *PolicyServer) GetFailOpen() bool {
+	if x != nil {
+		return x.FailOpen
+	}
+	return false
+}
+
+// قانون سیاست: بر اساس نام سیاست کاربر یا سطح او انتخاب می‌شود
+type PolicyConfig struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`                                      // با فیلد policy کاربر تطبیق داده می‌شود
+	Level         uint32                 `protobuf:"varint,2,opt,name=level,proto3" json:"level,omitempty"`                                   // برای کاربرانی که قانون نام‌دار ندارند
+	Profiles      []string               `protobuf:"bytes,3,rep,name=profiles,proto3" json:"profiles,omitempty"`                              // پروفایل‌های مجاز؛ اولی پیش‌فرض است
+	MaxBandwidth  uint64                 `protobuf:"varint,4,opt,name=max_bandwidth,json=maxBandwidth,proto3" json:"max_bandwidth,omitempty"` // بایت بر ثانیه، 0 یعنی نامحدود
+	Features      []string               `protobuf:"bytes,5,rep,name=features,proto3" json:"features,omitempty"`
+	Destinations  []string               `protobuf:"bytes,6,rep,name=destinations,proto3" json:"destinations,omitempty"`                       // میزبان، دامنه یا CIDR با پورت اختیاری
+	SessionTtl    uint32                 `protobuf:"varint,7,opt,name=session_ttl,json=sessionTtl,proto3" json:"session_ttl,omitempty"`        // حداکثر عمر نشست به ثانیه، 0 یعنی نامحدود
+	Switches      []*ProfileSwitchConfig `protobuf:"bytes,8,rep,name=switches,proto3" json:"switches,omitempty"`                               // برنامه تعویض پروفایل در طول نشست
+	Tier          string                 `protobuf:"bytes,9,opt,name=tier,proto3" json:"tier,omitempty"`                                       // سطح سرویس (مثلاً "premium") برای قوانین مسیریابی
+	Tags          []string               `protobuf:"bytes,10,rep,name=tags,proto3" json:"tags,omitempty"`                                      // برچسب‌های نشست برای قوانین مسیریابی
+	WriteTimeout  uint32                 `protobuf:"varint,11,opt,name=write_timeout,json=writeTimeout,proto3" json:"write_timeout,omitempty"` // حداکثر زمان نوشتن هر فریم به ثانیه تا کلاینتِ متوقف بافر سرور را نگه ندارد؛ 0 یعنی پیش‌فرض
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PolicyConfig) Reset() {
+	*x = PolicyConfig{}
+	mi := &file_proxy_reflex_config_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PolicyConfig) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PolicyConfig) ProtoMessage() {}
+
+func (x *PolicyConfig) ProtoReflect() protoreflect.Message {
+	mi := &file_proxy_reflex_config_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PolicyConfig.ProtoReflect.Descriptor instead.
+func (*PolicyConfig) Descriptor() ([]byte, []int) {
+	return file_proxy_reflex_config_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *PolicyConfig) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *PolicyConfig) GetLevel() uint32 {
+	if x != nil {
+		return x.Level
+	}
+	return 0
+}
+
+func (x *PolicyConfig) GetProfiles() []string {
+	if x != nil {
+		return x.Profiles
+	}
+	return nil
+}
+
+func (x *PolicyConfig) GetMaxBandwidth() uint64 {
+	if x != nil {
+		return x.MaxBandwidth
+	}
+	return 0
+}
+
+func (x *PolicyConfig) GetFeatures() []string {
+	if x != nil {
+		return x.Features
+	}
+	return nil
+}
+
+func (x *PolicyConfig) GetDestinations() []string {
+	if x != nil {
+		return x.Destinations
+	}
+	return nil
+}
+
+func (x *PolicyConfig) GetSessionTtl() uint32 {
+	if x != nil {
+		return x.SessionTtl
+	}
+	return 0
+}
+
+func (x *PolicyConfig) GetSwitches() []*ProfileSwitchConfig {
+	if x != nil {
+		return x.Switches
+	}
+	return nil
+}
+
+func (x *PolicyConfig) GetTier() string {
+	if x != nil {
+		return x.Tier
+	}
+	return ""
+}
+
+func (x *PolicyConfig) GetTags() []string {
+	if x != nil {
+		return x.Tags
+	}
+	return nil
+}
+
+func (x *PolicyConfig) GetWriteTimeout() uint32 {
+	if x != nil {
+		return x.WriteTimeout
+	}
+	return 0
+}
+
+type ProfileSwitchConfig struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Profile       string                 `protobuf:"bytes,1,opt,name=profile,proto3" json:"profile,omitempty"`
+	AfterBytes    uint64                 `protobuf:"varint,2,opt,name=after_bytes,json=afterBytes,proto3" json:"after_bytes,omitempty"`       // پس از این تعداد بایت
+	AfterSeconds  uint32                 `protobuf:"varint,3,opt,name=after_seconds,json=afterSeconds,proto3" json:"after_seconds,omitempty"` // پس از این مدت به ثانیه
+	OnThrottle    bool                   `protobuf:"varint,4,opt,name=on_throttle,json=onThrottle,proto3" json:"on_throttle,omitempty"`       // هنگام تشخیص محدودسازی
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ProfileSwitchConfig) Reset() {
+	*x = ProfileSwitchConfig{}
+	mi := &file_proxy_reflex_config_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ProfileSwitchConfig) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ProfileSwitchConfig) ProtoMessage() {}
+
+func (x *ProfileSwitchConfig) ProtoReflect() protoreflect.Message {
+	mi := &file_proxy_reflex_config_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ProfileSwitchConfig.ProtoReflect.Descriptor instead.
+func (*ProfileSwitchConfig) Descriptor() ([]byte, []int) {
+	return file_proxy_reflex_config_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *ProfileSwitchConfig) GetProfile() string {
+	if x != nil {
+		return x.Profile
+	}
+	return ""
+}
+
+func (x *ProfileSwitchConfig) GetAfterBytes() uint64 {
+	if x != nil {
+		return x.AfterBytes
+	}
+	return 0
+}
+
+func (x *ProfileSwitchConfig) GetAfterSeconds() uint32 {
+	if x != nil {
+		return x.AfterSeconds
+	}
+	return 0
+}
+
+func (x *ProfileSwitchConfig) GetOnThrottle() bool {
+	if x != nil {
+		return x.OnThrottle
+	}
+	return false
+}
+
+type ConcurrentLogin struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	MaxSources    uint32                 `protobuf:"varint,1,opt,name=max_sources,json=maxSources,proto3" json:"max_sources,omitempty"` // حداکثر تعداد IP مبدأ متمایز برای هر کاربر در پنجره
+	Window        uint32                 `protobuf:"varint,2,opt,name=window,proto3" json:"window,omitempty"`                           // طول پنجره به ثانیه
+	Action        string                 `protobuf:"bytes,3,opt,name=action,proto3" json:"action,omitempty"`                            // "allow"، "alert"، "limit" یا "block"
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ConcurrentLogin) Reset() {
+	*x = ConcurrentLogin{}
+	mi := &file_proxy_reflex_config_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ConcurrentLogin) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ConcurrentLogin) ProtoMessage() {}
+
+func (x *ConcurrentLogin) ProtoReflect() protoreflect.Message {
+	mi := &file_proxy_reflex_config_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ConcurrentLogin.ProtoReflect.Descriptor instead.
+func (*ConcurrentLogin) Descriptor() ([]byte, []int) {
+	return file_proxy_reflex_config_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *ConcurrentLogin) GetMaxSources() uint32 {
+	if x != nil {
+		return x.MaxSources
+	}
+	return 0
+}
+
+func (x *ConcurrentLogin) GetWindow() uint32 {
+	if x != nil {
+		return x.Window
+	}
+	return 0
+}
+
+func (x *ConcurrentLogin) GetAction() string {
+	if x != nil {
+		return x.Action
+	}
+	return ""
+}
+
+type Fallback struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Dest          uint32                 `protobuf:"varint,1,opt,name=dest,proto3" json:"dest,omitempty"`      // پورت مقصد fallback (مثلاً 80)
+	Decoy         *Decoy                 `protobuf:"bytes,2,opt,name=decoy,proto3" json:"decoy,omitempty"`     // اگر تنظیم شود، خود inbound سایت پوششی را روی address:dest سرو می‌کند
+	Address       string                 `protobuf:"bytes,3,opt,name=address,proto3" json:"address,omitempty"` // میزبان fallback، مثلاً "::1" یا "[::1]"؛ خالی یعنی 127.0.0.1
+	Jitter        uint32                 `protobuf:"varint,4,opt,name=jitter,proto3" json:"jitter,omitempty"`  // بیشترین تأخیر تصادفی، به میلی‌ثانیه، پیش از رله‌کردن هر تکه از پاسخ fallback؛ صفر یعنی بدون شکل‌دهی
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Fallback) Reset() {
+	*x = Fallback{}
+	mi := &file_proxy_reflex_config_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Fallback) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Fallback) ProtoMessage() {}
+
+func (x *Fallback) ProtoReflect() protoreflect.Message {
+	mi := &file_proxy_reflex_config_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Fallback.ProtoReflect.Descriptor instead.
+func (*Fallback) Descriptor() ([]byte, []int) {
+	return file_proxy_reflex_config_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *Fallback) GetDest() uint32 {
+	if x != nil {
+		return x.Dest
+	}
+	return 0
+}
+
+func (x *Fallback) GetDecoy() *Decoy {
+	if x != nil {
+		return x.Decoy
+	}
+	return nil
+}
+
+func (x *Fallback) GetAddress() string {
+	if x != nil {
+		return x.Address
+	}
+	return ""
+}
+
+func (x *Fallback) GetJitter() uint32 {
+	if x != nil {
+		return x.Jitter
+	}
+	return 0
+}
+
+// دامنه و مسیری که کلاینت هندشیک HTTP را به آن می‌فرستد
+type CoverFront struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Host          string                 `protobuf:"bytes,1,opt,name=host,proto3" json:"host,omitempty"` // مقدار هدر Host، مثلاً "cdn.example.com"
+	Path          string                 `protobuf:"bytes,2,opt,name=path,proto3" json:"path,omitempty"` // مثلاً "/api/v1/upload"
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CoverFront) Reset() {
+	*x = CoverFront{}
+	mi := &file_proxy_reflex_config_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CoverFront) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CoverFront) ProtoMessage() {}
+
+func (x *CoverFront) ProtoReflect() protoreflect.Message {
+	mi := &file_proxy_reflex_config_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CoverFront.ProtoReflect.Descriptor instead.
+func (*CoverFront) Descriptor() ([]byte, []int) {
+	return file_proxy_reflex_config_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *CoverFront) GetHost() string {
+	if x != nil {
+		return x.Host
+	}
+	return ""
+}
+
+func (x *CoverFront) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+// سایت پوششی که از یک سایت واقعی و بی‌خطر ساخته می‌شود
+type Decoy struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Origin        string                 `protobuf:"bytes,1,opt,name=origin,proto3" json:"origin,omitempty"`                      // مثلاً "https://example.org"
+	Mode          string                 `protobuf:"bytes,2,opt,name=mode,proto3" json:"mode,omitempty"`                          // "proxy" (پیش‌فرض، دریافت زنده با کش) یا "mirror" (کپی کامل سایت از قبل)
+	CacheTtl      uint32                 `protobuf:"varint,3,opt,name=cache_ttl,json=cacheTtl,proto3" json:"cache_ttl,omitempty"` // ثانیه، در حالت proxy؛ 0 یعنی پیش‌فرض
+	Refresh       uint32                 `protobuf:"varint,4,opt,name=refresh,proto3" json:"refresh,omitempty"`                   // ثانیه بین دو بار کپی سایت در حالت mirror؛ 0 یعنی پیش‌فرض
+	MaxPages      uint32                 `protobuf:"varint,5,opt,name=max_pages,json=maxPages,proto3" json:"max_pages,omitempty"` // حداکثر صفحات نگهداری‌شده؛ 0 یعنی پیش‌فرض
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Decoy) Reset() {
+	*x = Decoy{}
+	mi := &file_proxy_reflex_config_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Decoy) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Decoy) ProtoMessage() {}
+
+func (x *Decoy) ProtoReflect() protoreflect.Message {
+	mi := &file_proxy_reflex_config_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Decoy.ProtoReflect.Descriptor instead.
+func (*Decoy) Descriptor() ([]byte, []int) {
+	return file_proxy_reflex_config_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *Decoy) GetOrigin() string {
+	if x != nil {
+		return x.Origin
+	}
+	return ""
+}
+
+func (x *Decoy) GetMode() string {
+	if x != nil {
+		return x.Mode
+	}
+	return ""
+}
+
+func (x *Decoy) GetCacheTtl() uint32 {
+	if x != nil {
+		return x.CacheTtl
+	}
+	return 0
+}
+
+func (x *Decoy) GetRefresh() uint32 {
+	if x != nil {
+		return x.Refresh
+	}
+	return 0
+}
+
+func (x *Decoy) GetMaxPages() uint32 {
+	if x != nil {
+		return x.MaxPages
+	}
+	return 0
+}
+
+type OutboundConfig struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Address       string                 `protobuf:"bytes,1,opt,name=address,proto3" json:"address,omitempty"`
+	Port          uint32                 `protobuf:"varint,2,opt,name=port,proto3" json:"port,omitempty"`
+	Id            string                 `protobuf:"bytes,3,opt,name=id,proto3" json:"id,omitempty"`                                      // UUID کلاینت
+	Morphing      string                 `protobuf:"bytes,4,opt,name=morphing,proto3" json:"morphing,omitempty"`                          // شکل‌دهی فریم‌های داده‌ای که کلاینت می‌فرستد: "full"، "padding-only" یا "off"
+	Mux           bool                   `protobuf:"varint,5,opt,name=mux,proto3" json:"mux,omitempty"`                                   // همهٔ اتصال‌ها به‌صورت stream روی یک نشست مشترک می‌روند، بدون handshake جدا برای هر مقصد
+	ServerKey     string                 `protobuf:"bytes,6,opt,name=server_key,json=serverKey,proto3" json:"server_key,omitempty"`       // کلید عمومی هویت سرور (Ed25519، به base64)؛ اگر تنظیم شود handshake برای آن مهروموم می‌شود تا شناسهٔ کاربر آشکار فرستاده نشود، و پاسخ بدون امضای معتبر با آن رد می‌شود
+	Psk           string                 `protobuf:"bytes,7,opt,name=psk,proto3" json:"psk,omitempty"`                                    // کلید از پیش مشترک کاربر (base64)، اگر روی سرور برایش تنظیم شده باشد؛ پاسخ چالش‌های سرور و شناسهٔ یک‌بارمصرف از آن ساخته می‌شوند
+	OneTime       bool                   `protobuf:"varint,8,opt,name=one_time,json=oneTime,proto3" json:"one_time,omitempty"`            // به‌جای UUID، شناسهٔ یک‌بارمصرف (کد چرخان از کلید کاربر و زمان) فرستاده می‌شود؛ handshake_ids سرور باید "both" یا "one-time" باشد
+	PortHopping   *PortHopping           `protobuf:"bytes,9,opt,name=port_hopping,json=portHopping,proto3" json:"port_hopping,omitempty"` // همان secret و بازهٔ inbound سرور؛ هر اتصال به پورت فعال همان لحظه زده می‌شود و port نادیده گرفته می‌شود
+	Carrier       string                 `protobuf:"bytes,10,opt,name=carrier,proto3" json:"carrier,omitempty"`                           // "tcp" (پیش‌فرض)، "quic" یا "udp"؛ حامل باید روی سرور در carriers فعال باشد و port پورت UDP آن است. حامل‌ها مستقیم شماره‌گیری می‌شوند، نه از راه proxySettings
+	ServerName    string                 `protobuf:"bytes,11,opt,name=server_name,json=serverName,proto3" json:"server_name,omitempty"`   // SNI گواهی حامل QUIC، پیش‌فرض address؛ با server_key گواهی بررسی نمی‌شود چون خود handshake سرور را احراز می‌کند
+	FecData       uint32                 `protobuf:"varint,12,opt,name=fec_data,json=fecData,proto3" json:"fec_data,omitempty"`           // برای حامل udp، همانند fec_data سرور
+	FecParity     uint32                 `protobuf:"varint,13,opt,name=fec_parity,json=fecParity,proto3" json:"fec_parity,omitempty"`     // برای حامل udp، همانند fec_parity سرور
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *OutboundConfig) Reset() {
+	*x = OutboundConfig{}
+	mi := &file_proxy_reflex_config_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *OutboundConfig) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*OutboundConfig) ProtoMessage() {}
+
+func (x *OutboundConfig) ProtoReflect() protoreflect.Message {
+	mi := &file_proxy_reflex_config_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use OutboundConfig.ProtoReflect.Descriptor instead.
+func (*OutboundConfig) Descriptor() ([]byte, []int) {
+	return file_proxy_reflex_config_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *OutboundConfig) GetAddress() string {
+	if x != nil {
+		return x.Address
+	}
+	return ""
+}
+
+func (x *OutboundConfig) GetPort() uint32 {
+	if x != nil {
+		return x.Port
+	}
+	return 0
+}
+
+func (x *OutboundConfig) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *OutboundConfig) GetMorphing() string {
+	if x != nil {
+		return x.Morphing
+	}
+	return ""
+}
+
+func (x *OutboundConfig) GetMux() bool {
+	if x != nil {
+		return x.Mux
+	}
+	return false
+}
+
+func (x *OutboundConfig) GetServerKey() string {
+	if x != nil {
+		return x.ServerKey
+	}
+	return ""
+}
+
+func (x *OutboundConfig) GetPsk() string {
+	if x != nil {
+		return x.Psk
+	}
+	return ""
+}
+
+func (x *OutboundConfig) GetOneTime() bool {
+	if x != nil {
+		return x.OneTime
+	}
+	return false
+}
+
+func (x *OutboundConfig) GetPortHopping() *PortHopping {
+	if x != nil {
+		return x.PortHopping
+	}
+	return nil
+}
+
+func (x *OutboundConfig) GetCarrier() string {
+	if x != nil {
+		return x.Carrier
+	}
+	return ""
+}
+
+func (x *OutboundConfig) GetServerName() string {
+	if x != nil {
+		return x.ServerName
+	}
+	return ""
+}
+
+func (x *OutboundConfig) GetFecData() uint32 {
+	if x != nil {
+		return x.FecData
+	}
+	return 0
+}
+
+func (x *OutboundConfig) GetFecParity() uint32 {
+	if x != nil {
+		return x.FecParity
+	}
+	return 0
+}
+
+// پورت فعال از روی secret مشترک و زمان محاسبه می‌شود؛ inbound باید روی کل بازه گوش دهد
+type PortHopping struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Secret        string                 `protobuf:"bytes,1,opt,name=secret,proto3" json:"secret,omitempty"`
+	BasePort      uint32                 `protobuf:"varint,2,opt,name=base_port,json=basePort,proto3" json:"base_port,omitempty"`
+	PortCount     uint32                 `protobuf:"varint,3,opt,name=port_count,json=portCount,proto3" json:"port_count,omitempty"`
+	Interval      uint32                 `protobuf:"varint,4,opt,name=interval,proto3" json:"interval,omitempty"` // ثانیه، 0 یعنی پیش‌فرض
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PortHopping) Reset() {
+	*x = PortHopping{}
+	mi := &file_proxy_reflex_config_proto_msgTypes[15]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PortHopping) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PortHopping) ProtoMessage() {}
+
+func (x *PortHopping) ProtoReflect() protoreflect.Message {
+	mi := &file_proxy_reflex_config_proto_msgTypes[15]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PortHopping.ProtoReflect.Descriptor instead.
+func (*PortHopping) Descriptor() ([]byte, []int) {
+	return file_proxy_reflex_config_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *PortHopping) GetSecret() string {
+	if x != nil {
+		return x.Secret
+	}
+	return ""
+}
+
+func (x *PortHopping) GetBasePort() uint32 {
+	if x != nil {
+		return x.BasePort
+	}
+	return 0
+}
+
+func (x *PortHopping) GetPortCount() uint32 {
+	if x != nil {
+		return x.PortCount
+	}
+	return 0
+}
+
+func (x *PortHopping) GetInterval() uint32 {
+	if x != nil {
+		return x.Interval
+	}
+	return 0
+}
+
+// سقف منابع کل inbound تا گره زیر حمله به‌شکل قابل پیش‌بینی افت کند
+type ResourceLimits struct {
+	state               protoimpl.MessageState `protogen:"open.v1"`
+	MaxSessions         uint32                 `protobuf:"varint,1,opt,name=max_sessions,json=maxSessions,proto3" json:"max_sessions,omitempty"`                           // حداکثر اتصال‌های هم‌زمان، 0 یعنی نامحدود
+	HandshakesPerSecond uint32                 `protobuf:"varint,2,opt,name=handshakes_per_second,json=handshakesPerSecond,proto3" json:"handshakes_per_second,omitempty"` // 0 یعنی نامحدود
+	MaxBufferedBytes    uint64                 `protobuf:"varint,3,opt,name=max_buffered_bytes,json=maxBufferedBytes,proto3" json:"max_buffered_bytes,omitempty"`          // حافظهٔ فریم‌های در حال پردازش، 0 یعنی نامحدود
+	Action              string                 `protobuf:"bytes,4,opt,name=action,proto3" json:"action,omitempty"`                                                         // "close" (پیش‌فرض)، "fallback" یا "queue"
+	unknownFields       protoimpl.UnknownFields
+	sizeCache           protoimpl.SizeCache
+}
+
+func (x *ResourceLimits) Reset() {
+	*x = ResourceLimits{}
+	mi := &file_proxy_reflex_config_proto_msgTypes[16]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ResourceLimits) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ResourceLimits) ProtoMessage() {}
+
+func (x *ResourceLimits) ProtoReflect() protoreflect.Message {
+	mi := &file_proxy_reflex_config_proto_msgTypes[16]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ResourceLimits.ProtoReflect.Descriptor instead.
+func (*ResourceLimits) Descriptor() ([]byte, []int) {
+	return file_proxy_reflex_config_proto_rawDescGZIP(), []int{16}
+}
+
+func (x *ResourceLimits) GetMaxSessions() uint32 {
+	if x != nil {
+		return x.MaxSessions
+	}
+	return 0
+}
+
+func (x *ResourceLimits) GetHandshakesPerSecond() uint32 {
+	if x != nil {
+		return x.HandshakesPerSecond
+	}
+	return 0
+}
+
+func (x *ResourceLimits) GetMaxBufferedBytes() uint64 {
+	if x != nil {
+		return x.MaxBufferedBytes
+	}
+	return 0
+}
+
+func (x *ResourceLimits) GetAction() string {
+	if x != nil {
+		return x.Action
+	}
+	return ""
+}
+
+// افزونهٔ دست‌کاری قطعه‌بندی و زمان‌بندی نوشتن و خواندن روی سیم (به سبک Geneva)
+type WireStrategy struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`                                                                           // نام ثبت‌شده با RegisterStrategy، مثلاً "split" یا "delay"
+	Args          map[string]string      `protobuf:"bytes,2,rep,name=args,proto3" json:"args,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"` // آرگومان‌های استراتژی
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WireStrategy) Reset() {
+	*x = WireStrategy{}
+	mi := &file_proxy_reflex_config_proto_msgTypes[17]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WireStrategy) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WireStrategy) ProtoMessage() {}
+
+func (x *WireStrategy) ProtoReflect() protoreflect.Message {
+	mi := &file_proxy_reflex_config_proto_msgTypes[17]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WireStrategy.ProtoReflect.Descriptor instead.
+func (*WireStrategy) Descriptor() ([]byte, []int) {
+	return file_proxy_reflex_config_proto_rawDescGZIP(), []int{17}
+}
+
+func (x *WireStrategy) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *WireStrategy) GetArgs() map[string]string {
+	if x != nil {
+		return x.Args
+	}
+	return nil
+}
+
+// ضبط ترافیک شکل‌داده‌شده روی سیم در فایل pcap برای آزمودن با ابزارهای DPI
+type Capture struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Path          string                 `protobuf:"bytes,1,opt,name=path,proto3" json:"path,omitempty"`                                   // مسیر فایل pcap
+	Users         []string               `protobuf:"bytes,2,rep,name=users,proto3" json:"users,omitempty"`                                 // شناسهٔ کاربرانی که ضبط می‌شوند؛ خالی یعنی همه
+	HeadersOnly   bool                   `protobuf:"varint,3,opt,name=headers_only,json=headersOnly,proto3" json:"headers_only,omitempty"` // فقط اندازه و زمان‌بندی، بدون محتوای بسته‌ها
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Capture) Reset() {
+	*x = Capture{}
+	mi := &file_proxy_reflex_config_proto_msgTypes[18]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Capture) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Capture) ProtoMessage() {}
+
+func (x *Capture) ProtoReflect() protoreflect.Message {
+	mi := &file_proxy_reflex_config_proto_msgTypes[18]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Capture.ProtoReflect.Descriptor instead.
+func (*Capture) Descriptor() ([]byte, []int) {
+	return file_proxy_reflex_config_proto_rawDescGZIP(), []int{18}
+}
+
+func (x *Capture) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+func (x *Capture) GetUsers() []string {
+	if x != nil {
+		return x.Users
+	}
+	return nil
+}
+
+func (x *Capture) GetHeadersOnly() bool {
+	if x != nil {
+		return x.HeadersOnly
+	}
+	return false
+}
+
+var File_proxy_reflex_config_proto protoreflect.FileDescriptor
+
+const file_proxy_reflex_config_proto_rawDesc = "" +
+	"\n" +
+	"\x19proxy/reflex/config.proto\x12\freflex.proxy\"\xb6\x01\n" +
+	"\x04User\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x16\n" +
+	"\x06policy\x18\x02 \x01(\tR\x06policy\x12\x10\n" +
+	"\x03psk\x18\x03 \x01(\tR\x03psk\x12\x14\n" +
+	"\x05level\x18\x04 \x01(\rR\x05level\x12\x16\n" +
+	"\x06expiry\x18\x05 \x01(\x03R\x06expiry\x12\x14\n" +
+	"\x05email\x18\x06 \x01(\tR\x05email\x12\x14\n" +
+	"\x05quota\x18\a \x01(\x04R\x05quota\x12\x1a\n" +
+	"\bmorphing\x18\b \x01(\tR\bmorphing\"\x8d\x01\n" +
 	"\aAccount\x12\x0e\n" +
-	"\x02id\x18\x01 \x01(\tR\x02id\"q\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x16\n" +
+	"\x06policy\x18\x02 \x01(\tR\x06policy\x12\x10\n" +
+	"\x03psk\x18\x03 \x01(\tR\x03psk\x12\x16\n" +
+	"\x06expiry\x18\x04 \x01(\x03R\x06expiry\x12\x14\n" +
+	"\x05quota\x18\x05 \x01(\x04R\x05quota\x12\x1a\n" +
+	"\bmorphing\x18\x06 \x01(\tR\bmorphing\"\xb2\f\n" +
 	"\rInboundConfig\x12,\n" +
 	"\aclients\x18\x01 \x03(\v2\x12.reflex.proxy.UserR\aclients\x122\n" +
-	"\bfallback\x18\x02 \x01(\v2\x16.reflex.proxy.FallbackR\bfallback\"\x1e\n" +
+	"\bfallback\x18\x02 \x01(\v2\x16.reflex.proxy.FallbackR\bfallback\x12!\n" +
+	"\fretry_cookie\x18\x03 \x01(\bR\vretryCookie\x12H\n" +
+	"\x10concurrent_login\x18\x04 \x01(\v2\x1d.reflex.proxy.ConcurrentLoginR\x0fconcurrentLogin\x126\n" +
+	"\bpolicies\x18\x05 \x03(\v2\x1a.reflex.proxy.PolicyConfigR\bpolicies\x12?\n" +
+	"\rpolicy_server\x18\x06 \x01(\v2\x1a.reflex.proxy.PolicyServerR\fpolicyServer\x12%\n" +
+	"\x0edefault_policy\x18\a \x01(\tR\rdefaultPolicy\x12&\n" +
+	"\x0fdeny_by_default\x18\b \x01(\bR\rdenyByDefault\x12#\n" +
+	"\rdrain_timeout\x18\t \x01(\rR\fdrainTimeout\x12%\n" +
+	"\x0ehandoff_socket\x18\n" +
+	" \x01(\tR\rhandoffSocket\x12<\n" +
+	"\fport_hopping\x18\v \x01(\v2\x19.reflex.proxy.PortHoppingR\vportHopping\x12\x1f\n" +
+	"\vhealth_path\x18\f \x01(\tR\n" +
+	"healthPath\x12\x1d\n" +
+	"\n" +
+	"state_file\x18\r \x01(\tR\tstateFile\x124\n" +
+	"\x06limits\x18\x0e \x01(\v2\x1c.reflex.proxy.ResourceLimitsR\x06limits\x12/\n" +
+	"\acapture\x18\x0f \x01(\v2\x15.reflex.proxy.CaptureR\acapture\x12#\n" +
+	"\rfeedback_path\x18\x10 \x01(\tR\ffeedbackPath\x12;\n" +
+	"\fcover_fronts\x18\x11 \x03(\v2\x18.reflex.proxy.CoverFrontR\vcoverFronts\x126\n" +
+	"\bstrategy\x18\x12 \x01(\v2\x1a.reflex.proxy.WireStrategyR\bstrategy\x12#\n" +
+	"\rleakage_audit\x18\x13 \x01(\bR\fleakageAudit\x12\x17\n" +
+	"\akey_log\x18\x14 \x01(\tR\x06keyLog\x12%\n" +
+	"\x0etranscript_dir\x18\x15 \x01(\tR\rtranscriptDir\x12\x16\n" +
+	"\x06pacing\x18\x16 \x01(\bR\x06pacing\x12#\n" +
+	"\rvless_inbound\x18\x17 \x01(\tR\fvlessInbound\x12<\n" +
+	"\flog_sampling\x18\x18 \x01(\v2\x19.reflex.proxy.LogSamplingR\vlogSampling\x12#\n" +
+	"\rhandshake_ids\x18\x19 \x01(\tR\fhandshakeIds\x12'\n" +
+	"\x0fresume_rotation\x18\x1a \x01(\rR\x0eresumeRotation\x12g\n" +
+	"\x14destination_profiles\x18\x1b \x03(\v24.reflex.proxy.InboundConfig.DestinationProfilesEntryR\x13destinationProfiles\x12%\n" +
+	"\x0eusage_interval\x18\x1c \x01(\rR\rusageInterval\x12\x1a\n" +
+	"\bmorphing\x18\x1d \x01(\tR\bmorphing\x12\x1d\n" +
+	"\n" +
+	"server_key\x18\x1e \x01(\tR\tserverKey\x12#\n" +
+	"\rhealth_listen\x18\x1f \x01(\tR\fhealthListen\x12%\n" +
+	"\x0erequire_sealed\x18  \x01(\bR\rrequireSealed\x122\n" +
+	"\bcarriers\x18! \x01(\v2\x16.reflex.proxy.CarriersR\bcarriers\x1aF\n" +
+	"\x18DestinationProfilesEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"e\n" +
+	"\bCarriers\x12-\n" +
+	"\x04quic\x18\x01 \x01(\v2\x19.reflex.proxy.QUICCarrierR\x04quic\x12*\n" +
+	"\x03udp\x18\x02 \x01(\v2\x18.reflex.proxy.UDPCarrierR\x03udp\"k\n" +
+	"\vQUICCarrier\x12\x16\n" +
+	"\x06listen\x18\x01 \x01(\tR\x06listen\x12)\n" +
+	"\x10certificate_file\x18\x02 \x01(\tR\x0fcertificateFile\x12\x19\n" +
+	"\bkey_file\x18\x03 \x01(\tR\akeyFile\"^\n" +
+	"\n" +
+	"UDPCarrier\x12\x16\n" +
+	"\x06listen\x18\x01 \x01(\tR\x06listen\x12\x19\n" +
+	"\bfec_data\x18\x02 \x01(\rR\afecData\x12\x1d\n" +
+	"\n" +
+	"fec_parity\x18\x03 \x01(\rR\tfecParity\"?\n" +
+	"\vLogSampling\x12\x14\n" +
+	"\x05burst\x18\x01 \x01(\rR\x05burst\x12\x1a\n" +
+	"\binterval\x18\x02 \x01(\rR\binterval\"|\n" +
+	"\fPolicyServer\x12\x18\n" +
+	"\aaddress\x18\x01 \x01(\tR\aaddress\x12\x18\n" +
+	"\atimeout\x18\x02 \x01(\rR\atimeout\x12\x1b\n" +
+	"\tcache_ttl\x18\x03 \x01(\rR\bcacheTtl\x12\x1b\n" +
+	"\tfail_open\x18\x04 \x01(\bR\bfailOpen\"\xe6\x02\n" +
+	"\fPolicyConfig\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x14\n" +
+	"\x05level\x18\x02 \x01(\rR\x05level\x12\x1a\n" +
+	"\bprofiles\x18\x03 \x03(\tR\bprofiles\x12#\n" +
+	"\rmax_bandwidth\x18\x04 \x01(\x04R\fmaxBandwidth\x12\x1a\n" +
+	"\bfeatures\x18\x05 \x03(\tR\bfeatures\x12\"\n" +
+	"\fdestinations\x18\x06 \x03(\tR\fdestinations\x12\x1f\n" +
+	"\vsession_ttl\x18\a \x01(\rR\n" +
+	"sessionTtl\x12=\n" +
+	"\bswitches\x18\b \x03(\v2!.reflex.proxy.ProfileSwitchConfigR\bswitches\x12\x12\n" +
+	"\x04tier\x18\t \x01(\tR\x04tier\x12\x12\n" +
+	"\x04tags\x18\n" +
+	" \x03(\tR\x04tags\x12#\n" +
+	"\rwrite_timeout\x18\v \x01(\rR\fwriteTimeout\"\x96\x01\n" +
+	"\x13ProfileSwitchConfig\x12\x18\n" +
+	"\aprofile\x18\x01 \x01(\tR\aprofile\x12\x1f\n" +
+	"\vafter_bytes\x18\x02 \x01(\x04R\n" +
+	"afterBytes\x12#\n" +
+	"\rafter_seconds\x18\x03 \x01(\rR\fafterSeconds\x12\x1f\n" +
+	"\von_throttle\x18\x04 \x01(\bR\n" +
+	"onThrottle\"b\n" +
+	"\x0fConcurrentLogin\x12\x1f\n" +
+	"\vmax_sources\x18\x01 \x01(\rR\n" +
+	"maxSources\x12\x16\n" +
+	"\x06window\x18\x02 \x01(\rR\x06window\x12\x16\n" +
+	"\x06action\x18\x03 \x01(\tR\x06action\"{\n" +
 	"\bFallback\x12\x12\n" +
-	"\x04dest\x18\x01 \x01(\rR\x04dest\"N\n" +
+	"\x04dest\x18\x01 \x01(\rR\x04dest\x12)\n" +
+	"\x05decoy\x18\x02 \x01(\v2\x13.reflex.proxy.DecoyR\x05decoy\x12\x18\n" +
+	"\aaddress\x18\x03 \x01(\tR\aaddress\x12\x16\n" +
+	"\x06jitter\x18\x04 \x01(\rR\x06jitter\"4\n" +
+	"\n" +
+	"CoverFront\x12\x12\n" +
+	"\x04host\x18\x01 \x01(\tR\x04host\x12\x12\n" +
+	"\x04path\x18\x02 \x01(\tR\x04path\"\x87\x01\n" +
+	"\x05Decoy\x12\x16\n" +
+	"\x06origin\x18\x01 \x01(\tR\x06origin\x12\x12\n" +
+	"\x04mode\x18\x02 \x01(\tR\x04mode\x12\x1b\n" +
+	"\tcache_ttl\x18\x03 \x01(\rR\bcacheTtl\x12\x18\n" +
+	"\arefresh\x18\x04 \x01(\rR\arefresh\x12\x1b\n" +
+	"\tmax_pages\x18\x05 \x01(\rR\bmaxPages\"\xfb\x02\n" +
 	"\x0eOutboundConfig\x12\x18\n" +
 	"\aaddress\x18\x01 \x01(\tR\aaddress\x12\x12\n" +
 	"\x04port\x18\x02 \x01(\rR\x04port\x12\x0e\n" +
-	"\x02id\x18\x03 \x01(\tR\x02idB(Z&github.com/xtls/xray-core/proxy/reflexb\x06proto3"
+	"\x02id\x18\x03 \x01(\tR\x02id\x12\x1a\n" +
+	"\bmorphing\x18\x04 \x01(\tR\bmorphing\x12\x10\n" +
+	"\x03mux\x18\x05 \x01(\bR\x03mux\x12\x1d\n" +
+	"\n" +
+	"server_key\x18\x06 \x01(\tR\tserverKey\x12\x10\n" +
+	"\x03psk\x18\a \x01(\tR\x03psk\x12\x19\n" +
+	"\bone_time\x18\b \x01(\bR\aoneTime\x12<\n" +
+	"\fport_hopping\x18\t \x01(\v2\x19.reflex.proxy.PortHoppingR\vportHopping\x12\x18\n" +
+	"\acarrier\x18\n" +
+	" \x01(\tR\acarrier\x12\x1f\n" +
+	"\vserver_name\x18\v \x01(\tR\n" +
+	"serverName\x12\x19\n" +
+	"\bfec_data\x18\f \x01(\rR\afecData\x12\x1d\n" +
+	"\n" +
+	"fec_parity\x18\r \x01(\rR\tfecParity\"}\n" +
+	"\vPortHopping\x12\x16\n" +
+	"\x06secret\x18\x01 \x01(\tR\x06secret\x12\x1b\n" +
+	"\tbase_port\x18\x02 \x01(\rR\bbasePort\x12\x1d\n" +
+	"\n" +
+	"port_count\x18\x03 \x01(\rR\tportCount\x12\x1a\n" +
+	"\binterval\x18\x04 \x01(\rR\binterval\"\xad\x01\n" +
+	"\x0eResourceLimits\x12!\n" +
+	"\fmax_sessions\x18\x01 \x01(\rR\vmaxSessions\x122\n" +
+	"\x15handshakes_per_second\x18\x02 \x01(\rR\x13handshakesPerSecond\x12,\n" +
+	"\x12max_buffered_bytes\x18\x03 \x01(\x04R\x10maxBufferedBytes\x12\x16\n" +
+	"\x06action\x18\x04 \x01(\tR\x06action\"\x95\x01\n" +
+	"\fWireStrategy\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x128\n" +
+	"\x04args\x18\x02 \x03(\v2$.reflex.proxy.WireStrategy.ArgsEntryR\x04args\x1a7\n" +
+	"\tArgsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"V\n" +
+	"\aCapture\x12\x12\n" +
+	"\x04path\x18\x01 \x01(\tR\x04path\x12\x14\n" +
+	"\x05users\x18\x02 \x03(\tR\x05users\x12!\n" +
+	"\fheaders_only\x18\x03 \x01(\bR\vheadersOnlyB(Z&github.com/xtls/xray-core/proxy/reflexb\x06proto3"
 
 var (
 	file_proxy_reflex_config_proto_rawDescOnce sync.Once
@@ -305,22 +1822,55 @@ func file_proxy_reflex_config_proto_rawDescGZIP() []byte {
 	return file_proxy_reflex_config_proto_rawDescData
 }
 
-var file_proxy_reflex_config_proto_msgTypes = make([]protoimpl.MessageInfo, 5)
+var file_proxy_reflex_config_proto_msgTypes = make([]protoimpl.MessageInfo, 21)
 var file_proxy_reflex_config_proto_goTypes = []any{
-	(*User)(nil),           // 0: reflex.proxy.User
-	(*Account)(nil),        // 1: reflex.proxy.Account
-	(*InboundConfig)(nil),  // 2: reflex.proxy.InboundConfig
-	(*Fallback)(nil),       // 3: reflex.proxy.Fallback
-	(*OutboundConfig)(nil), // 4: reflex.proxy.OutboundConfig
+	(*User)(nil),                // 0: reflex.proxy.User
+	(*Account)(nil),             // 1: reflex.proxy.Account
+	(*InboundConfig)(nil),       // 2: reflex.proxy.InboundConfig
+	(*Carriers)(nil),            // 3: reflex.proxy.Carriers
+	(*QUICCarrier)(nil),         // 4: reflex.proxy.QUICCarrier
+	(*UDPCarrier)(nil),          // 5: reflex.proxy.UDPCarrier
+	(*LogSampling)(nil),         // 6: reflex.proxy.LogSampling
+	(*PolicyServer)(nil),        // 7: reflex.proxy.PolicyServer
+	(*PolicyConfig)(nil),        // 8: reflex.proxy.PolicyConfig
+	(*ProfileSwitchConfig)(nil), // 9: reflex.proxy.ProfileSwitchConfig
+	(*ConcurrentLogin)(nil),     // 10: reflex.proxy.ConcurrentLogin
+	(*Fallback)(nil),            // 11: reflex.proxy.Fallback
+	(*CoverFront)(nil),          // 12: reflex.proxy.CoverFront
+	(*Decoy)(nil),               // 13: reflex.proxy.Decoy
+	(*OutboundConfig)(nil),      // 14: reflex.proxy.OutboundConfig
+	(*PortHopping)(nil),         // 15: reflex.proxy.PortHopping
+	(*ResourceLimits)(nil),      // 16: reflex.proxy.ResourceLimits
+	(*WireStrategy)(nil),        // 17: reflex.proxy.WireStrategy
+	(*Capture)(nil),             // 18: reflex.proxy.Capture
+	nil,                         // 19: reflex.proxy.InboundConfig.DestinationProfilesEntry
+	nil,                         // 20: reflex.proxy.WireStrategy.ArgsEntry
 }
 var file_proxy_reflex_config_proto_depIdxs = []int32{
-	0, // 0: reflex.proxy.InboundConfig.clients:type_name -> reflex.proxy.User
-	3, // 1: reflex.proxy.InboundConfig.fallback:type_name -> reflex.proxy.Fallback
-	2, // [2:2] is the sub-list for method output_type
-	2, // [2:2] is the sub-list for method input_type
-	2, // [2:2] is the sub-list for extension type_name
-	2, // [2:2] is the sub-list for extension extendee
-	0, // [0:2] is the sub-list for field type_name
+	0,  // 0: reflex.proxy.InboundConfig.clients:type_name -> reflex.proxy.User
+	11, // 1: reflex.proxy.InboundConfig.fallback:type_name -> reflex.proxy.Fallback
+	10, // 2: reflex.proxy.InboundConfig.concurrent_login:type_name -> reflex.proxy.ConcurrentLogin
+	8,  // 3: reflex.proxy.InboundConfig.policies:type_name -> reflex.proxy.PolicyConfig
+	7,  // 4: reflex.proxy.InboundConfig.policy_server:type_name -> reflex.proxy.PolicyServer
+	15, // 5: reflex.proxy.InboundConfig.port_hopping:type_name -> reflex.proxy.PortHopping
+	16, // 6: reflex.proxy.InboundConfig.limits:type_name -> reflex.proxy.ResourceLimits
+	18, // 7: reflex.proxy.InboundConfig.capture:type_name -> reflex.proxy.Capture
+	12, // 8: reflex.proxy.InboundConfig.cover_fronts:type_name -> reflex.proxy.CoverFront
+	17, // 9: reflex.proxy.InboundConfig.strategy:type_name -> reflex.proxy.WireStrategy
+	6,  // 10: reflex.proxy.InboundConfig.log_sampling:type_name -> reflex.proxy.LogSampling
+	19, // 11: reflex.proxy.InboundConfig.destination_profiles:type_name -> reflex.proxy.InboundConfig.DestinationProfilesEntry
+	3,  // 12: reflex.proxy.InboundConfig.carriers:type_name -> reflex.proxy.Carriers
+	4,  // 13: reflex.proxy.Carriers.quic:type_name -> reflex.proxy.QUICCarrier
+	5,  // 14: reflex.proxy.Carriers.udp:type_name -> reflex.proxy.UDPCarrier
+	9,  // 15: reflex.proxy.PolicyConfig.switches:type_name -> reflex.proxy.ProfileSwitchConfig
+	13, // 16: reflex.proxy.Fallback.decoy:type_name -> reflex.proxy.Decoy
+	15, // 17: reflex.proxy.OutboundConfig.port_hopping:type_name -> reflex.proxy.PortHopping
+	20, // 18: reflex.proxy.WireStrategy.args:type_name -> reflex.proxy.WireStrategy.ArgsEntry
+	19, // [19:19] is the sub-list for method output_type
+	19, // [19:19] is the sub-list for method input_type
+	19, // [19:19] is the sub-list for extension type_name
+	19, // [19:19] is the sub-list for extension extendee
+	0,  // [0:19] is the sub-list for field type_name
 }
 
 func init() { file_proxy_reflex_config_proto_init() }
@@ -334,7 +1884,7 @@ func file_proxy_reflex_config_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_proxy_reflex_config_proto_rawDesc), len(file_proxy_reflex_config_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   5,
+			NumMessages:   21,
 			NumExtensions: 0,
 			NumServices:   0,
 		},
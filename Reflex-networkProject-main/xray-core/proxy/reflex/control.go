@@ -0,0 +1,102 @@
+package reflex
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"time"
+)
+
+// Limits on a pushed profile, so that a profile update fits in one frame and
+// cannot stall the peer.
+const (
+	maxUpdateBuckets = 64
+	maxPushedDelay   = 10 * time.Second
+)
+
+// PaddingControl returns the payload of a PADDING_CTRL frame that sets the
+// size of the next shaped frame, see ApplyControlFrame.
+func PaddingControl(size int) []byte {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, uint16(size))
+	return b
+}
+
+// TimingControl returns the payload of a TIMING_CTRL frame that sets the
+// delay after the next shaped frame, see ApplyControlFrame.
+func TimingControl(delay time.Duration) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(delay/time.Millisecond))
+	return b
+}
+
+// profileUpdate is the payload of a PROFILE_UPDATE frame.
+type profileUpdate struct {
+	Name   string        `json:"name"`
+	Sizes  []sizeBucket  `json:"sizes"`
+	Delays []delayBucket `json:"delays,omitempty"`
+}
+
+type sizeBucket struct {
+	Size   int     `json:"size"`
+	Weight float64 `json:"weight"`
+}
+
+type delayBucket struct {
+	DelayMs int64   `json:"delayMs"`
+	Weight  float64 `json:"weight"`
+}
+
+// PushProfile tells the peer to shape its traffic with profile from now on.
+// Unlike SwitchProfile, the peer need not know the profile by name.
+func PushProfile(s *Session, w io.Writer, profile *TrafficProfile) error {
+	return s.WriteFrame(w, FrameTypeProfileUpdate, marshalProfile(profile))
+}
+
+// OfferProfile hands the peer a profile to keep under its name, so that a
+// later SwitchProfile may name it. The peer keeps shaping as before and may
+// refuse the offer.
+func OfferProfile(s *Session, w io.Writer, profile *TrafficProfile) error {
+	return s.WriteFrame(w, FrameTypeProfileOffer, marshalProfile(profile))
+}
+
+func marshalProfile(profile *TrafficProfile) []byte {
+	u := profileUpdate{Name: profile.Name}
+	for _, d := range profile.PacketSizes {
+		u.Sizes = append(u.Sizes, sizeBucket{Size: d.Size, Weight: d.Weight})
+	}
+	for _, d := range profile.Delays {
+		u.Delays = append(u.Delays, delayBucket{DelayMs: d.Delay.Milliseconds(), Weight: d.Weight})
+	}
+	payload, _ := json.Marshal(&u)
+	return payload
+}
+
+// ParseProfileUpdate decodes and validates the payload of a PROFILE_UPDATE
+// or PROFILE_OFFER frame. The returned profile is the receiver's own to shape
+// with.
+func ParseProfileUpdate(b []byte) (*TrafficProfile, error) {
+	var u profileUpdate
+	if err := json.Unmarshal(b, &u); err != nil {
+		return nil, errors.New("reflex: malformed profile update")
+	}
+	if len(u.Sizes) > maxUpdateBuckets || len(u.Delays) > maxUpdateBuckets {
+		return nil, errors.New("reflex: profile update has too many buckets")
+	}
+	p := &TrafficProfile{Name: u.Name}
+	for _, d := range u.Sizes {
+		p.PacketSizes = append(p.PacketSizes, PacketSizeDist{Size: d.Size, Weight: d.Weight})
+	}
+	for _, d := range u.Delays {
+		delay := time.Duration(d.DelayMs) * time.Millisecond
+		if delay > maxPushedDelay {
+			return nil, errors.New("reflex: profile update delays too long")
+		}
+		p.Delays = append(p.Delays, DelayDist{Delay: delay, Weight: d.Weight})
+	}
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
@@ -0,0 +1,82 @@
+package reflex
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"time"
+)
+
+// CookieMarker starts the handshake padding of a retry that echoes a cookie.
+// The layout is CookieMarker | cookie | optional random padding.
+var CookieMarker = []byte("RFXC")
+
+// CookieSize is expiry (8, unix seconds) + HMAC-SHA256 (32).
+const CookieSize = 8 + 32
+
+// DefaultCookieLifetime is how long a client has to come back with a cookie.
+const DefaultCookieLifetime = time.Minute
+
+// CookieIssuer issues and verifies stateless retry cookies. A cookie binds
+// the client's source address to an expiry time under a key that never leaves
+// the server, so verifying it needs no per-client state and no X25519 work.
+type CookieIssuer struct {
+	key      [32]byte
+	lifetime time.Duration
+}
+
+// NewCookieIssuer returns an issuer with a fresh random key.
+func NewCookieIssuer(lifetime time.Duration) (*CookieIssuer, error) {
+	c := &CookieIssuer{lifetime: lifetime}
+	if _, err := rand.Read(c.key[:]); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Issue returns a cookie for source valid until now + lifetime.
+func (c *CookieIssuer) Issue(source string, now time.Time) []byte {
+	cookie := make([]byte, 8, CookieSize)
+	binary.BigEndian.PutUint64(cookie, uint64(now.Add(c.lifetime).Unix()))
+	return append(cookie, c.mac(source, cookie[:8])...)
+}
+
+// Verify reports whether cookie was issued to source and has not expired.
+func (c *CookieIssuer) Verify(source string, cookie []byte, now time.Time) bool {
+	if len(cookie) != CookieSize {
+		return false
+	}
+	if int64(binary.BigEndian.Uint64(cookie[:8])) < now.Unix() {
+		return false
+	}
+	return hmac.Equal(cookie[8:], c.mac(source, cookie[:8]))
+}
+
+func (c *CookieIssuer) mac(source string, expiry []byte) []byte {
+	m := hmac.New(sha256.New, c.key[:])
+	m.Write(expiry)
+	m.Write([]byte(source))
+	return m.Sum(nil)
+}
+
+// CookiePadding returns handshake padding that echoes cookie, followed by
+// random padding so retries do not have a fixed size either.
+func CookiePadding(cookie []byte) []byte {
+	padding := append(append([]byte(nil), CookieMarker...), cookie...)
+	extra := NewHandshakePadding()
+	if room := MaxHandshakePadding - len(padding); len(extra) > room {
+		extra = extra[:room]
+	}
+	return append(padding, extra...)
+}
+
+// CookieFromPadding extracts an echoed cookie from handshake padding, or
+// returns nil if the padding does not carry one.
+func CookieFromPadding(padding []byte) []byte {
+	if len(padding) < len(CookieMarker)+CookieSize || !bytes.HasPrefix(padding, CookieMarker) {
+		return nil
+	}
+	return padding[len(CookieMarker) : len(CookieMarker)+CookieSize]
+}
@@ -0,0 +1,269 @@
+// Package decode recovers the frame sequence of a captured Reflex session
+// from its session key or a key log, for debugging and for checking what a shaped
+// session actually carried. It backs the "xray reflex decode" command.
+package decode
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/proxy/reflex/capture"
+	"github.com/xtls/xray-core/proxy/reflex/handshake"
+)
+
+var (
+	// ErrNoPayload is returned for streams captured without payload, as in
+	// headers-only captures.
+	ErrNoPayload = errors.New("reflex: capture holds no payload to decode")
+	// ErrUnsupported is returned for streams that do not open with a magic
+	// number or HTTP POST handshake.
+	ErrUnsupported = errors.New("reflex: not a Reflex handshake this decoder understands")
+	// ErrWrongKey is set as Session.Err when the key does not confirm
+	// against the handshake.
+	ErrWrongKey = errors.New("reflex: session key does not match the handshake")
+	// ErrNoKey is returned by DecodeKeyLog for sessions the key log has no
+	// key for.
+	ErrNoKey = errors.New("reflex: session key not in the key log")
+)
+
+// Frame is one frame of a decoded session.
+type Frame struct {
+	// At is when the last byte of the frame was captured.
+	At         time.Time
+	FromClient bool
+	Type       uint8
+	Payload    []byte
+	// WireSize is the size of the record on the wire, header included.
+	WireSize int
+}
+
+// Session is a decoded session.
+type Session struct {
+	Client, Server *net.TCPAddr
+	// Handshake is the client's handshake. Of a sealed one, only what is
+	// sent in the clear is known: its user ID, timestamp and policy request
+	// are zero.
+	Handshake *handshake.Client
+	Sealed    bool
+	// Front is the Host and path the handshake was posted to; empty when it
+	// was sent with the magic number.
+	Front string
+	// Status is the HTTP status the server answered the handshake with.
+	Status int
+	// Retry is set when the server asked for a stateless retry instead of
+	// starting the session.
+	Retry        bool
+	Grant        *reflex.PolicyGrant
+	KeyConfirmed bool
+	// Frames are the frames of both directions in capture order.
+	Frames []*Frame
+	// Err is why decoding stopped before the end of the capture, if it did.
+	Err error
+}
+
+// Decode decodes stream with key, the 32-byte session key. Errors in the
+// handshake are returned; errors past it are recorded in Session.Err along
+// with the frames decoded until then.
+func Decode(stream *capture.Stream, key []byte) (*Session, error) {
+	return decode(stream, func(*handshake.Client) ([]byte, error) { return key, nil })
+}
+
+// DecodeKeyLog is Decode with the key looked up by the client nonce in keys,
+// as read by reflex.ReadKeyLog.
+func DecodeKeyLog(stream *capture.Stream, keys map[[16]byte][]byte) (*Session, error) {
+	return decode(stream, func(hs *handshake.Client) ([]byte, error) {
+		key, ok := keys[hs.Nonce]
+		if !ok {
+			return nil, ErrNoKey
+		}
+		return key, nil
+	})
+}
+
+func decode(stream *capture.Stream, keyFor func(*handshake.Client) ([]byte, error)) (*Session, error) {
+	if stream.ToServer.Truncated || stream.ToClient.Truncated {
+		return nil, ErrNoPayload
+	}
+	s := &Session{Client: stream.Client, Server: stream.Server}
+
+	toServer := &offsetReader{b: stream.ToServer.Data}
+	body, err := s.readClientHandshake(toServer)
+	if err != nil {
+		return nil, err
+	}
+	if s.Sealed {
+		sealed, err := handshake.UnmarshalSealed(body)
+		if err != nil {
+			return nil, err
+		}
+		s.Handshake = &handshake.Client{PublicKey: sealed.PublicKey, Nonce: sealed.Nonce, Padding: sealed.Padding}
+	} else if s.Handshake, err = handshake.Unmarshal(body); err != nil {
+		return nil, err
+	}
+	key, err := keyFor(s.Handshake)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != 32 {
+		return nil, errors.New("reflex: session key must be 32 bytes")
+	}
+
+	toClient := &offsetReader{b: stream.ToClient.Data}
+	resp, err := s.readServerHandshake(toClient)
+	if err != nil || resp == nil {
+		return s, err
+	}
+	transcript := reflex.NewTranscript()
+	transcript.Write(body)
+	transcript.Write(resp.PublicKey[:])
+	if s.KeyConfirmed = reflex.VerifyKeyConfirmation(key, transcript.Sum(), resp.KeyConfirm); !s.KeyConfirmed {
+		s.Err = ErrWrongKey
+		return s, nil
+	}
+	if s.Grant, err = reflex.ParsePolicyGrant(resp.PolicyGrant); err != nil {
+		return nil, err
+	}
+
+	s.Err = s.readFrames(key, toServer, stream.ToServer, true)
+	if err := s.readFrames(key, toClient, stream.ToClient, false); s.Err == nil {
+		s.Err = err
+	}
+	sort.SliceStable(s.Frames, func(i, j int) bool { return s.Frames[i].At.Before(s.Frames[j].At) })
+	return s, nil
+}
+
+// readClientHandshake returns the handshake body the client sent.
+func (s *Session) readClientHandshake(r *offsetReader) ([]byte, error) {
+	rest := r.b[r.off:]
+	if len(rest) >= 4 && binary.BigEndian.Uint32(rest) == handshake.Magic {
+		r.off += 4
+		return handshake.ReadBody(r)
+	}
+	if len(rest) >= 4 && binary.BigEndian.Uint32(rest) == handshake.SealedMagic {
+		r.off += 4
+		s.Sealed = true
+		return handshake.ReadSealedBody(r)
+	}
+	if !strings.HasPrefix(string(rest), "POST ") {
+		return nil, ErrUnsupported
+	}
+	br := bufio.NewReader(r)
+	req, err := http.ReadRequest(br)
+	if err != nil {
+		return nil, err
+	}
+	var payload struct {
+		Data   string `json:"data"`
+		Sealed string `json:"sealed"`
+	}
+	err = json.NewDecoder(req.Body).Decode(&payload)
+	_, _ = io.Copy(io.Discard, req.Body)
+	if err != nil {
+		return nil, err
+	}
+	r.off -= br.Buffered()
+	s.Front = req.Host + req.URL.Path
+	if payload.Sealed != "" {
+		s.Sealed = true
+		return base64.StdEncoding.DecodeString(payload.Sealed)
+	}
+	return base64.StdEncoding.DecodeString(payload.Data)
+}
+
+// readServerHandshake returns the server's answer to the handshake, or nil
+// if the server did not start a session.
+func (s *Session) readServerHandshake(r *offsetReader) (*reflex.HandshakeResponse, error) {
+	if r.off == len(r.b) {
+		return nil, nil // the server never answered
+	}
+	br := bufio.NewReader(r)
+	httpResp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := io.ReadAll(httpResp.Body)
+	_ = httpResp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	r.off -= br.Buffered()
+	if s.Status = httpResp.StatusCode; s.Status != http.StatusOK {
+		return nil, nil
+	}
+	var resp reflex.HandshakeResponse
+	if err := json.Unmarshal(respBody, &resp); err != nil {
+		return nil, errors.New("reflex: malformed handshake response")
+	}
+	if resp.RetryCookie != nil {
+		s.Retry = true
+		return nil, nil
+	}
+	return &resp, nil
+}
+
+// readFrames decodes the frames one direction carried after the handshake.
+func (s *Session) readFrames(key []byte, r *offsetReader, flow *capture.Flow, fromClient bool) error {
+	session, err := reflex.NewSession(key)
+	if err != nil {
+		return err
+	}
+	session.SetTLSRecords(s.Grant.HasFeature(reflex.FeatureTLSRecords))
+	if c, _ := reflex.ParseCipher(s.Grant.Cipher); c != reflex.CipherChaCha20Poly1305 {
+		if err := session.SetCipher(c); err != nil {
+			return err
+		}
+	}
+	session.SetKeyCommitment(s.Grant.HasFeature(reflex.FeatureKeyCommitment))
+	if err := session.SetMaskedLengths(s.Grant.HasFeature(reflex.FeatureMaskedLengths)); err != nil {
+		return err
+	}
+	if s.Grant.HasFeature(reflex.FeatureReorderTolerant) {
+		_ = session.SetOrdering(reflex.OrderingTolerant, reflex.DefaultReorderWindow)
+	}
+	for r.off < len(r.b) {
+		start := r.off
+		f, err := session.ReadFrame(r)
+		if err != nil {
+			if err == io.ErrUnexpectedEOF && !flow.Gap {
+				return nil // the capture ended within a frame
+			}
+			return err
+		}
+		s.Frames = append(s.Frames, &Frame{
+			At:         flow.TimeAt(r.off - 1),
+			FromClient: fromClient,
+			Type:       f.Type,
+			Payload:    f.Payload,
+			WireSize:   r.off - start,
+		})
+	}
+	if flow.Gap {
+		return errors.New("reflex: capture is missing a segment")
+	}
+	return nil
+}
+
+// offsetReader reads b and tracks how much of it was read.
+type offsetReader struct {
+	b   []byte
+	off int
+}
+
+func (r *offsetReader) Read(p []byte) (int, error) {
+	if r.off == len(r.b) {
+		return 0, io.EOF
+	}
+	n := copy(p, r.b[r.off:])
+	r.off += n
+	return n, nil
+}
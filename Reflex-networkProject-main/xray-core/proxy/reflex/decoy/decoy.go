@@ -0,0 +1,310 @@
+// Package decoy serves the cover site a Reflex inbound falls back to from a
+// real, benign website, so probes and failed handshakes always land on a
+// convincing and current site instead of a hand-maintained one.
+//
+// In proxy mode every request is fetched from the origin as it comes in and
+// successful GETs are cached for a while. In mirror mode the site is crawled
+// ahead of time and served from memory, refreshed periodically, so the
+// origin sees one crawler instead of the traffic of every probe.
+package decoy
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Modes of a Server.
+const (
+	ModeProxy  = "proxy"
+	ModeMirror = "mirror"
+)
+
+// Defaults for zero Config fields.
+const (
+	DefaultCacheTTL = 10 * time.Minute
+	DefaultRefresh  = 6 * time.Hour
+	DefaultMaxPages = 100
+)
+
+// maxPageSize bounds what is kept of one origin response.
+const maxPageSize = 8 << 20
+
+// fetchTimeout bounds one request to the origin.
+const fetchTimeout = 15 * time.Second
+
+// Config configures a Server.
+type Config struct {
+	Origin   string        // the site, e.g. "https://example.org"; only scheme and host are used
+	Mode     string        // ModeProxy or ModeMirror
+	CacheTTL time.Duration // proxy mode: how long a fetched page is reused
+	Refresh  time.Duration // mirror mode: how often the site is crawled again
+	MaxPages int           // pages kept: per crawl in mirror mode, in the cache in proxy mode
+}
+
+// page is an origin response as it is replayed.
+type page struct {
+	status  int
+	header  http.Header
+	body    []byte
+	fetched time.Time
+}
+
+// Server is an HTTP server presenting the origin site.
+type Server struct {
+	cfg    Config
+	origin *url.URL
+	client *http.Client
+
+	mu       sync.RWMutex
+	pages    map[string]*page // by path and query
+	notFound *page            // the origin's answer for a page that does not exist
+
+	srv  *http.Server
+	done chan struct{}
+}
+
+// New checks cfg and returns a Server for it. Nothing is fetched until the
+// server starts.
+func New(cfg Config) (*Server, error) {
+	origin, err := url.Parse(cfg.Origin)
+	if err != nil || (origin.Scheme != "http" && origin.Scheme != "https") || origin.Host == "" {
+		return nil, errors.New("reflex: decoy origin must be an http or https URL")
+	}
+	switch cfg.Mode {
+	case "":
+		cfg.Mode = ModeProxy
+	case ModeProxy, ModeMirror:
+	default:
+		return nil, errors.New("reflex: unknown decoy mode " + cfg.Mode)
+	}
+	if cfg.CacheTTL <= 0 {
+		cfg.CacheTTL = DefaultCacheTTL
+	}
+	if cfg.Refresh <= 0 {
+		cfg.Refresh = DefaultRefresh
+	}
+	if cfg.MaxPages <= 0 {
+		cfg.MaxPages = DefaultMaxPages
+	}
+	s := &Server{
+		cfg:    cfg,
+		origin: origin,
+		client: &http.Client{
+			Timeout: fetchTimeout,
+			// Redirects are the origin's answer and are replayed as such.
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+		pages: make(map[string]*page),
+		done:  make(chan struct{}),
+	}
+	s.srv = &http.Server{Handler: s, ReadHeaderTimeout: fetchTimeout}
+	return s, nil
+}
+
+// Start listens on addr and serves in the background. In mirror mode the
+// first crawl completes before Start returns, so the fallback never serves
+// an empty site.
+func (s *Server) Start(addr string) error {
+	if s.cfg.Mode == ModeMirror {
+		if err := s.Refresh(context.Background()); err != nil {
+			return err
+		}
+		go s.refreshPeriodically()
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	go func() { _ = s.srv.Serve(ln) }()
+	return nil
+}
+
+// Close stops the server.
+func (s *Server) Close() error {
+	select {
+	case <-s.done:
+	default:
+		close(s.done)
+	}
+	return s.srv.Close()
+}
+
+func (s *Server) refreshPeriodically() {
+	ticker := time.NewTicker(s.cfg.Refresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			// A failed crawl keeps the previous mirror.
+			_ = s.Refresh(context.Background())
+		}
+	}
+}
+
+// linkPattern finds the targets of href and src attributes.
+var linkPattern = regexp.MustCompile(`(?i)(?:href|src)\s*=\s*["']([^"'#]+)`)
+
+// Refresh crawls the origin from its front page, following links on the
+// same host up to MaxPages pages, and replaces the mirror.
+func (s *Server) Refresh(ctx context.Context) error {
+	pages := make(map[string]*page)
+	queue := []string{"/"}
+	seen := map[string]bool{"/": true}
+	for len(queue) > 0 && len(pages) < s.cfg.MaxPages {
+		key := queue[0]
+		queue = queue[1:]
+		p, err := s.fetch(ctx, key, nil)
+		if err != nil {
+			if key == "/" {
+				return err
+			}
+			continue
+		}
+		pages[key] = p
+		if !strings.HasPrefix(p.header.Get("Content-Type"), "text/html") {
+			continue
+		}
+		base := s.origin.ResolveReference(&url.URL{Path: key})
+		for _, m := range linkPattern.FindAllSubmatch(p.body, -1) {
+			ref, err := url.Parse(string(m[1]))
+			if err != nil {
+				continue
+			}
+			u := base.ResolveReference(ref)
+			if u.Host != s.origin.Host || (u.Scheme != "http" && u.Scheme != "https") {
+				continue
+			}
+			if next := u.RequestURI(); !seen[next] {
+				seen[next] = true
+				queue = append(queue, next)
+			}
+		}
+	}
+	notFound, err := s.fetch(ctx, "/"+randomName(), nil)
+	if err != nil {
+		notFound = nil
+	}
+
+	s.mu.Lock()
+	s.pages = pages
+	s.notFound = notFound
+	s.mu.Unlock()
+	return nil
+}
+
+func randomName() string {
+	b := make([]byte, 12)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// forwardedHeaders are passed on to the origin in proxy mode so that it
+// answers the way it would answer the visitor. Cookies are not, so a cached
+// page is never someone's session.
+var forwardedHeaders = []string{"Accept", "Accept-Language", "User-Agent", "If-None-Match", "If-Modified-Since"}
+
+// hopHeaders describe the connection to the origin, not the page.
+var hopHeaders = []string{"Connection", "Keep-Alive", "Proxy-Connection", "Transfer-Encoding", "Upgrade", "Trailer", "Content-Length", "Alt-Svc"}
+
+// fetch GETs requestURI from the origin.
+func (s *Server) fetch(ctx context.Context, requestURI string, from http.Header) (*page, error) {
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.origin.Scheme+"://"+s.origin.Host+requestURI, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range forwardedHeaders {
+		if v := from.Get(h); v != "" {
+			req.Header.Set(h, v)
+		}
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxPageSize))
+	if err != nil {
+		return nil, err
+	}
+	header := resp.Header.Clone()
+	for _, h := range hopHeaders {
+		header.Del(h)
+	}
+	// Replayed pages get the time they are served, not the time they were fetched.
+	header.Del("Date")
+	return &page{status: resp.StatusCode, header: header, body: body, fetched: time.Now()}, nil
+}
+
+// cache keeps p for key, dropping expired pages when the cache is full. A
+// page that does not fit is served but not kept.
+func (s *Server) cache(key string, p *page) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.pages) >= s.cfg.MaxPages {
+		for k, old := range s.pages {
+			if time.Since(old.fetched) > s.cfg.CacheTTL {
+				delete(s.pages, k)
+			}
+		}
+	}
+	if len(s.pages) < s.cfg.MaxPages {
+		s.pages[key] = p
+	}
+}
+
+// ServeHTTP answers from the mirror or the cache, fetching from the origin
+// in proxy mode when needed.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	key := r.URL.RequestURI()
+	s.mu.RLock()
+	p := s.pages[key]
+	notFound := s.notFound
+	s.mu.RUnlock()
+
+	switch {
+	case s.cfg.Mode == ModeMirror:
+		if p == nil {
+			p = notFound
+		}
+	case p == nil || time.Since(p.fetched) > s.cfg.CacheTTL:
+		fetched, err := s.fetch(r.Context(), key, r.Header)
+		if err != nil {
+			// A stale page is still better than an error.
+			break
+		}
+		p = fetched
+		if p.status == http.StatusOK && p.header.Get("Set-Cookie") == "" {
+			s.cache(key, p)
+		}
+	}
+	if p == nil {
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return
+	}
+	for k, v := range p.header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(p.status)
+	if r.Method == http.MethodGet {
+		_, _ = w.Write(p.body)
+	}
+}
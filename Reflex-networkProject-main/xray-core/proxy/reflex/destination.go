@@ -0,0 +1,138 @@
+package reflex
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+)
+
+// Address types of an ADDR frame, as in SOCKS5.
+const (
+	AddrTypeIPv4   uint8 = 0x01
+	AddrTypeDomain uint8 = 0x03
+	AddrTypeIPv6   uint8 = 0x04
+)
+
+// Destination is where the DATA frames of a session go. The client names it
+// in an ADDR frame before the data, and again whenever it changes:
+//
+//	type (1) | address | port (2, big endian)
+//
+// where the address is 4 bytes for AddrTypeIPv4, 16 for AddrTypeIPv6 and a
+// length (1) followed by the name for AddrTypeDomain.
+type Destination struct {
+	Host string // domain or IP address, without brackets
+	Port uint16
+}
+
+// DefaultDestination is where the server sends the data of clients that do
+// not name a destination.
+var DefaultDestination = Destination{Host: "127.0.0.1", Port: 80}
+
+// ParseDestination parses a "host:port" address, with an IPv6 host in
+// brackets.
+func ParseDestination(address string) (Destination, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return Destination{}, errors.New("reflex: invalid destination " + address)
+	}
+	n, err := strconv.ParseUint(port, 10, 16)
+	if err != nil || n == 0 || host == "" || len(host) > 255 {
+		return Destination{}, errors.New("reflex: invalid destination " + address)
+	}
+	return Destination{Host: host, Port: uint16(n)}, nil
+}
+
+// String returns d as "host:port", with an IPv6 host in brackets.
+func (d Destination) String() string {
+	return net.JoinHostPort(d.Host, strconv.Itoa(int(d.Port)))
+}
+
+// Marshal encodes d as the payload of an ADDR frame.
+func (d Destination) Marshal() []byte {
+	var b []byte
+	ip := net.ParseIP(d.Host)
+	switch {
+	case ip != nil && ip.To4() != nil:
+		b = append([]byte{AddrTypeIPv4}, ip.To4()...)
+	case ip != nil:
+		b = append([]byte{AddrTypeIPv6}, ip.To16()...)
+	default:
+		b = append([]byte{AddrTypeDomain, byte(len(d.Host))}, d.Host...)
+	}
+	return binary.BigEndian.AppendUint16(b, d.Port)
+}
+
+// UnmarshalDestination decodes the payload of an ADDR frame.
+func UnmarshalDestination(b []byte) (Destination, error) {
+	malformed := errors.New("reflex: malformed destination")
+	if len(b) < 1 {
+		return Destination{}, malformed
+	}
+	var host string
+	rest := b[1:]
+	switch b[0] {
+	case AddrTypeIPv4, AddrTypeIPv6:
+		size := net.IPv4len
+		if b[0] == AddrTypeIPv6 {
+			size = net.IPv6len
+		}
+		if len(rest) != size+2 {
+			return Destination{}, malformed
+		}
+		host, rest = net.IP(rest[:size]).String(), rest[size:]
+	case AddrTypeDomain:
+		if len(rest) < 1 || rest[0] == 0 || len(rest) != 1+int(rest[0])+2 {
+			return Destination{}, malformed
+		}
+		host, rest = string(rest[1:1+rest[0]]), rest[1+rest[0]:]
+	default:
+		return Destination{}, errors.New("reflex: unknown address type")
+	}
+	port := binary.BigEndian.Uint16(rest)
+	if port == 0 {
+		return Destination{}, malformed
+	}
+	return Destination{Host: host, Port: port}, nil
+}
+
+// SendDestination writes an ADDR frame with d.
+func SendDestination(s *Session, w io.Writer, d Destination) error {
+	return s.WriteFrame(w, FrameTypeAddr, d.Marshal())
+}
+
+// SetDestination records where the DATA frames of the session go, so that
+// it is kept when the session moves to another connection or process.
+func (s *Session) SetDestination(d Destination) {
+	s.mu.Lock()
+	s.destination = &d
+	s.mu.Unlock()
+}
+
+// Destination returns where the DATA frames of the session go, and false
+// while none was named.
+func (s *Session) Destination() (Destination, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.destination == nil {
+		return Destination{}, false
+	}
+	return *s.destination, true
+}
+
+// SetDestination tells the server to send the DATA frames that follow to
+// address ("host:port").
+func (c *ClientConn) SetDestination(address string) error {
+	d, err := ParseDestination(address)
+	if err != nil {
+		return err
+	}
+	conn, _ := c.transport()
+	if err := SendDestination(c.Session, conn, d); err != nil {
+		return err
+	}
+	c.Session.SetDestination(d)
+	return nil
+}
@@ -0,0 +1,81 @@
+package reflex
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/xtls/xray-core/common/protocol/http"
+	"github.com/xtls/xray-core/common/protocol/tls"
+)
+
+// DefaultDestinationProfiles is a mapping table for DestinationProfiles that
+// shapes streams like the service they go to, where a built-in profile
+// imitates it.
+var DefaultDestinationProfiles = map[string]string{
+	"googlevideo.com": "youtube",
+	"youtube.com":     "youtube",
+	"ytimg.com":       "youtube",
+	"zoom.us":         "zoom",
+	"zoom.com":        "zoom",
+}
+
+// DestinationProfiles picks the cover profile of a stream from its
+// destination, so that a stream to a video service is shaped like video and
+// one to a meeting service like a call.
+type DestinationProfiles struct {
+	domains map[string]string // lowercase domain to profile name
+}
+
+// NewDestinationProfiles returns DestinationProfiles for a mapping table of
+// domains to profile names. A domain covers its subdomains, and the most
+// specific domain of the table wins: with "googlevideo.com" and
+// "rr1.googlevideo.com" both mapped, the latter decides for its host.
+func NewDestinationProfiles(table map[string]string) (*DestinationProfiles, error) {
+	d := &DestinationProfiles{domains: make(map[string]string, len(table))}
+	for domain, profile := range table {
+		domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+		if domain == "" || profile == "" {
+			return nil, errors.New("reflex: destination profile mapping with an empty domain or profile")
+		}
+		d.domains[domain] = profile
+	}
+	return d, nil
+}
+
+// Lookup returns the profile for streams to host, a domain or an IP
+// address, if the table maps it. A nil DestinationProfiles maps nothing.
+func (d *DestinationProfiles) Lookup(host string) (string, bool) {
+	if d == nil || host == "" {
+		return "", false
+	}
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if net.ParseIP(host) != nil {
+		profile, ok := d.domains[host]
+		return profile, ok
+	}
+	for {
+		if profile, ok := d.domains[host]; ok {
+			return profile, true
+		}
+		_, parent, found := strings.Cut(host, ".")
+		if !found {
+			return "", false
+		}
+		host = parent
+	}
+}
+
+// SniffHost returns the host the first bytes of a stream are addressed to:
+// the server name of a TLS ClientHello or the Host header of an HTTP
+// request. It returns "" for anything else.
+func SniffHost(b []byte) string {
+	if h, err := tls.SniffTLS(b); err == nil {
+		return h.Domain()
+	}
+	if h, err := http.SniffHTTP(b, context.Background()); err == nil {
+		return h.Domain()
+	}
+	return ""
+}
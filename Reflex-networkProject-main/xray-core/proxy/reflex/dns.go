@@ -0,0 +1,189 @@
+package reflex
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// NewTunnelResolver returns a resolver that sends every DNS query over a
+// Reflex session, as DNS over TCP to server ("host:port"), instead of to the
+// resolvers of the local network. Names looked up through it do not leak to
+// the local network in plaintext.
+func NewTunnelResolver(dial TunnelDialer, server string) *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			c, err := dial(ctx, server)
+			if err != nil {
+				return nil, err
+			}
+			return newStreamConn(c), nil
+		},
+	}
+}
+
+// DNSServer is a local DNS server that answers the queries it gets over UDP
+// by sending them over a Reflex session, so that applications and the system
+// resolver can be pointed at it instead of the local network's resolvers.
+// Like the other front-ends it should listen on a loopback address.
+type DNSServer struct {
+	// Dial opens the session for each query.
+	Dial TunnelDialer
+	// Upstream is the resolver ("host:port") the queries are sent to, as
+	// DNS over TCP.
+	Upstream string
+	// Timeout bounds each query. Zero means DefaultFrontEndTimeout.
+	Timeout time.Duration
+
+	mu      sync.Mutex
+	pc      net.PacketConn
+	queries sync.WaitGroup
+}
+
+// ListenAndServe listens on the UDP address addr and calls Serve.
+func (s *DNSServer) ListenAndServe(addr string) error {
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(pc)
+}
+
+// Serve answers the queries that arrive on pc until Close is called, and
+// returns nil then.
+func (s *DNSServer) Serve(pc net.PacketConn) error {
+	s.mu.Lock()
+	s.pc = pc
+	s.mu.Unlock()
+	b := make([]byte, 65535)
+	for {
+		n, from, err := pc.ReadFrom(b)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		query := append([]byte(nil), b[:n]...)
+		s.queries.Add(1)
+		go func() {
+			defer s.queries.Done()
+			if reply, err := s.exchange(query); err == nil {
+				_, _ = pc.WriteTo(reply, from)
+			}
+		}()
+	}
+}
+
+// Close stops the server and waits for the queries in flight.
+func (s *DNSServer) Close() error {
+	s.mu.Lock()
+	pc := s.pc
+	s.mu.Unlock()
+	var err error
+	if pc != nil {
+		err = pc.Close()
+	}
+	s.queries.Wait()
+	return err
+}
+
+func (s *DNSServer) exchange(query []byte) ([]byte, error) {
+	timeout := s.Timeout
+	if timeout == 0 {
+		timeout = DefaultFrontEndTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	c, err := s.Dial(ctx, s.Upstream)
+	if err != nil {
+		return nil, err
+	}
+	conn := newStreamConn(c)
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+	msg := make([]byte, 2+len(query))
+	binary.BigEndian.PutUint16(msg, uint16(len(query)))
+	copy(msg[2:], query)
+	if _, err := conn.Write(msg); err != nil {
+		return nil, err
+	}
+	var length [2]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		return nil, err
+	}
+	reply := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+// streamConn is a net.Conn over the DATA frames of a session.
+type streamConn struct {
+	c       *ClientConn
+	nc      net.Conn // the session's connection, nil if it is not a net.Conn
+	pending []byte   // the rest of the last DATA frame read
+}
+
+func newStreamConn(c *ClientConn) *streamConn {
+	conn, _ := c.transport()
+	nc, _ := conn.(net.Conn)
+	return &streamConn{c: c, nc: nc}
+}
+
+func (s *streamConn) Read(b []byte) (int, error) {
+	for len(s.pending) == 0 {
+		f, err := s.c.ReadFrame()
+		if err != nil {
+			return 0, err
+		}
+		s.pending = f.Payload
+	}
+	n := copy(b, s.pending)
+	s.pending = s.pending[n:]
+	return n, nil
+}
+
+func (s *streamConn) Write(b []byte) (int, error) { return tunnelWriter{s.c}.Write(b) }
+func (s *streamConn) Close() error                { return s.c.Close() }
+
+func (s *streamConn) LocalAddr() net.Addr {
+	if s.nc == nil {
+		return nil
+	}
+	return s.nc.LocalAddr()
+}
+
+func (s *streamConn) RemoteAddr() net.Addr {
+	if s.nc == nil {
+		return nil
+	}
+	return s.nc.RemoteAddr()
+}
+
+func (s *streamConn) SetDeadline(t time.Time) error {
+	if s.nc == nil {
+		return nil
+	}
+	return s.nc.SetDeadline(t)
+}
+
+func (s *streamConn) SetReadDeadline(t time.Time) error {
+	if s.nc == nil {
+		return nil
+	}
+	return s.nc.SetReadDeadline(t)
+}
+
+func (s *streamConn) SetWriteDeadline(t time.Time) error {
+	if s.nc == nil {
+		return nil
+	}
+	return s.nc.SetWriteDeadline(t)
+}
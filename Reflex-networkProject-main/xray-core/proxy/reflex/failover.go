@@ -0,0 +1,122 @@
+package reflex
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// FailoverDialer opens sessions with one of several servers. It keeps using
+// the active server until a handshake with it fails or one of its sessions
+// reports interference, then moves on to the next server in the list.
+// Sessions that were cut end like any other; the tunnels the application
+// opens again are carried by the server now active.
+type FailoverDialer struct {
+	servers  []string
+	base     *ClientOptions
+	opts     []*ClientOptions // per server, to tell which one saw interference
+	onSwitch func(server string)
+
+	mu     sync.Mutex
+	active int
+}
+
+// NewFailoverDialer returns a dialer for servers, in order of preference,
+// with opts for every handshake. onSwitch, if set, is told whenever another
+// server becomes active.
+func NewFailoverDialer(servers []string, opts *ClientOptions, onSwitch func(server string)) *FailoverDialer {
+	d := &FailoverDialer{
+		servers:  append([]string(nil), servers...),
+		base:     opts,
+		onSwitch: onSwitch,
+	}
+	for n := range d.servers {
+		withFailover := *opts
+		withFailover.OnInterference = func(e *InterferenceEvent) {
+			d.activate(n, (n+1)%len(d.servers))
+			if opts.OnInterference != nil {
+				opts.OnInterference(e)
+			}
+		}
+		d.opts = append(d.opts, &withFailover)
+	}
+	return d
+}
+
+// Active returns the server new sessions are opened with first.
+func (d *FailoverDialer) Active() string {
+	if len(d.servers) == 0 {
+		return ""
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.servers[d.active]
+}
+
+// Dial is a TunnelDialer. It tries the active server and then the others in
+// order, and returns the first session established, or the last error.
+func (d *FailoverDialer) Dial(ctx context.Context, address string) (*ClientConn, error) {
+	if len(d.servers) == 0 {
+		return nil, errors.New("reflex: no servers to dial")
+	}
+	d.mu.Lock()
+	start := d.active
+	d.mu.Unlock()
+	var lastErr error
+	for i := range d.servers {
+		n := (start + i) % len(d.servers)
+		c, err := openTunnel(ctx, d.servers[n], d.opts[n], address)
+		if err == nil {
+			if i > 0 {
+				d.activate(start, n)
+			}
+			return c, nil
+		}
+		lastErr = err
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, lastErr
+}
+
+// Fail moves on from server if it is the active one, e.g. when the
+// application sees it misbehave in a way sessions do not detect.
+func (d *FailoverDialer) Fail(server string) {
+	if len(d.servers) == 0 {
+		return
+	}
+	d.mu.Lock()
+	n := d.active
+	d.mu.Unlock()
+	if d.servers[n] == server {
+		d.activate(n, (n+1)%len(d.servers))
+	}
+}
+
+// Prefer makes server the active one, if it is one of the servers.
+func (d *FailoverDialer) Prefer(server string) {
+	for n, s := range d.servers {
+		if s == server {
+			d.mu.Lock()
+			from := d.active
+			d.mu.Unlock()
+			d.activate(from, n)
+			return
+		}
+	}
+}
+
+// activate makes servers[to] active if servers[from] still is.
+func (d *FailoverDialer) activate(from, to int) {
+	d.mu.Lock()
+	if d.active != from || from == to {
+		d.mu.Unlock()
+		return
+	}
+	d.active = to
+	d.mu.Unlock()
+	if d.onSwitch != nil {
+		d.onSwitch(d.servers[to])
+	}
+}
@@ -0,0 +1,89 @@
+package reflex
+
+import (
+	"errors"
+	"sort"
+	"sync"
+)
+
+// MinFeedbackFlows is how many verdicts a profile needs before Suggest
+// considers it; fewer say more about chance than about the profile.
+const MinFeedbackFlows = 100
+
+// ClassifierVerdict is an external traffic classifier's verdict on one flow
+// shaped with Profile.
+type ClassifierVerdict struct {
+	Profile string `json:"profile"`
+	Flagged bool   `json:"flagged"`
+}
+
+// ProfileFeedback is what the classifier said about one profile so far.
+type ProfileFeedback struct {
+	Profile     string  `json:"profile"`
+	Flows       uint64  `json:"flows"`
+	Flagged     uint64  `json:"flagged"`
+	FlaggedRate float64 `json:"flagged_rate"`
+}
+
+// ClassifierFeedback accumulates classifier verdicts per traffic profile,
+// so operators can see which profiles a classifier picks out and move
+// users to the ones it does not.
+type ClassifierFeedback struct {
+	mu     sync.Mutex
+	counts map[string]*ProfileFeedback
+}
+
+// NewClassifierFeedback returns an empty ClassifierFeedback.
+func NewClassifierFeedback() *ClassifierFeedback {
+	return &ClassifierFeedback{counts: make(map[string]*ProfileFeedback)}
+}
+
+// Record adds one verdict. Verdicts on unknown profiles are refused, so a
+// typo in the classifier's output does not start a statistic of its own.
+func (f *ClassifierFeedback) Record(v ClassifierVerdict) error {
+	if _, known := Profiles[v.Profile]; !known {
+		return errors.New("reflex: feedback for unknown profile " + v.Profile)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c := f.counts[v.Profile]
+	if c == nil {
+		c = &ProfileFeedback{Profile: v.Profile}
+		f.counts[v.Profile] = c
+	}
+	c.Flows++
+	if v.Flagged {
+		c.Flagged++
+	}
+	c.FlaggedRate = float64(c.Flagged) / float64(c.Flows)
+	return nil
+}
+
+// Stats returns the feedback of every profile with verdicts, least flagged
+// first.
+func (f *ClassifierFeedback) Stats() []ProfileFeedback {
+	f.mu.Lock()
+	stats := make([]ProfileFeedback, 0, len(f.counts))
+	for _, c := range f.counts {
+		stats = append(stats, *c)
+	}
+	f.mu.Unlock()
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].FlaggedRate != stats[j].FlaggedRate {
+			return stats[i].FlaggedRate < stats[j].FlaggedRate
+		}
+		return stats[i].Profile < stats[j].Profile
+	})
+	return stats
+}
+
+// Suggest returns the least flagged profile with at least MinFeedbackFlows
+// verdicts, if there is one.
+func (f *ClassifierFeedback) Suggest() (string, bool) {
+	for _, s := range f.Stats() {
+		if s.Flows >= MinFeedbackFlows {
+			return s.Profile, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,75 @@
+package reflex
+
+import (
+	"io"
+	"math/rand"
+	"time"
+)
+
+// FragmentConfig controls how a client splits its first flight into several
+// writes, so the handshake does not arrive as one packet whose size and
+// leading bytes DPI can match on. With TCP_NODELAY (Go's default) every write
+// normally leaves as its own segment.
+type FragmentConfig struct {
+	MinSize  int
+	MaxSize  int
+	MinDelay time.Duration
+	MaxDelay time.Duration
+}
+
+// DefaultFragmentConfig splits into 8 to 64 byte pieces spaced 1 to 10 ms apart.
+var DefaultFragmentConfig = FragmentConfig{
+	MinSize:  8,
+	MaxSize:  64,
+	MinDelay: time.Millisecond,
+	MaxDelay: 10 * time.Millisecond,
+}
+
+// WriteFragmented writes b to w in randomly sized pieces with jittered pauses
+// between them. Servers tolerate this up to their handshake timeout.
+func WriteFragmented(w io.Writer, b []byte, cfg FragmentConfig) error {
+	if cfg.MinSize <= 0 {
+		cfg.MinSize = 1
+	}
+	if cfg.MaxSize < cfg.MinSize {
+		cfg.MaxSize = cfg.MinSize
+	}
+	for len(b) > 0 {
+		n := cfg.MinSize + rand.Intn(cfg.MaxSize-cfg.MinSize+1)
+		if n > len(b) {
+			n = len(b)
+		}
+		if _, err := w.Write(b[:n]); err != nil {
+			return err
+		}
+		b = b[n:]
+		if len(b) > 0 {
+			time.Sleep(jitter(cfg.MinDelay, cfg.MaxDelay))
+		}
+	}
+	return nil
+}
+
+func jitter(min, max time.Duration) time.Duration {
+	if max <= min {
+		return min
+	}
+	return min + time.Duration(rand.Int63n(int64(max-min)+1))
+}
+
+// JitterReader pauses for a random time of up to Max after each read from R
+// that returns data. Relaying the responses of a fallback web server through
+// it gives them the small timing variation of morphed Reflex frames, so both
+// paths of the server look alike under measurement.
+type JitterReader struct {
+	R   io.Reader
+	Max time.Duration
+}
+
+func (j *JitterReader) Read(b []byte) (int, error) {
+	n, err := j.R.Read(b)
+	if n > 0 {
+		time.Sleep(jitter(0, j.Max))
+	}
+	return n, err
+}
@@ -0,0 +1,288 @@
+// Package frame implements the Reflex wire format without any cryptography.
+//
+// A frame travels on the wire as a record:
+//
+//	length (2, big endian) | nonce | ciphertext
+//
+// where length covers nonce and ciphertext. Once the ciphertext has been
+// opened, the plaintext is:
+//
+//	type (1) | payload
+//
+// In TLS framing mode the length is preceded by the header of a TLS 1.3
+// application-data record, and records stay within TLS size limits:
+//
+//	0x17 | 0x03 0x03 | length (2, big endian) | nonce | ciphertext
+//
+// In masked-length mode the length follows the nonce and is masked with a
+// value only the peers can derive from the nonce, so that an observer of the
+// stream cannot tell where one record ends and the next begins:
+//
+//	nonce | length ^ mask (2, big endian) | ciphertext
+//
+// The package is shared by reflex.Session and by tooling (analyzers, fuzzers,
+// alternative transports) that needs the exact same layout.
+package frame
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Frame type constants.
+const (
+	TypeData              uint8 = 0x00
+	TypePaddingCtrl       uint8 = 0x01
+	TypeTimingCtrl        uint8 = 0x02
+	TypeChallenge         uint8 = 0x03
+	TypeChallengeResponse uint8 = 0x04
+	TypePolicyRequest     uint8 = 0x05
+	TypePolicyGrant       uint8 = 0x06
+	TypeProfileSwitch     uint8 = 0x07
+	TypeClose             uint8 = 0x08
+	TypeProfileUpdate     uint8 = 0x09
+	TypeProfileOffer      uint8 = 0x0A
+	TypeUsage             uint8 = 0x0B
+	TypeAddr              uint8 = 0x0C
+	TypeStreamOpen        uint8 = 0x0D
+	TypeStreamData        uint8 = 0x0E
+	TypeStreamClose       uint8 = 0x0F
+	TypeDatagram          uint8 = 0x10
+	TypePaddedData        uint8 = 0x11
+)
+
+// typeNames are the names the specification uses for frame types.
+var typeNames = map[uint8]string{
+	TypeData:              "DATA",
+	TypePaddingCtrl:       "PADDING_CTRL",
+	TypeTimingCtrl:        "TIMING_CTRL",
+	TypeChallenge:         "CHALLENGE",
+	TypeChallengeResponse: "CHALLENGE_RESPONSE",
+	TypePolicyRequest:     "POLICY_REQUEST",
+	TypePolicyGrant:       "POLICY_GRANT",
+	TypeProfileSwitch:     "PROFILE_SWITCH",
+	TypeClose:             "CLOSE",
+	TypeProfileUpdate:     "PROFILE_UPDATE",
+	TypeProfileOffer:      "PROFILE_OFFER",
+	TypeUsage:             "USAGE",
+	TypeAddr:              "ADDR",
+	TypeStreamOpen:        "STREAM_OPEN",
+	TypeStreamData:        "STREAM_DATA",
+	TypeStreamClose:       "STREAM_CLOSE",
+	TypeDatagram:          "DATAGRAM",
+	TypePaddedData:        "PADDED_DATA",
+}
+
+// TypeName returns the name of frame type t, e.g. "PADDING_CTRL", or its
+// value in hex if t is unknown.
+func TypeName(t uint8) string {
+	if name, ok := typeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%02x", t)
+}
+
+// LengthSize is the size of the record length prefix.
+const LengthSize = 2
+
+// MaxRecordSize is the largest nonce+ciphertext length the prefix can express.
+const MaxRecordSize = 0xFFFF
+
+// TLS framing mode values: the record type of TLS application data and the
+// legacy version every TLS 1.3 record carries.
+const (
+	tlsApplicationData = 0x17
+	tlsLegacyVersion   = 0x0303
+)
+
+// TLSHeaderSize is the size of the record header in TLS framing mode,
+// including the length.
+const TLSHeaderSize = 5
+
+// MaxTLSRecordSize is the largest nonce+ciphertext length in TLS framing
+// mode, the TLS 1.3 limit of 2^14 + 256 bytes.
+const MaxTLSRecordSize = 1<<14 + 256
+
+// Frame holds a decoded frame.
+type Frame struct {
+	Type    uint8
+	Payload []byte
+}
+
+// Marshal returns the plaintext encoding of f: type followed by payload.
+func (f *Frame) Marshal() []byte {
+	b := make([]byte, 1+len(f.Payload))
+	b[0] = f.Type
+	copy(b[1:], f.Payload)
+	return b
+}
+
+// Unmarshal decodes a plaintext produced by Frame.Marshal. The returned
+// frame's payload aliases b.
+func Unmarshal(b []byte) (*Frame, error) {
+	if len(b) < 1 {
+		return nil, errors.New("reflex: empty plaintext")
+	}
+	return &Frame{
+		Type:    b[0],
+		Payload: b[1:],
+	}, nil
+}
+
+// Record is one sealed frame as it appears on the wire.
+type Record struct {
+	Nonce      []byte
+	Ciphertext []byte
+}
+
+// Len returns the value carried in the record's length prefix.
+func (r *Record) Len() int {
+	return len(r.Nonce) + len(r.Ciphertext)
+}
+
+// WriteRecord writes rec to w in a single Write call.
+func WriteRecord(w io.Writer, rec *Record) error {
+	totalLen := rec.Len()
+	if totalLen > MaxRecordSize {
+		return errors.New("reflex: frame too large")
+	}
+	b := make([]byte, LengthSize+totalLen)
+	binary.BigEndian.PutUint16(b, uint16(totalLen))
+	copy(b[LengthSize:], rec.Nonce)
+	copy(b[LengthSize+len(rec.Nonce):], rec.Ciphertext)
+	_, err := w.Write(b)
+	return err
+}
+
+// ReadRecord reads one record from r. nonceSize is the fixed nonce length and
+// overhead the minimum ciphertext length (the AEAD tag size).
+func ReadRecord(r io.Reader, nonceSize, overhead int) (*Record, error) {
+	var lenBuf [LengthSize]byte
+	if _, err := io.ReadFull(r, lenBuf[:]); err != nil {
+		return nil, err
+	}
+	totalLen := int(binary.BigEndian.Uint16(lenBuf[:]))
+	if totalLen < nonceSize {
+		return nil, errors.New("reflex: frame too short")
+	}
+	cipherLen := totalLen - nonceSize
+	if cipherLen < overhead {
+		return nil, errors.New("reflex: ciphertext too short")
+	}
+
+	body := make([]byte, totalLen)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	return &Record{
+		Nonce:      body[:nonceSize],
+		Ciphertext: body[nonceSize:],
+	}, nil
+}
+
+// WriteTLSRecord is WriteRecord in TLS framing mode.
+func WriteTLSRecord(w io.Writer, rec *Record) error {
+	totalLen := rec.Len()
+	if totalLen > MaxTLSRecordSize {
+		return errors.New("reflex: frame too large for a TLS record")
+	}
+	b := make([]byte, TLSHeaderSize+totalLen)
+	b[0] = tlsApplicationData
+	binary.BigEndian.PutUint16(b[1:], tlsLegacyVersion)
+	binary.BigEndian.PutUint16(b[3:], uint16(totalLen))
+	copy(b[TLSHeaderSize:], rec.Nonce)
+	copy(b[TLSHeaderSize+len(rec.Nonce):], rec.Ciphertext)
+	_, err := w.Write(b)
+	return err
+}
+
+// ReadTLSRecord is ReadRecord in TLS framing mode.
+func ReadTLSRecord(r io.Reader, nonceSize, overhead int) (*Record, error) {
+	var hdr [TLSHeaderSize - LengthSize]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	if hdr[0] != tlsApplicationData || binary.BigEndian.Uint16(hdr[1:]) != tlsLegacyVersion {
+		return nil, errors.New("reflex: not a TLS application data record")
+	}
+	rec, err := ReadRecord(r, nonceSize, overhead)
+	if err != nil {
+		return nil, err
+	}
+	if rec.Len() > MaxTLSRecordSize {
+		return nil, errors.New("reflex: TLS record too large")
+	}
+	return rec, nil
+}
+
+// SampleSize is how many bytes of a record's ciphertext its header mask is
+// taken from in masked-length mode. AEAD ciphertexts are at least this long.
+const SampleSize = 16
+
+// HeaderMask returns the mask of the nonce and length of a record in
+// masked-length mode, taken from sample, the first SampleSize bytes of its
+// ciphertext. The mask must be at least as long as the nonce and length.
+type HeaderMask func(sample []byte) []byte
+
+// WriteMaskedRecord is WriteRecord in masked-length mode. The record starts
+// with the ciphertext's sample, and the nonce and length follow under its
+// mask, so that no part of the header is in clear:
+//
+//	sample(16) | (nonce | len) ^ mask(sample) | rest of ciphertext
+func WriteMaskedRecord(w io.Writer, rec *Record, mask HeaderMask) error {
+	totalLen := rec.Len()
+	if totalLen > MaxRecordSize {
+		return errors.New("reflex: frame too large")
+	}
+	if len(rec.Ciphertext) < SampleSize {
+		return errors.New("reflex: ciphertext too short")
+	}
+	b := make([]byte, LengthSize+totalLen)
+	sample := rec.Ciphertext[:SampleSize]
+	n := copy(b, sample)
+	head := b[n : n+len(rec.Nonce)+LengthSize]
+	copy(head, rec.Nonce)
+	binary.BigEndian.PutUint16(head[len(rec.Nonce):], uint16(totalLen))
+	xorMask(head, mask(sample))
+	copy(b[n+len(head):], rec.Ciphertext[SampleSize:])
+	_, err := w.Write(b)
+	return err
+}
+
+// ReadMaskedRecord is ReadRecord in masked-length mode.
+func ReadMaskedRecord(r io.Reader, nonceSize, overhead int, mask HeaderMask) (*Record, error) {
+	head := make([]byte, SampleSize+nonceSize+LengthSize)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return nil, err
+	}
+	sample := head[:SampleSize]
+	xorMask(head[SampleSize:], mask(sample))
+	nonce := head[SampleSize : SampleSize+nonceSize]
+	totalLen := int(binary.BigEndian.Uint16(head[SampleSize+nonceSize:]))
+	if totalLen < nonceSize {
+		return nil, errors.New("reflex: frame too short")
+	}
+	cipherLen := totalLen - nonceSize
+	if cipherLen < overhead || cipherLen < SampleSize {
+		return nil, errors.New("reflex: ciphertext too short")
+	}
+
+	ciphertext := make([]byte, cipherLen)
+	copy(ciphertext, sample)
+	if _, err := io.ReadFull(r, ciphertext[SampleSize:]); err != nil {
+		return nil, err
+	}
+	return &Record{
+		Nonce:      nonce,
+		Ciphertext: ciphertext,
+	}, nil
+}
+
+// xorMask XORs b with the start of mask.
+func xorMask(b, mask []byte) {
+	for i := range b {
+		b[i] ^= mask[i]
+	}
+}
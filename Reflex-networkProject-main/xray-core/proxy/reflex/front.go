@@ -0,0 +1,57 @@
+package reflex
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// PickFront picks the cover front for one connection. Every connection picks
+// anew, so blocking one Host or path only takes out part of the traffic.
+func PickFront(fronts []*CoverFront) *CoverFront {
+	return fronts[rand.Intn(len(fronts))]
+}
+
+// MatchFront reports whether an HTTP request for host and path is addressed
+// to one of fronts. The port of host and the query of path are ignored.
+func MatchFront(fronts []*CoverFront, host, path string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if i := strings.IndexByte(path, '?'); i >= 0 {
+		path = path[:i]
+	}
+	for _, f := range fronts {
+		if strings.EqualFold(f.Host, host) && f.Path == path {
+			return true
+		}
+	}
+	return false
+}
+
+// httpHandshake wraps a handshake body as the POST request an inbound
+// accepts on a cover front: a JSON object whose data field, or sealed field
+// for a sealed handshake, is the body in base64.
+func httpHandshake(front *CoverFront, body []byte, sealed bool) []byte {
+	var fields struct {
+		Data   string `json:"data,omitempty"`
+		Sealed string `json:"sealed,omitempty"`
+	}
+	if sealed {
+		fields.Sealed = base64.StdEncoding.EncodeToString(body)
+	} else {
+		fields.Data = base64.StdEncoding.EncodeToString(body)
+	}
+	payload, _ := json.Marshal(fields)
+	var b bytes.Buffer
+	b.WriteString("POST " + front.Path + " HTTP/1.1\r\n")
+	b.WriteString("Host: " + front.Host + "\r\n")
+	b.WriteString("Content-Type: application/json\r\n")
+	b.WriteString("Content-Length: " + strconv.Itoa(len(payload)) + "\r\n\r\n")
+	b.Write(payload)
+	return b.Bytes()
+}
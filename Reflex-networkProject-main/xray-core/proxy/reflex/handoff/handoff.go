@@ -0,0 +1,31 @@
+// Package handoff moves established Reflex sessions between processes on the
+// same host, so a restarted server can keep serving the users of the old one.
+//
+// The new process binds the same ports (listeners use SO_REUSEPORT) and
+// listens on a Unix socket. When the old process shuts down it connects to
+// that socket and sends, per session, the TCP file descriptor together with
+// a JSON State.
+package handoff
+
+import (
+	"errors"
+	"time"
+
+	"github.com/xtls/xray-core/proxy/reflex"
+)
+
+// State travels with each connection.
+type State struct {
+	Session *reflex.SessionState `json:"session"`
+	User    string               `json:"user"` // account ID
+	Grant   *reflex.PolicyGrant  `json:"grant"`
+	// Established is when the session's handshake completed, which its
+	// lifetime counts from.
+	Established time.Time `json:"established"`
+	// Pending holds bytes already received from the client but not yet
+	// decoded, including a partially read frame.
+	Pending []byte `json:"pending,omitempty"`
+}
+
+// ErrUnsupported is returned on platforms that cannot pass file descriptors.
+var ErrUnsupported = errors.New("reflex: session handoff is not supported on this platform")
@@ -0,0 +1,28 @@
+//go:build !unix
+
+package handoff
+
+import "net"
+
+// Send is not supported on this platform.
+func Send(path string, conn *net.TCPConn, state *State) error {
+	return ErrUnsupported
+}
+
+// Listener is not supported on this platform.
+type Listener struct{}
+
+// Listen is not supported on this platform.
+func Listen(path string) (*Listener, error) {
+	return nil, ErrUnsupported
+}
+
+// Accept is not supported on this platform.
+func (l *Listener) Accept() (net.Conn, *State, error) {
+	return nil, nil, ErrUnsupported
+}
+
+// Close is not supported on this platform.
+func (l *Listener) Close() error {
+	return ErrUnsupported
+}
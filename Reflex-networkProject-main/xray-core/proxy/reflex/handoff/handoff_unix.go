@@ -0,0 +1,136 @@
+//go:build unix
+
+package handoff
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// Send passes conn and its state to the process listening on path. The
+// caller keeps its own copy of conn and should close it once Send returns;
+// the connection stays open in the receiving process.
+func Send(path string, conn *net.TCPConn, state *State) error {
+	payload, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	f, err := conn.File()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	c, err := net.DialUnix("unix", nil, &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if _, _, err := c.WriteMsgUnix(payload, syscall.UnixRights(int(f.Fd())), nil); err != nil {
+		return err
+	}
+	return c.CloseWrite()
+}
+
+// Listener accepts connections handed off by another process.
+type Listener struct {
+	l *net.UnixListener
+}
+
+// Listen listens on path, taking it over from a previous process: a socket
+// left at path is unlinked first, and the new one is not removed on Close so
+// that it keeps pointing to whichever process listened last. Anything else at
+// path is left alone and fails Listen. The socket is created accessible to
+// its owner only.
+func Listen(path string) (*Listener, error) {
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, errors.New("reflex: handoff path " + path + " exists and is not a socket")
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	// The umask is the process's, so other files created meanwhile are at
+	// worst created more private than asked for.
+	mask := syscall.Umask(0o177)
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	syscall.Umask(mask)
+	if err != nil {
+		return nil, err
+	}
+	l.SetUnlinkOnClose(false)
+	return &Listener{l: l}, nil
+}
+
+// Accept returns the next handed off connection. Handoffs from processes
+// of another user than this one's are refused.
+func (l *Listener) Accept() (net.Conn, *State, error) {
+	c, err := l.l.AcceptUnix()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer c.Close()
+	uid, err := peerUID(c)
+	if err != nil {
+		return nil, nil, err
+	}
+	if int(uid) != os.Geteuid() {
+		return nil, nil, errors.New("reflex: handoff refused from user " + strconv.FormatUint(uint64(uid), 10))
+	}
+
+	buf := make([]byte, 64*1024)
+	oob := make([]byte, syscall.CmsgSpace(4))
+	n, oobn, _, _, err := c.ReadMsgUnix(buf, oob)
+	if err != nil {
+		return nil, nil, err
+	}
+	conn, err := fileConn(oob[:oobn])
+	if err != nil {
+		return nil, nil, err
+	}
+	rest, err := io.ReadAll(c)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	state := &State{}
+	if err := json.Unmarshal(append(buf[:n], rest...), state); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return conn, state, nil
+}
+
+// Close stops accepting handoffs.
+func (l *Listener) Close() error {
+	return l.l.Close()
+}
+
+// fileConn turns the descriptor carried in oob into a net.Conn.
+func fileConn(oob []byte) (net.Conn, error) {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil, err
+	}
+	if len(msgs) != 1 {
+		return nil, errors.New("reflex: handoff carries no connection")
+	}
+	fds, err := syscall.ParseUnixRights(&msgs[0])
+	if err != nil {
+		return nil, err
+	}
+	if len(fds) != 1 {
+		return nil, errors.New("reflex: handoff carries no connection")
+	}
+	f := os.NewFile(uintptr(fds[0]), "reflex-handoff")
+	defer f.Close()
+	return net.FileConn(f)
+}
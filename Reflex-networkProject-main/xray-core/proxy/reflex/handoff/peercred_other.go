@@ -0,0 +1,13 @@
+//go:build unix && !linux && !darwin && !freebsd
+
+package handoff
+
+import (
+	"errors"
+	"net"
+)
+
+// peerUID cannot tell the peer on this platform, so no handoff is trusted.
+func peerUID(c *net.UnixConn) (uint32, error) {
+	return 0, errors.New("reflex: handoff peer credentials are not supported on this platform")
+}
@@ -0,0 +1,42 @@
+package reflex
+
+import (
+	"crypto/rand"
+	"math/big"
+
+	"github.com/xtls/xray-core/proxy/reflex/handshake"
+)
+
+// HandshakeMagic ("REFX") starts a client handshake, see package handshake
+// for the layout.
+const HandshakeMagic = handshake.Magic
+
+// HandshakeResponse is the JSON body of the server's answer to a handshake.
+// KeyConfirm is KeyConfirmation over the handshake transcript, and
+// ServerSignature, from servers with an identity key, SignHandshake over
+// it. When RetryCookie is set the handshake was not processed: the client
+// must reconnect and echo the cookie via CookiePadding.
+type HandshakeResponse struct {
+	PublicKey       [32]byte `json:"public_key"`
+	PolicyGrant     []byte   `json:"policy_grant"`
+	KeyConfirm      []byte   `json:"key_confirm"`
+	ServerSignature []byte   `json:"server_signature,omitempty"`
+	RetryCookie     []byte   `json:"retry_cookie,omitempty"`
+}
+
+// MaxHandshakePadding bounds the random padding a client appends to its
+// handshake. Servers reject handshakes carrying more.
+const MaxHandshakePadding = handshake.MaxPadding
+
+// NewHandshakePadding returns between 0 and MaxHandshakePadding random bytes.
+// Clients append it to every handshake so packet sizes vary per connection
+// instead of always being 78 bytes plus the policy request.
+func NewHandshakePadding() []byte {
+	n, err := rand.Int(rand.Reader, big.NewInt(MaxHandshakePadding+1))
+	if err != nil {
+		return nil
+	}
+	padding := make([]byte, n.Int64())
+	_, _ = rand.Read(padding)
+	return padding
+}
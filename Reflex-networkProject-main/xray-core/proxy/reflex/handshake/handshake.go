@@ -0,0 +1,139 @@
+// Package handshake encodes and decodes the client handshake of Reflex
+// without any cryptography:
+//
+//	magic(4) | pub(32) | user(16) | ts(8) | nonce(16) | policyLen(2) | policyReq | padLen(2) | padding
+//
+// or, sealed, see SealedMagic, with the user, timestamp and policy request
+// encrypted.
+//
+// Everything after the magic number is the body. A magic-number handshake
+// sends the body right after the magic; an HTTP handshake sends it base64
+// encoded in a POST. The body is also what the handshake transcript covers.
+//
+// The package is shared by the inbound, the client and tooling that needs
+// the exact same layout.
+package handshake
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Magic ("REFX") starts a magic-number handshake.
+const Magic uint32 = 0x5246584C
+
+// FixedSize is the length of the fixed part of the body: pub (32) + user
+// (16) + timestamp (8) + nonce (16).
+const FixedSize = 32 + 16 + 8 + 16
+
+// MaxPadding bounds the padding of a handshake.
+const MaxPadding = 512
+
+// ErrPaddingTooLarge is returned for a handshake announcing more than
+// MaxPadding bytes of padding.
+var ErrPaddingTooLarge = errors.New("reflex: handshake padding too large")
+
+// Client is the handshake a client opens a connection with.
+type Client struct {
+	PublicKey [32]byte
+	UserID    [16]byte
+	Timestamp int64 // unix seconds
+	Nonce     [16]byte
+	PolicyReq []byte
+	Padding   []byte
+}
+
+// MarshalBody encodes the handshake without the magic number. PolicyReq and
+// Padding must each be shorter than 64 KiB.
+func (c *Client) MarshalBody() []byte {
+	b := make([]byte, 0, FixedSize+2+len(c.PolicyReq)+2+len(c.Padding))
+	b = append(b, c.PublicKey[:]...)
+	b = append(b, c.UserID[:]...)
+	b = binary.BigEndian.AppendUint64(b, uint64(c.Timestamp))
+	b = append(b, c.Nonce[:]...)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(c.PolicyReq)))
+	b = append(b, c.PolicyReq...)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(c.Padding)))
+	return append(b, c.Padding...)
+}
+
+// Marshal encodes the handshake with the magic number.
+func (c *Client) Marshal() []byte {
+	return append(binary.BigEndian.AppendUint32(nil, Magic), c.MarshalBody()...)
+}
+
+// Unmarshal decodes a handshake body, which must be exactly one handshake.
+func Unmarshal(b []byte) (*Client, error) {
+	c := &Client{}
+	if len(b) < FixedSize+2 {
+		return nil, errors.New("reflex: handshake packet too short")
+	}
+	offset := 0
+	copy(c.PublicKey[:], b[offset:offset+32])
+	offset += 32
+	copy(c.UserID[:], b[offset:offset+16])
+	offset += 16
+	c.Timestamp = int64(binary.BigEndian.Uint64(b[offset : offset+8]))
+	offset += 8
+	copy(c.Nonce[:], b[offset:offset+16])
+	offset += 16
+	policyLen := int(binary.BigEndian.Uint16(b[offset : offset+2]))
+	offset += 2
+	if len(b) < offset+policyLen+2 {
+		return nil, errors.New("reflex: handshake policy request truncated")
+	}
+	if policyLen > 0 {
+		c.PolicyReq = append([]byte(nil), b[offset:offset+policyLen]...)
+	}
+	offset += policyLen
+	padLen := int(binary.BigEndian.Uint16(b[offset : offset+2]))
+	offset += 2
+	if padLen > MaxPadding {
+		return nil, ErrPaddingTooLarge
+	}
+	if len(b) != offset+padLen {
+		return nil, errors.New("reflex: handshake padding length mismatch")
+	}
+	if padLen > 0 {
+		c.Padding = append([]byte(nil), b[offset:]...)
+	}
+	return c, nil
+}
+
+// ReadBody reads one handshake body from r, which is positioned right after
+// the magic number. The padding length is checked before the padding is
+// read, so an oversized handshake fails with ErrPaddingTooLarge without
+// waiting for it.
+func ReadBody(r io.Reader) ([]byte, error) {
+	return readBody(r, FixedSize)
+}
+
+// readBody reads a body of a fixed part of fixedSize bytes followed by a
+// part of its own length and the padding.
+func readBody(r io.Reader, fixedSize int) ([]byte, error) {
+	b := make([]byte, fixedSize+2)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	n := int(binary.BigEndian.Uint16(b[fixedSize:]))
+	b, err := readAppend(r, b, n+2)
+	if err != nil {
+		return nil, err
+	}
+	padLen := int(binary.BigEndian.Uint16(b[len(b)-2:]))
+	if padLen > MaxPadding {
+		return nil, ErrPaddingTooLarge
+	}
+	return readAppend(r, b, padLen)
+}
+
+// readAppend reads exactly n more bytes from r onto b.
+func readAppend(r io.Reader, b []byte, n int) ([]byte, error) {
+	out := make([]byte, len(b)+n)
+	copy(out, b)
+	if _, err := io.ReadFull(r, out[len(b):]); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
@@ -0,0 +1,116 @@
+package handshake
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// SealedMagic ("RFXS") starts a sealed handshake, whose user ID, timestamp
+// and policy request are encrypted to the server:
+//
+//	magic(4) | pub(32) | nonce(16) | sealedLen(2) | sealed | padLen(2) | padding
+//
+// sealed is the secret part of the handshake, see Client.Secret, encrypted
+// by the client; this package leaves it as it is. As with Magic, an HTTP
+// handshake sends the body in a POST instead, and the body is what the
+// handshake transcript covers.
+const SealedMagic uint32 = 0x52465853
+
+// SealedFixedSize is the length of the fixed part of a sealed body: pub (32)
+// + nonce (16).
+const SealedFixedSize = 32 + 16
+
+// secretFixedSize is the length of the fixed part of the secret part of a
+// handshake: user (16) + timestamp (8).
+const secretFixedSize = 16 + 8
+
+// Sealed is a sealed handshake.
+type Sealed struct {
+	PublicKey [32]byte
+	Nonce     [16]byte
+	Sealed    []byte
+	Padding   []byte
+}
+
+// MarshalBody encodes the handshake without the magic number. Sealed and
+// Padding must each be shorter than 64 KiB.
+func (s *Sealed) MarshalBody() []byte {
+	b := make([]byte, 0, SealedFixedSize+2+len(s.Sealed)+2+len(s.Padding))
+	b = append(b, s.PublicKey[:]...)
+	b = append(b, s.Nonce[:]...)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s.Sealed)))
+	b = append(b, s.Sealed...)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s.Padding)))
+	return append(b, s.Padding...)
+}
+
+// Marshal encodes the handshake with the magic number.
+func (s *Sealed) Marshal() []byte {
+	return append(binary.BigEndian.AppendUint32(nil, SealedMagic), s.MarshalBody()...)
+}
+
+// UnmarshalSealed decodes a sealed handshake body, which must be exactly one
+// handshake.
+func UnmarshalSealed(b []byte) (*Sealed, error) {
+	s := &Sealed{}
+	if len(b) < SealedFixedSize+2 {
+		return nil, errors.New("reflex: sealed handshake too short")
+	}
+	copy(s.PublicKey[:], b[:32])
+	copy(s.Nonce[:], b[32:SealedFixedSize])
+	offset := SealedFixedSize
+	sealedLen := int(binary.BigEndian.Uint16(b[offset:]))
+	offset += 2
+	if len(b) < offset+sealedLen+2 {
+		return nil, errors.New("reflex: sealed handshake truncated")
+	}
+	s.Sealed = append([]byte(nil), b[offset:offset+sealedLen]...)
+	offset += sealedLen
+	padLen := int(binary.BigEndian.Uint16(b[offset:]))
+	offset += 2
+	if padLen > MaxPadding {
+		return nil, ErrPaddingTooLarge
+	}
+	if len(b) != offset+padLen {
+		return nil, errors.New("reflex: handshake padding length mismatch")
+	}
+	if padLen > 0 {
+		s.Padding = append([]byte(nil), b[offset:]...)
+	}
+	return s, nil
+}
+
+// ReadSealedBody is ReadBody for a sealed handshake.
+func ReadSealedBody(r io.Reader) ([]byte, error) {
+	return readBody(r, SealedFixedSize)
+}
+
+// Secret returns the part of the handshake a sealed handshake encrypts:
+//
+//	user(16) | ts(8) | policyLen(2) | policyReq
+func (c *Client) Secret() []byte {
+	b := make([]byte, 0, secretFixedSize+2+len(c.PolicyReq))
+	b = append(b, c.UserID[:]...)
+	b = binary.BigEndian.AppendUint64(b, uint64(c.Timestamp))
+	b = binary.BigEndian.AppendUint16(b, uint16(len(c.PolicyReq)))
+	return append(b, c.PolicyReq...)
+}
+
+// Opened returns the handshake s is, given its secret part once decrypted.
+func (s *Sealed) Opened(secret []byte) (*Client, error) {
+	if len(secret) < secretFixedSize+2 || len(secret) != secretFixedSize+2+int(binary.BigEndian.Uint16(secret[secretFixedSize:])) {
+		return nil, errors.New("reflex: malformed sealed handshake")
+	}
+	c := &Client{
+		PublicKey: s.PublicKey,
+		Timestamp: int64(binary.BigEndian.Uint64(secret[16:secretFixedSize])),
+		Nonce:     s.Nonce,
+		Padding:   s.Padding,
+	}
+	copy(c.UserID[:], secret[:16])
+	if policy := secret[secretFixedSize+2:]; len(policy) > 0 {
+		c.PolicyReq = append([]byte(nil), policy...)
+	}
+	return c, nil
+}
@@ -0,0 +1,35 @@
+package reflex
+
+// SessionHooks holds optional callbacks that observe protocol activity on a
+// Session. Any field may be nil. Hooks run synchronously on the goroutine that
+// reads or writes the frame, so they should return quickly and must not call
+// back into the same Session.
+type SessionHooks struct {
+	// OnFrameRead is called for every frame that was successfully decrypted
+	// and passed replay checks.
+	OnFrameRead func(frame *Frame)
+	// OnFrameWrite is called after a frame has been written to the wire.
+	OnFrameWrite func(frameType uint8, payload []byte)
+	// OnControl is called for every PADDING_CTRL or TIMING_CTRL frame read,
+	// after OnFrameRead.
+	OnControl func(frame *Frame)
+}
+
+// SetHooks installs hooks on the session, replacing any previously set.
+// Passing a zero SessionHooks removes all hooks.
+func (s *Session) SetHooks(hooks SessionHooks) {
+	s.mu.Lock()
+	s.hooks = hooks
+	s.mu.Unlock()
+}
+
+func (s *Session) getHooks() SessionHooks {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.hooks
+}
+
+// IsControlFrame reports whether frameType is one of the morphing control frames.
+func IsControlFrame(frameType uint8) bool {
+	return frameType == FrameTypePaddingCtrl || frameType == FrameTypeTimingCtrl
+}
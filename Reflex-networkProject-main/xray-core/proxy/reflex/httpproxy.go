@@ -0,0 +1,147 @@
+package reflex
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// HTTPProxyServer is a local HTTP proxy that tunnels every connection it
+// accepts over a Reflex session, for applications that only support HTTP
+// proxies. It serves CONNECT requests and plain requests in absolute-URI
+// form, one request per connection for the latter. Like SOCKS5Server it
+// should listen on a loopback address.
+type HTTPProxyServer struct {
+	// Dial opens the session for each accepted connection.
+	Dial TunnelDialer
+	// Timeout bounds reading the request head and Dial. Zero means
+	// DefaultFrontEndTimeout.
+	Timeout time.Duration
+
+	mu    sync.Mutex
+	ln    net.Listener
+	conns sync.WaitGroup
+}
+
+// ListenAndServe listens on the TCP address addr and calls Serve.
+func (s *HTTPProxyServer) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ln)
+}
+
+// Serve accepts connections on ln until Close is called, and returns nil
+// then.
+func (s *HTTPProxyServer) Serve(ln net.Listener) error {
+	return serveFrontEnd(&s.mu, &s.ln, &s.conns, ln, s.serveConn)
+}
+
+// Close stops the server and waits for the tunnels it opened to end. Live
+// tunnels are not interrupted.
+func (s *HTTPProxyServer) Close() error {
+	return closeFrontEnd(&s.mu, &s.ln, &s.conns)
+}
+
+func (s *HTTPProxyServer) serveConn(conn net.Conn) {
+	timeout := s.Timeout
+	if timeout == 0 {
+		timeout = DefaultFrontEndTimeout
+	}
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+	br := bufio.NewReader(conn)
+	req, err := http.ReadRequest(br)
+	if err != nil {
+		_ = conn.Close()
+		return
+	}
+	var address string
+	if req.Method == http.MethodConnect && req.Host != "" {
+		address = withDefaultPort(req.Host, "443")
+	} else if req.URL.Scheme == "http" && req.URL.Host != "" {
+		address = withDefaultPort(req.URL.Host, "80")
+	} else {
+		writeProxyStatus(conn, http.StatusBadRequest)
+		_ = conn.Close()
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	c, err := s.Dial(ctx, address)
+	cancel()
+	if err != nil {
+		writeProxyStatus(conn, http.StatusBadGateway)
+		_ = conn.Close()
+		return
+	}
+	if req.Method == http.MethodConnect {
+		_, err = io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+	} else {
+		err = forwardProxyRequest(c, req)
+	}
+	if err != nil {
+		_ = conn.Close()
+		_ = c.Close()
+		return
+	}
+	_ = conn.SetDeadline(time.Time{})
+	Tunnel(&bufferedConn{Conn: conn, r: br}, c)
+}
+
+// forwardProxyRequest sends a plain proxy request on to its origin in
+// origin form, without the headers meant for the proxy. The request body is
+// sent along with it.
+func forwardProxyRequest(c *ClientConn, req *http.Request) error {
+	req.Header.Del("Proxy-Connection")
+	req.Header.Del("Proxy-Authorization")
+	// One request per connection: the origin closes it after answering.
+	req.Close = true
+	w := bufio.NewWriterSize(tunnelWriter{c}, tunnelChunk)
+	if err := req.Write(w); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+func writeProxyStatus(conn net.Conn, code int) {
+	_, _ = io.WriteString(conn, "HTTP/1.1 "+strconv.Itoa(code)+" "+http.StatusText(code)+"\r\nConnection: close\r\n\r\n")
+}
+
+func withDefaultPort(host, port string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// tunnelWriter sends what is written to it as DATA frames.
+type tunnelWriter struct {
+	c *ClientConn
+}
+
+func (w tunnelWriter) Write(b []byte) (int, error) {
+	for sent := 0; sent < len(b); {
+		n := min(len(b)-sent, tunnelChunk)
+		if err := w.c.WriteFrame(FrameTypeData, b[sent:sent+n]); err != nil {
+			return sent, err
+		}
+		sent += n
+	}
+	return len(b), nil
+}
+
+// bufferedConn reads through r, which may hold bytes already read from Conn.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
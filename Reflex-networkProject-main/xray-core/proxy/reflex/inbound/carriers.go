@@ -0,0 +1,66 @@
+package inbound
+
+import (
+	"context"
+	"crypto/tls"
+	stdnet "net"
+
+	"github.com/xtls/xray-core/features/routing"
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/proxy/reflex/carrier"
+)
+
+// listenCarriers opens the carrier listeners config asks for. Their
+// connections are served with dispatcher as if accepted on the inbound's
+// port.
+func (h *Handler) listenCarriers(ctx context.Context, config *reflex.Carriers, dispatcher routing.Dispatcher) error {
+	if q := config.GetQuic(); q != nil {
+		cert, err := tls.LoadX509KeyPair(q.CertificateFile, q.KeyFile)
+		if err != nil {
+			return err
+		}
+		ln, err := carrier.ListenQUIC(q.Listen, &tls.Config{Certificates: []tls.Certificate{cert}}, carrier.InboundHandler(ctx, h, dispatcher))
+		if err != nil {
+			return err
+		}
+		h.quic = ln
+	}
+	if u := config.GetUdp(); u != nil {
+		fec, err := carrier.FECOf(u.FecData, u.FecParity)
+		if err != nil {
+			return err
+		}
+		ln, err := carrier.ListenUDP(u.Listen, fec, carrier.InboundHandler(ctx, h, dispatcher))
+		if err != nil {
+			return err
+		}
+		h.udp = ln
+	}
+	return nil
+}
+
+// closeCarriers stops accepting carrier connections.
+func (h *Handler) closeCarriers() {
+	if h.quic != nil {
+		_ = h.quic.Close()
+	}
+	if h.udp != nil {
+		_ = h.udp.Close()
+	}
+}
+
+// QUICAddr returns the address the QUIC carrier is served on, or nil.
+func (h *Handler) QUICAddr() stdnet.Addr {
+	if h.quic == nil {
+		return nil
+	}
+	return h.quic.Addr()
+}
+
+// UDPAddr returns the address the UDP carrier is served on, or nil.
+func (h *Handler) UDPAddr() stdnet.Addr {
+	if h.udp == nil {
+		return nil
+	}
+	return h.udp.Addr()
+}
@@ -0,0 +1,156 @@
+package inbound
+
+import (
+	"errors"
+
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/transport/internet/stat"
+)
+
+// PushControl sends a PADDING_CTRL or TIMING_CTRL frame to the live sessions
+// of the user with the given email, or of every user if email is empty, so
+// that their clients adjust the shape of what they send. It returns how many
+// sessions the frame was sent to.
+func (h *Handler) PushControl(email string, frameType uint8, payload []byte) (int, error) {
+	if !reflex.IsControlFrame(frameType) {
+		return 0, errors.New("reflex: not a morphing control frame")
+	}
+	return h.push(email, func(session *reflex.Session, conn stat.Connection) error {
+		return session.WriteFrame(conn, frameType, payload)
+	}), nil
+}
+
+// PushProfile tells the clients of the live sessions of the user with the
+// given email, or of every user if email is empty, to shape what they send
+// with profile from now on. It returns how many sessions were told.
+func (h *Handler) PushProfile(email string, profile *reflex.TrafficProfile) (int, error) {
+	if err := profile.Validate(); err != nil {
+		return 0, err
+	}
+	return h.push(email, func(session *reflex.Session, conn stat.Connection) error {
+		return reflex.PushProfile(session, conn, profile)
+	}), nil
+}
+
+// KickUser terminates the live sessions of the user with the given email,
+// telling their clients the reason code, see reflex.KickReason. The user
+// stays configured and may connect again. It returns how many sessions were
+// terminated.
+func (h *Handler) KickUser(email, reason string) int {
+	return h.terminateUsers(map[string]bool{email: true}, reflex.KickReason(reason))
+}
+
+// RevokeResumption stops the live sessions of the user with the given email
+// from being resumed or given more bonding lanes, so that resumption keys
+// taken from a compromised client are useless, while the sessions go on on
+// the connections they have. It returns how many sessions were revoked.
+func (h *Handler) RevokeResumption(email string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	revoked := 0
+	for _, live := range h.sessions {
+		if live.user == email && !live.revoked {
+			live.revoked = true
+			revoked++
+		}
+	}
+	return revoked
+}
+
+// push runs send on the live sessions of a user, or of every user if email
+// is empty, and returns on how many it succeeded. A session whose connection
+// fails is left to notice that itself.
+func (h *Handler) push(email string, send func(*reflex.Session, stat.Connection) error) int {
+	h.mu.Lock()
+	targets := make(map[*reflex.Session]stat.Connection)
+	for session, live := range h.sessions {
+		if email == "" || live.user == email {
+			targets[session] = live.conn
+		}
+	}
+	h.mu.Unlock()
+	sent := 0
+	for session, conn := range targets {
+		if send(session, conn) == nil {
+			sent++
+		}
+	}
+	return sent
+}
+
+// maxRolloutProfiles bounds the profiles a handler rolls out, since every new
+// session is offered all of them.
+const maxRolloutProfiles = 32
+
+// RolloutProfile offers profile to the clients of every live session and of
+// every session started from now on, so that newly captured profiles reach
+// clients without a change to their configuration. From then on the handler
+// resolves the name to it, so grants and profile schedules may use it.
+// Clients may refuse it. A profile rolled out again under the same name
+// replaces the earlier one. It returns how many live sessions were offered
+// the profile.
+func (h *Handler) RolloutProfile(profile *reflex.TrafficProfile) (int, error) {
+	if err := profile.Validate(); err != nil {
+		return 0, err
+	}
+	if profile.Name == "" || reflex.Profiles[profile.Name] != nil {
+		return 0, errors.New("reflex: a rolled out profile needs a name no built-in profile has")
+	}
+	h.mu.Lock()
+	if _, ok := h.rollout[profile.Name]; !ok && len(h.rollout) >= maxRolloutProfiles {
+		h.mu.Unlock()
+		return 0, errors.New("reflex: too many profiles rolled out")
+	}
+	if h.rollout == nil {
+		h.rollout = make(map[string]*reflex.TrafficProfile)
+	}
+	h.rollout[profile.Name] = profile
+	h.mu.Unlock()
+	return h.push("", func(session *reflex.Session, conn stat.Connection) error {
+		return reflex.OfferProfile(session, conn, profile)
+	}), nil
+}
+
+// rolledOut returns the profiles rolled out so far.
+func (h *Handler) rolledOut() []*reflex.TrafficProfile {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	profiles := make([]*reflex.TrafficProfile, 0, len(h.rollout))
+	for _, p := range h.rollout {
+		profiles = append(profiles, p)
+	}
+	return profiles
+}
+
+// lookupProfile returns the profile called name: a rolled out one or a
+// built-in one, or nil.
+func (h *Handler) lookupProfile(name string) *reflex.TrafficProfile {
+	h.mu.Lock()
+	p := h.rollout[name]
+	h.mu.Unlock()
+	if p != nil {
+		return p
+	}
+	return reflex.Profiles[name]
+}
+
+// destinationProfile returns the name and the profile the destination
+// profiles of the configuration pick for a stream that starts with b, or a
+// nil profile if there are none, the destination cannot be told from b or
+// the profile is unknown.
+func (h *Handler) destinationProfile(b []byte) (string, *reflex.TrafficProfile) {
+	return h.hostProfile(reflex.SniffHost(b))
+}
+
+// hostProfile is destinationProfile for a stream to host.
+func (h *Handler) hostProfile(host string) (string, *reflex.TrafficProfile) {
+	table := h.settings.Load().destProfiles
+	if table == nil {
+		return "", nil
+	}
+	name, ok := table.Lookup(host)
+	if !ok {
+		return "", nil
+	}
+	return name, h.lookupProfile(name)
+}
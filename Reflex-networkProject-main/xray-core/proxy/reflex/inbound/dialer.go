@@ -0,0 +1,110 @@
+package inbound
+
+import (
+	"context"
+	"errors"
+	stdnet "net"
+
+	"github.com/xtls/xray-core/common"
+	"github.com/xtls/xray-core/common/buf"
+	"github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/core"
+	"github.com/xtls/xray-core/features/routing"
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/transport"
+	"github.com/xtls/xray-core/transport/internet/stat"
+	"github.com/xtls/xray-core/transport/pipe"
+)
+
+// Dialer connects to the destinations of session data. It is all a Handler
+// needs of a backend outside Xray, see NewWithDialer, where there is no
+// routing.Dispatcher to route the data with.
+type Dialer interface {
+	Dial(ctx context.Context, dest net.Destination) (stdnet.Conn, error)
+}
+
+// DialerFunc is a Dialer as a function.
+type DialerFunc func(ctx context.Context, dest net.Destination) (stdnet.Conn, error)
+
+// Dial implements Dialer.
+func (f DialerFunc) Dial(ctx context.Context, dest net.Destination) (stdnet.Conn, error) {
+	return f(ctx, dest)
+}
+
+// DirectDialer dials every destination directly.
+var DirectDialer Dialer = DialerFunc(func(ctx context.Context, dest net.Destination) (stdnet.Conn, error) {
+	var dialer stdnet.Dialer
+	return dialer.DialContext(ctx, dest.Network.SystemString(), dest.NetAddr())
+})
+
+// NewDispatcher returns a routing.Dispatcher whose links are connections of
+// dialer. A link ends as a whole, once either its writer is closed or the
+// connection ends, as the relays of a session do; it is not half-closed.
+func NewDispatcher(dialer Dialer) routing.Dispatcher {
+	return &dialerDispatcher{dialer: dialer}
+}
+
+type dialerDispatcher struct {
+	dialer Dialer
+}
+
+func (d *dialerDispatcher) Type() interface{} { return routing.DispatcherType() }
+func (d *dialerDispatcher) Start() error      { return nil }
+func (d *dialerDispatcher) Close() error      { return nil }
+
+// Dispatch implements routing.Dispatcher.
+func (d *dialerDispatcher) Dispatch(ctx context.Context, dest net.Destination) (*transport.Link, error) {
+	upReader, upWriter := pipe.New(pipe.WithoutSizeLimit())
+	downReader, downWriter := pipe.New(pipe.WithoutSizeLimit())
+	if err := d.DispatchLink(ctx, dest, &transport.Link{Reader: upReader, Writer: downWriter}); err != nil {
+		return nil, err
+	}
+	return &transport.Link{Reader: downReader, Writer: upWriter}, nil
+}
+
+// DispatchLink implements routing.Dispatcher.
+func (d *dialerDispatcher) DispatchLink(ctx context.Context, dest net.Destination, link *transport.Link) error {
+	conn, err := d.dialer.Dial(ctx, dest)
+	if err != nil {
+		return err
+	}
+	go func() {
+		_ = buf.Copy(link.Reader, buf.NewWriter(conn))
+		_ = conn.Close()
+	}()
+	go func() {
+		_ = buf.Copy(buf.NewReader(conn), link.Writer)
+		_ = conn.Close()
+		common.Close(link.Writer)
+	}()
+	return nil
+}
+
+// NewWithDialer returns a Reflex inbound for config that relays the data of
+// its sessions over connections of dialer, for serving Reflex from a program
+// of its own instead of from Xray, see Serve.
+func NewWithDialer(ctx context.Context, config *reflex.InboundConfig, dialer Dialer) (*Handler, error) {
+	in, err := New(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	h := in.(*Handler)
+	h.backend = NewDispatcher(dialer)
+	// Without Xray, New has not served the carriers for want of a dispatcher.
+	if config.Carriers != nil && core.FromContext(ctx) == nil {
+		if err := h.listenCarriers(ctx, config.Carriers, h.backend); err != nil {
+			_ = h.Close()
+			return nil, err
+		}
+	}
+	return h, nil
+}
+
+// Serve serves a connection accepted by the program that made the handler
+// with NewWithDialer, like Process does for Xray.
+func (h *Handler) Serve(ctx context.Context, conn stdnet.Conn) error {
+	if h.backend == nil {
+		return errors.New("reflex: handler has no dialer, see NewWithDialer")
+	}
+	return h.Process(ctx, net.Network_TCP, stat.Connection(conn), h.backend)
+}
@@ -0,0 +1,94 @@
+package inbound
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/transport/internet/stat"
+)
+
+// maxFeedbackBody bounds one batch of classifier verdicts.
+const maxFeedbackBody = 1 << 20
+
+// FeedbackStatus is the JSON body served on the feedback path.
+type FeedbackStatus struct {
+	Profiles  []reflex.ProfileFeedback `json:"profiles"`            // least flagged first
+	Suggested string                   `json:"suggested,omitempty"` // see ClassifierFeedback.Suggest
+}
+
+// isFeedbackRequest reports whether the connection starts with a POST or GET
+// for the feedback path. Only the request line is peeked, so anything else
+// continues through detection untouched.
+func (h *Handler) isFeedbackRequest(reader *bufio.Reader) bool {
+	return hasRequestLine(reader, "POST", h.feedbackPath) || hasRequestLine(reader, "GET", h.feedbackPath)
+}
+
+// hasRequestLine reports whether the connection starts with an HTTP request
+// line for method and path, without consuming it.
+func hasRequestLine(reader *bufio.Reader, method, path string) bool {
+	prefix := []byte(method + " " + path + " ")
+	for {
+		// Wait for one byte more than already buffered, until the prefix is decided.
+		b, err := reader.Peek(min(reader.Buffered()+1, len(prefix)))
+		if !bytes.HasPrefix(prefix, b) {
+			return false
+		}
+		if len(b) == len(prefix) {
+			return true
+		}
+		if err != nil {
+			return false
+		}
+	}
+}
+
+// serveFeedback records the verdicts POSTed as a JSON array of
+// ClassifierVerdict and answers with the statistics so far. A GET only
+// reads the statistics.
+func (h *Handler) serveFeedback(reader *bufio.Reader, conn stat.Connection) error {
+	req, err := http.ReadRequest(reader)
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+	if req.Method == http.MethodPost {
+		var verdicts []reflex.ClassifierVerdict
+		if err := json.NewDecoder(io.LimitReader(req.Body, maxFeedbackBody)).Decode(&verdicts); err != nil {
+			return h.writeFeedbackError(conn, "malformed verdicts")
+		}
+		// A batch is taken whole or not at all.
+		for _, v := range verdicts {
+			if _, known := reflex.Profiles[v.Profile]; !known {
+				return h.writeFeedbackError(conn, "unknown profile "+v.Profile)
+			}
+		}
+		for _, v := range verdicts {
+			_ = h.feedback.Record(v)
+		}
+	}
+
+	st := FeedbackStatus{Profiles: h.feedback.Stats()}
+	st.Suggested, _ = h.feedback.Suggest()
+	body, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	if err := writeHTTPResponse(conn, "200 OK", body); err != nil {
+		_ = conn.Close()
+		return err
+	}
+	return conn.Close()
+}
+
+func (h *Handler) writeFeedbackError(conn stat.Connection, reason string) error {
+	body, _ := json.Marshal(map[string]string{"error": reason})
+	if err := writeHTTPResponse(conn, "400 Bad Request", body); err != nil {
+		_ = conn.Close()
+		return err
+	}
+	return conn.Close()
+}
@@ -0,0 +1,55 @@
+package inbound
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+
+	"github.com/xtls/xray-core/proxy/reflex"
+)
+
+// acceptsFront reports whether the POST at the head of reader is addressed
+// to a configured cover front. Nothing is consumed, so any other request
+// reaches the fallback intact. Without configured fronts every POST is taken
+// for a handshake.
+func (h *Handler) acceptsFront(reader *bufio.Reader) bool {
+	fronts := h.settings.Load().fronts
+	if len(fronts) == 0 {
+		return true
+	}
+	head, ok := peekRequestHead(reader)
+	if !ok {
+		return false
+	}
+	lines := strings.Split(string(head), "\r\n")
+	request := strings.Fields(lines[0])
+	if len(request) != 3 {
+		return false
+	}
+	for _, line := range lines[1:] {
+		name, value, found := strings.Cut(line, ":")
+		if found && strings.EqualFold(strings.TrimSpace(name), "Host") {
+			return reflex.MatchFront(fronts, strings.TrimSpace(value), request[1])
+		}
+	}
+	return false
+}
+
+// peekRequestHead peeks at the request line and headers of an HTTP request,
+// up to the blank line ending them. Heads larger than the reader's buffer
+// are not requests a client of ours would send.
+func peekRequestHead(reader *bufio.Reader) ([]byte, bool) {
+	for {
+		b, _ := reader.Peek(reader.Buffered())
+		if i := bytes.Index(b, []byte("\r\n\r\n")); i >= 0 {
+			return b[:i], true
+		}
+		if len(b) == reader.Size() {
+			return nil, false
+		}
+		// Wait for one byte more than already buffered.
+		if _, err := reader.Peek(len(b) + 1); err != nil {
+			return nil, false
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package inbound
+
+import (
+	"encoding/json"
+	"errors"
+	stdnet "net"
+	"net/http"
+	"time"
+
+	"github.com/xtls/xray-core/proxy/reflex"
+)
+
+// healthDialTimeout bounds the fallback reachability probe.
+const healthDialTimeout = time.Second
+
+// HealthStatus is the JSON body served on the health path.
+type HealthStatus struct {
+	Status   string `json:"status"`   // "ok", "degraded" or "draining"
+	Sessions int    `json:"sessions"` // live Reflex sessions
+	Fallback string `json:"fallback"` // "reachable", "unreachable" or "none"
+	Cipher   string `json:"cipher"`   // granted to clients that offer it, see reflex.PreferredCipher
+
+	// SuppressedLogs counts the events of each high-frequency kind that
+	// were not logged, see reflex.LogSampler.
+	SuppressedLogs map[string]uint64 `json:"suppressedLogs,omitempty"`
+
+	// Transports counts the handshake outcomes of each transport across
+	// all client networks over the last day, see Handler.TransportStats.
+	Transports map[string]reflex.TransportOutcomes `json:"transports,omitempty"`
+	// TransportMetrics compares the handshakes and sessions of each
+	// transport, see Handler.TransportMetrics.
+	TransportMetrics map[string]reflex.TransportMetrics `json:"transportMetrics,omitempty"`
+}
+
+// health reports the state of the handler.
+func (h *Handler) health() (HealthStatus, bool) {
+	h.mu.Lock()
+	st := HealthStatus{Status: "ok", Sessions: len(h.sessions), Cipher: h.cipher.String(), SuppressedLogs: h.logs.Suppressed()}
+	draining := h.draining
+	h.mu.Unlock()
+	st.Transports = h.transports.Totals(time.Now())
+	st.TransportMetrics = h.transports.Metrics()
+
+	st.Fallback = "none"
+	if fallback := h.settings.Load().fallback; fallback != nil {
+		st.Fallback = "reachable"
+		target, err := stdnet.DialTimeout("tcp", fallback.Target, healthDialTimeout)
+		if err != nil {
+			st.Fallback = "unreachable"
+			st.Status = "degraded"
+		} else {
+			_ = target.Close()
+		}
+	}
+	if draining {
+		st.Status = "draining"
+	}
+	return st, st.Status == "ok"
+}
+
+// listenHealth serves health checks on address, apart from the port
+// clients and probes reach: the state of the node is no one else's
+// business. The server lives until the handler is closed, drain included.
+func (h *Handler) listenHealth(address, path string) error {
+	if address == "" {
+		return errors.New("reflex: health_path needs health_listen, health is not served on the public port")
+	}
+	ln, err := stdnet.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	h.healthPath = path
+	h.healthAddr = ln.Addr()
+	h.healthServer = &http.Server{
+		Handler:           http.HandlerFunc(h.serveHealth),
+		ReadHeaderTimeout: ReflexHandshakeTimeout,
+	}
+	go func() { _ = h.healthServer.Serve(ln) }()
+	return nil
+}
+
+// HealthAddr returns the address health checks are served on, or nil.
+func (h *Handler) HealthAddr() stdnet.Addr {
+	return h.healthAddr
+}
+
+// serveHealth answers a GET for the health path. Load balancers take
+// anything but 200 as not ready.
+func (h *Handler) serveHealth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet || r.URL.Path != h.healthPath {
+		http.NotFound(w, r)
+		return
+	}
+	st, ok := h.health()
+	body, err := json.Marshal(st)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if !ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_, _ = w.Write(body)
+}
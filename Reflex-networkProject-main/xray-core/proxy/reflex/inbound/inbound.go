@@ -2,92 +2,152 @@ package inbound
 
 import (
 	"bufio"
+	"bytes"
 	"context"
+	"crypto/ed25519"
 	"crypto/rand"
-	"crypto/sha256"
 	"encoding/base64"
 	"encoding/binary"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"io"
 	stdnet "net"
+	"net/http"
+	"os"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/crypto/curve25519"
-	"golang.org/x/crypto/hkdf"
-	"google.golang.org/protobuf/proto"
 
 	"github.com/google/uuid"
 	"github.com/xtls/xray-core/common"
 	"github.com/xtls/xray-core/common/net"
 	"github.com/xtls/xray-core/common/protocol"
+	"github.com/xtls/xray-core/core"
+	xsession "github.com/xtls/xray-core/common/session"
 	"github.com/xtls/xray-core/proxy"
 	"github.com/xtls/xray-core/proxy/reflex"
-	"github.com/xtls/xray-core/common/buf"
+	"github.com/xtls/xray-core/proxy/reflex/capture"
+	"github.com/xtls/xray-core/proxy/reflex/carrier"
+	"github.com/xtls/xray-core/proxy/reflex/decoy"
+	"github.com/xtls/xray-core/proxy/reflex/handoff"
+	"github.com/xtls/xray-core/proxy/reflex/handshake"
+	xerrors "github.com/xtls/xray-core/common/errors"
+	"github.com/xtls/xray-core/common/log"
 	"github.com/xtls/xray-core/transport/internet/stat"
+	feature_inbound "github.com/xtls/xray-core/features/inbound"
 	"github.com/xtls/xray-core/features/routing"
+	"github.com/xtls/xray-core/features/stats"
 )
 
 // ReflexMagic is the magic number ("REFX") used for fast handshake detection.
-const ReflexMagic uint32 = 0x5246584C
+const ReflexMagic = reflex.HandshakeMagic
 
-// ReflexMinHandshakeSize is the minimum number of bytes we peek to decide protocol.
-const ReflexMinHandshakeSize = 64
+// ReflexHandshakeTimeout bounds how long a client may take to deliver its
+// handshake. Clients may fragment the handshake with jittered pauses; the
+// deadline only has to cover the whole first flight.
+const ReflexHandshakeTimeout = 15 * time.Second
 
+// Handler serves a Reflex inbound. An inbound listening on several ports
+// shares one Handler across its per-port workers, so users, replay, login and
+// policy state are common to all ports, and Close is called once per port.
 type Handler struct {
-	clients        []*protocol.MemoryUser
-	fallback       *FallbackConfig
+	settings       atomic.Pointer[settings] // replaced as a whole by Reload and the user manager
+	settingsMu     sync.Mutex               // serializes settings writers
 	defaultProfile *reflex.TrafficProfile
+	cookies        *reflex.CookieIssuer // non-nil when retry cookies are required
+	replay         *reflex.ReplayCache
+	oneTime        oneTimeIndex // one-time IDs of the clients, see reflex.OneTimeID
+	drainTimeout   time.Duration
+	handoffPath    string
+	handoffs       *handoff.Listener      // non-nil when sessions are handed off across restarts
+	hopper         *reflex.PortHopper     // non-nil when port hopping
+	quic           *carrier.QUICListener  // non-nil when the QUIC carrier is served, see listenCarriers
+	udp            *carrier.UDPListener   // non-nil when the UDP carrier is served
+	healthPath     string                 // serves HealthStatus to GET requests for this path
+	healthAddr     stdnet.Addr            // of healthServer
+	healthServer   *http.Server           // non-nil when health checks are served, see listenHealth
+	feedbackPath   string                 // takes classifier verdicts and serves their statistics
+	feedback       *reflex.ClassifierFeedback
+	interference   *reflex.InterferenceLog
+	transports     *reflex.TransportTelemetry // handshake outcomes per client network and transport
+	leakageAudit   bool                   // sessions are audited for what their wire shape reveals
+	pacing         bool                   // morphed frames keep to a schedule, see Session.SetPacing
+	vless          *vlessDelegate         // non-nil when VLESS clients share the port
+	logs           *reflex.LogSampler     // bounds the log volume of high-frequency events
+	cipher         reflex.Cipher          // granted to clients that offer it, see reflex.ChooseCipher
+	usage          *reflex.UsageMeter     // traffic of each user, for quotas and usage reports
+	overhead       *reflex.OverheadMeter  // payload, padding and cover traffic of each user
+	stats          stats.Manager          // nil outside a running instance
+	usageInterval  time.Duration          // least time between usage reports to a session
+	tag            atomic.Value           // inbound tag (string), learned from the first connection
+	decoy          *decoy.Server          // non-nil when the inbound serves its own cover site
+	stateFile      string                 // replay and login state is kept here across restarts
+	limits         *reflex.ResourceBudget // nil when unlimited
+	capture        *capture.Writer        // non-nil when sessions are captured to pcap
+	captureFile    *os.File               // closed once the handler is closed
+	captureUsers   map[string]bool        // captured users; empty captures all
+	keyLog         *os.File               // session keys are appended here when set
+	transcriptDir  string                 // a redacted transcript of every session is written here
+	transcripts    atomic.Uint64          // transcripts started, numbering their files
+	backend        routing.Dispatcher     // of Serve, see NewWithDialer
+	serverKey      ed25519.PrivateKey     // signs every handshake answered, see reflex.SignHandshake
+	requireSealed  bool                   // handshakes that carry the user ID in the clear are refused
+	done           chan struct{}          // closed by Close
+
+	mu        sync.Mutex
+	draining  bool                              // set by Close; no new sessions are accepted
+	deadline  time.Time                         // end of the drain grace period
+	sessions  map[*reflex.Session]*liveSession  // live sessions, for draining and termination
+	resumable map[[16]byte]*reflex.Session      // live sessions by resumption id, see handleResume
+	rollout   map[string]*reflex.TrafficProfile // profiles offered to every session, see RolloutProfile
+	active    sync.WaitGroup
+	leakage   []*reflex.LeakageReport // reports of the last audited sessions
 }
 
-// MemoryAccount implements protocol.Account for Reflex.
-type MemoryAccount struct {
-	Id string
-}
-
-// Equals implements protocol.Account.
-func (a *MemoryAccount) Equals(account protocol.Account) bool {
-	reflexAccount, ok := account.(*MemoryAccount)
-	if !ok {
-		return false
-	}
-	return a.Id == reflexAccount.Id
+// liveSession is a session being served.
+type liveSession struct {
+	conn    stat.Connection
+	user    string // email of the session's user
+	account *protocol.MemoryUser
+	grant   *reflex.PolicyGrant // in force, for resuming the session
+	moving  bool                // the client resumes the session on another connection
+	revoked bool                // the session may no longer be resumed, see RevokeResumption
+	ended   chan struct{}       // closed once the session is no longer served
+	// established is when the handshake completed; the grant's lifetime
+	// counts from it, whichever connection serves the session.
+	established time.Time
+	relays      *sessionRelays // taken over by the loop serving the session next
 }
 
-func (a *MemoryAccount) ToProto() proto.Message {
-	return &reflex.Account{
-		Id: a.Id,
-	}
-}
+// MemoryAccount implements protocol.Account for Reflex.
+type MemoryAccount = reflex.MemoryAccount
 
 type FallbackConfig struct {
-	Dest uint32
+	Dest   uint32
+	Target string        // host:port the fallback is dialed at, see reflex.Fallback.Target
+	Jitter time.Duration // most a relayed response chunk is delayed, see reflex.JitterReader
 }
 
 // ClientHandshake carries client-side handshake data.
-type ClientHandshake struct {
-	PublicKey [32]byte
-	UserID    [16]byte
-	PolicyReq []byte
-	Timestamp int64
-	Nonce     [16]byte
-}
+type ClientHandshake = handshake.Client
 
 // ClientHandshakePacket is the full binary packet on the wire.
 // Layout (big endian):
-//   magic(4) | pub(32) | user(16) | ts(8) | nonce(16) | policyLen(2) | policyReq
+//   magic(4) | pub(32) | user(16) | ts(8) | nonce(16) | policyLen(2) | policyReq | padLen(2) | padding
 type ClientHandshakePacket struct {
 	Handshake ClientHandshake
 }
 
 // ServerHandshake is the response sent back to the client.
-type ServerHandshake struct {
-	PublicKey   [32]byte `json:"public_key"`
-	PolicyGrant []byte   `json:"policy_grant"`
-}
+type ServerHandshake = reflex.HandshakeResponse
 
+// Network implements proxy.Inbound. Xray only hands the handler TCP
+// connections; UDP traffic is carried in their sessions, see
+// reflex.FeatureUDP.
 func (h *Handler) Network() []net.Network {
 	return []net.Network{net.Network_TCP}
 }
@@ -95,15 +155,93 @@ func (h *Handler) Network() []net.Network {
 // Process performs handshake detection, authentication, and then either handles
 // Reflex traffic or falls back to a normal web server.
 func (h *Handler) Process(ctx context.Context, network net.Network, conn stat.Connection, dispatcher routing.Dispatcher) error {
+	if inbound := xsession.InboundFromContext(ctx); inbound != nil && inbound.Tag != "" {
+		h.tag.Store(inbound.Tag)
+	}
+	if h.capture != nil {
+		// Wrapped before anything is read, so that a captured session
+		// includes its handshake.
+		if c, err := h.capture.Wrap(conn); err == nil {
+			conn = c
+		}
+	}
+	conn = h.applyStrategy(conn)
 	reader := bufio.NewReader(conn)
+	if h.feedbackPath != "" {
+		_ = conn.SetReadDeadline(time.Now().Add(ReflexHandshakeTimeout))
+		if h.isFeedbackRequest(reader) {
+			return h.serveFeedback(reader, conn)
+		}
+	}
+	h.mu.Lock()
+	draining := h.draining
+	h.mu.Unlock()
+	if draining {
+		_ = conn.Close()
+		return errors.New("reflex: inbound is shutting down")
+	}
+	// Ports outside the hopping window only ever serve the cover site.
+	if h.hopper != nil {
+		port := net.DestinationFromAddr(conn.LocalAddr()).Port
+		if !h.hopper.Active(uint16(port), time.Now()) {
+			return h.handleFallback(ctx, reader, conn)
+		}
+	}
+	if !h.limits.Admit() {
+		if h.limits.Action() == reflex.LimitFallback {
+			return h.handleFallback(ctx, reader, conn)
+		}
+		_ = conn.Close()
+		return errors.New("reflex: inbound overloaded")
+	}
+	defer h.limits.Release()
+	return h.process(ctx, reader, conn, dispatcher, true)
+}
 
-	peeked, err := reader.Peek(ReflexMinHandshakeSize)
+// process implements Process. allowChannels is false inside a channel so
+// channel connections cannot nest.
+func (h *Handler) process(ctx context.Context, reader *bufio.Reader, conn stat.Connection, dispatcher routing.Dispatcher, allowChannels bool) error {
+	_ = conn.SetReadDeadline(time.Now().Add(ReflexHandshakeTimeout))
+	peeked, err := h.peekHead(reader)
 	if err != nil {
 		if err == io.EOF {
 			return nil
 		}
 		return err
 	}
+	if h.vless != nil && len(peeked) >= vlessHeaderSize {
+		// A VLESS request is told by its first bytes, which may be all
+		// its client sends before the server answers.
+		if in := h.vless.inbound(ctx, peeked[:vlessHeaderSize]); in != nil {
+			return h.handleVLESS(ctx, in, reader, conn, dispatcher)
+		}
+	}
+	if len(peeked) < 4 {
+		return h.handleFallback(ctx, reader, conn)
+	}
+
+	switch binary.BigEndian.Uint32(peeked[0:4]) {
+	case carrier.ChannelMagic:
+		if allowChannels {
+			return h.handleChannels(ctx, reader, conn, dispatcher)
+		}
+	case reflex.PSKMagic:
+		return h.attemptTransport(ctx, conn, reflex.TransportPSK, func(ctx context.Context) error {
+			return h.handleReflexPSK(ctx, reader, conn, dispatcher)
+		})
+	case reflex.ResumeMagic:
+		return h.handleResume(ctx, reader, conn, dispatcher)
+	case reflex.BondMagic:
+		return h.handleBond(reader, conn)
+	case reflex.SealedMagic:
+		// Only a server with an identity key can open a sealed handshake;
+		// to any other it is not Reflex.
+		if h.serverKey != nil {
+			return h.attemptTransport(ctx, conn, reflex.TransportMagic, func(ctx context.Context) error {
+				return h.handleReflexSealed(ctx, reader, conn, dispatcher)
+			})
+		}
+	}
 
 	// Decide whether this is Reflex traffic.
 	if isReflexHandshake(peeked) {
@@ -111,11 +249,22 @@ func (h *Handler) Process(ctx context.Context, network net.Network, conn stat.Co
 		if len(peeked) >= 4 {
 			magic := binary.BigEndian.Uint32(peeked[0:4])
 			if magic == ReflexMagic {
-				return h.handleReflexMagic(ctx, reader, conn, dispatcher)
+				return h.attemptTransport(ctx, conn, reflex.TransportMagic, func(ctx context.Context) error {
+					return h.handleReflexMagic(ctx, reader, conn, dispatcher)
+				})
 			}
 		}
 		if isHTTPPostLike(peeked) {
-			return h.handleReflexHTTP(ctx, reader, conn, dispatcher)
+			if !h.acceptsFront(reader) {
+				return h.handleFallback(ctx, reader, conn)
+			}
+			transport := reflex.TransportHTTP
+			if len(h.settings.Load().fronts) > 0 {
+				transport = reflex.TransportFronted
+			}
+			return h.attemptTransport(ctx, conn, transport, func(ctx context.Context) error {
+				return h.handleReflexHTTP(ctx, reader, conn, dispatcher)
+			})
 		}
 		// If detection said Reflex but we can't parse, treat as fallback.
 		return h.handleFallback(ctx, reader, conn)
@@ -125,6 +274,24 @@ func (h *Handler) Process(ctx context.Context, network net.Network, conn stat.Co
 	return h.handleFallback(ctx, reader, conn)
 }
 
+// handleChannels serves a connection that multiplexes several Reflex
+// connections, each with its own handshake, by channel ID.
+func (h *Handler) handleChannels(ctx context.Context, reader *bufio.Reader, conn stat.Connection, dispatcher routing.Dispatcher) error {
+	if _, err := reader.Discard(4); err != nil {
+		return err
+	}
+	// Each channel runs its own handshake deadline; the shared connection is long-lived.
+	_ = conn.SetReadDeadline(time.Time{})
+	err := carrier.ServeChannels(reader, conn, func(c stdnet.Conn) {
+		defer c.Close()
+		_ = h.process(ctx, bufio.NewReader(c), c, dispatcher, false)
+	})
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	return err
+}
+
 func init() {
 	common.Must(common.RegisterConfig((*reflex.InboundConfig)(nil), func(ctx context.Context, config interface{}) (interface{}, error) {
 		return New(ctx, config.(*reflex.InboundConfig))
@@ -133,30 +300,197 @@ func init() {
 
 func New(ctx context.Context, config *reflex.InboundConfig) (proxy.Inbound, error) {
 	handler := &Handler{
-		clients: make([]*protocol.MemoryUser, 0),
+		replay:       reflex.NewReplayCache(2*reflex.MaxClockSkew, reflex.MaxReplayEntries),
+		drainTimeout: reflex.DefaultDrainTimeout,
+		sessions:     make(map[*reflex.Session]*liveSession),
+		resumable:    make(map[[16]byte]*reflex.Session),
+		done:         make(chan struct{}),
+		cipher:       reflex.PreferredCipher(),
+		usage:        reflex.NewUsageMeter(),
+		overhead:     reflex.NewOverheadMeter(),
+	}
+	xerrors.LogInfo(ctx, "reflex: preferring ", handler.cipher, " for sessions, AES hardware: ", reflex.HasAESHardware)
+	if config.DrainTimeout > 0 {
+		handler.drainTimeout = time.Duration(config.DrainTimeout) * time.Second
+	}
+	handler.feedbackPath = config.FeedbackPath
+	handler.usageInterval = reflex.DefaultUsageInterval
+	if config.UsageInterval > 0 {
+		handler.usageInterval = time.Duration(config.UsageInterval) * time.Second
+	}
+	handler.feedback = reflex.NewClassifierFeedback()
+	handler.interference = reflex.NewInterferenceLog()
+	handler.transports = reflex.NewTransportTelemetry()
+	if v := core.FromContext(ctx); v != nil {
+		handler.stats, _ = v.GetFeature(stats.ManagerType()).(stats.Manager)
+	}
+	handler.leakageAudit = config.LeakageAudit
+	handler.pacing = config.Pacing
+	ls := config.LogSampling
+	handler.logs = reflex.NewLogSampler(int(ls.GetBurst()), time.Duration(ls.GetInterval())*time.Second)
+	if tag := config.VlessInbound; tag != "" {
+		if core.FromContext(ctx) == nil {
+			return nil, errors.New("reflex: vlessInbound needs a running instance to look the inbound up in")
+		}
+		handler.vless = &vlessDelegate{tag: tag}
+		if err := core.RequireFeatures(ctx, func(m feature_inbound.Manager) error {
+			handler.vless.inbounds = m
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+	}
+	if l := config.Limits; l != nil && (l.MaxSessions > 0 || l.HandshakesPerSecond > 0 || l.MaxBufferedBytes > 0) {
+		action, err := reflex.ParseLimitAction(l.Action)
+		if err != nil {
+			return nil, err
+		}
+		handler.limits = reflex.NewResourceBudget(int(l.MaxSessions), int(l.HandshakesPerSecond), int64(l.MaxBufferedBytes), action)
+	}
+	if c := config.Capture; c != nil && c.Path != "" {
+		f, err := os.OpenFile(c.Path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+		if err != nil {
+			return nil, err
+		}
+		w, err := capture.NewWriter(f, c.HeadersOnly)
+		if err != nil {
+			_ = f.Close()
+			return nil, err
+		}
+		handler.capture = w
+		handler.captureFile = f
+		handler.captureUsers = make(map[string]bool, len(c.Users))
+		for _, u := range c.Users {
+			handler.captureUsers[u] = true
+		}
+	}
+	if config.KeyLog != "" {
+		f, err := os.OpenFile(config.KeyLog, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+		if err != nil {
+			return nil, err
+		}
+		handler.keyLog = f
+		xerrors.LogWarning(ctx, "reflex: session keys are logged to ", config.KeyLog)
+	}
+	if config.ServerKey != "" {
+		key, err := reflex.ParseServerKey(config.ServerKey)
+		if err != nil {
+			return nil, err
+		}
+		handler.serverKey = key
+	}
+	if config.RequireSealed {
+		if handler.serverKey == nil {
+			return nil, errors.New("reflex: require_sealed needs server_key to open handshakes with")
+		}
+		handler.requireSealed = true
+	}
+	if config.TranscriptDir != "" {
+		if err := os.MkdirAll(config.TranscriptDir, 0o700); err != nil {
+			return nil, err
+		}
+		handler.transcriptDir = config.TranscriptDir
+	}
+	if ph := config.PortHopping; ph != nil {
+		if config.Carriers != nil {
+			return nil, errors.New("reflex: carriers cannot be used with port hopping")
+		}
+		hopper, err := ph.Hopper()
+		if err != nil {
+			return nil, err
+		}
+		handler.hopper = hopper
+	}
+	if config.HandoffSocket != "" {
+		// Take the socket over from the process we replace; it hands its
+		// sessions to us when it shuts down.
+		handoffs, err := handoff.Listen(config.HandoffSocket)
+		if err != nil {
+			return nil, err
+		}
+		handler.handoffPath = config.HandoffSocket
+		handler.handoffs = handoffs
+		if core.FromContext(ctx) != nil {
+			if err := core.RequireFeatures(ctx, func(d routing.Dispatcher) error {
+				go handler.ServeHandoffs(ctx, d)
+				return nil
+			}); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if config.Carriers != nil && core.FromContext(ctx) != nil {
+		if err := core.RequireFeatures(ctx, func(d routing.Dispatcher) error {
+			return handler.listenCarriers(ctx, config.Carriers, d)
+		}); err != nil {
+			return nil, err
+		}
 	}
 
-	for _, client := range config.Clients {
-		handler.clients = append(handler.clients, &protocol.MemoryUser{
-			Email: client.Id,
-			Account: &MemoryAccount{
-				Id: client.Id,
-			},
+	if config.HealthPath != "" {
+		if err := handler.listenHealth(config.HealthListen, config.HealthPath); err != nil {
+			return nil, err
+		}
+	}
+
+	if f := config.Fallback; f != nil && f.Decoy != nil {
+		d, err := decoy.New(decoy.Config{
+			Origin:   f.Decoy.Origin,
+			Mode:     f.Decoy.Mode,
+			CacheTTL: time.Duration(f.Decoy.CacheTtl) * time.Second,
+			Refresh:  time.Duration(f.Decoy.Refresh) * time.Second,
+			MaxPages: int(f.Decoy.MaxPages),
 		})
+		if err != nil {
+			return nil, err
+		}
+		// Started before the self-check, which expects the fallback to answer.
+		if err := d.Start(f.Target()); err != nil {
+			return nil, err
+		}
+		handler.decoy = d
 	}
 
-	if config.Fallback != nil {
-		handler.fallback = &FallbackConfig{
-			Dest: config.Fallback.Dest,
+	s, err := buildSettings(ctx, config, nil)
+	if err != nil {
+		return nil, err
+	}
+	handler.settings.Store(s)
+	for _, w := range SelfCheck(config) {
+		xerrors.LogWarning(ctx, "reflex: ", w)
+	}
+	if config.StateFile != "" {
+		handler.stateFile = config.StateFile
+		// A broken state file must not keep the server down.
+		if err := reflex.LoadState(config.StateFile, handler.replay, s.logins, time.Now()); err != nil {
+			xerrors.LogWarningInner(ctx, err, "reflex: failed to load state")
 		}
+		go handler.saveStatePeriodically(ctx)
 	}
+
 	if p := reflex.Profiles["http2-api"]; p != nil {
 		handler.defaultProfile = p
 	}
+	if config.RetryCookie {
+		cookies, err := reflex.NewCookieIssuer(reflex.DefaultCookieLifetime)
+		if err != nil {
+			return nil, err
+		}
+		handler.cookies = cookies
+	}
 
 	return handler, nil
 }
 
+func hasPolicy(policies []*reflex.PolicyConfig, name string) bool {
+	for _, p := range policies {
+		if p.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
 // isReflexHandshake combines magic-number and HTTP POST-like detection.
 func isReflexHandshake(data []byte) bool {
 	if isReflexMagic(data) {
@@ -208,33 +542,115 @@ func (h *Handler) handleReflexMagic(ctx context.Context, reader *bufio.Reader, c
 		return err
 	}
 
-	// Read fixed part.
-	fixedSize := 32 + 16 + 8 + 16 + 2 // pub + user + ts + nonce + policyLen
-	fixed := make([]byte, fixedSize)
-	if _, err := io.ReadFull(reader, fixed); err != nil {
+	raw, err := handshake.ReadBody(reader)
+	if errors.Is(err, handshake.ErrPaddingTooLarge) {
+		return h.refuseHandshake(ctx, conn, "padding too large", "bad request")
+	}
+	if err != nil {
 		return err
 	}
+	if h.requireSealed {
+		return h.refuseHandshake(ctx, conn, "unsealed", "forbidden")
+	}
 
-	offset := 0
-	var hs ClientHandshake
-	copy(hs.PublicKey[:], fixed[offset:offset+32])
-	offset += 32
-	copy(hs.UserID[:], fixed[offset:offset+16])
-	offset += 16
-	hs.Timestamp = int64(binary.BigEndian.Uint64(fixed[offset : offset+8]))
-	offset += 8
-	copy(hs.Nonce[:], fixed[offset:offset+16])
-	offset += 16
-	policyLen := int(binary.BigEndian.Uint16(fixed[offset : offset+2]))
-
-	if policyLen > 0 {
-		hs.PolicyReq = make([]byte, policyLen)
-		if _, err := io.ReadFull(reader, hs.PolicyReq); err != nil {
-			return err
-		}
+	hs, err := handshake.Unmarshal(raw)
+	if err != nil {
+		return err
+	}
+
+	transcript := reflex.NewTranscript()
+	transcript.Write(raw)
+
+	return h.processHandshake(ctx, reader, conn, dispatcher, hs, transcript)
+}
+
+// handleReflexSealed serves a sealed handshake, see reflex.SealHandshake.
+func (h *Handler) handleReflexSealed(ctx context.Context, reader *bufio.Reader, conn stat.Connection, dispatcher routing.Dispatcher) error {
+	if _, err := reader.Discard(4); err != nil {
+		return err
+	}
+
+	raw, err := handshake.ReadSealedBody(reader)
+	if errors.Is(err, handshake.ErrPaddingTooLarge) {
+		return h.refuseHandshake(ctx, conn, "padding too large", "bad request")
+	}
+	if err != nil {
+		return err
+	}
+	return h.openSealed(ctx, reader, conn, dispatcher, raw)
+}
+
+// openSealed opens the sealed handshake body raw and processes the handshake
+// it carries.
+func (h *Handler) openSealed(ctx context.Context, reader *bufio.Reader, conn stat.Connection, dispatcher routing.Dispatcher, raw []byte) error {
+	sealed, err := handshake.UnmarshalSealed(raw)
+	if err != nil {
+		return err
+	}
+	// The cookie is in the clear: check it before the X25519 work opening
+	// takes.
+	if retried, err := h.retryWithoutCookie(ctx, conn, sealed.Padding); retried {
+		return err
+	}
+	hs, err := reflex.OpenHandshake(sealed, h.serverKey)
+	if err != nil {
+		return h.refuseHandshake(ctx, conn, "bad seal", "forbidden")
+	}
+
+	transcript := reflex.NewTranscript()
+	transcript.Write(raw)
+
+	return h.processHandshake(ctx, reader, conn, dispatcher, hs, transcript)
+}
+
+// handleReflexPSK serves a PSK-only handshake. There is no server response:
+// once the MAC checks out the session starts and the client's frames follow
+// directly behind the handshake.
+func (h *Handler) handleReflexPSK(ctx context.Context, reader *bufio.Reader, conn stat.Connection, dispatcher routing.Dispatcher) error {
+	if _, err := reader.Discard(4); err != nil {
+		return err
+	}
+	hs, body, mac, err := reflex.ReadPSKHandshake(reader)
+	if err != nil {
+		return err
+	}
+	if h.requireSealed {
+		return h.refuseHandshake(ctx, conn, "unsealed", "forbidden")
 	}
 
-	return h.processHandshake(ctx, reader, conn, dispatcher, hs)
+	if !timestampValid(hs.Timestamp) {
+		return h.refuseHandshake(ctx, conn, "clock skew", "invalid timestamp")
+	}
+	user, err := h.authenticateUser(hs.UserID, hs.Timestamp)
+	if err != nil {
+		return h.refuseHandshake(ctx, conn, "unknown user", "forbidden")
+	}
+	psk := user.Account.(*MemoryAccount).PSK
+	if !reflex.VerifyPSKHandshake(psk, body, mac) {
+		return h.refuseHandshake(ctx, conn, "bad mac", "forbidden")
+	}
+	if !h.replay.Check(hs.Nonce, time.Now()) {
+		h.logSampled(ctx, xerrors.LogInfo, reflex.LogEventReplay, "reflex: replayed PSK handshake from ", sourceAddress(conn))
+		return h.refuseHandshake(ctx, conn, "replay", "forbidden")
+	}
+	if !h.loginAllowed(user, conn) {
+		return h.refuseHandshake(ctx, conn, "login limit", "forbidden")
+	}
+	// A PSK handshake carries no policy request; the user gets the defaults.
+	grant, err := h.evaluatePolicy(ctx, user, nil)
+	if err != nil {
+		return h.refuseHandshake(ctx, conn, "policy", "forbidden")
+	}
+
+	_ = conn.SetReadDeadline(time.Time{})
+	h.transportOutcome(ctx, nil, reflex.TransportCompleted)
+	sessionKey := reflex.DerivePSKSessionKey(psk, body)
+	h.logKey(hs.Nonce[:], sessionKey)
+	session, err := reflex.NewServerSession(sessionKey)
+	if err != nil {
+		return err
+	}
+	return h.handleSession(ctx, reader, conn, dispatcher, session, user, grant, time.Now(), nil)
 }
 
 func (h *Handler) handleReflexHTTP(ctx context.Context, reader *bufio.Reader, conn stat.Connection, dispatcher routing.Dispatcher) error {
@@ -250,18 +666,8 @@ func (h *Handler) handleReflexHTTP(ctx context.Context, reader *bufio.Reader, co
 			break
 		}
 		// Very small header parser for Content-Length.
-		if len(line) >= 16 && (line[0:15] == "Content-Length" || line[0:15] == "content-length") {
-			// naive parse: "Content-Length: "
-			for i := 0; i < len(line); i++ {
-				if line[i] >= '0' && line[i] <= '9' {
-					var v int
-					for ; i < len(line) && line[i] >= '0' && line[i] <= '9'; i++ {
-						v = v*10 + int(line[i]-'0')
-					}
-					contentLength = v
-					break
-				}
-			}
+		if name, value, found := strings.Cut(line, ":"); found && strings.EqualFold(name, "Content-Length") {
+			contentLength, _ = strconv.Atoi(strings.TrimSpace(value))
 		}
 	}
 
@@ -275,44 +681,38 @@ func (h *Handler) handleReflexHTTP(ctx context.Context, reader *bufio.Reader, co
 	}
 
 	var payload struct {
-		Data string `json:"data"`
+		Data   string `json:"data"`
+		Sealed string `json:"sealed"`
 	}
 	if err := json.Unmarshal(body, &payload); err != nil {
 		return err
 	}
 
+	if payload.Sealed != "" && h.serverKey != nil {
+		raw, err := base64.StdEncoding.DecodeString(payload.Sealed)
+		if err != nil {
+			return err
+		}
+		return h.openSealed(ctx, reader, conn, dispatcher, raw)
+	}
+	if h.requireSealed {
+		return h.refuseHandshake(ctx, conn, "unsealed", "forbidden")
+	}
+
 	raw, err := base64.StdEncoding.DecodeString(payload.Data)
 	if err != nil {
 		return err
 	}
 
-	hs, err := parseClientHandshakeFromBytes(raw)
+	hs, err := handshake.Unmarshal(raw)
 	if err != nil {
 		return err
 	}
 
-	return h.processHandshake(ctx, reader, conn, dispatcher, hs)
-}
+	transcript := reflex.NewTranscript()
+	transcript.Write(raw)
 
-func parseClientHandshakeFromBytes(b []byte) (ClientHandshake, error) {
-	var hs ClientHandshake
-	if len(b) < 32+16+8+16 {
-		return hs, errors.New("handshake packet too short")
-	}
-	offset := 0
-	copy(hs.PublicKey[:], b[offset:offset+32])
-	offset += 32
-	copy(hs.UserID[:], b[offset:offset+16])
-	offset += 16
-	hs.Timestamp = int64(binary.BigEndian.Uint64(b[offset : offset+8]))
-	offset += 8
-	copy(hs.Nonce[:], b[offset:offset+16])
-	offset += 16
-	if offset < len(b) {
-		hs.PolicyReq = make([]byte, len(b)-offset)
-		copy(hs.PolicyReq, b[offset:])
-	}
-	return hs, nil
+	return h.processHandshake(ctx, reader, conn, dispatcher, hs, transcript)
 }
 
 func generateKeyPair() (priv [32]byte, pub [32]byte, err error) {
@@ -329,29 +729,72 @@ func deriveSharedKey(privateKey, peerPublicKey [32]byte) [32]byte {
 	return shared
 }
 
-func deriveSessionKey(sharedKey [32]byte, salt []byte) []byte {
-	h := hkdf.New(sha256.New, sharedKey[:], salt, []byte("reflex-session"))
-	sessionKey := make([]byte, 32)
-	_, _ = io.ReadFull(h, sessionKey)
-	return sessionKey
+// authenticateUser finds the client a handshake stamped ts identifies
+// itself as, by its UUID or its one-time ID as the settings allow.
+func (h *Handler) authenticateUser(userID [16]byte, ts int64) (*protocol.MemoryUser, error) {
+	s := h.settings.Load()
+	var found *protocol.MemoryUser
+	if s.ids.AcceptsStatic() {
+		userIDStr := uuid.UUID(userID).String()
+		for _, user := range s.clients {
+			if acc, ok := user.Account.(*MemoryAccount); ok && acc.Id == userIDStr {
+				found = user
+				break
+			}
+		}
+	}
+	if found == nil && s.ids.AcceptsOneTime() {
+		found = h.oneTime.lookup(s, userID, ts)
+	}
+	if found == nil {
+		return nil, errors.New("user not found")
+	}
+	if found.Account.(*MemoryAccount).Expired(time.Now()) {
+		return nil, errors.New("user expired")
+	}
+	return found, nil
 }
 
-func (h *Handler) authenticateUser(userID [16]byte) (*protocol.MemoryUser, error) {
-	userIDStr := uuid.UUID(userID).String()
-	for _, user := range h.clients {
-		if acc, ok := user.Account.(*MemoryAccount); ok && acc.Id == userIDStr {
-			return user, nil
-		}
+// loginAllowed applies the concurrent-login policy to an authenticated handshake.
+func (h *Handler) loginAllowed(user *protocol.MemoryUser, conn stat.Connection) bool {
+	logins := h.settings.Load().logins
+	if logins == nil {
+		return true
 	}
-	return nil, errors.New("user not found")
+	return logins.Observe(user.Email, sourceAddress(conn), time.Now())
 }
 
-func (h *Handler) processHandshake(ctx context.Context, reader *bufio.Reader, conn stat.Connection, dispatcher routing.Dispatcher, clientHS ClientHandshake) error {
-	// Basic timestamp check to avoid trivial replay.
+// timestampValid reports whether a handshake timestamp is within
+// reflex.MaxClockSkew of the local clock.
+func timestampValid(ts int64) bool {
 	now := time.Now().Unix()
-	if clientHS.Timestamp < now-300 || clientHS.Timestamp > now+300 {
-		// Outside 5 minute window.
-		return h.writeHTTPErrorAndClose(conn, "invalid timestamp")
+	skew := int64(reflex.MaxClockSkew / time.Second)
+	return ts >= now-skew && ts <= now+skew
+}
+
+func (h *Handler) processHandshake(ctx context.Context, reader *bufio.Reader, conn stat.Connection, dispatcher routing.Dispatcher, clientHS *ClientHandshake, transcript *reflex.Transcript) error {
+	// Basic timestamp check to avoid trivial replay.
+	if !timestampValid(clientHS.Timestamp) {
+		return h.refuseHandshake(ctx, conn, "clock skew", "invalid timestamp")
+	}
+
+	// Stateless retry: without a valid cookie, answer with one before doing
+	// any X25519 work or allocating session state.
+	if retried, err := h.retryWithoutCookie(ctx, conn, clientHS.Padding); retried {
+		return err
+	}
+
+	user, err := h.authenticateUser(clientHS.UserID, clientHS.Timestamp)
+	if err != nil {
+		// Authentication failed, behave like normal HTTP error and close.
+		return h.refuseHandshake(ctx, conn, "unknown user", "forbidden")
+	}
+	// A handshake nonce is accepted once; replays get the same answer as a
+	// stranger. Only a known user's nonces are recorded, so strangers
+	// cannot fill the cache.
+	if !h.replay.Check(clientHS.Nonce, time.Now()) {
+		h.logSampled(ctx, xerrors.LogInfo, reflex.LogEventReplay, "reflex: replayed handshake from ", sourceAddress(conn))
+		return h.refuseHandshake(ctx, conn, "replay", "forbidden")
 	}
 
 	serverPriv, serverPub, err := generateKeyPair()
@@ -360,18 +803,25 @@ func (h *Handler) processHandshake(ctx context.Context, reader *bufio.Reader, co
 	}
 
 	shared := deriveSharedKey(serverPriv, clientHS.PublicKey)
-	sessionKey := deriveSessionKey(shared, clientHS.Nonce[:])
+	transcript.Write(serverPub[:])
+	transcriptHash := transcript.Sum()
+	sessionKey := reflex.DeriveSessionKey(shared, clientHS.Nonce[:], transcriptHash)
 
-	user, err := h.authenticateUser(clientHS.UserID)
-	if err != nil {
-		// Authentication failed, behave like normal HTTP error and close.
-		return h.writeHTTPErrorAndClose(conn, "forbidden")
+	if !h.loginAllowed(user, conn) {
+		return h.refuseHandshake(ctx, conn, "login limit", "forbidden")
 	}
 
-	// Build a simple JSON response; PolicyGrant is left empty for now.
+	grant, err := h.evaluatePolicy(ctx, user, clientHS.PolicyReq)
+	if err != nil {
+		return h.refuseHandshake(ctx, conn, "policy", "forbidden")
+	}
 	resp := ServerHandshake{
 		PublicKey:   serverPub,
-		PolicyGrant: nil,
+		PolicyGrant: grant.Marshal(),
+		KeyConfirm:  reflex.KeyConfirmation(sessionKey, transcriptHash),
+	}
+	if h.serverKey != nil {
+		resp.ServerSignature = reflex.SignHandshake(h.serverKey, transcriptHash)
 	}
 
 	respBody, err := json.Marshal(resp)
@@ -379,77 +829,1049 @@ func (h *Handler) processHandshake(ctx context.Context, reader *bufio.Reader, co
 		return err
 	}
 
-	header := "HTTP/1.1 200 OK\r\nContent-Type: application/json\r\nContent-Length: "
-	header += strconv.Itoa(len(respBody))
-	header += "\r\n\r\n"
-
-	if _, err := conn.Write([]byte(header)); err != nil {
-		return err
+	// The client has no session key before reading the response, so nothing
+	// it sends ahead of it can be a frame. Reading it as the session's first
+	// frames would let whoever sent it desync the session.
+	if reader.Buffered() > 0 {
+		return h.refuseHandshake(ctx, conn, "early data", "bad request")
 	}
-	if _, err := conn.Write(respBody); err != nil {
+	if err := writeHTTPResponse(conn, "200 OK", respBody); err != nil {
 		return err
 	}
 
+	// Handshake complete; session reads may block indefinitely.
+	_ = conn.SetReadDeadline(time.Time{})
+	h.transportOutcome(ctx, nil, reflex.TransportCompleted)
+
 	// Step 3: create session and handle encrypted frames.
-	session, err := reflex.NewSession(sessionKey)
+	h.logKey(clientHS.Nonce[:], sessionKey)
+	session, err := reflex.NewServerSession(sessionKey)
 	if err != nil {
 		return err
 	}
-	return h.handleSession(ctx, reader, conn, dispatcher, session, user)
+	return h.handleSession(ctx, reader, conn, dispatcher, session, user, grant, time.Now(), nil)
+}
+
+// evaluatePolicy decides a user's policy request. A request that cannot be
+// parsed is treated as asking for nothing in particular, unless deny-by-default
+// is configured.
+func (h *Handler) evaluatePolicy(ctx context.Context, user *protocol.MemoryUser, policyReq []byte) (*reflex.PolicyGrant, error) {
+	account := user.Account.(*MemoryAccount)
+	subject := reflex.PolicySubject{
+		UserID: account.Id,
+		Policy: account.Policy,
+		Level:  user.Level,
+	}
+	s := h.settings.Load()
+	req, err := reflex.ParsePolicyReq(policyReq)
+	if err != nil {
+		if s.denyMalformed {
+			auditPolicy(ctx, &reflex.PolicyAudit{Subject: subject, Err: err})
+			return nil, err
+		}
+		req = &reflex.PolicyReq{}
+	}
+	grant, err := s.policy.Decide(ctx, subject, req)
+	if err == nil {
+		grant = grant.Downgrade(req.NegotiateVersion())
+		grant.ResumeRotation = uint32(s.resumeRotation / time.Second)
+		grant.Cipher = ""
+		if c := reflex.ChooseCipher(req.Ciphers, h.cipher); c != reflex.CipherChaCha20Poly1305 {
+			grant.Cipher = c.String()
+		}
+	}
+	auditPolicy(ctx, &reflex.PolicyAudit{Subject: subject, Request: req, Grant: grant, Err: err})
+	return grant, err
 }
 
 // handleSession reads encrypted frames and processes them by type (Data, PaddingCtrl, TimingCtrl).
-func (h *Handler) handleSession(ctx context.Context, reader *bufio.Reader, conn stat.Connection, dispatcher routing.Dispatcher, session *reflex.Session, user *protocol.MemoryUser) error {
-	_ = user
-	profile := h.defaultProfile
+// The session is shaped with the granted profile and limited to the granted bandwidth.
+//
+// relays are those of a session resumed on this connection, see
+// handleResume, and nil for a new one.
+func (h *Handler) handleSession(ctx context.Context, reader *bufio.Reader, conn stat.Connection, dispatcher routing.Dispatcher, session *reflex.Session, user *protocol.MemoryUser, grant *reflex.PolicyGrant, established time.Time, relays *sessionRelays) (err error) {
+	if relays == nil {
+		relays = newSessionRelays(func() bool { return h.isMoving(session) })
+	}
+	// Set once the relays are given to the loop, which lets go of them.
+	tornDown := false
+	defer func() {
+		if !tornDown {
+			relays.close()
+		}
+	}()
+	if grant.HasFeature(reflex.FeatureTLSRecords) {
+		session.SetTLSRecords(true)
+	}
+	if c, _ := reflex.ParseCipher(grant.Cipher); c != reflex.CipherChaCha20Poly1305 {
+		if err := session.SetCipher(c); err != nil {
+			return err
+		}
+	}
+	if grant.HasFeature(reflex.FeatureKeyCommitment) {
+		session.SetKeyCommitment(true)
+	}
+	if grant.HasFeature(reflex.FeatureMaskedLengths) {
+		if err := session.SetMaskedLengths(true); err != nil {
+			return err
+		}
+	}
+	if grant.HasFeature(reflex.FeatureReorderTolerant) {
+		_ = session.SetOrdering(reflex.OrderingTolerant, reflex.DefaultReorderWindow)
+	}
+	if grant.HasFeature(reflex.FeatureTimestamps) {
+		session.SetTimestamps(true)
+	}
+	if c, ok := captured(conn); ok {
+		c.Select(len(h.captureUsers) == 0 || h.captureUsers[user.Email] || h.captureUsers[user.Account.(*MemoryAccount).Id])
+	}
+	if grant.HasFeature(reflex.FeatureBonding) {
+		// Other connections may join the session as lanes, see handleBond.
+		bond := reflex.NewBond(session, conn, reader)
+		conn, reader = bond, bufio.NewReader(bond)
+	}
+	if reason, ok := h.track(session, conn, user, established, relays); !ok {
+		_ = reflex.CloseSession(session, conn, reason)
+		return conn.Close()
+	}
+	defer h.untrack(session)
+	session.SetPacing(h.pacing)
+	morphing := h.settings.Load().morphing
+	if m := user.Account.(*MemoryAccount).Morphing; m != nil {
+		morphing = *m
+	}
+	session.SetMorphing(morphing)
+	if h.leakageAudit {
+		audit := reflex.NewLeakageAudit()
+		session.SetLeakageAudit(audit)
+		defer h.reportLeakage(ctx, audit)
+	}
+	var transcript *reflex.SessionTranscript
+	if h.transcriptDir != "" {
+		var done func(error)
+		if transcript, done = h.startTranscript(ctx, session, grant); transcript != nil {
+			defer func() { done(err) }()
+		}
+	}
+	if inbound := xsession.InboundFromContext(ctx); inbound != nil {
+		inbound.Name = "reflex"
+		inbound.User = user
+	}
+	started := time.Now()
+	var moved atomic.Uint64 // DATA payload both ways, for the transport metrics
+	defer func() { h.transportSessionEnded(ctx, moved.Load(), started) }()
+	defer h.accountOverhead(user.Email, session)
+	var routeCtx context.Context
+	// shapeMu guards what the downlink of the relay uses as well: profile,
+	// downLimiter, schedule, grant and usageSent. The session loop is their
+	// only other user, and takes it to change them, and to read those the
+	// downlink changes too.
+	var shapeMu sync.Mutex
+	var profile *reflex.TrafficProfile
+	// The grant's bandwidth holds for each direction by itself.
+	var upLimiter, downLimiter *reflex.RateLimiter
+	var schedule *reflex.ProfileScheduler
+	var expiry *time.Timer
+	defer func() {
+		if expiry != nil {
+			expiry.Stop()
+		}
+	}()
+	// applyGrant enforces grant from now on. Its lifetime counts from when
+	// the session was established, not from the renewal, and ends the
+	// session at once if it is already up.
+	applyGrant := func(g *reflex.PolicyGrant) {
+		h.noteGrant(session, g)
+		session.SetPolicyVersion(g.Version)
+		routeCtx = reflex.ContextWithGrant(ctx, g)
+		p := h.lookupProfile(g.Profile)
+		if p == nil {
+			p = h.defaultProfile
+		}
+		shapeMu.Lock()
+		grant = g
+		profile = p
+		upLimiter = reflex.NewRateLimiter(g.Bandwidth)
+		downLimiter = reflex.NewRateLimiter(g.Bandwidth)
+		schedule = reflex.NewProfileScheduler(g.Switches, time.Now())
+		shapeMu.Unlock()
+		h.logProfile(ctx, user, p)
+		session.SetWriteTimeout(g.FrameWriteTimeout())
+		if expiry != nil {
+			expiry.Stop()
+			expiry = nil
+		}
+		if ttl := g.SessionLifetime(); ttl > 0 {
+			expiry = time.AfterFunc(time.Until(established.Add(ttl)), func() { terminate(session, conn, reflex.CloseReasonExpired) })
+		}
+	}
+	applyGrant(grant)
+	for _, p := range h.rolledOut() {
+		if err := reflex.OfferProfile(session, conn, p); err != nil {
+			return err
+		}
+	}
+	// Clients that ask for usage reports get one now, on renewals and then
+	// at most every usage interval while there is traffic.
+	var usageSent time.Time
+	reportUsage := func(now bool) error {
+		shapeMu.Lock()
+		g := grant
+		if !g.HasFeature(reflex.FeatureUsageReports) || (!now && time.Since(usageSent) < h.usageInterval) {
+			shapeMu.Unlock()
+			return nil
+		}
+		usageSent = time.Now()
+		shapeMu.Unlock()
+		return reflex.SendUsage(session, conn, h.usageReport(user, g))
+	}
+	// setProfile shapes what the session sends with p from now on.
+	setProfile := func(p *reflex.TrafficProfile) {
+		shapeMu.Lock()
+		profile = p
+		shapeMu.Unlock()
+		h.logProfile(ctx, user, p)
+	}
+	// carried counts n bytes of DATA payload moved either way against the
+	// user, and lets the profile schedule see them.
+	carried := func(n int) error {
+		h.usage.Add(user.Email, n)
+		moved.Add(uint64(n))
+		h.accountOverhead(user.Email, session)
+		shapeMu.Lock()
+		name, switched := schedule.Observe(n, time.Now())
+		shapeMu.Unlock()
+		if switched {
+			if err := reflex.SwitchProfile(session, conn, name); err != nil {
+				return err
+			}
+			setProfile(h.lookupProfile(name))
+		}
+		return reportUsage(false)
+	}
+	// sendData sends the client a frame of frameType with n bytes of data
+	// in its payload.
+	sendData := func(frameType uint8, payload []byte, n int) error {
+		if h.quotaExhausted(user) {
+			terminate(session, conn, reflex.CloseReasonQuota)
+			return errors.New("reflex: quota of " + user.Email + " exhausted")
+		}
+		shapeMu.Lock()
+		p, l := profile, downLimiter
+		shapeMu.Unlock()
+		l.Wait(n)
+		if err := reflex.WriteFrameWithMorphing(session, conn, frameType, payload, p); err != nil {
+			return err
+		}
+		return carried(n)
+	}
+	// downlinkOf returns what sends the client what the destination of the
+	// relay of stream id answers, sealed with cipher for a stream other
+	// than 0, through whichever loop serves the session.
+	downlinkOf := func(id uint32, cipher *reflex.StreamCipher) func([]byte) error {
+		return func(data []byte) error {
+			if id == 0 {
+				return relays.send(func(out *relayOut) error {
+					return out.sendData(reflex.FrameTypeData, data, len(data))
+				})
+			}
+			for len(data) > 0 {
+				n := min(len(data), session.MaxStreamData())
+				sealed, err := cipher.Seal(data[:n])
+				if err != nil {
+					return err
+				}
+				if err := relays.send(func(out *relayOut) error {
+					return out.sendData(reflex.FrameTypeStreamData, reflex.StreamDataPayload(id, sealed), n)
+				}); err != nil {
+					return err
+				}
+				data = data[n:]
+			}
+			return nil
+		}
+	}
+	// datagramsFrom returns what sends the client the datagrams d answers
+	// with. One too large for a frame is dropped, as UDP would.
+	datagramsFrom := func(d reflex.Destination) func([]byte) error {
+		return func(data []byte) error {
+			if len(data) > session.MaxDatagram(d) {
+				return nil
+			}
+			return relays.send(func(out *relayOut) error {
+				return out.sendData(reflex.FrameTypeDatagram, reflex.DatagramPayload(d, data), len(data))
+			})
+		}
+	}
+	// The relays send what comes back through this loop until it ends. They
+	// end with the session, unless it moves to another connection.
+	relays.attach(&relayOut{conn: conn, sendData: sendData})
+	defer func() {
+		tornDown = true
+		if h.isMoving(session) {
+			relays.detach()
+		} else {
+			relays.close()
+		}
+	}()
+	if err := reportUsage(true); err != nil {
+		return err
+	}
+
+	throttled := false // set when the monitor sees throughput collapse
+	monitor := reflex.NewInterferenceMonitor(conn.RemoteAddr().String(), func(e *reflex.InterferenceEvent) {
+		h.reportInterference(ctx, e)
+		if e.Kind == reflex.InterferenceResetAfterHandshake {
+			h.transportOutcome(ctx, nil, reflex.TransportReset)
+		}
+		if transcript != nil {
+			transcript.Event(reflex.TranscriptInterference, e.Kind)
+		}
+		throttled = throttled || e.Kind == reflex.InterferenceThroughputCollapse
+	})
+	var anomalies reflex.AnomalyDetector
+	// DATA frames go where the client's last ADDR frame said, see
+	// reflex.Destination.
+	dest, named := session.Destination()
+	if !named {
+		dest = reflex.DefaultDestination
+	}
+	// shapeFor shapes both directions like the service a stream goes to,
+	// once its destination is known. The client is sent the whole profile.
+	shapeFor := func(name string, p *reflex.TrafficProfile) error {
+		shapeMu.Lock()
+		current := profile
+		shapeMu.Unlock()
+		if p == nil || p == current {
+			return nil
+		}
+		if err := reflex.SwitchProfile(session, conn, name); err != nil {
+			return err
+		}
+		if err := reflex.PushProfile(session, conn, p); err != nil {
+			return err
+		}
+		setProfile(p)
+		return nil
+	}
+	// dial opens a relay to d over network for the data of a stream or
+	// datagrams, which sends what comes back with send. The dispatcher
+	// records the access once per link, not per frame.
+	dial := func(network net.Network, d reflex.Destination, send func([]byte) error, ended func()) (*relay, error) {
+		target := net.Destination{Network: network, Address: net.ParseAddress(d.Host), Port: net.Port(d.Port)}
+		dispatchCtx := log.ContextWithAccessMessage(routeCtx, &log.AccessMessage{
+			From:   conn.RemoteAddr(),
+			To:     target,
+			Status: log.AccessAccepted,
+			Email:  user.Email,
+		})
+		return openRelay(dispatchCtx, dispatcher, target, send, ended)
+	}
+	// openStream opens stream id to d, or tells the client the stream is
+	// closed if it cannot be opened. Streams are not shaped like their
+	// destination, since several may share the session.
+	openStream := func(id uint32, d reflex.Destination) error {
+		if _, open := relays.streams[id]; open {
+			return errors.New("reflex: stream already open")
+		}
+		open := 0
+		for other, r := range relays.streams {
+			if other != 0 && r.ended() {
+				r.close()
+				relays.forget(other)
+			} else if other != 0 {
+				open++
+			}
+		}
+		if open >= reflex.MaxStreams || dispatcher == nil || !grant.AllowsDestination(d.Host, d.Port) {
+			return reflex.CloseStream(session, conn, id)
+		}
+		cipher, err := session.NewStreamCipher(id)
+		if err != nil {
+			return err
+		}
+		r, err := dial(net.Network_TCP, d, downlinkOf(id, cipher), func() {
+			_ = relays.send(func(out *relayOut) error { return reflex.CloseStream(session, out.conn, id) })
+		})
+		if err != nil {
+			return reflex.CloseStream(session, conn, id)
+		}
+		relays.streams[id] = r
+		relays.ciphers[id] = cipher
+		return nil
+	}
+	// received accounts for n bytes of data the client sent, before they
+	// are relayed.
+	received := func(n int) error {
+		monitor.Received(n)
+		if h.quotaExhausted(user) {
+			terminate(session, conn, reflex.CloseReasonQuota)
+			return errors.New("reflex: quota of " + user.Email + " exhausted")
+		}
+		if throttled {
+			// The current shape is being throttled: move both directions
+			// to the profile the schedule keeps for that, if any. The
+			// client is sent the whole profile, not just its name, so it
+			// can shape what it sends with it.
+			throttled = false
+			shapeMu.Lock()
+			name, ok := schedule.Throttled()
+			shapeMu.Unlock()
+			if ok && h.lookupProfile(name) != nil {
+				if err := reflex.SwitchProfile(session, conn, name); err != nil {
+					return err
+				}
+				if err := reflex.PushProfile(session, conn, h.lookupProfile(name)); err != nil {
+					return err
+				}
+				setProfile(h.lookupProfile(name))
+			}
+		}
+		return nil
+	}
+	// uplink relays payload, sent by the client on stream id, to the
+	// stream's destination. A relay of stream 0 is opened with its first
+	// frame, and again if the destination went away.
+	uplink := func(id uint32, payload []byte) error {
+		if err := received(len(payload)); err != nil {
+			return err
+		}
+		if id == 0 {
+			if err := shapeFor(h.destinationProfile(payload)); err != nil {
+				return err
+			}
+		}
+		if !h.limits.Reserve(len(payload)) {
+			terminate(session, conn, reflex.CloseReasonOverloaded)
+			return errors.New("reflex: frame buffer budget exhausted")
+		}
+		defer h.limits.Free(len(payload))
+		upLimiter.Wait(len(payload))
+		r := relays.streams[id]
+		if r != nil && r.ended() {
+			r.close()
+			relays.forget(id)
+			r = nil
+		}
+		if r == nil && id == 0 && dispatcher != nil && grant.AllowsDestination(dest.Host, dest.Port) {
+			var err error
+			if r, err = dial(net.Network_TCP, dest, downlinkOf(0, nil), nil); err != nil {
+				return nil
+			}
+			relays.streams[0] = r
+		}
+		if r != nil {
+			if err := r.write(payload); err != nil {
+				// The destination went away. The next DATA frame dials it
+				// again; a stream is closed.
+				r.close()
+				relays.forget(id)
+				if id != 0 {
+					if err := reflex.CloseStream(session, conn, id); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		return carried(len(payload))
+	}
+	// datagram relays the datagram dg, sent by the client, to its
+	// destination. A relay is opened with the first datagram to a
+	// destination; datagrams that cannot be relayed are dropped.
+	datagram := func(dg *reflex.Datagram) error {
+		if err := received(len(dg.Data)); err != nil {
+			return err
+		}
+		if !h.limits.Reserve(len(dg.Data)) {
+			terminate(session, conn, reflex.CloseReasonOverloaded)
+			return errors.New("reflex: frame buffer budget exhausted")
+		}
+		defer h.limits.Free(len(dg.Data))
+		upLimiter.Wait(len(dg.Data))
+		d := dg.Destination
+		r := relays.datagrams[d]
+		if r != nil && r.ended() {
+			r.close()
+			delete(relays.datagrams, d)
+			r = nil
+		}
+		if r == nil {
+			for other, r := range relays.datagrams {
+				if r.ended() {
+					r.close()
+					delete(relays.datagrams, other)
+				}
+			}
+			if len(relays.datagrams) >= reflex.MaxStreams || dispatcher == nil || !grant.AllowsDestination(d.Host, d.Port) {
+				return nil
+			}
+			var err error
+			if r, err = dial(net.Network_UDP, d, datagramsFrom(d), nil); err != nil {
+				return nil
+			}
+			relays.datagrams[d] = r
+		}
+		if err := r.write(dg.Data); err != nil {
+			// The link ended: the next datagram opens another.
+			r.close()
+			delete(relays.datagrams, d)
+		}
+		return carried(len(dg.Data))
+	}
+	var challenge []byte // outstanding challenge, if any
+	// With handoff enabled, frames are read through a tap so that a frame
+	// interrupted by the shutdown can be passed on along with the session.
+	var src io.Reader = reader
+	var tap *tapReader
+	if h.handoffs != nil {
+		tap = &tapReader{r: reader}
+		src = tap
+	}
+	handoffTried := false
 	for {
-		frame, err := session.ReadFrame(reader)
+		if tap != nil {
+			tap.buf = tap.buf[:0]
+		}
+		frame, err := session.ReadFrame(src)
 		if err != nil {
+			if h.isMoving(session) {
+				// The client resumed the session on another connection,
+				// which serves it from now on.
+				return nil
+			}
+			monitor.Failed(err)
+			if tap != nil && !handoffTried && h.isDraining() {
+				handoffTried = true
+				buffered, _ := reader.Peek(reader.Buffered())
+				pending := append(append([]byte(nil), tap.buf...), buffered...)
+				if err := h.handOff(conn, session, user, grant, established, pending); err == nil {
+					return nil
+				}
+				// Nobody to take over: drain like any other session.
+				reader = bufio.NewReader(io.MultiReader(bytes.NewReader(pending), conn))
+				tap.r = reader
+				_ = conn.SetDeadline(h.drainDeadline())
+				if err := reflex.CloseSession(session, conn, reflex.CloseReasonShutdown); err != nil {
+					return err
+				}
+				continue
+			}
 			if err == io.EOF {
 				return nil
 			}
+			if challenge != nil {
+				return errors.New("reflex: challenge not answered")
+			}
 			return err
 		}
+		if challenge == nil && anomalies.Observe(frame) {
+			if challenge, err = reflex.NewChallenge(); err != nil {
+				return err
+			}
+			if err := session.WriteFrame(conn, reflex.FrameTypeChallenge, challenge); err != nil {
+				return err
+			}
+			_ = conn.SetReadDeadline(time.Now().Add(reflex.ChallengeTimeout))
+		}
 		switch frame.Type {
 		case reflex.FrameTypeData:
-			if dispatcher != nil {
-				dest := net.TCPDestination(net.ParseAddress("127.0.0.1"), net.Port(80))
-				link, err := dispatcher.Dispatch(ctx, dest)
-				if err != nil {
-					continue
+			if err := uplink(0, frame.Payload); err != nil {
+				return err
+			}
+		case reflex.FrameTypeStreamOpen, reflex.FrameTypeStreamData, reflex.FrameTypeStreamClose:
+			if !grant.HasFeature(reflex.FeatureMux) {
+				continue
+			}
+			sf, err := reflex.ParseStreamFrame(frame)
+			if err != nil {
+				return err
+			}
+			if sf.ID == 0 {
+				return errors.New("reflex: stream 0 is the session's own")
+			}
+			switch frame.Type {
+			case reflex.FrameTypeStreamOpen:
+				err = openStream(sf.ID, sf.Destination)
+			case reflex.FrameTypeStreamData:
+				// Data of a stream that is not open is dropped.
+				cipher := relays.ciphers[sf.ID]
+				if cipher == nil {
+					break
 				}
-				b := buf.New()
-				b.Write(frame.Payload)
-				_ = link.Writer.WriteMultiBuffer(buf.MultiBuffer{b})
-				if c, ok := link.Writer.(interface{ Close() error }); ok {
-					_ = c.Close()
+				var data []byte
+				if data, err = cipher.Open(sf.Data); err == nil && len(data) > 0 {
+					err = uplink(sf.ID, data)
 				}
-				mb, err := link.Reader.ReadMultiBuffer()
-				if err == nil && !mb.IsEmpty() {
-					data := make([]byte, mb.Len())
-					mb.Copy(data)
-					_ = reflex.WriteFrameWithMorphing(session, conn, reflex.FrameTypeData, data, profile)
-					buf.ReleaseMulti(mb)
+			default:
+				if r := relays.streams[sf.ID]; r != nil {
+					r.close()
+					relays.forget(sf.ID)
 				}
 			}
+			if err != nil {
+				return err
+			}
+		case reflex.FrameTypeDatagram:
+			if !grant.HasFeature(reflex.FeatureUDP) {
+				continue
+			}
+			dg, err := reflex.ParseDatagram(frame)
+			if err != nil {
+				return err
+			}
+			if err := datagram(dg); err != nil {
+				return err
+			}
 		case reflex.FrameTypePaddingCtrl, reflex.FrameTypeTimingCtrl:
-			reflex.ApplyControlFrame(profile, frame.Type, frame.Payload)
+			shapeMu.Lock()
+			p := profile
+			shapeMu.Unlock()
+			reflex.ApplyControlFrame(p, frame.Type, frame.Payload)
+		case reflex.FrameTypeAddr:
+			d, err := reflex.UnmarshalDestination(frame.Payload)
+			if err != nil {
+				return err
+			}
+			if r := relays.streams[0]; r != nil && d != dest {
+				// The data that follows is for another destination.
+				r.close()
+				delete(relays.streams, 0)
+			}
+			dest = d
+			session.SetDestination(d)
+			if err := shapeFor(h.hostProfile(d.Host)); err != nil {
+				return err
+			}
+		case reflex.FrameTypeChallengeResponse:
+			if challenge == nil {
+				continue
+			}
+			secret := user.Account.(*MemoryAccount).Secret()
+			if !reflex.VerifyChallengeResponse(secret, challenge, frame.Payload) {
+				_ = conn.Close()
+				return errors.New("reflex: challenge failed")
+			}
+			challenge = nil
+			anomalies.Reset()
+			_ = conn.SetReadDeadline(time.Time{})
+		case reflex.FrameTypeProfileSwitch:
+			// The client saw a trigger fire (e.g. throttling); only scheduled profiles are honoured.
+			name := string(frame.Payload)
+			shapeMu.Lock()
+			allowed := schedule.Allows(name)
+			shapeMu.Unlock()
+			if p := h.lookupProfile(name); p != nil && allowed {
+				setProfile(p)
+			}
+		case reflex.FrameTypePolicyRequest:
+			// Entitlements may have changed since the handshake; a denial ends the session.
+			renewed, err := h.evaluatePolicy(ctx, user, frame.Payload)
+			if err != nil {
+				_ = conn.Close()
+				return err
+			}
+			if err := session.WriteFrame(conn, reflex.FrameTypePolicyGrant, renewed.Marshal()); err != nil {
+				return err
+			}
+			applyGrant(renewed)
+			if err := reportUsage(true); err != nil {
+				return err
+			}
+		case reflex.FrameTypeClose:
+			// The client is done with the session.
+			return nil
 		default:
 			// Unknown frame type; ignore.
 		}
 	}
 }
 
-func (h *Handler) writeHTTPErrorAndClose(conn stat.Connection, reason string) error {
-	body := []byte(`{"error":"` + reason + `"}`)
-	resp := "HTTP/1.1 403 Forbidden\r\nContent-Type: application/json\r\nContent-Length: "
-	resp += strconv.Itoa(len(body))
-	resp += "\r\n\r\n"
-	if _, err := conn.Write([]byte(resp)); err != nil {
+// track registers a live session for draining and termination. It reports
+// false, with the reason to close the session for, once the handler is
+// shutting down or if the user was removed since authenticating.
+func (h *Handler) track(session *reflex.Session, conn stat.Connection, user *protocol.MemoryUser, established time.Time, relays *sessionRelays) (string, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.draining {
+		return reflex.CloseReasonShutdown, false
+	}
+	// Checked under mu: a removal that is not seen here terminates the
+	// session once it is registered.
+	if !h.settings.Load().hasUser(user.Email) {
+		return reflex.CloseReasonUserRemoved, false
+	}
+	h.sessions[session] = &liveSession{conn: conn, user: user.Email, account: user, established: established, relays: relays, ended: make(chan struct{})}
+	id, _ := session.Resumption()
+	h.resumable[id] = session
+	h.active.Add(1)
+	return "", true
+}
+
+func (h *Handler) untrack(session *reflex.Session) {
+	h.mu.Lock()
+	if live := h.sessions[session]; live != nil {
+		close(live.ended)
+	}
+	delete(h.sessions, session)
+	id, _ := session.Resumption()
+	delete(h.resumable, id)
+	h.mu.Unlock()
+	h.active.Done()
+}
+
+func (h *Handler) isDraining() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.draining
+}
+
+func (h *Handler) drainDeadline() time.Time {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.deadline
+}
+
+// terminateWriteTimeout bounds how long sending the CLOSE frame of a
+// terminated session may take.
+const terminateWriteTimeout = time.Second
+
+// terminate ends a session at once: the peer is told why, then the
+// connection is closed without waiting for streams in flight.
+func terminate(session *reflex.Session, conn stat.Connection, reason string) {
+	_ = conn.SetWriteDeadline(time.Now().Add(terminateWriteTimeout))
+	session.SetWriteTimeout(terminateWriteTimeout)
+	_ = reflex.CloseSession(session, conn, reason)
+	_ = conn.Close()
+}
+
+// terminateUsers terminates the live sessions of the users with the given
+// emails and returns how many were terminated.
+func (h *Handler) terminateUsers(emails map[string]bool, reason string) int {
+	h.mu.Lock()
+	victims := make(map[*reflex.Session]stat.Connection)
+	for session, live := range h.sessions {
+		if emails[live.user] {
+			victims[session] = live.conn
+		}
+	}
+	h.mu.Unlock()
+	for session, conn := range victims {
+		terminate(session, conn, reason)
+	}
+	return len(victims)
+}
+
+// Close implements common.Closable. It is called when the inbound is removed
+// or the server shuts down: new connections are refused, every live session
+// gets a CLOSE frame and in-flight streams have drainTimeout to finish before
+// the remaining connections are closed.
+//
+// With a handoff socket, sessions are first offered to the process listening
+// on it; only those it does not take are drained.
+func (h *Handler) Close() error {
+	h.mu.Lock()
+	if h.draining {
+		h.mu.Unlock()
+		return nil
+	}
+	h.draining = true
+	close(h.done)
+	h.deadline = time.Now().Add(h.drainTimeout)
+	deadline := h.deadline
+	sessions := make(map[*reflex.Session]stat.Connection, len(h.sessions))
+	for session, live := range h.sessions {
+		sessions[session] = live.conn
+	}
+	h.mu.Unlock()
+
+	// No new handshakes from here on; a successor may start loading the
+	// state before the drain is over.
+	h.saveState(context.Background())
+	h.closeCarriers()
+
+	if h.handoffs != nil {
+		_ = h.handoffs.Close()
+		// Wake every session loop; each hands itself off between frames.
+		for _, conn := range sessions {
+			_ = conn.SetReadDeadline(time.Now())
+		}
+	} else {
+		for session, conn := range sessions {
+			// A peer that stopped reading must not hold up the others.
+			_ = conn.SetWriteDeadline(deadline)
+			session.SetWriteTimeout(time.Until(deadline))
+			_ = reflex.CloseSession(session, conn, reflex.CloseReasonShutdown)
+		}
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		h.active.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-time.After(time.Until(deadline)):
+		h.mu.Lock()
+		for _, live := range h.sessions {
+			_ = live.conn.Close()
+		}
+		h.mu.Unlock()
+	}
+	if h.captureFile != nil {
+		_ = h.captureFile.Close()
+	}
+	if h.keyLog != nil {
+		_ = h.keyLog.Close()
+	}
+	if h.decoy != nil {
+		_ = h.decoy.Close()
+	}
+	if h.healthServer != nil {
+		_ = h.healthServer.Close()
+	}
+	return nil
+}
+
+// handOff passes a session to the process listening on the handoff socket.
+// The session must not be used afterwards.
+func (h *Handler) handOff(conn stat.Connection, session *reflex.Session, user *protocol.MemoryUser, grant *reflex.PolicyGrant, established time.Time, pending []byte) error {
+	tcp, ok := tcpConn(conn)
+	if !ok {
+		return errors.New("reflex: connection cannot be handed off")
+	}
+	return handoff.Send(h.handoffPath, tcp, &handoff.State{
+		Session:     session.State(),
+		User:        user.Account.(*MemoryAccount).Id,
+		Grant:       grant,
+		Established: established,
+		Pending:     pending,
+	})
+}
+
+// ServeHandoffs resumes the sessions handed off by the process this one
+// replaces, until the handler is closed. New starts it automatically when
+// running inside an Xray instance; embedders call it with their dispatcher.
+func (h *Handler) ServeHandoffs(ctx context.Context, dispatcher routing.Dispatcher) {
+	if h.handoffs == nil {
+		return
+	}
+	for {
+		conn, state, err := h.handoffs.Accept()
+		if err != nil {
+			if errors.Is(err, stdnet.ErrClosed) {
+				return
+			}
+			xerrors.LogInfoInner(ctx, err, "reflex: failed to accept handoff")
+			continue
+		}
+		go func() {
+			if err := h.resume(ctx, conn, state, dispatcher); err != nil {
+				xerrors.LogInfoInner(ctx, err, "reflex: handed off session ended")
+			}
+		}()
+	}
+}
+
+// resume continues a session handed off by a previous process.
+func (h *Handler) resume(ctx context.Context, conn stdnet.Conn, state *handoff.State, dispatcher routing.Dispatcher) error {
+	var user *protocol.MemoryUser
+	for _, u := range h.settings.Load().clients {
+		if u.Account.(*MemoryAccount).Id == state.User {
+			user = u
+			break
+		}
+	}
+	if user == nil || state.Session == nil || state.Grant == nil {
+		_ = conn.Close()
+		return errors.New("reflex: cannot resume session of user " + state.User)
+	}
+	session, err := reflex.RestoreSession(state.Session)
+	if err != nil {
 		_ = conn.Close()
 		return err
 	}
-	if _, err := conn.Write(body); err != nil {
+	ctx = xsession.ContextWithInbound(ctx, &xsession.Inbound{
+		Source: net.DestinationFromAddr(conn.RemoteAddr()),
+		Tag:    h.Tag(),
+		Conn:   conn,
+	})
+	reader := bufio.NewReader(io.MultiReader(bytes.NewReader(state.Pending), conn))
+	established := state.Established
+	if established.IsZero() {
+		// Handed off by a process that did not pass it on.
+		established = time.Now()
+	}
+	return h.handleSession(ctx, reader, conn, dispatcher, session, user, state.Grant, established, nil)
+}
+
+// tcpConn returns the TCP connection under conn, if there is one.
+func tcpConn(conn stdnet.Conn) (*stdnet.TCPConn, bool) {
+	for {
+		switch c := conn.(type) {
+		case *stdnet.TCPConn:
+			return c, true
+		case *stat.CounterConnection:
+			conn = c.Connection
+		case *capture.Conn:
+			conn = c.Conn
+		case *reflex.StrategyConn:
+			conn = c.Conn
+		default:
+			return nil, false
+		}
+	}
+}
+
+// tapReader records what is read through it since buf was last reset.
+type tapReader struct {
+	r   io.Reader
+	buf []byte
+}
+
+func (t *tapReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	t.buf = append(t.buf, p[:n]...)
+	return n, err
+}
+
+// logSampled logs an event of a high-frequency kind with log, unless the
+// handler's LogSampler suppresses it. A logged event tells how many like it
+// were suppressed before it.
+func (h *Handler) logSampled(ctx context.Context, log func(context.Context, ...interface{}), kind string, msg ...interface{}) {
+	ok, suppressed := h.logs.Sample(kind, time.Now())
+	if !ok {
+		return
+	}
+	if suppressed > 0 {
+		msg = append(msg, " (", suppressed, " more suppressed)")
+	}
+	log(ctx, msg...)
+}
+
+// logProfile logs the traffic profile a session of user is shaped with.
+func (h *Handler) logProfile(ctx context.Context, user *protocol.MemoryUser, profile *reflex.TrafficProfile) {
+	name := "none"
+	if profile != nil {
+		name = profile.Name
+	}
+	h.logSampled(ctx, xerrors.LogDebug, reflex.LogEventMorph, "reflex: session of ", user.Email, " shaped with profile ", name)
+}
+
+// SuppressedLogs returns how many events of each high-frequency kind were
+// not logged, see reflex.LogSampler.
+func (h *Handler) SuppressedLogs() map[string]uint64 {
+	return h.logs.Suppressed()
+}
+
+// reportInterference logs an interference event and adds it to the
+// per-network record, logging a reset burst it completes as well.
+func (h *Handler) reportInterference(ctx context.Context, e *reflex.InterferenceEvent) {
+	xerrors.LogWarning(ctx, e)
+	if burst := h.interference.Record(e); burst != nil {
+		xerrors.LogWarning(ctx, burst)
+	}
+}
+
+// Tag returns the tag of the inbound the handler serves. The proxy is not
+// told its tag when it is created; it is learned from the session metadata
+// of the first connection, and is empty until then.
+func (h *Handler) Tag() string {
+	tag, _ := h.tag.Load().(string)
+	return tag
+}
+
+// Interference returns the interference seen per client network, so
+// operators can map where Reflex is being blocked.
+func (h *Handler) Interference() []reflex.NetworkInterference {
+	return h.interference.Networks()
+}
+
+// maxLeakageReports bounds the reports kept for LeakageReports.
+const maxLeakageReports = 100
+
+// reportLeakage logs the leakage report of a session that ended and keeps it
+// among the latest.
+func (h *Handler) reportLeakage(ctx context.Context, audit *reflex.LeakageAudit) {
+	r := audit.Report()
+	if r.Samples == 0 {
+		return
+	}
+	xerrors.LogInfo(ctx, r)
+	h.mu.Lock()
+	h.leakage = append(h.leakage, r)
+	if len(h.leakage) > maxLeakageReports {
+		h.leakage = h.leakage[len(h.leakage)-maxLeakageReports:]
+	}
+	h.mu.Unlock()
+}
+
+// LeakageReports returns the leakage reports of the latest audited sessions,
+// oldest first. Sessions are only audited with leakage auditing configured.
+func (h *Handler) LeakageReports() []*reflex.LeakageReport {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]*reflex.LeakageReport(nil), h.leakage...)
+}
+
+// auditPolicy writes a policy decision to the log, so operators can tell why
+// a user was throttled or denied.
+func auditPolicy(ctx context.Context, audit *reflex.PolicyAudit) {
+	xerrors.LogInfo(ctx, audit)
+}
+
+// sourceAddress returns the host part of the peer address that retry cookies
+// and concurrent logins are keyed by, see reflex.SourceHost.
+func sourceAddress(conn stat.Connection) string {
+	return reflex.SourceHost(conn.RemoteAddr().String())
+}
+
+// retryWithoutCookie answers a handshake whose padding carries no valid
+// retry cookie with one, when cookies are required, and reports whether it
+// did.
+func (h *Handler) retryWithoutCookie(ctx context.Context, conn stat.Connection, padding []byte) (bool, error) {
+	if h.cookies == nil {
+		return false, nil
+	}
+	source := sourceAddress(conn)
+	if h.cookies.Verify(source, reflex.CookieFromPadding(padding), time.Now()) {
+		return false, nil
+	}
+	h.transportOutcome(ctx, nil, transportRetried)
+	return true, h.writeRetryAndClose(conn, h.cookies.Issue(source, time.Now()))
+}
+
+// writeRetryAndClose answers a handshake with a retry cookie and closes.
+func (h *Handler) writeRetryAndClose(conn stat.Connection, cookie []byte) error {
+	body, err := json.Marshal(ServerHandshake{RetryCookie: cookie})
+	if err != nil {
+		return err
+	}
+	if err := writeHTTPResponse(conn, "200 OK", body); err != nil {
+		_ = conn.Close()
+		return err
+	}
+	return conn.Close()
+}
+
+// writeHTTPResponse writes a minimal JSON HTTP/1.1 response. Like any web
+// server it sends a Date header; clients rejected for clock skew use it to
+// correct their handshake timestamp (see reflex.ClockOffset).
+func writeHTTPResponse(conn stat.Connection, status string, body []byte) error {
+	header := "HTTP/1.1 " + status + "\r\nDate: " + time.Now().UTC().Format(http.TimeFormat)
+	header += "\r\nContent-Type: application/json\r\nContent-Length: "
+	header += strconv.Itoa(len(body))
+	header += "\r\n\r\n"
+	if _, err := conn.Write([]byte(header)); err != nil {
+		return err
+	}
+	_, err := conn.Write(body)
+	return err
+}
+
+func (h *Handler) writeHTTPErrorAndClose(conn stat.Connection, reason string) error {
+	body := []byte(`{"error":"` + reason + `"}`)
+	if err := writeHTTPResponse(conn, "403 Forbidden", body); err != nil {
 		_ = conn.Close()
 		return err
 	}
@@ -472,20 +1894,23 @@ func (pc *preloadedConn) Write(b []byte) (int, error) {
 }
 
 // handleFallback forwards the connection (including already-peeked bytes)
-// to a local web server defined by h.fallback.
+// to the local web server of the current fallback settings.
 func (h *Handler) handleFallback(ctx context.Context, reader *bufio.Reader, conn stat.Connection) error {
-	if h.fallback == nil {
+	fallback := h.settings.Load().fallback
+	if fallback == nil {
 		_ = conn.Close()
 		return errors.New("no fallback configured")
 	}
 
+	_ = conn.SetReadDeadline(time.Time{})
+	h.logSampled(ctx, xerrors.LogInfo, reflex.LogEventProbe, "reflex: serving the fallback to ", sourceAddress(conn))
+
 	wrapped := &preloadedConn{
 		Reader:     reader,
 		Connection: conn,
 	}
 
-	targetAddr := fmt.Sprintf("127.0.0.1:%d", h.fallback.Dest)
-	target, err := stdnet.Dial("tcp", targetAddr)
+	target, err := stdnet.Dial("tcp", fallback.Target)
 	if err != nil {
 		_ = conn.Close()
 		return err
@@ -501,8 +1926,13 @@ func (h *Handler) handleFallback(ctx context.Context, reader *bufio.Reader, conn
 		errc <- e
 	}()
 
+	// Responses may be shaped like the frames of the Reflex path.
+	var responses io.Reader = target
+	if fallback.Jitter > 0 {
+		responses = &reflex.JitterReader{R: target, Max: fallback.Jitter}
+	}
 	go func() {
-		_, e := io.Copy(wrapped, target)
+		_, e := io.Copy(wrapped, responses)
 		_ = wrapped.Close()
 		errc <- e
 	}()
@@ -512,4 +1942,14 @@ func (h *Handler) handleFallback(ctx context.Context, reader *bufio.Reader, conn
 		return e
 	}
 	return nil
-}
\ No newline at end of file
+}
+
+// logKey appends a session key to the key log, if one is configured.
+func (h *Handler) logKey(nonce, sessionKey []byte) {
+	if h.keyLog == nil {
+		return
+	}
+	if err := reflex.WriteKeyLog(h.keyLog, nonce, sessionKey); err != nil {
+		xerrors.LogWarningInner(context.Background(), err, "reflex: failed to log session key")
+	}
+}
@@ -0,0 +1,56 @@
+package inbound
+
+import (
+	"sync"
+	"time"
+
+	"github.com/xtls/xray-core/common/protocol"
+	"github.com/xtls/xray-core/proxy/reflex"
+)
+
+// oneTimeIndex maps the one-time IDs of the configured clients to them. The
+// IDs of a step are computed once, on the first handshake stamped in it, and
+// dropped when the step is out of reflex.MaxClockSkew or the settings
+// change.
+type oneTimeIndex struct {
+	mu       sync.Mutex
+	settings *settings // what steps were computed from
+	steps    map[int64]map[[16]byte]*protocol.MemoryUser
+}
+
+// lookup returns the client whose one-time ID for the step of ts is id, or
+// nil.
+func (x *oneTimeIndex) lookup(s *settings, id [16]byte, ts int64) *protocol.MemoryUser {
+	counter := reflex.OneTimeIDCounter(ts)
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.settings != s {
+		x.settings, x.steps = s, nil
+	}
+	if x.steps == nil {
+		x.steps = make(map[int64]map[[16]byte]*protocol.MemoryUser)
+	}
+	ids, ok := x.steps[counter]
+	if !ok {
+		x.expire(time.Now())
+		ids = make(map[[16]byte]*protocol.MemoryUser, len(s.clients))
+		for _, user := range s.clients {
+			if acc, ok := user.Account.(*MemoryAccount); ok {
+				ids[reflex.OneTimeIDAt(acc.Secret(), counter)] = user
+			}
+		}
+		x.steps[counter] = ids
+	}
+	return ids[id]
+}
+
+// expire drops the steps no valid handshake timestamp falls in anymore.
+func (x *oneTimeIndex) expire(now time.Time) {
+	oldest := reflex.OneTimeIDCounter(now.Add(-reflex.MaxClockSkew).Unix())
+	newest := reflex.OneTimeIDCounter(now.Add(reflex.MaxClockSkew).Unix())
+	for counter := range x.steps {
+		if counter < oldest || counter > newest {
+			delete(x.steps, counter)
+		}
+	}
+}
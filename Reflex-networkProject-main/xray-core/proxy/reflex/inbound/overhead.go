@@ -0,0 +1,39 @@
+package inbound
+
+import (
+	"github.com/xtls/xray-core/features/stats"
+	"github.com/xtls/xray-core/proxy/reflex"
+)
+
+// accountOverhead counts what the frames of session carried since it was
+// last accounted against user. In a running instance the counts are added
+// to the user's stats counters as well:
+//
+//	user>>>{email}>>>reflex>>>payload
+//	user>>>{email}>>>reflex>>>padding
+//	user>>>{email}>>>reflex>>>cover
+func (h *Handler) accountOverhead(user string, session *reflex.Session) {
+	o := session.TakeOverhead()
+	if o.Total() == 0 {
+		return
+	}
+	h.overhead.Add(user, o)
+	if h.stats == nil || user == "" {
+		return
+	}
+	for kind, n := range map[string]uint64{"payload": o.Payload, "padding": o.Padding, "cover": o.Cover} {
+		if n == 0 {
+			continue
+		}
+		if c, _ := stats.GetOrRegisterCounter(h.stats, "user>>>"+user+">>>reflex>>>"+kind); c != nil {
+			c.Add(int64(n))
+		}
+	}
+}
+
+// Overhead returns the payload, morphing padding and cover traffic the
+// sessions of the user with the given email carried since the inbound
+// started, so operators can quantify what the obfuscation costs.
+func (h *Handler) Overhead(email string) reflex.Overhead {
+	return h.overhead.Overhead(email)
+}
@@ -0,0 +1,59 @@
+package inbound
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/proxy/reflex/carrier"
+)
+
+// headMagics are the magic numbers a connection served here may start with.
+var headMagics = []uint32{ReflexMagic, reflex.SealedMagic, reflex.PSKMagic, reflex.ResumeMagic, reflex.BondMagic, carrier.ChannelMagic}
+
+// peekHead peeks at the first bytes of a connection, as many as it takes to
+// tell what the client speaks and no more. A client's first segment may be
+// as small as its transport makes it; waiting for a fixed number of bytes
+// would both stall such clients and tell a probe which connections are
+// ours. Bytes that cannot start anything served here are decided on at
+// once. Otherwise more are waited for, up to the read deadline, and what
+// arrived by then is decided on; only a connection that sent nothing at
+// all ends in err.
+func (h *Handler) peekHead(reader *bufio.Reader) ([]byte, error) {
+	for {
+		b, _ := reader.Peek(reader.Buffered())
+		if len(b) > 0 && (len(b) == reader.Size() || !h.undecided(b)) {
+			return b, nil
+		}
+		// Wait for one byte more than already buffered.
+		if _, err := reader.Peek(len(b) + 1); err != nil {
+			if len(b) == 0 {
+				return nil, err
+			}
+			return b, nil
+		}
+	}
+}
+
+// undecided reports whether head, the first bytes of a connection, may
+// still turn out to be a handshake with more of them: a prefix of a magic
+// number, a POST whose request line has not arrived, or the start of a
+// VLESS header.
+func (h *Handler) undecided(head []byte) bool {
+	if len(head) < 4 {
+		var magic [4]byte
+		for _, m := range headMagics {
+			binary.BigEndian.PutUint32(magic[:], m)
+			if bytes.HasPrefix(magic[:], head) {
+				return true
+			}
+		}
+		if bytes.HasPrefix([]byte("POST"), head) {
+			return true
+		}
+	} else if string(head[:4]) == "POST" && bytes.IndexByte(head, '\n') < 0 && !containsHTTPVersion(head) {
+		return true
+	}
+	return h.vless != nil && head[0] == 0 && len(head) < vlessHeaderSize
+}
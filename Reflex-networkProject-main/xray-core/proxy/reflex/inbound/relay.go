@@ -0,0 +1,226 @@
+package inbound
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xtls/xray-core/common"
+	"github.com/xtls/xray-core/common/buf"
+	"github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/features/routing"
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/transport"
+	"github.com/xtls/xray-core/transport/internet/stat"
+)
+
+// relayCloseTimeout bounds how long closing a relay waits for what is
+// being sent back to the client, so that it is accounted with the session.
+const relayCloseTimeout = time.Second
+
+// relay is the link the DATA frames of a session, or those of one of its
+// streams, are carried over to their destination. Both directions are pumped at once: the session loop writes
+// what the client sends, and a goroutine sends back what the destination
+// answers as it comes, so that long-lived connections such as SSH,
+// WebSockets or video work through the session.
+//
+// A relay to a UDP destination carries datagrams: each write is one, and
+// each is sent back on its own.
+type relay struct {
+	link      *transport.Link
+	datagrams bool
+	done      chan struct{} // closed once the destination stopped answering
+	closing   atomic.Bool   // set by close
+}
+
+// openRelay dispatches a link to dest and sends what comes back over it
+// with send until the link ends or send fails. ended, if not nil, is
+// called once it did, unless the relay was closed first.
+func openRelay(ctx context.Context, dispatcher routing.Dispatcher, dest net.Destination, send func([]byte) error, ended func()) (*relay, error) {
+	link, err := dispatcher.Dispatch(ctx, dest)
+	if err != nil {
+		return nil, err
+	}
+	r := &relay{link: link, datagrams: dest.Network == net.Network_UDP, done: make(chan struct{})}
+	go r.downlink(send, ended)
+	return r, nil
+}
+
+func (r *relay) downlink(send func([]byte) error, ended func()) {
+	defer close(r.done)
+	if ended != nil {
+		defer func() {
+			if !r.closing.Load() {
+				ended()
+			}
+		}()
+	}
+	for {
+		mb, err := r.link.Reader.ReadMultiBuffer()
+		if !mb.IsEmpty() {
+			datagrams := []buf.MultiBuffer{mb}
+			if r.datagrams {
+				datagrams = datagrams[:0]
+				for _, b := range mb {
+					datagrams = append(datagrams, buf.MultiBuffer{b})
+				}
+			}
+			for i, mb := range datagrams {
+				data := make([]byte, mb.Len())
+				mb.Copy(data)
+				buf.ReleaseMulti(mb)
+				if err := send(data); err != nil {
+					for _, rest := range datagrams[i+1:] {
+						buf.ReleaseMulti(rest)
+					}
+					common.Interrupt(r.link.Reader)
+					return
+				}
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// ended reports whether the destination stopped answering, after which the
+// link is of no more use.
+func (r *relay) ended() bool {
+	select {
+	case <-r.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// write sends b to the destination.
+func (r *relay) write(b []byte) error {
+	if r.datagrams {
+		// One buffer, however large, so the datagram stays whole.
+		return r.link.Writer.WriteMultiBuffer(buf.MultiBuffer{buf.FromBytes(append([]byte(nil), b...))})
+	}
+	return r.link.Writer.WriteMultiBuffer(buf.MergeBytes(nil, b))
+}
+
+// close ends the link and waits, up to relayCloseTimeout, for the frame
+// being sent back, if any. What the destination still answers is dropped.
+func (r *relay) close() {
+	r.closing.Store(true)
+	common.Close(r.link.Writer)
+	common.Interrupt(r.link.Reader)
+	select {
+	case <-r.done:
+	case <-time.After(relayCloseTimeout):
+	}
+}
+
+// sessionRelays are the relays of a session: the DATA frames are relayed
+// over one link to their destination at a time, and those of each stream
+// the client opened, see reflex.FeatureMux, over a link of their own, by
+// stream ID, 0 for DATA. Datagrams, see reflex.FeatureUDP, are relayed over
+// a link per destination. The data of a stream is opened with its cipher.
+//
+// The maps belong to the loop serving the session. They outlive its
+// connection: when the client resumes the session on another one, see
+// handleResume, the loop serving it there takes them over, and what the
+// destinations answer in between waits for it.
+type sessionRelays struct {
+	streams   map[uint32]*relay
+	ciphers   map[uint32]*reflex.StreamCipher
+	datagrams map[reflex.Destination]*relay
+
+	moving func() bool // reports whether the session moves to another connection
+	mu     sync.Mutex
+	cond   *sync.Cond
+	out    *relayOut // of the loop serving the session, nil between loops
+	gen    int       // counts the loops that served the session
+	closed bool
+}
+
+// relayOut is what the loop serving a session sends what its relays bring
+// back with.
+type relayOut struct {
+	conn     stat.Connection
+	sendData func(frameType uint8, payload []byte, n int) error
+}
+
+var errRelaysClosed = errors.New("reflex: session ended")
+
+func newSessionRelays(moving func() bool) *sessionRelays {
+	s := &sessionRelays{
+		streams:   make(map[uint32]*relay),
+		ciphers:   make(map[uint32]*reflex.StreamCipher),
+		datagrams: make(map[reflex.Destination]*relay),
+		moving:    moving,
+	}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+// forget drops stream id, once its relay is closed.
+func (s *sessionRelays) forget(id uint32) {
+	delete(s.streams, id)
+	delete(s.ciphers, id)
+}
+
+// attach makes out what the relays send with from now on.
+func (s *sessionRelays) attach(out *relayOut) {
+	s.mu.Lock()
+	s.out = out
+	s.gen++
+	s.cond.Broadcast()
+	s.mu.Unlock()
+}
+
+// detach lets go of the loop that served the session, which moves to
+// another connection. Sends wait for the loop that serves it there.
+func (s *sessionRelays) detach() {
+	s.mu.Lock()
+	s.out = nil
+	s.mu.Unlock()
+}
+
+// send runs f with the loop serving the session. If f fails because the
+// session is moving to another connection, it runs again with the loop
+// that takes over.
+func (s *sessionRelays) send(f func(out *relayOut) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for {
+		for s.out == nil && !s.closed {
+			s.cond.Wait()
+		}
+		if s.closed {
+			return errRelaysClosed
+		}
+		out, gen := s.out, s.gen
+		s.mu.Unlock()
+		err := f(out)
+		s.mu.Lock()
+		if err == nil || (s.gen == gen && s.out != nil && !s.moving()) {
+			return err
+		}
+		for s.gen == gen && !s.closed {
+			s.cond.Wait()
+		}
+	}
+}
+
+// close ends the relays, once the session ended.
+func (s *sessionRelays) close() {
+	s.mu.Lock()
+	s.out = nil
+	s.closed = true
+	s.cond.Broadcast()
+	s.mu.Unlock()
+	for _, r := range s.streams {
+		r.close()
+	}
+	for _, r := range s.datagrams {
+		r.close()
+	}
+}
@@ -0,0 +1,275 @@
+package inbound
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	xerrors "github.com/xtls/xray-core/common/errors"
+	"github.com/xtls/xray-core/common/protocol"
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/proxy/reflex/policyrpc"
+)
+
+// settings is the part of the configuration that can change at runtime. A
+// snapshot is never modified once published: Reload and the user manager
+// methods build a new one and swap it in, so every handshake sees one
+// consistent configuration and established sessions keep the user and grant
+// they started with until their user is removed.
+type settings struct {
+	clients        []*protocol.MemoryUser
+	fallback       *FallbackConfig
+	policy         reflex.PolicyDecider
+	denyMalformed  bool                        // refuse handshakes whose policy request does not parse
+	logins         *reflex.LoginTracker        // non-nil when concurrent logins are tracked
+	loginConfig    *reflex.ConcurrentLogin     // what logins was built from
+	fronts         []*reflex.CoverFront        // HTTP handshakes must name one of these; empty accepts any
+	strategy       *reflex.WireStrategy        // applied to every new connection; nil for none
+	ids            reflex.IDMode               // which user identifiers handshakes may carry
+	resumeRotation time.Duration               // granted, see reflex.PolicyGrant.ResumeRotation
+	destProfiles   *reflex.DestinationProfiles // nil when streams are not shaped by destination
+	morphing       reflex.MorphingMode         // of sessions whose user does not set one
+}
+
+// hasUser reports whether a client with the given email is configured.
+func (s *settings) hasUser(email string) bool {
+	for _, c := range s.clients {
+		if c.Email == email {
+			return true
+		}
+	}
+	return false
+}
+
+// buildSettings builds the reloadable settings from config. The login tracker
+// of prev is kept when its configuration is unchanged, so a reload does not
+// forget who is logged in from where.
+func buildSettings(ctx context.Context, config *reflex.InboundConfig, prev *settings) (*settings, error) {
+	s := &settings{
+		clients:       make([]*protocol.MemoryUser, 0, len(config.Clients)),
+		denyMalformed: config.DenyByDefault,
+		fronts:        config.CoverFronts,
+	}
+
+	policy, err := reflex.NewPolicyEngineFromConfig(config.Policies)
+	if err != nil {
+		return nil, err
+	}
+	if config.DefaultPolicy != "" && !hasPolicy(config.Policies, config.DefaultPolicy) {
+		return nil, fmt.Errorf("reflex: default policy %s is not defined", config.DefaultPolicy)
+	}
+	policy.DefaultPolicy = config.DefaultPolicy
+	policy.DenyByDefault = config.DenyByDefault
+	s.policy = policy
+
+	if s.ids, err = reflex.ParseIDMode(config.HandshakeIds); err != nil {
+		return nil, err
+	}
+	if s.morphing, err = reflex.ParseMorphingMode(config.Morphing); err != nil {
+		return nil, err
+	}
+	s.resumeRotation = time.Duration(config.ResumeRotation) * time.Second
+	if len(config.DestinationProfiles) > 0 {
+		if s.destProfiles, err = reflex.NewDestinationProfiles(config.DestinationProfiles); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, client := range config.Clients {
+		user, err := newMemoryUser(client)
+		if err != nil {
+			return nil, err
+		}
+		s.clients = append(s.clients, user)
+	}
+
+	if ws := config.Strategy; ws != nil && ws.Name != "" {
+		if _, err := reflex.NewStrategy(ws.Name, ws.Args); err != nil {
+			return nil, err
+		}
+		s.strategy = ws
+	}
+
+	if config.Fallback != nil {
+		s.fallback = &FallbackConfig{
+			Dest:   config.Fallback.Dest,
+			Target: config.Fallback.Target(),
+			Jitter: time.Duration(config.Fallback.Jitter) * time.Millisecond,
+		}
+	}
+	if cl := config.ConcurrentLogin; cl != nil && cl.MaxSources > 0 {
+		if prev != nil && prev.logins != nil && proto.Equal(prev.loginConfig, cl) {
+			s.logins = prev.logins
+		} else {
+			action, err := reflex.ParseLoginAction(cl.Action)
+			if err != nil {
+				return nil, err
+			}
+			window := time.Duration(cl.Window) * time.Second
+			if window <= 0 {
+				window = 10 * time.Minute
+			}
+			s.logins = reflex.NewLoginTracker(window, int(cl.MaxSources), action)
+			s.logins.OnAlert = func(user string, sources int) {
+				xerrors.LogWarning(ctx, "reflex: user ", user, " logged in from ", sources, " sources")
+			}
+		}
+		s.loginConfig = cl
+	}
+
+	// The policy server is dialed last so that nothing above can fail after
+	// a connection was opened.
+	if ps := config.PolicyServer; ps != nil && ps.Address != "" {
+		remote, err := policyrpc.Dial(ps.Address)
+		if err != nil {
+			return nil, err
+		}
+		if ps.Timeout > 0 {
+			remote.Timeout = time.Duration(ps.Timeout) * time.Millisecond
+		}
+		if ps.CacheTtl > 0 {
+			remote.CacheTTL = time.Duration(ps.CacheTtl) * time.Second
+		}
+		remote.FailOpen = ps.FailOpen
+		remote.Fallback = policy
+		s.policy = remote
+	}
+	return s, nil
+}
+
+// newMemoryUser converts a configured client. Its email names it in stats
+// counters, access logs and the user manager; without one the UUID does.
+func newMemoryUser(client *reflex.User) (*protocol.MemoryUser, error) {
+	account, err := (&reflex.Account{
+		Id:       client.Id,
+		Policy:   client.Policy,
+		Psk:      client.Psk,
+		Expiry:   client.Expiry,
+		Quota:    client.Quota,
+		Morphing: client.Morphing,
+	}).AsAccount()
+	if err != nil {
+		return nil, err
+	}
+	email := client.Email
+	if email == "" {
+		email = client.Id
+	}
+	return &protocol.MemoryUser{
+		Email:   email,
+		Level:   client.Level,
+		Account: account,
+	}, nil
+}
+
+// Reload applies the clients, fallback, policies and concurrent-login limits
+// of config without dropping established sessions, except those of clients
+// that are no longer configured. Either all of them are applied or, if config
+// is invalid, none. Listener settings such as port hopping, the handoff
+// socket and the health path need a restart.
+func (h *Handler) Reload(ctx context.Context, config proto.Message) error {
+	c, ok := config.(*reflex.InboundConfig)
+	if !ok {
+		return errors.New("reflex: not a reflex inbound config")
+	}
+	h.settingsMu.Lock()
+	defer h.settingsMu.Unlock()
+	prev := h.settings.Load()
+	s, err := buildSettings(ctx, c, prev)
+	if err != nil {
+		return err
+	}
+	h.settings.Store(s)
+	for _, w := range SelfCheck(c) {
+		xerrors.LogWarning(ctx, "reflex: ", w)
+	}
+	if closer, ok := prev.policy.(io.Closer); ok {
+		_ = closer.Close()
+	}
+	removed := make(map[string]bool)
+	for _, c := range prev.clients {
+		if !s.hasUser(c.Email) {
+			removed[c.Email] = true
+		}
+	}
+	terminated := h.terminateUsers(removed, reflex.CloseReasonUserRemoved)
+	xerrors.LogInfo(ctx, "reflex: reloaded settings with ", len(s.clients), " clients, terminated ", terminated, " sessions of removed clients")
+	return nil
+}
+
+// update publishes a copy of the current settings changed by f.
+func (h *Handler) update(f func(s *settings) error) error {
+	h.settingsMu.Lock()
+	defer h.settingsMu.Unlock()
+	s := *h.settings.Load()
+	if err := f(&s); err != nil {
+		return err
+	}
+	h.settings.Store(&s)
+	return nil
+}
+
+// AddUser implements proxy.UserManager.AddUser().
+func (h *Handler) AddUser(ctx context.Context, u *protocol.MemoryUser) error {
+	account, ok := u.Account.(*MemoryAccount)
+	if !ok {
+		return errors.New("reflex: not a reflex account")
+	}
+	if u.Email == "" {
+		u.Email = account.Id
+	}
+	return h.update(func(s *settings) error {
+		for _, c := range s.clients {
+			if c.Email == u.Email || c.Account.(*MemoryAccount).Id == account.Id {
+				return errors.New("reflex: user " + u.Email + " already exists")
+			}
+		}
+		s.clients = append(s.clients[:len(s.clients):len(s.clients)], u)
+		return nil
+	})
+}
+
+// RemoveUser implements proxy.UserManager.RemoveUser(). Live sessions of the
+// user are terminated.
+func (h *Handler) RemoveUser(ctx context.Context, email string) error {
+	err := h.update(func(s *settings) error {
+		for i, c := range s.clients {
+			if c.Email == email {
+				clients := make([]*protocol.MemoryUser, 0, len(s.clients)-1)
+				s.clients = append(append(clients, s.clients[:i]...), s.clients[i+1:]...)
+				return nil
+			}
+		}
+		return errors.New("reflex: user " + email + " not found")
+	})
+	if err != nil {
+		return err
+	}
+	h.terminateUsers(map[string]bool{email: true}, reflex.CloseReasonUserRemoved)
+	return nil
+}
+
+// GetUser implements proxy.UserManager.GetUser().
+func (h *Handler) GetUser(ctx context.Context, email string) *protocol.MemoryUser {
+	for _, c := range h.settings.Load().clients {
+		if c.Email == email {
+			return c
+		}
+	}
+	return nil
+}
+
+// GetUsers implements proxy.UserManager.GetUsers().
+func (h *Handler) GetUsers(ctx context.Context) []*protocol.MemoryUser {
+	clients := h.settings.Load().clients
+	return append([]*protocol.MemoryUser(nil), clients...)
+}
+
+// GetUsersCount implements proxy.UserManager.GetUsersCount().
+func (h *Handler) GetUsersCount(context.Context) int64 {
+	return int64(len(h.settings.Load().clients))
+}
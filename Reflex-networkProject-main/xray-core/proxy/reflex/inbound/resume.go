@@ -0,0 +1,136 @@
+package inbound
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"time"
+
+	"github.com/xtls/xray-core/features/routing"
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/transport/internet/stat"
+)
+
+// handleResume serves a resumption, see reflex.ResumeMagic: the session is
+// taken from the connection it was served on and served on this one from
+// now on, with the grant in force and the relays it had open. Requests for sessions that are unknown,
+// fail to authenticate or are replayed get the same answer as a stranger.
+func (h *Handler) handleResume(ctx context.Context, reader *bufio.Reader, conn stat.Connection, dispatcher routing.Dispatcher) error {
+	session, live, reason, err := h.readAttach(reader, reflex.VerifyResume)
+	if err != nil {
+		return err
+	}
+	if live == nil {
+		return h.writeHTTPErrorAndClose(conn, reason)
+	}
+
+	h.mu.Lock()
+	if live.moving {
+		h.mu.Unlock()
+		return h.writeHTTPErrorAndClose(conn, "forbidden")
+	}
+	live.moving = true
+	old, grant, established := live.conn, live.grant, live.established
+	h.mu.Unlock()
+	// Closing the old connection makes the session's loop let go of it.
+	_ = old.Close()
+	select {
+	case <-live.ended:
+	case <-time.After(ReflexHandshakeTimeout):
+		_ = conn.Close()
+		// Nobody takes over the relays the session's loop lets go of.
+		go func() {
+			<-live.ended
+			live.relays.close()
+		}()
+		return errors.New("reflex: resumed session was not released")
+	}
+
+	_ = conn.SetReadDeadline(time.Time{})
+	return h.handleSession(ctx, reader, conn, dispatcher, session, live.account, grant, established, live.relays)
+}
+
+// handleBond serves a bonding request, see reflex.BondMagic: the connection
+// becomes one more lane of the session's bond until either ends. Requests
+// are checked like resumptions, and sessions that were not granted
+// reflex.FeatureBonding are treated as unknown.
+func (h *Handler) handleBond(reader *bufio.Reader, conn stat.Connection) error {
+	_, live, reason, err := h.readAttach(reader, reflex.VerifyBond)
+	if err != nil {
+		return err
+	}
+	var bond *reflex.Bond
+	if live != nil {
+		h.mu.Lock()
+		bond, _ = live.conn.(*reflex.Bond)
+		h.mu.Unlock()
+	}
+	if bond == nil {
+		if reason == "" {
+			reason = "forbidden"
+		}
+		return h.writeHTTPErrorAndClose(conn, reason)
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+	select {
+	case <-bond.Add(conn, reader):
+	case <-live.ended:
+		_ = conn.Close()
+	}
+	return nil
+}
+
+// readAttach reads a request that attaches a connection to a live session
+// and returns the session. It returns no session, and the reason to reject
+// the request for, if the session is unknown or revoked, the request fails
+// verify under the resumption key of its timestamp, or it is stale or
+// replayed.
+func (h *Handler) readAttach(reader *bufio.Reader, verify func(key, body, mac []byte) bool) (*reflex.Session, *liveSession, string, error) {
+	if _, err := reader.Discard(4); err != nil {
+		return nil, nil, "", err
+	}
+	req, body, mac, err := reflex.ReadResume(reader)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	h.mu.Lock()
+	session := h.resumable[req.SessionID]
+	live := h.sessions[session]
+	var rotation time.Duration
+	if live != nil && live.grant != nil {
+		rotation = time.Duration(live.grant.ResumeRotation) * time.Second
+	}
+	revoked := live != nil && live.revoked
+	h.mu.Unlock()
+	if live == nil || revoked {
+		return nil, nil, "forbidden", nil
+	}
+	if key := session.ResumptionKey(rotation, req.Timestamp); !verify(key, body, mac) {
+		return nil, nil, "forbidden", nil
+	}
+	if !timestampValid(req.Timestamp) {
+		return nil, nil, "invalid timestamp", nil
+	}
+	if !h.replay.Check(req.Nonce, time.Now()) {
+		return nil, nil, "forbidden", nil
+	}
+	return session, live, "", nil
+}
+
+// noteGrant records the grant in force for a live session.
+func (h *Handler) noteGrant(session *reflex.Session, grant *reflex.PolicyGrant) {
+	h.mu.Lock()
+	if live := h.sessions[session]; live != nil {
+		live.grant = grant
+	}
+	h.mu.Unlock()
+}
+
+// isMoving reports whether the client of a live session resumes it on
+// another connection.
+func (h *Handler) isMoving(session *reflex.Session) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	live := h.sessions[session]
+	return live != nil && live.moving
+}
@@ -0,0 +1,77 @@
+package inbound
+
+import (
+	"fmt"
+	stdnet "net"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"github.com/xtls/xray-core/proxy/reflex"
+)
+
+// SelfCheck looks for configuration that loads but will not work as
+// intended and returns an actionable warning for each problem. The inbound
+// logs them when it starts and on reload, so broken setups show up at boot
+// rather than on the first user connection.
+func SelfCheck(config *reflex.InboundConfig) []string {
+	var warnings []string
+	warn := func(format string, args ...interface{}) {
+		warnings = append(warnings, fmt.Sprintf(format, args...))
+	}
+
+	if config.Fallback == nil {
+		warn("no fallback configured: probes and non-Reflex clients get their connection closed instead of the cover site")
+	} else {
+		target := config.Fallback.Target()
+		conn, err := stdnet.DialTimeout("tcp", target, healthDialTimeout)
+		if err != nil {
+			warn("fallback %s is unreachable (%v): start the cover site or fix fallback.dest", target, err)
+		} else {
+			_ = conn.Close()
+		}
+	}
+
+	if len(config.Clients) == 0 {
+		warn("no clients configured: every handshake is refused until users are added through the API")
+	}
+	seen := make(map[string]bool)
+	psks := make(map[string]string)
+	for _, c := range config.Clients {
+		if id, err := uuid.Parse(c.Id); err != nil {
+			warn("client id %q is not a UUID: it can never complete a handshake", c.Id)
+		} else if id.String() != c.Id {
+			warn("client id %q only matches when written as %s", c.Id, id)
+		}
+		if seen[c.Id] {
+			warn("client %s is configured twice: only the first entry is used", c.Id)
+		}
+		seen[c.Id] = true
+		if c.Psk != "" {
+			if other, dup := psks[c.Psk]; dup {
+				warn("clients %s and %s share a psk: give every client its own key", other, c.Id)
+			}
+			psks[c.Psk] = c.Id
+		}
+		if c.Policy != "" && !hasPolicy(config.Policies, c.Policy) {
+			warn("client %s refers to undefined policy %q: level and inbound rules apply instead", c.Id, c.Policy)
+		}
+	}
+
+	if ph := config.PortHopping; ph != nil && len(ph.Secret) < reflex.MinPSKSize {
+		warn("port hopping secret is shorter than %d bytes: the schedule may be guessed", reflex.MinPSKSize)
+	}
+
+	names := make([]string, 0, len(reflex.Profiles))
+	for name := range reflex.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := reflex.Profiles[name].Validate(); err != nil {
+			warn("%s", strings.TrimPrefix(err.Error(), "reflex: "))
+		}
+	}
+	return warnings
+}
@@ -0,0 +1,38 @@
+package inbound
+
+import (
+	"context"
+	"time"
+
+	xerrors "github.com/xtls/xray-core/common/errors"
+	"github.com/xtls/xray-core/proxy/reflex"
+)
+
+// stateSaveInterval is how often the state file is written while running, to
+// bound what a crash loses.
+const stateSaveInterval = 30 * time.Second
+
+// saveStatePeriodically writes the state file until the handler is closed.
+func (h *Handler) saveStatePeriodically(ctx context.Context) {
+	ticker := time.NewTicker(stateSaveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-h.done:
+			return
+		case <-ticker.C:
+			h.saveState(ctx)
+		}
+	}
+}
+
+// saveState writes the replay cache and the login tracker to the state file,
+// if one is configured.
+func (h *Handler) saveState(ctx context.Context) {
+	if h.stateFile == "" {
+		return
+	}
+	if err := reflex.SaveState(h.stateFile, h.replay, h.settings.Load().logins, time.Now()); err != nil {
+		xerrors.LogWarningInner(ctx, err, "reflex: failed to save state")
+	}
+}
@@ -0,0 +1,31 @@
+package inbound
+
+import (
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/proxy/reflex/capture"
+	"github.com/xtls/xray-core/transport/internet/stat"
+)
+
+// applyStrategy puts the configured wire strategy on conn. It goes outside
+// the capture, so that a capture shows the segments the strategy produced.
+func (h *Handler) applyStrategy(conn stat.Connection) stat.Connection {
+	ws := h.settings.Load().strategy
+	if ws == nil {
+		return conn
+	}
+	// The configuration was checked when it was loaded.
+	s, err := reflex.NewStrategy(ws.Name, ws.Args)
+	if err != nil {
+		return conn
+	}
+	return reflex.NewStrategyConn(conn, s)
+}
+
+// captured returns the capture of conn, if it is captured.
+func captured(conn stat.Connection) (*capture.Conn, bool) {
+	if c, ok := conn.(*reflex.StrategyConn); ok {
+		conn = c.Conn
+	}
+	c, ok := conn.(*capture.Conn)
+	return c, ok
+}
@@ -0,0 +1,36 @@
+package inbound
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	xerrors "github.com/xtls/xray-core/common/errors"
+	"github.com/xtls/xray-core/proxy/reflex"
+)
+
+// startTranscript starts the transcript of a session in a new file of the
+// transcript directory. done ends it with the error the session ended with.
+// The transcript is nil if the file cannot be created.
+func (h *Handler) startTranscript(ctx context.Context, session *reflex.Session, grant *reflex.PolicyGrant) (transcript *reflex.SessionTranscript, done func(error)) {
+	name := fmt.Sprintf("%s-%d.jsonl", time.Now().UTC().Format("20060102T150405"), h.transcripts.Add(1))
+	f, err := os.OpenFile(filepath.Join(h.transcriptDir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		xerrors.LogWarningInner(ctx, err, "reflex: failed to start session transcript")
+		return nil, nil
+	}
+	transcript = reflex.NewSessionTranscript(f)
+	transcript.Attach(session)
+	transcript.Event(reflex.TranscriptStart, grant.Profile)
+	return transcript, func(err error) {
+		reason := "closed"
+		if err != nil && err != io.EOF {
+			reason = err.Error()
+		}
+		transcript.Event(reflex.TranscriptEnd, reason)
+		_ = f.Close()
+	}
+}
@@ -0,0 +1,133 @@
+package inbound
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"time"
+
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/transport/internet/stat"
+)
+
+// transportRetried is the outcome of a handshake answered with a retry
+// cookie. It is not recorded; the retry that follows is.
+const transportRetried = "retried"
+
+// transportKey is the context key of the *transportAttempt of a connection.
+type transportKey struct{}
+
+// transportAttempt is the handshake a connection makes over one transport.
+// Its outcome is recorded once known; until then it is "".
+type transportAttempt struct {
+	network   string
+	transport string
+	start     time.Time
+	outcome   string
+	refused   string // why the server refused the handshake, see refuseHandshake
+}
+
+// attemptFrom returns the attempt of ctx, or nil for connections that are
+// not handshakes, such as resumed sessions.
+func attemptFrom(ctx context.Context) *transportAttempt {
+	attempt, _ := ctx.Value(transportKey{}).(*transportAttempt)
+	return attempt
+}
+
+// attemptTransport serves a handshake over transport and records its
+// outcome. A handshake that completes records that itself, see
+// transportOutcome, since its session is served before serve returns.
+// Otherwise one that ended in an error never arrived whole, and one that
+// was answered and closed was refused.
+func (h *Handler) attemptTransport(ctx context.Context, conn stat.Connection, transport string, serve func(context.Context) error) error {
+	attempt := &transportAttempt{
+		network:   reflex.InterferenceNetwork(conn.RemoteAddr().String()),
+		transport: transport,
+		start:     time.Now(),
+	}
+	err := serve(context.WithValue(ctx, transportKey{}, attempt))
+	switch {
+	case attempt.outcome != "":
+	case err != nil:
+		h.transports.Failure(transport, handshakeFailure(err))
+		h.transportOutcome(ctx, attempt, reflex.TransportFailed)
+	default:
+		if attempt.refused == "" {
+			attempt.refused = "refused"
+		}
+		h.transports.Failure(transport, attempt.refused)
+		h.transportOutcome(ctx, attempt, reflex.TransportRejected)
+	}
+	return err
+}
+
+// handshakeFailure names why a handshake that ended in err never arrived
+// whole.
+func handshakeFailure(err error) string {
+	switch {
+	case reflex.IsReset(err):
+		return "reset"
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return "cut off"
+	default:
+		return "malformed"
+	}
+}
+
+// refuseHandshake answers a handshake the server refuses with an HTTP error
+// for reason and closes, noting why for the transport metrics. Several
+// refusals share a reason on the wire so that probes cannot tell them apart.
+func (h *Handler) refuseHandshake(ctx context.Context, conn stat.Connection, why, reason string) error {
+	if attempt := attemptFrom(ctx); attempt != nil {
+		attempt.refused = why
+	}
+	return h.writeHTTPErrorAndClose(conn, reason)
+}
+
+// transportOutcome records the outcome of attempt, or of the attempt of ctx
+// when attempt is nil. Connections without an attempt are not recorded.
+func (h *Handler) transportOutcome(ctx context.Context, attempt *transportAttempt, outcome string) {
+	if attempt == nil {
+		if attempt = attemptFrom(ctx); attempt == nil {
+			return
+		}
+	}
+	switch outcome {
+	case transportRetried:
+		attempt.outcome = outcome
+		return
+	case reflex.TransportCompleted:
+		h.transports.Handshake(attempt.transport, time.Since(attempt.start))
+	case reflex.TransportReset:
+		h.transports.Failure(attempt.transport, reflex.InterferenceResetAfterHandshake)
+	}
+	if outcome != reflex.TransportReset {
+		attempt.outcome = outcome
+	}
+	h.transports.Record(attempt.network, attempt.transport, outcome, time.Now())
+}
+
+// transportSessionEnded measures the session of the attempt of ctx, which
+// moved bytes of DATA payload since start.
+func (h *Handler) transportSessionEnded(ctx context.Context, bytes uint64, start time.Time) {
+	if attempt := attemptFrom(ctx); attempt != nil {
+		h.transports.SessionEnded(attempt.transport, bytes, time.Since(start))
+	}
+}
+
+// TransportStats returns the handshake outcomes per client network and
+// transport over the last day, so operators can see which transports are
+// being blocked where.
+func (h *Handler) TransportStats() []reflex.NetworkTransports {
+	return h.transports.Networks(time.Now())
+}
+
+// TransportMetrics returns the handshake latency, failure reasons and
+// session throughput of each transport, so operators can compare how
+// reliable and fast the transports are.
+func (h *Handler) TransportMetrics() map[string]reflex.TransportMetrics {
+	return h.transports.Metrics()
+}
@@ -0,0 +1,43 @@
+package inbound
+
+import (
+	"github.com/xtls/xray-core/common/protocol"
+	"github.com/xtls/xray-core/proxy/reflex"
+)
+
+// Usage returns the bytes the user with the given email moved through the
+// inbound, both directions, since it started, which is what its quota is
+// counted against.
+func (h *Handler) Usage(email string) uint64 {
+	return h.usage.Used(email)
+}
+
+// ResetUsage forgets the usage of the user with the given email, e.g. at the
+// start of a billing period, so that its quota is available again.
+func (h *Handler) ResetUsage(email string) {
+	h.usage.Reset(email)
+}
+
+// usageReport returns what a USAGE frame tells a session of user under grant.
+func (h *Handler) usageReport(user *protocol.MemoryUser, grant *reflex.PolicyGrant) *reflex.UsageReport {
+	account := user.Account.(*MemoryAccount)
+	r := &reflex.UsageReport{
+		Used:      h.usage.Used(user.Email),
+		Quota:     account.Quota,
+		Bandwidth: grant.Bandwidth,
+		Tier:      grant.Tier,
+	}
+	if r.Quota > r.Used {
+		r.Remaining = r.Quota - r.Used
+	}
+	if !account.Expiry.IsZero() {
+		r.Expiry = account.Expiry.Unix()
+	}
+	return r
+}
+
+// quotaExhausted reports whether user used up the quota of its account.
+func (h *Handler) quotaExhausted(user *protocol.MemoryUser) bool {
+	quota := user.Account.(*MemoryAccount).Quota
+	return quota > 0 && h.usage.Used(user.Email) >= quota
+}
@@ -0,0 +1,65 @@
+package inbound
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+
+	xerrors "github.com/xtls/xray-core/common/errors"
+	"github.com/xtls/xray-core/common/net"
+	feature_inbound "github.com/xtls/xray-core/features/inbound"
+	"github.com/xtls/xray-core/features/routing"
+	"github.com/xtls/xray-core/proxy"
+	"github.com/xtls/xray-core/proxy/vless"
+	"github.com/xtls/xray-core/transport/internet/stat"
+)
+
+// vlessHeaderSize is what of a VLESS request identifies its client: the
+// version and the user ID.
+const vlessHeaderSize = 1 + 16
+
+// vlessDelegate hands the connections of VLESS clients to a VLESS inbound,
+// so that during a migration one port serves both the clients that moved to
+// Reflex and those still on VLESS. The VLESS inbound is looked up by tag on
+// every connection, so it may be configured after the Reflex one and its
+// users changed through the API.
+type vlessDelegate struct {
+	tag      string
+	inbounds feature_inbound.Manager
+}
+
+// inbound returns the VLESS inbound if header, the first bytes of a
+// connection, is a VLESS request of one of its users.
+func (d *vlessDelegate) inbound(ctx context.Context, header []byte) proxy.Inbound {
+	if header[0] != 0 || d.inbounds == nil {
+		return nil
+	}
+	handler, err := d.inbounds.GetHandler(ctx, d.tag)
+	if err != nil {
+		return nil
+	}
+	gi, ok := handler.(proxy.GetInbound)
+	if !ok {
+		return nil
+	}
+	in := gi.GetInbound()
+	users, ok := in.(proxy.UserManager)
+	if !ok {
+		return nil
+	}
+	for _, u := range users.GetUsers(ctx) {
+		if a, ok := u.Account.(*vless.MemoryAccount); ok && bytes.Equal(a.ID.Bytes(), header[1:vlessHeaderSize]) {
+			return in
+		}
+	}
+	return nil
+}
+
+// handleVLESS serves a connection of a VLESS client with the VLESS inbound.
+// The peeked bytes are replayed to it. Clients with the xtls-rprx-vision
+// flow cannot be served this way: Vision needs the TLS connection itself,
+// not one Reflex has read from.
+func (h *Handler) handleVLESS(ctx context.Context, in proxy.Inbound, reader *bufio.Reader, conn stat.Connection, dispatcher routing.Dispatcher) error {
+	xerrors.LogInfo(ctx, "reflex: serving a VLESS client with inbound ", h.vless.tag)
+	return in.Process(ctx, net.Network_TCP, &preloadedConn{Reader: reader, Connection: conn}, dispatcher)
+}
@@ -0,0 +1,43 @@
+//go:build reflex_insecure
+
+package reflex
+
+import "errors"
+
+// InsecureBuild reports whether this binary was built with the reflex_insecure
+// tag and therefore allows plaintext sessions.
+const InsecureBuild = true
+
+// NewPlaintextSession returns a Session that frames data exactly like a normal
+// session but does not encrypt or authenticate it. It exists for wire-format
+// tests, fuzzing and debugging tools, and is only available in builds tagged
+// reflex_insecure; never ship such a build.
+func NewPlaintextSession() (*Session, error) {
+	s := &Session{aead: plaintextAEAD{}}
+	if err := s.initNoncePrefix(DirectionNone); err != nil {
+		return nil, err
+	}
+	if err := s.initEpochs(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// plaintextAEAD is a no-op cipher.AEAD that keeps the 12-byte nonce layout
+// so replay checks keep working.
+type plaintextAEAD struct{}
+
+func (plaintextAEAD) NonceSize() int { return 12 }
+
+func (plaintextAEAD) Overhead() int { return 0 }
+
+func (plaintextAEAD) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	return append(dst, plaintext...)
+}
+
+func (plaintextAEAD) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(nonce) != 12 {
+		return nil, errors.New("reflex: invalid nonce size")
+	}
+	return append(dst, ciphertext...), nil
+}
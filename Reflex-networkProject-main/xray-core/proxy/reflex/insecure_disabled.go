@@ -0,0 +1,15 @@
+//go:build !reflex_insecure
+
+package reflex
+
+import "errors"
+
+// InsecureBuild reports whether this binary was built with the reflex_insecure
+// tag and therefore allows plaintext sessions.
+const InsecureBuild = false
+
+// NewPlaintextSession always fails in production builds. Rebuild with
+// -tags reflex_insecure to enable plaintext sessions for diagnostics.
+func NewPlaintextSession() (*Session, error) {
+	return nil, errors.New("reflex: plaintext sessions require the reflex_insecure build tag")
+}
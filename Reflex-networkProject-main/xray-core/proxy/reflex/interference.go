@@ -0,0 +1,244 @@
+package reflex
+
+import (
+	"errors"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Kinds of interference an InterferenceMonitor reports.
+const (
+	// InterferenceResetAfterHandshake is a reset before the first DATA frame:
+	// the handshake passed, then something tore the connection down.
+	InterferenceResetAfterHandshake = "reset-after-handshake"
+	// InterferenceMidStreamReset is a reset after data was flowing.
+	InterferenceMidStreamReset = "mid-stream-reset"
+	// InterferenceThroughputCollapse is a session whose receive rate falls
+	// to a trickle while frames keep arriving, the shape of throttling.
+	InterferenceThroughputCollapse = "throughput-collapse"
+	// InterferenceResetBurst is reported by InterferenceLog when one network
+	// sees ResetBurstCount resets within ResetBurstWindow.
+	InterferenceResetBurst = "reset-burst"
+)
+
+// InterferenceWindow is the interval over which receive throughput is
+// measured. A gap longer than that counts as idle, not as a collapse.
+const InterferenceWindow = time.Second
+
+// A collapse is reported when a window's rate falls below the best window
+// by collapseRatio, once that best window reached collapseMinRate.
+const (
+	collapseMinRate = 64 << 10 // bytes per second
+	collapseRatio   = 10
+)
+
+// A reset burst is ResetBurstCount resets from one network within
+// ResetBurstWindow.
+const (
+	ResetBurstCount  = 3
+	ResetBurstWindow = time.Minute
+)
+
+// InterferenceEvent is one sign of interference on a session.
+type InterferenceEvent struct {
+	Kind    string
+	Time    time.Time
+	Remote  string        // peer address
+	Network string        // see InterferenceNetwork
+	Age     time.Duration // session age when it happened
+	Bytes   uint64        // DATA payload bytes received before it
+}
+
+// String formats the event as key=value pairs for the structured log.
+func (e *InterferenceEvent) String() string {
+	var b strings.Builder
+	b.WriteString("reflex interference: kind=" + e.Kind)
+	b.WriteString(" network=" + e.Network)
+	b.WriteString(" remote=" + e.Remote)
+	b.WriteString(" age=" + e.Age.Round(time.Millisecond).String())
+	b.WriteString(" bytes=" + strconv.FormatUint(e.Bytes, 10))
+	return b.String()
+}
+
+// InterferenceNetwork returns the network blocking is mapped by for a peer
+// address: the /24 of an IPv4 address or the /48 of an IPv6 address.
+func InterferenceNetwork(addr string) string {
+	host := SourceHost(addr)
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return host
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return (&net.IPNet{IP: ip4.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}).String()
+	}
+	return (&net.IPNet{IP: ip.Mask(net.CIDRMask(48, 128)), Mask: net.CIDRMask(48, 128)}).String()
+}
+
+// IsReset reports whether err is the connection being reset.
+func IsReset(err error) bool {
+	return errors.Is(err, syscall.ECONNRESET)
+}
+
+// InterferenceMonitor watches the receiving side of one session. Received is
+// called for every DATA frame and Failed with the error that ended reading;
+// events go to report as they are detected.
+type InterferenceMonitor struct {
+	remote string
+	start  time.Time
+	report func(*InterferenceEvent)
+
+	mu          sync.Mutex
+	bytes       uint64
+	windowStart time.Time
+	windowBytes uint64
+	lastReceive time.Time
+	peak        float64 // best window rate so far, bytes per second
+	collapsed   bool    // a collapse is reported once per session
+}
+
+// NewInterferenceMonitor starts watching a session with remote that has
+// just completed its handshake.
+func NewInterferenceMonitor(remote string, report func(*InterferenceEvent)) *InterferenceMonitor {
+	return &InterferenceMonitor{remote: remote, start: time.Now(), report: report}
+}
+
+func (m *InterferenceMonitor) event(kind string, now time.Time) *InterferenceEvent {
+	return &InterferenceEvent{
+		Kind:    kind,
+		Time:    now,
+		Remote:  m.remote,
+		Network: InterferenceNetwork(m.remote),
+		Age:     now.Sub(m.start),
+		Bytes:   m.bytes,
+	}
+}
+
+// Received accounts n bytes of DATA payload.
+func (m *InterferenceMonitor) Received(n int) {
+	now := time.Now()
+	var collapse *InterferenceEvent
+	m.mu.Lock()
+	switch {
+	case m.windowStart.IsZero() || now.Sub(m.lastReceive) > InterferenceWindow:
+		// First frame, or the peer was idle: start measuring afresh.
+		m.windowStart, m.windowBytes = now, 0
+	case now.Sub(m.windowStart) >= InterferenceWindow:
+		rate := float64(m.windowBytes) / now.Sub(m.windowStart).Seconds()
+		if rate > m.peak {
+			m.peak = rate
+		} else if !m.collapsed && m.peak >= collapseMinRate && rate*collapseRatio < m.peak {
+			m.collapsed = true
+			collapse = m.event(InterferenceThroughputCollapse, now)
+		}
+		m.windowStart, m.windowBytes = now, 0
+	}
+	m.windowBytes += uint64(n)
+	m.bytes += uint64(n)
+	m.lastReceive = now
+	m.mu.Unlock()
+	if collapse != nil {
+		m.report(collapse)
+	}
+}
+
+// Failed classifies the error that ended reading; only resets are
+// interference, anything else is ignored.
+func (m *InterferenceMonitor) Failed(err error) {
+	if !IsReset(err) {
+		return
+	}
+	m.mu.Lock()
+	kind := InterferenceMidStreamReset
+	if m.bytes == 0 {
+		kind = InterferenceResetAfterHandshake
+	}
+	e := m.event(kind, time.Now())
+	m.mu.Unlock()
+	m.report(e)
+}
+
+// NetworkInterference is what was seen from one network.
+type NetworkInterference struct {
+	Network  string            `json:"network"`
+	Events   map[string]uint64 `json:"events"` // count by kind
+	LastSeen time.Time         `json:"last_seen"`
+}
+
+// InterferenceLog collects events from many sessions by network, so
+// operators can map where blocking happens.
+type InterferenceLog struct {
+	mu       sync.Mutex
+	networks map[string]*NetworkInterference
+	resets   map[string][]time.Time // recent resets by network
+}
+
+// NewInterferenceLog returns an empty InterferenceLog.
+func NewInterferenceLog() *InterferenceLog {
+	return &InterferenceLog{
+		networks: make(map[string]*NetworkInterference),
+		resets:   make(map[string][]time.Time),
+	}
+}
+
+// Record adds e. When e completes a reset burst, the InterferenceResetBurst
+// event is recorded as well and returned; otherwise Record returns nil.
+func (l *InterferenceLog) Record(e *InterferenceEvent) *InterferenceEvent {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.count(e)
+	if e.Kind != InterferenceResetAfterHandshake && e.Kind != InterferenceMidStreamReset {
+		return nil
+	}
+	recent := l.resets[e.Network][:0]
+	for _, t := range l.resets[e.Network] {
+		if e.Time.Sub(t) < ResetBurstWindow {
+			recent = append(recent, t)
+		}
+	}
+	recent = append(recent, e.Time)
+	if len(recent) < ResetBurstCount {
+		l.resets[e.Network] = recent
+		return nil
+	}
+	// The next burst needs ResetBurstCount new resets.
+	delete(l.resets, e.Network)
+	burst := *e
+	burst.Kind = InterferenceResetBurst
+	l.count(&burst)
+	return &burst
+}
+
+func (l *InterferenceLog) count(e *InterferenceEvent) {
+	n := l.networks[e.Network]
+	if n == nil {
+		n = &NetworkInterference{Network: e.Network, Events: make(map[string]uint64)}
+		l.networks[e.Network] = n
+	}
+	n.Events[e.Kind]++
+	if e.Time.After(n.LastSeen) {
+		n.LastSeen = e.Time
+	}
+}
+
+// Networks returns what was seen per network, most recently seen first.
+func (l *InterferenceLog) Networks() []NetworkInterference {
+	l.mu.Lock()
+	list := make([]NetworkInterference, 0, len(l.networks))
+	for _, n := range l.networks {
+		events := make(map[string]uint64, len(n.Events))
+		for k, v := range n.Events {
+			events[k] = v
+		}
+		list = append(list, NetworkInterference{Network: n.Network, Events: events, LastSeen: n.LastSeen})
+	}
+	l.mu.Unlock()
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].LastSeen.After(list[j].LastSeen)
+	})
+	return list
+}
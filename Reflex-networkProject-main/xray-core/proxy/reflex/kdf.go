@@ -0,0 +1,62 @@
+package reflex
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"hash"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// Transcript is a running SHA-256 over every handshake byte both peers agree
+// on. It is mixed into the session key so that any byte altered in transit
+// yields different keys on the two ends, which key confirmation then detects.
+//
+// Both peers write, in order: the client handshake body (everything after the
+// magic number, or the decoded HTTP payload), then the server's ephemeral
+// public key.
+type Transcript struct {
+	h hash.Hash
+}
+
+// NewTranscript returns an empty transcript.
+func NewTranscript() *Transcript {
+	return &Transcript{h: sha256.New()}
+}
+
+// Write appends handshake bytes to the transcript. It never fails.
+func (t *Transcript) Write(p []byte) (int, error) {
+	return t.h.Write(p)
+}
+
+// Sum returns the hash of everything written so far without resetting it.
+func (t *Transcript) Sum() []byte {
+	return t.h.Sum(nil)
+}
+
+// DeriveSessionKey derives the 32-byte session key from the X25519 shared
+// secret. salt is the client nonce and transcript the handshake transcript
+// hash, which is appended to the HKDF info string.
+func DeriveSessionKey(sharedKey [32]byte, salt, transcript []byte) []byte {
+	info := append([]byte("reflex-session"), transcript...)
+	h := hkdf.New(sha256.New, sharedKey[:], salt, info)
+	sessionKey := make([]byte, 32)
+	_, _ = io.ReadFull(h, sessionKey)
+	return sessionKey
+}
+
+// KeyConfirmation returns the value the server sends so the client can check
+// that both sides derived the same key over the same transcript.
+func KeyConfirmation(sessionKey, transcript []byte) []byte {
+	mac := hmac.New(sha256.New, sessionKey)
+	mac.Write([]byte("reflex-key-confirm"))
+	mac.Write(transcript)
+	return mac.Sum(nil)
+}
+
+// VerifyKeyConfirmation reports whether confirm matches KeyConfirmation in
+// constant time.
+func VerifyKeyConfirmation(sessionKey, transcript, confirm []byte) bool {
+	return hmac.Equal(KeyConfirmation(sessionKey, transcript), confirm)
+}
@@ -0,0 +1,56 @@
+package reflex
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// KeyLogLabel starts every line of a key log. The file format is that of
+// SSLKEYLOGFILE, so a Reflex key log and a TLS key log may share a file:
+//
+//	REFLEX_SESSION <client nonce> <session key>
+//
+// with the 16-byte nonce of the client handshake and the 32-byte session key
+// in hex.
+const KeyLogLabel = "REFLEX_SESSION"
+
+// keyLogMu serializes key log lines written by concurrent handshakes, as
+// crypto/tls does for its KeyLogWriter.
+var keyLogMu sync.Mutex
+
+// WriteKeyLog appends the session key of the handshake with the given client
+// nonce to w. Whoever reads the log can decrypt every logged session; it is
+// meant for test environments only.
+func WriteKeyLog(w io.Writer, nonce, sessionKey []byte) error {
+	keyLogMu.Lock()
+	defer keyLogMu.Unlock()
+	_, err := fmt.Fprintf(w, "%s %x %x\n", KeyLogLabel, nonce, sessionKey)
+	return err
+}
+
+// ReadKeyLog reads the session keys of a key log, indexed by client nonce.
+// Comments and lines with other labels are skipped.
+func ReadKeyLog(r io.Reader) (map[[16]byte][]byte, error) {
+	keys := make(map[[16]byte][]byte)
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || fields[0] != KeyLogLabel {
+			continue
+		}
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("reflex: key log line %d: want nonce and key", line)
+		}
+		nonce, err1 := hex.DecodeString(fields[1])
+		key, err2 := hex.DecodeString(fields[2])
+		if err1 != nil || err2 != nil || len(nonce) != 16 || len(key) != 32 {
+			return nil, fmt.Errorf("reflex: key log line %d: malformed nonce or key", line)
+		}
+		keys[[16]byte(nonce)] = key
+	}
+	return keys, scanner.Err()
+}
@@ -0,0 +1,190 @@
+package reflex
+
+import (
+	"io"
+	"math"
+	"math/bits"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MinLeakageSamples is the number of writes below which a LeakageReport is
+// too noisy to mean much; the information estimates are biased upward on
+// small samples.
+const MinLeakageSamples = 20
+
+// LeakageAudit measures, for one session, how much of the shape of the
+// plaintext shows through on the wire: for every DATA payload handed to
+// WriteFrameWithMorphing it records the payload size and when it was handed
+// over, next to the bytes written for it and when the write completed. The
+// report then says how closely wire sizes and gaps follow plaintext sizes and
+// gaps, i.e. how much morphing actually hides.
+type LeakageAudit struct {
+	mu      sync.Mutex
+	samples []leakageSample
+}
+
+type leakageSample struct {
+	plainSize int
+	plainAt   time.Time
+	wireSize  int
+	wireAt    time.Time
+}
+
+// NewLeakageAudit returns an empty audit.
+func NewLeakageAudit() *LeakageAudit {
+	return &LeakageAudit{}
+}
+
+// SetLeakageAudit attaches a to the session; WriteFrameWithMorphing then
+// records every DATA frame into it. Nil detaches it.
+func (s *Session) SetLeakageAudit(a *LeakageAudit) {
+	s.mu.Lock()
+	s.leakage = a
+	s.mu.Unlock()
+}
+
+func (s *Session) leakageAudit() *LeakageAudit {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.leakage
+}
+
+func (a *LeakageAudit) observe(plainSize int, plainAt time.Time, wireSize int, wireAt time.Time) {
+	a.mu.Lock()
+	a.samples = append(a.samples, leakageSample{plainSize, plainAt, wireSize, wireAt})
+	a.mu.Unlock()
+}
+
+// LeakageReport quantifies what the wire reveals about the plaintext.
+// Correlations are Pearson coefficients (0 when either side never varies).
+// Information is the mutual information in bits between the power-of-two
+// class of the plaintext and of the wire value, next to the entropy of the
+// plaintext class: Information equal to Entropy means the wire gives the
+// plaintext class away, 0 means it tells nothing.
+type LeakageReport struct {
+	Samples           int     `json:"samples"`
+	SizeCorrelation   float64 `json:"size_correlation"`
+	SizeInformation   float64 `json:"size_information"`
+	SizeEntropy       float64 `json:"size_entropy"`
+	TimingCorrelation float64 `json:"timing_correlation"` // between gaps, so over Samples-1 pairs
+	TimingInformation float64 `json:"timing_information"`
+	TimingEntropy     float64 `json:"timing_entropy"`
+}
+
+// String formats the report as key=value pairs for the structured log.
+func (r *LeakageReport) String() string {
+	f := func(v float64) string { return strconv.FormatFloat(v, 'f', 3, 64) }
+	var b strings.Builder
+	b.WriteString("reflex leakage: samples=" + strconv.Itoa(r.Samples))
+	b.WriteString(" size_r=" + f(r.SizeCorrelation))
+	b.WriteString(" size_bits=" + f(r.SizeInformation) + "/" + f(r.SizeEntropy))
+	b.WriteString(" timing_r=" + f(r.TimingCorrelation))
+	b.WriteString(" timing_bits=" + f(r.TimingInformation) + "/" + f(r.TimingEntropy))
+	return b.String()
+}
+
+// Report computes the report over everything recorded so far.
+func (a *LeakageAudit) Report() *LeakageReport {
+	a.mu.Lock()
+	samples := append([]leakageSample(nil), a.samples...)
+	a.mu.Unlock()
+
+	r := &LeakageReport{Samples: len(samples)}
+	plainSizes := make([]float64, len(samples))
+	wireSizes := make([]float64, len(samples))
+	for i, s := range samples {
+		plainSizes[i] = float64(s.plainSize)
+		wireSizes[i] = float64(s.wireSize)
+	}
+	r.SizeCorrelation = pearson(plainSizes, wireSizes)
+	r.SizeInformation, r.SizeEntropy = mutualInformation(plainSizes, wireSizes)
+
+	if len(samples) > 1 {
+		plainGaps := make([]float64, len(samples)-1)
+		wireGaps := make([]float64, len(samples)-1)
+		for i := 1; i < len(samples); i++ {
+			plainGaps[i-1] = float64(samples[i].plainAt.Sub(samples[i-1].plainAt).Microseconds())
+			wireGaps[i-1] = float64(samples[i].wireAt.Sub(samples[i-1].wireAt).Microseconds())
+		}
+		r.TimingCorrelation = pearson(plainGaps, wireGaps)
+		r.TimingInformation, r.TimingEntropy = mutualInformation(plainGaps, wireGaps)
+	}
+	return r
+}
+
+func pearson(x, y []float64) float64 {
+	n := float64(len(x))
+	if n < 2 {
+		return 0
+	}
+	var mx, my float64
+	for i := range x {
+		mx += x[i]
+		my += y[i]
+	}
+	mx /= n
+	my /= n
+	var sxy, sxx, syy float64
+	for i := range x {
+		dx, dy := x[i]-mx, y[i]-my
+		sxy += dx * dy
+		sxx += dx * dx
+		syy += dy * dy
+	}
+	if sxx == 0 || syy == 0 {
+		return 0
+	}
+	return sxy / math.Sqrt(sxx*syy)
+}
+
+// leakageClass buckets a non-negative value by its power of two.
+func leakageClass(v float64) int {
+	if v < 1 {
+		return 0
+	}
+	return bits.Len64(uint64(v))
+}
+
+// mutualInformation returns I(X;Y) and H(X) in bits over the classes of x
+// and y.
+func mutualInformation(x, y []float64) (info, entropy float64) {
+	n := float64(len(x))
+	if n == 0 {
+		return 0, 0
+	}
+	type pair struct{ a, b int }
+	px := make(map[int]float64)
+	py := make(map[int]float64)
+	pxy := make(map[pair]float64)
+	for i := range x {
+		a, b := leakageClass(x[i]), leakageClass(y[i])
+		px[a]++
+		py[b]++
+		pxy[pair{a, b}]++
+	}
+	for p, c := range pxy {
+		info += c / n * math.Log2(c*n/(px[p.a]*py[p.b]))
+	}
+	for _, c := range px {
+		entropy -= c / n * math.Log2(c/n)
+	}
+	return info, entropy
+}
+
+// countingWriter counts the bytes written through it and notes when the
+// last write completed.
+type countingWriter struct {
+	w  io.Writer
+	n  int
+	at time.Time
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n += n
+	c.at = time.Now()
+	return n, err
+}
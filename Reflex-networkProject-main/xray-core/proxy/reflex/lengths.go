@@ -0,0 +1,74 @@
+package reflex
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"io"
+
+	"github.com/xtls/xray-core/proxy/reflex/frame"
+	"golang.org/x/crypto/hkdf"
+)
+
+// FeatureMaskedLengths is the policy feature that switches a session to
+// masked-length mode, see SetMaskedLengths.
+const FeatureMaskedLengths = "masked-lengths"
+
+// SetMaskedLengths switches masked-length mode on or off. A record's length
+// prefix and nonce are otherwise on the wire in clear, and they let an
+// observer cut the stream into records, read their sizes and count them
+// without any key. In masked-length mode the record starts with the first
+// bytes of its ciphertext, and the nonce and length that follow are XORed
+// with
+//
+//	HMAC-SHA256(length key, those bytes)
+//
+// where the length key is derived from the session key, much like QUIC
+// protects its packet headers with a sample of the ciphertext under a key
+// apart from the payload. The mask changes with every record and depends on
+// no other record, so records can still be read out of order, see Bond.
+//
+// TLS framing mode takes precedence: its record headers carry the length in
+// clear as TLS does. Both peers must switch before the first frame, which
+// they do when FeatureMaskedLengths is granted.
+func (s *Session) SetMaskedLengths(on bool) error {
+	var key []byte
+	if on {
+		key = make([]byte, 32)
+		if _, err := io.ReadFull(hkdf.New(sha256.New, s.key, nil, []byte("reflex-length-mask")), key); err != nil {
+			return err
+		}
+	}
+	s.mu.Lock()
+	s.lengthKey = key
+	s.mu.Unlock()
+	return nil
+}
+
+// headerMask returns the mask of record headers under key.
+func headerMask(key []byte) frame.HeaderMask {
+	return func(sample []byte) []byte {
+		mac := hmac.New(sha256.New, key)
+		mac.Write(sample)
+		return mac.Sum(nil)
+	}
+}
+
+// records returns how the session reads and writes its records, by its
+// framing mode.
+func (s *Session) records() (func(io.Reader, int, int) (*frame.Record, error), func(io.Writer, *frame.Record) error) {
+	s.mu.Lock()
+	tlsRecords, key := s.tlsRecords, s.lengthKey
+	s.mu.Unlock()
+	switch {
+	case tlsRecords:
+		return frame.ReadTLSRecord, frame.WriteTLSRecord
+	case key != nil:
+		mask := headerMask(key)
+		return func(r io.Reader, nonceSize, overhead int) (*frame.Record, error) {
+				return frame.ReadMaskedRecord(r, nonceSize, overhead, mask)
+			}, func(w io.Writer, rec *frame.Record) error {
+				return frame.WriteMaskedRecord(w, rec, mask)
+			}
+	}
+	return frame.ReadRecord, frame.WriteRecord
+}
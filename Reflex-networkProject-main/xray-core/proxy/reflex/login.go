@@ -0,0 +1,146 @@
+package reflex
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// LoginAction is what a LoginTracker does when a user handshakes from more
+// distinct source addresses than allowed within its window.
+type LoginAction int
+
+const (
+	// LoginAllow accepts every handshake; sources are only counted.
+	LoginAllow LoginAction = iota
+	// LoginAlert accepts every handshake and reports the user via OnAlert.
+	LoginAlert
+	// LoginLimit keeps serving the sources already seen but refuses new ones.
+	LoginLimit
+	// LoginBlock refuses every handshake of the user until enough sources
+	// have aged out of the window.
+	LoginBlock
+)
+
+// ParseLoginAction maps a config string to a LoginAction. The empty string
+// means LoginAllow.
+func ParseLoginAction(s string) (LoginAction, error) {
+	switch s {
+	case "", "allow":
+		return LoginAllow, nil
+	case "alert":
+		return LoginAlert, nil
+	case "limit":
+		return LoginLimit, nil
+	case "block":
+		return LoginBlock, nil
+	}
+	return LoginAllow, errors.New("reflex: unknown concurrent login action " + s)
+}
+
+// LoginTracker counts the distinct source addresses each user handshakes
+// from within a sliding window. Many addresses for one UUID usually means the
+// credential has leaked.
+type LoginTracker struct {
+	window     time.Duration
+	maxSources int
+	action     LoginAction
+
+	// OnAlert, if set, is called whenever a handshake puts a user over the
+	// limit, regardless of the action. It must not block.
+	OnAlert func(user string, sources int)
+
+	mu    sync.Mutex
+	users map[string]map[string]time.Time // user -> source -> last seen
+}
+
+// NewLoginTracker returns a tracker that allows maxSources distinct sources
+// per user within window and applies action beyond that.
+func NewLoginTracker(window time.Duration, maxSources int, action LoginAction) *LoginTracker {
+	return &LoginTracker{
+		window:     window,
+		maxSources: maxSources,
+		action:     action,
+		users:      make(map[string]map[string]time.Time),
+	}
+}
+
+// Observe records a handshake of user from source and reports whether it may
+// proceed.
+func (t *LoginTracker) Observe(user, source string, now time.Time) bool {
+	t.mu.Lock()
+	sources := t.users[user]
+	if sources == nil {
+		sources = make(map[string]time.Time)
+		t.users[user] = sources
+	}
+	for s, seen := range sources {
+		if now.Sub(seen) > t.window {
+			delete(sources, s)
+		}
+	}
+	_, known := sources[source]
+	count := len(sources)
+	if !known {
+		count++
+	}
+	over := count > t.maxSources
+
+	allowed := true
+	switch {
+	case !over:
+	case t.action == LoginLimit:
+		allowed = known
+	case t.action == LoginBlock:
+		allowed = false
+	}
+	if allowed || t.action == LoginBlock {
+		// Blocked sources are still recorded so the block lasts while they keep trying.
+		sources[source] = now
+	}
+	t.mu.Unlock()
+
+	if over && t.OnAlert != nil {
+		t.OnAlert(user, count)
+	}
+	return allowed
+}
+
+// snapshot returns the sources of each user that are still within the
+// window at now.
+func (t *LoginTracker) snapshot(now time.Time) map[string]map[string]time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	users := make(map[string]map[string]time.Time, len(t.users))
+	for user, sources := range t.users {
+		for s, seen := range sources {
+			if now.Sub(seen) > t.window {
+				continue
+			}
+			if users[user] == nil {
+				users[user] = make(map[string]time.Time)
+			}
+			users[user][s] = seen
+		}
+	}
+	return users
+}
+
+// restore adds the sources of users that are still within the window.
+func (t *LoginTracker) restore(users map[string]map[string]time.Time, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for user, sources := range users {
+		for s, seen := range sources {
+			if now.Sub(seen) > t.window {
+				continue
+			}
+			if t.users[user] == nil {
+				t.users[user] = make(map[string]time.Time)
+			}
+			if seen.After(t.users[user][s]) {
+				t.users[user][s] = seen
+			}
+		}
+	}
+}
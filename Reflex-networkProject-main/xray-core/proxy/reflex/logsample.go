@@ -0,0 +1,97 @@
+package reflex
+
+import (
+	"sync"
+	"time"
+)
+
+// Kinds of events a busy server sees too often to log every one of, see
+// LogSampler.
+const (
+	LogEventReplay = "replay" // a handshake nonce was seen before
+	LogEventProbe  = "probe"  // a connection was not Reflex and got the cover site
+	LogEventMorph  = "morph"  // a session was given a traffic profile
+)
+
+// DefaultLogBurst and DefaultLogInterval bound the events of one kind that
+// are logged: the first DefaultLogBurst of every DefaultLogInterval.
+const (
+	DefaultLogBurst    = 10
+	DefaultLogInterval = time.Minute
+)
+
+// LogSampler bounds the log volume of high-frequency events. Of every kind,
+// the first burst events of each interval are logged and the rest are
+// counted; the next event that is logged reports how many were suppressed
+// before it, and Suppressed keeps the totals. A nil *LogSampler logs every
+// event.
+type LogSampler struct {
+	burst    int
+	interval time.Duration
+
+	mu    sync.Mutex
+	kinds map[string]*logWindow
+}
+
+type logWindow struct {
+	start   time.Time
+	logged  int
+	pending uint64 // suppressed since the last logged event
+	total   uint64 // suppressed overall
+}
+
+// NewLogSampler returns a sampler logging burst events of each kind per
+// interval; zero values take the defaults.
+func NewLogSampler(burst int, interval time.Duration) *LogSampler {
+	if burst <= 0 {
+		burst = DefaultLogBurst
+	}
+	if interval <= 0 {
+		interval = DefaultLogInterval
+	}
+	return &LogSampler{burst: burst, interval: interval, kinds: make(map[string]*logWindow)}
+}
+
+// Sample reports whether an event of kind happening at now is logged and, if
+// it is, how many events of the kind were suppressed since the last one that
+// was.
+func (l *LogSampler) Sample(kind string, now time.Time) (bool, uint64) {
+	if l == nil {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	w := l.kinds[kind]
+	if w == nil {
+		w = &logWindow{start: now}
+		l.kinds[kind] = w
+	}
+	if now.Sub(w.start) >= l.interval {
+		w.start, w.logged = now, 0
+	}
+	if w.logged >= l.burst {
+		w.pending++
+		w.total++
+		return false, 0
+	}
+	w.logged++
+	suppressed := w.pending
+	w.pending = 0
+	return true, suppressed
+}
+
+// Suppressed returns how many events of each kind were not logged.
+func (l *LogSampler) Suppressed() map[string]uint64 {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	counts := make(map[string]uint64, len(l.kinds))
+	for kind, w := range l.kinds {
+		if w.total > 0 {
+			counts[kind] = w.total
+		}
+	}
+	return counts
+}
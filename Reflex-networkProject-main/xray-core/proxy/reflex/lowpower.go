@@ -0,0 +1,77 @@
+package reflex
+
+import (
+	"errors"
+	"net"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// Keepalive periods of the TCP connection under a session, and how long a
+// tunnel in low-power mode waits to batch what the application writes.
+const (
+	NormalKeepAlive    = 15 * time.Second
+	LowPowerKeepAlive  = 5 * time.Minute
+	LowPowerBatchDelay = 50 * time.Millisecond
+)
+
+// PowerMode carries the power state the device reports to the sessions it
+// is set for in ClientOptions. In low-power mode sessions trade some
+// unobservability for battery life: DATA frames are sent as they are, without
+// the padding and delays of the traffic profile, TCP keepalives are sent
+// every LowPowerKeepAlive instead of every NormalKeepAlive, and tunnels batch
+// the application's writes for up to LowPowerBatchDelay. The zero value is
+// the normal mode.
+type PowerMode struct {
+	low atomic.Bool
+}
+
+// SetLowPower switches low-power mode on or off, e.g. when the application
+// goes to the background or the battery runs low. It takes effect on the
+// next frame every session writes.
+func (p *PowerMode) SetLowPower(low bool) {
+	p.low.Store(low)
+}
+
+// LowPower reports whether low-power mode is on. A nil PowerMode is off.
+func (p *PowerMode) LowPower() bool {
+	return p != nil && p.low.Load()
+}
+
+// lowPower reports whether the session is in low-power mode, and adjusts the
+// keepalive period of its connection when the mode changed.
+func (c *ClientConn) lowPower() bool {
+	low := c.power.LowPower()
+	if c.lowKeepAlive.CompareAndSwap(!low, low) {
+		conn, _ := c.transport()
+		if tc, ok := conn.(interface{ SetKeepAlivePeriod(time.Duration) error }); ok {
+			period := NormalKeepAlive
+			if low {
+				period = LowPowerKeepAlive
+			}
+			_ = tc.SetKeepAlivePeriod(period)
+		}
+	}
+	return low
+}
+
+// batchReads reads more from local into b, after the n bytes already there,
+// until b is full or LowPowerBatchDelay passed.
+func batchReads(local net.Conn, b []byte, n int) (int, error) {
+	if err := local.SetReadDeadline(time.Now().Add(LowPowerBatchDelay)); err != nil {
+		return n, nil
+	}
+	defer func() { _ = local.SetReadDeadline(time.Time{}) }()
+	for n < len(b) {
+		m, err := local.Read(b[n:])
+		n += m
+		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				err = nil
+			}
+			return n, err
+		}
+	}
+	return n, nil
+}
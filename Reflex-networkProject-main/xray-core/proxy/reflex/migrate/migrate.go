@@ -0,0 +1,337 @@
+// Package migrate converts VLESS configuration to Reflex, for operators
+// moving fleets of servers and clients over. It backs the "xray reflex
+// migrate" command.
+package migrate
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/xtls/xray-core/proxy/reflex"
+)
+
+// Result is a converted configuration and what could not be carried over.
+type Result struct {
+	Config   json.RawMessage
+	Warnings []string
+}
+
+// Convert converts a VLESS inbound or outbound block to Reflex, or, given a
+// whole configuration, every VLESS block among its inbounds and outbounds.
+// Clients, the default fallback and the server and user of an outbound are
+// carried over; listen, port, tag, sniffing and streamSettings (TLS
+// included) are kept as they are. Settings Reflex has no equivalent for,
+// such as flows and VLESS encryption, are dropped with a warning.
+func Convert(config []byte) (*Result, error) {
+	var root map[string]json.RawMessage
+	if err := json.Unmarshal(config, &root); err != nil {
+		return nil, errors.New("reflex: configuration is not a JSON object")
+	}
+	r := &Result{}
+	_, inbounds := root["inbounds"]
+	_, outbounds := root["outbounds"]
+	if _, ok := root["protocol"]; ok || !inbounds && !outbounds {
+		inbound := true
+		if _, ok := root["vnext"]; ok {
+			inbound = false
+		}
+		if s, ok := root["settings"]; ok && isOutboundSettings(s) {
+			inbound = false
+		}
+		if err := r.convertBlock(root, "", inbound); err != nil {
+			return nil, err
+		}
+	} else {
+		converted := 0
+		for _, list := range []struct {
+			key     string
+			inbound bool
+		}{{"inbounds", true}, {"outbounds", false}} {
+			n, err := r.convertList(root, list.key, list.inbound)
+			if err != nil {
+				return nil, err
+			}
+			converted += n
+		}
+		if converted == 0 {
+			return nil, errors.New("reflex: configuration has no VLESS inbound or outbound")
+		}
+	}
+	out, err := json.MarshalIndent(root, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	r.Config = out
+	return r, nil
+}
+
+// convertList converts the VLESS blocks of the inbounds or outbounds of a
+// whole configuration and returns how many there were.
+func (r *Result) convertList(root map[string]json.RawMessage, key string, inbound bool) (int, error) {
+	raw, ok := root[key]
+	if !ok {
+		return 0, nil
+	}
+	var blocks []map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &blocks); err != nil {
+		return 0, fmt.Errorf("reflex: %s is not a list of objects", key)
+	}
+	n := 0
+	for i, block := range blocks {
+		if protocol(block) != "vless" {
+			continue
+		}
+		name := fmt.Sprintf("%s[%d]", key, i)
+		var tag string
+		if json.Unmarshal(block["tag"], &tag) == nil && tag != "" {
+			name += " (" + tag + ")"
+		}
+		if err := r.convertBlock(block, name, inbound); err != nil {
+			return 0, err
+		}
+		n++
+	}
+	out, err := json.Marshal(blocks)
+	if err != nil {
+		return 0, err
+	}
+	root[key] = out
+	return n, nil
+}
+
+// convertBlock converts one inbound or outbound block in place; name
+// prefixes its warnings.
+func (r *Result) convertBlock(block map[string]json.RawMessage, name string, inbound bool) error {
+	if p := protocol(block); p != "vless" {
+		if name == "" {
+			name = "block"
+		}
+		return fmt.Errorf("reflex: %s has protocol %q, not vless", name, p)
+	}
+	var settings []byte
+	var err error
+	warn := func(format string, args ...interface{}) {
+		msg := fmt.Sprintf(format, args...)
+		if name != "" {
+			msg = name + ": " + msg
+		}
+		r.Warnings = append(r.Warnings, msg)
+	}
+	if inbound {
+		settings, err = convertInbound(block["settings"], warn)
+	} else {
+		settings, err = convertOutbound(block["settings"], warn)
+	}
+	if err != nil {
+		if name != "" {
+			return errors.New("reflex: " + name + ": " + strings.TrimPrefix(err.Error(), "reflex: "))
+		}
+		return err
+	}
+	block["protocol"] = json.RawMessage(`"reflex"`)
+	block["settings"] = settings
+	return nil
+}
+
+func protocol(block map[string]json.RawMessage) string {
+	var p string
+	_ = json.Unmarshal(block["protocol"], &p)
+	return strings.ToLower(p)
+}
+
+// isOutboundSettings tells the settings of a VLESS outbound, which name a
+// server, from those of an inbound.
+func isOutboundSettings(raw json.RawMessage) bool {
+	var s map[string]json.RawMessage
+	if json.Unmarshal(raw, &s) != nil {
+		return false
+	}
+	_, vnext := s["vnext"]
+	_, address := s["address"]
+	return vnext || address
+}
+
+type vlessUser struct {
+	ID         string `json:"id"`
+	Level      uint32 `json:"level"`
+	Email      string `json:"email"`
+	Flow       string `json:"flow"`
+	Encryption string `json:"encryption"`
+}
+
+type vlessFallback struct {
+	Name string          `json:"name"`
+	Alpn string          `json:"alpn"`
+	Path string          `json:"path"`
+	Dest json.RawMessage `json:"dest"`
+	Xver uint64          `json:"xver"`
+}
+
+type vlessInbound struct {
+	Clients    []vlessUser      `json:"clients"`
+	Decryption string           `json:"decryption"`
+	Fallbacks  []*vlessFallback `json:"fallbacks"`
+}
+
+type reflexUser struct {
+	ID    string `json:"id"`
+	Level uint32 `json:"level,omitempty"`
+	Email string `json:"email,omitempty"`
+}
+
+type reflexInbound struct {
+	Clients  []reflexUser `json:"clients"`
+	Fallback *struct {
+		Dest json.RawMessage `json:"dest"`
+	} `json:"fallback,omitempty"`
+}
+
+func convertInbound(raw json.RawMessage, warn func(string, ...interface{})) ([]byte, error) {
+	var in vlessInbound
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &in); err != nil {
+			return nil, errors.New("reflex: invalid VLESS inbound settings: " + err.Error())
+		}
+	}
+	out := reflexInbound{Clients: []reflexUser{}}
+	flows := 0
+	for i, c := range in.Clients {
+		if c.ID == "" {
+			return nil, fmt.Errorf("reflex: client %d has no id", i)
+		}
+		if c.Flow != "" {
+			flows++
+		}
+		out.Clients = append(out.Clients, reflexUser{ID: c.ID, Level: c.Level, Email: c.Email})
+	}
+	if flows > 0 {
+		warn("the flow of %d client(s) was dropped: Reflex shapes its own traffic", flows)
+	}
+	if in.Decryption != "" && in.Decryption != "none" {
+		warn("VLESS decryption was dropped: the Reflex handshake does its own key exchange")
+	}
+
+	// Reflex has one fallback: the one VLESS uses when no name, ALPN or
+	// path matches.
+	var fallback *vlessFallback
+	for _, fb := range in.Fallbacks {
+		if fb.Name == "" && fb.Alpn == "" && fb.Path == "" {
+			fallback = fb
+			break
+		}
+	}
+	if len(in.Fallbacks) > 0 && fallback == nil {
+		warn("no default fallback; %d fallback(s) by name, ALPN or path were dropped", len(in.Fallbacks))
+	} else if len(in.Fallbacks) > 1 {
+		warn("%d fallback(s) by name, ALPN or path were dropped: Reflex has a single fallback", len(in.Fallbacks)-1)
+	}
+	if fallback != nil {
+		dest, err := fallbackDest(fallback.Dest)
+		if err != nil {
+			warn("fallback dropped: %s", strings.TrimPrefix(err.Error(), "reflex: "))
+		} else {
+			if fallback.Xver != 0 {
+				warn("the fallback's PROXY protocol header (xver %d) was dropped", fallback.Xver)
+			}
+			out.Fallback = &struct {
+				Dest json.RawMessage `json:"dest"`
+			}{Dest: dest}
+		}
+	}
+	return json.Marshal(out)
+}
+
+// fallbackDest converts the dest of a VLESS fallback, which may also be a
+// Unix socket, to the port or host:port a Reflex fallback dials.
+func fallbackDest(raw json.RawMessage) (json.RawMessage, error) {
+	var port uint32
+	if json.Unmarshal(raw, &port) == nil {
+		if _, _, err := reflex.ParseFallbackDest(strconv.FormatUint(uint64(port), 10)); err != nil {
+			return nil, err
+		}
+		return raw, nil
+	}
+	var dest string
+	if err := json.Unmarshal(raw, &dest); err != nil || dest == "" {
+		return nil, errors.New("reflex: fallback has no dest")
+	}
+	if strings.HasPrefix(dest, "/") || strings.HasPrefix(dest, "@") || dest == "serve-ws-none" {
+		return nil, errors.New("reflex: fallback " + dest + " is not a TCP address")
+	}
+	if _, _, err := reflex.ParseFallbackDest(dest); err != nil {
+		return nil, err
+	}
+	if isPort(dest) {
+		return json.RawMessage(dest), nil
+	}
+	return json.Marshal(dest)
+}
+
+type vlessServer struct {
+	Address string            `json:"address"`
+	Port    uint16            `json:"port"`
+	Users   []json.RawMessage `json:"users"`
+}
+
+type vlessOutbound struct {
+	vlessUser
+	Address string         `json:"address"`
+	Port    uint16         `json:"port"`
+	Vnext   []*vlessServer `json:"vnext"`
+}
+
+type reflexOutbound struct {
+	Address string `json:"address"`
+	Port    uint16 `json:"port"`
+	ID      string `json:"id"`
+}
+
+func convertOutbound(raw json.RawMessage, warn func(string, ...interface{})) ([]byte, error) {
+	var in vlessOutbound
+	if err := json.Unmarshal(raw, &in); err != nil {
+		return nil, errors.New("reflex: invalid VLESS outbound settings: " + err.Error())
+	}
+	user := in.vlessUser
+	address, port := in.Address, in.Port
+	if len(in.Vnext) > 0 {
+		server := in.Vnext[0]
+		if len(in.Vnext) > 1 {
+			warn("%d server(s) after the first were dropped: a Reflex outbound has one server", len(in.Vnext)-1)
+		}
+		if len(server.Users) == 0 {
+			return nil, errors.New("reflex: outbound server has no user")
+		}
+		if len(server.Users) > 1 {
+			warn("%d user(s) after the first were dropped: a Reflex outbound has one user", len(server.Users)-1)
+		}
+		if err := json.Unmarshal(server.Users[0], &user); err != nil {
+			return nil, errors.New("reflex: invalid VLESS user: " + err.Error())
+		}
+		address, port = server.Address, server.Port
+	}
+	if address == "" || port == 0 {
+		return nil, errors.New("reflex: outbound has no server address and port")
+	}
+	if user.ID == "" {
+		return nil, errors.New("reflex: outbound has no user id")
+	}
+	if user.Flow != "" {
+		warn("flow %s was dropped: Reflex shapes its own traffic", user.Flow)
+	}
+	if user.Encryption != "" && user.Encryption != "none" {
+		warn("VLESS encryption was dropped: the Reflex handshake does its own key exchange")
+	}
+	return json.Marshal(reflexOutbound{Address: address, Port: port, ID: user.ID})
+}
+
+func isPort(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
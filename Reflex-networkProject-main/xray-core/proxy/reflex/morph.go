@@ -3,13 +3,21 @@ package reflex
 import (
 	crand "crypto/rand"
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"io"
 	"math/rand"
 	"sort"
 	"sync"
 	"time"
+
+	"github.com/xtls/xray-core/proxy/reflex/frame"
 )
 
+// maxMorphedSize is the largest frame payload a record can carry: the record
+// also holds the nonce, the AEAD tag and the frame type.
+const maxMorphedSize = frame.MaxRecordSize - 12 - 16 - 1
+
 // TrafficProfile describes the statistical shape of traffic for a given
 // impersonated protocol (e.g. YouTube, Zoom, HTTP/2 API).
 type TrafficProfile struct {
@@ -146,14 +154,39 @@ func (p *TrafficProfile) SetNextDelay(delay time.Duration) {
 	p.nextDelay = delay
 }
 
-// AddPadding pads or truncates a payload to reach the targetSize. When padding
-// is required, random bytes are appended to reach the exact target size.
-func AddPadding(data []byte, targetSize int) []byte {
-	if targetSize <= 0 {
-		return data
+// Validate reports the first problem that makes the profile unusable for
+// shaping.
+func (p *TrafficProfile) Validate() error {
+	if len(p.PacketSizes) == 0 {
+		return fmt.Errorf("reflex: profile %s has no packet sizes", p.Name)
 	}
+	var total float64
+	for _, d := range p.PacketSizes {
+		if d.Size <= 0 || d.Size > maxMorphedSize {
+			return fmt.Errorf("reflex: profile %s has packet size %d outside 1-%d", p.Name, d.Size, maxMorphedSize)
+		}
+		if d.Weight < 0 {
+			return fmt.Errorf("reflex: profile %s has a negative packet size weight", p.Name)
+		}
+		total += d.Weight
+	}
+	if total <= 0 {
+		return fmt.Errorf("reflex: profile %s has no packet size with a positive weight", p.Name)
+	}
+	for _, d := range p.Delays {
+		if d.Delay < 0 || d.Weight < 0 {
+			return fmt.Errorf("reflex: profile %s has a negative delay or delay weight", p.Name)
+		}
+	}
+	return nil
+}
+
+// AddPadding appends random bytes to data to reach targetSize. Data that
+// already reaches it is returned as it is: it is never cut, so the frame's
+// payload must say where its data ends, see PaddedDataPayload.
+func AddPadding(data []byte, targetSize int) []byte {
 	if len(data) >= targetSize {
-		return data[:targetSize]
+		return data
 	}
 
 	padding := make([]byte, targetSize-len(data))
@@ -161,29 +194,141 @@ func AddPadding(data []byte, targetSize int) []byte {
 	return append(data, padding...)
 }
 
+// paddedDataHeaderSize is the size of the length of a PADDED_DATA payload.
+const paddedDataHeaderSize = 2
+
+// PaddedDataPayload returns the payload of a PADDED_DATA frame with data,
+// the form a DATA frame takes once padded:
+//
+//	length (2, big endian) | data | padding
+//
+// data must fit in a frame with the header. Session.ReadFrame returns
+// PADDED_DATA frames as the DATA frames they stand for, without the padding.
+func PaddedDataPayload(data []byte) []byte {
+	b := make([]byte, paddedDataHeaderSize+len(data))
+	binary.BigEndian.PutUint16(b, uint16(len(data)))
+	copy(b[paddedDataHeaderSize:], data)
+	return b
+}
+
+// unpadded returns the DATA frame the PADDED_DATA frame f stands for.
+func unpadded(f *Frame) (*Frame, error) {
+	if len(f.Payload) < paddedDataHeaderSize || len(f.Payload)-paddedDataHeaderSize < int(binary.BigEndian.Uint16(f.Payload)) {
+		return nil, errors.New("reflex: malformed PADDED_DATA frame")
+	}
+	n := int(binary.BigEndian.Uint16(f.Payload))
+	return &Frame{Type: FrameTypeData, Payload: f.Payload[paddedDataHeaderSize : paddedDataHeaderSize+n]}, nil
+}
+
+// morphChunk splits the payload of a frame of frameType to be padded to
+// targetSize: it returns the type and payload of the frame to pad, and the
+// payload of the frame of frameType left to send, if any. DATA is sent as
+// PADDED_DATA, and STREAM_DATA as it is, in chunks that fit targetSize with
+// their header. Other frames are padded as they are. A targetSize too small
+// for any data leaves the frame unpadded.
+func morphChunk(frameType uint8, payload []byte, targetSize int) (uint8, []byte, []byte) {
+	switch frameType {
+	case FrameTypeData:
+		room := targetSize - paddedDataHeaderSize
+		if room <= 0 {
+			return frameType, payload, nil
+		}
+		if len(payload) <= room {
+			return FrameTypePaddedData, PaddedDataPayload(payload), nil
+		}
+		return FrameTypePaddedData, PaddedDataPayload(payload[:room]), payload[room:]
+	case FrameTypeStreamData:
+		sf, err := ParseStreamFrame(&Frame{Type: frameType, Payload: payload})
+		room := targetSize - streamDataHeaderSize
+		if err != nil || room <= 0 || len(sf.Data) <= room {
+			return frameType, payload, nil
+		}
+		return frameType, StreamDataPayload(sf.ID, sf.Data[:room]), StreamDataPayload(sf.ID, sf.Data[room:])
+	}
+	return frameType, payload, nil
+}
+
 // WriteFrameWithMorphing writes a frame with traffic morphing: payload is padded
 // to a profile-sampled size, then written via session, then a profile-sampled
 // delay is applied. If profile is nil, morphing is skipped (no padding, no delay).
+// The session's MorphingMode may skip the delay, or morphing altogether.
+//
+// Padding never cuts data: a DATA or STREAM_DATA payload larger than the
+// sampled size is sent as several frames, each of a size sampled anew, see
+// morphChunk. The sampled size is kept within what one frame of session
+// carries.
+//
+// With pacing on, see SetPacing, the delay is kept by the frame that follows:
+// it waits for its slot on the session's schedule, and the socket is paced.
+//
+// With a LeakageAudit attached to session, DATA frames are recorded into it.
+//
+// Once the session measured the jitter of the path, see PathQuality, the
+// delay is shortened by it, since the path already spreads frames out.
+//
+// The padding of DATA frames is counted apart from their payload, see
+// Overhead.
 func WriteFrameWithMorphing(session *Session, w io.Writer, frameType uint8, payload []byte, profile *TrafficProfile) error {
-	if profile == nil {
-		return session.WriteFrame(w, frameType, payload)
+	if audit := session.leakageAudit(); audit != nil && carriesData(frameType) {
+		plainSize, plainAt := len(payload), time.Now()
+		cw := &countingWriter{w: w}
+		w = cw
+		defer func() {
+			if cw.n > 0 {
+				audit.observe(plainSize, plainAt, cw.n, cw.at)
+			}
+		}()
 	}
-	targetSize := profile.GetPacketSize()
-	morphed := AddPadding(payload, targetSize)
-	if err := session.WriteFrame(w, frameType, morphed); err != nil {
-		return err
+	pacer := session.getPacer()
+	mode := session.Morphing()
+	if profile == nil || mode == MorphingOff {
+		if pacer != nil {
+			pacer.setRate(w, 0, 0)
+		}
+		return session.WriteFrame(w, frameType, payload)
 	}
-	if d := profile.GetDelay(); d > 0 {
-		time.Sleep(d)
+	for {
+		targetSize := min(profile.GetPacketSize(), session.MaxPayload())
+		chunkType, chunk, rest := morphChunk(frameType, payload, targetSize)
+		morphed := AddPadding(chunk, targetSize)
+		if pacer != nil {
+			if mode == MorphingPaddingOnly {
+				pacer.setRate(w, 0, 0)
+			} else {
+				pacer.wait(w, len(morphed), session.shapeDelay(profile.GetDelay()))
+			}
+		}
+		if err := session.WriteFrame(w, chunkType, morphed); err != nil {
+			return err
+		}
+		if carriesData(frameType) {
+			// The length PADDED_DATA adds to DATA is padding as well. What
+			// is padding is never less than nothing, or the payload counted
+			// would be more than was sent.
+			added := len(morphed) - len(chunk)
+			if chunkType == FrameTypePaddedData {
+				added += paddedDataHeaderSize
+			}
+			session.padded(max(added, 0))
+		}
+		if pacer == nil && mode != MorphingPaddingOnly {
+			if d := session.shapeDelay(profile.GetDelay()); d > 0 {
+				time.Sleep(d)
+			}
+		}
+		if rest == nil {
+			return nil
+		}
+		payload = rest
 	}
-	return nil
 }
 
 // ApplyControlFrame updates profile from a PADDING_CTRL or TIMING_CTRL frame payload.
 // PADDING_CTRL: payload is 2 bytes (big-endian target size).
 // TIMING_CTRL: payload is 8 bytes (big-endian delay in milliseconds).
+// A frame that only carries a stamp, see SetTimestamps, changes nothing.
 func ApplyControlFrame(profile *TrafficProfile, frameType uint8, payload []byte) {
-	if profile == nil {
+	if profile == nil || isEchoOnly(frameType, payload) {
 		return
 	}
 	switch frameType {
@@ -0,0 +1,58 @@
+package reflex
+
+import "errors"
+
+// MorphingMode says how much of a traffic profile shapes the DATA frames a
+// session writes, see WriteFrameWithMorphing. High-throughput links between
+// trusted hosts, such as server to server, can skip shaping altogether.
+type MorphingMode int
+
+const (
+	// MorphingFull pads frames to the profile's packet sizes and spaces
+	// them by its delays. This is the default.
+	MorphingFull MorphingMode = iota
+	// MorphingPaddingOnly pads frames but sends them without delay.
+	MorphingPaddingOnly
+	// MorphingOff sends frames as they are.
+	MorphingOff
+)
+
+// ParseMorphingMode maps a config string to a MorphingMode. The empty string
+// means MorphingFull.
+func ParseMorphingMode(s string) (MorphingMode, error) {
+	switch s {
+	case "", "full":
+		return MorphingFull, nil
+	case "padding-only":
+		return MorphingPaddingOnly, nil
+	case "off":
+		return MorphingOff, nil
+	}
+	return MorphingFull, errors.New("reflex: unknown morphing mode " + s)
+}
+
+func (m MorphingMode) String() string {
+	switch m {
+	case MorphingPaddingOnly:
+		return "padding-only"
+	case MorphingOff:
+		return "off"
+	}
+	return "full"
+}
+
+// SetMorphing sets how much of their profile shapes the DATA frames the
+// session writes with WriteFrameWithMorphing. Only this end is affected:
+// the peer shapes what it sends by its own mode.
+func (s *Session) SetMorphing(mode MorphingMode) {
+	s.mu.Lock()
+	s.morphing = mode
+	s.mu.Unlock()
+}
+
+// Morphing returns the session's morphing mode.
+func (s *Session) Morphing() MorphingMode {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.morphing
+}
@@ -0,0 +1,272 @@
+package reflex
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+)
+
+// FeatureMux is the policy feature that lets one session carry many
+// streams, each a connection of its own to its destination, so that a
+// client does not pay for a handshake per connection.
+//
+// Every stream frame starts with the stream's ID:
+//
+//	streamID (4, big endian) | body
+//
+// STREAM_OPEN names the stream's destination, with a body laid out like the
+// payload of an ADDR frame. STREAM_DATA carries its data:
+//
+//	length (2, big endian) | data | padding
+//
+// so that the frame may be padded to a profile's packet size like DATA.
+// The data is sealed once more, with a key of the stream's own, see
+// StreamCipher.
+// STREAM_CLOSE has no body and ends the stream both ways. Either peer may
+// send it, and forgets the stream as it does; it is not answered.
+//
+// The client allocates stream IDs. Stream 0 is the session's own DATA
+// frames, which go where ADDR says as before.
+const FeatureMux = "mux"
+
+// MaxStreams is how many streams a session may have open at once. A
+// STREAM_OPEN beyond it is answered with STREAM_CLOSE.
+const MaxStreams = 128
+
+// streamHeaderSize is the size of the stream ID, and streamDataHeaderSize
+// that of the ID and length of STREAM_DATA.
+const (
+	streamHeaderSize     = 4
+	streamDataHeaderSize = streamHeaderSize + 2
+)
+
+// StreamFrame is a decoded stream frame.
+type StreamFrame struct {
+	ID          uint32
+	Destination Destination // of STREAM_OPEN
+	Data        []byte      // of STREAM_DATA, without its padding
+}
+
+// ParseStreamFrame decodes a STREAM_OPEN, STREAM_DATA or STREAM_CLOSE frame.
+// The data aliases f's payload.
+func ParseStreamFrame(f *Frame) (*StreamFrame, error) {
+	malformed := errors.New("reflex: malformed stream frame")
+	if len(f.Payload) < streamHeaderSize {
+		return nil, malformed
+	}
+	sf := &StreamFrame{ID: binary.BigEndian.Uint32(f.Payload)}
+	body := f.Payload[streamHeaderSize:]
+	switch f.Type {
+	case FrameTypeStreamOpen:
+		d, err := UnmarshalDestination(body)
+		if err != nil {
+			return nil, err
+		}
+		sf.Destination = d
+	case FrameTypeStreamData:
+		if len(body) < 2 || len(body)-2 < int(binary.BigEndian.Uint16(body)) {
+			return nil, malformed
+		}
+		sf.Data = body[2 : 2+int(binary.BigEndian.Uint16(body))]
+	case FrameTypeStreamClose:
+	default:
+		return nil, errors.New("reflex: not a stream frame")
+	}
+	return sf, nil
+}
+
+func streamPayload(id uint32, body []byte) []byte {
+	return append(binary.BigEndian.AppendUint32(nil, id), body...)
+}
+
+// StreamDataPayload returns the payload of a STREAM_DATA frame with data,
+// which must fit in a frame with the header, see MaxStreamData.
+func StreamDataPayload(id uint32, data []byte) []byte {
+	b := make([]byte, streamDataHeaderSize+len(data))
+	binary.BigEndian.PutUint32(b, id)
+	binary.BigEndian.PutUint16(b[streamHeaderSize:], uint16(len(data)))
+	copy(b[streamDataHeaderSize:], data)
+	return b
+}
+
+// MaxStreamData returns the most data one STREAM_DATA frame of s carries,
+// once sealed by its StreamCipher.
+func (s *Session) MaxStreamData() int {
+	return min(s.MaxPayload()-streamDataHeaderSize, 0xFFFF) - s.streamSealOverhead()
+}
+
+// OpenStream writes a STREAM_OPEN frame for stream id to d.
+func OpenStream(s *Session, w io.Writer, id uint32, d Destination) error {
+	return s.WriteFrame(w, FrameTypeStreamOpen, streamPayload(id, d.Marshal()))
+}
+
+// CloseStream writes a STREAM_CLOSE frame for stream id.
+func CloseStream(s *Session, w io.Writer, id uint32) error {
+	return s.WriteFrame(w, FrameTypeStreamClose, streamPayload(id, nil))
+}
+
+// carriesData reports whether frames of frameType carry the application's
+// data, which is shaped and accounted as payload.
+func carriesData(frameType uint8) bool {
+	switch frameType {
+	case FrameTypeData, FrameTypePaddedData, FrameTypeStreamData, FrameTypeDatagram:
+		return true
+	}
+	return false
+}
+
+// Mux opens streams over a ClientConn whose grant has FeatureMux. It reads
+// the session's frames itself from then on: the application reads its
+// streams instead. Like channels, streams have no flow control of their
+// own: one that is not read holds up the others.
+type Mux struct {
+	c *ClientConn
+
+	mu      sync.Mutex
+	streams map[uint32]*muxStream
+	nextID  uint32
+	err     error // why the session ended, once it did
+}
+
+// muxStream is an open stream of a Mux.
+type muxStream struct {
+	end    net.Conn // our end of the stream's pipe
+	cipher *StreamCipher
+}
+
+// NewMux starts reading the frames of c for its streams.
+func NewMux(c *ClientConn) (*Mux, error) {
+	if !c.Grant.HasFeature(FeatureMux) {
+		return nil, errors.New("reflex: server did not grant " + FeatureMux)
+	}
+	m := &Mux{c: c, streams: make(map[uint32]*muxStream)}
+	go m.readLoop()
+	return m, nil
+}
+
+// Open opens a stream to address ("host:port").
+func (m *Mux) Open(address string) (net.Conn, error) {
+	d, err := ParseDestination(address)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	if m.err != nil {
+		m.mu.Unlock()
+		return nil, m.err
+	}
+	m.nextID++
+	id := m.nextID
+	cipher, err := m.c.Session.NewStreamCipher(id)
+	if err != nil {
+		m.mu.Unlock()
+		return nil, err
+	}
+	appEnd, muxEnd := net.Pipe()
+	stream := &muxStream{end: muxEnd, cipher: cipher}
+	m.streams[id] = stream
+	m.mu.Unlock()
+
+	conn, _ := m.c.transport()
+	if err := OpenStream(m.c.Session, conn, id, d); err != nil {
+		m.forget(id)
+		return nil, err
+	}
+	go m.pump(id, stream)
+	return appEnd, nil
+}
+
+// pump sends what the application writes to stream id as STREAM_DATA
+// frames, and STREAM_CLOSE once it closed the stream.
+func (m *Mux) pump(id uint32, stream *muxStream) {
+	b := make([]byte, m.c.Session.MaxStreamData())
+	for {
+		n, err := stream.end.Read(b)
+		if n > 0 {
+			sealed, serr := stream.cipher.Seal(b[:n])
+			if serr != nil {
+				break
+			}
+			if werr := m.c.WriteFrame(FrameTypeStreamData, StreamDataPayload(id, sealed)); werr != nil {
+				break
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	if m.forget(id) {
+		conn, _ := m.c.transport()
+		_ = CloseStream(m.c.Session, conn, id)
+	}
+}
+
+// forget removes stream id and reports whether it was still open.
+func (m *Mux) forget(id uint32) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stream, found := m.streams[id]
+	if found {
+		delete(m.streams, id)
+		_ = stream.end.Close()
+	}
+	return found
+}
+
+// readLoop delivers the frames of the session to their streams until it
+// ends, and then closes them all.
+func (m *Mux) readLoop() {
+	for {
+		f, err := m.c.ReadFrame()
+		if err != nil {
+			m.closeAll(err)
+			return
+		}
+		if f.Type != FrameTypeStreamData && f.Type != FrameTypeStreamClose {
+			continue
+		}
+		sf, err := ParseStreamFrame(f)
+		if err != nil {
+			continue
+		}
+		if f.Type == FrameTypeStreamClose {
+			m.forget(sf.ID)
+			continue
+		}
+		m.mu.Lock()
+		stream, found := m.streams[sf.ID]
+		m.mu.Unlock()
+		if !found {
+			continue
+		}
+		data, err := stream.cipher.Open(sf.Data)
+		if err != nil {
+			m.forget(sf.ID)
+			continue
+		}
+		if len(data) == 0 {
+			continue
+		}
+		if _, err := stream.end.Write(data); err != nil {
+			m.forget(sf.ID)
+		}
+	}
+}
+
+func (m *Mux) closeAll(err error) {
+	m.mu.Lock()
+	m.err = err
+	streams := m.streams
+	m.streams = make(map[uint32]*muxStream)
+	m.mu.Unlock()
+	for _, stream := range streams {
+		_ = stream.end.Close()
+	}
+}
+
+// Close closes the session and with it every stream.
+func (m *Mux) Close() error {
+	return m.c.Close()
+}
@@ -0,0 +1,71 @@
+package reflex
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// OneTimeIDStep is how long a one-time ID is valid: the codes roll over at
+// every multiple of it, like TOTP codes.
+const OneTimeIDStep = 30 * time.Second
+
+// IDMode says which user identifiers the server accepts in handshakes.
+type IDMode int
+
+const (
+	// IDStatic accepts only the user's UUID.
+	IDStatic IDMode = iota
+	// IDBoth accepts the UUID or a one-time ID, for moving clients over.
+	IDBoth
+	// IDOneTime accepts only one-time IDs, so a captured handshake does not
+	// identify the user once its step is over.
+	IDOneTime
+)
+
+// ParseIDMode maps a config string to an IDMode. The empty string means
+// IDStatic.
+func ParseIDMode(s string) (IDMode, error) {
+	switch s {
+	case "", "static":
+		return IDStatic, nil
+	case "both":
+		return IDBoth, nil
+	case "one-time":
+		return IDOneTime, nil
+	}
+	return IDStatic, errors.New("reflex: unknown handshake id mode " + s)
+}
+
+// AcceptsStatic reports whether the UUID itself is accepted.
+func (m IDMode) AcceptsStatic() bool { return m != IDOneTime }
+
+// AcceptsOneTime reports whether one-time IDs are accepted.
+func (m IDMode) AcceptsOneTime() bool { return m != IDStatic }
+
+// OneTimeIDCounter returns the step a handshake timestamp falls in.
+func OneTimeIDCounter(ts int64) int64 {
+	return ts / int64(OneTimeIDStep/time.Second)
+}
+
+// OneTimeID returns the identifier a client with the given secret (its PSK,
+// or the 16 UUID bytes without one) sends in place of its UUID in a
+// handshake stamped ts: HMAC-SHA256(secret, "reflex-one-time-id" ||
+// counter) truncated to 16 bytes, where counter is OneTimeIDCounter(ts).
+func OneTimeID(secret []byte, ts int64) [16]byte {
+	return OneTimeIDAt(secret, OneTimeIDCounter(ts))
+}
+
+// OneTimeIDAt is OneTimeID for a step counter rather than a timestamp.
+func OneTimeIDAt(secret []byte, counter int64) [16]byte {
+	var c [8]byte
+	binary.BigEndian.PutUint64(c[:], uint64(counter))
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("reflex-one-time-id"))
+	mac.Write(c[:])
+	var id [16]byte
+	copy(id[:], mac.Sum(nil))
+	return id
+}
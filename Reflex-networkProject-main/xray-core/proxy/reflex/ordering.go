@@ -0,0 +1,216 @@
+package reflex
+
+import (
+	"errors"
+	"slices"
+	"sort"
+	"time"
+)
+
+// OrderingMode selects how a session treats frames that arrive in another
+// order than they were sealed in, which happens over multipath carriers and
+// bonded connections.
+type OrderingMode uint8
+
+const (
+	// OrderingStrict delivers frames in the order they were sealed. A frame
+	// that arrives after a later one is refused as a replay, and a Bond
+	// holds frames back until the ones before them arrived or were lost.
+	// This is the default.
+	OrderingStrict OrderingMode = iota
+	// OrderingTolerant accepts frames in any order: a frame is accepted
+	// once if it is no more than the reorder window behind the latest one.
+	// Frames that arrive ahead of a missing one are held in a reorder
+	// buffer and returned in the order they were sealed once it arrives, or
+	// once it is given up for lost, see ReorderBuffer. A Bond passes frames
+	// on without waiting for earlier ones and leaves that to the session.
+	OrderingTolerant
+)
+
+// FeatureReorderTolerant is the policy feature that switches both peers of
+// a session to OrderingTolerant with DefaultReorderWindow.
+const FeatureReorderTolerant = "reorder-tolerant"
+
+// Bounds of the reorder window, in frames.
+const (
+	DefaultReorderWindow = 256
+	MaxReorderWindow     = 4096
+)
+
+// Bounds of the reorder buffer of OrderingTolerant. A missing frame is
+// given up for lost once more than ReorderBuffer frames are held for it, or
+// once a frame arrives after ReorderTimeout of waiting for it, or the read
+// fails; the frames held are then returned, and the missing one is returned
+// as it comes if it still does within the reorder window. The buffer is not
+// kept across RestoreSession.
+const (
+	ReorderBuffer  = 64
+	ReorderTimeout = 200 * time.Millisecond
+)
+
+// SetOrdering sets the ordering mode of the frames the session reads. In
+// OrderingTolerant, window is how many frames behind the latest one a frame
+// may arrive; it is rounded up to a multiple of 64 and must not exceed
+// MaxReorderWindow. The replay check stays exact in both modes: no frame is
+// accepted twice.
+func (s *Session) SetOrdering(mode OrderingMode, window int) error {
+	if mode == OrderingTolerant && (window <= 0 || window > MaxReorderWindow) {
+		return errors.New("reflex: reorder window out of range")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ordering = mode
+	s.window = nil
+	s.held = nil
+	if mode == OrderingTolerant {
+		s.window = make(replayWindow, (window+63)/64)
+		if s.readSeen {
+			s.window.set(0)
+		}
+		s.expectNext()
+	}
+	return nil
+}
+
+// expectNext makes the frame after the latest read the one ReadFrame
+// returns next. s.mu must be held.
+func (s *Session) expectNext() {
+	s.nextSeq = 0
+	if s.readSeen {
+		s.nextSeq = s.readNonceCount&seqMask + 1
+	}
+}
+
+// heldFrame is a frame the reorder buffer holds for a missing one.
+type heldFrame struct {
+	seq uint64
+	f   *Frame
+}
+
+// reorder returns f, the frame with sequence seq, if it is the one
+// ReadFrame returns next or one given up for lost, and holds it if it came
+// ahead of that.
+func (s *Session) reorder(f *Frame, seq uint64, now time.Time) *Frame {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case seq == s.nextSeq:
+		s.nextSeq++
+		s.gapSince = now
+		return f
+	case seq < s.nextSeq:
+		return f
+	}
+	if len(s.held) == 0 {
+		s.gapSince = now
+	}
+	i := sort.Search(len(s.held), func(i int) bool { return s.held[i].seq > seq })
+	s.held = slices.Insert(s.held, i, heldFrame{seq: seq, f: f})
+	return nil
+}
+
+// releaseHeld returns the held frame ReadFrame returns next, if any: the
+// one it expects, or else the lowest once the buffer is over ReorderBuffer,
+// the wait timed out or the read failed. With nothing held, it returns the
+// error the read failed with, if any.
+func (s *Session) releaseHeld(now time.Time) (*Frame, error) {
+	s.mu.Lock()
+	if len(s.held) == 0 {
+		err := s.heldErr
+		s.heldErr = nil
+		s.mu.Unlock()
+		return nil, err
+	}
+	h := s.held[0]
+	if h.seq != s.nextSeq && len(s.held) <= ReorderBuffer && now.Sub(s.gapSince) < ReorderTimeout && s.heldErr == nil {
+		s.mu.Unlock()
+		return nil, nil
+	}
+	s.held = s.held[1:]
+	s.nextSeq = h.seq + 1
+	s.gapSince = now
+	s.mu.Unlock()
+	return s.delivered(h.f), nil
+}
+
+// holdError keeps err, which a read failed with while frames were held, to
+// be returned once they are. It reports false if none were.
+func (s *Session) holdError(err error) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.held) == 0 {
+		return false
+	}
+	s.heldErr = err
+	return true
+}
+
+// Ordering returns the session's ordering mode.
+func (s *Session) Ordering() OrderingMode {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ordering
+}
+
+// acceptCounter reports whether a frame with counter may be read, and
+// records it if so. s.mu must be held.
+func (s *Session) acceptCounter(counter uint64) bool {
+	if !s.readSeen {
+		if s.window != nil {
+			s.window.set(0)
+		}
+		s.readNonceCount = counter
+		return true
+	}
+	if s.window == nil {
+		if counter <= s.readNonceCount {
+			return false
+		}
+		s.readNonceCount = counter
+		return true
+	}
+	// The window goes by the sequence, which Rekey carries on, so that late
+	// frames of the epoch before are as far behind as they were sealed.
+	seq, latest := counter&seqMask, s.readNonceCount&seqMask
+	if seq > latest {
+		s.window.advance(seq - latest)
+		s.window.set(0)
+		s.readNonceCount = counter
+		return true
+	}
+	behind := latest - seq
+	if behind >= s.window.size() || s.window.has(behind) {
+		return false
+	}
+	s.window.set(behind)
+	return true
+}
+
+// replayWindow has a bit for each of the latest counters, set once the
+// frame with it was read: bit i is the counter i behind the latest one.
+type replayWindow []uint64
+
+func (w replayWindow) size() uint64 { return uint64(len(w)) * 64 }
+
+func (w replayWindow) has(i uint64) bool { return w[i/64]&(1<<(i%64)) != 0 }
+
+func (w replayWindow) set(i uint64) { w[i/64] |= 1 << (i % 64) }
+
+// advance moves the window n counters on.
+func (w replayWindow) advance(n uint64) {
+	if n >= w.size() {
+		clear(w)
+		return
+	}
+	words, bits := int(n/64), n%64
+	for i := len(w) - 1; i >= 0; i-- {
+		var v uint64
+		if j := i - words; j >= 0 {
+			v = w[j] << bits
+			if bits > 0 && j > 0 {
+				v |= w[j-1] >> (64 - bits)
+			}
+		}
+		w[i] = v
+	}
+}
@@ -1,3 +1,570 @@
+// Package outbound is the client side of Reflex as an Xray outbound: every
+// connection routed to it is carried over its own Reflex session with the
+// configured server.
+//
+// Chaining through another outbound (proxySettings) needs nothing of its own:
+// the handler dials its server with the internet.Dialer that Process is
+// given, which proxyman already routes through the chained outbound. Only
+// the handshake timeout is kept by hand, since those connections take no
+// deadlines.
+//
+// With mux on, the connections are streams of one session instead, see
+// reflex.FeatureMux, and only the first of them waits for a handshake. The
+// session outlives the connection it is dialed for: it ends with the
+// handler, or with the connection to the server.
+//
+// UDP is carried as DATAGRAM frames of a session of its own, see
+// reflex.FeatureUDP, whether mux is on or not.
+//
+// With port hopping, every connection to the server is dialed on the port
+// active at the time, see reflex.PortHopper; sessions stay on theirs.
+//
+// Over the QUIC carrier, see package carrier, every connection is a stream
+// of one QUIC connection to the server that the handler keeps, and dials
+// again once it ends. Over the UDP carrier every connection is a datagram
+// flow of its own, and asks for the record framing and ordering datagrams
+// need. Carriers are dialed directly, not through a chained outbound.
 package outbound
 
-// Outbound handler for Reflex protocol will be implemented in later steps.
\ No newline at end of file
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"io"
+	stdnet "net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/xtls/xray-core/common"
+	"github.com/xtls/xray-core/common/buf"
+	xerrors "github.com/xtls/xray-core/common/errors"
+	"github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/common/session"
+	"github.com/xtls/xray-core/common/signal"
+	"github.com/xtls/xray-core/common/task"
+	"github.com/xtls/xray-core/core"
+	"github.com/xtls/xray-core/features/policy"
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/proxy/reflex/carrier"
+	"github.com/xtls/xray-core/transport"
+	"github.com/xtls/xray-core/transport/internet"
+)
+
+// HandshakeTimeout bounds connecting to the server and the handshake.
+const HandshakeTimeout = 15 * time.Second
+
+// Handler is a Reflex outbound.
+type Handler struct {
+	server        net.Destination
+	userID        uuid.UUID
+	serverKey     ed25519.PublicKey   // the server must sign its handshakes with, if set
+	secret        []byte              // the user's PSK, if configured, otherwise the UUID bytes
+	oneTimeID     bool                // send one-time IDs in place of the UUID
+	morphing      reflex.MorphingMode // of the DATA frames sent to the server
+	hopper        *reflex.PortHopper  // picks the port to dial, if the server hops
+	carrier       string              // reflex.CarrierTCP, reflex.CarrierQUIC or reflex.CarrierUDP
+	quicTLS       *tls.Config         // of the QUIC carrier
+	fec           carrier.FEC         // of the UDP carrier
+	policyManager policy.Manager      // nil outside a running instance
+
+	quicMu sync.Mutex
+	quic   *carrier.QUICDialer // the connections' QUIC connection, nil until dialed
+
+	mux    bool
+	muxMu  sync.Mutex
+	shared *sharedSession // the session streams are opened on, nil until dialed
+	closed bool
+}
+
+// sharedSession is the session the streams of a mux handler are opened on.
+type sharedSession struct {
+	ready  chan struct{} // closed once the dial ended, with mux or err set
+	mux    *reflex.Mux
+	err    error
+	cancel context.CancelFunc // of the context the session was dialed with
+}
+
+func init() {
+	common.Must(common.RegisterConfig((*reflex.OutboundConfig)(nil), func(ctx context.Context, config interface{}) (interface{}, error) {
+		return New(ctx, config.(*reflex.OutboundConfig))
+	}))
+}
+
+// New returns a Reflex outbound for config.
+func New(ctx context.Context, config *reflex.OutboundConfig) (*Handler, error) {
+	if config.Address == "" || (config.Port == 0 && config.PortHopping == nil) || config.Port > 65535 {
+		return nil, errors.New("reflex: outbound needs a server address and port")
+	}
+	id, err := uuid.Parse(config.Id)
+	if err != nil {
+		return nil, errors.New("reflex: invalid outbound id " + config.Id)
+	}
+	morphing, err := reflex.ParseMorphingMode(config.Morphing)
+	if err != nil {
+		return nil, err
+	}
+	h := &Handler{
+		server:   net.TCPDestination(net.ParseAddress(config.Address), net.Port(config.Port)),
+		userID:   id,
+		morphing:  morphing,
+		mux:       config.Mux,
+		secret:    id[:],
+		oneTimeID: config.OneTime,
+	}
+	if config.Psk != "" {
+		psk, err := base64.StdEncoding.DecodeString(config.Psk)
+		if err != nil || len(psk) < reflex.MinPSKSize {
+			return nil, errors.New("reflex: outbound psk must be base64 of at least " + strconv.Itoa(reflex.MinPSKSize) + " bytes")
+		}
+		h.secret = psk
+	}
+	if config.PortHopping != nil {
+		if h.hopper, err = config.PortHopping.Hopper(); err != nil {
+			return nil, err
+		}
+	}
+	if config.ServerKey != "" {
+		if h.serverKey, err = reflex.ParseServerPublicKey(config.ServerKey); err != nil {
+			return nil, err
+		}
+	}
+	switch config.Carrier {
+	case "", reflex.CarrierTCP:
+		h.carrier = reflex.CarrierTCP
+	case reflex.CarrierQUIC:
+		h.carrier = config.Carrier
+		h.quicTLS = &tls.Config{ServerName: config.ServerName}
+		if h.quicTLS.ServerName == "" {
+			h.quicTLS.ServerName = config.Address
+		}
+		// The handshake authenticates a server with a key, and the
+		// certificate is only its cover.
+		h.quicTLS.InsecureSkipVerify = h.serverKey != nil
+	case reflex.CarrierUDP:
+		h.carrier = config.Carrier
+		if h.fec, err = carrier.FECOf(config.FecData, config.FecParity); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New("reflex: outbound carrier must be tcp, quic or udp, not " + config.Carrier)
+	}
+	if h.hopper != nil && h.carrier != reflex.CarrierTCP {
+		return nil, errors.New("reflex: carriers cannot be used with port hopping")
+	}
+	if v := core.FromContext(ctx); v != nil {
+		h.policyManager, _ = v.GetFeature(policy.ManagerType()).(policy.Manager)
+	}
+	return h, nil
+}
+
+// Process implements proxy.Outbound. The session's server is told to send
+// the bytes to the target, see reflex.Destination.
+func (h *Handler) Process(ctx context.Context, link *transport.Link, dialer internet.Dialer) error {
+	outbounds := session.OutboundsFromContext(ctx)
+	ob := outbounds[len(outbounds)-1]
+	if !ob.Target.IsValid() {
+		return errors.New("reflex: target not specified")
+	}
+	ob.Name = "reflex"
+	switch {
+	case ob.Target.Network == net.Network_UDP:
+		return h.processDatagrams(ctx, link, dialer, ob.Target)
+	case ob.Target.Network != net.Network_TCP:
+		return errors.New("reflex: only TCP and UDP are carried")
+	case h.mux:
+		return h.processStream(ctx, link, dialer, ob.Target)
+	}
+
+	c, err := h.connect(ctx, dialer, false)
+	if err != nil {
+		return xerrors.New("reflex: failed to connect to ", h.serverName()).Base(err).AtWarning()
+	}
+	// Closing the session closes the connection as well.
+	defer c.Close()
+	xerrors.LogInfo(ctx, "reflex: tunneling request to ", ob.Target, " via ", h.serverName())
+	if err := c.SetDestination(ob.Target.NetAddr()); err != nil {
+		return xerrors.New("reflex: failed to send destination ", ob.Target).Base(err)
+	}
+
+	sessionPolicy := h.sessionPolicy()
+	ctx, cancel := context.WithCancel(ctx)
+	timer := signal.CancelAfterInactivity(ctx, cancel, sessionPolicy.Timeouts.ConnectionIdle)
+
+	postRequest := func() error {
+		defer timer.SetTimeout(sessionPolicy.Timeouts.DownlinkOnly)
+		for {
+			mb, err := link.Reader.ReadMultiBuffer()
+			for _, b := range mb {
+				if werr := c.WriteFrame(reflex.FrameTypeData, b.Bytes()); werr != nil {
+					buf.ReleaseMulti(mb)
+					return werr
+				}
+			}
+			buf.ReleaseMulti(mb)
+			timer.Update()
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					return nil
+				}
+				return err
+			}
+		}
+	}
+
+	getResponse := func() error {
+		defer timer.SetTimeout(sessionPolicy.Timeouts.UplinkOnly)
+		for {
+			f, err := c.ReadFrame()
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					return nil
+				}
+				return err
+			}
+			if f.Type != reflex.FrameTypeData {
+				continue
+			}
+			if err := link.Writer.WriteMultiBuffer(buf.MergeBytes(nil, f.Payload)); err != nil {
+				return err
+			}
+			timer.Update()
+		}
+	}
+
+	responseDoneAndCloseWriter := task.OnSuccess(getResponse, task.Close(link.Writer))
+	if err := task.Run(ctx, postRequest, responseDoneAndCloseWriter); err != nil {
+		return xerrors.New("reflex: connection ends").Base(err)
+	}
+	return nil
+}
+
+// processStream carries a connection to target as a stream of the shared
+// session.
+func (h *Handler) processStream(ctx context.Context, link *transport.Link, dialer internet.Dialer, target net.Destination) error {
+	conn, err := h.openStream(ctx, dialer, target.NetAddr())
+	if err != nil {
+		return xerrors.New("reflex: failed to open a stream to ", target, " via ", h.serverName()).Base(err).AtWarning()
+	}
+	defer conn.Close()
+	xerrors.LogInfo(ctx, "reflex: tunneling request to ", target, " in a stream via ", h.serverName())
+
+	sessionPolicy := h.sessionPolicy()
+	ctx, cancel := context.WithCancel(ctx)
+	timer := signal.CancelAfterInactivity(ctx, cancel, sessionPolicy.Timeouts.ConnectionIdle)
+
+	postRequest := func() error {
+		defer timer.SetTimeout(sessionPolicy.Timeouts.DownlinkOnly)
+		return buf.Copy(link.Reader, buf.NewWriter(conn), buf.UpdateActivity(timer))
+	}
+	getResponse := func() error {
+		defer timer.SetTimeout(sessionPolicy.Timeouts.UplinkOnly)
+		return buf.Copy(buf.NewReader(conn), link.Writer, buf.UpdateActivity(timer))
+	}
+
+	responseDoneAndCloseWriter := task.OnSuccess(getResponse, task.Close(link.Writer))
+	if err := task.Run(ctx, postRequest, responseDoneAndCloseWriter); err != nil {
+		return xerrors.New("reflex: connection ends").Base(err)
+	}
+	return nil
+}
+
+// processDatagrams carries the datagrams of a connection to target, or to
+// where each says, over a session of its own. The datagrams that come back
+// say where they came from.
+func (h *Handler) processDatagrams(ctx context.Context, link *transport.Link, dialer internet.Dialer, target net.Destination) error {
+	c, err := h.connect(ctx, dialer, true)
+	if err != nil {
+		return xerrors.New("reflex: failed to connect to ", h.serverName()).Base(err).AtWarning()
+	}
+	defer c.Close()
+	if !c.Grant.HasFeature(reflex.FeatureUDP) {
+		return xerrors.New("reflex: ", h.serverName(), " does not carry UDP").AtWarning()
+	}
+	xerrors.LogInfo(ctx, "reflex: tunneling datagrams to ", target, " via ", h.serverName())
+
+	sessionPolicy := h.sessionPolicy()
+	ctx, cancel := context.WithCancel(ctx)
+	timer := signal.CancelAfterInactivity(ctx, cancel, sessionPolicy.Timeouts.ConnectionIdle)
+
+	postRequest := func() error {
+		defer timer.SetTimeout(sessionPolicy.Timeouts.DownlinkOnly)
+		for {
+			mb, err := link.Reader.ReadMultiBuffer()
+			for _, b := range mb {
+				dest := target
+				if b.UDP != nil {
+					dest = *b.UDP
+				}
+				if d, derr := reflex.ParseDestination(dest.NetAddr()); derr != nil || int(b.Len()) > c.Session.MaxDatagram(d) {
+					// Dropped, as UDP would.
+					continue
+				}
+				if werr := c.WriteDatagram(dest.NetAddr(), b.Bytes()); werr != nil {
+					buf.ReleaseMulti(mb)
+					return werr
+				}
+			}
+			buf.ReleaseMulti(mb)
+			timer.Update()
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					return nil
+				}
+				return err
+			}
+		}
+	}
+
+	getResponse := func() error {
+		defer timer.SetTimeout(sessionPolicy.Timeouts.UplinkOnly)
+		for {
+			f, err := c.ReadFrame()
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					return nil
+				}
+				return err
+			}
+			if f.Type != reflex.FrameTypeDatagram {
+				continue
+			}
+			dg, err := reflex.ParseDatagram(f)
+			if err != nil {
+				return err
+			}
+			b := buf.FromBytes(append([]byte(nil), dg.Data...))
+			from := net.UDPDestination(net.ParseAddress(dg.Destination.Host), net.Port(dg.Destination.Port))
+			b.UDP = &from
+			if err := link.Writer.WriteMultiBuffer(buf.MultiBuffer{b}); err != nil {
+				return err
+			}
+			timer.Update()
+		}
+	}
+
+	responseDoneAndCloseWriter := task.OnSuccess(getResponse, task.Close(link.Writer))
+	if err := task.Run(ctx, postRequest, responseDoneAndCloseWriter); err != nil {
+		return xerrors.New("reflex: connection ends").Base(err)
+	}
+	return nil
+}
+
+// openStream opens a stream to address on the shared session, dialing the
+// session first if there is none yet or the last one ended. The stream
+// waits for a dial under way as long as ctx allows.
+func (h *Handler) openStream(ctx context.Context, dialer internet.Dialer, address string) (stdnet.Conn, error) {
+	for retried := false; ; retried = true {
+		s, err := h.sharedSession(ctx, dialer)
+		if err != nil {
+			return nil, err
+		}
+		select {
+		case <-s.ready:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if s.err != nil {
+			return nil, s.err
+		}
+		conn, err := s.mux.Open(address)
+		if err == nil || retried {
+			return conn, err
+		}
+		// The session ended: the stream dials another.
+		h.dropShared(s)
+	}
+}
+
+// sharedSession returns the shared session, starting to dial it if there is
+// none. The session is dialed with the values of ctx, but not its deadline
+// or cancellation, which are of the stream that happens to come first.
+func (h *Handler) sharedSession(ctx context.Context, dialer internet.Dialer) (*sharedSession, error) {
+	h.muxMu.Lock()
+	defer h.muxMu.Unlock()
+	if h.closed {
+		return nil, errors.New("reflex: outbound closed")
+	}
+	if h.shared == nil {
+		dialCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		h.shared = &sharedSession{ready: make(chan struct{}), cancel: cancel}
+		go h.dialShared(dialCtx, h.shared, dialer)
+	}
+	return h.shared, nil
+}
+
+// dialShared dials the shared session s.
+func (h *Handler) dialShared(ctx context.Context, s *sharedSession, dialer internet.Dialer) {
+	defer close(s.ready)
+	c, err := h.connect(ctx, dialer, false)
+	var m *reflex.Mux
+	if err == nil {
+		if m, err = reflex.NewMux(c); err != nil {
+			_ = c.Close()
+		}
+	}
+	h.muxMu.Lock()
+	defer h.muxMu.Unlock()
+	if err == nil && h.shared != s {
+		// The handler closed while the session was dialed.
+		_ = m.Close()
+		err = errors.New("reflex: outbound closed")
+	}
+	if err != nil {
+		s.cancel()
+		if h.shared == s {
+			h.shared = nil
+		}
+	}
+	s.mux, s.err = m, err
+}
+
+// dropShared closes the shared session s, which ended, so that the next
+// stream dials another.
+func (h *Handler) dropShared(s *sharedSession) {
+	h.muxMu.Lock()
+	if h.shared == s {
+		h.shared = nil
+	}
+	h.muxMu.Unlock()
+	_ = s.mux.Close()
+	s.cancel()
+}
+
+// Close closes the shared session, if any, or stops dialing it, and the
+// QUIC connection.
+func (h *Handler) Close() error {
+	h.quicMu.Lock()
+	if h.quic != nil {
+		_ = h.quic.Close()
+		h.quic = nil
+	}
+	h.quicMu.Unlock()
+	h.muxMu.Lock()
+	s := h.shared
+	h.shared, h.closed = nil, true
+	h.muxMu.Unlock()
+	if s == nil {
+		return nil
+	}
+	s.cancel()
+	select {
+	case <-s.ready:
+	default:
+		return nil // dialShared closes it
+	}
+	if s.mux == nil {
+		return nil
+	}
+	return s.mux.Close()
+}
+
+func (h *Handler) sessionPolicy() policy.Session {
+	if h.policyManager != nil {
+		return h.policyManager.ForLevel(0)
+	}
+	return policy.SessionDefault()
+}
+
+// serverName is the server to log: its address, with the range of ports if
+// it hops.
+func (h *Handler) serverName() string {
+	if h.hopper == nil {
+		return h.server.NetAddr()
+	}
+	return h.server.Address.String() + ":" + h.hopper.Range()
+}
+
+// dial opens a connection to server for one session: one of dialer, a
+// stream of the QUIC connection the handler keeps, or a UDP flow.
+func (h *Handler) dial(ctx context.Context, dialer internet.Dialer, server net.Destination) (stdnet.Conn, error) {
+	switch h.carrier {
+	case reflex.CarrierUDP:
+		conn, err := carrier.DialUDP(ctx, server.NetAddr(), h.fec)
+		if err != nil {
+			return nil, err
+		}
+		return conn, nil
+	case reflex.CarrierTCP:
+		return dialer.Dial(ctx, server)
+	}
+	h.quicMu.Lock()
+	d := h.quic
+	h.quicMu.Unlock()
+	if d != nil {
+		if conn, err := d.Open(ctx); err == nil {
+			return conn, nil
+		}
+	}
+
+	h.quicMu.Lock()
+	defer h.quicMu.Unlock()
+	if h.quic != nil && h.quic != d {
+		// Dialed again meanwhile.
+		return h.quic.Open(ctx)
+	}
+	if d != nil {
+		_ = d.Close()
+		h.quic = nil
+	}
+	d, err := carrier.DialQUIC(ctx, server.NetAddr(), h.quicTLS)
+	if err != nil {
+		return nil, err
+	}
+	h.quic = d
+	return d.Open(ctx)
+}
+
+// connect dials the server and performs the handshake, once more with the
+// cookie if the server asks for a stateless retry. A session for udp carries
+// datagrams instead of streams.
+func (h *Handler) connect(ctx context.Context, dialer internet.Dialer, udp bool) (*reflex.ClientConn, error) {
+	opts := &reflex.ClientOptions{
+		UserID:    h.userID,
+		Secret:    h.secret,
+		OneTimeID: h.oneTimeID,
+		ServerKey: h.serverKey,
+		Morphing:  h.morphing,
+		Mux:       h.mux && !udp,
+		UDP:       udp,
+	}
+	if h.carrier == reflex.CarrierUDP {
+		// Every record must fit a datagram, and those recovered from
+		// parity come late, see carrier.FECConn.
+		opts.Policy = &reflex.PolicyReq{Features: []string{reflex.FeatureTLSRecords, reflex.FeatureReorderTolerant}}
+	}
+	for {
+		server := h.server
+		if h.hopper != nil {
+			server.Port = net.Port(h.hopper.Port(time.Now()))
+		}
+		conn, err := h.dial(ctx, dialer, server)
+		if err != nil {
+			return nil, err
+		}
+		_ = conn.SetDeadline(time.Now().Add(HandshakeTimeout))
+		// The connection of an outbound chained through, see proxySettings,
+		// takes no deadline: it is closed instead once the time is up.
+		expiry := time.AfterFunc(HandshakeTimeout, func() { _ = conn.Close() })
+		c, err := reflex.ClientHandshake(conn, opts)
+		if !expiry.Stop() && err == nil {
+			err = errors.New("reflex: handshake timed out")
+		}
+		var retry *reflex.RetryError
+		if errors.As(err, &retry) && opts.Cookie == nil {
+			_ = conn.Close()
+			opts.Cookie = retry.Cookie
+			continue
+		}
+		if err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		_ = conn.SetDeadline(time.Time{})
+		return c, nil
+	}
+}
@@ -0,0 +1,91 @@
+package reflex
+
+import "sync"
+
+// Overhead counts what the frames of a session carried, telling the useful
+// payload apart from what traffic morphing added to it, so operators can
+// quantify, bill or budget the obfuscation. Counts are of frame payloads,
+// before framing and encryption, in both directions. Other frames are
+// signalling and not counted.
+//
+// Padding is only counted for the frames this end wrote: that of a DATA
+// frame read is dropped uncounted, see PaddedDataPayload, and that of other
+// frames read counts as payload.
+type Overhead struct {
+	Payload uint64 `json:"payload"` // DATA payload, without the padding this end added
+	Padding uint64 `json:"padding"` // padding this end added to DATA frames, see WriteFrameWithMorphing
+	Cover   uint64 `json:"cover"`   // PADDING_CTRL and TIMING_CTRL frames, timestamp probes included
+}
+
+// Total returns the bytes counted.
+func (o Overhead) Total() uint64 {
+	return o.Payload + o.Padding + o.Cover
+}
+
+func (o *Overhead) add(p Overhead) {
+	o.Payload += p.Payload
+	o.Padding += p.Padding
+	o.Cover += p.Cover
+}
+
+// count counts a frame of frameType with n bytes of payload.
+func (o *Overhead) count(frameType uint8, n int) {
+	switch {
+	case carriesData(frameType):
+		o.Payload += uint64(n)
+	case IsControlFrame(frameType):
+		o.Cover += uint64(n)
+	}
+}
+
+// padded counts n bytes of the DATA frames written as padding.
+func (s *Session) padded(n int) {
+	if n <= 0 {
+		return
+	}
+	s.mu.Lock()
+	s.carried.Payload -= uint64(n)
+	s.carried.Padding += uint64(n)
+	s.mu.Unlock()
+}
+
+// TakeOverhead returns what the session's frames carried since the last
+// call, so that a session served over several connections is accounted once.
+func (s *Session) TakeOverhead() Overhead {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o := s.carried
+	o.Payload -= s.taken.Payload
+	o.Padding -= s.taken.Padding
+	o.Cover -= s.taken.Cover
+	s.taken = s.carried
+	return o
+}
+
+// OverheadMeter sums the Overhead of the sessions of each user. It is safe
+// for concurrent use.
+type OverheadMeter struct {
+	mu   sync.Mutex
+	used map[string]Overhead
+}
+
+// NewOverheadMeter returns an OverheadMeter that counted nothing yet.
+func NewOverheadMeter() *OverheadMeter {
+	return &OverheadMeter{used: make(map[string]Overhead)}
+}
+
+// Add counts o for user.
+func (m *OverheadMeter) Add(user string, o Overhead) {
+	m.mu.Lock()
+	u := m.used[user]
+	u.add(o)
+	m.used[user] = u
+	m.mu.Unlock()
+}
+
+// Overhead returns what was counted for user.
+func (m *OverheadMeter) Overhead(user string) Overhead {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.used[user]
+}
@@ -0,0 +1,93 @@
+package reflex
+
+import (
+	"io"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/xtls/xray-core/transport/internet/stat"
+)
+
+// pacer keeps the schedule of the frames WriteFrameWithMorphing writes for
+// a session, see SetPacing.
+type pacer struct {
+	mu     sync.Mutex
+	next   time.Time // slot of the next frame
+	socket net.Conn  // socket the rate was set on
+	rate   uint64    // pacing rate set on socket, bytes per second
+}
+
+// SetPacing switches pacing of morphed frames on or off. Without it,
+// WriteFrameWithMorphing sleeps for the sampled gap after every write, which
+// lets the gaps drift by however long the writes took, and the kernel
+// coalesces writes that queue up on a busy socket into back-to-back segments
+// anyway. With it, every frame gets a slot, the previous slot plus the gap
+// sampled for the frame before, and waits for it before it is written; on
+// sockets that support it (TCP on Linux) the pacing rate of the socket is
+// also capped at the frame's size over its gap, so that the kernel spreads
+// the frame's segments over the gap and holds the next frame until the gap
+// is over.
+//
+// The pacing rate holds for everything written on the socket until the next
+// frame WriteFrameWithMorphing writes, which lifts it if it has no profile.
+func (s *Session) SetPacing(on bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pacing = nil
+	if on {
+		s.pacing = &pacer{}
+	}
+}
+
+func (s *Session) getPacer() *pacer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pacing
+}
+
+// wait holds a frame of size bytes, to be followed by gap, written to w until
+// its slot, and sets the pacing rate of w's socket for it.
+func (p *pacer) wait(w io.Writer, size int, gap time.Duration) {
+	p.mu.Lock()
+	slot := p.next
+	now := time.Now()
+	if slot.Before(now) {
+		// A frame that is late does not make the next ones catch up.
+		slot = now
+	}
+	p.next = slot.Add(gap)
+	p.mu.Unlock()
+
+	time.Sleep(time.Until(slot))
+	p.setRate(w, size, gap)
+}
+
+// setRate sets the pacing rate of w's socket to size bytes over gap, or
+// lifts it for a frame that has no gap after it.
+func (p *pacer) setRate(w io.Writer, size int, gap time.Duration) {
+	conn, ok := w.(net.Conn)
+	if !ok {
+		return
+	}
+	conn = stat.TryUnwrapStatsConn(conn)
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return
+	}
+	rate := unpaced
+	if gap > 0 {
+		rate = max(uint64(size)*uint64(time.Second)/uint64(gap), 1)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.socket == conn && p.rate == rate {
+		return
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil || setPacingRate(raw, rate) != nil {
+		return
+	}
+	p.socket, p.rate = conn, rate
+}
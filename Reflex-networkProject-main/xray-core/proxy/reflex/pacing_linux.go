@@ -0,0 +1,24 @@
+//go:build linux
+
+package reflex
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// unpaced is the pacing rate that leaves a socket unpaced.
+const unpaced = ^uint64(0)
+
+// setPacingRate sets SO_MAX_PACING_RATE on raw. The kernel paces TCP by
+// itself, and other sockets under the fq queueing discipline.
+func setPacingRate(raw syscall.RawConn, rate uint64) error {
+	var err error
+	if cerr := raw.Control(func(fd uintptr) {
+		err = unix.SetsockoptUint64(int(fd), unix.SOL_SOCKET, unix.SO_MAX_PACING_RATE, rate)
+	}); cerr != nil {
+		return cerr
+	}
+	return err
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+
+package reflex
+
+import (
+	"errors"
+	"syscall"
+)
+
+// unpaced is the pacing rate that leaves a socket unpaced.
+const unpaced = ^uint64(0)
+
+// setPacingRate fails: only Linux paces sockets, elsewhere frames keep to
+// their slots only.
+func setPacingRate(raw syscall.RawConn, rate uint64) error {
+	return errors.New("reflex: socket pacing is not supported")
+}
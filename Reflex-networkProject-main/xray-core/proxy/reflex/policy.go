@@ -0,0 +1,444 @@
+package reflex
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Policy encoding versions. Version 1 is profile, bandwidth, features,
+// destinations and lifetime; version 2 adds profile switches, which need the
+// client's cooperation.
+const (
+	PolicyVersion1 uint8 = 1
+	PolicyVersion2 uint8 = 2
+
+	// PolicyVersion is the newest version this build speaks.
+	PolicyVersion = PolicyVersion2
+)
+
+// DefaultProfileName is granted when neither the request nor the rule names
+// a usable profile.
+const DefaultProfileName = "http2-api"
+
+// PolicyReq is what a client asks for in the handshake's policyReq field,
+// encoded as JSON. Every field is optional.
+type PolicyReq struct {
+	Version   uint8    `json:"v,omitempty"`         // newest version the client speaks, 0 for PolicyVersion1
+	Profile   string   `json:"profile,omitempty"`   // traffic profile name, see Profiles
+	Bandwidth uint64   `json:"bandwidth,omitempty"` // bytes per second, 0 for no preference
+	Features  []string `json:"features,omitempty"`
+	Ciphers   []string `json:"ciphers,omitempty"` // AEADs the client would rather use than ChaCha20-Poly1305, see Cipher
+}
+
+// PolicyGrant is the server's decision, returned as JSON in the handshake
+// response. Both peers enforce it for the lifetime of the session.
+type PolicyGrant struct {
+	Version        uint8           `json:"v"` // negotiated version
+	Profile        string          `json:"profile"`
+	Bandwidth      uint64          `json:"bandwidth,omitempty"` // bytes per second per direction, 0 for unlimited
+	Features       []string        `json:"features,omitempty"`
+	Destinations   []string        `json:"destinations,omitempty"` // allowed destinations, empty for any
+	TTL            uint32          `json:"ttl,omitempty"`          // session lifetime in seconds, 0 for unlimited
+	Switches       []ProfileSwitch `json:"switches,omitempty"`     // profile schedule, see ProfileScheduler
+	Tier           string          `json:"tier,omitempty"`         // exposed to routing, see ContextWithGrant
+	Tags           []string        `json:"tags,omitempty"`
+	WriteTimeout   uint32          `json:"writeTimeout,omitempty"`   // seconds a frame write may take, 0 for DefaultWriteTimeout
+	Cipher         string          `json:"cipher,omitempty"`         // AEAD of the session, empty for ChaCha20-Poly1305
+	ResumeRotation uint32          `json:"resumeRotation,omitempty"` // seconds between resumption key rotations, see Session.ResumptionKey; 0 for never
+
+	// Rule names the rule the grant was derived from, for auditing. It is
+	// never sent to the client.
+	Rule string `json:"-"`
+}
+
+// ParsePolicyReq decodes a handshake policy request. An empty request is
+// valid and asks for nothing in particular.
+func ParsePolicyReq(b []byte) (*PolicyReq, error) {
+	req := &PolicyReq{}
+	if len(b) == 0 {
+		return req, nil
+	}
+	if err := json.Unmarshal(b, req); err != nil {
+		return nil, errors.New("reflex: malformed policy request")
+	}
+	return req, nil
+}
+
+// NegotiateVersion returns the version both peers speak.
+func (req *PolicyReq) NegotiateVersion() uint8 {
+	switch {
+	case req.Version == 0:
+		return PolicyVersion1
+	case req.Version > PolicyVersion:
+		return PolicyVersion
+	}
+	return req.Version
+}
+
+// IsEmpty reports whether req asks for nothing.
+func (req *PolicyReq) IsEmpty() bool {
+	return req.Profile == "" && req.Bandwidth == 0 && len(req.Features) == 0
+}
+
+// Marshal encodes req for the handshake.
+func (req *PolicyReq) Marshal() []byte {
+	b, _ := json.Marshal(req)
+	return b
+}
+
+// RequestPolicy asks the server for an updated grant mid-session. The answer
+// arrives as a POLICY_GRANT frame; decode it with ParsePolicyGrant.
+func RequestPolicy(s *Session, w io.Writer, req *PolicyReq) error {
+	return s.WriteFrame(w, FrameTypePolicyRequest, req.Marshal())
+}
+
+// ParsePolicyGrant decodes the grant from a handshake response.
+func ParsePolicyGrant(b []byte) (*PolicyGrant, error) {
+	grant := &PolicyGrant{}
+	if err := json.Unmarshal(b, grant); err != nil {
+		return nil, errors.New("reflex: malformed policy grant")
+	}
+	return grant, nil
+}
+
+// Downgrade returns a copy of g without what version cannot express, so a
+// client is never sent a grant it cannot enforce. Tier and tags only matter
+// to the server and are kept.
+func (g *PolicyGrant) Downgrade(version uint8) *PolicyGrant {
+	d := *g
+	d.Version = version
+	if version < PolicyVersion2 {
+		d.Switches = nil
+	}
+	return &d
+}
+
+// Enforceable reports why this build could not enforce g, if it cannot.
+// Clients must refuse sessions whose grant fails this check.
+func (g *PolicyGrant) Enforceable() error {
+	if g.Version == 0 || g.Version > PolicyVersion {
+		return errors.New("reflex: unsupported policy version " + strconv.Itoa(int(g.Version)))
+	}
+	if _, known := Profiles[g.Profile]; !known {
+		return errors.New("reflex: granted profile " + g.Profile + " is unknown")
+	}
+	if _, err := ParseCipher(g.Cipher); err != nil {
+		return err
+	}
+	for _, sw := range g.Switches {
+		if _, known := Profiles[sw.Profile]; !known {
+			return errors.New("reflex: scheduled profile " + sw.Profile + " is unknown")
+		}
+	}
+	return nil
+}
+
+// Marshal encodes grant for the handshake response.
+func (g *PolicyGrant) Marshal() []byte {
+	b, _ := json.Marshal(g)
+	return b
+}
+
+// HasFeature reports whether feature was granted.
+func (g *PolicyGrant) HasFeature(feature string) bool {
+	for _, f := range g.Features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// SessionLifetime returns the granted session lifetime, 0 for unlimited.
+func (g *PolicyGrant) SessionLifetime() time.Duration {
+	return time.Duration(g.TTL) * time.Second
+}
+
+// DefaultWriteTimeout bounds the frame writes of a session whose grant does
+// not, see Session.SetWriteTimeout.
+const DefaultWriteTimeout = time.Minute
+
+// FrameWriteTimeout returns how long the server lets writing one frame of
+// the session take.
+func (g *PolicyGrant) FrameWriteTimeout() time.Duration {
+	if g.WriteTimeout == 0 {
+		return DefaultWriteTimeout
+	}
+	return time.Duration(g.WriteTimeout) * time.Second
+}
+
+// AllowsDestination reports whether the grant permits connecting to host:port.
+// Entries are a domain (matching itself and its subdomains), an IP or a CIDR,
+// each optionally followed by :port; an IPv6 address followed by a port is
+// written in brackets, as in "[::1]:443".
+func (g *PolicyGrant) AllowsDestination(host string, port uint16) bool {
+	if len(g.Destinations) == 0 {
+		return true
+	}
+	host = strings.ToLower(strings.TrimSuffix(unbracket(host), "."))
+	ip := net.ParseIP(host)
+	for _, entry := range g.Destinations {
+		d, err := parseDestination(entry)
+		if err != nil || (d.port != 0 && d.port != port) {
+			continue
+		}
+		switch {
+		case d.cidr != nil:
+			if ip != nil && d.cidr.Contains(ip) {
+				return true
+			}
+		case host == d.host || strings.HasSuffix(host, "."+d.host):
+			return true
+		}
+	}
+	return false
+}
+
+type destination struct {
+	host string
+	cidr *net.IPNet
+	port uint16
+}
+
+// parseDestination parses one destination entry of a policy rule.
+func parseDestination(entry string) (destination, error) {
+	var d destination
+	host := entry
+	if strings.HasPrefix(entry, "[") && !strings.HasSuffix(entry, "]") || strings.Count(entry, ":") == 1 {
+		h, p, err := net.SplitHostPort(entry)
+		if err != nil {
+			return d, errors.New("reflex: invalid policy destination " + entry)
+		}
+		port, err := strconv.ParseUint(p, 10, 16)
+		if err != nil || port == 0 {
+			return d, errors.New("reflex: invalid port in policy destination " + entry)
+		}
+		host, d.port = h, uint16(port)
+	}
+	host = unbracket(host)
+	if host == "" {
+		return d, errors.New("reflex: empty policy destination")
+	}
+	if strings.Contains(host, "/") {
+		_, cidr, err := net.ParseCIDR(host)
+		if err != nil {
+			return d, errors.New("reflex: invalid CIDR in policy destination " + entry)
+		}
+		d.cidr = cidr
+		return d, nil
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		d.cidr = &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)}
+		return d, nil
+	}
+	d.host = strings.ToLower(strings.TrimSuffix(host, "."))
+	return d, nil
+}
+
+// PolicyRule bounds what a user may be granted. A nil list allows anything.
+type PolicyRule struct {
+	Profiles     []string // allowed profiles; the first one is the default
+	MaxBandwidth uint64   // bytes per second, 0 for unlimited
+	Features     []string // allowed features
+	Destinations []string // allowed destinations, see PolicyGrant.AllowsDestination
+	SessionTTL   uint32   // seconds, 0 for unlimited
+	WriteTimeout uint32   // seconds, 0 for DefaultWriteTimeout
+	Switches     []ProfileSwitch
+	Tier         string
+	Tags         []string
+}
+
+// PolicyRuleFromConfig compiles and validates a configured rule.
+func PolicyRuleFromConfig(c *PolicyConfig) (*PolicyRule, error) {
+	for _, p := range c.Profiles {
+		if _, known := Profiles[p]; !known {
+			return nil, errors.New("reflex: unknown profile " + p + " in policy")
+		}
+	}
+	for _, entry := range c.Destinations {
+		if _, err := parseDestination(entry); err != nil {
+			return nil, err
+		}
+	}
+	var switches []ProfileSwitch
+	for _, sw := range c.Switches {
+		if _, known := Profiles[sw.Profile]; !known {
+			return nil, errors.New("reflex: unknown profile " + sw.Profile + " in policy switch")
+		}
+		if sw.AfterBytes == 0 && sw.AfterSeconds == 0 && !sw.OnThrottle {
+			return nil, errors.New("reflex: policy switch to " + sw.Profile + " has no trigger")
+		}
+		switches = append(switches, ProfileSwitch{
+			Profile:      sw.Profile,
+			AfterBytes:   sw.AfterBytes,
+			AfterSeconds: sw.AfterSeconds,
+			OnThrottle:   sw.OnThrottle,
+		})
+	}
+	return &PolicyRule{
+		Profiles:     c.Profiles,
+		MaxBandwidth: c.MaxBandwidth,
+		Features:     c.Features,
+		Destinations: c.Destinations,
+		SessionTTL:   c.SessionTtl,
+		WriteTimeout: c.WriteTimeout,
+		Switches:     switches,
+		Tier:         c.Tier,
+		Tags:         c.Tags,
+	}, nil
+}
+
+// PolicySubject identifies the user a policy decision is made for.
+type PolicySubject struct {
+	UserID string
+	Policy string // policy name from the user's configuration
+	Level  uint32
+}
+
+// PolicyDecider makes the policy decision for a handshake. An error denies
+// the handshake.
+type PolicyDecider interface {
+	Decide(ctx context.Context, subject PolicySubject, req *PolicyReq) (*PolicyGrant, error)
+}
+
+// PolicyEngine turns policy requests into grants. Users are matched to rules
+// by the policy name in their configuration, then by their level; users
+// without a matching rule fall back to the inbound rule.
+type PolicyEngine struct {
+	rules   map[string]*PolicyRule
+	levels  map[uint32]*PolicyRule
+	inbound *PolicyRule
+
+	// DefaultPolicy names the rule applied to clients that send no policy
+	// request, instead of their own rule.
+	DefaultPolicy string
+	// DenyByDefault makes Decide refuse empty requests (when there is no
+	// DefaultPolicy) and requests for profiles the rule does not allow.
+	DenyByDefault bool
+}
+
+// NewPolicyEngine returns an engine using inbound for users without a named
+// rule. A nil inbound rule allows everything.
+func NewPolicyEngine(inbound *PolicyRule) *PolicyEngine {
+	if inbound == nil {
+		inbound = &PolicyRule{}
+	}
+	return &PolicyEngine{
+		rules:   make(map[string]*PolicyRule),
+		levels:  make(map[uint32]*PolicyRule),
+		inbound: inbound,
+	}
+}
+
+// NewPolicyEngineFromConfig compiles the configured policy section. Rules
+// with a name apply to users with that policy, the others to their level.
+func NewPolicyEngineFromConfig(configs []*PolicyConfig) (*PolicyEngine, error) {
+	e := NewPolicyEngine(nil)
+	for _, c := range configs {
+		rule, err := PolicyRuleFromConfig(c)
+		if err != nil {
+			return nil, err
+		}
+		if c.Name != "" {
+			e.SetRule(c.Name, rule)
+		} else {
+			e.SetLevelRule(c.Level, rule)
+		}
+	}
+	return e, nil
+}
+
+// SetRule installs the rule for users configured with policy name.
+func (e *PolicyEngine) SetRule(name string, rule *PolicyRule) {
+	e.rules[name] = rule
+}
+
+// SetLevelRule installs the rule for users at level that have no named rule.
+func (e *PolicyEngine) SetLevelRule(level uint32, rule *PolicyRule) {
+	e.levels[level] = rule
+}
+
+// rule returns the rule that applies to a user with policy name and level,
+// along with a label for audit records.
+func (e *PolicyEngine) rule(name string, level uint32) (*PolicyRule, string) {
+	if r, found := e.rules[name]; found {
+		return r, "policy:" + name
+	}
+	if r, found := e.levels[level]; found {
+		return r, "level:" + strconv.Itoa(int(level))
+	}
+	return e.inbound, "inbound"
+}
+
+// Evaluate grants req to a user configured with policy name at level.
+// Requests are narrowed to the rule rather than refused: a disallowed profile
+// is replaced by the rule's default, bandwidth is capped and unknown features
+// dropped. Destinations, lifetime and write timeout come from the rule alone.
+func (e *PolicyEngine) Evaluate(name string, level uint32, req *PolicyReq) *PolicyGrant {
+	r, label := e.rule(name, level)
+	grant := &PolicyGrant{
+		Profile:      grantProfile(r, req.Profile),
+		Bandwidth:    req.Bandwidth,
+		Destinations: r.Destinations,
+		TTL:          r.SessionTTL,
+		WriteTimeout: r.WriteTimeout,
+		Switches:     r.Switches,
+		Tier:         r.Tier,
+		Tags:         r.Tags,
+		Rule:         label,
+	}
+	if r.MaxBandwidth > 0 && (grant.Bandwidth == 0 || grant.Bandwidth > r.MaxBandwidth) {
+		grant.Bandwidth = r.MaxBandwidth
+	}
+	for _, f := range req.Features {
+		if r.Features == nil || contains(r.Features, f) {
+			grant.Features = append(grant.Features, f)
+		}
+	}
+	return grant
+}
+
+// Decide implements PolicyDecider. Unless DenyByDefault is set the local
+// engine never denies.
+func (e *PolicyEngine) Decide(ctx context.Context, subject PolicySubject, req *PolicyReq) (*PolicyGrant, error) {
+	if req.IsEmpty() {
+		switch {
+		case e.DefaultPolicy != "":
+			subject.Policy = e.DefaultPolicy
+		case e.DenyByDefault:
+			return nil, errors.New("reflex: empty policy request denied")
+		}
+	}
+	grant := e.Evaluate(subject.Policy, subject.Level, req)
+	if e.DenyByDefault && req.Profile != "" && grant.Profile != req.Profile {
+		return nil, errors.New("reflex: profile " + req.Profile + " denied by " + grant.Rule)
+	}
+	return grant, nil
+}
+
+func grantProfile(r *PolicyRule, requested string) string {
+	if _, known := Profiles[requested]; known && (r.Profiles == nil || contains(r.Profiles, requested)) {
+		return requested
+	}
+	for _, p := range r.Profiles {
+		if _, known := Profiles[p]; known {
+			return p
+		}
+	}
+	return DefaultProfileName
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,147 @@
+// Package policyrpc delegates Reflex policy decisions to a central gRPC
+// PolicyService, so a fleet of servers shares one entitlement source.
+package policyrpc
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"github.com/xtls/xray-core/proxy/reflex"
+)
+
+// Defaults for RemoteDecider.
+const (
+	DefaultTimeout  = 2 * time.Second
+	DefaultCacheTTL = time.Minute
+)
+
+var errDenied = errors.New("reflex: denied by policy server")
+
+// RemoteDecider is a reflex.PolicyDecider backed by a PolicyService. Decisions
+// are cached per user and request. When the service cannot be reached the
+// decider fails closed, denying the handshake, unless FailOpen is set, in
+// which case Fallback decides.
+type RemoteDecider struct {
+	client   PolicyServiceClient
+	conn     *grpc.ClientConn
+	Timeout  time.Duration
+	CacheTTL time.Duration
+	FailOpen bool
+	Fallback reflex.PolicyDecider
+
+	mu        sync.Mutex
+	cache     map[string]cachedDecision
+	nextSweep time.Time
+}
+
+type cachedDecision struct {
+	grant  *reflex.PolicyGrant // nil for a denial
+	expiry time.Time
+}
+
+// NewRemoteDecider returns a decider using client.
+func NewRemoteDecider(client PolicyServiceClient) *RemoteDecider {
+	return &RemoteDecider{
+		client:   client,
+		Timeout:  DefaultTimeout,
+		CacheTTL: DefaultCacheTTL,
+		cache:    make(map[string]cachedDecision),
+	}
+}
+
+// Dial connects to the PolicyService at address. The connection is plain
+// text; run the service on a trusted network or behind a local tunnel.
+func Dial(address string) (*RemoteDecider, error) {
+	conn, err := grpc.NewClient(address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, err
+	}
+	d := NewRemoteDecider(NewPolicyServiceClient(conn))
+	d.conn = conn
+	return d, nil
+}
+
+// Close closes the connection opened by Dial.
+func (d *RemoteDecider) Close() error {
+	if d.conn == nil {
+		return nil
+	}
+	return d.conn.Close()
+}
+
+// Decide implements reflex.PolicyDecider.
+func (d *RemoteDecider) Decide(ctx context.Context, subject reflex.PolicySubject, req *reflex.PolicyReq) (*reflex.PolicyGrant, error) {
+	key := cacheKey(subject, req)
+	now := time.Now()
+	d.mu.Lock()
+	if c, found := d.cache[key]; found && now.Before(c.expiry) {
+		d.mu.Unlock()
+		if c.grant == nil {
+			return nil, errDenied
+		}
+		return c.grant, nil
+	}
+	d.mu.Unlock()
+
+	rpcCtx, cancel := context.WithTimeout(ctx, d.Timeout)
+	defer cancel()
+	resp, err := d.client.Evaluate(rpcCtx, &EvaluateRequest{
+		UserId:    subject.UserID,
+		Policy:    subject.Policy,
+		Level:     subject.Level,
+		Profile:   req.Profile,
+		Bandwidth: req.Bandwidth,
+		Features:  req.Features,
+	})
+	if err != nil {
+		if d.FailOpen && d.Fallback != nil {
+			return d.Fallback.Decide(ctx, subject, req)
+		}
+		return nil, err
+	}
+
+	var grant *reflex.PolicyGrant
+	if !resp.Deny {
+		grant = &reflex.PolicyGrant{
+			Profile:      resp.Profile,
+			Bandwidth:    resp.Bandwidth,
+			Features:     resp.Features,
+			Destinations: resp.Destinations,
+			TTL:          resp.Ttl,
+			Tier:         resp.Tier,
+			Tags:         resp.Tags,
+			Rule:         "remote",
+		}
+	}
+	ttl := d.CacheTTL
+	if resp.CacheTtl > 0 {
+		ttl = time.Duration(resp.CacheTtl) * time.Second
+	}
+	d.mu.Lock()
+	if now.After(d.nextSweep) {
+		for k, c := range d.cache {
+			if now.After(c.expiry) {
+				delete(d.cache, k)
+			}
+		}
+		d.nextSweep = now.Add(d.CacheTTL)
+	}
+	d.cache[key] = cachedDecision{grant: grant, expiry: now.Add(ttl)}
+	d.mu.Unlock()
+
+	if grant == nil {
+		return nil, errDenied
+	}
+	return grant, nil
+}
+
+func cacheKey(subject reflex.PolicySubject, req *reflex.PolicyReq) string {
+	return strings.Join([]string{subject.UserID, subject.Policy, strconv.FormatUint(uint64(subject.Level), 10), string(req.Marshal())}, "\x00")
+}
@@ -0,0 +1,84 @@
+package reflex
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// DefaultHopInterval is how long each port stays current when port hopping.
+const DefaultHopInterval = time.Minute
+
+// HopWindow is how many intervals before and after the current one a port
+// stays active, to absorb clock skew and handshakes started just before a hop.
+const HopWindow = 1
+
+// PortHopper derives the currently active port from a shared secret and the
+// clock, so client and server agree on it without talking. The inbound
+// listens on the whole range and only accepts handshakes on active ports;
+// established sessions stay on the port they started on.
+type PortHopper struct {
+	secret   []byte
+	base     uint16
+	count    uint16
+	interval time.Duration
+}
+
+// NewPortHopper returns a hopper over the count ports starting at base. A
+// zero interval means DefaultHopInterval.
+func NewPortHopper(secret []byte, base, count uint16, interval time.Duration) (*PortHopper, error) {
+	if len(secret) == 0 {
+		return nil, errors.New("reflex: port hopping needs a secret")
+	}
+	if count == 0 || int(base)+int(count) > 65536 {
+		return nil, errors.New("reflex: invalid port hopping range")
+	}
+	if interval <= 0 {
+		interval = DefaultHopInterval
+	}
+	return &PortHopper{secret: secret, base: base, count: count, interval: interval}, nil
+}
+
+// Hopper returns the hopper c configures.
+func (c *PortHopping) Hopper() (*PortHopper, error) {
+	if c.BasePort > 65535 || c.PortCount > 65535 || c.BasePort+c.PortCount > 65536 {
+		return nil, errors.New("reflex: invalid port hopping range")
+	}
+	return NewPortHopper([]byte(c.Secret), uint16(c.BasePort), uint16(c.PortCount), time.Duration(c.Interval)*time.Second)
+}
+
+// Range returns the ports hopped over, as "base-last".
+func (p *PortHopper) Range() string {
+	return strconv.Itoa(int(p.base)) + "-" + strconv.Itoa(int(p.base)+int(p.count)-1)
+}
+
+// Port returns the port clients should dial at now.
+func (p *PortHopper) Port(now time.Time) uint16 {
+	return p.portAt(p.epoch(now))
+}
+
+// Active reports whether the server accepts handshakes on port at now.
+func (p *PortHopper) Active(port uint16, now time.Time) bool {
+	e := p.epoch(now)
+	for i := -HopWindow; i <= HopWindow; i++ {
+		if p.portAt(e+int64(i)) == port {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *PortHopper) epoch(now time.Time) int64 {
+	return now.UnixNano() / int64(p.interval)
+}
+
+func (p *PortHopper) portAt(epoch int64) uint16 {
+	mac := hmac.New(sha256.New, p.secret)
+	mac.Write([]byte("reflex-port-hop"))
+	_ = binary.Write(mac, binary.BigEndian, epoch)
+	sum := mac.Sum(nil)
+	return p.base + uint16(binary.BigEndian.Uint32(sum)%uint32(p.count))
+}
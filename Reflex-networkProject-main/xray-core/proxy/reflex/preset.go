@@ -0,0 +1,167 @@
+package reflex
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"time"
+)
+
+// Carriers a Preset can ask for: plain TCP, several connections multiplexed
+// over one TCP connection (carrier.NewChannelClient), QUIC streams
+// (carrier.DialQUIC) or UDP datagrams with forward error correction
+// (carrier.DialUDP).
+const (
+	CarrierTCP      = "tcp"
+	CarrierChannels = "channels"
+	CarrierQUIC     = "quic"
+	CarrierUDP      = "udp"
+)
+
+// Preset bundles client defaults that suit one kind of network: which cover
+// profiles blend in, which carrier gets through and how the handshake is
+// timed. Presets only pick among existing settings; the user and server
+// still come from the client's own configuration.
+type Preset struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Profiles    []string        `json:"profiles"`             // cover profiles, most preferred first
+	Carrier     string          `json:"carrier"`              // CarrierTCP, CarrierChannels, CarrierQUIC or CarrierUDP
+	TLSRecords  bool            `json:"tlsRecords,omitempty"` // ask for FeatureTLSRecords
+	Fragment    *PresetFragment `json:"fragment,omitempty"`   // split the first flight, nil to send it whole
+	// HandshakeTimeout is how long, in seconds, the client waits for the
+	// handshake response before trying again.
+	HandshakeTimeout uint32 `json:"handshakeTimeout"`
+}
+
+// PresetFragment is FragmentConfig with delays in milliseconds, as written
+// in preset files.
+type PresetFragment struct {
+	MinSize  int    `json:"minSize"`
+	MaxSize  int    `json:"maxSize"`
+	MinDelay uint32 `json:"minDelay"`
+	MaxDelay uint32 `json:"maxDelay"`
+}
+
+// Config returns f as a FragmentConfig.
+func (f *PresetFragment) Config() FragmentConfig {
+	return FragmentConfig{
+		MinSize:  f.MinSize,
+		MaxSize:  f.MaxSize,
+		MinDelay: time.Duration(f.MinDelay) * time.Millisecond,
+		MaxDelay: time.Duration(f.MaxDelay) * time.Millisecond,
+	}
+}
+
+// Presets are the built-in presets by name. They are starting points; tune
+// them with LoadPresets as regimes change.
+var Presets = map[string]*Preset{
+	"mobile-carrier": {
+		Name:             "mobile-carrier",
+		Description:      "mobile networks: video-heavy traffic, long TCP flows throttled, high handshake latency",
+		Profiles:         []string{"youtube", "http2-api"},
+		Carrier:          CarrierQUIC,
+		HandshakeTimeout: 20,
+	},
+	"campus": {
+		Name:             "campus",
+		Description:      "campus and office firewalls that pass TLS-shaped web and conferencing traffic",
+		Profiles:         []string{"http2-api", "zoom"},
+		Carrier:          CarrierTCP,
+		TLSRecords:       true,
+		HandshakeTimeout: 10,
+	},
+	"national-whitelist": {
+		Name:             "national-whitelist",
+		Description:      "national filters that only pass recognised protocols and match on first-packet signatures",
+		Profiles:         []string{"http2-api"},
+		Carrier:          CarrierChannels,
+		TLSRecords:       true,
+		Fragment:         &PresetFragment{MinSize: 8, MaxSize: 64, MinDelay: 1, MaxDelay: 10},
+		HandshakeTimeout: 15,
+	},
+	"national-throttle": {
+		Name:             "national-throttle",
+		Description:      "national filters that throttle long-lived TCP flows but leave UDP alone",
+		Profiles:         []string{"zoom", "youtube"},
+		Carrier:          CarrierQUIC,
+		HandshakeTimeout: 20,
+	},
+}
+
+// Validate reports the first setting of p this build cannot apply.
+func (p *Preset) Validate() error {
+	if p.Name == "" {
+		return errors.New("reflex: preset has no name")
+	}
+	if len(p.Profiles) == 0 {
+		return errors.New("reflex: preset " + p.Name + " has no profiles")
+	}
+	for _, name := range p.Profiles {
+		if _, known := Profiles[name]; !known {
+			return errors.New("reflex: preset " + p.Name + " uses unknown profile " + name)
+		}
+	}
+	switch p.Carrier {
+	case CarrierTCP, CarrierChannels, CarrierQUIC, CarrierUDP:
+	default:
+		return errors.New("reflex: preset " + p.Name + " uses unknown carrier " + p.Carrier)
+	}
+	if f := p.Fragment; f != nil && (f.MinSize <= 0 || f.MaxSize < f.MinSize || f.MaxDelay < f.MinDelay) {
+		return errors.New("reflex: preset " + p.Name + " has an invalid fragment range")
+	}
+	return nil
+}
+
+// ClientOptions returns the options ClientHandshake needs to follow p for
+// userID. The carrier and handshake timeout are up to the caller, which
+// dials the connection.
+func (p *Preset) ClientOptions(userID [16]byte) *ClientOptions {
+	opts := &ClientOptions{
+		UserID: userID,
+		Policy: &PolicyReq{Profile: p.Profiles[0]},
+	}
+	if p.TLSRecords || p.Carrier == CarrierUDP {
+		opts.Policy.Features = []string{FeatureTLSRecords}
+	}
+	if p.Carrier == CarrierUDP {
+		// Records a datagram carrier recovers arrive late, see carrier.FECConn.
+		opts.Policy.Features = append(opts.Policy.Features, FeatureReorderTolerant)
+	}
+	if p.Fragment != nil {
+		cfg := p.Fragment.Config()
+		opts.Fragment = &cfg
+	}
+	return opts
+}
+
+// LoadPresets reads a JSON array of presets, such as a bundle shipped for a
+// region, and returns them by name. Every preset is validated.
+func LoadPresets(r io.Reader) (map[string]*Preset, error) {
+	var list []*Preset
+	if err := json.NewDecoder(r).Decode(&list); err != nil {
+		return nil, errors.New("reflex: malformed preset bundle")
+	}
+	presets := make(map[string]*Preset, len(list))
+	for _, p := range list {
+		if err := p.Validate(); err != nil {
+			return nil, err
+		}
+		if _, dup := presets[p.Name]; dup {
+			return nil, errors.New("reflex: preset " + p.Name + " is defined twice")
+		}
+		presets[p.Name] = p
+	}
+	return presets, nil
+}
+
+// LoadPresetFile is LoadPresets for a file.
+func LoadPresetFile(path string) (map[string]*Preset, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return LoadPresets(f)
+}
@@ -0,0 +1,283 @@
+// Package probe replays the probes a censor uses to find proxy servers
+// against a Reflex server and its decoy site, and reports every probe the
+// two answer differently. A Reflex server that falls back correctly is
+// indistinguishable from the decoy for all of them.
+package probe
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/xtls/xray-core/proxy/reflex"
+)
+
+// DefaultTimeout is how long a probe waits for a response when Options
+// does not say otherwise.
+const DefaultTimeout = 5 * time.Second
+
+// Probe is one probe pattern.
+type Probe struct {
+	Name    string
+	Payload []byte // sent right after connecting, nil to send nothing
+	// Prime sends the payload to the server once before the comparison, so
+	// the compared connection replays it.
+	Prime bool
+}
+
+// Probes returns the built-in probe patterns. host is the name of the decoy
+// site, used where a probe carries one. Handshakes are made for user, or for
+// a random user if it is zero.
+func Probes(host string, user uuid.UUID) ([]Probe, error) {
+	if user == uuid.Nil {
+		user = uuid.New()
+	}
+	handshake, err := recordFirstFlight(func(conn io.ReadWriter) {
+		_, _ = reflex.ClientHandshake(conn, &reflex.ClientOptions{UserID: user})
+	})
+	if err != nil {
+		return nil, err
+	}
+	unknown, err := recordFirstFlight(func(conn io.ReadWriter) {
+		_, _ = reflex.ClientHandshake(conn, &reflex.ClientOptions{UserID: uuid.New()})
+	})
+	if err != nil {
+		return nil, err
+	}
+	hello, err := recordFirstFlight(func(conn io.ReadWriter) {
+		_ = tls.Client(&flightConn{rw: conn}, &tls.Config{ServerName: host}).Handshake()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return []Probe{
+		{Name: "empty"},
+		{Name: "random-short", Payload: randomBytes(16)},
+		{Name: "random-long", Payload: randomBytes(512)},
+		{Name: "http-get", Payload: []byte("GET / HTTP/1.1\r\nHost: " + host + "\r\n\r\n")},
+		{Name: "tls-client-hello", Payload: hello},
+		{Name: "unknown-user-handshake", Payload: unknown},
+		{Name: "truncated-handshake", Payload: handshake[:40]},
+		{Name: "replayed-handshake", Payload: handshake, Prime: true},
+	}, nil
+}
+
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return b
+}
+
+// recorder keeps the first write of a handshake and fails every read, which
+// ends the handshake right after its first flight.
+type recorder struct {
+	first []byte
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	if r.first == nil {
+		r.first = append([]byte(nil), b...)
+	}
+	return len(b), nil
+}
+
+func (r *recorder) Read([]byte) (int, error) {
+	return 0, io.EOF
+}
+
+func recordFirstFlight(handshake func(conn io.ReadWriter)) ([]byte, error) {
+	r := &recorder{}
+	handshake(r)
+	if r.first == nil {
+		return nil, errors.New("reflex: probe handshake wrote nothing")
+	}
+	return r.first, nil
+}
+
+// flightConn lets crypto/tls run over a recorder.
+type flightConn struct {
+	net.Conn
+	rw io.ReadWriter
+}
+
+func (c *flightConn) Read(b []byte) (int, error)  { return c.rw.Read(b) }
+func (c *flightConn) Write(b []byte) (int, error) { return c.rw.Write(b) }
+func (c *flightConn) Close() error                { return nil }
+
+// Options configures Run.
+type Options struct {
+	Server  string        // address of the Reflex server
+	Decoy   string        // address of the decoy site the server falls back to
+	Timeout time.Duration // how long to wait for a response, DefaultTimeout if 0
+}
+
+// Response is how one side answered a probe.
+type Response struct {
+	Data    []byte
+	End     string // "closed", "reset" or "timeout"
+	Elapsed time.Duration
+}
+
+// Result is the outcome of one probe.
+type Result struct {
+	Probe         string
+	Server, Decoy *Response
+	// Difference says what tells the server apart from the decoy, empty if
+	// they answered alike.
+	Difference string
+}
+
+// Distinguishable reports whether the probe tells the server apart.
+func (r *Result) Distinguishable() bool {
+	return r.Difference != ""
+}
+
+// Run sends every probe to the server and to the decoy. Probes run one
+// after the other, the two sides of a probe at the same time.
+func Run(opts *Options, probes []Probe) ([]Result, error) {
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	results := make([]Result, 0, len(probes))
+	for _, p := range probes {
+		if p.Prime {
+			if _, err := send(opts.Server, p.Payload, timeout); err != nil {
+				return nil, err
+			}
+		}
+		type answer struct {
+			resp *Response
+			err  error
+		}
+		decoy := make(chan answer, 1)
+		go func() {
+			resp, err := send(opts.Decoy, p.Payload, timeout)
+			decoy <- answer{resp, err}
+		}()
+		server, err := send(opts.Server, p.Payload, timeout)
+		d := <-decoy
+		if err != nil {
+			return nil, err
+		}
+		if d.err != nil {
+			return nil, d.err
+		}
+		results = append(results, Result{
+			Probe:      p.Name,
+			Server:     server,
+			Decoy:      d.resp,
+			Difference: compare(server, d.resp),
+		})
+	}
+	return results, nil
+}
+
+// send writes payload on a new connection to addr and reads until the peer
+// closes it or timeout passes.
+func send(addr string, payload []byte, timeout time.Duration) (*Response, error) {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	start := time.Now()
+	_ = conn.SetDeadline(start.Add(timeout))
+	if len(payload) > 0 {
+		if _, err := conn.Write(payload); err != nil {
+			return nil, err
+		}
+	}
+	data, err := io.ReadAll(conn)
+	resp := &Response{Data: data, End: "closed", Elapsed: time.Since(start)}
+	var netErr net.Error
+	switch {
+	case err == nil:
+	case errors.Is(err, syscall.ECONNRESET):
+		resp.End = "reset"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		resp.End = "timeout"
+	default:
+		resp.End = err.Error()
+	}
+	return resp, nil
+}
+
+// volatileHeaders change from one response to the next even on the same
+// site, so they are not compared.
+var volatileHeaders = map[string]bool{
+	"Age":        true,
+	"Date":       true,
+	"Expires":    true,
+	"Set-Cookie": true,
+}
+
+func compare(server, decoy *Response) string {
+	if server.End != decoy.End {
+		return fmt.Sprintf("connection %s by the server, %s by the decoy", server.End, decoy.End)
+	}
+	if bytes.Equal(server.Data, decoy.Data) {
+		return ""
+	}
+	if !bytes.HasPrefix(server.Data, []byte("HTTP/")) || !bytes.HasPrefix(decoy.Data, []byte("HTTP/")) {
+		return fmt.Sprintf("server sent %d bytes, decoy %d different bytes", len(server.Data), len(decoy.Data))
+	}
+	s, sBody, err := parseHTTP(server.Data)
+	if err != nil {
+		return "server sent a malformed HTTP response: " + err.Error()
+	}
+	d, dBody, err := parseHTTP(decoy.Data)
+	if err != nil {
+		return "decoy sent a malformed HTTP response: " + err.Error()
+	}
+	if s.Status != d.Status {
+		return fmt.Sprintf("status %q from the server, %q from the decoy", s.Status, d.Status)
+	}
+	var differ []string
+	for name := range mergeKeys(s.Header, d.Header) {
+		if !volatileHeaders[name] && strings.Join(s.Header[name], ",") != strings.Join(d.Header[name], ",") {
+			differ = append(differ, name)
+		}
+	}
+	if len(differ) > 0 {
+		sort.Strings(differ)
+		return "headers differ: " + strings.Join(differ, ", ")
+	}
+	if !bytes.Equal(sBody, dBody) {
+		return "response bodies differ"
+	}
+	return ""
+}
+
+func parseHTTP(data []byte) (*http.Response, []byte, error) {
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(data)), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp, body, nil
+}
+
+func mergeKeys(a, b http.Header) map[string]bool {
+	keys := make(map[string]bool, len(a)+len(b))
+	for k := range a {
+		keys[k] = true
+	}
+	for k := range b {
+		keys[k] = true
+	}
+	return keys
+}
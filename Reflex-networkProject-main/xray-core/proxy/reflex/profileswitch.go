@@ -0,0 +1,81 @@
+package reflex
+
+import (
+	"io"
+	"time"
+)
+
+// ProfileSwitch is one step of a granted profile schedule. The step fires
+// once, when the session has carried AfterBytes or lasted AfterSeconds
+// (whichever is set and reached first), or when a peer reports throttling
+// for a step with OnThrottle.
+type ProfileSwitch struct {
+	Profile      string `json:"profile"`
+	AfterBytes   uint64 `json:"afterBytes,omitempty"`
+	AfterSeconds uint32 `json:"afterSeconds,omitempty"`
+	OnThrottle   bool   `json:"onThrottle,omitempty"`
+}
+
+// ProfileScheduler tracks a session against the switches of its grant.
+// The side that sees a trigger fire sends a PROFILE_SWITCH frame naming the
+// new profile; both sides then shape traffic with it.
+type ProfileScheduler struct {
+	switches []ProfileSwitch
+	fired    []bool
+	start    time.Time
+	bytes    uint64
+}
+
+// NewProfileScheduler starts tracking switches from start.
+func NewProfileScheduler(switches []ProfileSwitch, start time.Time) *ProfileScheduler {
+	return &ProfileScheduler{
+		switches: switches,
+		fired:    make([]bool, len(switches)),
+		start:    start,
+	}
+}
+
+// Observe accounts for n transferred bytes and returns the profile to switch
+// to if a byte or time trigger fired.
+func (p *ProfileScheduler) Observe(n int, now time.Time) (string, bool) {
+	p.bytes += uint64(n)
+	elapsed := now.Sub(p.start)
+	for i, sw := range p.switches {
+		if p.fired[i] {
+			continue
+		}
+		if (sw.AfterBytes > 0 && p.bytes >= sw.AfterBytes) ||
+			(sw.AfterSeconds > 0 && elapsed >= time.Duration(sw.AfterSeconds)*time.Second) {
+			p.fired[i] = true
+			return sw.Profile, true
+		}
+	}
+	return "", false
+}
+
+// Throttled returns the profile to switch to after throttling was detected.
+func (p *ProfileScheduler) Throttled() (string, bool) {
+	for i, sw := range p.switches {
+		if !p.fired[i] && sw.OnThrottle {
+			p.fired[i] = true
+			return sw.Profile, true
+		}
+	}
+	return "", false
+}
+
+// Allows reports whether the schedule contains profile, i.e. whether a
+// PROFILE_SWITCH frame naming it may be honoured.
+func (p *ProfileScheduler) Allows(profile string) bool {
+	for _, sw := range p.switches {
+		if sw.Profile == profile {
+			return true
+		}
+	}
+	return false
+}
+
+// SwitchProfile tells the peer that traffic is now shaped with profile.
+func SwitchProfile(s *Session, w io.Writer, profile string) error {
+	return s.WriteFrame(w, FrameTypeProfileSwitch, []byte(profile))
+}
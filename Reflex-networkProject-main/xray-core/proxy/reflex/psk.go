@@ -0,0 +1,109 @@
+package reflex
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// PSKMagic ("RFXP") starts a PSK-only handshake. It skips X25519 and the
+// server response entirely: the session key comes from a per-user pre-shared
+// key, so constrained clients can send frames right behind the handshake.
+//
+// Layout (big endian):
+//
+//	magic(4) | user(16) | ts(8) | nonce(16) | padLen(2) | padding | mac(32)
+//
+// mac is HMAC-SHA256(psk, "reflex-psk-auth" || body), where body is
+// everything between the magic and the mac.
+const PSKMagic uint32 = 0x52465850
+
+// MinPSKSize is the shortest pre-shared key accepted.
+const MinPSKSize = 16
+
+const (
+	pskFixedSize = 16 + 8 + 16 + 2 // user + ts + nonce + padLen
+	pskMACSize   = sha256.Size
+)
+
+// PSKHandshake is the decoded content of a PSK-only handshake.
+type PSKHandshake struct {
+	UserID    [16]byte
+	Timestamp int64
+	Nonce     [16]byte
+	Padding   []byte
+}
+
+func (hs *PSKHandshake) body() []byte {
+	b := make([]byte, pskFixedSize, pskFixedSize+len(hs.Padding))
+	copy(b[0:16], hs.UserID[:])
+	binary.BigEndian.PutUint64(b[16:24], uint64(hs.Timestamp))
+	copy(b[24:40], hs.Nonce[:])
+	binary.BigEndian.PutUint16(b[40:42], uint16(len(hs.Padding)))
+	return append(b, hs.Padding...)
+}
+
+// MarshalPSKHandshake returns the complete packet, magic included.
+func MarshalPSKHandshake(hs *PSKHandshake, psk []byte) []byte {
+	body := hs.body()
+	packet := make([]byte, 4, 4+len(body)+pskMACSize)
+	binary.BigEndian.PutUint32(packet, PSKMagic)
+	packet = append(packet, body...)
+	return append(packet, pskMAC(psk, body)...)
+}
+
+// ReadPSKHandshake reads a PSK handshake whose magic has already been
+// consumed. The caller must look up the user's key and call VerifyPSKHandshake
+// with the returned body and mac before trusting anything in hs.
+func ReadPSKHandshake(r io.Reader) (hs *PSKHandshake, body, mac []byte, err error) {
+	body = make([]byte, pskFixedSize)
+	if _, err = io.ReadFull(r, body); err != nil {
+		return nil, nil, nil, err
+	}
+	padLen := int(binary.BigEndian.Uint16(body[40:42]))
+	if padLen > MaxHandshakePadding {
+		return nil, nil, nil, errors.New("reflex: handshake padding too long")
+	}
+	rest := make([]byte, padLen+pskMACSize)
+	if _, err = io.ReadFull(r, rest); err != nil {
+		return nil, nil, nil, err
+	}
+	body = append(body, rest[:padLen]...)
+	mac = rest[padLen:]
+
+	hs = &PSKHandshake{
+		Timestamp: int64(binary.BigEndian.Uint64(body[16:24])),
+		Padding:   body[pskFixedSize:],
+	}
+	copy(hs.UserID[:], body[0:16])
+	copy(hs.Nonce[:], body[24:40])
+	return hs, body, mac, nil
+}
+
+// VerifyPSKHandshake checks the handshake MAC in constant time.
+func VerifyPSKHandshake(psk, body, mac []byte) bool {
+	return len(psk) >= MinPSKSize && hmac.Equal(pskMAC(psk, body), mac)
+}
+
+// DerivePSKSessionKey derives the session key from the pre-shared key, salted
+// with the client nonce. The hash of the whole handshake body, timestamp
+// included, goes into the HKDF info.
+func DerivePSKSessionKey(psk, body []byte) []byte {
+	digest := sha256.Sum256(body)
+	info := append([]byte("reflex-psk-session"), digest[:]...)
+	h := hkdf.New(sha256.New, psk, body[24:40], info)
+	sessionKey := make([]byte, 32)
+	_, _ = io.ReadFull(h, sessionKey)
+	return sessionKey
+}
+
+func pskMAC(psk, body []byte) []byte {
+	m := hmac.New(sha256.New, psk)
+	m.Write([]byte("reflex-psk-auth"))
+	m.Write(body)
+	return m.Sum(nil)
+}
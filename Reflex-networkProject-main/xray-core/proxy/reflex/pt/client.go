@@ -0,0 +1,117 @@
+package pt
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net"
+	"net/url"
+
+	"github.com/google/uuid"
+
+	"github.com/xtls/xray-core/proxy/reflex"
+)
+
+// ClientOptions returns the options of a session to a bridge with the
+// given arguments: "id", the UUID of the user, which is required; "psk",
+// its pre-shared key in base64, if it has one; and "one-time=1" to send
+// one-time IDs, see reflex.OneTimeID.
+func ClientOptions(args Args) (*reflex.ClientOptions, error) {
+	id, ok := args.Get("id")
+	if !ok {
+		return nil, errors.New("reflex: bridge has no id argument")
+	}
+	userID, err := uuid.Parse(id)
+	if err != nil {
+		return nil, errors.New("reflex: bridge id is not a UUID")
+	}
+	opts := &reflex.ClientOptions{UserID: userID, Secret: userID[:]}
+	if psk, ok := args.Get("psk"); ok {
+		if opts.Secret, err = base64.StdEncoding.DecodeString(psk); err != nil {
+			return nil, errors.New("reflex: bridge psk is not base64")
+		}
+	}
+	if v, _ := args.Get("one-time"); v == "1" {
+		opts.OneTimeID = true
+	}
+	return opts, nil
+}
+
+// bridgeArgs recovers the arguments a PT client passes as SOCKS5
+// credentials: the username followed by the password, which is a single
+// NUL byte when everything fit in the username.
+func bridgeArgs(username, password string) string {
+	if password == "\x00" {
+		password = ""
+	}
+	return username + password
+}
+
+// StartClient starts the client transport: a SOCKS5 listener on loopback
+// that opens a session with the bridge every connection asks for, with
+// the arguments in its credentials, and tunnels the connection over it.
+// The listener is announced to the parent on out. Closing the returned
+// io.Closer stops it.
+func StartClient(env *Env, out *Output) (io.Closer, error) {
+	if !env.Wants() {
+		out.CMethodsDone()
+		return nil, errors.New("reflex: the parent does not ask for the reflex transport")
+	}
+	proxy := ""
+	if env.Proxy != "" {
+		u, err := url.Parse(env.Proxy)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "socks5") {
+			out.line("PROXY-ERROR unsupported proxy " + env.Proxy)
+			return nil, errors.New("reflex: unsupported proxy " + env.Proxy)
+		}
+		proxy = env.Proxy
+		out.line("PROXY DONE")
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		out.CMethodError(err.Error())
+		out.CMethodsDone()
+		return nil, err
+	}
+	server := &reflex.SOCKS5Server{
+		Dial: func(ctx context.Context, address string) (*reflex.ClientConn, error) {
+			return nil, errors.New("reflex: bridge has no arguments")
+		},
+		Credentials: func(username, password string) (reflex.TunnelDialer, error) {
+			args, err := ParseArgs(bridgeArgs(username, password))
+			if err != nil {
+				return nil, err
+			}
+			opts, err := ClientOptions(args)
+			if err != nil {
+				out.Log("warning", err.Error())
+				return nil, err
+			}
+			opts.UpstreamProxy = proxy
+			// The bridge is where the connection asks to go; what it
+			// carries goes on to the bridge's default destination.
+			return func(ctx context.Context, address string) (*reflex.ClientConn, error) {
+				return reflex.DialSession(ctx, address, opts)
+			}, nil
+		},
+	}
+	go func() { _ = server.Serve(ln) }()
+	out.CMethod(ln.Addr().String())
+	out.CMethodsDone()
+	return &listening{ln: ln, server: server}, nil
+}
+
+// listening is a started transport.
+type listening struct {
+	ln     net.Listener
+	server io.Closer
+}
+
+// Close stops accepting connections, also if the server did not get to
+// serve the listener yet, and waits for those accepted to end.
+func (l *listening) Close() error {
+	_ = l.ln.Close()
+	return l.server.Close()
+}
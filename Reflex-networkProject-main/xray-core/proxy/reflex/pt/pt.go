@@ -0,0 +1,266 @@
+// Package pt runs Reflex as a Pluggable Transport, with the managed-proxy
+// interface of the PT 2.x specification that Tor and other PT-aware
+// applications speak: the parent process configures the transport through
+// TOR_PT_* environment variables and reads its status from stdout. Clients
+// hand connections over SOCKS5, with the arguments of the bridge as
+// credentials, and servers forward their sessions to the parent's ORPort.
+package pt
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// MethodName is the name of the transport in bridge lines and in the
+// TOR_PT_*_TRANSPORTS lists.
+const MethodName = "reflex"
+
+// managedVersion is the only version of the managed-proxy interface.
+const managedVersion = "1"
+
+// Env is the configuration the parent process passes in environment
+// variables.
+type Env struct {
+	StateLocation    string // TOR_PT_STATE_LOCATION, where state may be kept across runs
+	ExitOnStdinClose bool   // TOR_PT_EXIT_ON_STDIN_CLOSE
+
+	// Client mode.
+	ClientTransports []string // TOR_PT_CLIENT_TRANSPORTS
+	Proxy            string   // TOR_PT_PROXY, the URL of the proxy to reach servers through
+
+	// Server mode.
+	ServerTransports []string          // TOR_PT_SERVER_TRANSPORTS
+	BindAddrs        map[string]string // TOR_PT_SERVER_BINDADDR, by transport
+	ORPort           string            // TOR_PT_ORPORT, where sessions are forwarded
+	Options          map[string]Args   // TOR_PT_SERVER_TRANSPORT_OPTIONS, by transport
+}
+
+// IsServer reports whether the parent runs the transport as a server.
+func (e *Env) IsServer() bool {
+	return len(e.ServerTransports) > 0
+}
+
+// Wants reports whether the parent asks for the Reflex transport, either
+// by name or with "*".
+func (e *Env) Wants() bool {
+	transports := e.ClientTransports
+	if e.IsServer() {
+		transports = e.ServerTransports
+	}
+	for _, t := range transports {
+		if t == MethodName || t == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// ReadEnv reads the configuration with getenv, such as os.Getenv. A
+// version mismatch or a malformed variable is reported to the parent on out
+// before the error is returned.
+func ReadEnv(getenv func(string) string, out *Output) (*Env, error) {
+	versions := strings.Split(getenv("TOR_PT_MANAGED_TRANSPORT_VER"), ",")
+	if !contains(versions, managedVersion) {
+		out.line("VERSION-ERROR no-version")
+		return nil, errors.New("reflex: no supported managed transport version")
+	}
+	out.line("VERSION " + managedVersion)
+
+	e := &Env{
+		StateLocation:    getenv("TOR_PT_STATE_LOCATION"),
+		ExitOnStdinClose: getenv("TOR_PT_EXIT_ON_STDIN_CLOSE") == "1",
+		Proxy:            getenv("TOR_PT_PROXY"),
+		ORPort:           getenv("TOR_PT_ORPORT"),
+	}
+	if s := getenv("TOR_PT_CLIENT_TRANSPORTS"); s != "" {
+		e.ClientTransports = strings.Split(s, ",")
+	}
+	if s := getenv("TOR_PT_SERVER_TRANSPORTS"); s != "" {
+		e.ServerTransports = strings.Split(s, ",")
+	}
+	fail := func(msg string) (*Env, error) {
+		out.line("ENV-ERROR " + msg)
+		return nil, errors.New("reflex: " + msg)
+	}
+	switch {
+	case e.IsServer() && len(e.ClientTransports) > 0:
+		return fail("both client and server transports are set")
+	case !e.IsServer() && len(e.ClientTransports) == 0:
+		return fail("neither client nor server transports are set")
+	case e.IsServer() && e.ORPort == "":
+		return fail("TOR_PT_ORPORT is not set")
+	}
+	if s := getenv("TOR_PT_SERVER_BINDADDR"); s != "" {
+		e.BindAddrs = make(map[string]string)
+		for _, bind := range strings.Split(s, ",") {
+			transport, addr, ok := strings.Cut(bind, "-")
+			if !ok {
+				return fail("malformed TOR_PT_SERVER_BINDADDR " + bind)
+			}
+			e.BindAddrs[transport] = addr
+		}
+	}
+	options, err := ParseServerOptions(getenv("TOR_PT_SERVER_TRANSPORT_OPTIONS"))
+	if err != nil {
+		return fail(err.Error())
+	}
+	e.Options = options
+	return e, nil
+}
+
+// Output writes the messages of the managed-proxy interface to the parent.
+// Lines are written whole, so an Output may be shared between goroutines.
+type Output struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewOutput returns an Output writing to w, which is the transport's
+// stdout.
+func NewOutput(w io.Writer) *Output {
+	return &Output{w: w}
+}
+
+func (o *Output) line(s string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	_, _ = io.WriteString(o.w, s+"\n")
+}
+
+// Log sends a message for the parent's log; severity is one of "error",
+// "warning", "notice", "info" and "debug".
+func (o *Output) Log(severity, message string) {
+	o.line("LOG SEVERITY=" + severity + " MESSAGE=" + strconv.Quote(message))
+}
+
+// CMethod announces the SOCKS5 address the client transport listens on.
+func (o *Output) CMethod(addr string) {
+	o.line(fmt.Sprintf("CMETHOD %s socks5 %s", MethodName, addr))
+}
+
+// CMethodError reports that the client transport could not start.
+func (o *Output) CMethodError(msg string) {
+	o.line(fmt.Sprintf("CMETHOD-ERROR %s %s", MethodName, msg))
+}
+
+// CMethodsDone ends the client methods.
+func (o *Output) CMethodsDone() {
+	o.line("CMETHODS DONE")
+}
+
+// SMethod announces the address the server transport listens on.
+func (o *Output) SMethod(addr string) {
+	o.line(fmt.Sprintf("SMETHOD %s %s", MethodName, addr))
+}
+
+// SMethodError reports that the server transport could not start.
+func (o *Output) SMethodError(msg string) {
+	o.line(fmt.Sprintf("SMETHOD-ERROR %s %s", MethodName, msg))
+}
+
+// SMethodsDone ends the server methods.
+func (o *Output) SMethodsDone() {
+	o.line("SMETHODS DONE")
+}
+
+// Args are the key=value arguments of a bridge line or of server options.
+// A key may be given more than once.
+type Args map[string][]string
+
+// Get returns the first value of key.
+func (a Args) Get(key string) (string, bool) {
+	v := a[key]
+	if len(v) == 0 {
+		return "", false
+	}
+	return v[0], true
+}
+
+// ParseArgs parses "key=value;key=value", where a backslash escapes the
+// character after it, as clients pass the arguments of a bridge line.
+func ParseArgs(s string) (Args, error) {
+	args := make(Args)
+	for _, pair := range splitEscaped(s, ';') {
+		if pair == "" {
+			continue
+		}
+		parts := splitEscaped(pair, '=')
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, errors.New("reflex: malformed argument " + pair)
+		}
+		key, value := unescape(parts[0]), unescape(parts[1])
+		args[key] = append(args[key], value)
+	}
+	return args, nil
+}
+
+// ParseServerOptions parses TOR_PT_SERVER_TRANSPORT_OPTIONS,
+// "transport:key=value;transport:key=value", into the options of each
+// transport.
+func ParseServerOptions(s string) (map[string]Args, error) {
+	options := make(map[string]Args)
+	for _, option := range splitEscaped(s, ';') {
+		if option == "" {
+			continue
+		}
+		transport, pair, ok := strings.Cut(option, ":")
+		if !ok || transport == "" {
+			return nil, errors.New("malformed server transport option " + option)
+		}
+		args, err := ParseArgs(pair)
+		if err != nil {
+			return nil, err
+		}
+		if options[transport] == nil {
+			options[transport] = make(Args)
+		}
+		for k, v := range args {
+			options[transport][k] = append(options[transport][k], v...)
+		}
+	}
+	return options, nil
+}
+
+// splitEscaped splits s at the sep that no backslash escapes, leaving the
+// escapes in the parts.
+func splitEscaped(s string, sep byte) []string {
+	var parts []string
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case sep:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+func unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
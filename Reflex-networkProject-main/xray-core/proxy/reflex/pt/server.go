@@ -0,0 +1,186 @@
+package pt
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"github.com/xtls/xray-core/common"
+	"github.com/xtls/xray-core/common/buf"
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/features/routing"
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/proxy/reflex/inbound"
+	"github.com/xtls/xray-core/transport"
+	"github.com/xtls/xray-core/transport/internet/stat"
+	"github.com/xtls/xray-core/transport/pipe"
+)
+
+// Files the server keeps in the state location.
+const (
+	idFile         = "reflex_id"             // the user ID made up when the options name none
+	bridgeLineFile = "reflex_bridgeline.txt" // the bridge line clients need
+)
+
+// ServerConfig returns the inbound configuration of a server with the
+// given options: "id", the UUID of a user, any number of times, and
+// "fallback", the port or host:port of the site unrecognized connections
+// are served from. Without an id, one is made up and kept in stateDir.
+func ServerConfig(options Args, stateDir string) (*reflex.InboundConfig, error) {
+	config := &reflex.InboundConfig{}
+	ids := options["id"]
+	if len(ids) == 0 {
+		id, err := loadOrCreateID(stateDir)
+		if err != nil {
+			return nil, err
+		}
+		ids = []string{id}
+	}
+	for _, id := range ids {
+		if _, err := uuid.Parse(id); err != nil {
+			return nil, errors.New("reflex: server id " + id + " is not a UUID")
+		}
+		config.Clients = append(config.Clients, &reflex.User{Id: id})
+	}
+	if dest, ok := options.Get("fallback"); ok {
+		host, port, err := reflex.ParseFallbackDest(dest)
+		if err != nil {
+			return nil, err
+		}
+		config.Fallback = &reflex.Fallback{Dest: port, Address: host}
+	}
+	return config, nil
+}
+
+func loadOrCreateID(stateDir string) (string, error) {
+	if stateDir == "" {
+		return "", errors.New("reflex: no id option and no state location to keep one in")
+	}
+	path := filepath.Join(stateDir, idFile)
+	if b, err := os.ReadFile(path); err == nil {
+		return strings.TrimSpace(string(b)), nil
+	}
+	if err := os.MkdirAll(stateDir, 0o700); err != nil {
+		return "", err
+	}
+	id := uuid.New().String()
+	return id, os.WriteFile(path, []byte(id+"\n"), 0o600)
+}
+
+// BridgeLine returns the line a client adds to its configuration to use
+// the server at addr as the user with the given ID.
+func BridgeLine(addr, id string) string {
+	return "Bridge " + MethodName + " " + addr + " id=" + id
+}
+
+// StartServer starts the server transport: it listens on the bind address
+// the parent gave for Reflex, or on a free port, and forwards the sessions
+// it serves to the parent's ORPort. The address is announced to the parent
+// on out, and the bridge line of the first user is written to the state
+// location. Closing the returned io.Closer stops it.
+func StartServer(ctx context.Context, env *Env, out *Output) (io.Closer, error) {
+	if !env.Wants() {
+		out.SMethodsDone()
+		return nil, errors.New("reflex: the parent does not ask for the reflex transport")
+	}
+	fail := func(err error) (io.Closer, error) {
+		out.SMethodError(err.Error())
+		out.SMethodsDone()
+		return nil, err
+	}
+	config, err := ServerConfig(env.Options[MethodName], env.StateLocation)
+	if err != nil {
+		return fail(err)
+	}
+	handler, err := inbound.New(ctx, config)
+	if err != nil {
+		return fail(err)
+	}
+	addr := env.BindAddrs[MethodName]
+	if addr == "" {
+		addr = "0.0.0.0:0"
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		_ = handler.(common.Closable).Close()
+		return fail(err)
+	}
+	if env.StateLocation != "" {
+		line := BridgeLine(ln.Addr().String(), config.Clients[0].Id)
+		if err := os.WriteFile(filepath.Join(env.StateLocation, bridgeLineFile), []byte(line+"\n"), 0o600); err != nil {
+			out.Log("warning", "cannot write the bridge line: "+err.Error())
+		}
+	}
+
+	dispatcher := &orDispatcher{orPort: env.ORPort}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				if err := handler.Process(ctx, xnet.Network_TCP, stat.Connection(conn), dispatcher); err != nil {
+					out.Log("info", "session ended: "+err.Error())
+				}
+			}()
+		}
+	}()
+	out.SMethod(ln.Addr().String())
+	out.SMethodsDone()
+	return &listening{ln: ln, server: handler.(io.Closer)}, nil
+}
+
+// orDispatcher forwards everything the server dispatches to the ORPort,
+// wherever it was addressed to.
+type orDispatcher struct {
+	orPort string
+}
+
+func (d *orDispatcher) Type() interface{} { return routing.DispatcherType() }
+func (d *orDispatcher) Start() error      { return nil }
+func (d *orDispatcher) Close() error      { return nil }
+
+// Dispatch connects to the ORPort and returns the link to it.
+func (d *orDispatcher) Dispatch(ctx context.Context, dest xnet.Destination) (*transport.Link, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", d.orPort)
+	if err != nil {
+		return nil, err
+	}
+	upReader, upWriter := pipe.New(pipe.WithoutSizeLimit())
+	downReader, downWriter := pipe.New(pipe.WithoutSizeLimit())
+	go func() {
+		_ = buf.Copy(upReader, buf.NewWriter(conn))
+		if tcp, ok := conn.(*net.TCPConn); ok {
+			_ = tcp.CloseWrite()
+		}
+	}()
+	go func() {
+		_ = buf.Copy(buf.NewReader(conn), downWriter)
+		_ = downWriter.Close()
+		_ = conn.Close()
+	}()
+	return &transport.Link{Reader: downReader, Writer: upWriter}, nil
+}
+
+// DispatchLink connects link to the ORPort.
+func (d *orDispatcher) DispatchLink(ctx context.Context, dest xnet.Destination, link *transport.Link) error {
+	l, err := d.Dispatch(ctx, dest)
+	if err != nil {
+		return err
+	}
+	go func() {
+		_ = buf.Copy(link.Reader, l.Writer)
+		common.Close(l.Writer)
+	}()
+	_ = buf.Copy(l.Reader, link.Writer)
+	common.Close(link.Writer)
+	return nil
+}
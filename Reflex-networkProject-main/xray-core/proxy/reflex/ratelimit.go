@@ -0,0 +1,51 @@
+package reflex
+
+import (
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket enforcing the bandwidth of a PolicyGrant.
+// The bucket holds one second worth of bytes.
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // bytes per second
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter returns a limiter for bytesPerSecond, or nil if the rate is
+// unlimited. A nil *RateLimiter never waits.
+func NewRateLimiter(bytesPerSecond uint64) *RateLimiter {
+	if bytesPerSecond == 0 {
+		return nil
+	}
+	return &RateLimiter{
+		rate:   float64(bytesPerSecond),
+		tokens: float64(bytesPerSecond),
+		last:   time.Now(),
+	}
+}
+
+// Wait blocks until n bytes may be sent.
+func (l *RateLimiter) Wait(n int) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	var wait time.Duration
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+}
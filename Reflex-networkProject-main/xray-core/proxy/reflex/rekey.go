@@ -0,0 +1,133 @@
+package reflex
+
+import (
+	"crypto/cipher"
+	"crypto/sha256"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// The key epoch is the top byte of a nonce counter:
+//
+//	direction (1) | salt (3) | epoch (1) | sequence (7)
+//
+// A session starts in epoch 0, where the layout is the plain counter of
+// makeNonce. Rekey moves one direction to the next epoch and its key; the
+// sequence goes on, so counters keep increasing across the switch.
+const (
+	epochShift = 56
+	seqMask    = 1<<epochShift - 1
+)
+
+// MaxEpoch is the last key epoch a session can rekey to.
+const MaxEpoch = 0xFF
+
+// epochKey is the key of one epoch and its AEAD.
+type epochKey struct {
+	epoch  uint8
+	key    []byte
+	aead   cipher.AEAD
+	cipher Cipher
+}
+
+// next derives the key of the following epoch. Keys only derive forward, so
+// the key of an epoch does not reveal those before it.
+func (k epochKey) next() (epochKey, error) {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, k.key, nil, []byte("reflex-rekey")), key); err != nil {
+		return epochKey{}, err
+	}
+	aead, err := newAEAD(k.cipher, key)
+	if err != nil {
+		return epochKey{}, err
+	}
+	return epochKey{epoch: k.epoch + 1, key: key, aead: aead, cipher: k.cipher}, nil
+}
+
+// forward returns the key of epoch, which must not be before k's.
+func (k epochKey) forward(epoch uint8) (epochKey, error) {
+	var err error
+	for k.epoch < epoch && err == nil {
+		k, err = k.next()
+	}
+	return k, err
+}
+
+// initEpochs sets the keys of the epochs the counters are in.
+func (s *Session) initEpochs() error {
+	base := epochKey{key: s.key, aead: s.aead, cipher: s.cipher}
+	var err error
+	if s.send, err = base.forward(uint8(s.writeNonceCount >> epochShift)); err != nil {
+		return err
+	}
+	s.recv, err = base.forward(uint8(s.readNonceCount >> epochShift))
+	return err
+}
+
+// Rekey moves the frames the session writes to the next key epoch, so that
+// no key seals more than a bounded amount of traffic. The peer follows by
+// itself: the epoch travels in every nonce, and the counters and the replay
+// check go on across the switch, so frames sealed before it that are still
+// in flight are read under the old key, and none can be replayed under the
+// new one. Each direction is rekeyed by its writer; a session has MaxEpoch
+// rekeys. An epoch moved to must carry a frame before the next rekey, so
+// that the reader never sees the epoch jump by more than one, see recvKey.
+func (s *Session) Rekey() error {
+	// No frame is between taking its counter and being sealed.
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.send.epoch == MaxEpoch {
+		return errors.New("reflex: no key epochs left")
+	}
+	if s.send.epoch > 0 && s.writeNonceCount == s.rekeyedAt {
+		return errors.New("reflex: no frame written since the last rekey")
+	}
+	next, err := s.send.next()
+	if err != nil {
+		return err
+	}
+	s.send = next
+	s.writeNonceCount = uint64(next.epoch)<<epochShift | s.writeNonceCount&seqMask
+	s.rekeyedAt = s.writeNonceCount
+	return nil
+}
+
+// Epochs returns the key epochs the session writes and reads in.
+func (s *Session) Epochs() (write, read uint8) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.send.epoch, s.recv.epoch
+}
+
+// recvKey returns the key of the epoch of a frame with counter. It is only
+// kept by ReadFrame once the frame is accepted. Frames of an epoch before
+// the current one are refused; they would not pass the replay check, except
+// in OrderingTolerant, where late frames of the epoch just left still may.
+// So are frames of an epoch the peer cannot have reached yet: more than one
+// past the current one, or two in OrderingTolerant, where all frames of an
+// epoch may be overtaken by one of the next. Those are not the peer's, and
+// would only have the reader derive keys for nothing.
+func (s *Session) recvKey(counter uint64) (epochKey, error) {
+	epoch := uint8(counter >> epochShift)
+	s.mu.Lock()
+	recv, prev, tolerant := s.recv, s.prevRecv, s.window != nil
+	s.mu.Unlock()
+	if tolerant && prev.aead != nil && epoch == prev.epoch {
+		return prev, nil
+	}
+	if epoch < recv.epoch {
+		return epochKey{}, errors.New("reflex: frame of a past key epoch")
+	}
+	ahead := uint8(1)
+	if tolerant {
+		ahead = 2
+	}
+	if epoch-recv.epoch > ahead {
+		return epochKey{}, errors.New("reflex: frame of an unexpected key epoch")
+	}
+	return recv.forward(epoch)
+}
@@ -0,0 +1,98 @@
+package reflex
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// MaxReplayEntries is how many nonces a server's ReplayCache remembers at
+// most. Past it the oldest is forgotten, which at worst lets that one
+// handshake be replayed while its timestamp still passes.
+const MaxReplayEntries = 1 << 20
+
+// ReplayCache remembers handshake nonces until they can no longer pass the
+// timestamp check, so a captured handshake cannot be replayed within the
+// ±MaxClockSkew window.
+type ReplayCache struct {
+	mu    sync.Mutex
+	ttl   time.Duration
+	max   int
+	seen  map[[16]byte]time.Time // nonce -> expiry
+	order []replayEntry          // oldest first
+}
+
+type replayEntry struct {
+	nonce  [16]byte
+	expiry time.Time
+}
+
+// NewReplayCache returns a cache that keeps each nonce for ttl, and no more
+// than max nonces at a time.
+func NewReplayCache(ttl time.Duration, max int) *ReplayCache {
+	return &ReplayCache{
+		ttl:  ttl,
+		max:  max,
+		seen: make(map[[16]byte]time.Time),
+	}
+}
+
+// Check records nonce and reports whether it was fresh. A nonce seen within
+// the last ttl is reported as a replay.
+func (c *ReplayCache) Check(nonce [16]byte, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for len(c.order) > 0 && now.After(c.order[0].expiry) {
+		c.evictOldest()
+	}
+
+	if expiry, found := c.seen[nonce]; found && !now.After(expiry) {
+		return false
+	}
+	c.seen[nonce] = now.Add(c.ttl)
+	c.order = append(c.order, replayEntry{nonce, now.Add(c.ttl)})
+	for len(c.seen) > c.max {
+		c.evictOldest()
+	}
+	return true
+}
+
+// evictOldest forgets the oldest entry, unless its nonce was recorded again
+// since.
+func (c *ReplayCache) evictOldest() {
+	e := c.order[0]
+	c.order = c.order[1:]
+	if c.seen[e.nonce].Equal(e.expiry) {
+		delete(c.seen, e.nonce)
+	}
+}
+
+// snapshot returns the nonces that are still remembered at now.
+func (c *ReplayCache) snapshot(now time.Time) map[[16]byte]time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	seen := make(map[[16]byte]time.Time, len(c.seen))
+	for nonce, expiry := range c.seen {
+		if !now.After(expiry) {
+			seen[nonce] = expiry
+		}
+	}
+	return seen
+}
+
+// restore adds the unexpired nonces of seen to the cache.
+func (c *ReplayCache) restore(seen map[[16]byte]time.Time, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for nonce, expiry := range seen {
+		if !now.After(expiry) && expiry.After(c.seen[nonce]) {
+			c.seen[nonce] = expiry
+			c.order = append(c.order, replayEntry{nonce, expiry})
+		}
+	}
+	sort.SliceStable(c.order, func(i, j int) bool { return c.order[i].expiry.Before(c.order[j].expiry) })
+	for len(c.seen) > c.max {
+		c.evictOldest()
+	}
+}
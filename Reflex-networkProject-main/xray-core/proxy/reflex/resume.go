@@ -0,0 +1,210 @@
+package reflex
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// ResumeMagic ("RFXR") starts a resumption: the client re-attaches an
+// established session to a new connection, e.g. after its address changed,
+// instead of making a new handshake. There is no server response; the
+// session's frames follow the request in both directions, their counters
+// going on from where the old connection left them.
+//
+// Layout (big endian):
+//
+//	magic(4) | session id(16) | ts(8) | nonce(16) | mac(32)
+//
+// The session id and the resumption key are derived from the session key,
+// see Session.Resumption; mac is HMAC-SHA256(resumption key, "reflex-resume"
+// || body), where body is everything between the magic and the mac, and the
+// key is that of the request's timestamp if the server rotates them, see
+// Session.ResumptionKey. The id
+// stays the same for the lifetime of the session, so an observer of both
+// paths can link them.
+const ResumeMagic uint32 = 0x52465852
+
+const (
+	resumeBodySize = 16 + 8 + 16 // session id + ts + nonce
+	resumeMACSize  = sha256.Size
+)
+
+// ResumeRequest is the decoded content of a resumption request.
+type ResumeRequest struct {
+	SessionID [16]byte
+	Timestamp int64
+	Nonce     [16]byte
+}
+
+func (r *ResumeRequest) body() []byte {
+	b := make([]byte, resumeBodySize)
+	copy(b[0:16], r.SessionID[:])
+	binary.BigEndian.PutUint64(b[16:24], uint64(r.Timestamp))
+	copy(b[24:40], r.Nonce[:])
+	return b
+}
+
+// Resumption returns the id the session is resumed by and the key that
+// authenticates the resumption. Both peers derive the same values.
+func (s *Session) Resumption() (id [16]byte, key []byte) {
+	out := make([]byte, 16+32)
+	_, _ = io.ReadFull(hkdf.New(sha256.New, s.key, nil, []byte("reflex-resume")), out)
+	copy(id[:], out[:16])
+	return id, out[16:]
+}
+
+// ResumptionKey returns the key that authenticates a request stamped ts to
+// attach a connection to the session when the server rotates resumption
+// keys every rotation, see PolicyGrant.ResumeRotation. The key of
+// Resumption is then only used to derive one key per period, so a key that
+// leaks is refused once its period is over and the clock skew allowed for
+// timestamps has passed. A zero rotation returns the key of Resumption.
+func (s *Session) ResumptionKey(rotation time.Duration, ts int64) []byte {
+	_, key := s.Resumption()
+	if rotation < time.Second {
+		return key
+	}
+	info := make([]byte, len("reflex-resume-epoch")+8)
+	copy(info, "reflex-resume-epoch")
+	binary.BigEndian.PutUint64(info[len("reflex-resume-epoch"):], uint64(ts/int64(rotation/time.Second)))
+	out := make([]byte, 32)
+	_, _ = io.ReadFull(hkdf.New(sha256.New, key, nil, info), out)
+	return out
+}
+
+// MarshalResume returns the complete resumption request for a session with
+// the given resumption key, magic included.
+func MarshalResume(r *ResumeRequest, key []byte) []byte {
+	return marshalAttach(ResumeMagic, resumeLabel, r, key)
+}
+
+func marshalAttach(magic uint32, label string, r *ResumeRequest, key []byte) []byte {
+	body := r.body()
+	packet := make([]byte, 4, 4+len(body)+resumeMACSize)
+	binary.BigEndian.PutUint32(packet, magic)
+	packet = append(packet, body...)
+	return append(packet, attachMAC(key, label, body)...)
+}
+
+// ReadResume reads a resumption or bonding request whose magic has already
+// been consumed. The caller must look up the session by id and call
+// VerifyResume or VerifyBond with its key and the returned body and mac
+// before trusting the rest.
+func ReadResume(r io.Reader) (req *ResumeRequest, body, mac []byte, err error) {
+	packet := make([]byte, resumeBodySize+resumeMACSize)
+	if _, err = io.ReadFull(r, packet); err != nil {
+		return nil, nil, nil, err
+	}
+	body, mac = packet[:resumeBodySize], packet[resumeBodySize:]
+	req = &ResumeRequest{Timestamp: int64(binary.BigEndian.Uint64(body[16:24]))}
+	copy(req.SessionID[:], body[0:16])
+	copy(req.Nonce[:], body[24:40])
+	return req, body, mac, nil
+}
+
+// VerifyResume checks the request MAC in constant time.
+func VerifyResume(key, body, mac []byte) bool {
+	return len(key) == 32 && hmac.Equal(attachMAC(key, resumeLabel, body), mac)
+}
+
+// Labels of the requests that attach a connection to a session.
+const (
+	resumeLabel = "reflex-resume"
+	bondLabel   = "reflex-bond"
+)
+
+func attachMAC(key []byte, label string, body []byte) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(label))
+	m.Write(body)
+	return m.Sum(nil)
+}
+
+// transport returns the connection the session currently runs on and its
+// reader.
+func (c *ClientConn) transport() (io.ReadWriter, *bufio.Reader) {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	return c.conn, c.reader
+}
+
+// Resume re-attaches the session to conn, a new connection to the server
+// it was established with, and closes the old one. Frames in flight on the
+// old connection are lost. A server that no longer has the session answers
+// like to any unknown client, and the next ReadFrame fails.
+func (c *ClientConn) Resume(conn io.ReadWriter) error {
+	if c.ended.Load() {
+		return errors.New("reflex: session has ended")
+	}
+	conn, err := c.attach(conn, ResumeMagic, resumeLabel)
+	if err != nil {
+		return err
+	}
+	c.connMu.Lock()
+	old := c.conn
+	c.conn, c.reader = conn, bufio.NewReader(conn)
+	c.connMu.Unlock()
+	c.unconfirmed.Store(true)
+	if closer, ok := old.(io.Closer); ok {
+		_ = closer.Close()
+	}
+	return nil
+}
+
+// migrate resumes the session on a new connection after failed, the
+// connection it ran on, failed. It reports whether the session now runs on
+// another connection and the failed operation should be tried again.
+func (c *ClientConn) migrate(failed io.ReadWriter) bool {
+	c.resumeMu.Lock()
+	defer c.resumeMu.Unlock()
+	if conn, _ := c.transport(); conn != failed {
+		// Another goroutine resumed it already.
+		return true
+	}
+	// A resumption that was not followed by a single frame failed; the
+	// server does not know the session any more.
+	if c.redial == nil || c.ended.Load() || c.unconfirmed.Load() {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultFrontEndTimeout)
+	defer cancel()
+	conn, err := c.redial(ctx)
+	if err != nil {
+		return false
+	}
+	if err := c.Resume(conn); err != nil {
+		_ = conn.Close()
+		return false
+	}
+	return true
+}
+
+// attach writes the request that attaches conn to the session, and returns
+// conn as the session uses it, with the session's strategy.
+func (c *ClientConn) attach(conn io.ReadWriter, magic uint32, label string) (io.ReadWriter, error) {
+	if c.strategy != nil {
+		if nc, ok := conn.(net.Conn); ok {
+			conn = NewStrategyConn(nc, c.strategy)
+		}
+	}
+	id, _ := c.Session.Resumption()
+	req := &ResumeRequest{SessionID: id, Timestamp: time.Now().Unix()}
+	if _, err := rand.Read(req.Nonce[:]); err != nil {
+		return nil, err
+	}
+	key := c.Session.ResumptionKey(time.Duration(c.resumeRotation.Load())*time.Second, req.Timestamp)
+	if _, err := conn.Write(marshalAttach(magic, label, req, key)); err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
@@ -0,0 +1,36 @@
+package reflex
+
+import (
+	"context"
+	"strings"
+
+	"github.com/xtls/xray-core/common/session"
+)
+
+// Content attributes set from a session's PolicyGrant. Routing rules match
+// them with "attrs", e.g. {"attrs": {"reflex-tier": "^premium$"}}.
+const (
+	AttributeTier = "reflex-tier"
+	AttributeTags = "reflex-tags" // comma separated
+)
+
+// ContextWithGrant returns ctx with the grant's tier and tags attached to the
+// session content, where xray routing reads attributes from. Existing content
+// is copied, not modified.
+func ContextWithGrant(ctx context.Context, grant *PolicyGrant) context.Context {
+	content := &session.Content{}
+	if c := session.ContentFromContext(ctx); c != nil {
+		*content = *c
+		content.Attributes = nil
+		for k, v := range c.Attributes {
+			content.SetAttribute(k, v)
+		}
+	}
+	if grant.Tier != "" {
+		content.SetAttribute(AttributeTier, grant.Tier)
+	}
+	if len(grant.Tags) > 0 {
+		content.SetAttribute(AttributeTags, strings.Join(grant.Tags, ","))
+	}
+	return session.ContextWithContent(ctx, content)
+}
@@ -0,0 +1,121 @@
+package reflex
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"io"
+
+	"filippo.io/edwards25519"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+
+	"github.com/xtls/xray-core/proxy/reflex/handshake"
+)
+
+// SealedMagic ("RFXS") starts a sealed handshake, see SealHandshake.
+const SealedMagic = handshake.SealedMagic
+
+// A plain handshake carries the user's UUID in the clear, so whoever sees
+// it can tell the users of a server apart, or probe for valid ones. A
+// client that knows the server's identity key, see ClientOptions.ServerKey,
+// seals the user ID, timestamp and policy request of its handshake to the
+// server instead, with
+//
+//	key = HKDF-SHA256(X25519(ephemeral, server), nonce, "reflex-sealed-handshake" || pub || server)
+//
+// where ephemeral and pub are the client's ephemeral key pair, the same as
+// the handshake's, and server the X25519 form of the server's identity key,
+// so the server opens it without knowing who sent it. The sealed part is
+// ChaCha20-Poly1305 with a zero nonce, as the key is never used twice, and
+// the handshake's public key and nonce as additional data. What stays in
+// the clear is random to an observer.
+//
+// Sealing hides the user ID; it adds no secret of the user's to it. The ID
+// stays the credential, and anyone with the server's public key can seal a
+// handshake, as anyone can send a plain one. A server that requires sealed
+// handshakes, see InboundConfig.RequireSealed, refuses the plain ones, so
+// that no client of it ever sends its ID in the clear.
+
+// sealContext separates handshake sealing keys from other keys.
+const sealContext = "reflex-sealed-handshake"
+
+// dhPrivateKey returns the X25519 form of a server's identity key: the
+// scalar Ed25519 signs with.
+func dhPrivateKey(key ed25519.PrivateKey) []byte {
+	h := sha512.Sum512(key.Seed())
+	return h[:curve25519.ScalarSize]
+}
+
+// dhPublicKey returns the X25519 form of a server's public identity key,
+// the Montgomery u = (1 + y) / (1 - y) of its Edwards point, computed in
+// constant time.
+func dhPublicKey(pub ed25519.PublicKey) ([]byte, error) {
+	p, err := new(edwards25519.Point).SetBytes(pub)
+	if err != nil {
+		return nil, errors.New("reflex: invalid server public key")
+	}
+	return p.BytesMontgomery(), nil
+}
+
+// sealKey derives the key the secret part of a handshake is sealed with
+// from the X25519 secret the client and the server share.
+func sealKey(shared, pub, server []byte, nonce [16]byte) []byte {
+	info := append(append([]byte(sealContext), pub...), server...)
+	key := make([]byte, chacha20poly1305.KeySize)
+	_, _ = io.ReadFull(hkdf.New(sha256.New, shared, nonce[:], info), key)
+	return key
+}
+
+// sealAEAD seals or opens the secret part of a handshake with key.
+func sealAEAD(key []byte, open bool, s *handshake.Sealed, secret []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	ad := append(append([]byte(nil), s.PublicKey[:]...), s.Nonce[:]...)
+	if open {
+		return aead.Open(nil, nonce, secret, ad)
+	}
+	return aead.Seal(nil, nonce, secret, ad), nil
+}
+
+// SealHandshake returns hello sealed to the server with public identity key
+// server. priv is the private key of hello's ephemeral key.
+func SealHandshake(hello *handshake.Client, priv [32]byte, server ed25519.PublicKey) (*handshake.Sealed, error) {
+	serverDH, err := dhPublicKey(server)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := curve25519.X25519(priv[:], serverDH)
+	if err != nil {
+		return nil, err
+	}
+	s := &handshake.Sealed{PublicKey: hello.PublicKey, Nonce: hello.Nonce, Padding: hello.Padding}
+	s.Sealed, err = sealAEAD(sealKey(shared, hello.PublicKey[:], serverDH, hello.Nonce), false, s, hello.Secret())
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// OpenHandshake returns the handshake s sealed to the server with identity
+// key key.
+func OpenHandshake(s *handshake.Sealed, key ed25519.PrivateKey) (*handshake.Client, error) {
+	serverDH, err := dhPublicKey(key.Public().(ed25519.PublicKey))
+	if err != nil {
+		return nil, err
+	}
+	shared, err := curve25519.X25519(dhPrivateKey(key), s.PublicKey[:])
+	if err != nil {
+		return nil, err
+	}
+	secret, err := sealAEAD(sealKey(shared, s.PublicKey[:], serverDH, s.Nonce), true, s, s.Sealed)
+	if err != nil {
+		return nil, errors.New("reflex: handshake not sealed to this server")
+	}
+	return s.Opened(secret)
+}
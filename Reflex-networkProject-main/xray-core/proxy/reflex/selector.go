@@ -0,0 +1,196 @@
+package reflex
+
+import (
+	"context"
+	"math"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Defaults for ServerSelector.
+const (
+	DefaultProbeInterval   = time.Minute
+	DefaultProbeSamples    = 3
+	DefaultProbeTimeout    = 5 * time.Second
+	DefaultSelectionMargin = 0.2
+)
+
+// ServerProbe is what probing one server found.
+type ServerProbe struct {
+	Server string
+	// RTT is the median time the completed probes took to connect and
+	// finish the handshake.
+	RTT time.Duration
+	// Loss is the fraction of probes that failed during the handshake or
+	// in the first exchange after it, where blocking tends to strike.
+	Loss float64
+}
+
+// Score orders probes, lower being better: the RTT inflated by the loss.
+// A server no probe got through to scores +Inf.
+func (p *ServerProbe) Score() float64 {
+	if p.Loss >= 1 {
+		return math.Inf(1)
+	}
+	return float64(p.RTT) / (1 - p.Loss)
+}
+
+// ProbeServer probes server samples times. Every probe is a session like the
+// client's own, made with opts, so that probes look like real traffic and
+// meet the same interference: a handshake followed by a policy renewal,
+// each within timeout.
+func ProbeServer(ctx context.Context, server string, opts *ClientOptions, samples int, timeout time.Duration) *ServerProbe {
+	probe := &ServerProbe{Server: server}
+	var rtts []time.Duration
+	lost := 0
+	for i := 0; i < samples; i++ {
+		rtt, err := probeOnce(ctx, server, opts, timeout)
+		if err != nil {
+			lost++
+			continue
+		}
+		rtts = append(rtts, rtt)
+	}
+	if samples > 0 {
+		probe.Loss = float64(lost) / float64(samples)
+	}
+	if len(rtts) > 0 {
+		sort.Slice(rtts, func(i, j int) bool { return rtts[i] < rtts[j] })
+		probe.RTT = rtts[len(rtts)/2]
+	}
+	return probe
+}
+
+func probeOnce(ctx context.Context, server string, opts *ClientOptions, timeout time.Duration) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	start := time.Now()
+	c, err := dialServer(ctx, server, opts)
+	if err != nil {
+		return 0, err
+	}
+	rtt := time.Since(start)
+	defer c.Close()
+	conn, reader := c.transport()
+	if nc, ok := conn.(net.Conn); ok {
+		deadline, _ := ctx.Deadline()
+		_ = nc.SetDeadline(deadline)
+	}
+	req := &PolicyReq{Profile: c.Grant.Profile}
+	if opts.Policy != nil {
+		req = opts.Policy
+	}
+	if err := c.RenewPolicy(req); err != nil {
+		return 0, err
+	}
+	for {
+		f, err := c.Session.ReadFrame(reader)
+		if err != nil {
+			return 0, err
+		}
+		if f.Type == FrameTypePolicyGrant {
+			return rtt, nil
+		}
+	}
+}
+
+// ServerSelector probes the servers of a FailoverDialer in the background
+// and makes the best one active. Another server must be better than the
+// active one by Margin to replace it, so that close scores do not make the
+// client flap between servers; an active server no probe got through to is
+// replaced by the best one.
+type ServerSelector struct {
+	Dialer *FailoverDialer
+	// Interval is the time between probe rounds. Zero means
+	// DefaultProbeInterval.
+	Interval time.Duration
+	// Samples is the number of probes per server and round. Zero means
+	// DefaultProbeSamples.
+	Samples int
+	// Timeout bounds every probe. Zero means DefaultProbeTimeout.
+	Timeout time.Duration
+	// Margin is how much lower, as a fraction of the active server's score,
+	// another server's score must be. Zero means DefaultSelectionMargin; 1
+	// keeps the active server for as long as it is reachable.
+	Margin float64
+	// OnProbe, if set, is given the results of every round.
+	OnProbe func([]*ServerProbe)
+}
+
+// Run probes every Interval until ctx is done.
+func (s *ServerSelector) Run(ctx context.Context) {
+	interval := s.Interval
+	if interval == 0 {
+		interval = DefaultProbeInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		s.Probe(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Probe runs one round of probes against all servers, in parallel, and
+// prefers the best server if it is better enough. It returns the results.
+func (s *ServerSelector) Probe(ctx context.Context) []*ServerProbe {
+	samples := s.Samples
+	if samples == 0 {
+		samples = DefaultProbeSamples
+	}
+	timeout := s.Timeout
+	if timeout == 0 {
+		timeout = DefaultProbeTimeout
+	}
+	// Probes are not the application's sessions: their interference must not
+	// make the dialer fail over, and they are not transcribed.
+	opts := *s.Dialer.base
+	opts.OnInterference = nil
+	opts.Transcript = nil
+
+	probes := make([]*ServerProbe, len(s.Dialer.servers))
+	var wg sync.WaitGroup
+	for n, server := range s.Dialer.servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			probes[n] = ProbeServer(ctx, server, &opts, samples, timeout)
+		}()
+	}
+	wg.Wait()
+	if ctx.Err() == nil {
+		s.prefer(probes)
+	}
+	if s.OnProbe != nil {
+		s.OnProbe(probes)
+	}
+	return probes
+}
+
+func (s *ServerSelector) prefer(probes []*ServerProbe) {
+	margin := s.Margin
+	if margin == 0 {
+		margin = DefaultSelectionMargin
+	}
+	var best, active *ServerProbe
+	for _, p := range probes {
+		if best == nil || p.Score() < best.Score() {
+			best = p
+		}
+		if p.Server == s.Dialer.Active() {
+			active = p
+		}
+	}
+	if best == nil || active == nil || best == active || math.IsInf(best.Score(), 1) {
+		return
+	}
+	if math.IsInf(active.Score(), 1) || best.Score() < active.Score()*(1-margin) {
+		s.Dialer.Prefer(best.Server)
+	}
+}
@@ -0,0 +1,72 @@
+package reflex
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+)
+
+// The X25519 exchange of a handshake is anonymous: on its own, whoever sits
+// between the client and the server can complete it with both. A server
+// with a static identity key, an Ed25519 key pair, signs every handshake
+// it answers, and a client that pins the public key, see
+// ClientOptions.ServerKey, only accepts answers signed with it.
+//
+// The signature, HandshakeResponse.ServerSignature, is over
+//
+//	"reflex-server-auth" || transcript
+//
+// where the transcript, see Transcript, covers the client's ephemeral key
+// and nonce as well as the server's ephemeral key, so it cannot be moved to
+// another handshake. The public key itself is never sent: clients are given
+// it along with the server's address, and a response that named it would
+// tell every server of an operator apart on the wire.
+
+// serverAuthContext separates server signatures from other uses of the key.
+const serverAuthContext = "reflex-server-auth"
+
+// GenerateServerKey returns a new server identity key pair, each base64
+// encoded as ParseServerKey and ParseServerPublicKey take them.
+func GenerateServerKey() (private, public string, err error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	return base64.StdEncoding.EncodeToString(priv.Seed()), base64.StdEncoding.EncodeToString(pub), nil
+}
+
+// ParseServerKey decodes a server's private identity key: its 32-byte
+// Ed25519 seed, base64 encoded.
+func ParseServerKey(s string) (ed25519.PrivateKey, error) {
+	seed, err := base64.StdEncoding.DecodeString(s)
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, errors.New("reflex: server key must be a base64 Ed25519 seed of 32 bytes")
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// ParseServerPublicKey decodes a server's public identity key, base64
+// encoded.
+func ParseServerPublicKey(s string) (ed25519.PublicKey, error) {
+	pub, err := base64.StdEncoding.DecodeString(s)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return nil, errors.New("reflex: server public key must be a base64 Ed25519 key of 32 bytes")
+	}
+	return ed25519.PublicKey(pub), nil
+}
+
+// SignHandshake returns the server's signature over the handshake
+// transcript.
+func SignHandshake(key ed25519.PrivateKey, transcript []byte) []byte {
+	return ed25519.Sign(key, append([]byte(serverAuthContext), transcript...))
+}
+
+// VerifyHandshakeSignature reports whether signature is the signature of
+// the server with public key pub over the handshake transcript.
+func VerifyHandshakeSignature(pub ed25519.PublicKey, transcript, signature []byte) bool {
+	if len(signature) != ed25519.SignatureSize {
+		return false
+	}
+	return ed25519.Verify(pub, append([]byte(serverAuthContext), transcript...), signature)
+}
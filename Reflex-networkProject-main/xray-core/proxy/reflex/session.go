@@ -2,133 +2,416 @@ package reflex
 
 import (
 	"crypto/cipher"
+	"crypto/rand"
 	"encoding/binary"
 	"errors"
 	"io"
 	"sync"
+	"time"
 
-	"golang.org/x/crypto/chacha20poly1305"
+	"github.com/xtls/xray-core/proxy/reflex/frame"
 )
 
 // Frame type constants for Reflex protocol.
 const (
-	FrameTypeData        uint8 = 0x00
-	FrameTypePaddingCtrl uint8 = 0x01
-	FrameTypeTimingCtrl  uint8 = 0x02
+	FrameTypeData              = frame.TypeData
+	FrameTypePaddingCtrl       = frame.TypePaddingCtrl
+	FrameTypeTimingCtrl        = frame.TypeTimingCtrl
+	FrameTypeChallenge         = frame.TypeChallenge
+	FrameTypeChallengeResponse = frame.TypeChallengeResponse
+	FrameTypePolicyRequest     = frame.TypePolicyRequest
+	FrameTypePolicyGrant       = frame.TypePolicyGrant
+	FrameTypeProfileSwitch     = frame.TypeProfileSwitch
+	FrameTypeClose             = frame.TypeClose
+	FrameTypeProfileUpdate     = frame.TypeProfileUpdate
+	FrameTypeProfileOffer      = frame.TypeProfileOffer
+	FrameTypeUsage             = frame.TypeUsage
+	FrameTypeAddr              = frame.TypeAddr
+	FrameTypeStreamOpen        = frame.TypeStreamOpen
+	FrameTypeStreamData        = frame.TypeStreamData
+	FrameTypeStreamClose       = frame.TypeStreamClose
+	FrameTypeDatagram          = frame.TypeDatagram
+	FrameTypePaddedData        = frame.TypePaddedData
 )
 
-// Session provides encrypted frame read/write with ChaCha20-Poly1305 and replay protection.
+// Direction values occupy the first nonce byte. Client and server share one
+// session key, so without them both sides would seal their first frame under
+// the same key and nonce.
+const (
+	DirectionNone   uint8 = 0x00
+	DirectionClient uint8 = 0x43 // 'C', client to server
+	DirectionServer uint8 = 0x53 // 'S', server to client
+)
+
+// Session provides encrypted frame read/write with ChaCha20-Poly1305, or
+// another Cipher, and replay protection.
 type Session struct {
-	aead cipher.AEAD
+	aead      cipher.AEAD // for key epoch 0, see Rekey
+	cipher    Cipher      // what aead is, see SetCipher
+	key       []byte      // retained for deriving per-stream subkeys
+	direction uint8
+	prefix    [4]byte // direction (1) + random salt (3), see makeNonce
 
+	wmu             sync.Mutex // serializes WriteFrame so records leave in nonce order
 	mu              sync.Mutex
 	writeNonceCount uint64
 	readNonceCount  uint64 // last accepted read counter for replay check
 	readSeen        bool   // true after first frame accepted
+	peerPrefix      [4]byte
+	hooks           SessionHooks
+	framesRead      uint64
+	policyVersion   uint8
+	tlsRecords      bool          // frames travel as TLS application data records
+	keyCommitment   bool          // records commit to the key, see SetKeyCommitment
+	lengthKey       []byte        // masks record lengths, see SetMaskedLengths
+	pacing          *pacer        // nil when morphing sleeps, see SetPacing
+	morphing        MorphingMode  // how much of a profile shapes DATA frames, see SetMorphing
+	destination     *Destination  // where DATA frames go, see SetDestination
+	writeTimeout    time.Duration // bounds each frame write, see SetWriteTimeout
+	send            epochKey      // key of the epoch frames are written in
+	rekeyedAt       uint64        // writeNonceCount when send was last rekeyed
+	recv            epochKey      // key of the epoch of the last frame read
+	prevRecv        epochKey      // key of the epoch before recv, for late frames
+	ordering        OrderingMode
+	window          replayWindow // counters read behind the latest, when tolerant
+	held            []heldFrame  // read ahead of nextSeq, by sequence, when tolerant
+	nextSeq         uint64       // sequence of the frame ReadFrame returns next, when tolerant
+	gapSince        time.Time    // when the wait for nextSeq began
+	heldErr         error        // the read failed with frames held, see holdError
+	leakage         *LeakageAudit
+	path            pathClock // stamps of control frames, see SetTimestamps
+	carried         Overhead  // what the frames written and read carried
+	taken           Overhead  // carried as of the last TakeOverhead
 }
 
 // NewSession creates a new Reflex session with the given 32-byte session key.
+// The session does not mark its nonces with a direction; peers should prefer
+// NewClientSession and NewServerSession.
 func NewSession(sessionKey []byte) (*Session, error) {
+	return newSession(sessionKey, DirectionNone)
+}
+
+// NewClientSession creates a session for the client end of a connection.
+func NewClientSession(sessionKey []byte) (*Session, error) {
+	return newSession(sessionKey, DirectionClient)
+}
+
+// NewServerSession creates a session for the server end of a connection.
+func NewServerSession(sessionKey []byte) (*Session, error) {
+	return newSession(sessionKey, DirectionServer)
+}
+
+func newSession(sessionKey []byte, direction uint8) (*Session, error) {
 	if len(sessionKey) != 32 {
 		return nil, errors.New("reflex: session key must be 32 bytes")
 	}
-	aead, err := chacha20poly1305.New(sessionKey)
+	aead, err := newAEAD(CipherChaCha20Poly1305, sessionKey)
 	if err != nil {
 		return nil, err
 	}
-	return &Session{aead: aead}, nil
+	key := make([]byte, len(sessionKey))
+	copy(key, sessionKey)
+	s := &Session{aead: aead, key: key}
+	if err := s.initNoncePrefix(direction); err != nil {
+		return nil, err
+	}
+	if err := s.initEpochs(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// initNoncePrefix sets the direction byte and draws a fresh 3-byte salt.
+func (s *Session) initNoncePrefix(direction uint8) error {
+	s.direction = direction
+	s.prefix[0] = direction
+	_, err := rand.Read(s.prefix[1:])
+	return err
 }
 
-// makeNonce writes 12-byte nonce: 4 zero bytes + 8-byte big-endian counter.
-func makeNonce(nonceOut []byte, counter uint64) {
+// makeNonce writes the 12-byte nonce:
+//
+//	direction (1) | salt (3) | counter (8, big endian)
+//
+// The direction byte separates the two halves of a connection, the salt is
+// drawn once per Session so nonces differ across sessions even if a key were
+// ever reused, and the counter increases by one per frame; its top byte is
+// the key epoch, see Rekey. The receiver pins the peer's prefix on the first
+// frame and uses the counter for replay checks.
+func makeNonce(nonceOut []byte, prefix [4]byte, counter uint64) {
 	if len(nonceOut) < 12 {
 		return
 	}
+	copy(nonceOut[0:4], prefix[:])
 	binary.BigEndian.PutUint64(nonceOut[4:12], counter)
 }
 
+// FeatureTLSRecords is the policy feature that switches a session to TLS
+// framing mode once the grant is delivered, see SetTLSRecords.
+const FeatureTLSRecords = "tls-records"
+
+// SetTLSRecords switches the session to TLS framing mode, where every frame
+// is sent as a TLS 1.3 application data record so the stream looks like TLS
+// to middleboxes that only let TLS-shaped traffic through. Both peers must
+// switch before the first frame, which they do when FeatureTLSRecords is
+// granted.
+func (s *Session) SetTLSRecords(on bool) {
+	s.mu.Lock()
+	s.tlsRecords = on
+	s.mu.Unlock()
+}
+
+func (s *Session) usesTLSRecords() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tlsRecords
+}
+
+// SetWriteTimeout bounds how long writing one frame may take on writers
+// that take a write deadline, such as a net.Conn, so that a peer that stops
+// reading fails the write instead of holding the writer, and whatever it
+// buffers, forever. The deadline is set for every frame and cleared once
+// it is written. 0 leaves writes unbounded.
+func (s *Session) SetWriteTimeout(d time.Duration) {
+	s.mu.Lock()
+	s.writeTimeout = d
+	s.mu.Unlock()
+}
+
 // WriteFrame encrypts and writes one frame: length (2) + nonce (12) + ciphertext.
 // Plaintext is frameType (1 byte) + payload. Replay is avoided by monotonic write nonce.
+// In TLS framing mode a DATA payload too large for one record is sent as
+// several frames. WriteFrame may be called concurrently.
+//
+// A session that stamps its control frames, see SetTimestamps, stamps them
+// here, and sends a stamp ahead of a DATA frame when one is due.
 func (s *Session) WriteFrame(w io.Writer, frameType uint8, payload []byte) error {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	_, writeRecord := s.records()
+	if IsControlFrame(frameType) {
+		payload = s.stamp(frameType, payload)
+	} else if carriesData(frameType) && s.stampDue() {
+		if err := s.writeFrame(w, writeRecord, FrameTypeTimingCtrl, s.stamp(FrameTypeTimingCtrl, nil)); err != nil {
+			return err
+		}
+	}
+	if !s.usesTLSRecords() {
+		return s.writeFrame(w, writeRecord, frameType, payload)
+	}
+	maxPayload := s.MaxPayload()
+	for frameType == FrameTypeData && len(payload) > maxPayload {
+		if err := s.writeFrame(w, writeRecord, frameType, payload[:maxPayload]); err != nil {
+			return err
+		}
+		payload = payload[maxPayload:]
+	}
+	if frameType != FrameTypeData && carriesData(frameType) && len(payload) > maxPayload {
+		// Only padding is cut: the data fits, see MaxStreamData and
+		// MaxDatagram.
+		payload = payload[:maxPayload]
+	}
+	return s.writeFrame(w, writeRecord, frameType, payload)
+}
+
+// MaxPayload returns the largest payload one frame of the session carries.
+func (s *Session) MaxPayload() int {
+	size := frame.MaxRecordSize
+	if s.usesTLSRecords() {
+		size = frame.MaxTLSRecordSize
+	}
+	return size - s.aead.NonceSize() - s.overhead() - 1
+}
+
+// WriteFrameFrom writes n bytes read from r as DATA frames of up to
+// MaxPayload bytes, so that a large payload is sealed as it is read instead
+// of being held in memory whole. A negative n writes until r ends. It
+// returns the number of bytes written, and io.ErrUnexpectedEOF if r ends
+// before n bytes. Frames written concurrently may come between its frames.
+func (s *Session) WriteFrameFrom(w io.Writer, r io.Reader, n int64) (int64, error) {
+	return s.writeFrameFrom(w, r, n, nil)
+}
+
+// writeFrameFrom is WriteFrameFrom calling sent after every frame.
+func (s *Session) writeFrameFrom(w io.Writer, r io.Reader, n int64, sent func(int)) (int64, error) {
+	b := make([]byte, s.MaxPayload())
+	var written int64
+	for n < 0 || written < n {
+		chunk := b
+		if n >= 0 && n-written < int64(len(chunk)) {
+			chunk = chunk[:n-written]
+		}
+		k, err := r.Read(chunk)
+		if k > 0 {
+			if err := s.WriteFrame(w, FrameTypeData, chunk[:k]); err != nil {
+				return written, err
+			}
+			written += int64(k)
+			if sent != nil {
+				sent(k)
+			}
+		}
+		if err == io.EOF {
+			if n >= 0 && written < n {
+				return written, io.ErrUnexpectedEOF
+			}
+			return written, nil
+		}
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+func (s *Session) writeFrame(w io.Writer, writeRecord func(io.Writer, *frame.Record) error, frameType uint8, payload []byte) error {
 	s.mu.Lock()
 	nonceCount := s.writeNonceCount
 	s.writeNonceCount++
+	send := s.send
 	s.mu.Unlock()
 
-	plaintext := make([]byte, 1+len(payload))
-	plaintext[0] = frameType
-	copy(plaintext[1:], payload)
+	plaintext := (&frame.Frame{Type: frameType, Payload: payload}).Marshal()
 
 	nonce := make([]byte, s.aead.NonceSize())
-	makeNonce(nonce, nonceCount)
-	ciphertext := s.aead.Seal(nil, nonce, plaintext, nil)
-
-	// Wire: 2-byte length (nonce + ciphertext), then nonce, then ciphertext.
-	totalLen := len(nonce) + len(ciphertext)
-	header := make([]byte, 2)
-	binary.BigEndian.PutUint16(header, uint16(totalLen))
-	if _, err := w.Write(header); err != nil {
-		return err
+	makeNonce(nonce, s.prefix, nonceCount)
+	ciphertext := send.aead.Seal(nil, nonce, plaintext, nil)
+	if s.commitsToKey() {
+		ciphertext = append(ciphertext, commitmentOf(send.key, nonce)...)
+	}
+
+	s.mu.Lock()
+	timeout := s.writeTimeout
+	s.mu.Unlock()
+	if d, ok := w.(interface{ SetWriteDeadline(time.Time) error }); ok && timeout > 0 {
+		_ = d.SetWriteDeadline(time.Now().Add(timeout))
+		defer d.SetWriteDeadline(time.Time{})
 	}
-	if _, err := w.Write(nonce); err != nil {
+	if err := writeRecord(w, &frame.Record{Nonce: nonce, Ciphertext: ciphertext}); err != nil {
 		return err
 	}
-	_, err := w.Write(ciphertext)
-	return err
+	s.mu.Lock()
+	s.carried.count(frameType, len(payload))
+	s.mu.Unlock()
+	if hook := s.getHooks().OnFrameWrite; hook != nil {
+		hook(frameType, payload)
+	}
+	return nil
 }
 
 // Frame holds a decoded frame.
-type Frame struct {
-	Type    uint8
-	Payload []byte
-}
+type Frame = frame.Frame
 
 // ReadFrame reads and decrypts one frame. Returns error on replay (duplicate nonce) or auth failure.
+// In OrderingTolerant, frames are returned in the order they were sealed,
+// see SetOrdering.
 func (s *Session) ReadFrame(r io.Reader) (*Frame, error) {
-	var lenBuf [2]byte
-	if _, err := io.ReadFull(r, lenBuf[:]); err != nil {
-		return nil, err
-	}
-	totalLen := int(binary.BigEndian.Uint16(lenBuf[:]))
-	nonceSize := s.aead.NonceSize()
-	if totalLen < nonceSize {
-		return nil, errors.New("reflex: frame too short")
+	if s.Ordering() != OrderingTolerant {
+		f, _, err := s.openFrame(r)
+		if err != nil {
+			return nil, err
+		}
+		return s.delivered(f), nil
 	}
-	cipherLen := totalLen - nonceSize
-	if cipherLen < s.aead.Overhead() {
-		return nil, errors.New("reflex: ciphertext too short")
+	for {
+		f, err := s.releaseHeld(time.Now())
+		if f != nil || err != nil {
+			return f, err
+		}
+		f, seq, err := s.openFrame(r)
+		if err != nil {
+			if s.holdError(err) {
+				continue
+			}
+			return nil, err
+		}
+		if f = s.reorder(f, seq, time.Now()); f != nil {
+			return s.delivered(f), nil
+		}
 	}
+}
 
-	nonce := make([]byte, nonceSize)
-	if _, err := io.ReadFull(r, nonce); err != nil {
-		return nil, err
+// openFrame reads, decrypts and checks one frame, and returns it with its
+// sequence number.
+func (s *Session) openFrame(r io.Reader) (*Frame, uint64, error) {
+	readRecord, _ := s.records()
+	rec, err := readRecord(r, s.aead.NonceSize(), s.overhead())
+	if err != nil {
+		return nil, 0, err
 	}
-	ciphertext := make([]byte, cipherLen)
-	if _, err := io.ReadFull(r, ciphertext); err != nil {
-		return nil, err
+	nonce := rec.Nonce
+	readCounter := binary.BigEndian.Uint64(nonce[4:12])
+	recv, err := s.recvKey(readCounter)
+	if err != nil {
+		return nil, 0, err
 	}
 
-	plaintext, err := s.aead.Open(nil, nonce, ciphertext, nil)
+	sealed := rec.Ciphertext
+	if s.commitsToKey() {
+		if sealed, err = openCommitted(recv.key, nonce, sealed); err != nil {
+			return nil, 0, err
+		}
+	}
+	plaintext, err := recv.aead.Open(nil, nonce, sealed, nil)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
+	}
+	f, err := frame.Unmarshal(plaintext)
+	if err != nil {
+		return nil, 0, err
 	}
-	if len(plaintext) < 1 {
-		return nil, errors.New("reflex: empty plaintext")
+	if f.Type == FrameTypePaddedData {
+		if f, err = unpadded(f); err != nil {
+			return nil, 0, err
+		}
 	}
 
-	// Replay protection: require strictly increasing read counter (nonce last 8 bytes).
-	readCounter := binary.BigEndian.Uint64(nonce[4:12])
+	// A directional session never accepts frames carrying its own direction:
+	// those are our own frames reflected back at us.
+	if s.direction != DirectionNone && nonce[0] == s.direction {
+		return nil, 0, errors.New("reflex: reflected frame")
+	}
+
+	// Replay protection: require strictly increasing read counter (nonce
+	// last 8 bytes), or one not yet seen within the reorder window, see
+	// SetOrdering. The key epoch is the counter's top byte, so the check
+	// holds across rekeys.
 	s.mu.Lock()
-	if s.readSeen && readCounter <= s.readNonceCount {
+	if s.readSeen && string(s.peerPrefix[:]) != string(nonce[0:4]) {
 		s.mu.Unlock()
-		return nil, errors.New("reflex: replay detected")
+		return nil, 0, errors.New("reflex: nonce prefix mismatch")
+	}
+	if !s.readSeen {
+		copy(s.peerPrefix[:], nonce[0:4])
+	}
+	if !s.acceptCounter(readCounter) {
+		s.mu.Unlock()
+		return nil, 0, errors.New("reflex: replay detected")
 	}
 	s.readSeen = true
-	s.readNonceCount = readCounter
+	if recv.epoch > s.recv.epoch {
+		s.prevRecv, s.recv = s.recv, recv
+	}
 	s.mu.Unlock()
+	return f, readCounter & seqMask, nil
+}
 
-	return &Frame{
-		Type:    plaintext[0],
-		Payload: plaintext[1:],
-	}, nil
+// delivered accounts for f, which ReadFrame returns.
+func (s *Session) delivered(f *Frame) *Frame {
+	s.mu.Lock()
+	s.framesRead++
+	s.carried.count(f.Type, len(f.Payload))
+	s.mu.Unlock()
+	if IsControlFrame(f.Type) {
+		s.observeStamp(f.Type, f.Payload)
+	}
+
+	hooks := s.getHooks()
+	if hooks.OnFrameRead != nil {
+		hooks.OnFrameRead(f)
+	}
+	if hooks.OnControl != nil && IsControlFrame(f.Type) {
+		hooks.OnControl(f)
+	}
+	return f
 }
@@ -0,0 +1,78 @@
+package reflex
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/xtls/xray-core/proxy/reflex/frame"
+)
+
+// Kinds of events a SessionTranscript records besides frames.
+const (
+	TranscriptStart        = "start"        // the session was established; detail is the granted profile
+	TranscriptEnd          = "end"          // the session ended; detail is why
+	TranscriptInterference = "interference" // detail is the kind of interference seen
+)
+
+// TranscriptEntry is one line of a session transcript. Frames have Dir,
+// Type and Size; events have Event and Detail.
+type TranscriptEntry struct {
+	AtMs   float64 `json:"atMs"` // milliseconds since the transcript began
+	Dir    string  `json:"dir,omitempty"`
+	Type   string  `json:"type,omitempty"`
+	Size   int     `json:"size,omitempty"` // payload bytes, padding included
+	Event  string  `json:"event,omitempty"`
+	Detail string  `json:"detail,omitempty"`
+}
+
+// Directions of transcript frames.
+const (
+	TranscriptRecv = "recv"
+	TranscriptSent = "sent"
+)
+
+// SessionTranscript records a redacted transcript of a session as JSON
+// lines: the type, size and time of every frame and the session's events,
+// but no payload, so that it can go with a bug report about stalls or
+// corruption. It is safe for concurrent use.
+type SessionTranscript struct {
+	mu    sync.Mutex
+	enc   *json.Encoder
+	start time.Time
+	err   error // first write error; nothing is written after it
+}
+
+// NewSessionTranscript starts a transcript written to w.
+func NewSessionTranscript(w io.Writer) *SessionTranscript {
+	return &SessionTranscript{enc: json.NewEncoder(w), start: time.Now()}
+}
+
+// Attach records the frames s reads and writes from now on. It installs
+// session hooks, replacing any that were set.
+func (t *SessionTranscript) Attach(s *Session) {
+	s.SetHooks(SessionHooks{
+		OnFrameRead: func(f *Frame) {
+			t.record(&TranscriptEntry{Dir: TranscriptRecv, Type: frame.TypeName(f.Type), Size: len(f.Payload)})
+		},
+		OnFrameWrite: func(frameType uint8, payload []byte) {
+			t.record(&TranscriptEntry{Dir: TranscriptSent, Type: frame.TypeName(frameType), Size: len(payload)})
+		},
+	})
+}
+
+// Event records an event of the given kind.
+func (t *SessionTranscript) Event(kind, detail string) {
+	t.record(&TranscriptEntry{Event: kind, Detail: detail})
+}
+
+func (t *SessionTranscript) record(e *TranscriptEntry) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.err != nil {
+		return
+	}
+	e.AtMs = float64(time.Since(t.start).Microseconds()) / 1000
+	t.err = t.enc.Encode(e)
+}
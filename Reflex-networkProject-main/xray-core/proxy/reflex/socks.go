@@ -0,0 +1,269 @@
+package reflex
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultFrontEndTimeout bounds how long a local front-end waits for an
+// application's proxy request and for the tunnel to open.
+const DefaultFrontEndTimeout = 10 * time.Second
+
+// SOCKS5 values used by SOCKS5Server, see RFC 1928.
+const (
+	socks5Version        = 0x05
+	socks5NoAuth         = 0x00
+	socks5UserPass       = 0x02
+	socks5NoAcceptable   = 0xFF
+	socks5Connect        = 0x01
+	socks5AddrIPv4       = 0x01
+	socks5AddrDomain     = 0x03
+	socks5AddrIPv6       = 0x04
+	socks5Succeeded      = 0x00
+	socks5Refused        = 0x05
+	socks5CmdNotSupport  = 0x07
+	socks5AddrNotSupport = 0x08
+)
+
+// SOCKS5Server is a local SOCKS5 proxy that tunnels every connection it
+// accepts over a Reflex session, so that browsers and other applications
+// can use Reflex without a full xray on the client. It supports CONNECT
+// without authentication, or with a username and password if Credentials
+// is set; it should listen on a loopback address.
+type SOCKS5Server struct {
+	// Dial opens the session for each accepted connection.
+	Dial TunnelDialer
+	// Credentials, if set, is offered the username and password (RFC
+	// 1929) of clients that send them, such as the per-bridge arguments of
+	// Pluggable Transports, and returns the dialer for the connection, or
+	// an error to refuse it. Clients without credentials use Dial.
+	Credentials func(username, password string) (TunnelDialer, error)
+	// Timeout bounds the SOCKS5 negotiation and Dial. Zero means
+	// DefaultFrontEndTimeout.
+	Timeout time.Duration
+
+	mu    sync.Mutex
+	ln    net.Listener
+	conns sync.WaitGroup
+}
+
+// ListenAndServe listens on the TCP address addr and calls Serve.
+func (s *SOCKS5Server) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ln)
+}
+
+// Serve accepts connections on ln until Close is called, and returns nil
+// then.
+func (s *SOCKS5Server) Serve(ln net.Listener) error {
+	return serveFrontEnd(&s.mu, &s.ln, &s.conns, ln, s.serveConn)
+}
+
+// Close stops the server and waits for the tunnels it opened to end. Live
+// tunnels are not interrupted.
+func (s *SOCKS5Server) Close() error {
+	return closeFrontEnd(&s.mu, &s.ln, &s.conns)
+}
+
+func (s *SOCKS5Server) serveConn(conn net.Conn) {
+	timeout := s.Timeout
+	if timeout == 0 {
+		timeout = DefaultFrontEndTimeout
+	}
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+	dial := s.Dial
+	var auth func(username, password string) bool
+	if s.Credentials != nil {
+		auth = func(username, password string) bool {
+			d, err := s.Credentials(username, password)
+			dial = d
+			return err == nil
+		}
+	}
+	address, err := readSOCKS5Request(conn, auth)
+	if err != nil {
+		_ = conn.Close()
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	c, err := dial(ctx, address)
+	cancel()
+	if err != nil {
+		_ = writeSOCKS5Reply(conn, socks5Refused)
+		_ = conn.Close()
+		return
+	}
+	if err := writeSOCKS5Reply(conn, socks5Succeeded); err != nil {
+		_ = conn.Close()
+		_ = c.Close()
+		return
+	}
+	_ = conn.SetDeadline(time.Time{})
+	Tunnel(conn, c)
+}
+
+// readSOCKS5Request negotiates the method and reads a CONNECT request,
+// returning its address. Requests that are not served get their reply here.
+// If auth is set, clients that offer a username and password are asked for
+// them and refused unless auth accepts them.
+func readSOCKS5Request(conn net.Conn, auth func(username, password string) bool) (string, error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(conn, hdr[:]); err != nil {
+		return "", err
+	}
+	if hdr[0] != socks5Version {
+		return "", errors.New("reflex: not a SOCKS5 client")
+	}
+	methods := make([]byte, hdr[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return "", err
+	}
+	method := byte(socks5NoAcceptable)
+	for _, m := range methods {
+		if m == socks5UserPass && auth != nil {
+			method = socks5UserPass
+			break
+		}
+		if m == socks5NoAuth {
+			method = socks5NoAuth
+		}
+	}
+	if _, err := conn.Write([]byte{socks5Version, method}); err != nil {
+		return "", err
+	}
+	if method == socks5NoAcceptable {
+		return "", errors.New("reflex: SOCKS5 client offers no usable method")
+	}
+	if method == socks5UserPass {
+		if err := readSOCKS5Credentials(conn, auth); err != nil {
+			return "", err
+		}
+	}
+
+	var req [4]byte
+	if _, err := io.ReadFull(conn, req[:]); err != nil {
+		return "", err
+	}
+	if req[0] != socks5Version {
+		return "", errors.New("reflex: malformed SOCKS5 request")
+	}
+	var host string
+	switch req[3] {
+	case socks5AddrIPv4, socks5AddrIPv6:
+		ip := make([]byte, net.IPv4len)
+		if req[3] == socks5AddrIPv6 {
+			ip = make([]byte, net.IPv6len)
+		}
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return "", err
+		}
+		host = net.IP(ip).String()
+	case socks5AddrDomain:
+		var n [1]byte
+		if _, err := io.ReadFull(conn, n[:]); err != nil {
+			return "", err
+		}
+		name := make([]byte, n[0])
+		if _, err := io.ReadFull(conn, name); err != nil {
+			return "", err
+		}
+		host = string(name)
+	default:
+		_ = writeSOCKS5Reply(conn, socks5AddrNotSupport)
+		return "", errors.New("reflex: unsupported SOCKS5 address type")
+	}
+	var port [2]byte
+	if _, err := io.ReadFull(conn, port[:]); err != nil {
+		return "", err
+	}
+	if req[1] != socks5Connect {
+		_ = writeSOCKS5Reply(conn, socks5CmdNotSupport)
+		return "", errors.New("reflex: unsupported SOCKS5 command")
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port[:])))), nil
+}
+
+// readSOCKS5Credentials reads a username and password request (RFC 1929)
+// and answers it with what auth makes of them.
+func readSOCKS5Credentials(conn net.Conn, auth func(username, password string) bool) error {
+	readField := func() (string, error) {
+		var n [1]byte
+		if _, err := io.ReadFull(conn, n[:]); err != nil {
+			return "", err
+		}
+		b := make([]byte, n[0])
+		_, err := io.ReadFull(conn, b)
+		return string(b), err
+	}
+	var version [1]byte
+	if _, err := io.ReadFull(conn, version[:]); err != nil {
+		return err
+	}
+	if version[0] != 0x01 {
+		return errors.New("reflex: malformed SOCKS5 credentials")
+	}
+	username, err := readField()
+	if err != nil {
+		return err
+	}
+	password, err := readField()
+	if err != nil {
+		return err
+	}
+	if !auth(username, password) {
+		_, _ = conn.Write([]byte{0x01, 0x01})
+		return errors.New("reflex: SOCKS5 credentials refused")
+	}
+	_, err = conn.Write([]byte{0x01, 0x00})
+	return err
+}
+
+// writeSOCKS5Reply answers a request. The bound address is not meaningful
+// for a tunnel and is sent as 0.0.0.0:0.
+func writeSOCKS5Reply(conn net.Conn, status byte) error {
+	_, err := conn.Write([]byte{socks5Version, status, 0, socks5AddrIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
+
+// serveFrontEnd is the accept loop shared by the local front-ends. It
+// records ln in *cur so that Close can stop it.
+func serveFrontEnd(mu *sync.Mutex, cur *net.Listener, conns *sync.WaitGroup, ln net.Listener, serve func(net.Conn)) error {
+	mu.Lock()
+	*cur = ln
+	mu.Unlock()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		conns.Add(1)
+		go func() {
+			defer conns.Done()
+			serve(conn)
+		}()
+	}
+}
+
+func closeFrontEnd(mu *sync.Mutex, cur *net.Listener, conns *sync.WaitGroup) error {
+	mu.Lock()
+	ln := *cur
+	mu.Unlock()
+	var err error
+	if ln != nil {
+		err = ln.Close()
+	}
+	conns.Wait()
+	return err
+}
@@ -0,0 +1,109 @@
+package reflex
+
+import (
+	"errors"
+)
+
+// SessionState is everything needed to continue a session in another
+// process: the key, the nonce prefixes and both counters. It is secret
+// material and must only travel over a channel as trusted as the key itself.
+type SessionState struct {
+	Key           []byte   `json:"key"`
+	Direction     uint8    `json:"direction"`
+	Prefix        [4]byte  `json:"prefix"`
+	WriteNonce    uint64   `json:"write_nonce"`
+	ReadNonce     uint64   `json:"read_nonce"`
+	ReadSeen      bool     `json:"read_seen"`
+	PeerPrefix    [4]byte  `json:"peer_prefix"`
+	FramesRead    uint64   `json:"frames_read"`
+	PolicyVersion uint8    `json:"policy_version"`
+	TLSRecords    bool     `json:"tls_records,omitempty"`
+	KeyCommitment bool     `json:"key_commitment,omitempty"`
+	MaskedLengths bool     `json:"masked_lengths,omitempty"`
+	Ordering      uint8    `json:"ordering,omitempty"`
+	ReorderWindow []uint64 `json:"reorder_window,omitempty"`
+	Cipher        uint8    `json:"cipher,omitempty"`
+	Timestamps    bool     `json:"timestamps,omitempty"`
+	Destination   string   `json:"destination,omitempty"` // host:port, see SetDestination
+}
+
+// State returns a snapshot of s for RestoreSession. No frames may be read or
+// written on s afterwards, or the two copies would reuse nonces.
+func (s *Session) State() *SessionState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var destination string
+	if s.destination != nil {
+		destination = s.destination.String()
+	}
+	return &SessionState{
+		Key:           append([]byte(nil), s.key...),
+		Direction:     s.direction,
+		Prefix:        s.prefix,
+		WriteNonce:    s.writeNonceCount,
+		ReadNonce:     s.readNonceCount,
+		ReadSeen:      s.readSeen,
+		PeerPrefix:    s.peerPrefix,
+		FramesRead:    s.framesRead,
+		PolicyVersion: s.policyVersion,
+		TLSRecords:    s.tlsRecords,
+		KeyCommitment: s.keyCommitment,
+		MaskedLengths: s.lengthKey != nil,
+		Ordering:      uint8(s.ordering),
+		ReorderWindow: append([]uint64(nil), s.window...),
+		Cipher:        uint8(s.cipher),
+		Timestamps:    s.path.on,
+		Destination:   destination,
+	}
+}
+
+// RestoreSession recreates a session from State. Hooks are not part of the
+// state and must be set again.
+func RestoreSession(state *SessionState) (*Session, error) {
+	if len(state.Key) != 32 {
+		return nil, errors.New("reflex: session key must be 32 bytes")
+	}
+	aead, err := newAEAD(Cipher(state.Cipher), state.Key)
+	if err != nil {
+		return nil, err
+	}
+	s := &Session{
+		aead:            aead,
+		cipher:          Cipher(state.Cipher),
+		key:             append([]byte(nil), state.Key...),
+		direction:       state.Direction,
+		prefix:          state.Prefix,
+		writeNonceCount: state.WriteNonce,
+		readNonceCount:  state.ReadNonce,
+		readSeen:        state.ReadSeen,
+		peerPrefix:      state.PeerPrefix,
+		framesRead:      state.FramesRead,
+		policyVersion:   state.PolicyVersion,
+		tlsRecords:      state.TLSRecords,
+		keyCommitment:   state.KeyCommitment,
+		ordering:        OrderingMode(state.Ordering),
+		path:            pathClock{on: state.Timestamps},
+	}
+	if s.ordering == OrderingTolerant {
+		if len(state.ReorderWindow) == 0 || len(state.ReorderWindow)*64 > MaxReorderWindow {
+			return nil, errors.New("reflex: reorder window out of range")
+		}
+		s.window = append(replayWindow(nil), state.ReorderWindow...)
+		s.expectNext()
+	}
+	if err := s.SetMaskedLengths(state.MaskedLengths); err != nil {
+		return nil, err
+	}
+	if state.Destination != "" {
+		d, err := ParseDestination(state.Destination)
+		if err != nil {
+			return nil, err
+		}
+		s.destination = &d
+	}
+	// The key epochs are the top bytes of the counters, see Rekey.
+	if err := s.initEpochs(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
@@ -0,0 +1,83 @@
+package reflex
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// savedState is the on-disk form of the state kept by SaveState.
+type savedState struct {
+	Replay map[string]time.Time            `json:"replay,omitempty"` // hex nonce -> expiry
+	Logins map[string]map[string]time.Time `json:"logins,omitempty"` // user -> source -> last seen
+}
+
+// SaveState writes the unexpired entries of the replay cache and the login
+// tracker to path, so that a restart neither reopens the replay window nor
+// lifts login blocks. Either may be nil. The file is replaced atomically.
+func SaveState(path string, replay *ReplayCache, logins *LoginTracker, now time.Time) error {
+	var state savedState
+	if replay != nil {
+		state.Replay = make(map[string]time.Time)
+		for nonce, expiry := range replay.snapshot(now) {
+			state.Replay[hex.EncodeToString(nonce[:])] = expiry
+		}
+	}
+	if logins != nil {
+		state.Logins = logins.snapshot(now)
+	}
+	data, err := json.Marshal(&state)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// LoadState restores what SaveState wrote to path into replay and logins,
+// either of which may be nil. Expired entries are dropped; a missing file
+// is not an error.
+func LoadState(path string, replay *ReplayCache, logins *LoginTracker, now time.Time) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var state savedState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return errors.New("reflex: corrupt state file " + path)
+	}
+	if replay != nil {
+		seen := make(map[[16]byte]time.Time, len(state.Replay))
+		for s, expiry := range state.Replay {
+			var nonce [16]byte
+			if b, err := hex.DecodeString(s); err == nil && len(b) == len(nonce) {
+				copy(nonce[:], b)
+				seen[nonce] = expiry
+			}
+		}
+		replay.restore(seen, now)
+	}
+	if logins != nil {
+		logins.restore(state.Logins, now)
+	}
+	return nil
+}
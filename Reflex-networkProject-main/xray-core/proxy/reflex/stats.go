@@ -0,0 +1,30 @@
+package reflex
+
+// SessionStats is a snapshot of a session's counters.
+type SessionStats struct {
+	FramesWritten uint64
+	FramesRead    uint64
+	PolicyVersion uint8       // negotiated policy encoding version, 0 before a grant
+	Path          PathQuality // delay estimates from stamped control frames
+	Overhead      Overhead    // payload, padding and cover traffic of the frames
+}
+
+// Stats returns a snapshot of the session's counters.
+func (s *Session) Stats() SessionStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return SessionStats{
+		FramesWritten: s.writeNonceCount & seqMask, // without the key epoch, see Rekey
+		FramesRead:    s.framesRead,
+		PolicyVersion: s.policyVersion,
+		Path:          s.path.quality,
+		Overhead:      s.carried,
+	}
+}
+
+// SetPolicyVersion records the policy version negotiated for the session.
+func (s *Session) SetPolicyVersion(version uint8) {
+	s.mu.Lock()
+	s.policyVersion = version
+	s.mu.Unlock()
+}
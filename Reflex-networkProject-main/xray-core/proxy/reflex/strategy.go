@@ -0,0 +1,182 @@
+package reflex
+
+import (
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Strategy changes how a connection's bytes meet the wire, in the spirit of
+// Geneva: splitting writes into several segments, pausing between them,
+// delaying reads. It sits below framing and encryption, so a strategy cannot
+// break the protocol as long as every byte is written once and in order.
+//
+// Write is called instead of writing b, the bytes of one write (normally one
+// frame record or the handshake), to the connection w. It must write all of
+// b, in order, or return an error. w is the connection itself, so a strategy
+// that works at the TCP layer (TTL tricks, out-of-order segments) can reach
+// its syscall.Conn where the platform allows it.
+//
+// Read is called with the bytes of each read from the wire before anything
+// parses them. It may pause to delay the read; it must not change b.
+//
+// A Strategy serves one connection, so it may keep per-connection state.
+// Writes are serialized; Read may run concurrently with Write.
+type Strategy interface {
+	Write(w io.Writer, b []byte) error
+	Read(b []byte)
+}
+
+// StrategyFactory builds a Strategy from its configured arguments. It is
+// called once per connection.
+type StrategyFactory func(args map[string]string) (Strategy, error)
+
+var (
+	strategyMu sync.RWMutex
+	strategies = map[string]StrategyFactory{
+		"split": newSplitStrategy,
+		"delay": newDelayStrategy,
+	}
+)
+
+// RegisterStrategy makes a strategy available by name to the client options
+// and the inbound configuration. Packages outside this module register their
+// strategies from init and are linked in with a blank import, so experiments
+// need no fork of the relay.
+func RegisterStrategy(name string, factory StrategyFactory) {
+	strategyMu.Lock()
+	defer strategyMu.Unlock()
+	strategies[name] = factory
+}
+
+// NewStrategy builds the registered strategy name with args.
+func NewStrategy(name string, args map[string]string) (Strategy, error) {
+	strategyMu.RLock()
+	factory := strategies[name]
+	strategyMu.RUnlock()
+	if factory == nil {
+		return nil, errors.New("reflex: unknown strategy " + name)
+	}
+	return factory(args)
+}
+
+// StrategyConn is a connection whose reads and writes go through a Strategy.
+type StrategyConn struct {
+	net.Conn
+	strategy Strategy
+	wmu      sync.Mutex
+}
+
+// NewStrategyConn applies s to conn.
+func NewStrategyConn(conn net.Conn, s Strategy) *StrategyConn {
+	return &StrategyConn{Conn: conn, strategy: s}
+}
+
+// Read implements net.Conn.
+func (c *StrategyConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.strategy.Read(b[:n])
+	}
+	return n, err
+}
+
+// Write implements net.Conn.
+func (c *StrategyConn) Write(b []byte) (int, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if err := c.strategy.Write(c.Conn, b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// strategyArgs reads the numeric arguments of the built-in strategies.
+type strategyArgs struct {
+	args map[string]string
+	err  error
+}
+
+func (a *strategyArgs) int(name string, def int) int {
+	v, ok := a.args[name]
+	if !ok || a.err != nil {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		a.err = errors.New("reflex: strategy argument " + name + " must be a non-negative integer")
+		return def
+	}
+	return n
+}
+
+// splitStrategy splits writes into random pieces with jittered pauses, see
+// WriteFragmented. Arguments: min and max piece size in bytes, minDelay and
+// maxDelay in milliseconds, and writes, the number of writes to split
+// (0 splits all of them; 1 only the handshake).
+type splitStrategy struct {
+	cfg    FragmentConfig
+	writes int
+
+	mu      sync.Mutex
+	written int
+}
+
+func newSplitStrategy(args map[string]string) (Strategy, error) {
+	a := &strategyArgs{args: args}
+	s := &splitStrategy{
+		cfg: FragmentConfig{
+			MinSize:  a.int("min", DefaultFragmentConfig.MinSize),
+			MaxSize:  a.int("max", DefaultFragmentConfig.MaxSize),
+			MinDelay: time.Duration(a.int("minDelay", int(DefaultFragmentConfig.MinDelay/time.Millisecond))) * time.Millisecond,
+			MaxDelay: time.Duration(a.int("maxDelay", int(DefaultFragmentConfig.MaxDelay/time.Millisecond))) * time.Millisecond,
+		},
+		writes: a.int("writes", 0),
+	}
+	return s, a.err
+}
+
+func (s *splitStrategy) Write(w io.Writer, b []byte) error {
+	s.mu.Lock()
+	s.written++
+	split := s.writes == 0 || s.written <= s.writes
+	s.mu.Unlock()
+	if !split {
+		_, err := w.Write(b)
+		return err
+	}
+	return WriteFragmented(w, b, s.cfg)
+}
+
+func (s *splitStrategy) Read([]byte) {}
+
+// delayStrategy pauses before every write and, with readDelay, after every
+// read. Arguments in milliseconds: min and max for writes, readDelay for
+// reads.
+type delayStrategy struct {
+	min, max  time.Duration
+	readDelay time.Duration
+}
+
+func newDelayStrategy(args map[string]string) (Strategy, error) {
+	a := &strategyArgs{args: args}
+	s := &delayStrategy{
+		min:       time.Duration(a.int("min", 0)) * time.Millisecond,
+		max:       time.Duration(a.int("max", 0)) * time.Millisecond,
+		readDelay: time.Duration(a.int("readDelay", 0)) * time.Millisecond,
+	}
+	return s, a.err
+}
+
+func (s *delayStrategy) Write(w io.Writer, b []byte) error {
+	time.Sleep(jitter(s.min, s.max))
+	_, err := w.Write(b)
+	return err
+}
+
+func (s *delayStrategy) Read([]byte) {
+	time.Sleep(s.readDelay)
+}
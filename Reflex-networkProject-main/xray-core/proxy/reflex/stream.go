@@ -0,0 +1,96 @@
+package reflex
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/xtls/xray-core/proxy/reflex/frame"
+	"golang.org/x/crypto/hkdf"
+)
+
+// StreamSession derives an independent Session for one multiplexed stream.
+// The subkey is HKDF-SHA256(session key, info = "reflex-stream" || streamID),
+// so every stream has its own key and nonce space: a nonce-management bug in
+// one stream cannot leak another stream's plaintext, and each stream's frames
+// can be sealed and opened by an independent worker without sharing counters.
+// Both peers derive the same subkey for the same streamID.
+func (s *Session) StreamSession(streamID uint32) (*Session, error) {
+	if len(s.key) == 0 {
+		return nil, errors.New("reflex: session has no key material to derive streams from")
+	}
+	info := make([]byte, len("reflex-stream")+4)
+	copy(info, "reflex-stream")
+	binary.BigEndian.PutUint32(info[len("reflex-stream"):], streamID)
+
+	subkey := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, s.key, nil, info), subkey); err != nil {
+		return nil, err
+	}
+	stream, err := newSession(subkey, s.direction)
+	if err != nil {
+		return nil, err
+	}
+	if c := s.Cipher(); c != CipherChaCha20Poly1305 {
+		if err := stream.SetCipher(c); err != nil {
+			return nil, err
+		}
+	}
+	return stream, nil
+}
+
+// StreamCipher seals the data of one stream of a Mux with the stream's own
+// Session, see StreamSession. The data of a STREAM_DATA frame is a piece of
+// the stream's frames: the frames of a stream are one byte stream, which
+// the reader reassembles whatever pieces they came in, so that padding may
+// split STREAM_DATA further, see WriteFrameWithMorphing.
+type StreamCipher struct {
+	session *Session
+	pending []byte // of a frame not read whole yet
+}
+
+// NewStreamCipher returns the cipher of stream id, which both peers derive
+// when the stream is opened.
+func (s *Session) NewStreamCipher(id uint32) (*StreamCipher, error) {
+	stream, err := s.StreamSession(id)
+	if err != nil {
+		return nil, err
+	}
+	return &StreamCipher{session: stream}, nil
+}
+
+// streamSealOverhead is what sealing data with a stream's Session adds to it.
+func (s *Session) streamSealOverhead() int {
+	return frame.LengthSize + s.aead.NonceSize() + s.aead.Overhead() + 1
+}
+
+// Seal returns data sealed as a frame of the stream, to send as the data
+// of STREAM_DATA. data must fit in one frame, see MaxStreamData.
+func (c *StreamCipher) Seal(data []byte) ([]byte, error) {
+	var b bytes.Buffer
+	if err := c.session.WriteFrame(&b, FrameTypeData, data); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// Open takes the data of a STREAM_DATA frame and returns the data of the
+// frames of the stream it completes, if any.
+func (c *StreamCipher) Open(sealed []byte) ([]byte, error) {
+	c.pending = append(c.pending, sealed...)
+	var data []byte
+	for {
+		r := bytes.NewReader(c.pending)
+		f, err := c.session.ReadFrame(r)
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return data, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		c.pending = c.pending[len(c.pending)-r.Len():]
+		data = append(data, f.Payload...)
+	}
+}
@@ -0,0 +1,179 @@
+package reflex
+
+import (
+	"encoding/binary"
+	"math"
+	"time"
+)
+
+// FeatureTimestamps is the policy feature that has both peers of a session
+// stamp their control frames, see SetTimestamps.
+const FeatureTimestamps = "timestamps"
+
+// TimestampInterval is how often a stamping session sends a TIMING_CTRL
+// frame of its own while it writes DATA, so that the peer has samples even
+// when nobody pushes control frames.
+const TimestampInterval = time.Second
+
+// timestampSize is the size of the trailer a stamped PADDING_CTRL or
+// TIMING_CTRL frame carries after its usual payload:
+//
+//	sent (8) | echo (8) | held (4)
+//
+// all big endian microseconds: when the frame was sent, on the sender's
+// clock; the sent value of the last stamped frame the sender read, 0 if
+// none; and how long the sender held that one before echoing it. Peers that
+// do not stamp read the usual payload and ignore the trailer.
+const timestampSize = 20
+
+// controlSize returns the size of the usual payload of a control frame, see
+// ApplyControlFrame.
+func controlSize(frameType uint8) int {
+	if frameType == FrameTypePaddingCtrl {
+		return 2
+	}
+	return 8
+}
+
+// PathQuality is what a session learned about the path from the stamps of
+// its peer's control frames.
+type PathQuality struct {
+	Samples uint64        // stamped frames read
+	RTT     time.Duration // smoothed round-trip time, from echoes
+	MinRTT  time.Duration // lowest round-trip time seen
+	// OneWayDelay estimates the delay from the peer to us: half the lowest
+	// round trip, plus how much later than at best the last frame came. The
+	// clocks of the peers need not agree.
+	OneWayDelay time.Duration
+	// Jitter is the smoothed variation of the delay from the peer, as RTP
+	// computes it (RFC 3550).
+	Jitter time.Duration
+}
+
+// pathClock is the timestamp state of a session.
+type pathClock struct {
+	on        bool
+	lastSent  time.Time // when we last sent a stamp
+	peerSent  int64     // the peer's last stamp, in microseconds
+	peerAt    time.Time // when that was read
+	offset    int64     // arrival minus peer stamp of the last frame, in microseconds
+	minOffset int64
+	quality   PathQuality
+}
+
+// SetTimestamps sets whether the session stamps the PADDING_CTRL and
+// TIMING_CTRL frames it writes, and sends a TIMING_CTRL frame that only
+// carries a stamp every TimestampInterval while it writes DATA. Both peers
+// switch when FeatureTimestamps is granted. Stamped frames are read whether
+// or not the session stamps its own; see PathQuality.
+func (s *Session) SetTimestamps(on bool) {
+	s.mu.Lock()
+	s.path.on = on
+	s.mu.Unlock()
+}
+
+// PathQuality returns the delay estimates of the session so far.
+func (s *Session) PathQuality() PathQuality {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.path.quality
+}
+
+// stampDue reports whether a stamping session should send a stamp before a
+// DATA frame.
+func (s *Session) stampDue() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.path.on && time.Since(s.path.lastSent) >= TimestampInterval
+}
+
+// stamp returns the payload of control frame frameType with a trailer
+// stamped now, or payload itself if the session does not stamp.
+func (s *Session) stamp(frameType uint8, payload []byte) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.path.on {
+		return payload
+	}
+	n := controlSize(frameType)
+	b := make([]byte, n+timestampSize)
+	copy(b, payload)
+	now := time.Now()
+	binary.BigEndian.PutUint64(b[n:], uint64(now.UnixMicro()))
+	if s.path.peerSent != 0 {
+		binary.BigEndian.PutUint64(b[n+8:], uint64(s.path.peerSent))
+		held := now.Sub(s.path.peerAt).Microseconds()
+		binary.BigEndian.PutUint32(b[n+16:], uint32(min(held, math.MaxUint32)))
+	}
+	s.path.lastSent = now
+	return b
+}
+
+// observeStamp updates the delay estimates from a control frame read now,
+// if it is stamped.
+func (s *Session) observeStamp(frameType uint8, payload []byte) {
+	n := controlSize(frameType)
+	if len(payload) != n+timestampSize {
+		return
+	}
+	sent := int64(binary.BigEndian.Uint64(payload[n:]))
+	echo := int64(binary.BigEndian.Uint64(payload[n+8:]))
+	held := int64(binary.BigEndian.Uint32(payload[n+16:]))
+	now := time.Now()
+	offset := now.UnixMicro() - sent
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, q := &s.path, &s.path.quality
+	if q.Samples == 0 {
+		p.minOffset = offset
+	} else {
+		d := offset - p.offset
+		if d < 0 {
+			d = -d
+		}
+		q.Jitter += (time.Duration(d)*time.Microsecond - q.Jitter) / 16
+	}
+	p.offset, p.minOffset = offset, min(p.minOffset, offset)
+	if echo != 0 {
+		if rtt := time.Duration(now.UnixMicro()-echo-held) * time.Microsecond; rtt >= 0 {
+			if q.RTT == 0 {
+				q.RTT, q.MinRTT = rtt, rtt
+			} else {
+				q.RTT += (rtt - q.RTT) / 8
+				q.MinRTT = min(q.MinRTT, rtt)
+			}
+		}
+	}
+	q.OneWayDelay = q.MinRTT/2 + time.Duration(offset-p.minOffset)*time.Microsecond
+	q.Samples++
+	p.peerSent, p.peerAt = sent, now
+}
+
+// isEchoOnly reports whether a control frame only carries a stamp: its
+// usual payload is zero, which asks for nothing.
+func isEchoOnly(frameType uint8, payload []byte) bool {
+	n := controlSize(frameType)
+	if len(payload) != n+timestampSize {
+		return false
+	}
+	for _, b := range payload[:n] {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// shapeDelay returns the delay the morpher waits after a frame for a
+// profile-sampled delay d. The path spreads frames out by its jitter on
+// its own, so that much less is waited, but never less than half of d.
+func (s *Session) shapeDelay(d time.Duration) time.Duration {
+	s.mu.Lock()
+	jitter := s.path.quality.Jitter
+	s.mu.Unlock()
+	if d <= 0 || jitter <= 0 {
+		return d
+	}
+	return max(d-jitter, d/2)
+}
@@ -0,0 +1,303 @@
+package reflex
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Handshake transports a server tells apart. A client network that
+// completes handshakes over one and not another is blocking the latter.
+const (
+	TransportMagic   = "magic"   // binary handshake behind HandshakeMagic
+	TransportPSK     = "psk"     // PSK-only handshake, see PSKMagic
+	TransportHTTP    = "http"    // handshake in an HTTP POST
+	TransportFronted = "fronted" // handshake in an HTTP POST to a cover front
+)
+
+// Outcomes of a handshake attempt, see TransportTelemetry.Record.
+const (
+	// TransportCompleted is a handshake the server answered and started a
+	// session for.
+	TransportCompleted = "completed"
+	// TransportRejected is a handshake the server refused: unknown user,
+	// replay, clock skew or policy. It says nothing about the path.
+	TransportRejected = "rejected"
+	// TransportFailed is a handshake that never arrived whole: cut off,
+	// malformed or timed out.
+	TransportFailed = "failed"
+	// TransportReset is a completed handshake whose connection was reset
+	// before any data, see InterferenceResetAfterHandshake.
+	TransportReset = "reset"
+)
+
+// TransportStatsWindow is the interval attempts are counted in, and
+// TransportStatsWindows how many of them are kept.
+const (
+	TransportStatsWindow  = time.Hour
+	TransportStatsWindows = 24
+)
+
+// TransportOutcomes counts the handshake attempts over one transport.
+type TransportOutcomes struct {
+	Completed uint64 `json:"completed"`
+	Rejected  uint64 `json:"rejected"`
+	Failed    uint64 `json:"failed"`
+	Reset     uint64 `json:"reset"` // of Completed
+}
+
+// Attempts returns the number of attempts counted.
+func (o TransportOutcomes) Attempts() uint64 {
+	return o.Completed + o.Rejected + o.Failed
+}
+
+// SuccessRate returns the share of attempts that got through the path: that
+// completed and were not reset, of those the server did not refuse. It is 0
+// without any such attempts.
+func (o TransportOutcomes) SuccessRate() float64 {
+	tried := o.Completed + o.Failed
+	if tried == 0 || o.Reset >= o.Completed {
+		return 0
+	}
+	return float64(o.Completed-o.Reset) / float64(tried)
+}
+
+// MarshalJSON adds the attempts and success rate to the counts.
+func (o TransportOutcomes) MarshalJSON() ([]byte, error) {
+	type counts TransportOutcomes
+	return json.Marshal(struct {
+		counts
+		Attempts    uint64  `json:"attempts"`
+		SuccessRate float64 `json:"successRate"`
+	}{counts(o), o.Attempts(), o.SuccessRate()})
+}
+
+func (o *TransportOutcomes) add(p TransportOutcomes) {
+	o.Completed += p.Completed
+	o.Rejected += p.Rejected
+	o.Failed += p.Failed
+	o.Reset += p.Reset
+}
+
+// TransportWindow is what was counted in one TransportStatsWindow.
+type TransportWindow struct {
+	Start      time.Time                    `json:"start"`
+	Transports map[string]TransportOutcomes `json:"transports"` // by transport
+}
+
+// NetworkTransports is what was counted for one client network, see
+// InterferenceNetwork, over the kept windows.
+type NetworkTransports struct {
+	Network    string                       `json:"network"`
+	Transports map[string]TransportOutcomes `json:"transports"` // totals by transport
+	Windows    []TransportWindow            `json:"windows"`    // oldest first; windows without attempts are left out
+	LastSeen   time.Time                    `json:"last_seen"`
+}
+
+// TransportMetrics is how the handshakes and sessions over one transport
+// fared since the server started.
+type TransportMetrics struct {
+	Handshakes    uint64            `json:"handshakes"`         // completed
+	MeanHandshake time.Duration     `json:"meanHandshake"`      // from the handshake's first bytes to its session starting
+	MaxHandshake  time.Duration     `json:"maxHandshake"`       // slowest of Handshakes
+	Failures      map[string]uint64 `json:"failures,omitempty"` // refused, failed and reset handshakes by reason
+	Sessions      uint64            `json:"sessions"`           // ended
+	Bytes         uint64            `json:"bytes"`              // DATA payload of the ended sessions, both directions
+	Throughput    float64           `json:"throughput"`         // Bytes per second the ended sessions lasted
+}
+
+// transportMetrics accumulates the TransportMetrics of a transport.
+type transportMetrics struct {
+	handshakes    uint64
+	handshakeTime time.Duration
+	maxHandshake  time.Duration
+	failures      map[string]uint64
+	sessions      uint64
+	bytes         uint64
+	sessionTime   time.Duration
+}
+
+// TransportTelemetry counts handshake outcomes per client network and
+// transport over time, so operators can see which transports are blocked
+// where, and measures the handshakes and sessions of each transport, so they
+// can compare how the transports perform. It is safe for concurrent use.
+type TransportTelemetry struct {
+	mu       sync.Mutex
+	networks map[string]*NetworkTransports // Transports is unused here
+	window   time.Time                     // start of the current window
+	metrics  map[string]*transportMetrics  // by transport
+}
+
+// NewTransportTelemetry returns an empty TransportTelemetry.
+func NewTransportTelemetry() *TransportTelemetry {
+	return &TransportTelemetry{
+		networks: make(map[string]*NetworkTransports),
+		metrics:  make(map[string]*transportMetrics),
+	}
+}
+
+// Record counts an outcome of a handshake over transport from network.
+func (t *TransportTelemetry) Record(network, transport, outcome string, now time.Time) {
+	start := now.Truncate(TransportStatsWindow)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if start.After(t.window) {
+		t.window = start
+		t.expire(start)
+	}
+	n := t.networks[network]
+	if n == nil {
+		n = &NetworkTransports{Network: network}
+		t.networks[network] = n
+	}
+	if len(n.Windows) == 0 || n.Windows[len(n.Windows)-1].Start.Before(start) {
+		n.Windows = append(n.Windows, TransportWindow{Start: start, Transports: make(map[string]TransportOutcomes)})
+	}
+	w := n.Windows[len(n.Windows)-1]
+	o := w.Transports[transport]
+	switch outcome {
+	case TransportCompleted:
+		o.Completed++
+	case TransportRejected:
+		o.Rejected++
+	case TransportFailed:
+		o.Failed++
+	case TransportReset:
+		o.Reset++
+	}
+	w.Transports[transport] = o
+	if now.After(n.LastSeen) {
+		n.LastSeen = now
+	}
+}
+
+// expire drops the windows that are no longer kept once the window starting
+// at start is the current one, and the networks left without any.
+func (t *TransportTelemetry) expire(start time.Time) {
+	oldest := start.Add(-(TransportStatsWindows - 1) * TransportStatsWindow)
+	for network, n := range t.networks {
+		kept := n.Windows[:0]
+		for _, w := range n.Windows {
+			if !w.Start.Before(oldest) {
+				kept = append(kept, w)
+			}
+		}
+		if len(kept) == 0 {
+			delete(t.networks, network)
+			continue
+		}
+		n.Windows = kept
+	}
+}
+
+// Networks returns what was counted per network within the kept windows
+// before now, most recently seen first.
+func (t *TransportTelemetry) Networks(now time.Time) []NetworkTransports {
+	oldest := now.Truncate(TransportStatsWindow).Add(-(TransportStatsWindows - 1) * TransportStatsWindow)
+	t.mu.Lock()
+	list := make([]NetworkTransports, 0, len(t.networks))
+	for _, n := range t.networks {
+		c := NetworkTransports{Network: n.Network, Transports: make(map[string]TransportOutcomes), LastSeen: n.LastSeen}
+		for _, w := range n.Windows {
+			if w.Start.Before(oldest) {
+				continue
+			}
+			transports := make(map[string]TransportOutcomes, len(w.Transports))
+			for transport, o := range w.Transports {
+				transports[transport] = o
+				total := c.Transports[transport]
+				total.add(o)
+				c.Transports[transport] = total
+			}
+			c.Windows = append(c.Windows, TransportWindow{Start: w.Start, Transports: transports})
+		}
+		if len(c.Windows) > 0 {
+			list = append(list, c)
+		}
+	}
+	t.mu.Unlock()
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].LastSeen.After(list[j].LastSeen)
+	})
+	return list
+}
+
+// Totals returns what was counted per transport across all networks within
+// the kept windows before now.
+func (t *TransportTelemetry) Totals(now time.Time) map[string]TransportOutcomes {
+	totals := make(map[string]TransportOutcomes)
+	for _, n := range t.Networks(now) {
+		for transport, o := range n.Transports {
+			total := totals[transport]
+			total.add(o)
+			totals[transport] = total
+		}
+	}
+	return totals
+}
+
+func (t *TransportTelemetry) transport(transport string) *transportMetrics {
+	m := t.metrics[transport]
+	if m == nil {
+		m = &transportMetrics{failures: make(map[string]uint64)}
+		t.metrics[transport] = m
+	}
+	return m
+}
+
+// Handshake measures a handshake over transport that completed in latency.
+func (t *TransportTelemetry) Handshake(transport string, latency time.Duration) {
+	t.mu.Lock()
+	m := t.transport(transport)
+	m.handshakes++
+	m.handshakeTime += latency
+	m.maxHandshake = max(m.maxHandshake, latency)
+	t.mu.Unlock()
+}
+
+// Failure counts a handshake over transport that did not lead to a session,
+// or whose session was reset right away, by why.
+func (t *TransportTelemetry) Failure(transport, reason string) {
+	t.mu.Lock()
+	t.transport(transport).failures[reason]++
+	t.mu.Unlock()
+}
+
+// SessionEnded measures a session over transport that moved bytes of DATA
+// payload in d.
+func (t *TransportTelemetry) SessionEnded(transport string, bytes uint64, d time.Duration) {
+	t.mu.Lock()
+	m := t.transport(transport)
+	m.sessions++
+	m.bytes += bytes
+	m.sessionTime += d
+	t.mu.Unlock()
+}
+
+// Metrics returns the metrics of each transport seen.
+func (t *TransportTelemetry) Metrics() map[string]TransportMetrics {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	metrics := make(map[string]TransportMetrics, len(t.metrics))
+	for transport, m := range t.metrics {
+		c := TransportMetrics{
+			Handshakes:   m.handshakes,
+			MaxHandshake: m.maxHandshake,
+			Failures:     make(map[string]uint64, len(m.failures)),
+			Sessions:     m.sessions,
+			Bytes:        m.bytes,
+		}
+		if m.handshakes > 0 {
+			c.MeanHandshake = m.handshakeTime / time.Duration(m.handshakes)
+		}
+		if m.sessionTime > 0 {
+			c.Throughput = float64(m.bytes) / m.sessionTime.Seconds()
+		}
+		for reason, n := range m.failures {
+			c.Failures[reason] = n
+		}
+		metrics[transport] = c
+	}
+	return metrics
+}
@@ -0,0 +1,148 @@
+package reflex
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// TunnelDialer opens the Reflex session that carries one tunneled
+// connection. address ("host:port") is where the application asked to
+// connect; the session's server is told to send the bytes there.
+type TunnelDialer func(ctx context.Context, address string) (*ClientConn, error)
+
+// NewTunnelDialer returns a TunnelDialer that makes a new connection and
+// handshake with server for every tunneled connection.
+func NewTunnelDialer(server string, opts *ClientOptions) TunnelDialer {
+	return func(ctx context.Context, address string) (*ClientConn, error) {
+		return openTunnel(ctx, server, opts, address)
+	}
+}
+
+// DialSession connects to server and performs the handshake, within ctx,
+// without naming a destination: the server sends the data to its default
+// one, see DefaultDestination.
+func DialSession(ctx context.Context, server string, opts *ClientOptions) (*ClientConn, error) {
+	return dialServer(ctx, server, opts)
+}
+
+// openTunnel opens a session with server for a tunnel to address.
+func openTunnel(ctx context.Context, server string, opts *ClientOptions, address string) (*ClientConn, error) {
+	c, err := dialServer(ctx, server, opts)
+	if err != nil {
+		return nil, err
+	}
+	c.shapeForDestination(opts.DestinationProfiles, address)
+	if err := c.SetDestination(address); err != nil {
+		_ = c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// shapeForDestination shapes what c sends with the profile table maps
+// address to, unless the server already pushed a shape. The profile is
+// copied, since control frames change the one a session shapes with.
+func (c *ClientConn) shapeForDestination(table *DestinationProfiles, address string) {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+	name, ok := table.Lookup(host)
+	if !ok {
+		return
+	}
+	p := Profiles[name]
+	if p == nil {
+		p = c.offeredProfile(name)
+	}
+	if p == nil {
+		return
+	}
+	c.shape.CompareAndSwap(nil, &TrafficProfile{Name: p.Name, PacketSizes: p.PacketSizes, Delays: p.Delays})
+}
+
+// dialServer connects to server, through the upstream proxy if there is
+// one, and performs the handshake, within ctx. A bonded session gets its
+// other lanes as far as they can be opened.
+func dialServer(ctx context.Context, server string, opts *ClientOptions) (*ClientConn, error) {
+	dial := func(ctx context.Context) (net.Conn, error) {
+		if opts.UpstreamProxy != "" {
+			return dialUpstream(ctx, opts.UpstreamProxy, server)
+		}
+		var d net.Dialer
+		return d.DialContext(ctx, "tcp", server)
+	}
+	conn, err := dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	c, err := ClientHandshake(conn, opts)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	_ = conn.SetDeadline(time.Time{})
+	for i := 1; i < opts.Lanes; i++ {
+		lane, err := dial(ctx)
+		if err != nil {
+			break
+		}
+		if err := c.AddLane(lane); err != nil {
+			_ = lane.Close()
+			break
+		}
+	}
+	if opts.Migrate {
+		c.redial = dial
+	}
+	return c, nil
+}
+
+// tunnelChunk is the most a tunnel reads from the application at once, so
+// that DATA frames stay well within a record.
+const tunnelChunk = 16 << 10
+
+// Tunnel carries local over c, both ways, until one side is done, then
+// closes both. A CLOSE frame ends the session for both peers, so when the
+// application stops sending the tunnel ends as well. In low-power mode what
+// the application writes is batched, see PowerMode.
+func Tunnel(local net.Conn, c *ClientConn) {
+	up := make(chan struct{})
+	go func() {
+		defer close(up)
+		b := make([]byte, tunnelChunk)
+		for {
+			n, err := local.Read(b)
+			if n > 0 && err == nil && c.lowPower() {
+				n, err = batchReads(local, b, n)
+			}
+			if n > 0 {
+				if c.WriteFrame(FrameTypeData, b[:n]) != nil {
+					break
+				}
+			}
+			if err != nil {
+				break
+			}
+		}
+		_ = c.Close()
+	}()
+	for {
+		f, err := c.ReadFrame()
+		if err != nil {
+			break
+		}
+		if f.Type != FrameTypeData {
+			continue
+		}
+		if _, err := local.Write(f.Payload); err != nil {
+			break
+		}
+	}
+	_ = local.Close()
+	<-up
+}
@@ -0,0 +1,102 @@
+package reflex
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+)
+
+// FeatureUDP is the policy feature that lets a session carry UDP, e.g. DNS,
+// QUIC or games, over its connection. Each datagram is a DATAGRAM frame of
+// its own, so that datagram boundaries are kept:
+//
+//	destination | length (2, big endian) | data | padding
+//
+// The destination is laid out like the payload of an ADDR frame. The client
+// names where the datagram goes, and the server where the one it sends back
+// came from. The frame may be padded to a profile's packet size like DATA.
+//
+// The server relays the datagrams to each destination over a link of its
+// own, at most MaxStreams of them at once; datagrams to more are dropped,
+// as are those that do not fit in a frame, see MaxDatagram.
+const FeatureUDP = "udp"
+
+// Datagram is a decoded DATAGRAM frame.
+type Datagram struct {
+	Destination Destination
+	Data        []byte // without its padding
+}
+
+// destinationSize returns the size of the destination b starts with, or 0
+// if b does not start with a whole one.
+func destinationSize(b []byte) int {
+	if len(b) < 1 {
+		return 0
+	}
+	size := 0
+	switch b[0] {
+	case AddrTypeIPv4:
+		size = 1 + net.IPv4len + 2
+	case AddrTypeIPv6:
+		size = 1 + net.IPv6len + 2
+	case AddrTypeDomain:
+		if len(b) < 2 {
+			return 0
+		}
+		size = 2 + int(b[1]) + 2
+	}
+	if len(b) < size {
+		return 0
+	}
+	return size
+}
+
+// ParseDatagram decodes a DATAGRAM frame. The data aliases f's payload.
+func ParseDatagram(f *Frame) (*Datagram, error) {
+	if f.Type != FrameTypeDatagram {
+		return nil, errors.New("reflex: not a datagram frame")
+	}
+	size := destinationSize(f.Payload)
+	if size == 0 {
+		return nil, errors.New("reflex: malformed destination")
+	}
+	d, err := UnmarshalDestination(f.Payload[:size])
+	if err != nil {
+		return nil, err
+	}
+	body := f.Payload[size:]
+	if len(body) < 2 || len(body)-2 < int(binary.BigEndian.Uint16(body)) {
+		return nil, errors.New("reflex: malformed datagram frame")
+	}
+	return &Datagram{Destination: d, Data: body[2 : 2+int(binary.BigEndian.Uint16(body))]}, nil
+}
+
+// DatagramPayload returns the payload of a DATAGRAM frame with data for d,
+// which must fit in a frame with the header, see MaxDatagram.
+func DatagramPayload(d Destination, data []byte) []byte {
+	b := binary.BigEndian.AppendUint16(d.Marshal(), uint16(len(data)))
+	return append(b, data...)
+}
+
+// MaxDatagram returns the most data one DATAGRAM frame of s to or from d
+// carries.
+func (s *Session) MaxDatagram(d Destination) int {
+	return min(s.MaxPayload()-len(d.Marshal())-2, 0xFFFF)
+}
+
+// WriteDatagram sends data as one datagram to address ("host:port"). The
+// grant must have FeatureUDP; the datagrams that come back are DATAGRAM
+// frames of ReadFrame, see ParseDatagram.
+func (c *ClientConn) WriteDatagram(address string, data []byte) error {
+	d, err := ParseDestination(address)
+	if err != nil {
+		return err
+	}
+	if !c.Grant.HasFeature(FeatureUDP) {
+		return errors.New("reflex: server did not grant " + FeatureUDP)
+	}
+	if len(data) > c.Session.MaxDatagram(d) {
+		return errors.New("reflex: datagram too large")
+	}
+	return c.WriteFrame(FrameTypeDatagram, DatagramPayload(d, data))
+}
@@ -0,0 +1,78 @@
+package reflex
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// dialUpstream connects to server through the proxy at proxyURL, see
+// ClientOptions.UpstreamProxy.
+func dialUpstream(ctx context.Context, proxyURL, server string) (net.Conn, error) {
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "http":
+		return dialHTTPConnect(ctx, u, server)
+	case "socks5", "socks5h":
+		var auth *proxy.Auth
+		if u.User != nil {
+			auth = &proxy.Auth{User: u.User.Username()}
+			auth.Password, _ = u.User.Password()
+		}
+		d, err := proxy.SOCKS5("tcp", u.Host, auth, &net.Dialer{})
+		if err != nil {
+			return nil, err
+		}
+		return d.(proxy.ContextDialer).DialContext(ctx, "tcp", server)
+	default:
+		return nil, errors.New("reflex: unsupported upstream proxy scheme " + u.Scheme)
+	}
+}
+
+// dialHTTPConnect opens a tunnel to server with a CONNECT request to the HTTP
+// proxy at u, authenticating with the user info of u, if any.
+func dialHTTPConnect(ctx context.Context, u *url.URL, server string) (net.Conn, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", u.Host)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	req := "CONNECT " + server + " HTTP/1.1\r\nHost: " + server + "\r\n"
+	if u.User != nil {
+		password, _ := u.User.Password()
+		req += "Proxy-Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte(u.User.Username()+":"+password)) + "\r\n"
+	}
+	if _, err := io.WriteString(conn, req+"\r\n"); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		_ = conn.Close()
+		return nil, errors.New("reflex: upstream proxy refused the tunnel: " + resp.Status)
+	}
+	_ = conn.SetDeadline(time.Time{})
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
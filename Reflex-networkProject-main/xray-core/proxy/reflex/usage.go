@@ -0,0 +1,79 @@
+package reflex
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// FeatureUsageReports is the policy feature that has the server send the
+// client USAGE frames, so that client UIs can show usage and what is left
+// without asking a panel.
+const FeatureUsageReports = "usage-reports"
+
+// DefaultUsageInterval is the least time between two usage reports to a
+// session. Reports are sent at the start of the session, when its grant is
+// renewed, and then with traffic, since usage only changes with traffic.
+const DefaultUsageInterval = time.Minute
+
+// UsageReport is the payload of a USAGE frame: what the user of the session
+// used and may still use, across all of its sessions.
+type UsageReport struct {
+	Used      uint64 `json:"used"`                // bytes moved, both directions, since the server started
+	Quota     uint64 `json:"quota,omitempty"`     // bytes the account may move, 0 for unlimited
+	Remaining uint64 `json:"remaining,omitempty"` // bytes left of Quota
+	Bandwidth uint64 `json:"bandwidth,omitempty"` // granted bytes per second per direction, 0 for unlimited
+	Tier      string `json:"tier,omitempty"`      // granted tier, see PolicyGrant.Tier
+	Expiry    int64  `json:"expiry,omitempty"`    // when the account expires, Unix seconds; 0 for never
+}
+
+// SendUsage writes a USAGE frame with r.
+func SendUsage(s *Session, w io.Writer, r *UsageReport) error {
+	payload, _ := json.Marshal(r)
+	return s.WriteFrame(w, FrameTypeUsage, payload)
+}
+
+// ParseUsageReport decodes the payload of a USAGE frame.
+func ParseUsageReport(b []byte) (*UsageReport, error) {
+	r := &UsageReport{}
+	if err := json.Unmarshal(b, r); err != nil {
+		return nil, errors.New("reflex: malformed usage report")
+	}
+	return r, nil
+}
+
+// UsageMeter counts the traffic of each user, for quotas and usage reports.
+// It is safe for concurrent use.
+type UsageMeter struct {
+	mu   sync.Mutex
+	used map[string]uint64
+}
+
+// NewUsageMeter returns a UsageMeter that counted nothing yet.
+func NewUsageMeter() *UsageMeter {
+	return &UsageMeter{used: make(map[string]uint64)}
+}
+
+// Add counts n bytes of user's traffic and returns its usage so far.
+func (m *UsageMeter) Add(user string, n int) uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.used[user] += uint64(n)
+	return m.used[user]
+}
+
+// Used returns the bytes counted for user.
+func (m *UsageMeter) Used(user string) uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.used[user]
+}
+
+// Reset forgets the usage of user, e.g. at the start of a billing period.
+func (m *UsageMeter) Reset(user string) {
+	m.mu.Lock()
+	delete(m.used, user)
+	m.mu.Unlock()
+}
@@ -0,0 +1,76 @@
+package tests
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/xtls/xray-core/common/protocol"
+	"github.com/xtls/xray-core/common/serial"
+	"github.com/xtls/xray-core/proxy"
+	"github.com/xtls/xray-core/proxy/reflex"
+)
+
+func TestReflexAccountToMemoryUser(t *testing.T) {
+	account := &reflex.Account{
+		Id:     uuid.NewString(),
+		Policy: "premium",
+		Psk:    base64.StdEncoding.EncodeToString(make([]byte, reflex.MinPSKSize)),
+		Expiry: time.Now().Add(time.Hour).Unix(),
+	}
+	user, err := (&protocol.User{Email: "a@example", Level: 2, Account: serial.ToTypedMessage(account)}).ToMemoryUser()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mem, ok := user.Account.(*reflex.MemoryAccount)
+	if !ok {
+		t.Fatalf("account converted to %T", user.Account)
+	}
+	if mem.Id != account.Id || mem.Policy != "premium" || len(mem.PSK) != reflex.MinPSKSize || mem.Expired(time.Now()) {
+		t.Fatalf("account converted to %+v", mem)
+	}
+	if !proto.Equal(mem.ToProto(), account) {
+		t.Fatalf("ToProto returned %v, want %v", mem.ToProto(), account)
+	}
+
+	account.Psk = base64.StdEncoding.EncodeToString([]byte("short"))
+	if _, err := account.AsAccount(); err == nil {
+		t.Fatal("short psk accepted")
+	}
+}
+
+func TestReflexExpiredAccount(t *testing.T) {
+	handler, _ := newReflexTestHandlerWithClient(t)
+	um := handler.(proxy.UserManager)
+	ctx := context.Background()
+	addr := serveReflexReplyPort(t, handler, "pong")
+
+	add := func(expiry time.Time) uuid.UUID {
+		id := uuid.New()
+		user, err := (&protocol.User{Account: serial.ToTypedMessage(&reflex.Account{Id: id.String(), Expiry: expiry.Unix()})}).ToMemoryUser()
+		if err != nil {
+			t.Fatal(err)
+		}
+		user.Email = id.String()
+		if err := um.AddUser(ctx, user); err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	valid := add(time.Now().Add(time.Hour))
+	expired := add(time.Now().Add(-time.Minute))
+
+	c, err := dialReflexClient(t, addr, valid)
+	if err != nil {
+		t.Fatalf("unexpired account must be accepted: %v", err)
+	}
+	pingReflexSession(t, c)
+	if _, err := dialReflexClient(t, addr, expired); !errors.Is(err, reflex.ErrHandshakeRejected) {
+		t.Fatalf("expired account must be rejected, got %v", err)
+	}
+}
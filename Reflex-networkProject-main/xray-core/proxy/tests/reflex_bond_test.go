@@ -0,0 +1,95 @@
+package tests
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/xtls/xray-core/proxy/reflex"
+)
+
+// countingConn counts the writes made to it.
+type countingConn struct {
+	net.Conn
+	writes atomic.Int64
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	c.writes.Add(1)
+	return c.Conn.Write(b)
+}
+
+func TestReflexBonding(t *testing.T) {
+	handler, userID := newReflexTestHandlerWithClient(t)
+	addr := serveReflexReplyPort(t, handler, "pong")
+
+	var lanes []*countingConn
+	dial := func() *countingConn {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = conn.Close() })
+		lane := &countingConn{Conn: conn}
+		lanes = append(lanes, lane)
+		return lane
+	}
+	c, err := reflex.ClientHandshake(dial(), &reflex.ClientOptions{UserID: userID, Lanes: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.Grant.HasFeature(reflex.FeatureBonding) {
+		t.Fatal("bonding was not granted")
+	}
+	for i := 0; i < 2; i++ {
+		if err := c.AddLane(dial()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if c.Lanes() != 3 {
+		t.Fatalf("expected 3 lanes, got %d", c.Lanes())
+	}
+	// The dispatcher takes up to 16 requests.
+	for i := 0; i < 12; i++ {
+		pingReflexSession(t, c)
+	}
+	for i, lane := range lanes {
+		// The handshake and the bonding request are one write each.
+		if lane.writes.Load() < 2 {
+			t.Fatalf("lane %d carried no frames", i)
+		}
+	}
+
+	// The session survives losing a lane.
+	_ = lanes[1].Conn.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for c.Lanes() != 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("lost lane was not dropped, %d lanes", c.Lanes())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for i := 0; i < 3; i++ {
+		pingReflexSession(t, c)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	plain, err := dialReflexClient(t, addr, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plain.Lanes() != 1 {
+		t.Fatalf("unbonded session reports %d lanes", plain.Lanes())
+	}
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := plain.AddLane(conn); err == nil {
+		t.Fatal("a lane was added to an unbonded session")
+	}
+}
@@ -0,0 +1,125 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/xtls/xray-core/common"
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/proxy/reflex/inbound"
+)
+
+type pcapPacket struct {
+	srcPort, dstPort uint16
+	flags            byte
+	payload          []byte
+	origLen          int
+}
+
+// readReflexPcap parses a raw-IPv4 pcap file written by the capture tap.
+func readReflexPcap(t *testing.T, path string) []pcapPacket {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) < 24 || binary.LittleEndian.Uint32(data) != 0xa1b2c3d4 || binary.LittleEndian.Uint32(data[20:]) != 101 {
+		t.Fatal("not a raw IP pcap file")
+	}
+	var packets []pcapPacket
+	for rest := data[24:]; len(rest) > 0; {
+		capLen := int(binary.LittleEndian.Uint32(rest[8:]))
+		origLen := int(binary.LittleEndian.Uint32(rest[12:]))
+		pkt := rest[16 : 16+capLen]
+		rest = rest[16+capLen:]
+		tcp := pkt[20:]
+		packets = append(packets, pcapPacket{
+			srcPort: binary.BigEndian.Uint16(tcp),
+			dstPort: binary.BigEndian.Uint16(tcp[2:]),
+			flags:   tcp[13],
+			payload: tcp[20:],
+			origLen: origLen,
+		})
+	}
+	return packets
+}
+
+func captureReflexSessions(t *testing.T, headersOnly bool) (path string, captured, ignored uint16) {
+	t.Helper()
+	captureUser, otherUser := uuid.New(), uuid.New()
+	path = filepath.Join(t.TempDir(), "reflex.pcap")
+	handler, err := inbound.New(context.Background(), &reflex.InboundConfig{
+		Clients:      []*reflex.User{{Id: captureUser.String()}, {Id: otherUser.String()}},
+		Capture:      &reflex.Capture{Path: path, Users: []string{captureUser.String()}, HeadersOnly: headersOnly},
+		DrainTimeout: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := serveReflexReplyPort(t, handler, "pong")
+
+	ports := make([]uint16, 0, 2)
+	for _, id := range []uuid.UUID{captureUser, otherUser} {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+		c, err := reflex.ClientHandshake(conn, &reflex.ClientOptions{UserID: id})
+		if err != nil {
+			t.Fatal(err)
+		}
+		pingReflexSession(t, c)
+		ports = append(ports, uint16(conn.LocalAddr().(*net.TCPAddr).Port))
+		conn.Close()
+	}
+	if err := handler.(common.Closable).Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path, ports[0], ports[1]
+}
+
+func TestReflexCaptureSelectedSession(t *testing.T) {
+	path, captured, ignored := captureReflexSessions(t, false)
+	packets := readReflexPcap(t, path)
+	if len(packets) < 5 {
+		t.Fatalf("expected handshake and data packets, got %d", len(packets))
+	}
+	if packets[0].flags != 0x02 || packets[0].srcPort != captured {
+		t.Fatalf("capture must start with the client's SYN, got %+v", packets[0])
+	}
+	var fromServer []byte
+	for _, p := range packets {
+		if p.srcPort == ignored || p.dstPort == ignored {
+			t.Fatal("session of an unselected user was captured")
+		}
+		if p.dstPort == captured {
+			fromServer = append(fromServer, p.payload...)
+		}
+	}
+	if !bytes.HasPrefix(fromServer, []byte("HTTP/1.1 200")) {
+		t.Fatalf("server side of the handshake missing, got %q", fromServer[:min(len(fromServer), 16)])
+	}
+}
+
+func TestReflexCaptureHeadersOnly(t *testing.T) {
+	path, _, _ := captureReflexSessions(t, true)
+	sized := false
+	for _, p := range readReflexPcap(t, path) {
+		if len(p.payload) != 0 {
+			t.Fatal("payload written in headers-only mode")
+		}
+		sized = sized || p.origLen > 40
+	}
+	if !sized {
+		t.Fatal("packet sizes must be kept in headers-only mode")
+	}
+}
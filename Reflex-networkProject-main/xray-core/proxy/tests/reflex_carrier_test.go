@@ -0,0 +1,204 @@
+package tests
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/xtls/xray-core/common"
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/common/protocol/tls/cert"
+	"github.com/xtls/xray-core/common/session"
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/proxy/reflex/carrier"
+	"github.com/xtls/xray-core/proxy/reflex/inbound"
+	"github.com/xtls/xray-core/proxy/reflex/outbound"
+	"github.com/xtls/xray-core/transport"
+	"github.com/xtls/xray-core/transport/internet/stat"
+	"github.com/xtls/xray-core/transport/pipe"
+)
+
+func TestReflexQUICCarrierHandshake(t *testing.T) {
+	handler, userID := newReflexTestHandlerWithClient(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	certPEM, keyPEM := cert.MustGenerate(nil, cert.CommonName("localhost")).ToPEM()
+	serverCert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := carrier.ListenQUIC("127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{serverCert}}, carrier.InboundHandler(ctx, handler, nil))
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+
+	dialCtx, dialCancel := context.WithTimeout(ctx, 5*time.Second)
+	defer dialCancel()
+	dialer, err := carrier.DialQUIC(dialCtx, ln.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer dialer.Close()
+
+	conn, err := dialer.Open(dialCtx)
+	if err != nil {
+		t.Fatalf("open stream: %v", err)
+	}
+	defer conn.Close()
+
+	var pub [32]byte
+	_, _ = rand.Read(pub[:])
+	if _, err := conn.Write(buildReflexMagicHandshakeWithKey(userID, time.Now().Unix(), pub)); err != nil {
+		t.Fatalf("write handshake: %v", err)
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	statusLine, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatalf("read status line: %v", err)
+	}
+	if !strings.Contains(statusLine, "200") {
+		t.Fatalf("expected HTTP 200 over QUIC carrier, got %q", statusLine)
+	}
+}
+
+func TestReflexQUICCarrierOutbound(t *testing.T) {
+	certPEM, keyPEM := cert.MustGenerate(nil, cert.CommonName("localhost")).ToPEM()
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	common.Must(os.WriteFile(certFile, certPEM, 0o600))
+	common.Must(os.WriteFile(keyFile, keyPEM, 0o600))
+	private, public, err := reflex.GenerateServerKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The server answers every destination with "pong".
+	backend := inbound.DialerFunc(func(ctx context.Context, dest xnet.Destination) (net.Conn, error) {
+		a, b := net.Pipe()
+		go func() {
+			defer b.Close()
+			if _, err := b.Read(make([]byte, 64)); err == nil {
+				_, _ = b.Write([]byte("pong"))
+			}
+		}()
+		return a, nil
+	})
+	u := uuid.New()
+	handler, err := inbound.NewWithDialer(context.Background(), &reflex.InboundConfig{
+		Clients:      []*reflex.User{{Id: u.String()}},
+		ServerKey:    private,
+		DrainTimeout: 1,
+		Carriers: &reflex.Carriers{
+			Quic: &reflex.QUICCarrier{Listen: "127.0.0.1:0", CertificateFile: certFile, KeyFile: keyFile},
+		},
+	}, backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer handler.Close()
+
+	ob, err := outbound.New(context.Background(), &reflex.OutboundConfig{
+		Address:   "127.0.0.1",
+		Port:      uint32(handler.QUICAddr().(*net.UDPAddr).Port),
+		Id:        u.String(),
+		ServerKey: public,
+		Carrier:   reflex.CarrierQUIC,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ob.Close()
+	dialer := &countingDialer{}
+	pingReflexOutbound(t, ob, dialer)
+	pingReflexOutbound(t, ob, dialer)
+	if n := dialer.dials.Load(); n != 0 {
+		t.Fatalf("%d TCP dials, want none over the QUIC carrier", n)
+	}
+
+	// Without the server key to authenticate the server, its certificate
+	// must be valid.
+	unverified, err := outbound.New(context.Background(), &reflex.OutboundConfig{
+		Address: "127.0.0.1",
+		Port:    uint32(handler.QUICAddr().(*net.UDPAddr).Port),
+		Id:      u.String(),
+		Carrier: reflex.CarrierQUIC,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unverified.Close()
+	ctx := session.ContextWithOutbounds(context.Background(), []*session.Outbound{{Target: xnet.TCPDestination(xnet.DomainAddress("example.com"), 80)}})
+	upReader, _ := pipe.New(pipe.WithoutSizeLimit())
+	_, downWriter := pipe.New(pipe.WithoutSizeLimit())
+	if err := unverified.Process(ctx, &transport.Link{Reader: upReader, Writer: downWriter}, dialer); err == nil {
+		t.Fatal("a self-signed certificate was accepted")
+	}
+}
+
+func TestReflexChannelsShareOneConnection(t *testing.T) {
+	handler, userID := newReflexTestHandlerWithClient(t)
+
+	clientConn, serverConn := net.Pipe()
+	defer serverConn.Close()
+
+	go func() {
+		_ = handler.Process(context.Background(), xnet.Network_TCP, stat.Connection(serverConn), nil)
+	}()
+
+	client, err := carrier.NewChannelClient(clientConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// An unknown user on one channel must not disturb the other channel.
+	statuses := make(chan string, 2)
+	for _, id := range []uuid.UUID{uuid.New(), userID} {
+		ch, err := client.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		go func(ch net.Conn, id uuid.UUID) {
+			defer ch.Close()
+			var pub [32]byte
+			_, _ = rand.Read(pub[:])
+			if _, err := ch.Write(buildReflexMagicHandshakeWithKey(id, time.Now().Unix(), pub)); err != nil {
+				statuses <- "write: " + err.Error()
+				return
+			}
+			_ = ch.SetReadDeadline(time.Now().Add(5 * time.Second))
+			line, err := bufio.NewReader(ch).ReadString('\n')
+			if err != nil {
+				statuses <- "read: " + err.Error()
+				return
+			}
+			if id == userID {
+				statuses <- "user " + line
+			} else {
+				statuses <- "stranger " + line
+			}
+		}(ch, id)
+	}
+
+	var got []string
+	for i := 0; i < 2; i++ {
+		got = append(got, <-statuses)
+	}
+	joined := strings.Join(got, "|")
+	if !strings.Contains(joined, "user HTTP/1.1 200") || !strings.Contains(joined, "stranger HTTP/1.1 403") {
+		t.Fatalf("unexpected channel results: %q", got)
+	}
+}
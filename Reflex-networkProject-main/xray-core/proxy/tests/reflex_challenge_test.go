@@ -0,0 +1,67 @@
+package tests
+
+import (
+	"bufio"
+	"io"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/xtls/xray-core/proxy/reflex"
+)
+
+// provokeReflexChallenge sends enough unknown frames to be flagged and
+// returns the CHALLENGE frame the server answers with.
+func provokeReflexChallenge(t *testing.T, session *reflex.Session, conn io.Writer, reader *bufio.Reader) *reflex.Frame {
+	t.Helper()
+	for i := 0; i <= reflex.MaxUnknownFrames; i++ {
+		if err := session.WriteFrame(conn, 0x7F, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f, err := session.ReadFrame(reader)
+	if err != nil {
+		t.Fatalf("failed to read challenge: %v", err)
+	}
+	if f.Type != reflex.FrameTypeChallenge || len(f.Payload) != reflex.ChallengeSize {
+		t.Fatalf("expected challenge frame, got type %d", f.Type)
+	}
+	return f
+}
+
+func TestReflexChallengeAnswered(t *testing.T) {
+	handler, userID := newReflexTestHandlerWithClient(t)
+	dispatcher := newReflexReplyDispatcher("pong")
+	session, conn, reader := dialReflexSession(t, handler, userID, dispatcher)
+
+	challenge := provokeReflexChallenge(t, session, conn, reader)
+	if err := reflex.AnswerChallenge(session, conn, userID[:], challenge); err != nil {
+		t.Fatal(err)
+	}
+
+	// The session keeps working after a correct answer.
+	if err := session.WriteFrame(conn, reflex.FrameTypeData, []byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	f, err := session.ReadFrame(reader)
+	if err != nil {
+		t.Fatalf("session terminated after correct answer: %v", err)
+	}
+	if f.Type != reflex.FrameTypeData {
+		t.Fatalf("expected data frame, got type %d", f.Type)
+	}
+}
+
+func TestReflexChallengeFailed(t *testing.T) {
+	handler, userID := newReflexTestHandlerWithClient(t)
+	session, conn, reader := dialReflexSession(t, handler, userID, nil)
+
+	challenge := provokeReflexChallenge(t, session, conn, reader)
+	wrong := uuid.New()
+	if err := reflex.AnswerChallenge(session, conn, wrong[:], challenge); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := session.ReadFrame(reader); err == nil {
+		t.Fatal("session must be terminated after a wrong answer")
+	}
+}
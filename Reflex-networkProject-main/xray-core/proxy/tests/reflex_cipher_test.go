@@ -0,0 +1,86 @@
+package tests
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/xtls/xray-core/proxy/reflex"
+)
+
+func TestReflexChooseCipher(t *testing.T) {
+	for _, tc := range []struct {
+		offered   []string
+		preferred reflex.Cipher
+		expected  reflex.Cipher
+	}{
+		{[]string{"aes-256-gcm"}, reflex.CipherAES256GCM, reflex.CipherAES256GCM},
+		{nil, reflex.CipherAES256GCM, reflex.CipherChaCha20Poly1305},
+		{[]string{"aes-256-gcm"}, reflex.CipherChaCha20Poly1305, reflex.CipherChaCha20Poly1305},
+		{[]string{"twofish"}, reflex.CipherAES256GCM, reflex.CipherChaCha20Poly1305},
+	} {
+		if got := reflex.ChooseCipher(tc.offered, tc.preferred); got != tc.expected {
+			t.Fatalf("%v preferring %v: chose %v", tc.offered, tc.preferred, got)
+		}
+	}
+	if got := reflex.OfferedCiphers(); reflex.HasAESHardware != (len(got) == 1 && got[0] == "aes-256-gcm") {
+		t.Fatalf("AES hardware %v, offered %v", reflex.HasAESHardware, got)
+	}
+	if err := (&reflex.PolicyGrant{Version: reflex.PolicyVersion, Profile: reflex.DefaultProfileName, Cipher: "twofish"}).Enforceable(); err == nil {
+		t.Fatal("a grant with an unknown cipher is enforceable")
+	}
+}
+
+func TestReflexSessionAESGCM(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 32)
+	client, err := reflex.NewClientSession(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := reflex.NewServerSession(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []*reflex.Session{client, server} {
+		if err := s.SetCipher(reflex.CipherAES256GCM); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var wire bytes.Buffer
+	for _, payload := range []string{"before", "after"} {
+		if err := client.WriteFrame(&wire, reflex.FrameTypeData, []byte(payload)); err != nil {
+			t.Fatal(err)
+		}
+		f, err := server.ReadFrame(&wire)
+		if err != nil || string(f.Payload) != payload {
+			t.Fatalf("read %v, %v", f, err)
+		}
+		// Rekeyed epochs keep the cipher.
+		if err := client.Rekey(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := client.SetCipher(reflex.CipherChaCha20Poly1305); err == nil {
+		t.Fatal("the cipher changed after the first frame")
+	}
+
+	// A peer on ChaCha20-Poly1305 cannot read the frames.
+	other, err := reflex.NewServerSession(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.WriteFrame(&wire, reflex.FrameTypeData, []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.ReadFrame(&wire); err == nil {
+		t.Fatal("a ChaCha20-Poly1305 session opened an AES-GCM frame")
+	}
+
+	restored, err := reflex.RestoreSession(server.State())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.Cipher() != reflex.CipherAES256GCM {
+		t.Fatalf("restored session uses %v", restored.Cipher())
+	}
+}
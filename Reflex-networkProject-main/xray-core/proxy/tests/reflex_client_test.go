@@ -0,0 +1,81 @@
+package tests
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/transport/internet/stat"
+)
+
+func TestReflexClientHandshakePolicy(t *testing.T) {
+	handler, userID := newReflexTestHandlerWithClient(t)
+
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	go func() {
+		_ = handler.Process(context.Background(), xnet.Network_TCP, stat.Connection(serverConn), newReflexReplyDispatcher("pong"))
+	}()
+	_ = clientConn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	opts := &reflex.ClientOptions{
+		UserID: userID,
+		Secret: userID[:],
+		Policy: &reflex.PolicyReq{Profile: "zoom", Bandwidth: 1 << 20},
+	}
+	c, err := reflex.ClientHandshake(clientConn, opts)
+	if err != nil {
+		t.Fatalf("client handshake failed: %v", err)
+	}
+	if c.Grant.Version != reflex.PolicyVersion || c.Grant.Profile != "zoom" || c.Grant.Bandwidth != 1<<20 {
+		t.Fatalf("unexpected grant %+v", c.Grant)
+	}
+
+	// A renewed grant is applied before the next application frame is returned.
+	if err := c.RenewPolicy(&reflex.PolicyReq{Profile: "youtube"}); err != nil {
+		t.Fatal(err)
+	}
+	// net.Pipe is unbuffered, so read while the data frame is written.
+	type result struct {
+		f   *reflex.Frame
+		err error
+	}
+	read := make(chan result, 1)
+	go func() {
+		f, err := c.ReadFrame()
+		read <- result{f, err}
+	}()
+	if err := c.WriteFrame(reflex.FrameTypeData, []byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	r := <-read
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	f := r.f
+	// Replies are padded to the profile's packet sizes, and come without it.
+	if f.Type != reflex.FrameTypeData || string(f.Payload) != "pong" {
+		t.Fatalf("unexpected frame type %d payload %q", f.Type, f.Payload)
+	}
+	if c.Grant.Profile != "youtube" || c.Grant.Bandwidth != 0 {
+		t.Fatalf("renewed grant not applied: %+v", c.Grant)
+	}
+}
+
+func TestReflexClientHandshakeRejected(t *testing.T) {
+	handler, _ := newReflexTestHandlerWithClient(t)
+
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	go func() {
+		_ = handler.Process(context.Background(), xnet.Network_TCP, stat.Connection(serverConn), nil)
+	}()
+	_ = clientConn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if _, err := reflex.ClientHandshake(clientConn, &reflex.ClientOptions{}); err != reflex.ErrHandshakeRejected {
+		t.Fatalf("unknown user must be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,67 @@
+package tests
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/xtls/xray-core/proxy/reflex"
+)
+
+func TestReflexClientStats(t *testing.T) {
+	handler, userID := newReflexTestHandlerWithClient(t)
+	addr := serveReflexReplyPort(t, handler, "pong")
+	stats := &reflex.ClientStats{}
+	dial := func() *reflex.ClientConn {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = conn.Close() })
+		_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+		c, err := reflex.ClientHandshake(conn, &reflex.ClientOptions{UserID: userID, Stats: stats})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	first, second := dial(), dial()
+	pingReflexSession(t, first)
+	pingReflexSession(t, first)
+	pingReflexSession(t, second)
+
+	s := first.Stats()
+	if s.BytesSent != 8 || s.FramesSent != 2 || s.FramesReceived != 2 || s.BytesReceived < 8 || s.HandshakeRTT <= 0 {
+		t.Fatalf("session stats %+v", s)
+	}
+	snap := stats.Snapshot()
+	if snap.Sessions != 2 || snap.Active != 2 || snap.BytesSent != 12 || snap.BytesReceived != s.BytesReceived+second.Stats().BytesReceived || snap.HandshakeRTT <= 0 {
+		t.Fatalf("aggregate stats %+v", snap)
+	}
+	first.Close()
+	first.Close()
+	if snap := stats.Snapshot(); snap.Active != 1 {
+		t.Fatalf("%d sessions active after closing one", snap.Active)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rates := make(chan float64)
+	go stats.Watch(ctx, 20*time.Millisecond, func(_ reflex.ClientStatsSnapshot, sendRate, _ float64) {
+		select {
+		case rates <- sendRate:
+		case <-ctx.Done():
+		}
+	})
+	<-rates
+	pingReflexSession(t, second)
+	for i := 0; ; i++ {
+		if rate := <-rates; rate > 0 {
+			break
+		}
+		if i == 10 {
+			t.Fatal("no send rate after a ping")
+		}
+	}
+}
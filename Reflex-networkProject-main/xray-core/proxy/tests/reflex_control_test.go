@@ -0,0 +1,124 @@
+package tests
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/proxy/reflex/inbound"
+)
+
+func TestReflexServerPushedShape(t *testing.T) {
+	handler, userID := newReflexTestHandlerWithClient(t)
+	h := handler.(*inbound.Handler)
+	addr := serveReflexReplyPort(t, handler, "pong")
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	rec := &writeRecorder{Conn: conn}
+	c, err := reflex.ClientHandshake(rec, &reflex.ClientOptions{UserID: userID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pingReflexSession(t, c)
+	if c.Shape() != nil {
+		t.Fatal("client shapes traffic before the server asked it to")
+	}
+	// lastRecord pings and returns the size of the ping's record: length,
+	// nonce, tag and frame type around the shaped payload.
+	lastRecord := func() int {
+		pingReflexSession(t, c)
+		return len(rec.writes[len(rec.writes)-1])
+	}
+
+	fixed := &reflex.TrafficProfile{Name: "fixed", PacketSizes: []reflex.PacketSizeDist{{Size: 700, Weight: 1}}}
+	if n, err := h.PushProfile(userID.String(), fixed); err != nil || n != 1 {
+		t.Fatalf("profile pushed to %d sessions: %v", n, err)
+	}
+	pingReflexSession(t, c) // reads the update along with the reply
+	if c.Shape() == nil || c.Profile != "fixed" {
+		t.Fatalf("client did not take the pushed profile, shaping with %+v", c.Shape())
+	}
+	if n := lastRecord(); n != 2+12+16+1+700 {
+		t.Fatalf("shaped frame took %d bytes on the wire", n)
+	}
+
+	if n, err := h.PushControl("", reflex.FrameTypePaddingCtrl, reflex.PaddingControl(300)); err != nil || n != 1 {
+		t.Fatalf("control frame pushed to %d sessions: %v", n, err)
+	}
+	pingReflexSession(t, c)
+	if n := lastRecord(); n != 2+12+16+1+300 {
+		t.Fatalf("frame after PADDING_CTRL took %d bytes on the wire", n)
+	}
+	if n := lastRecord(); n != 2+12+16+1+700 {
+		t.Fatalf("PADDING_CTRL must only apply once, frame took %d bytes", n)
+	}
+
+	if n, _ := h.PushControl("nobody@example.com", reflex.FrameTypeTimingCtrl, reflex.TimingControl(time.Millisecond)); n != 0 {
+		t.Fatalf("control frame for another user reached %d sessions", n)
+	}
+	if _, err := h.PushControl("", reflex.FrameTypeData, nil); err == nil {
+		t.Fatal("DATA frame pushed as a control frame")
+	}
+	if _, err := h.PushProfile("", &reflex.TrafficProfile{Name: "empty"}); err == nil {
+		t.Fatal("profile without packet sizes pushed")
+	}
+}
+
+func TestReflexProfileRollout(t *testing.T) {
+	handler, userID := newReflexTestHandlerWithClient(t)
+	h := handler.(*inbound.Handler)
+	addr := serveReflexReplyPort(t, handler, "pong")
+	dial := func(opts reflex.ClientOptions) *reflex.ClientConn {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = conn.Close() })
+		_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+		opts.UserID = userID
+		c, err := reflex.ClientHandshake(conn, &opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pingReflexSession(t, c)
+		return c
+	}
+	keeping := dial(reflex.ClientOptions{})
+	refusing := dial(reflex.ClientOptions{RefuseProfiles: true})
+
+	captured := reflex.CreateProfileFromCapture("captured", []int{900, 900, 1100}, []time.Duration{time.Millisecond})
+	if n, err := h.RolloutProfile(captured); err != nil || n != 2 {
+		t.Fatalf("profile offered to %d sessions: %v", n, err)
+	}
+	pingReflexSession(t, keeping)
+	pingReflexSession(t, refusing)
+	if p := keeping.OfferedProfiles()["captured"]; p == nil || len(p.PacketSizes) != 2 {
+		t.Fatalf("client kept %+v", keeping.OfferedProfiles())
+	}
+	if keeping.Shape() != nil {
+		t.Fatal("an offered profile must not change the shape")
+	}
+	if len(refusing.OfferedProfiles()) != 0 {
+		t.Fatal("client that opted out kept an offered profile")
+	}
+
+	// Sessions started later are offered it too, and clients may vet it.
+	var asked []string
+	late := dial(reflex.ClientOptions{AcceptProfile: func(p *reflex.TrafficProfile) bool {
+		asked = append(asked, p.Name)
+		return false
+	}})
+	if len(asked) != 1 || asked[0] != "captured" || len(late.OfferedProfiles()) != 0 {
+		t.Fatalf("late client was asked about %v and kept %v", asked, late.OfferedProfiles())
+	}
+
+	if _, err := h.RolloutProfile(&reflex.TrafficProfile{Name: "zoom", PacketSizes: []reflex.PacketSizeDist{{Size: 100, Weight: 1}}}); err == nil {
+		t.Fatal("built-in profile redefined")
+	}
+}
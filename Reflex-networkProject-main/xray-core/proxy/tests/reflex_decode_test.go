@@ -0,0 +1,185 @@
+package tests
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/curve25519"
+
+	"github.com/xtls/xray-core/common"
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/proxy/reflex/capture"
+	"github.com/xtls/xray-core/proxy/reflex/decode"
+	"github.com/xtls/xray-core/proxy/reflex/inbound"
+)
+
+// captureReflexSessionKey runs one captured session and returns the capture
+// and the session key the client derived.
+func captureReflexSessionKey(t *testing.T) (path string, key []byte) {
+	t.Helper()
+	userID := uuid.New()
+	path = filepath.Join(t.TempDir(), "reflex.pcap")
+	handler, err := inbound.New(context.Background(), &reflex.InboundConfig{
+		Clients:      []*reflex.User{{Id: userID.String()}},
+		Capture:      &reflex.Capture{Path: path},
+		DrainTimeout: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := serveReflexReplyPort(t, handler, "pong")
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	var priv, pub [32]byte
+	_, _ = rand.Read(priv[:])
+	curve25519.ScalarBaseMult(&pub, &priv)
+	hs := buildReflexMagicHandshakeFull(userID, time.Now().Unix(), pub, nil, reflex.NewHandshakePadding())
+	if _, err := conn.Write(hs); err != nil {
+		t.Fatal(err)
+	}
+	reader := bufio.NewReader(conn)
+	resp := readReflexHandshakeResponse(t, reader)
+	var shared [32]byte
+	curve25519.ScalarMult(&shared, &priv, &resp.PublicKey)
+	transcript := reflex.NewTranscript()
+	transcript.Write(hs[4:])
+	transcript.Write(resp.PublicKey[:])
+	key = reflex.DeriveSessionKey(shared, hs[4+32+16+8:4+32+16+8+16], transcript.Sum())
+
+	session, err := reflex.NewClientSession(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := session.WriteFrame(conn, reflex.FrameTypeData, []byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	if f, err := session.ReadFrame(reader); err != nil || string(f.Payload) != "pong" {
+		t.Fatalf("no reply to ping: %v", err)
+	}
+	_ = conn.Close()
+	if err := handler.(common.Closable).Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path, key
+}
+
+func readReflexStreams(t *testing.T, path string) []*capture.Stream {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	streams, err := capture.ReadStreams(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(streams) != 1 {
+		t.Fatalf("read %d streams, want 1", len(streams))
+	}
+	return streams
+}
+
+func TestReflexDecodeCapture(t *testing.T) {
+	path, key := captureReflexSessionKey(t)
+	stream := readReflexStreams(t, path)[0]
+
+	s, err := decode.Decode(stream, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.KeyConfirmed || s.Err != nil {
+		t.Fatalf("decoding failed: confirmed=%v, %v", s.KeyConfirmed, s.Err)
+	}
+	var ping, pong bool
+	for _, f := range s.Frames {
+		if f.Type != reflex.FrameTypeData || f.WireSize <= len(f.Payload) {
+			continue
+		}
+		ping = ping || f.FromClient && string(f.Payload) == "ping"
+		pong = pong || !f.FromClient && string(f.Payload) == "pong"
+	}
+	if !ping || !pong {
+		t.Fatalf("frames %+v miss the exchange", s.Frames)
+	}
+
+	wrong := make([]byte, 32)
+	s, err = decode.Decode(stream, wrong)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !errors.Is(s.Err, decode.ErrWrongKey) || len(s.Frames) != 0 {
+		t.Fatalf("wrong key decoded %d frames, %v", len(s.Frames), s.Err)
+	}
+}
+
+func TestReflexDecodeKeyLog(t *testing.T) {
+	userID := uuid.New()
+	dir := t.TempDir()
+	capturePath, keyLogPath := filepath.Join(dir, "reflex.pcap"), filepath.Join(dir, "keys.log")
+	handler, err := inbound.New(context.Background(), &reflex.InboundConfig{
+		Clients:      []*reflex.User{{Id: userID.String()}},
+		Capture:      &reflex.Capture{Path: capturePath},
+		KeyLog:       keyLogPath,
+		DrainTimeout: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := serveReflexReplyPort(t, handler, "pong")
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	var clientLog bytes.Buffer
+	c, err := reflex.ClientHandshake(conn, &reflex.ClientOptions{UserID: userID, KeyLogWriter: &clientLog})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pingReflexSession(t, c)
+	_ = conn.Close()
+	if err := handler.(common.Closable).Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	serverLog, err := os.ReadFile(keyLogPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(clientLog.String(), reflex.KeyLogLabel+" ") || clientLog.String() != string(serverLog) {
+		t.Fatalf("client logged %q, server %q", clientLog.String(), serverLog)
+	}
+	// Lines of a TLS key log sharing the file are skipped.
+	keys, err := reflex.ReadKeyLog(strings.NewReader("# comment\nCLIENT_RANDOM 00 11\n" + string(serverLog)))
+	if err != nil || len(keys) != 1 {
+		t.Fatalf("read %d keys: %v", len(keys), err)
+	}
+
+	stream := readReflexStreams(t, capturePath)[0]
+	s, err := decode.DecodeKeyLog(stream, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.KeyConfirmed || s.Err != nil || len(s.Frames) < 2 {
+		t.Fatalf("decoded %d frames: confirmed=%v, %v", len(s.Frames), s.KeyConfirmed, s.Err)
+	}
+	if _, err := decode.DecodeKeyLog(stream, nil); !errors.Is(err, decode.ErrNoKey) {
+		t.Fatalf("decoding without the key returned %v", err)
+	}
+}
@@ -0,0 +1,144 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/xtls/xray-core/common"
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/proxy/reflex/decoy"
+	"github.com/xtls/xray-core/proxy/reflex/inbound"
+)
+
+// serveDecoyOrigin is a small benign site that counts the requests it gets.
+func serveDecoyOrigin(t *testing.T) (string, *atomic.Int32) {
+	t.Helper()
+	var hits atomic.Int32
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			_, _ = io.WriteString(w, `<html><link href="/style.css"><a href='about'>About</a><a href="https://elsewhere.example/">x</a></html>`)
+		case "/about":
+			w.Header().Set("Content-Type", "text/html")
+			_, _ = io.WriteString(w, "about the bakery")
+		case "/style.css":
+			w.Header().Set("Content-Type", "text/css")
+			_, _ = io.WriteString(w, "body{}")
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = io.WriteString(w, "the bakery has no such page")
+		}
+	}))
+	t.Cleanup(origin.Close)
+	return origin.URL, &hits
+}
+
+func freeLocalPort(t *testing.T) int {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+func startDecoy(t *testing.T, cfg decoy.Config) string {
+	t.Helper()
+	d, err := decoy.New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := fmt.Sprintf("127.0.0.1:%d", freeLocalPort(t))
+	if err := d.Start(addr); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = d.Close() })
+	return "http://" + addr
+}
+
+func getDecoyPage(t *testing.T, url string) (int, string) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+func TestReflexDecoyMirror(t *testing.T) {
+	origin, hits := serveDecoyOrigin(t)
+	site := startDecoy(t, decoy.Config{Origin: origin, Mode: decoy.ModeMirror})
+	crawled := hits.Load()
+	if crawled != 4 {
+		t.Fatalf("expected the crawl to fetch 3 pages and a missing one, got %d requests", crawled)
+	}
+
+	for path, want := range map[string]string{"/about": "about the bakery", "/style.css": "body{}"} {
+		if code, body := getDecoyPage(t, site+path); code != http.StatusOK || body != want {
+			t.Fatalf("%s: got %d %q", path, code, body)
+		}
+	}
+	if code, body := getDecoyPage(t, site+"/wp-admin"); code != http.StatusNotFound || body != "the bakery has no such page" {
+		t.Fatalf("missing page must look like the origin's, got %d %q", code, body)
+	}
+	if hits.Load() != crawled {
+		t.Fatal("mirror mode must not reach the origin while serving")
+	}
+}
+
+func TestReflexDecoyProxyCaches(t *testing.T) {
+	origin, hits := serveDecoyOrigin(t)
+	site := startDecoy(t, decoy.Config{Origin: origin})
+	for i := 0; i < 3; i++ {
+		if code, body := getDecoyPage(t, site+"/about"); code != http.StatusOK || body != "about the bakery" {
+			t.Fatalf("got %d %q", code, body)
+		}
+	}
+	if hits.Load() != 1 {
+		t.Fatalf("page fetched %d times, expected it to be cached", hits.Load())
+	}
+	// Missing pages are not cached.
+	getDecoyPage(t, site+"/nope")
+	getDecoyPage(t, site+"/nope")
+	if hits.Load() != 3 {
+		t.Fatalf("expected missing pages to be fetched each time, got %d requests", hits.Load())
+	}
+
+	if _, err := decoy.New(decoy.Config{Origin: "ftp://example.org"}); err == nil {
+		t.Fatal("non-HTTP origin accepted")
+	}
+}
+
+func TestReflexInboundServesDecoy(t *testing.T) {
+	origin, _ := serveDecoyOrigin(t)
+	handler, err := inbound.New(context.Background(), &reflex.InboundConfig{
+		Clients: []*reflex.User{{Id: uuid.New().String()}},
+		Fallback: &reflex.Fallback{
+			Dest:  uint32(freeLocalPort(t)),
+			Decoy: &reflex.Decoy{Origin: origin},
+		},
+		DrainTimeout: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer handler.(common.Closable).Close()
+	addr := serveReflexPort(t, handler)
+
+	if code, body := getDecoyPage(t, "http://"+addr+"/about"); code != http.StatusOK || body != "about the bakery" {
+		t.Fatalf("fallback did not serve the decoy: %d %q", code, body)
+	}
+}
@@ -0,0 +1,93 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/xtls/xray-core/common"
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/proxy/reflex/inbound"
+)
+
+func TestReflexDestinationFrame(t *testing.T) {
+	for _, address := range []string{"93.184.216.34:443", "[2001:db8::1]:8080", "example.com:80"} {
+		d, err := reflex.ParseDestination(address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := reflex.UnmarshalDestination(d.Marshal())
+		if err != nil || got != d || got.String() != address {
+			t.Fatalf("%s came back as %v, %v", address, got, err)
+		}
+	}
+	if b := (reflex.Destination{Host: "::1", Port: 53}).Marshal(); b[0] != reflex.AddrTypeIPv6 || len(b) != 1+16+2 {
+		t.Fatalf("IPv6 destination encoded as %x", b)
+	}
+
+	for _, b := range [][]byte{
+		nil,
+		{reflex.AddrTypeIPv4, 127, 0, 0, 1}, // no port
+		{reflex.AddrTypeIPv4, 127, 0, 0, 1, 0, 0},   // port 0
+		{reflex.AddrTypeDomain, 0, 0, 80},           // empty name
+		{reflex.AddrTypeDomain, 5, 'a', 'b', 0, 80}, // short name
+		{0x02, 127, 0, 0, 1, 0, 80},                 // unknown type
+		append([]byte{reflex.AddrTypeIPv6}, bytes.Repeat([]byte{1}, 17)...),
+	} {
+		if d, err := reflex.UnmarshalDestination(b); err == nil {
+			t.Errorf("%x decoded as %v", b, d)
+		}
+	}
+	for _, address := range []string{"example.com", "example.com:0", ":80", "[::1]:http"} {
+		if _, err := reflex.ParseDestination(address); err == nil {
+			t.Errorf("%q parsed", address)
+		}
+	}
+}
+
+func TestReflexDestinationDispatch(t *testing.T) {
+	u := uuid.New()
+	handler, err := inbound.New(context.Background(), &reflex.InboundConfig{
+		Clients:      []*reflex.User{{Id: u.String()}},
+		DrainTimeout: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer handler.(common.Closable).Close()
+	dispatcher := newReflexReplyDispatcher("pong")
+	addr := serveReflexDispatcher(t, handler, dispatcher)
+
+	c, err := dialReflexClient(t, addr, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Without an ADDR frame the data goes to the default destination.
+	pingReflexSession(t, c)
+	if got := (<-dispatcher.dests).NetAddr(); got != reflex.DefaultDestination.String() {
+		t.Fatalf("dispatched to %s without a destination", got)
+	}
+	for _, address := range []string{"example.com:443", "[2001:db8::1]:8080", "10.0.0.1:22"} {
+		if err := c.SetDestination(address); err != nil {
+			t.Fatal(err)
+		}
+		pingReflexSession(t, c)
+		if got := (<-dispatcher.dests).NetAddr(); got != address {
+			t.Fatalf("dispatched to %s, want %s", got, address)
+		}
+	}
+	// The destination stays with the session, for resumption and handoff.
+	if d, ok := c.Session.Destination(); !ok || d.String() != "10.0.0.1:22" {
+		t.Fatalf("session destination %v, %v", d, ok)
+	}
+	state := c.Session.State()
+	restored, err := reflex.RestoreSession(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d, _ := restored.Destination(); d.String() != "10.0.0.1:22" {
+		t.Fatalf("restored destination %v", d)
+	}
+}
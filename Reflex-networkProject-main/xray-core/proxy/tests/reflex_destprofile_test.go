@@ -0,0 +1,153 @@
+package tests
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/xtls/xray-core/common"
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/proxy/reflex/inbound"
+)
+
+func TestReflexDestinationProfilesLookup(t *testing.T) {
+	table, err := reflex.NewDestinationProfiles(map[string]string{
+		"googlevideo.com":     "youtube",
+		"RR1.googlevideo.com": "zoom",
+		"10.0.0.1":            "http2-api",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for host, want := range map[string]string{
+		"googlevideo.com":              "youtube",
+		"r3---sn-4g5e.googlevideo.com": "youtube",
+		"rr1.googlevideo.com.":         "zoom",
+		"a.rr1.googlevideo.com":        "zoom",
+		"notgooglevideo.com":           "",
+		"10.0.0.1":                     "http2-api",
+		"":                             "",
+	} {
+		if got, _ := table.Lookup(host); got != want {
+			t.Errorf("%q: got %q, want %q", host, got, want)
+		}
+	}
+	if _, err := reflex.NewDestinationProfiles(map[string]string{"example.com": ""}); err == nil {
+		t.Fatal("a mapping without a profile was accepted")
+	}
+	var none *reflex.DestinationProfiles
+	if _, ok := none.Lookup("youtube.com"); ok {
+		t.Fatal("a nil table mapped a host")
+	}
+}
+
+// reflexClientHello returns the first TLS record a client sends to
+// serverName.
+func reflexClientHello(t *testing.T, serverName string) []byte {
+	t.Helper()
+	client, server := net.Pipe()
+	defer server.Close()
+	go func() {
+		_ = tls.Client(client, &tls.Config{ServerName: serverName}).Handshake()
+		_ = client.Close()
+	}()
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(server, header); err != nil {
+		t.Fatal(err)
+	}
+	record := make([]byte, 5+int(binary.BigEndian.Uint16(header[3:])))
+	copy(record, header)
+	if _, err := io.ReadFull(server, record[5:]); err != nil {
+		t.Fatal(err)
+	}
+	return record
+}
+
+func TestReflexSniffHost(t *testing.T) {
+	if host := reflex.SniffHost(reflexClientHello(t, "rr2.googlevideo.com")); host != "rr2.googlevideo.com" {
+		t.Fatalf("ClientHello: %q", host)
+	}
+	if host := reflex.SniffHost([]byte("GET / HTTP/1.1\r\nHost: www.youtube.com:8080\r\n\r\n")); host != "www.youtube.com" {
+		t.Fatalf("HTTP: %q", host)
+	}
+	if host := reflex.SniffHost([]byte("ping")); host != "" {
+		t.Fatalf("opaque bytes: %q", host)
+	}
+}
+
+func TestReflexDestinationProfileSelected(t *testing.T) {
+	u := uuid.New()
+	handler, err := inbound.New(context.Background(), &reflex.InboundConfig{
+		Clients:             []*reflex.User{{Id: u.String()}},
+		DestinationProfiles: map[string]string{"youtube.com": "youtube"},
+		DrainTimeout:        1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer handler.(common.Closable).Close()
+	addr := serveReflexReplyPort(t, handler, "pong")
+
+	c, err := dialReflexClient(t, addr, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Opaque data does not tell the destination.
+	pingReflexSession(t, c)
+	if c.Shape() != nil {
+		t.Fatalf("shaped with %s before the destination was known", c.Shape().Name)
+	}
+	if err := c.WriteFrame(reflex.FrameTypeData, []byte("GET / HTTP/1.1\r\nHost: www.youtube.com\r\n\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.ReadFrame(); err != nil {
+		t.Fatal(err)
+	}
+	if shape := c.Shape(); shape == nil || shape.Name != reflex.Profiles["youtube"].Name {
+		t.Fatalf("destination profile not pushed: %+v", shape)
+	}
+}
+
+func TestReflexTunnelDestinationProfile(t *testing.T) {
+	u := uuid.New()
+	handler, err := inbound.New(context.Background(), &reflex.InboundConfig{
+		Clients:      []*reflex.User{{Id: u.String()}},
+		DrainTimeout: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer handler.(common.Closable).Close()
+	addr := serveReflexReplyPort(t, handler, "pong")
+
+	table, _ := reflex.NewDestinationProfiles(reflex.DefaultDestinationProfiles)
+	dial := reflex.NewTunnelDialer(addr, &reflex.ClientOptions{UserID: u, DestinationProfiles: table})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for address, want := range map[string]string{
+		"rr1.googlevideo.com:443": reflex.Profiles["youtube"].Name,
+		"example.com:443":         "",
+	} {
+		c, err := dial(ctx, address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := ""
+		if c.Shape() != nil {
+			got = c.Shape().Name
+		}
+		if got != want {
+			t.Errorf("%s: shaped with %q, want %q", address, got, want)
+		}
+		if c.Shape() == reflex.Profiles["youtube"] {
+			t.Error("the tunnel shapes with the shared built-in profile")
+		}
+		_ = c.Close()
+	}
+}
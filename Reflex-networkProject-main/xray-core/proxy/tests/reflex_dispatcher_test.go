@@ -0,0 +1,68 @@
+package tests
+
+import (
+	"context"
+	"errors"
+
+	"github.com/xtls/xray-core/common/buf"
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/features/routing"
+	"github.com/xtls/xray-core/transport"
+	"github.com/xtls/xray-core/transport/pipe"
+)
+
+// reflexReplyDispatcher is a routing.Dispatcher whose links answer every
+// chunk of uplink data with a fixed reply, like a server on a long-lived
+// connection. Destinations and uplink chunks are reported on channels; a
+// chunk nobody waits for is dropped once the channel is full.
+type reflexReplyDispatcher struct {
+	reply    []byte
+	dests    chan xnet.Destination
+	payloads chan []byte
+}
+
+func newReflexReplyDispatcher(reply string) *reflexReplyDispatcher {
+	return &reflexReplyDispatcher{
+		reply:    []byte(reply),
+		dests:    make(chan xnet.Destination, 16),
+		payloads: make(chan []byte, 16),
+	}
+}
+
+func (d *reflexReplyDispatcher) Type() interface{} { return routing.DispatcherType() }
+func (d *reflexReplyDispatcher) Start() error      { return nil }
+func (d *reflexReplyDispatcher) Close() error      { return nil }
+
+func (d *reflexReplyDispatcher) Dispatch(ctx context.Context, dest xnet.Destination) (*transport.Link, error) {
+	upReader, upWriter := pipe.New(pipe.WithoutSizeLimit())
+	downReader, downWriter := pipe.New(pipe.WithoutSizeLimit())
+
+	d.dests <- dest
+	go func() {
+		defer downWriter.Close()
+		for {
+			mb, err := upReader.ReadMultiBuffer()
+			if !mb.IsEmpty() {
+				got := make([]byte, mb.Len())
+				mb.Copy(got)
+				buf.ReleaseMulti(mb)
+				select {
+				case d.payloads <- got:
+				default:
+				}
+				if len(d.reply) > 0 {
+					_ = downWriter.WriteMultiBuffer(buf.MergeBytes(nil, d.reply))
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	return &transport.Link{Reader: downReader, Writer: upWriter}, nil
+}
+
+func (d *reflexReplyDispatcher) DispatchLink(ctx context.Context, dest xnet.Destination, link *transport.Link) error {
+	return errors.New("not implemented")
+}
@@ -0,0 +1,56 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/xtls/xray-core/proxy/reflex"
+)
+
+func TestReflexTunnelDNS(t *testing.T) {
+	handler, userID := newReflexTestHandlerWithClient(t)
+	// The reply is not a DNS answer; what matters is what reaches the server.
+	dispatcher := newReflexReplyDispatcher("")
+	addr := serveReflexDispatcher(t, handler, dispatcher)
+	dial := reflex.NewTunnelDialer(addr, &reflex.ClientOptions{UserID: userID})
+	name := []byte("\x07example\x03com\x00")
+	// query waits for a query for example.com to be tunneled, framed as DNS
+	// over TCP.
+	query := func() {
+		t.Helper()
+		select {
+		case got := <-dispatcher.payloads:
+			if len(got) < 2+12 || int(got[0])<<8|int(got[1]) != len(got)-2 || !bytes.Contains(got, name) {
+				t.Fatalf("server got %x", got)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("query did not reach the server")
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	_, _ = reflex.NewTunnelResolver(dial, "1.1.1.1:53").LookupHost(ctx, "example.com")
+	cancel()
+	query()
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &reflex.DNSServer{Dial: dial, Upstream: "1.1.1.1:53", Timeout: 500 * time.Millisecond}
+	go srv.Serve(pc)
+	defer srv.Close()
+	client, err := net.Dial("udp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	msg := append([]byte{0x12, 0x34, 1, 0, 0, 1, 0, 0, 0, 0, 0, 0}, name...)
+	if _, err := client.Write(append(msg, 0, 1, 0, 1)); err != nil {
+		t.Fatal(err)
+	}
+	query()
+}
@@ -0,0 +1,69 @@
+package tests
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/xtls/xray-core/common"
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/proxy/reflex/inbound"
+	"github.com/xtls/xray-core/transport/internet/stat"
+)
+
+func TestReflexInboundDrainsSessionsOnClose(t *testing.T) {
+	userID := uuid.New()
+	handler, err := inbound.New(context.Background(), &reflex.InboundConfig{
+		Clients:      []*reflex.User{{Id: userID.String()}},
+		DrainTimeout: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	go func() {
+		_ = handler.Process(context.Background(), xnet.Network_TCP, stat.Connection(serverConn), nil)
+	}()
+	_ = clientConn.SetDeadline(time.Now().Add(5 * time.Second))
+	c, err := reflex.ClientHandshake(clientConn, &reflex.ClientOptions{UserID: userID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The server reads the request only once the session is live.
+	if err := c.RenewPolicy(&reflex.PolicyReq{}); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	closed := make(chan error, 1)
+	go func() { closed <- handler.(common.Closable).Close() }()
+
+	// The session gets a CLOSE frame, then the connection is closed once the
+	// grace period is over.
+	if _, err := c.ReadFrame(); err == nil {
+		t.Fatal("session must end after draining")
+	}
+	if c.CloseReason != reflex.CloseReasonShutdown {
+		t.Fatalf("expected close reason %q, got %q", reflex.CloseReasonShutdown, c.CloseReason)
+	}
+	if err := <-closed; err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 900*time.Millisecond {
+		t.Fatalf("session closed before the grace period: %v", elapsed)
+	}
+
+	// No new handshakes once shutting down.
+	clientConn2, serverConn2 := net.Pipe()
+	defer clientConn2.Close()
+	if err := handler.Process(context.Background(), xnet.Network_TCP, stat.Connection(serverConn2), nil); err == nil {
+		t.Fatal("handler must refuse connections while shutting down")
+	}
+}
@@ -0,0 +1,104 @@
+package tests
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/proxy/reflex/inbound"
+)
+
+// earlyConn appends extra to the first write, as if the client sent bytes
+// right behind its handshake without waiting for the response.
+type earlyConn struct {
+	net.Conn
+	extra []byte
+}
+
+func (c *earlyConn) Write(b []byte) (int, error) {
+	if c.extra != nil {
+		extra := c.extra
+		c.extra = nil
+		if _, err := c.Conn.Write(append(append([]byte(nil), b...), extra...)); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+	return c.Conn.Write(b)
+}
+
+func TestReflexEarlyDataRefused(t *testing.T) {
+	handler, userID := newReflexTestHandlerWithClient(t)
+	addr := serveReflexReplyPort(t, handler, "pong")
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	early := &earlyConn{Conn: conn, extra: bytes.Repeat([]byte{0x17}, 64)}
+	if _, err := reflex.ClientHandshake(early, &reflex.ClientOptions{UserID: userID}); !errors.Is(err, reflex.ErrHandshakeRejected) {
+		t.Fatalf("handshake with early data: %v", err)
+	}
+	if n := handler.(*inbound.Handler).TransportMetrics()[reflex.TransportMagic].Failures["early data"]; n != 1 {
+		t.Fatalf("%d early data refusals, want 1", n)
+	}
+
+	// Without the early bytes the same client gets its session.
+	c, err := dialReflexClient(t, addr, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pingReflexSession(t, c)
+}
+
+func TestReflexUnexpectedEpoch(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 32)
+	client, err := reflex.NewClientSession(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := reflex.NewServerSession(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var records [][]byte
+	write := func(payload string) {
+		var wire bytes.Buffer
+		if err := client.WriteFrame(&wire, reflex.FrameTypeData, []byte(payload)); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, wire.Bytes())
+	}
+	write("epoch-0")
+	if err := client.Rekey(); err != nil {
+		t.Fatal(err)
+	}
+	// An epoch carries a frame before the next rekey.
+	if err := client.Rekey(); err == nil {
+		t.Fatal("rekeyed twice without a frame in between")
+	}
+	write("epoch-1")
+	if err := client.Rekey(); err != nil {
+		t.Fatal(err)
+	}
+	write("epoch-2")
+
+	if f, err := server.ReadFrame(bytes.NewReader(records[0])); err != nil || string(f.Payload) != "epoch-0" {
+		t.Fatalf("read %v, %v", f, err)
+	}
+	// Epoch 1 never arrived: a frame of epoch 2 is not the peer's.
+	if _, err := server.ReadFrame(bytes.NewReader(records[2])); err == nil {
+		t.Fatal("read a frame two epochs ahead")
+	}
+	for i, want := range []string{"epoch-1", "epoch-2"} {
+		if f, err := server.ReadFrame(bytes.NewReader(records[i+1])); err != nil || string(f.Payload) != want {
+			t.Fatalf("read %v, %v", f, err)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+
+	"github.com/google/uuid"
+
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/proxy/reflex/inbound"
+)
+
+func TestReflexEmbeddedHandler(t *testing.T) {
+	// The service behind the server: it answers in upper case.
+	service, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer service.Close()
+	go func() {
+		for {
+			conn, err := service.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				b := make([]byte, 1024)
+				for {
+					n, err := conn.Read(b)
+					if err != nil {
+						return
+					}
+					if _, err := conn.Write(bytes.ToUpper(b[:n])); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+
+	var dials atomic.Int32
+	dialer := inbound.DialerFunc(func(ctx context.Context, dest xnet.Destination) (net.Conn, error) {
+		dials.Add(1)
+		return inbound.DirectDialer.Dial(ctx, dest)
+	})
+	u := uuid.New()
+	handler, err := inbound.NewWithDialer(context.Background(), &reflex.InboundConfig{
+		Clients:      []*reflex.User{{Id: u.String()}},
+		DrainTimeout: 1,
+	}, dialer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer handler.Close()
+
+	// Served by a listener of the program's own, without Xray.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go handler.Serve(context.Background(), conn)
+		}
+	}()
+
+	c, err := dialReflexClient(t, ln.Addr().String(), u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.SetDestination(service.Addr().String()); err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range []string{"hello", "again"} {
+		if err := c.WriteFrame(reflex.FrameTypeData, []byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+		f, err := c.ReadFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Replies come padded to the server's default profile, and are
+		// read without it.
+		if want := bytes.ToUpper([]byte(msg)); !bytes.Equal(f.Payload, want) {
+			t.Fatalf("got %q, want %q", f.Payload, want)
+		}
+	}
+	if n := dials.Load(); n != 1 {
+		t.Fatalf("%d dials, want the one connection of the session", n)
+	}
+
+	// A handler made by New has nothing to serve with.
+	plain, err := inbound.New(context.Background(), &reflex.InboundConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer plain.(*inbound.Handler).Close()
+	client, server := net.Pipe()
+	defer client.Close()
+	if err := plain.(*inbound.Handler).Serve(context.Background(), server); err == nil {
+		t.Fatal("served without a dialer")
+	}
+}
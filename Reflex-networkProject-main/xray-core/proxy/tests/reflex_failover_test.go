@@ -0,0 +1,141 @@
+package tests
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xtls/xray-core/proxy/reflex"
+)
+
+// resettingForwarder forwards connections to addr, after delay, until reset
+// is called, which resets the connections to their clients.
+func resettingForwarder(t *testing.T, addr string, delay time.Duration) (string, func()) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+	var mu sync.Mutex
+	var conns []*net.TCPConn
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			time.Sleep(delay)
+			upstream, err := net.Dial("tcp", addr)
+			if err != nil {
+				_ = conn.Close()
+				continue
+			}
+			mu.Lock()
+			conns = append(conns, conn.(*net.TCPConn))
+			mu.Unlock()
+			go func() { _, _ = io.Copy(upstream, conn); _ = upstream.Close() }()
+			go func() { _, _ = io.Copy(conn, upstream) }()
+		}
+	}()
+	return ln.Addr().String(), func() {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, conn := range conns {
+			_ = conn.SetLinger(0)
+			_ = conn.Close()
+		}
+	}
+}
+
+func TestReflexFailover(t *testing.T) {
+	handler, userID := newReflexTestHandlerWithClient(t)
+	addr := serveReflexReplyPort(t, handler, "pong")
+	forwarded, reset := resettingForwarder(t, addr, 0)
+	dead, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dead.Close()
+
+	switched := make(chan string, 4)
+	d := reflex.NewFailoverDialer([]string{dead.Addr().String(), forwarded, addr}, &reflex.ClientOptions{UserID: userID}, func(server string) {
+		switched <- server
+	})
+	dial := func() *reflex.ClientConn {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		c, err := d.Dial(ctx, "example.com:443")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	expectSwitch := func(to string) {
+		t.Helper()
+		select {
+		case got := <-switched:
+			if got != to || d.Active() != to {
+				t.Fatalf("switched to %s, active %s, want %s", got, d.Active(), to)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no switch to %s", to)
+		}
+	}
+
+	// The first server refuses connections.
+	c := dial()
+	expectSwitch(forwarded)
+	pingReflexSession(t, c)
+
+	// The session through the second is reset mid-way.
+	reset()
+	if _, err := c.ReadFrame(); err == nil {
+		t.Fatal("read from a reset session")
+	}
+	expectSwitch(addr)
+	c = dial()
+	defer c.Close()
+	pingReflexSession(t, c)
+	if len(switched) != 0 {
+		t.Fatalf("unexpected switch to %s", <-switched)
+	}
+}
+
+func TestReflexServerSelection(t *testing.T) {
+	handler, userID := newReflexTestHandlerWithClient(t)
+	addr := serveReflexReplyPort(t, handler, "pong")
+	slow, _ := resettingForwarder(t, addr, 200*time.Millisecond)
+	dead, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dead.Close()
+	opts := &reflex.ClientOptions{UserID: userID}
+	probe := func(s *reflex.ServerSelector) []*reflex.ServerProbe {
+		s.Samples, s.Timeout = 2, 2*time.Second
+		return s.Probe(context.Background())
+	}
+
+	d := reflex.NewFailoverDialer([]string{slow, addr}, opts, nil)
+	probes := probe(&reflex.ServerSelector{Dialer: d, Margin: 1})
+	if probes[0].Loss != 0 || probes[0].RTT < 200*time.Millisecond || probes[1].Loss != 0 || probes[1].RTT >= probes[0].RTT {
+		t.Fatalf("probes found %+v and %+v", probes[0], probes[1])
+	}
+	if d.Active() != slow {
+		t.Fatal("a reachable server was replaced despite the margin")
+	}
+	probe(&reflex.ServerSelector{Dialer: d})
+	if d.Active() != addr {
+		t.Fatal("the faster server was not preferred")
+	}
+
+	d = reflex.NewFailoverDialer([]string{dead.Addr().String(), addr}, opts, nil)
+	probes = probe(&reflex.ServerSelector{Dialer: d, Margin: 1})
+	if probes[0].Loss != 1 || d.Active() != addr {
+		t.Fatalf("unreachable server probed as %+v, active %s", probes[0], d.Active())
+	}
+}
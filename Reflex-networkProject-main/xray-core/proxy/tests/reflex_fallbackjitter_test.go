@@ -0,0 +1,83 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/xtls/xray-core/common"
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/proxy/reflex/inbound"
+)
+
+func TestReflexJitterReader(t *testing.T) {
+	data := bytes.Repeat([]byte("cover "), 10)
+	r := &reflex.JitterReader{R: iotest.OneByteReader(bytes.NewReader(data)), Max: time.Millisecond}
+	start := time.Now()
+	got, err := io.ReadAll(r)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("read %q, %v", got, err)
+	}
+	// One pause of at most Max per read; an allowance for the scheduler.
+	if elapsed := time.Since(start); elapsed > time.Duration(len(data))*time.Millisecond+time.Second {
+		t.Fatalf("reads took %v", elapsed)
+	}
+}
+
+func TestReflexFallbackJitter(t *testing.T) {
+	// A fallback server that answers in several chunks.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				if _, err := conn.Read(make([]byte, 1024)); err != nil {
+					return
+				}
+				for i := 0; i < 5; i++ {
+					_, _ = conn.Write([]byte("chunk" + strconv.Itoa(i) + "\n"))
+					time.Sleep(5 * time.Millisecond)
+				}
+			}()
+		}
+	}()
+
+	handler, err := inbound.New(context.Background(), &reflex.InboundConfig{
+		Fallback: &reflex.Fallback{Dest: uint32(ln.Addr().(*net.TCPAddr).Port), Jitter: 20},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer handler.(common.Closable).Close()
+	addr := serveReflexPort(t, handler)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write([]byte("GET / HTTP/1.1\r\nHost: example.com\r\nUser-Agent: test\r\nAccept: */*\r\n\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "chunk0\nchunk1\nchunk2\nchunk3\nchunk4\n"; string(got) != want {
+		t.Fatalf("relayed %q, want %q", got, want)
+	}
+}
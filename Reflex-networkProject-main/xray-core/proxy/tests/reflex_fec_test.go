@@ -0,0 +1,249 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/proxy/reflex/carrier"
+	"github.com/xtls/xray-core/proxy/reflex/inbound"
+	"github.com/xtls/xray-core/proxy/reflex/outbound"
+)
+
+// lossyConn drops the datagrams written through it that drop picks, by
+// their position.
+type lossyConn struct {
+	net.Conn
+	mu   sync.Mutex
+	sent int
+	drop func(i int) bool
+}
+
+func (c *lossyConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	i := c.sent
+	c.sent++
+	c.mu.Unlock()
+	if c.drop(i) {
+		return len(p), nil
+	}
+	return c.Conn.Write(p)
+}
+
+func TestReflexFECRecoversLostDatagrams(t *testing.T) {
+	// net.Pipe keeps write boundaries for readers with room for them.
+	a, b := net.Pipe()
+	fec := carrier.FEC{Data: 4, Parity: 2}
+	// Two of the six datagrams of every group are lost, both data.
+	sender, err := carrier.NewFECConn(&lossyConn{Conn: a, drop: func(i int) bool { return i%6 == 1 || i%6 == 3 }}, fec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+	receiver, err := carrier.NewFECConn(b, fec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer receiver.Close()
+
+	want := make(map[string]bool)
+	for i := 0; i < 20; i++ {
+		msg := fmt.Sprintf("message %d %s", i, make([]byte, i*7))
+		want[msg] = true
+		if _, err := sender.Write([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = receiver.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, carrier.MaxDatagramSize)
+	for len(want) > 0 {
+		n, err := receiver.Read(buf)
+		if err != nil {
+			t.Fatalf("%d messages not recovered: %v", len(want), err)
+		}
+		if !want[string(buf[:n])] {
+			t.Fatalf("unexpected or repeated message %q", buf[:n])
+		}
+		delete(want, string(buf[:n]))
+	}
+}
+
+func TestReflexFECFlushesPartialGroup(t *testing.T) {
+	a, b := net.Pipe()
+	fec := carrier.FEC{Data: 8, Parity: 1}
+	// Only the first datagram is lost; its group is never filled.
+	sender, err := carrier.NewFECConn(&lossyConn{Conn: a, drop: func(i int) bool { return i == 0 }}, fec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+	receiver, err := carrier.NewFECConn(b, fec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer receiver.Close()
+
+	for _, msg := range []string{"lost", "kept"} {
+		if _, err := sender.Write([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = receiver.SetReadDeadline(time.Now().Add(5 * time.Second))
+	got := make(map[string]bool)
+	buf := make([]byte, 64)
+	for len(got) < 2 {
+		n, err := receiver.Read(buf)
+		if err != nil {
+			t.Fatalf("got %v: %v", got, err)
+		}
+		got[string(buf[:n])] = true
+	}
+	if !got["lost"] || !got["kept"] {
+		t.Fatalf("unexpected messages %v", got)
+	}
+}
+
+func TestReflexUDPCarrierSession(t *testing.T) {
+	handler, userID := newReflexTestHandlerWithClient(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ln, err := carrier.ListenUDP("127.0.0.1:0", carrier.DefaultFEC, carrier.InboundHandler(ctx, handler, newReflexReplyDispatcher("pong")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	conn, err := carrier.DialUDP(ctx, ln.Addr().String(), carrier.DefaultFEC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	preset := &reflex.Preset{Name: "udp", Profiles: []string{"http2-api"}, Carrier: reflex.CarrierUDP}
+	c, err := reflex.ClientHandshake(conn, preset.ClientOptions(userID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.Grant.HasFeature(reflex.FeatureReorderTolerant) {
+		t.Fatal("tolerant ordering was not granted")
+	}
+	pingReflexSession(t, c)
+	pingReflexSession(t, c)
+}
+
+func TestReflexUDPCarrierNeedsCookie(t *testing.T) {
+	var conns atomic.Int32
+	ln, err := carrier.ListenUDP("127.0.0.1:0", carrier.DefaultFEC, func(c net.Conn) {
+		conns.Add(1)
+		// Writes fail once the deadline has passed.
+		_ = c.SetWriteDeadline(time.Now().Add(-time.Second))
+		if _, err := c.Write([]byte("late")); !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Errorf("write past the deadline: %v", err)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	raw, err := net.Dial("udp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+	read := func() []byte {
+		_ = raw.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+		b := make([]byte, 2048)
+		n, err := raw.Read(b)
+		if err != nil {
+			return nil
+		}
+		return b[:n]
+	}
+
+	// Datagrams from an address that has not echoed a cookie, as a spoofed
+	// one cannot, open no connection and get no answer.
+	for i := 0; i < 16; i++ {
+		_, _ = raw.Write(bytes.Repeat([]byte{byte(i)}, 100))
+	}
+	_, _ = raw.Write(append([]byte{0xfe}, make([]byte, 16)...))
+	if got := read(); got != nil {
+		t.Fatalf("answered with %x", got)
+	}
+	if n := conns.Load(); n != 0 {
+		t.Fatalf("%d connections without a cookie", n)
+	}
+
+	// A hello is answered with no more than it carries.
+	hello := append([]byte{0xfc}, make([]byte, 63)...)
+	_, _ = raw.Write(hello)
+	cookie := read()
+	if len(cookie) != 17 || cookie[0] != 0xfd || len(cookie) > len(hello) {
+		t.Fatalf("hello answered with %x", cookie)
+	}
+	_, _ = raw.Write(append([]byte{0xfe}, cookie[1:]...))
+	if got := read(); !bytes.Equal(got, []byte{0xff}) {
+		t.Fatalf("echo answered with %x", got)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for conns.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := conns.Load(); n != 1 {
+		t.Fatalf("%d connections after the echo", n)
+	}
+}
+
+func TestReflexUDPCarrierOutbound(t *testing.T) {
+	backend := inbound.DialerFunc(func(ctx context.Context, dest xnet.Destination) (net.Conn, error) {
+		a, b := net.Pipe()
+		go func() {
+			defer b.Close()
+			if _, err := b.Read(make([]byte, 64)); err == nil {
+				_, _ = b.Write([]byte("pong"))
+			}
+		}()
+		return a, nil
+	})
+	u := uuid.New()
+	handler, err := inbound.NewWithDialer(context.Background(), &reflex.InboundConfig{
+		Clients:      []*reflex.User{{Id: u.String()}},
+		DrainTimeout: 1,
+		Carriers:     &reflex.Carriers{Udp: &reflex.UDPCarrier{Listen: "127.0.0.1:0", FecData: 4, FecParity: 1}},
+	}, backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer handler.Close()
+
+	ob, err := outbound.New(context.Background(), &reflex.OutboundConfig{
+		Address:   "127.0.0.1",
+		Port:      uint32(handler.UDPAddr().(*net.UDPAddr).Port),
+		Id:        u.String(),
+		Carrier:   reflex.CarrierUDP,
+		FecData:   4,
+		FecParity: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ob.Close()
+	dialer := &countingDialer{}
+	pingReflexOutbound(t, ob, dialer)
+	pingReflexOutbound(t, ob, dialer)
+	if n := dialer.dials.Load(); n != 0 {
+		t.Fatalf("%d TCP dials, want none over the UDP carrier", n)
+	}
+}
@@ -0,0 +1,91 @@
+package tests
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/xtls/xray-core/common"
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/proxy/reflex/inbound"
+)
+
+func TestReflexClassifierFeedback(t *testing.T) {
+	f := reflex.NewClassifierFeedback()
+	for i := 0; i < reflex.MinFeedbackFlows; i++ {
+		_ = f.Record(reflex.ClassifierVerdict{Profile: "youtube", Flagged: i%2 == 0})
+		_ = f.Record(reflex.ClassifierVerdict{Profile: "zoom", Flagged: i%10 == 0})
+	}
+	_ = f.Record(reflex.ClassifierVerdict{Profile: "http2-api"})
+	if err := f.Record(reflex.ClassifierVerdict{Profile: "no-such-profile"}); err == nil {
+		t.Fatal("verdict on an unknown profile was accepted")
+	}
+
+	stats := f.Stats()
+	if len(stats) != 3 || stats[0].Profile != "http2-api" || stats[1].Profile != "zoom" || stats[2].FlaggedRate != 0.5 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	// http2-api is never flagged but has too few verdicts to go by.
+	if p, ok := f.Suggest(); !ok || p != "zoom" {
+		t.Fatalf("expected zoom to be suggested, got %q", p)
+	}
+}
+
+func postReflexFeedback(t *testing.T, addr, body string) (int, inbound.FeedbackStatus) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	req := fmt.Sprintf("POST /verdicts HTTP/1.1\r\nHost: classifier\r\nContent-Length: %d\r\n\r\n%s", len(body), body)
+	if _, err := conn.Write([]byte(req)); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var st inbound.FeedbackStatus
+	_ = json.NewDecoder(resp.Body).Decode(&st)
+	return resp.StatusCode, st
+}
+
+func TestReflexFeedbackEndpoint(t *testing.T) {
+	handler, err := inbound.New(context.Background(), &reflex.InboundConfig{
+		Clients:      []*reflex.User{{Id: uuid.New().String()}},
+		FeedbackPath: "/verdicts",
+		DrainTimeout: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer handler.(common.Closable).Close()
+	addr := serveReflexPort(t, handler)
+
+	code, st := postReflexFeedback(t, addr, `[{"profile":"youtube","flagged":true},{"profile":"youtube"}]`)
+	if code != http.StatusOK || len(st.Profiles) != 1 || st.Profiles[0].Flows != 2 || st.Profiles[0].Flagged != 1 {
+		t.Fatalf("unexpected feedback %d %+v", code, st)
+	}
+
+	// A batch with an unknown profile is refused as a whole.
+	if code, _ := postReflexFeedback(t, addr, `[{"profile":"youtube"},{"profile":"nope"}]`); code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", code)
+	}
+	code, st = postReflexFeedback(t, addr, `[]`)
+	if code != http.StatusOK || st.Profiles[0].Flows != 2 {
+		t.Fatalf("refused batch was recorded: %+v", st)
+	}
+	if st.Suggested != "" {
+		t.Fatal("profile suggested before enough verdicts")
+	}
+}
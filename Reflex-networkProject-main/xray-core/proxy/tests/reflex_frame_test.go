@@ -0,0 +1,68 @@
+package tests
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/proxy/reflex/frame"
+)
+
+func TestReflexFrameRecordRoundTrip(t *testing.T) {
+	rec := &frame.Record{
+		Nonce:      bytes.Repeat([]byte{0x01}, 12),
+		Ciphertext: bytes.Repeat([]byte{0x02}, 20),
+	}
+
+	var buf bytes.Buffer
+	if err := frame.WriteRecord(&buf, rec); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != frame.LengthSize+rec.Len() {
+		t.Fatalf("unexpected wire size %d", buf.Len())
+	}
+
+	got, err := frame.ReadRecord(&buf, 12, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Nonce, rec.Nonce) || !bytes.Equal(got.Ciphertext, rec.Ciphertext) {
+		t.Fatal("record mismatch after round trip")
+	}
+}
+
+func TestReflexFrameRecordRejectsShortCiphertext(t *testing.T) {
+	var buf bytes.Buffer
+	_ = frame.WriteRecord(&buf, &frame.Record{Nonce: make([]byte, 12), Ciphertext: make([]byte, 4)})
+	if _, err := frame.ReadRecord(&buf, 12, 16); err == nil {
+		t.Fatal("expected error for ciphertext shorter than AEAD overhead")
+	}
+}
+
+func TestReflexFrameMatchesSessionWireFormat(t *testing.T) {
+	sess, err := reflex.NewSession(make([]byte, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := sess.WriteFrame(&buf, reflex.FrameTypeData, []byte("payload")); err != nil {
+		t.Fatal(err)
+	}
+
+	rec, err := frame.ReadRecord(&buf, 12, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// type (1) + payload (7) + Poly1305 tag (16)
+	if len(rec.Ciphertext) != 1+7+16 {
+		t.Fatalf("unexpected ciphertext length %d", len(rec.Ciphertext))
+	}
+
+	f, err := frame.Unmarshal((&frame.Frame{Type: frame.TypeTimingCtrl, Payload: []byte{1}}).Marshal())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Type != frame.TypeTimingCtrl || len(f.Payload) != 1 {
+		t.Fatalf("unexpected plaintext decode: %+v", f)
+	}
+}
@@ -0,0 +1,94 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/xtls/xray-core/common"
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/proxy/reflex/inbound"
+)
+
+func TestReflexMatchFront(t *testing.T) {
+	fronts := []*reflex.CoverFront{{Host: "cdn.example.com", Path: "/upload"}}
+	for _, c := range []struct {
+		host, path string
+		want       bool
+	}{
+		{"cdn.example.com", "/upload", true},
+		{"CDN.Example.com:443", "/upload?part=2", true},
+		{"cdn.example.com", "/upload/", false},
+		{"www.example.com", "/upload", false},
+	} {
+		if got := reflex.MatchFront(fronts, c.host, c.path); got != c.want {
+			t.Errorf("MatchFront(%q, %q) = %v", c.host, c.path, got)
+		}
+	}
+}
+
+func TestReflexCoverFrontRotation(t *testing.T) {
+	userID := uuid.New()
+	fronts := []*reflex.CoverFront{
+		{Host: "a.example", Path: "/upload"},
+		{Host: "b.example", Path: "/api/sync"},
+	}
+	decoy := serveProbeSite(t, "welcome")
+	_, port, _ := net.SplitHostPort(decoy)
+	dest, _ := net.LookupPort("tcp", port)
+	handler, err := inbound.New(context.Background(), &reflex.InboundConfig{
+		Clients:      []*reflex.User{{Id: userID.String()}},
+		Fallback:     &reflex.Fallback{Dest: uint32(dest)},
+		CoverFronts:  fronts,
+		DrainTimeout: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer handler.(common.Closable).Close()
+	addr := serveReflexReplyPort(t, handler, "pong")
+
+	handshake := func(fronts []*reflex.CoverFront) ([]byte, error) {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+		rec := &writeRecorder{Conn: conn}
+		c, err := reflex.ClientHandshake(rec, &reflex.ClientOptions{UserID: userID, Fronts: fronts})
+		if err != nil {
+			return rec.writes[0], err
+		}
+		pingReflexSession(t, c)
+		return rec.writes[0], nil
+	}
+
+	// Each connection picks its front anew.
+	used := make(map[*reflex.CoverFront]bool)
+	for i := 0; i < 64; i++ {
+		used[reflex.PickFront(fronts)] = true
+	}
+	if len(used) != len(fronts) {
+		t.Fatalf("connections did not rotate among the fronts: %v", used)
+	}
+
+	for i := 0; i < 8; i++ {
+		req, err := handshake(fronts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasPrefix(req, []byte("POST ")) {
+			t.Fatalf("handshake was not sent as an HTTP request: %q", req)
+		}
+	}
+
+	// A handshake on any other front is left to the cover site.
+	if _, err := handshake([]*reflex.CoverFront{{Host: "c.example", Path: "/upload"}}); err == nil {
+		t.Fatal("handshake on an unlisted front was accepted")
+	}
+}
@@ -0,0 +1,131 @@
+//go:build unix
+
+package tests
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/xtls/xray-core/common"
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/proxy/reflex/handoff"
+	"github.com/xtls/xray-core/proxy/reflex/inbound"
+	"github.com/xtls/xray-core/transport/internet/stat"
+)
+
+func TestReflexSessionHandoff(t *testing.T) {
+	userID := uuid.New()
+	cfg := &reflex.InboundConfig{
+		Clients:       []*reflex.User{{Id: userID.String()}},
+		HandoffSocket: filepath.Join(t.TempDir(), "handoff.sock"),
+		DrainTimeout:  1,
+	}
+	oldHandler, err := inbound.New(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go oldHandler.Process(context.Background(), xnet.Network_TCP, stat.Connection(conn), newReflexReplyDispatcher("old"))
+		}
+	}()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	c, err := reflex.ClientHandshake(conn, &reflex.ClientOptions{UserID: userID, Policy: &reflex.PolicyReq{Profile: "zoom"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exchange := func() string {
+		t.Helper()
+		if err := c.WriteFrame(reflex.FrameTypeData, []byte("ping")); err != nil {
+			t.Fatal(err)
+		}
+		f, err := c.ReadFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(f.Payload)
+	}
+	if reply := exchange(); !strings.HasPrefix(reply, "old") {
+		t.Fatalf("unexpected reply before handoff %q", reply)
+	}
+
+	// The new process takes over the socket, then the old one shuts down.
+	newHandler, err := inbound.New(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer newHandler.(common.Closable).Close()
+	go newHandler.(*inbound.Handler).ServeHandoffs(context.Background(), newReflexReplyDispatcher("new"))
+	if err := oldHandler.(common.Closable).Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if reply := exchange(); !strings.HasPrefix(reply, "new") {
+		t.Fatalf("session was not resumed by the new handler, got %q", reply)
+	}
+	if c.CloseReason != "" {
+		t.Fatalf("handed off session must not be closed, got %q", c.CloseReason)
+	}
+	if c.Grant.Profile != "zoom" {
+		t.Fatalf("grant changed across handoff: %+v", c.Grant)
+	}
+}
+
+func TestReflexHandoffSocketPath(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(file, []byte("{}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if l, err := handoff.Listen(file); err == nil {
+		l.Close()
+		t.Fatal("listened over a regular file")
+	}
+	if _, err := os.Stat(file); err != nil {
+		t.Fatalf("the file at the path was removed: %v", err)
+	}
+
+	path := filepath.Join(dir, "handoff.sock")
+	l, err := handoff.Listen(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := fi.Mode().Perm(); perm != 0o600 {
+		t.Fatalf("socket created with mode %v, want only its owner's access", perm)
+	}
+	// The socket a previous process left behind is taken over.
+	l, err = handoff.Listen(path)
+	if err != nil {
+		t.Fatalf("taking over the socket: %v", err)
+	}
+	l.Close()
+}
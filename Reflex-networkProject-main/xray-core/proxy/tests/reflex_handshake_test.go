@@ -0,0 +1,52 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/xtls/xray-core/proxy/reflex/handshake"
+)
+
+func TestReflexHandshakeCodec(t *testing.T) {
+	hello := &handshake.Client{
+		UserID:    uuid.New(),
+		Timestamp: 1700000000,
+		PolicyReq: []byte(`{"v":1}`),
+		Padding:   bytes.Repeat([]byte{0xAA}, 17),
+	}
+	hello.PublicKey[0], hello.Nonce[15] = 1, 2
+
+	packet := bytes.NewReader(hello.Marshal())
+	var magic uint32
+	_ = binary.Read(packet, binary.BigEndian, &magic)
+	if magic != handshake.Magic {
+		t.Fatalf("packet starts with %#x", magic)
+	}
+	body, err := handshake.ReadBody(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if packet.Len() != 0 || !bytes.Equal(body, hello.MarshalBody()) {
+		t.Fatal("ReadBody did not read exactly the body")
+	}
+	got, err := handshake.Unmarshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, hello) {
+		t.Fatalf("decoded %+v, want %+v", got, hello)
+	}
+
+	if _, err := handshake.Unmarshal(body[:len(body)-1]); err == nil {
+		t.Fatal("truncated body accepted")
+	}
+	hello.Padding = make([]byte, handshake.MaxPadding+1)
+	if _, err := handshake.ReadBody(bytes.NewReader(hello.MarshalBody())); !errors.Is(err, handshake.ErrPaddingTooLarge) {
+		t.Fatalf("oversized padding read with %v", err)
+	}
+}
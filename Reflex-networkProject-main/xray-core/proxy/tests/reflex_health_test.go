@@ -0,0 +1,144 @@
+package tests
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/xtls/xray-core/common"
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/proxy/reflex/inbound"
+)
+
+func getReflexHealth(t *testing.T, addr string) (int, inbound.HealthStatus) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write([]byte("GET /healthz HTTP/1.1\r\nHost: lb\r\n\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var st inbound.HealthStatus
+	if err := json.NewDecoder(resp.Body).Decode(&st); err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, st
+}
+
+func TestReflexHealthEndpoint(t *testing.T) {
+	userID := uuid.New()
+	handler, err := inbound.New(context.Background(), &reflex.InboundConfig{
+		Clients:      []*reflex.User{{Id: userID.String()}},
+		HealthPath:   "/healthz",
+		HealthListen: "127.0.0.1:0",
+		DrainTimeout: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := serveReflexPort(t, handler)
+	health := handler.(*inbound.Handler).HealthAddr().String()
+
+	code, st := getReflexHealth(t, health)
+	if code != http.StatusOK || st.Status != "ok" || st.Sessions != 0 || st.Fallback != "none" {
+		t.Fatalf("unexpected health %d %+v", code, st)
+	}
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := reflex.ClientHandshake(conn, &reflex.ClientOptions{UserID: userID}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; st.Sessions != 1; i++ {
+		if i == 50 {
+			t.Fatalf("session not counted: %+v", st)
+		}
+		time.Sleep(10 * time.Millisecond)
+		_, st = getReflexHealth(t, health)
+	}
+
+	go handler.(common.Closable).Close()
+	for i := 0; st.Status != "draining"; i++ {
+		if i == 50 {
+			t.Fatalf("draining not reported: %+v", st)
+		}
+		time.Sleep(10 * time.Millisecond)
+		code, st = getReflexHealth(t, health)
+	}
+	if code != http.StatusServiceUnavailable {
+		t.Fatalf("draining node must not be ready, got %d", code)
+	}
+}
+
+func TestReflexHealthFallbackUnreachable(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedPort := uint32(ln.Addr().(*net.TCPAddr).Port)
+	ln.Close()
+
+	handler, err := inbound.New(context.Background(), &reflex.InboundConfig{
+		Fallback:     &reflex.Fallback{Dest: closedPort},
+		HealthPath:   "/healthz",
+		HealthListen: "127.0.0.1:0",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer handler.(common.Closable).Close()
+	code, st := getReflexHealth(t, handler.(*inbound.Handler).HealthAddr().String())
+	if code != http.StatusServiceUnavailable || st.Status != "degraded" || st.Fallback != "unreachable" {
+		t.Fatalf("unexpected health %d %+v", code, st)
+	}
+}
+
+func TestReflexHealthNotOnPublicPort(t *testing.T) {
+	if _, err := inbound.New(context.Background(), &reflex.InboundConfig{HealthPath: "/healthz"}); err == nil {
+		t.Fatal("health path accepted without a listen address of its own")
+	}
+
+	handler, err := inbound.New(context.Background(), &reflex.InboundConfig{
+		Clients:      []*reflex.User{{Id: uuid.New().String()}},
+		HealthPath:   "/healthz",
+		HealthListen: "127.0.0.1:0",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer handler.(common.Closable).Close()
+
+	// The public port treats the health path like any other request: with
+	// no fallback, the connection is just closed.
+	conn, err := net.Dial("tcp", serveReflexPort(t, handler))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write([]byte("GET /healthz HTTP/1.1\r\nHost: lb\r\n\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := http.ReadResponse(bufio.NewReader(conn), nil); err == nil {
+		resp.Body.Close()
+		t.Fatalf("public port answered the health path: %s", resp.Status)
+	}
+}
@@ -0,0 +1,68 @@
+package tests
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/xtls/xray-core/proxy/reflex"
+)
+
+func TestReflexHTTPProxyFrontEnd(t *testing.T) {
+	handler, userID := newReflexTestHandlerWithClient(t)
+	addr := serveReflexReplyPort(t, handler, "pong")
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &reflex.HTTPProxyServer{Dial: reflex.NewTunnelDialer(addr, &reflex.ClientOptions{UserID: userID})}
+	go srv.Serve(ln)
+	defer srv.Close()
+	open := func(head string) (net.Conn, *bufio.Reader) {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+		if _, err := io.WriteString(conn, head); err != nil {
+			t.Fatal(err)
+		}
+		return conn, bufio.NewReader(conn)
+	}
+	// pong reads what the tunnel delivers and checks it is the reply.
+	pong := func(r io.Reader) {
+		t.Helper()
+		b := make([]byte, 64)
+		n, err := io.ReadAtLeast(r, b, len("pong"))
+		if err != nil || string(b[:n]) != "pong" {
+			t.Fatalf("tunnel answered %q: %v", b[:n], err)
+		}
+	}
+
+	conn, r := open("CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n")
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("CONNECT answered %v: %v", resp, err)
+	}
+	if _, err := io.WriteString(conn, "ping"); err != nil {
+		t.Fatal(err)
+	}
+	pong(r)
+	conn.Close()
+
+	// A plain request goes out in origin form, as one DATA frame.
+	conn, r = open("GET http://example.com/index.html HTTP/1.1\r\nHost: example.com\r\nProxy-Connection: keep-alive\r\n\r\n")
+	pong(r)
+	conn.Close()
+
+	conn, r = open("GET /index.html HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	resp, err = http.ReadResponse(r, nil)
+	conn.Close()
+	if err != nil || resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("request in origin form answered %v: %v", resp, err)
+	}
+}
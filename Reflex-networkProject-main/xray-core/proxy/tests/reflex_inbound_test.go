@@ -5,7 +5,8 @@ import (
 	"bytes"
 	"context"
 	"crypto/rand"
-	"encoding/binary"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"io"
 	"net"
@@ -18,45 +19,41 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"golang.org/x/crypto/curve25519"
 
 	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/features/routing"
 	"github.com/xtls/xray-core/proxy"
 	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/proxy/reflex/handshake"
 	"github.com/xtls/xray-core/proxy/reflex/inbound"
 	"github.com/xtls/xray-core/transport/internet/stat"
 )
 
-// reflexMagic is the Reflex handshake magic number (REFX).
-const reflexMagic uint32 = 0x5246584C
-
 func buildReflexMagicHandshake(userID uuid.UUID, ts int64) []byte {
-	var buf bytes.Buffer
-
-	_ = binary.Write(&buf, binary.BigEndian, reflexMagic)
-
 	var pub [32]byte
 	_, _ = rand.Read(pub[:])
-	buf.Write(pub[:])
-
-	var userBytes [16]byte
-	copy(userBytes[:], userID[:])
-	buf.Write(userBytes[:])
-
-	var tsBytes [8]byte
-	binary.BigEndian.PutUint64(tsBytes[:], uint64(ts))
-	buf.Write(tsBytes[:])
+	return buildReflexMagicHandshakeWithKey(userID, ts, pub)
+}
 
-	var nonce [16]byte
-	_, _ = rand.Read(nonce[:])
-	buf.Write(nonce[:])
+func buildReflexMagicHandshakeWithKey(userID uuid.UUID, ts int64, pub [32]byte) []byte {
+	return buildReflexMagicHandshakeWithPadding(userID, ts, pub, reflex.NewHandshakePadding())
+}
 
-	policy := []byte("policy")
-	var plen [2]byte
-	binary.BigEndian.PutUint16(plen[:], uint16(len(policy)))
-	buf.Write(plen[:])
-	buf.Write(policy)
+func buildReflexMagicHandshakeWithPadding(userID uuid.UUID, ts int64, pub [32]byte, padding []byte) []byte {
+	return buildReflexMagicHandshakeFull(userID, ts, pub, []byte("policy"), padding)
+}
 
-	return buf.Bytes()
+func buildReflexMagicHandshakeFull(userID uuid.UUID, ts int64, pub [32]byte, policy, padding []byte) []byte {
+	hello := &handshake.Client{
+		PublicKey: pub,
+		UserID:    userID,
+		Timestamp: ts,
+		PolicyReq: policy,
+		Padding:   padding,
+	}
+	_, _ = rand.Read(hello.Nonce[:])
+	return hello.Marshal()
 }
 
 func newReflexTestHandlerWithClient(t *testing.T) (handler proxy.Inbound, userID uuid.UUID) {
@@ -115,6 +112,164 @@ func TestReflexMagicHandshakeSuccess(t *testing.T) {
 	}
 }
 
+// readReflexHandshakeResponse reads the HTTP response to a handshake and
+// returns the decoded JSON body.
+func readReflexHandshakeResponse(t *testing.T, reader *bufio.Reader) inbound.ServerHandshake {
+	t.Helper()
+
+	statusLine, err := reader.ReadString('\n')
+	if err != nil {
+		t.Fatalf("failed to read status line: %v", err)
+	}
+	if !strings.Contains(statusLine, "200") {
+		t.Fatalf("expected HTTP 200 in status line, got: %q", statusLine)
+	}
+	var contentLen int
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("failed to read header line: %v", err)
+		}
+		if line == "\r\n" {
+			break
+		}
+		if strings.HasPrefix(strings.ToLower(line), "content-length:") {
+			contentLen, _ = strconv.Atoi(strings.TrimSpace(line[len("content-length:"):]))
+		}
+	}
+	body := make([]byte, contentLen)
+	if _, err := io.ReadFull(reader, body); err != nil {
+		t.Fatalf("failed to read body: %v", err)
+	}
+	var resp inbound.ServerHandshake
+	if err := json.Unmarshal(body, &resp); err != nil {
+		t.Fatalf("failed to decode handshake response: %v", err)
+	}
+	return resp
+}
+
+func TestReflexHandshakeKeyConfirmation(t *testing.T) {
+	handler, userID := newReflexTestHandlerWithClient(t)
+
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	go func() {
+		_ = handler.Process(context.Background(), xnet.Network_TCP, stat.Connection(serverConn), nil)
+	}()
+
+	var priv, pub [32]byte
+	_, _ = rand.Read(priv[:])
+	curve25519.ScalarBaseMult(&pub, &priv)
+	hs := buildReflexMagicHandshakeWithKey(userID, time.Now().Unix(), pub)
+	if _, err := clientConn.Write(hs); err != nil {
+		t.Fatalf("client write handshake failed: %v", err)
+	}
+
+	_ = clientConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	resp := readReflexHandshakeResponse(t, bufio.NewReader(clientConn))
+
+	var shared [32]byte
+	curve25519.ScalarMult(&shared, &priv, &resp.PublicKey)
+	body := hs[4:]
+	transcript := reflex.NewTranscript()
+	transcript.Write(body)
+	transcript.Write(resp.PublicKey[:])
+	nonce := body[32+16+8 : 32+16+8+16]
+	key := reflex.DeriveSessionKey(shared, nonce, transcript.Sum())
+	if !reflex.VerifyKeyConfirmation(key, transcript.Sum(), resp.KeyConfirm) {
+		t.Fatal("key confirmation mismatch")
+	}
+
+	// Any flipped handshake byte must change the transcript and fail confirmation.
+	tampered := reflex.NewTranscript()
+	tampered.Write(append([]byte{body[0] ^ 1}, body[1:]...))
+	tampered.Write(resp.PublicKey[:])
+	if reflex.VerifyKeyConfirmation(reflex.DeriveSessionKey(shared, nonce, tampered.Sum()), tampered.Sum(), resp.KeyConfirm) {
+		t.Fatal("tampered transcript must not confirm")
+	}
+}
+
+// dialReflexSession runs a full magic handshake against handler over a pipe
+// and returns the client session with the connection and its reader.
+func dialReflexSession(t *testing.T, handler proxy.Inbound, userID uuid.UUID, dispatcher routing.Dispatcher) (*reflex.Session, net.Conn, *bufio.Reader) {
+	t.Helper()
+	session, conn, reader, _ := dialReflexSessionWithPolicy(t, handler, userID, dispatcher, []byte("policy"))
+	return session, conn, reader
+}
+
+// dialReflexSessionWithPolicy is dialReflexSession with an explicit policy
+// request; it also returns the handshake response.
+func dialReflexSessionWithPolicy(t *testing.T, handler proxy.Inbound, userID uuid.UUID, dispatcher routing.Dispatcher, policy []byte) (*reflex.Session, net.Conn, *bufio.Reader, inbound.ServerHandshake) {
+	t.Helper()
+
+	clientConn, serverConn := net.Pipe()
+	t.Cleanup(func() { _ = clientConn.Close() })
+	go func() {
+		_ = handler.Process(context.Background(), xnet.Network_TCP, stat.Connection(serverConn), dispatcher)
+	}()
+
+	var priv, pub [32]byte
+	_, _ = rand.Read(priv[:])
+	curve25519.ScalarBaseMult(&pub, &priv)
+	hs := buildReflexMagicHandshakeFull(userID, time.Now().Unix(), pub, policy, reflex.NewHandshakePadding())
+	if _, err := clientConn.Write(hs); err != nil {
+		t.Fatalf("client write handshake failed: %v", err)
+	}
+
+	_ = clientConn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(clientConn)
+	resp := readReflexHandshakeResponse(t, reader)
+
+	var shared [32]byte
+	curve25519.ScalarMult(&shared, &priv, &resp.PublicKey)
+	transcript := reflex.NewTranscript()
+	transcript.Write(hs[4:])
+	transcript.Write(resp.PublicKey[:])
+	key := reflex.DeriveSessionKey(shared, hs[4+32+16+8:4+32+16+8+16], transcript.Sum())
+	session, err := reflex.NewClientSession(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return session, clientConn, reader, resp
+}
+
+func TestReflexHandshakePaddingBounds(t *testing.T) {
+	cases := []struct {
+		padding int
+		status  string
+	}{
+		{0, "200"},
+		{reflex.MaxHandshakePadding, "200"},
+		{reflex.MaxHandshakePadding + 1, "403"},
+	}
+	for _, tc := range cases {
+		handler, userID := newReflexTestHandlerWithClient(t)
+		clientConn, serverConn := net.Pipe()
+
+		go func() {
+			_ = handler.Process(context.Background(), xnet.Network_TCP, stat.Connection(serverConn), nil)
+		}()
+
+		var pub [32]byte
+		_, _ = rand.Read(pub[:])
+		hs := buildReflexMagicHandshakeWithPadding(userID, time.Now().Unix(), pub, make([]byte, tc.padding))
+		go func() { _, _ = clientConn.Write(hs) }()
+
+		_ = clientConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		statusLine, err := bufio.NewReader(clientConn).ReadString('\n')
+		if err != nil {
+			t.Fatalf("padding %d: failed to read status line: %v", tc.padding, err)
+		}
+		if !strings.Contains(statusLine, tc.status) {
+			t.Fatalf("padding %d: expected %s, got %q", tc.padding, tc.status, statusLine)
+		}
+		clientConn.Close()
+		serverConn.Close()
+	}
+}
+
 func TestReflexHandshakeOldTimestampRejected(t *testing.T) {
 	handler, userID := newReflexTestHandlerWithClient(t)
 
@@ -315,3 +470,260 @@ func TestReflexFallbackPeekedBytesReachServer(t *testing.T) {
 		t.Fatal("fallback server did not receive request (peeked bytes may not have been forwarded)")
 	}
 }
+
+func TestReflexFragmentedHandshake(t *testing.T) {
+	handler, userID := newReflexTestHandlerWithClient(t)
+
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	go func() {
+		_ = handler.Process(context.Background(), xnet.Network_TCP, stat.Connection(serverConn), nil)
+	}()
+
+	hs := buildReflexMagicHandshake(userID, time.Now().Unix())
+	go func() {
+		cfg := reflex.FragmentConfig{MinSize: 1, MaxSize: 7, MaxDelay: 2 * time.Millisecond}
+		_ = reflex.WriteFragmented(clientConn, hs, cfg)
+	}()
+
+	_ = clientConn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	statusLine, err := bufio.NewReader(clientConn).ReadString('\n')
+	if err != nil {
+		t.Fatalf("failed to read status line: %v", err)
+	}
+	if !strings.Contains(statusLine, "200") {
+		t.Fatalf("expected HTTP 200 for fragmented handshake, got: %q", statusLine)
+	}
+}
+
+func TestReflexHandshakeRetryCookie(t *testing.T) {
+	userID := uuid.New()
+	handler, err := inbound.New(context.Background(), &reflex.InboundConfig{
+		Clients:     []*reflex.User{{Id: userID.String()}},
+		RetryCookie: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handshake := func(padding []byte) inbound.ServerHandshake {
+		clientConn, serverConn := net.Pipe()
+		defer clientConn.Close()
+		defer serverConn.Close()
+		go func() {
+			_ = handler.Process(context.Background(), xnet.Network_TCP, stat.Connection(serverConn), nil)
+		}()
+		var pub [32]byte
+		_, _ = rand.Read(pub[:])
+		hs := buildReflexMagicHandshakeWithPadding(userID, time.Now().Unix(), pub, padding)
+		go func() { _, _ = clientConn.Write(hs) }()
+		_ = clientConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		return readReflexHandshakeResponse(t, bufio.NewReader(clientConn))
+	}
+
+	first := handshake(reflex.NewHandshakePadding())
+	if len(first.RetryCookie) != reflex.CookieSize || first.KeyConfirm != nil {
+		t.Fatalf("expected a retry cookie and no key exchange, got %+v", first)
+	}
+
+	forged := append([]byte(nil), first.RetryCookie...)
+	forged[len(forged)-1] ^= 1
+	if resp := handshake(reflex.CookiePadding(forged)); resp.RetryCookie == nil {
+		t.Fatal("forged cookie must be answered with a new retry")
+	}
+
+	second := handshake(reflex.CookiePadding(first.RetryCookie))
+	if second.RetryCookie != nil || second.KeyConfirm == nil {
+		t.Fatalf("expected completed handshake after echoing cookie, got %+v", second)
+	}
+}
+
+func TestReflexClockOffsetFromRejection(t *testing.T) {
+	handler, userID := newReflexTestHandlerWithClient(t)
+
+	// Pretend the client clock is 20 minutes behind the server.
+	skewedNow := time.Now().Add(-20 * time.Minute)
+
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+	go func() {
+		_ = handler.Process(context.Background(), xnet.Network_TCP, stat.Connection(serverConn), nil)
+	}()
+	hs := buildReflexMagicHandshake(userID, skewedNow.Unix())
+	go func() { _, _ = clientConn.Write(hs) }()
+
+	_ = clientConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	resp, err := http.ReadResponse(bufio.NewReader(clientConn), nil)
+	if err != nil {
+		t.Fatalf("failed to read response: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected 403 for skewed clock, got %d", resp.StatusCode)
+	}
+
+	offset, err := reflex.ClockOffset(resp.Header.Get("Date"), skewedNow)
+	if err != nil {
+		t.Fatalf("failed to parse Date header: %v", err)
+	}
+	corrected := skewedNow.Add(offset)
+	if d := time.Since(corrected); d > 2*time.Second || d < -2*time.Second {
+		t.Fatalf("corrected clock is off by %v", d)
+	}
+}
+
+func TestReflexPSKHandshake(t *testing.T) {
+	userID := uuid.New()
+	psk := bytes.Repeat([]byte{0x42}, 32)
+	handler, err := inbound.New(context.Background(), &reflex.InboundConfig{
+		Clients: []*reflex.User{{Id: userID.String(), Psk: base64.StdEncoding.EncodeToString(psk)}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dispatcher := newReflexReplyDispatcher("pong")
+
+	hs := &reflex.PSKHandshake{Timestamp: time.Now().Unix()}
+	copy(hs.UserID[:], userID[:])
+	_, _ = rand.Read(hs.Nonce[:])
+	packet := reflex.MarshalPSKHandshake(hs, psk)
+
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	go func() {
+		_ = handler.Process(context.Background(), xnet.Network_TCP, stat.Connection(serverConn), dispatcher)
+	}()
+
+	// No round trip: the first data frame follows the handshake directly.
+	session, err := reflex.NewClientSession(reflex.DerivePSKSessionKey(psk, packet[4:len(packet)-32]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var flight bytes.Buffer
+	flight.Write(packet)
+	_ = session.WriteFrame(&flight, reflex.FrameTypeData, []byte("ping"))
+	go func() { _, _ = clientConn.Write(flight.Bytes()) }()
+
+	_ = clientConn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	frame, err := session.ReadFrame(clientConn)
+	if err != nil {
+		t.Fatalf("failed to read response frame: %v", err)
+	}
+	if string(frame.Payload) != "pong" {
+		t.Fatalf("unexpected response payload %q", frame.Payload)
+	}
+	if got := <-dispatcher.payloads; string(got) != "ping" {
+		t.Fatalf("dispatcher received %q", got)
+	}
+
+	// Replaying the same handshake must be refused.
+	replayClient, replayServer := net.Pipe()
+	defer replayClient.Close()
+	go func() {
+		_ = handler.Process(context.Background(), xnet.Network_TCP, stat.Connection(replayServer), dispatcher)
+	}()
+	go func() { _, _ = replayClient.Write(packet) }()
+	_ = replayClient.SetReadDeadline(time.Now().Add(2 * time.Second))
+	statusLine, err := bufio.NewReader(replayClient).ReadString('\n')
+	if err != nil || !strings.Contains(statusLine, "403") {
+		t.Fatalf("expected 403 for replayed PSK handshake, got %q (%v)", statusLine, err)
+	}
+}
+
+func TestReflexPSKHandshakeWrongKey(t *testing.T) {
+	userID := uuid.New()
+	handler, err := inbound.New(context.Background(), &reflex.InboundConfig{
+		Clients: []*reflex.User{{Id: userID.String(), Psk: base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hs := &reflex.PSKHandshake{Timestamp: time.Now().Unix()}
+	copy(hs.UserID[:], userID[:])
+	packet := reflex.MarshalPSKHandshake(hs, bytes.Repeat([]byte{2}, 32))
+
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	go func() {
+		_ = handler.Process(context.Background(), xnet.Network_TCP, stat.Connection(serverConn), nil)
+	}()
+	go func() { _, _ = clientConn.Write(packet) }()
+
+	_ = clientConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	statusLine, err := bufio.NewReader(clientConn).ReadString('\n')
+	if err != nil || !strings.Contains(statusLine, "403") {
+		t.Fatalf("expected 403 for wrong PSK, got %q (%v)", statusLine, err)
+	}
+}
+
+func TestReflexPSKHandshakeChecksClockFirst(t *testing.T) {
+	handler, err := inbound.New(context.Background(), &reflex.InboundConfig{
+		Clients: []*reflex.User{{Id: uuid.New().String(), Psk: base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A stale handshake is refused for its clock before any user is looked
+	// up, so its answer does not depend on the user ID it carries.
+	hs := &reflex.PSKHandshake{Timestamp: time.Now().Add(-2 * reflex.MaxClockSkew).Unix()}
+	stranger := uuid.New()
+	copy(hs.UserID[:], stranger[:])
+	packet := reflex.MarshalPSKHandshake(hs, bytes.Repeat([]byte{2}, 32))
+
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	go func() {
+		_ = handler.Process(context.Background(), xnet.Network_TCP, stat.Connection(serverConn), nil)
+	}()
+	go func() { _, _ = clientConn.Write(packet) }()
+
+	_ = clientConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	answer, err := io.ReadAll(clientConn)
+	if err != nil || !bytes.Contains(answer, []byte("invalid timestamp")) {
+		t.Fatalf("expected a clock skew refusal, got %q (%v)", answer, err)
+	}
+}
+
+func TestReflexStrangerNonceNotRecorded(t *testing.T) {
+	handler, userID := newReflexTestHandlerWithClient(t)
+	addr := serveReflexPort(t, handler)
+	hello := &handshake.Client{UserID: uuid.New(), Timestamp: time.Now().Unix(), Padding: reflex.NewHandshakePadding()}
+	_, _ = rand.Read(hello.PublicKey[:])
+	_, _ = rand.Read(hello.Nonce[:])
+
+	status := func() int {
+		t.Helper()
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+		if _, err := conn.Write(hello.Marshal()); err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := status(); code != http.StatusForbidden {
+		t.Fatalf("stranger got %d", code)
+	}
+	// The stranger's nonce was not recorded, so the user's handshake with
+	// the same one goes through.
+	hello.UserID = userID
+	if code := status(); code != http.StatusOK {
+		t.Fatalf("handshake reusing a stranger's nonce got %d", code)
+	}
+	if code := status(); code != http.StatusForbidden {
+		t.Fatalf("replay got %d", code)
+	}
+}
@@ -0,0 +1,116 @@
+package tests
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/xtls/xray-core/common"
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/proxy/reflex/inbound"
+)
+
+func TestReflexInterferenceNetwork(t *testing.T) {
+	for addr, want := range map[string]string{
+		"203.0.113.77:443":      "203.0.113.0/24",
+		"[2001:db8:1:2::5]:443": "2001:db8:1::/48",
+		"unix-socket-peer":      "unix-socket-peer",
+		"198.51.100.9":          "198.51.100.0/24",
+	} {
+		if got := reflex.InterferenceNetwork(addr); got != want {
+			t.Errorf("InterferenceNetwork(%q) = %q, want %q", addr, got, want)
+		}
+	}
+}
+
+func TestReflexInterferenceLogBurst(t *testing.T) {
+	l := reflex.NewInterferenceLog()
+	now := time.Now()
+	reset := func(at time.Time) *reflex.InterferenceEvent {
+		return l.Record(&reflex.InterferenceEvent{Kind: reflex.InterferenceResetAfterHandshake, Time: at, Network: "203.0.113.0/24"})
+	}
+	if reset(now.Add(-2*reflex.ResetBurstWindow)) != nil || reset(now.Add(-time.Second)) != nil || reset(now) != nil {
+		t.Fatal("resets spread over more than the burst window reported as a burst")
+	}
+	burst := reset(now.Add(time.Second))
+	if burst == nil || burst.Kind != reflex.InterferenceResetBurst {
+		t.Fatalf("expected a reset burst, got %+v", burst)
+	}
+	networks := l.Networks()
+	if len(networks) != 1 || networks[0].Events[reflex.InterferenceResetAfterHandshake] != 4 || networks[0].Events[reflex.InterferenceResetBurst] != 1 {
+		t.Fatalf("unexpected networks %+v", networks)
+	}
+}
+
+func TestReflexInterferenceThroughputCollapse(t *testing.T) {
+	events := make(chan *reflex.InterferenceEvent, 4)
+	m := reflex.NewInterferenceMonitor("203.0.113.5:1000", func(e *reflex.InterferenceEvent) { events <- e })
+	m.Received(256 << 10)
+	time.Sleep(reflex.InterferenceWindow + 50*time.Millisecond)
+	// The peer was idle for a window: no collapse, measuring restarts.
+	m.Received(256 << 10)
+	for i := 0; i < 10; i++ {
+		time.Sleep(100 * time.Millisecond)
+		m.Received(256 << 10)
+	}
+	// Frames keep coming, but only a trickle of data.
+	for i := 0; i < 22; i++ {
+		time.Sleep(100 * time.Millisecond)
+		m.Received(100)
+	}
+	select {
+	case e := <-events:
+		if e.Kind != reflex.InterferenceThroughputCollapse || e.Network != "203.0.113.0/24" {
+			t.Fatalf("unexpected event %v", e)
+		}
+	default:
+		t.Fatal("throughput collapse was not reported")
+	}
+	if len(events) != 0 {
+		t.Fatal("collapse reported more than once")
+	}
+}
+
+func TestReflexInterferenceResetsAfterHandshake(t *testing.T) {
+	userID := uuid.New()
+	handler, err := inbound.New(context.Background(), &reflex.InboundConfig{
+		Clients:      []*reflex.User{{Id: userID.String()}},
+		DrainTimeout: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer handler.(common.Closable).Close()
+	addr := serveReflexReplyPort(t, handler, "pong")
+
+	for i := 0; i < reflex.ResetBurstCount; i++ {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+		if _, err := reflex.ClientHandshake(conn, &reflex.ClientOptions{UserID: userID}); err != nil {
+			t.Fatal(err)
+		}
+		// What a middlebox does: tear the connection down with a RST.
+		_ = conn.(*net.TCPConn).SetLinger(0)
+		conn.Close()
+	}
+
+	for i := 0; ; i++ {
+		networks := handler.(*inbound.Handler).Interference()
+		if len(networks) == 1 && networks[0].Events[reflex.InterferenceResetBurst] == 1 {
+			if networks[0].Network != "127.0.0.0/24" || networks[0].Events[reflex.InterferenceResetAfterHandshake] != reflex.ResetBurstCount {
+				t.Fatalf("unexpected interference %+v", networks)
+			}
+			break
+		}
+		if i == 100 {
+			t.Fatalf("reset burst not detected: %+v", networks)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
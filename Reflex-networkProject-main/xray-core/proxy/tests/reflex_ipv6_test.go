@@ -0,0 +1,134 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/proxy/reflex/inbound"
+	"github.com/xtls/xray-core/transport/internet/stat"
+)
+
+func TestReflexFallbackDest(t *testing.T) {
+	for _, tc := range []struct {
+		dest, target string
+	}{
+		{"80", "127.0.0.1:80"},
+		{"127.0.0.2:8080", "127.0.0.2:8080"},
+		{"[::1]:80", "[::1]:80"},
+		{"[2001:db8::1]:443", "[2001:db8::1]:443"},
+		{"localhost:80", "localhost:80"},
+	} {
+		host, port, err := reflex.ParseFallbackDest(tc.dest)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.dest, err)
+		}
+		if got := (&reflex.Fallback{Dest: port, Address: host}).Target(); got != tc.target {
+			t.Fatalf("%s: target %s, expected %s", tc.dest, got, tc.target)
+		}
+	}
+	// An address given in brackets is dialed the same.
+	if got := (&reflex.Fallback{Dest: 80, Address: "[::1]"}).Target(); got != "[::1]:80" {
+		t.Fatalf("bracketed address: target %s", got)
+	}
+	for _, dest := range []string{"::1:80", "[::1]", ":80", "0", "[::1]:65536", ""} {
+		if _, _, err := reflex.ParseFallbackDest(dest); err == nil {
+			t.Fatalf("%q was accepted", dest)
+		}
+	}
+}
+
+func TestReflexSourceHostDualStack(t *testing.T) {
+	for addr, want := range map[string]string{
+		"192.0.2.1:443":           "192.0.2.1",
+		"[::ffff:192.0.2.1]:443":  "192.0.2.1",
+		"[2001:DB8::1]:443":       "2001:db8::1",
+		"[fe80::1%eth0]:443":      "fe80::1",
+		"2001:db8::1":             "2001:db8::1",
+		"unix-peer":               "unix-peer",
+		"[::ffff:198.51.100.7]:1": "198.51.100.7",
+	} {
+		if got := reflex.SourceHost(addr); got != want {
+			t.Fatalf("SourceHost(%s) = %s, expected %s", addr, got, want)
+		}
+	}
+	// A mapped peer is blocked on the same network as the IPv4 one.
+	if a, b := reflex.InterferenceNetwork("[::ffff:192.0.2.1]:1"), reflex.InterferenceNetwork("192.0.2.9:2"); a != b {
+		t.Fatalf("networks %s and %s differ", a, b)
+	}
+}
+
+func TestReflexPolicyDestinationsIPv6(t *testing.T) {
+	grant := &reflex.PolicyGrant{Destinations: []string{"[::1]", "[2001:db8::1]:443", "2001:db8:1::/48", "192.0.2.0/24"}}
+	for _, tc := range []struct {
+		host  string
+		port  uint16
+		allow bool
+	}{
+		{"::1", 80, true},
+		{"[::1]", 80, true},
+		{"2001:db8::1", 443, true},
+		{"2001:db8::1", 80, false},
+		{"2001:db8:1::42", 22, true},
+		{"2001:db8:2::42", 22, false},
+		{"::ffff:192.0.2.5", 80, true},
+	} {
+		if got := grant.AllowsDestination(tc.host, tc.port); got != tc.allow {
+			t.Fatalf("AllowsDestination(%s, %d) = %v", tc.host, tc.port, got)
+		}
+	}
+}
+
+func TestReflexFallbackOverIPv6(t *testing.T) {
+	cover, err := net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		t.Skipf("no IPv6 loopback: %v", err)
+	}
+	defer cover.Close()
+	go func() {
+		// The self-check of the inbound connects first.
+		for {
+			conn, err := cover.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				buf := make([]byte, 1024)
+				n, _ := conn.Read(buf)
+				if bytes.HasPrefix(buf[:n], []byte("GET ")) {
+					_, _ = conn.Write([]byte("HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nv6"))
+				}
+			}()
+		}
+	}()
+
+	handler, err := inbound.New(context.Background(), &reflex.InboundConfig{
+		Fallback: &reflex.Fallback{Dest: uint32(cover.Addr().(*net.TCPAddr).Port), Address: "::1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = handler.Process(ctx, xnet.Network_TCP, stat.Connection(serverConn), nil)
+	}()
+
+	req := "GET / HTTP/1.1\r\nHost: example.com\r\nUser-Agent: test\r\nAccept: */*\r\n\r\n"
+	if _, err := clientConn.Write([]byte(req)); err != nil {
+		t.Fatal(err)
+	}
+	_ = clientConn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 1024)
+	n, _ := clientConn.Read(buf)
+	if !bytes.HasSuffix(buf[:n], []byte("v6")) {
+		t.Fatalf("fallback over IPv6 answered %q", buf[:n])
+	}
+}
@@ -0,0 +1,82 @@
+package tests
+
+import (
+	"bytes"
+	"crypto/rand"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/xtls/xray-core/proxy/reflex"
+)
+
+func TestReflexKeyCommitment(t *testing.T) {
+	key := make([]byte, 32)
+	_, _ = rand.Read(key)
+	client, err := reflex.NewClientSession(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := reflex.NewServerSession(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetKeyCommitment(true)
+	server.SetKeyCommitment(true)
+
+	var wire bytes.Buffer
+	if err := client.WriteFrame(&wire, reflex.FrameTypeData, []byte("committed")); err != nil {
+		t.Fatal(err)
+	}
+	committed := append([]byte(nil), wire.Bytes()...)
+	f, err := server.ReadFrame(&wire)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(f.Payload) != "committed" {
+		t.Fatalf("unexpected payload %q", f.Payload)
+	}
+
+	// A record whose commitment was altered is refused before it is opened.
+	if err := client.WriteFrame(&wire, reflex.FrameTypeData, []byte("committed")); err != nil {
+		t.Fatal(err)
+	}
+	tampered := wire.Bytes()
+	tampered[len(tampered)-1] ^= 1
+	if _, err := server.ReadFrame(&wire); err == nil {
+		t.Fatal("a record with a wrong key commitment was accepted")
+	}
+
+	// A peer that does not expect commitments cannot read the records.
+	plain, err := reflex.NewServerSession(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := plain.ReadFrame(bytes.NewReader(committed)); err == nil {
+		t.Fatal("a committed record was read without key commitment")
+	}
+}
+
+func TestReflexKeyCommitmentGranted(t *testing.T) {
+	handler, userID := newReflexTestHandlerWithClient(t)
+	addr := serveReflexReplyPort(t, handler, "pong")
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	c, err := reflex.ClientHandshake(conn, &reflex.ClientOptions{
+		UserID: userID,
+		Policy: &reflex.PolicyReq{Features: []string{reflex.FeatureKeyCommitment}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.Grant.HasFeature(reflex.FeatureKeyCommitment) {
+		t.Fatal("key commitment was not granted")
+	}
+	pingReflexSession(t, c)
+	pingReflexSession(t, c)
+}
@@ -0,0 +1,87 @@
+package tests
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/xtls/xray-core/common"
+	feature_inbound "github.com/xtls/xray-core/features/inbound"
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/proxy/reflex/command"
+	"github.com/xtls/xray-core/proxy/reflex/inbound"
+)
+
+func TestReflexKickUser(t *testing.T) {
+	kicked, kept := uuid.New(), uuid.New()
+	handler, err := inbound.New(context.Background(), &reflex.InboundConfig{
+		Clients:      []*reflex.User{{Id: kicked.String()}, {Id: kept.String()}},
+		DrainTimeout: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer handler.(common.Closable).Close()
+	addr := serveReflexReplyPort(t, handler, "pong")
+
+	manager := &fakeInboundManager{handlers: map[string]feature_inbound.Handler{}}
+	_ = manager.AddHandler(context.Background(), &fakeInboundHandler{tag: "reflex-in", in: handler})
+	_ = manager.AddHandler(context.Background(), &fakeInboundHandler{tag: "vless-in", in: &fakeVLESSInbound{}})
+	service := command.NewService(manager)
+
+	first, err := dialReflexClient(t, addr, kicked)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := dialReflexClient(t, addr, kicked)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := dialReflexClient(t, addr, kept)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pingReflexSession(t, first)
+	pingReflexSession(t, second)
+	pingReflexSession(t, other)
+
+	resp, err := service.KickUser(context.Background(), &command.KickUserRequest{Email: kicked.String(), Reason: "payment"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Sessions != 2 {
+		t.Fatalf("kicked %d sessions", resp.Sessions)
+	}
+	expectReflexTerminated(t, first, "kicked: payment")
+	expectReflexTerminated(t, second, "kicked: payment")
+	pingReflexSession(t, other)
+
+	// The user stays configured.
+	again, err := dialReflexClient(t, addr, kicked)
+	if err != nil {
+		t.Fatalf("kicked user must be able to connect again: %v", err)
+	}
+	pingReflexSession(t, again)
+
+	for _, tc := range []struct {
+		request *command.KickUserRequest
+		code    codes.Code
+	}{
+		{&command.KickUserRequest{}, codes.InvalidArgument},
+		{&command.KickUserRequest{Email: kicked.String(), Tag: "missing"}, codes.NotFound},
+		{&command.KickUserRequest{Email: kicked.String(), Tag: "vless-in"}, codes.InvalidArgument},
+	} {
+		if _, err := service.KickUser(context.Background(), tc.request); status.Code(err) != tc.code {
+			t.Fatalf("%v: expected %v, got %v", tc.request, tc.code, err)
+		}
+	}
+	// Sessions kicked before may not be gone yet, and are counted again.
+	resp, err = service.KickUser(context.Background(), &command.KickUserRequest{Email: kicked.String(), Tag: "reflex-in"})
+	if err != nil || resp.Sessions < 1 {
+		t.Fatalf("kick by tag: %v, %v", resp, err)
+	}
+	expectReflexTerminated(t, again, reflex.CloseReasonKicked)
+}
@@ -0,0 +1,100 @@
+package tests
+
+import (
+	"context"
+	"crypto/rand"
+	"io"
+	"math"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/xtls/xray-core/common"
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/proxy/reflex/inbound"
+)
+
+func auditedLeakage(t *testing.T, profile *reflex.TrafficProfile) *reflex.LeakageReport {
+	t.Helper()
+	key := make([]byte, 32)
+	_, _ = rand.Read(key)
+	session, err := reflex.NewServerSession(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	audit := reflex.NewLeakageAudit()
+	session.SetLeakageAudit(audit)
+	for i := 0; i < 40; i++ {
+		payload := make([]byte, 64<<(i%8))
+		if err := reflex.WriteFrameWithMorphing(session, io.Discard, reflex.FrameTypeData, payload, profile); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Control frames carry no plaintext and are not audited.
+	if err := reflex.WriteFrameWithMorphing(session, io.Discard, reflex.FrameTypePaddingCtrl, []byte{0, 1}, profile); err != nil {
+		t.Fatal(err)
+	}
+	return audit.Report()
+}
+
+func TestReflexLeakageAudit(t *testing.T) {
+	bare := auditedLeakage(t, nil)
+	if bare.Samples != 40 {
+		t.Fatalf("audited %d writes, want 40", bare.Samples)
+	}
+	if bare.SizeCorrelation < 0.99 {
+		t.Fatalf("unmorphed sizes correlate at %.3f", bare.SizeCorrelation)
+	}
+	if math.Abs(bare.SizeInformation-bare.SizeEntropy) > 1e-9 || bare.SizeEntropy != 3 {
+		t.Fatalf("unmorphed sizes reveal %.3f of %.3f bits, want all 3", bare.SizeInformation, bare.SizeEntropy)
+	}
+
+	fixed := &reflex.TrafficProfile{
+		Name:        "fixed",
+		PacketSizes: []reflex.PacketSizeDist{{Size: 10000, Weight: 1}},
+	}
+	morphed := auditedLeakage(t, fixed)
+	if morphed.SizeCorrelation != 0 || morphed.SizeInformation != 0 {
+		t.Fatalf("fixed-size morphing leaks: r=%.3f, %.3f bits", morphed.SizeCorrelation, morphed.SizeInformation)
+	}
+}
+
+func TestReflexLeakageAuditInbound(t *testing.T) {
+	userID := uuid.New()
+	handler, err := inbound.New(context.Background(), &reflex.InboundConfig{
+		Clients:      []*reflex.User{{Id: userID.String()}},
+		LeakageAudit: true,
+		DrainTimeout: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer handler.(common.Closable).Close()
+	addr := serveReflexReplyPort(t, handler, "pong")
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	c, err := reflex.ClientHandshake(conn, &reflex.ClientOptions{UserID: userID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pingReflexSession(t, c)
+	_ = conn.Close()
+
+	h := handler.(*inbound.Handler)
+	deadline := time.Now().Add(5 * time.Second)
+	for len(h.LeakageReports()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("no leakage report after the session ended")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if r := h.LeakageReports()[0]; r.Samples != 1 {
+		t.Fatalf("report covers %d writes, want 1", r.Samples)
+	}
+}
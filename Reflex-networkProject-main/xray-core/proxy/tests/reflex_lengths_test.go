@@ -0,0 +1,147 @@
+package tests
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/proxy/reflex/frame"
+)
+
+func TestReflexFrameMaskedRecordRoundTrip(t *testing.T) {
+	rec := &frame.Record{
+		Nonce:      bytes.Repeat([]byte{0x01}, 12),
+		Ciphertext: bytes.Repeat([]byte{0x02}, 20),
+	}
+	mask := func(sample []byte) []byte { return bytes.Repeat([]byte{0xA5}, 32) }
+
+	var buf bytes.Buffer
+	if err := frame.WriteMaskedRecord(&buf, rec, mask); err != nil {
+		t.Fatal(err)
+	}
+	wire := buf.Bytes()
+	head := append([]byte(nil), wire[frame.SampleSize:frame.SampleSize+14]...)
+	for i := range head {
+		head[i] ^= 0xA5
+	}
+	if !bytes.Equal(wire[:frame.SampleSize], rec.Ciphertext[:frame.SampleSize]) ||
+		!bytes.Equal(head[:12], rec.Nonce) || binary.BigEndian.Uint16(head[12:]) != uint16(rec.Len()) ||
+		!bytes.Equal(wire[frame.SampleSize+14:], rec.Ciphertext[frame.SampleSize:]) {
+		t.Fatal("unexpected masked record layout")
+	}
+	got, err := frame.ReadMaskedRecord(&buf, 12, 16, mask)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Nonce, rec.Nonce) || !bytes.Equal(got.Ciphertext, rec.Ciphertext) {
+		t.Fatal("record mismatch after round trip")
+	}
+}
+
+func TestReflexMaskedLengths(t *testing.T) {
+	key := make([]byte, 32)
+	_, _ = rand.Read(key)
+	client, err := reflex.NewClientSession(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := reflex.NewServerSession(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.SetMaskedLengths(true); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.SetMaskedLengths(true); err != nil {
+		t.Fatal(err)
+	}
+
+	// The same payload, sealed again and again, shows neither its length
+	// nor its nonce: no bytes of the header stay the same from record to
+	// record, as the nonce prefix would, or count up, as its counter would.
+	var wire bytes.Buffer
+	var records [][]byte
+	for i := 0; i < 8; i++ {
+		start := wire.Len()
+		if err := client.WriteFrame(&wire, reflex.FrameTypeData, []byte("masked")); err != nil {
+			t.Fatal(err)
+		}
+		rec := wire.Bytes()[start:]
+		length := len(rec) - frame.LengthSize
+		if binary.BigEndian.Uint16(rec) == uint16(length) {
+			t.Fatal("a record starts with its length")
+		}
+		records = append(records, append([]byte(nil), rec...))
+	}
+	for off := 0; off+4 <= frame.SampleSize+12+frame.LengthSize; off++ {
+		same, counting := true, true
+		for i, rec := range records[1:] {
+			prev := binary.BigEndian.Uint32(records[i][off:])
+			cur := binary.BigEndian.Uint32(rec[off:])
+			same = same && cur == prev
+			counting = counting && cur == prev+1
+		}
+		if same || counting {
+			t.Fatalf("the bytes at %d of the header are in clear", off)
+		}
+	}
+	sealed := append([]byte(nil), wire.Bytes()...)
+	for i := 0; i < 8; i++ {
+		f, err := server.ReadFrame(&wire)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(f.Payload) != "masked" {
+			t.Fatalf("unexpected payload %q", f.Payload)
+		}
+	}
+
+	// A peer without the length key cannot cut the stream into records.
+	plain, err := reflex.NewServerSession(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := plain.ReadFrame(bytes.NewReader(sealed)); err == nil {
+		t.Fatal("a masked record was read without masked lengths")
+	}
+
+	// The mode is part of the session state.
+	restored, err := reflex.RestoreSession(client.State())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := restored.WriteFrame(&wire, reflex.FrameTypeData, []byte("moved")); err != nil {
+		t.Fatal(err)
+	}
+	if f, err := server.ReadFrame(&wire); err != nil || string(f.Payload) != "moved" {
+		t.Fatalf("restored session: %v", err)
+	}
+}
+
+func TestReflexMaskedLengthsGranted(t *testing.T) {
+	handler, userID := newReflexTestHandlerWithClient(t)
+	addr := serveReflexReplyPort(t, handler, "pong")
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	c, err := reflex.ClientHandshake(conn, &reflex.ClientOptions{
+		UserID: userID,
+		Policy: &reflex.PolicyReq{Features: []string{reflex.FeatureMaskedLengths}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.Grant.HasFeature(reflex.FeatureMaskedLengths) {
+		t.Fatal("masked lengths were not granted")
+	}
+	pingReflexSession(t, c)
+	pingReflexSession(t, c)
+}
@@ -0,0 +1,140 @@
+package tests
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/xtls/xray-core/common"
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/proxy/reflex/inbound"
+)
+
+func TestReflexResourceBudget(t *testing.T) {
+	sessions := reflex.NewResourceBudget(1, 0, 0, reflex.LimitClose)
+	if !sessions.Admit() || sessions.Admit() {
+		t.Fatal("expected exactly one session to be admitted")
+	}
+	sessions.Release()
+	if !sessions.Admit() {
+		t.Fatal("released slot must be reusable")
+	}
+
+	rate := reflex.NewResourceBudget(0, 2, 0, reflex.LimitClose)
+	if !rate.Admit() || !rate.Admit() || rate.Admit() {
+		t.Fatal("expected two handshakes per second")
+	}
+
+	queue := reflex.NewResourceBudget(1, 0, 0, reflex.LimitQueue)
+	queue.Admit()
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		queue.Release()
+	}()
+	start := time.Now()
+	if !queue.Admit() {
+		t.Fatal("queued connection must be admitted once a slot frees up")
+	}
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Fatalf("queued connection was not made to wait: %v", elapsed)
+	}
+
+	buffer := reflex.NewResourceBudget(0, 0, 10, reflex.LimitClose)
+	if !buffer.Reserve(8) || buffer.Reserve(8) || !buffer.Reserve(2) {
+		t.Fatal("unexpected buffer reservations")
+	}
+	buffer.Free(10)
+	if !buffer.Reserve(100) {
+		t.Fatal("a frame larger than the budget must pass when nothing else is buffered")
+	}
+
+	var unlimited *reflex.ResourceBudget
+	if !unlimited.Admit() || !unlimited.Reserve(1<<30) {
+		t.Fatal("nil budget must admit everything")
+	}
+}
+
+func TestReflexSessionLimitFallsBack(t *testing.T) {
+	// The cover site answers anything with a teapot.
+	cover, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cover.Close()
+	go func() {
+		for {
+			conn, err := cover.Accept()
+			if err != nil {
+				return
+			}
+			_, _ = conn.Write([]byte("HTTP/1.1 418 I'm a teapot\r\nContent-Length: 0\r\n\r\n"))
+			conn.Close()
+		}
+	}()
+
+	userID := uuid.New()
+	handler, err := inbound.New(context.Background(), &reflex.InboundConfig{
+		Clients:      []*reflex.User{{Id: userID.String()}},
+		Fallback:     &reflex.Fallback{Dest: uint32(cover.Addr().(*net.TCPAddr).Port)},
+		Limits:       &reflex.ResourceLimits{MaxSessions: 1, Action: "fallback"},
+		DrainTimeout: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer handler.(common.Closable).Close()
+	addr := serveReflexReplyPort(t, handler, "pong")
+
+	first, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = first.SetDeadline(time.Now().Add(5 * time.Second))
+	c, err := reflex.ClientHandshake(first, &reflex.ClientOptions{UserID: userID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pingReflexSession(t, c)
+
+	// Over the limit, a handshake reaches the cover site.
+	status := func() int {
+		t.Helper()
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+		var pub [32]byte
+		_, _ = rand.Read(pub[:])
+		if _, err := conn.Write(buildReflexMagicHandshakeFull(userID, time.Now().Unix(), pub, nil, nil)); err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode
+	}
+	if code := status(); code != http.StatusTeapot {
+		t.Fatalf("expected the cover site to answer, got %d", code)
+	}
+
+	// Ending the session frees its slot.
+	first.Close()
+	for i := 0; ; i++ {
+		if code := status(); code == http.StatusOK {
+			break
+		}
+		if i == 50 {
+			t.Fatal("slot was not released after the session ended")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,66 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/xtls/xray-core/proxy/reflex"
+)
+
+func TestReflexLoginTrackerLimit(t *testing.T) {
+	now := time.Now()
+	tracker := reflex.NewLoginTracker(time.Minute, 2, reflex.LoginLimit)
+	var alerts int
+	tracker.OnAlert = func(user string, sources int) { alerts++ }
+
+	if !tracker.Observe("u", "10.0.0.1", now) || !tracker.Observe("u", "10.0.0.2", now) {
+		t.Fatal("sources within the limit must be allowed")
+	}
+	if tracker.Observe("u", "10.0.0.3", now) {
+		t.Fatal("third source must be refused under limit")
+	}
+	if !tracker.Observe("u", "10.0.0.1", now) {
+		t.Fatal("known source must still be allowed under limit")
+	}
+	if !tracker.Observe("other", "10.0.0.3", now) {
+		t.Fatal("limit must be per user")
+	}
+	if alerts != 1 {
+		t.Fatalf("expected 1 alert, got %d", alerts)
+	}
+
+	// Once the window has passed the old sources no longer count.
+	if !tracker.Observe("u", "10.0.0.3", now.Add(2*time.Minute)) {
+		t.Fatal("new source must be allowed after the window")
+	}
+}
+
+func TestReflexLoginTrackerBlock(t *testing.T) {
+	now := time.Now()
+	tracker := reflex.NewLoginTracker(time.Minute, 1, reflex.LoginBlock)
+
+	if !tracker.Observe("u", "10.0.0.1", now) {
+		t.Fatal("first source must be allowed")
+	}
+	if tracker.Observe("u", "10.0.0.2", now) {
+		t.Fatal("second source must be blocked")
+	}
+	if tracker.Observe("u", "10.0.0.1", now) {
+		t.Fatal("block must apply to known sources too")
+	}
+	if !tracker.Observe("u", "10.0.0.1", now.Add(2*time.Minute)) {
+		t.Fatal("user must be unblocked after the window")
+	}
+}
+
+func TestReflexParseLoginAction(t *testing.T) {
+	if a, err := reflex.ParseLoginAction(""); err != nil || a != reflex.LoginAllow {
+		t.Fatalf("empty action: %v %v", a, err)
+	}
+	if a, err := reflex.ParseLoginAction("alert"); err != nil || a != reflex.LoginAlert {
+		t.Fatalf("alert action: %v %v", a, err)
+	}
+	if _, err := reflex.ParseLoginAction("kick"); err == nil {
+		t.Fatal("unknown action must be rejected")
+	}
+}
@@ -0,0 +1,95 @@
+package tests
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/proxy/reflex/inbound"
+	"github.com/xtls/xray-core/transport/internet/stat"
+)
+
+func TestReflexLogSampler(t *testing.T) {
+	l := reflex.NewLogSampler(2, time.Minute)
+	start := time.Now()
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.Sample(reflex.LogEventReplay, start); !ok {
+			t.Fatalf("event %d of the burst was suppressed", i)
+		}
+	}
+	for i := 0; i < 5; i++ {
+		if ok, _ := l.Sample(reflex.LogEventReplay, start.Add(time.Second)); ok {
+			t.Fatal("an event past the burst was logged")
+		}
+	}
+	// Kinds are sampled apart.
+	if ok, _ := l.Sample(reflex.LogEventProbe, start); !ok {
+		t.Fatal("another kind was suppressed")
+	}
+	// The next interval logs again and reports what was suppressed.
+	ok, suppressed := l.Sample(reflex.LogEventReplay, start.Add(time.Minute))
+	if !ok || suppressed != 5 {
+		t.Fatalf("next interval: logged %v, suppressed %d", ok, suppressed)
+	}
+	if ok, suppressed := l.Sample(reflex.LogEventReplay, start.Add(time.Minute)); !ok || suppressed != 0 {
+		t.Fatalf("suppressed events were reported twice: %d", suppressed)
+	}
+	if got := l.Suppressed(); len(got) != 1 || got[reflex.LogEventReplay] != 5 {
+		t.Fatalf("suppressed counts %v", got)
+	}
+
+	var none *reflex.LogSampler
+	if ok, _ := none.Sample(reflex.LogEventMorph, start); !ok {
+		t.Fatal("a nil sampler suppressed an event")
+	}
+}
+
+func TestReflexProbeLogsSampled(t *testing.T) {
+	cover, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cover.Close()
+	go func() {
+		for {
+			conn, err := cover.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, _ = io.Copy(io.Discard, conn)
+				conn.Close()
+			}()
+		}
+	}()
+	handler, err := inbound.New(context.Background(), &reflex.InboundConfig{
+		Fallback:    &reflex.Fallback{Dest: uint32(cover.Addr().(*net.TCPAddr).Port)},
+		LogSampling: &reflex.LogSampling{Burst: 1, Interval: 3600},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := handler.(*inbound.Handler)
+
+	probe := make([]byte, 128)
+	for i := 0; i < 4; i++ {
+		client, server := net.Pipe()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			_ = h.Process(context.Background(), xnet.Network_TCP, stat.Connection(server), nil)
+		}()
+		if _, err := client.Write(probe); err != nil {
+			t.Fatal(err)
+		}
+		client.Close()
+		<-done
+	}
+	if got := h.SuppressedLogs()[reflex.LogEventProbe]; got != 3 {
+		t.Fatalf("%d probe hits suppressed, expected 3", got)
+	}
+}
@@ -0,0 +1,65 @@
+package tests
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/proxy/reflex/inbound"
+)
+
+func TestReflexLowPowerMode(t *testing.T) {
+	handler, userID := newReflexTestHandlerWithClient(t)
+	h := handler.(*inbound.Handler)
+	dispatcher := newReflexReplyDispatcher("pong")
+	addr := serveReflexDispatcher(t, handler, dispatcher)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	rec := &writeRecorder{Conn: conn}
+	power := &reflex.PowerMode{}
+	c, err := reflex.ClientHandshake(rec, &reflex.ClientOptions{UserID: userID, Power: power})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fixed := &reflex.TrafficProfile{Name: "fixed", PacketSizes: []reflex.PacketSizeDist{{Size: 700, Weight: 1}}}
+	if _, err := h.PushProfile("", fixed); err != nil {
+		t.Fatal(err)
+	}
+	pingReflexSession(t, c)
+	<-dispatcher.payloads
+
+	power.SetLowPower(true)
+	pingReflexSession(t, c)
+	if n := len(rec.writes[len(rec.writes)-1]); n != 2+12+16+1+len("ping") {
+		t.Fatalf("frame in low-power mode took %d bytes on the wire", n)
+	}
+	<-dispatcher.payloads
+
+	power.SetLowPower(false)
+	pingReflexSession(t, c)
+	if n := len(rec.writes[len(rec.writes)-1]); n != 2+12+16+1+700 {
+		t.Fatalf("frame back in normal mode took %d bytes on the wire", n)
+	}
+	<-dispatcher.payloads
+
+	power.SetLowPower(true)
+	// What the application writes in quick succession goes out in one frame.
+	app, local := net.Pipe()
+	defer app.Close()
+	go reflex.Tunnel(local, c)
+	for _, part := range []string{"pi", "ng"} {
+		if _, err := app.Write([]byte(part)); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := string(<-dispatcher.payloads); got != "ping" {
+		t.Fatalf("server got %q from the batched writes", got)
+	}
+}
@@ -0,0 +1,141 @@
+package tests
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/proxy/reflex/migrate"
+)
+
+const vlessFleetConfig = `{
+  "log": { "loglevel": "warning" },
+  "inbounds": [
+    {
+      "tag": "edge",
+      "port": 443,
+      "protocol": "vless",
+      "settings": {
+        "clients": [
+          { "id": "27848739-7e62-4138-9fd3-098a63964b6b", "flow": "xtls-rprx-vision", "email": "a@example.com" },
+          { "id": "6f4f1d5e-3c1b-4b5e-8a0e-5a4f6d0c9b1a", "level": 1 }
+        ],
+        "decryption": "none",
+        "fallbacks": [
+          { "dest": "[::1]:8080" },
+          { "path": "/ws", "dest": 2001 }
+        ]
+      },
+      "streamSettings": { "network": "tcp", "security": "tls", "tlsSettings": { "serverName": "example.com" } }
+    },
+    { "tag": "socks", "port": 1080, "protocol": "socks" }
+  ],
+  "outbounds": [
+    {
+      "protocol": "vless",
+      "settings": {
+        "vnext": [
+          { "address": "example.com", "port": 443, "users": [ { "id": "27848739-7e62-4138-9fd3-098a63964b6b", "encryption": "none" } ] }
+        ]
+      }
+    },
+    { "protocol": "freedom" }
+  ]
+}`
+
+func TestReflexMigrateVLESSConfig(t *testing.T) {
+	result, err := migrate.Convert([]byte(vlessFleetConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		Inbounds  []map[string]json.RawMessage `json:"inbounds"`
+		Outbounds []map[string]json.RawMessage `json:"outbounds"`
+		Log       json.RawMessage              `json:"log"`
+	}
+	if err := json.Unmarshal(result.Config, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Log == nil {
+		t.Fatal("the rest of the configuration was not kept")
+	}
+	edge := got.Inbounds[0]
+	if string(edge["protocol"]) != `"reflex"` || !strings.Contains(string(edge["streamSettings"]), `"tls"`) {
+		t.Fatalf("edge inbound converted to %s", edge)
+	}
+	if string(got.Inbounds[1]["protocol"]) != `"socks"` || string(got.Outbounds[1]["protocol"]) != `"freedom"` {
+		t.Fatal("blocks of other protocols were converted")
+	}
+
+	var inbound struct {
+		Clients []struct {
+			ID    string `json:"id"`
+			Level uint32 `json:"level"`
+			Email string `json:"email"`
+			Flow  string `json:"flow"`
+		} `json:"clients"`
+		Fallback struct {
+			Dest string `json:"dest"`
+		} `json:"fallback"`
+	}
+	if err := json.Unmarshal(edge["settings"], &inbound); err != nil {
+		t.Fatal(err)
+	}
+	if len(inbound.Clients) != 2 || inbound.Clients[0].Email != "a@example.com" || inbound.Clients[0].Flow != "" || inbound.Clients[1].Level != 1 {
+		t.Fatalf("clients converted to %+v", inbound.Clients)
+	}
+	host, port, err := reflex.ParseFallbackDest(inbound.Fallback.Dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target := (&reflex.Fallback{Dest: port, Address: host}).Target(); target != "[::1]:8080" {
+		t.Fatalf("fallback converted to %s", target)
+	}
+
+	var outbound struct {
+		Address string `json:"address"`
+		Port    uint16 `json:"port"`
+		ID      string `json:"id"`
+	}
+	if err := json.Unmarshal(got.Outbounds[0]["settings"], &outbound); err != nil {
+		t.Fatal(err)
+	}
+	if outbound.Address != "example.com" || outbound.Port != 443 || outbound.ID != "27848739-7e62-4138-9fd3-098a63964b6b" {
+		t.Fatalf("outbound converted to %+v", outbound)
+	}
+
+	// The flow and the fallback by path cannot be carried over.
+	warnings := strings.Join(result.Warnings, "\n")
+	for _, want := range []string{"inbounds[0] (edge): the flow of 1 client(s)", "1 fallback(s) by name, ALPN or path"} {
+		if !strings.Contains(warnings, want) {
+			t.Fatalf("no warning %q in:\n%s", want, warnings)
+		}
+	}
+}
+
+func TestReflexMigrateVLESSBlock(t *testing.T) {
+	result, err := migrate.Convert([]byte(`{"protocol":"vless","settings":{"address":"192.0.2.1","port":8443,"id":"27848739-7e62-4138-9fd3-098a63964b6b","flow":"xtls-rprx-vision"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(result.Config), `"address": "192.0.2.1"`) || len(result.Warnings) != 1 {
+		t.Fatalf("converted to %s with warnings %v", result.Config, result.Warnings)
+	}
+
+	// A Unix socket fallback has no Reflex equivalent.
+	result, err = migrate.Convert([]byte(`{"protocol":"vless","settings":{"clients":[{"id":"27848739-7e62-4138-9fd3-098a63964b6b"}],"fallbacks":[{"dest":"/dev/shm/h1.sock"}]}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(result.Config), "fallback") || len(result.Warnings) != 1 {
+		t.Fatalf("converted to %s with warnings %v", result.Config, result.Warnings)
+	}
+
+	if _, err := migrate.Convert([]byte(`{"protocol":"vmess","settings":{}}`)); err == nil {
+		t.Fatal("a VMess block was converted")
+	}
+	if _, err := migrate.Convert([]byte(`{"inbounds":[{"protocol":"socks"}]}`)); err == nil {
+		t.Fatal("a configuration without VLESS was converted")
+	}
+}
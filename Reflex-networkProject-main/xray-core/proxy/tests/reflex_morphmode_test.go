@@ -0,0 +1,119 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/xtls/xray-core/common"
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/proxy/reflex/inbound"
+)
+
+func TestReflexMorphingModes(t *testing.T) {
+	slow := &reflex.TrafficProfile{
+		Name:        "slow",
+		PacketSizes: []reflex.PacketSizeDist{{Size: 100, Weight: 1}},
+		Delays:      []reflex.DelayDist{{Delay: 200 * time.Millisecond, Weight: 1}},
+	}
+	for _, tc := range []struct {
+		mode    string
+		size    int
+		delayed bool
+	}{
+		{"", 100, true},
+		{"full", 100, true},
+		{"padding-only", 100, false},
+		{"off", 5, false},
+	} {
+		mode, err := reflex.ParseMorphingMode(tc.mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		key := bytes.Repeat([]byte{7}, 32)
+		client, _ := reflex.NewClientSession(key)
+		server, _ := reflex.NewServerSession(key)
+		client.SetMorphing(mode)
+
+		var wire bytes.Buffer
+		start := time.Now()
+		if err := reflex.WriteFrameWithMorphing(client, &wire, reflex.FrameTypeData, []byte("hello"), slow); err != nil {
+			t.Fatal(err)
+		}
+		written := wire.Len()
+		if delayed := time.Since(start) >= 200*time.Millisecond; delayed != tc.delayed {
+			t.Errorf("%q: delayed %v, want %v", tc.mode, delayed, tc.delayed)
+		}
+		f, err := server.ReadFrame(&wire)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sent := written - 2 - 12 - 16 - 1; sent != tc.size {
+			t.Errorf("%q: sent %d bytes, want %d", tc.mode, sent, tc.size)
+		}
+		if string(f.Payload) != "hello" {
+			t.Errorf("%q: read %q", tc.mode, f.Payload)
+		}
+	}
+	if _, err := reflex.ParseMorphingMode("partial"); err == nil {
+		t.Fatal("unknown mode parsed")
+	}
+}
+
+func TestReflexMorphingPerUser(t *testing.T) {
+	plain, shaped := uuid.New(), uuid.New()
+	handler, err := inbound.New(context.Background(), &reflex.InboundConfig{
+		Clients: []*reflex.User{
+			{Id: plain.String()},
+			{Id: shaped.String(), Morphing: "full"},
+		},
+		Morphing:     "off",
+		DrainTimeout: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer handler.(common.Closable).Close()
+	addr := serveReflexReplyPort(t, handler, "pong")
+
+	reply := func(u uuid.UUID) []byte {
+		c, err := dialReflexClient(t, addr, u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		if err := c.WriteFrame(reflex.FrameTypeData, []byte("ping")); err != nil {
+			t.Fatal(err)
+		}
+		f, err := c.ReadFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return f.Payload
+	}
+	// The inbound does not shape; the user that asks for it is shaped.
+	for _, u := range []uuid.UUID{plain, shaped} {
+		if got := reply(u); string(got) != "pong" {
+			t.Fatalf("reply %q", got)
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for handler.(*inbound.Handler).Overhead(shaped.String()).Padding == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("shaped reply not padded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if o := handler.(*inbound.Handler).Overhead(plain.String()); o.Padding != 0 {
+		t.Fatalf("unshaped reply padded: %+v", o)
+	}
+
+	if _, err := inbound.New(context.Background(), &reflex.InboundConfig{
+		Clients: []*reflex.User{{Id: plain.String(), Morphing: "sometimes"}},
+	}); err == nil {
+		t.Fatal("unknown per-user morphing mode accepted")
+	}
+}
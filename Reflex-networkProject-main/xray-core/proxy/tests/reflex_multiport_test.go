@@ -0,0 +1,100 @@
+package tests
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xtls/xray-core/common"
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/proxy"
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/transport/internet/stat"
+)
+
+// serveReflexPort feeds every connection accepted on a fresh loopback port to
+// handler, like one worker of a multi-port inbound.
+func serveReflexPort(t *testing.T, handler proxy.Inbound) string {
+	t.Helper()
+	return serveReflexPortAt(t, handler, "127.0.0.1:0")
+}
+
+// serveReflexPortAt is serveReflexPort on a given address.
+func serveReflexPortAt(t *testing.T, handler proxy.Inbound, address string) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go handler.Process(context.Background(), xnet.Network_TCP, stat.Connection(conn), nil)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestReflexInboundSharedAcrossPorts(t *testing.T) {
+	handler, userID := newReflexTestHandlerWithClient(t)
+	ports := []string{serveReflexPort(t, handler), serveReflexPort(t, handler)}
+
+	for _, addr := range ports {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+		if _, err := reflex.ClientHandshake(conn, &reflex.ClientOptions{UserID: userID}); err != nil {
+			t.Fatalf("handshake on %s failed: %v", addr, err)
+		}
+		conn.Close()
+	}
+
+	// Replay state is shared: a handshake seen on one port is refused on the other.
+	var pub [32]byte
+	_, _ = rand.Read(pub[:])
+	hs := buildReflexMagicHandshakeFull(userID, time.Now().Unix(), pub, nil, nil)
+	statuses := make([]int, 0, len(ports))
+	for _, addr := range ports {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+		if _, err := conn.Write(hs); err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		statuses = append(statuses, resp.StatusCode)
+		conn.Close()
+	}
+	if statuses[0] != http.StatusOK || statuses[1] != http.StatusForbidden {
+		t.Fatalf("expected replay on the second port to be refused, got %v", statuses)
+	}
+
+	// Every worker closes the handler when the inbound goes away.
+	var wg sync.WaitGroup
+	for range ports {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := handler.(common.Closable).Close(); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+}
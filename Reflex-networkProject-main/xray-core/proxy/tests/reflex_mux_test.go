@@ -0,0 +1,374 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/xtls/xray-core/common"
+	"github.com/xtls/xray-core/common/buf"
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/common/session"
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/proxy/reflex/inbound"
+	"github.com/xtls/xray-core/proxy/reflex/outbound"
+	"github.com/xtls/xray-core/transport"
+	"github.com/xtls/xray-core/transport/pipe"
+)
+
+func TestReflexMuxStreams(t *testing.T) {
+	handler, userID := newReflexTestHandlerWithClient(t)
+	dispatcher := &shoutDispatcher{}
+	addr := serveReflexDispatcher(t, handler, dispatcher)
+
+	c, err := dialReflexClientWith(t, addr, &reflex.ClientOptions{UserID: userID, Mux: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := reflex.NewMux(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	// Streams to several destinations at once, over the one handshake.
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			conn, err := m.Open("example.com:" + strconv.Itoa(8000+i))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer conn.Close()
+			_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+			msg := "stream-" + strconv.Itoa(i)
+			if _, err := conn.Write([]byte(msg)); err != nil {
+				t.Error(err)
+				return
+			}
+			// Stream data comes without the padding of its frames.
+			for _, want := range []string{"STREAM-" + strconv.Itoa(i), "push"} {
+				got := make([]byte, len(want))
+				if _, err := io.ReadFull(conn, got); err != nil || string(got) != want {
+					t.Errorf("stream %d read %q, %v, want %q", i, got, err, want)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	if n := dispatcher.links.Load(); n != 4 {
+		t.Fatalf("%d links dispatched, want one per stream", n)
+	}
+
+	// A stream the server cannot open is closed right away.
+	refused, err := m.Open("example.com:9")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = refused.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := refused.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("refused stream read %v, want EOF", err)
+	}
+}
+
+func TestReflexMuxLargeStreamData(t *testing.T) {
+	handler, userID := newReflexTestHandlerWithClient(t)
+	addr := serveReflexDispatcher(t, handler, &shoutDispatcher{})
+	c, err := dialReflexClientWith(t, addr, &reflex.ClientOptions{UserID: userID, Mux: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var largest atomic.Int32
+	c.Session.SetHooks(reflex.SessionHooks{OnFrameRead: func(f *reflex.Frame) {
+		if f.Type == reflex.FrameTypeStreamData && int32(len(f.Payload)) > largest.Load() {
+			largest.Store(int32(len(f.Payload)))
+		}
+	}})
+	m, err := reflex.NewMux(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	conn, err := m.Open("example.com:80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// More than one of the server's profile packets: the reply is sent in
+	// several STREAM_DATA frames, none of them cut by its padding.
+	large := bytes.Repeat([]byte("reflex"), 700)
+	if _, err := conn.Write(large); err != nil {
+		t.Fatal(err)
+	}
+	var got []byte
+	b := make([]byte, 1024)
+	for len(bytes.ReplaceAll(got, []byte("push"), nil)) < len(large) {
+		n, err := conn.Read(b)
+		if err != nil {
+			t.Fatalf("after %d bytes: %v", len(got), err)
+		}
+		got = append(got, b[:n]...)
+	}
+	if got = bytes.ReplaceAll(got, []byte("push"), nil); !bytes.Equal(got[:len(large)], bytes.ToUpper(large)) {
+		t.Fatalf("stream read %d bytes, %q...", len(got), got[:32])
+	}
+	var profileMax int
+	for _, d := range reflex.Profiles["http2-api"].PacketSizes {
+		profileMax = max(profileMax, d.Size)
+	}
+	if n := int(largest.Load()); n > profileMax {
+		t.Fatalf("STREAM_DATA frame of %d bytes, beyond the profile's %d", n, profileMax)
+	}
+}
+
+func TestReflexMuxStreamsSealedApart(t *testing.T) {
+	handler, userID := newReflexTestHandlerWithClient(t)
+	addr := serveReflexDispatcher(t, handler, &shoutDispatcher{})
+
+	c, err := dialReflexClientWith(t, addr, &reflex.ClientOptions{UserID: userID, Mux: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	var payloads [][]byte
+	record := func(frameType uint8, payload []byte) {
+		if frameType == reflex.FrameTypeStreamData {
+			mu.Lock()
+			payloads = append(payloads, bytes.Clone(payload))
+			mu.Unlock()
+		}
+	}
+	c.Session.SetHooks(reflex.SessionHooks{
+		OnFrameWrite: record,
+		OnFrameRead:  func(f *reflex.Frame) { record(f.Type, f.Payload) },
+	})
+	m, err := reflex.NewMux(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	conn, err := m.Open("example.com:8000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write([]byte("stream-secret")); err != nil {
+		t.Fatal(err)
+	}
+	got := make([]byte, len("STREAM-SECRET"))
+	if _, err := io.ReadFull(conn, got); err != nil || string(got) != "STREAM-SECRET" {
+		t.Fatalf("read %q, %v", got, err)
+	}
+
+	// Stream data is sealed with the stream's own key inside the frames
+	// of the session, so even the session's key does not show it.
+	mu.Lock()
+	defer mu.Unlock()
+	if len(payloads) < 2 {
+		t.Fatalf("%d STREAM_DATA frames, want both ways", len(payloads))
+	}
+	for _, p := range payloads {
+		if bytes.Contains(bytes.ToLower(p), []byte("stream-secret")) {
+			t.Fatalf("stream data not sealed apart: %q", p)
+		}
+	}
+}
+
+func TestReflexMuxNotGranted(t *testing.T) {
+	handler, userID := newReflexTestHandlerWithClient(t)
+	addr := serveReflexReplyPort(t, handler, "pong")
+
+	c, err := dialReflexClient(t, addr, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := reflex.NewMux(c); err == nil {
+		t.Fatal("mux without the feature")
+	}
+}
+
+func TestReflexOutboundMux(t *testing.T) {
+	u := uuid.New()
+	handler, err := inbound.New(context.Background(), &reflex.InboundConfig{
+		Clients:      []*reflex.User{{Id: u.String()}},
+		DrainTimeout: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer handler.(common.Closable).Close()
+	dispatcher := newReflexReplyDispatcher("pong")
+	server, err := xnet.ParseDestination("tcp:" + serveReflexDispatcher(t, handler, dispatcher))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ob, err := outbound.New(context.Background(), &reflex.OutboundConfig{Address: "127.0.0.1", Port: uint32(server.Port), Id: u.String(), Mux: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ob.Close()
+	dialer := &countingDialer{}
+
+	for _, host := range []string{"example.com", "example.org"} {
+		upReader, upWriter := pipe.New(pipe.WithoutSizeLimit())
+		downReader, downWriter := pipe.New(pipe.WithoutSizeLimit())
+		ctx, cancel := context.WithCancel(context.Background())
+		ctx = session.ContextWithOutbounds(ctx, []*session.Outbound{{Target: xnet.TCPDestination(xnet.DomainAddress(host), 80)}})
+		done := make(chan error, 1)
+		go func() {
+			done <- ob.Process(ctx, &transport.Link{Reader: upReader, Writer: downWriter}, dialer)
+		}()
+		b := buf.New()
+		b.WriteString("ping")
+		if err := upWriter.WriteMultiBuffer(buf.MultiBuffer{b}); err != nil {
+			t.Fatal(err)
+		}
+		mb, err := downReader.ReadMultiBuffer()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := mb.String(); got != "pong" {
+			t.Fatalf("downlink %q", got)
+		}
+		buf.ReleaseMulti(mb)
+		if got := (<-dispatcher.dests).NetAddr(); got != host+":80" {
+			t.Fatalf("dispatched to %s, want %s:80", got, host)
+		}
+		cancel()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Process did not return once its context ended")
+		}
+	}
+	if n := dialer.dials.Load(); n != 1 {
+		t.Fatalf("%d dials, want the connections to share one session", n)
+	}
+}
+
+// startOutboundConnection processes a connection to host:80 with ob under
+// ctx, and returns the application's ends of it and what Process returns.
+func startOutboundConnection(ctx context.Context, ob *outbound.Handler, dialer *countingDialer, host string) (*pipe.Writer, *pipe.Reader, chan error) {
+	upReader, upWriter := pipe.New(pipe.WithoutSizeLimit())
+	downReader, downWriter := pipe.New(pipe.WithoutSizeLimit())
+	ctx = session.ContextWithOutbounds(ctx, []*session.Outbound{{Target: xnet.TCPDestination(xnet.DomainAddress(host), 80)}})
+	done := make(chan error, 1)
+	go func() {
+		done <- ob.Process(ctx, &transport.Link{Reader: upReader, Writer: downWriter}, dialer)
+	}()
+	return upWriter, downReader, done
+}
+
+// pingOutboundConnection sends "ping" over a connection started with
+// startOutboundConnection and expects "pong".
+func pingOutboundConnection(t *testing.T, up *pipe.Writer, down *pipe.Reader) {
+	t.Helper()
+	b := buf.New()
+	b.WriteString("ping")
+	if err := up.WriteMultiBuffer(buf.MultiBuffer{b}); err != nil {
+		t.Fatal(err)
+	}
+	mb, err := down.ReadMultiBuffer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := mb.String(); got != "pong" {
+		t.Fatalf("downlink %q", got)
+	}
+	buf.ReleaseMulti(mb)
+}
+
+// newOutboundMux returns a mux outbound for a server that answers "pong".
+func newOutboundMux(t *testing.T) *outbound.Handler {
+	u := uuid.New()
+	handler, err := inbound.New(context.Background(), &reflex.InboundConfig{
+		Clients:      []*reflex.User{{Id: u.String()}},
+		DrainTimeout: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = handler.(common.Closable).Close() })
+	server, err := xnet.ParseDestination("tcp:" + serveReflexDispatcher(t, handler, newReflexReplyDispatcher("pong")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ob, err := outbound.New(context.Background(), &reflex.OutboundConfig{Address: "127.0.0.1", Port: uint32(server.Port), Id: u.String(), Mux: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ob.Close() })
+	return ob
+}
+
+func TestReflexOutboundMuxOutlivesFirstConnection(t *testing.T) {
+	ob := newOutboundMux(t)
+	dialer := &countingDialer{tied: true}
+
+	firstCtx, cancelFirst := context.WithCancel(context.Background())
+	defer cancelFirst()
+	up, down, firstDone := startOutboundConnection(firstCtx, ob, dialer, "example.com")
+	pingOutboundConnection(t, up, down)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	up, down, _ = startOutboundConnection(ctx, ob, dialer, "example.org")
+	pingOutboundConnection(t, up, down)
+
+	// The connection the session was dialed for ends; the session, and
+	// the other connection, go on.
+	cancelFirst()
+	select {
+	case <-firstDone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Process did not return once its context ended")
+	}
+	pingOutboundConnection(t, up, down)
+	if n := dialer.dials.Load(); n != 1 {
+		t.Fatalf("%d dials, want the connections to share one session", n)
+	}
+}
+
+func TestReflexOutboundMuxDialHoldsNoOne(t *testing.T) {
+	ob := newOutboundMux(t)
+	dialer := &countingDialer{hold: make(chan struct{})}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	up, down, _ := startOutboundConnection(ctx, ob, dialer, "example.com")
+	time.Sleep(50 * time.Millisecond)
+
+	// A connection that gives up waiting for the session's dial is let go.
+	waitCtx, cancelWait := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancelWait()
+	_, _, waitDone := startOutboundConnection(waitCtx, ob, dialer, "example.org")
+	select {
+	case err := <-waitDone:
+		if err == nil {
+			t.Fatal("connection without a session succeeded")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection held by the dial under way")
+	}
+
+	close(dialer.hold)
+	pingOutboundConnection(t, up, down)
+	if n := dialer.dials.Load(); n != 1 {
+		t.Fatalf("%d dials, want the connections to share one", n)
+	}
+}
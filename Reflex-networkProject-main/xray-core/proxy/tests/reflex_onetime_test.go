@@ -0,0 +1,134 @@
+package tests
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/proxy/reflex/inbound"
+)
+
+func TestReflexOneTimeID(t *testing.T) {
+	secret := []byte("0123456789abcdef")
+	step := int64(reflex.OneTimeIDStep / time.Second)
+	start := (time.Now().Unix() / step) * step
+	if reflex.OneTimeID(secret, start) != reflex.OneTimeID(secret, start+step-1) {
+		t.Fatal("the ID changed within a step")
+	}
+	if reflex.OneTimeID(secret, start) == reflex.OneTimeID(secret, start+step) {
+		t.Fatal("the ID did not roll over")
+	}
+	if reflex.OneTimeID(secret, start) == reflex.OneTimeID([]byte("fedcba9876543210"), start) {
+		t.Fatal("two secrets share an ID")
+	}
+	if _, err := reflex.ParseIDMode("rolling"); err == nil {
+		t.Fatal("an unknown mode was accepted")
+	}
+}
+
+func TestReflexOneTimeIDHandshake(t *testing.T) {
+	u := uuid.New()
+	config := &reflex.InboundConfig{
+		Clients:      []*reflex.User{{Id: u.String()}},
+		HandshakeIds: "both",
+	}
+	handler, err := inbound.New(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := serveReflexReplyPort(t, handler, "pong")
+
+	dial := func(oneTime bool) (*reflex.ClientConn, error) {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = conn.Close() })
+		_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+		return reflex.ClientHandshake(conn, &reflex.ClientOptions{UserID: u, OneTimeID: oneTime})
+	}
+
+	for _, oneTime := range []bool{false, true} {
+		c, err := dial(oneTime)
+		if err != nil {
+			t.Fatalf("both, one-time %v: %v", oneTime, err)
+		}
+		pingReflexSession(t, c)
+	}
+
+	config.HandshakeIds = "one-time"
+	if err := handler.(*inbound.Handler).Reload(context.Background(), config); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dial(false); !errors.Is(err, reflex.ErrHandshakeRejected) {
+		t.Fatalf("static ID accepted in one-time mode: %v", err)
+	}
+	c, err := dial(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pingReflexSession(t, c)
+
+	// A captured ID is refused once its step is over, even with a fresh
+	// timestamp.
+	now := time.Now().Unix()
+	stale := uuid.UUID(reflex.OneTimeID(u[:], now-2*int64(reflex.OneTimeIDStep/time.Second)))
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write(buildReflexMagicHandshake(stale, now)); err != nil {
+		t.Fatal(err)
+	}
+	status, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil || !strings.Contains(status, "403") {
+		t.Fatalf("stale one-time ID: %q, %v", status, err)
+	}
+}
+
+func TestReflexOneTimeIDUsesPSK(t *testing.T) {
+	u := uuid.New()
+	psk := []byte("a pre-shared key of some length")
+	handler, err := inbound.New(context.Background(), &reflex.InboundConfig{
+		Clients:      []*reflex.User{{Id: u.String(), Psk: base64.StdEncoding.EncodeToString(psk)}},
+		HandshakeIds: "one-time",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := serveReflexReplyPort(t, handler, "pong")
+
+	for _, tc := range []struct {
+		secret []byte
+		ok     bool
+	}{
+		{psk, true},
+		{nil, false}, // the UUID bytes are not this user's secret
+	} {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+		c, err := reflex.ClientHandshake(conn, &reflex.ClientOptions{UserID: u, Secret: tc.secret, OneTimeID: true})
+		if tc.ok {
+			if err != nil {
+				t.Fatal(err)
+			}
+			pingReflexSession(t, c)
+		} else if !errors.Is(err, reflex.ErrHandshakeRejected) {
+			t.Fatalf("wrong secret: %v", err)
+		}
+		conn.Close()
+	}
+}
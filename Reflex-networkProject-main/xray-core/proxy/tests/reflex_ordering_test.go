@@ -0,0 +1,247 @@
+package tests
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/xtls/xray-core/proxy/reflex"
+)
+
+// sealReflexFrames seals n data frames with s, each into its own record.
+func sealReflexFrames(t *testing.T, s *reflex.Session, n int) [][]byte {
+	t.Helper()
+	records := make([][]byte, n)
+	for i := range records {
+		var wire bytes.Buffer
+		if err := s.WriteFrame(&wire, reflex.FrameTypeData, []byte{byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+		records[i] = wire.Bytes()
+	}
+	return records
+}
+
+// readReflexPayloads reads n frames with s from r and returns the first
+// byte of each payload.
+func readReflexPayloads(t *testing.T, s *reflex.Session, r io.Reader, n int) []byte {
+	t.Helper()
+	got := make([]byte, n)
+	for i := range got {
+		f, err := s.ReadFrame(r)
+		if err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+		got[i] = f.Payload[0]
+	}
+	return got
+}
+
+// concatReflexRecords puts the records picked by order into one stream.
+func concatReflexRecords(records [][]byte, order ...int) *bytes.Reader {
+	var wire []byte
+	for _, i := range order {
+		wire = append(wire, records[i]...)
+	}
+	return bytes.NewReader(wire)
+}
+
+func TestReflexOrderingTolerant(t *testing.T) {
+	key := make([]byte, 32)
+	_, _ = rand.Read(key)
+	client, err := reflex.NewClientSession(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := reflex.NewServerSession(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.SetOrdering(reflex.OrderingTolerant, 0); err == nil {
+		t.Fatal("an empty reorder window was accepted")
+	}
+	if err := server.SetOrdering(reflex.OrderingTolerant, 64); err != nil {
+		t.Fatal(err)
+	}
+
+	records := sealReflexFrames(t, client, 70)
+	// Frames that arrive early are returned in the order they were sealed.
+	if got := readReflexPayloads(t, server, concatReflexRecords(records, 2, 0, 3, 1), 4); !bytes.Equal(got, []byte{0, 1, 2, 3}) {
+		t.Fatalf("frames were read in the order %v", got)
+	}
+	// A frame held for missing ones is returned once the read fails.
+	if got := readReflexPayloads(t, server, concatReflexRecords(records, 69), 1); got[0] != 69 {
+		t.Fatalf("frame 69 carries %d", got[0])
+	}
+	// Every frame is read once, however late.
+	if _, err := server.ReadFrame(bytes.NewReader(records[1])); err == nil {
+		t.Fatal("a frame was read twice")
+	}
+	// Frame 5 is 64 behind the latest, just out of the window; 6 is in it.
+	if _, err := server.ReadFrame(bytes.NewReader(records[5])); err == nil {
+		t.Fatal("a frame behind the reorder window was read")
+	}
+	if _, err := server.ReadFrame(bytes.NewReader(records[6])); err != nil {
+		t.Fatal(err)
+	}
+
+	// The window survives moving the session.
+	restored, err := reflex.RestoreSession(server.State())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := restored.ReadFrame(bytes.NewReader(records[6])); err == nil {
+		t.Fatal("a restored session read a frame twice")
+	}
+	if _, err := restored.ReadFrame(bytes.NewReader(records[7])); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReflexOrderingStrict(t *testing.T) {
+	key := make([]byte, 32)
+	_, _ = rand.Read(key)
+	client, err := reflex.NewClientSession(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := reflex.NewServerSession(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server.Ordering() != reflex.OrderingStrict {
+		t.Fatal("sessions are not strict by default")
+	}
+
+	records := sealReflexFrames(t, client, 2)
+	if _, err := server.ReadFrame(bytes.NewReader(records[1])); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.ReadFrame(bytes.NewReader(records[0])); err == nil {
+		t.Fatal("a strict session read a frame after a later one")
+	}
+}
+
+func TestReflexOrderingTolerantRekey(t *testing.T) {
+	key := make([]byte, 32)
+	_, _ = rand.Read(key)
+	client, err := reflex.NewClientSession(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := reflex.NewServerSession(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.SetOrdering(reflex.OrderingTolerant, reflex.DefaultReorderWindow); err != nil {
+		t.Fatal(err)
+	}
+
+	before := sealReflexFrames(t, client, 2)
+	if err := client.Rekey(); err != nil {
+		t.Fatal(err)
+	}
+	after := sealReflexFrames(t, client, 1)
+	// The frame sealed before the rekey arrives after the first one under
+	// the new key, and is still read, in the order it was sealed.
+	wire := bytes.NewReader(append(append(append([]byte(nil), before[0]...), after[0]...), before[1]...))
+	if got := readReflexPayloads(t, server, wire, 3); !bytes.Equal(got, []byte{0, 1, 0}) {
+		t.Fatalf("frames were read in the order %v", got)
+	}
+}
+
+func TestReflexOrderingReorderBuffer(t *testing.T) {
+	key := make([]byte, 32)
+	_, _ = rand.Read(key)
+	client, err := reflex.NewClientSession(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := reflex.NewServerSession(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.SetOrdering(reflex.OrderingTolerant, reflex.DefaultReorderWindow); err != nil {
+		t.Fatal(err)
+	}
+
+	n := reflex.ReorderBuffer + 2
+	records := sealReflexFrames(t, client, n+4)
+	if got := readReflexPayloads(t, server, concatReflexRecords(records, 0, 2, 1, 3), 4); !bytes.Equal(got, []byte{0, 1, 2, 3}) {
+		t.Fatalf("frames were read in the order %v", got)
+	}
+
+	// With more than ReorderBuffer frames held, the missing one is given
+	// up for lost, and returned as it comes.
+	order := []int{}
+	want := []byte{}
+	for i := 5; i < n+4; i++ {
+		order = append(order, i)
+		want = append(want, byte(i))
+	}
+	order = append(order, 4)
+	want = append(want, 4)
+	if got := readReflexPayloads(t, server, concatReflexRecords(records, order...), len(order)); !bytes.Equal(got, want) {
+		t.Fatalf("frames were read in the order %v", got)
+	}
+}
+
+func TestReflexOrderingReorderTimeout(t *testing.T) {
+	key := make([]byte, 32)
+	_, _ = rand.Read(key)
+	client, err := reflex.NewClientSession(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := reflex.NewServerSession(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.SetOrdering(reflex.OrderingTolerant, reflex.DefaultReorderWindow); err != nil {
+		t.Fatal(err)
+	}
+
+	records := sealReflexFrames(t, client, 3)
+	pr, pw := io.Pipe()
+	defer pr.Close()
+	go func() {
+		_, _ = pw.Write(records[1])
+		time.Sleep(reflex.ReorderTimeout + 50*time.Millisecond)
+		_, _ = pw.Write(records[2])
+		_, _ = pw.Write(records[0])
+	}()
+	// Frame 0 is given up for lost once frame 2 arrives after the timeout.
+	if got := readReflexPayloads(t, server, pr, 3); !bytes.Equal(got, []byte{1, 2, 0}) {
+		t.Fatalf("frames were read in the order %v", got)
+	}
+}
+
+func TestReflexOrderingGranted(t *testing.T) {
+	handler, userID := newReflexTestHandlerWithClient(t)
+	addr := serveReflexReplyPort(t, handler, "pong")
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	c, err := reflex.ClientHandshake(conn, &reflex.ClientOptions{
+		UserID: userID,
+		Policy: &reflex.PolicyReq{Features: []string{reflex.FeatureReorderTolerant}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.Grant.HasFeature(reflex.FeatureReorderTolerant) {
+		t.Fatal("tolerant ordering was not granted")
+	}
+	if c.Session.Ordering() != reflex.OrderingTolerant {
+		t.Fatal("the client session is not tolerant")
+	}
+	pingReflexSession(t, c)
+	pingReflexSession(t, c)
+}
@@ -0,0 +1,345 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/xtls/xray-core/app/proxyman"
+	_ "github.com/xtls/xray-core/app/proxyman/outbound"
+	"github.com/xtls/xray-core/common"
+	"github.com/xtls/xray-core/common/buf"
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/common/serial"
+	"github.com/xtls/xray-core/common/session"
+	"github.com/xtls/xray-core/core"
+	feature_outbound "github.com/xtls/xray-core/features/outbound"
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/proxy/reflex/inbound"
+	"github.com/xtls/xray-core/proxy/reflex/outbound"
+	"github.com/xtls/xray-core/transport"
+	"github.com/xtls/xray-core/transport/internet"
+	"github.com/xtls/xray-core/transport/internet/stat"
+	_ "github.com/xtls/xray-core/transport/internet/tcp"
+	"github.com/xtls/xray-core/transport/pipe"
+)
+
+// countingDialer is an internet.Dialer that dials TCP and counts its dials,
+// standing in for the dialer proxyman gives an outbound.
+type countingDialer struct {
+	dials atomic.Int32
+	port  atomic.Uint32 // dialed last
+	// tied closes every connection once the context it was dialed with
+	// ends, as the dialer through another outbound, see proxySettings, does.
+	tied bool
+	// hold, if set, holds every dial until it is closed.
+	hold chan struct{}
+}
+
+func (d *countingDialer) Dial(ctx context.Context, dest xnet.Destination) (stat.Connection, error) {
+	d.dials.Add(1)
+	d.port.Store(uint32(dest.Port))
+	if d.hold != nil {
+		select {
+		case <-d.hold:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", dest.NetAddr())
+	if err == nil && d.tied {
+		context.AfterFunc(ctx, func() { _ = conn.Close() })
+	}
+	return conn, err
+}
+
+func (d *countingDialer) DestIpAddress() xnet.IP                                       { return nil }
+func (d *countingDialer) SetOutboundGateway(ctx context.Context, ob *session.Outbound) {}
+
+// pingReflexOutbound sends "ping" through ob, dialing with dialer, and
+// fails the test unless "pong" comes back.
+func pingReflexOutbound(t *testing.T, ob *outbound.Handler, dialer internet.Dialer) {
+	t.Helper()
+	upReader, upWriter := pipe.New(pipe.WithoutSizeLimit())
+	downReader, downWriter := pipe.New(pipe.WithoutSizeLimit())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ctx = session.ContextWithOutbounds(ctx, []*session.Outbound{{Target: xnet.TCPDestination(xnet.DomainAddress("example.com"), 80)}})
+	done := make(chan error, 1)
+	go func() {
+		done <- ob.Process(ctx, &transport.Link{Reader: upReader, Writer: downWriter}, dialer)
+	}()
+	b := buf.New()
+	b.WriteString("ping")
+	common.Must(upWriter.WriteMultiBuffer(buf.MultiBuffer{b}))
+	read := make(chan string, 1)
+	go func() {
+		mb, _ := downReader.ReadMultiBuffer()
+		read <- mb.String()
+		buf.ReleaseMulti(mb)
+	}()
+	select {
+	case got := <-read:
+		if got != "pong" {
+			t.Fatalf("downlink %q", got)
+		}
+	case err := <-done:
+		t.Fatalf("relay ended: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reply")
+	}
+}
+
+func TestReflexOutbound(t *testing.T) {
+	u := uuid.New()
+	handler, err := inbound.New(context.Background(), &reflex.InboundConfig{
+		Clients:      []*reflex.User{{Id: u.String()}},
+		RetryCookie:  true,
+		DrainTimeout: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer handler.(common.Closable).Close()
+	dispatcher := newReflexReplyDispatcher("pong")
+	addr := serveReflexDispatcher(t, handler, dispatcher)
+	server, err := xnet.ParseDestination("tcp:" + addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ob, err := outbound.New(context.Background(), &reflex.OutboundConfig{Address: "127.0.0.1", Port: uint32(server.Port), Id: u.String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	upReader, upWriter := pipe.New(pipe.WithoutSizeLimit())
+	downReader, downWriter := pipe.New(pipe.WithoutSizeLimit())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ctx = session.ContextWithOutbounds(ctx, []*session.Outbound{{Target: xnet.TCPDestination(xnet.DomainAddress("example.com"), 80)}})
+	dialer := &countingDialer{}
+	done := make(chan error, 1)
+	go func() {
+		done <- ob.Process(ctx, &transport.Link{Reader: upReader, Writer: downWriter}, dialer)
+	}()
+
+	b := buf.New()
+	b.WriteString("ping")
+	if err := upWriter.WriteMultiBuffer(buf.MultiBuffer{b}); err != nil {
+		t.Fatal(err)
+	}
+	mb, err := downReader.ReadMultiBuffer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The reply comes padded to the server's default profile, and is
+	// passed on without it.
+	if got := mb.String(); got != "pong" {
+		t.Fatalf("downlink %q", got)
+	}
+	buf.ReleaseMulti(mb)
+	if got := <-dispatcher.payloads; string(got) != "ping" {
+		t.Fatalf("uplink %q", got)
+	}
+	if got := (<-dispatcher.dests).NetAddr(); got != "example.com:80" {
+		t.Fatalf("dispatched to %s, want the target", got)
+	}
+	// The server asked for a retry cookie: the first handshake is retried.
+	if n := dialer.dials.Load(); n != 2 {
+		t.Fatalf("%d dials, want 2 through the given dialer", n)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Process did not return once its context ended")
+	}
+}
+
+func TestReflexOutboundChained(t *testing.T) {
+	// The inner server, which the outbound is configured with.
+	inner, userID := newReflexTestHandlerWithClient(t)
+	dispatcher := newReflexReplyDispatcher("pong")
+	innerAddr := serveReflexDispatcher(t, inner, dispatcher)
+	innerDest, err := xnet.ParseDestination("tcp:" + innerAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The outer server, which only the outbound it is chained through
+	// knows, and which dials whatever that asks for.
+	var dialed atomic.Value
+	outerID := uuid.New()
+	outer, err := inbound.NewWithDialer(context.Background(), &reflex.InboundConfig{
+		Clients:      []*reflex.User{{Id: outerID.String()}},
+		DrainTimeout: 1,
+	}, inbound.DialerFunc(func(ctx context.Context, dest xnet.Destination) (net.Conn, error) {
+		dialed.Store(dest.NetAddr())
+		return inbound.DirectDialer.Dial(ctx, dest)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer outer.Close()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go outer.Serve(context.Background(), conn)
+		}
+	}()
+
+	instance, err := core.New(&core.Config{
+		App: []*serial.TypedMessage{serial.ToTypedMessage(&proxyman.OutboundConfig{})},
+		Outbound: []*core.OutboundHandlerConfig{
+			{
+				Tag:           "reflex",
+				ProxySettings: serial.ToTypedMessage(&reflex.OutboundConfig{Address: "127.0.0.1", Port: uint32(innerDest.Port), Id: userID.String()}),
+				SenderSettings: serial.ToTypedMessage(&proxyman.SenderConfig{
+					ProxySettings: &internet.ProxyConfig{Tag: "hop"},
+				}),
+			},
+			{
+				Tag:           "hop",
+				ProxySettings: serial.ToTypedMessage(&reflex.OutboundConfig{Address: "127.0.0.1", Port: uint32(ln.Addr().(*net.TCPAddr).Port), Id: outerID.String()}),
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer instance.Close()
+	handler := instance.GetFeature(feature_outbound.ManagerType()).(feature_outbound.Manager).GetHandler("reflex")
+
+	upReader, upWriter := pipe.New(pipe.WithoutSizeLimit())
+	downReader, downWriter := pipe.New(pipe.WithoutSizeLimit())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ctx = session.ContextWithOutbounds(ctx, []*session.Outbound{{Target: xnet.TCPDestination(xnet.DomainAddress("example.com"), 80)}})
+	go handler.Dispatch(ctx, &transport.Link{Reader: upReader, Writer: downWriter})
+
+	b := buf.New()
+	b.WriteString("ping")
+	if err := upWriter.WriteMultiBuffer(buf.MultiBuffer{b}); err != nil {
+		t.Fatal(err)
+	}
+	mb, err := downReader.ReadMultiBuffer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := mb.String(); got != "pong" {
+		t.Fatalf("downlink %q", got)
+	}
+	buf.ReleaseMulti(mb)
+	if got := <-dispatcher.payloads; string(got) != "ping" {
+		t.Fatalf("uplink %q", got)
+	}
+	if got := (<-dispatcher.dests).NetAddr(); got != "example.com:80" {
+		t.Fatalf("dispatched to %s, want the target", got)
+	}
+	// The inner session was carried by the outbound it is chained through.
+	if got, _ := dialed.Load().(string); got != innerAddr {
+		t.Fatalf("outer server dialed %q, want the inner server %s", got, innerAddr)
+	}
+}
+
+func TestReflexOutboundPSKUser(t *testing.T) {
+	u := uuid.New()
+	psk := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{0x42}, 32))
+	// With one-time IDs only, the server finds the user only by an ID made
+	// from their PSK.
+	handler, err := inbound.New(context.Background(), &reflex.InboundConfig{
+		Clients:      []*reflex.User{{Id: u.String(), Psk: psk}},
+		HandshakeIds: "one-time",
+		DrainTimeout: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer handler.(common.Closable).Close()
+	server, err := xnet.ParseDestination("tcp:" + serveReflexDispatcher(t, handler, newReflexReplyDispatcher("pong")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	relay := func(config *reflex.OutboundConfig) error {
+		ob, err := outbound.New(context.Background(), config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		upReader, upWriter := pipe.New(pipe.WithoutSizeLimit())
+		downReader, downWriter := pipe.New(pipe.WithoutSizeLimit())
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		ctx = session.ContextWithOutbounds(ctx, []*session.Outbound{{Target: xnet.TCPDestination(xnet.DomainAddress("example.com"), 80)}})
+		done := make(chan error, 1)
+		go func() {
+			done <- ob.Process(ctx, &transport.Link{Reader: upReader, Writer: downWriter}, &countingDialer{})
+		}()
+		b := buf.New()
+		b.WriteString("ping")
+		if err := upWriter.WriteMultiBuffer(buf.MultiBuffer{b}); err != nil {
+			t.Fatal(err)
+		}
+		read := make(chan string, 1)
+		go func() {
+			mb, _ := downReader.ReadMultiBuffer()
+			read <- mb.String()
+			buf.ReleaseMulti(mb)
+		}()
+		select {
+		case got := <-read:
+			if got != "pong" {
+				t.Fatalf("downlink %q", got)
+			}
+			return nil
+		case err := <-done:
+			return err
+		case <-time.After(5 * time.Second):
+			t.Fatal("no reply")
+			return nil
+		}
+	}
+	config := &reflex.OutboundConfig{Address: "127.0.0.1", Port: uint32(server.Port), Id: u.String(), Psk: psk, OneTime: true}
+	if err := relay(config); err != nil {
+		t.Fatalf("PSK user: %v", err)
+	}
+	config.Psk = base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{0x24}, 32))
+	if err := relay(config); err == nil {
+		t.Fatal("a wrong PSK must not find the user")
+	}
+}
+
+func TestReflexOutboundConfig(t *testing.T) {
+	for _, config := range []*reflex.OutboundConfig{
+		{Port: 443, Id: uuid.NewString()},
+		{Address: "example.com", Id: uuid.NewString()},
+		{Address: "example.com", Port: 443, Id: "not-a-uuid"},
+		{Address: "example.com", Port: 443, Id: uuid.NewString(), Morphing: "fast"},
+		{Address: "example.com", Port: 443, Id: uuid.NewString(), ServerKey: "not-a-key"},
+		{Address: "example.com", Port: 443, Id: uuid.NewString(), Psk: "c2hvcnQ="},
+		{Address: "example.com", Id: uuid.NewString(), PortHopping: &reflex.PortHopping{BasePort: 20000, PortCount: 10}},
+		{Address: "example.com", Id: uuid.NewString(), PortHopping: &reflex.PortHopping{Secret: "secret", BasePort: 65530, PortCount: 10}},
+		{Address: "example.com", Port: 443, Id: uuid.NewString(), Carrier: "sctp"},
+		{Address: "example.com", Id: uuid.NewString(), Carrier: reflex.CarrierQUIC, PortHopping: &reflex.PortHopping{Secret: "secret", BasePort: 20000, PortCount: 10}},
+	} {
+		if _, err := outbound.New(context.Background(), config); err == nil {
+			t.Errorf("accepted %+v", config)
+		}
+	}
+}
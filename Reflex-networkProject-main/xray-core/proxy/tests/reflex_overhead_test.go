@@ -0,0 +1,108 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/xtls/xray-core/common"
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/proxy/reflex/inbound"
+)
+
+func TestReflexSessionOverhead(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 32)
+	client, _ := reflex.NewClientSession(key)
+	server, _ := reflex.NewServerSession(key)
+	fixed := &reflex.TrafficProfile{Name: "fixed", PacketSizes: []reflex.PacketSizeDist{{Size: 100, Weight: 1}}}
+
+	var wire bytes.Buffer
+	if err := reflex.WriteFrameWithMorphing(client, &wire, reflex.FrameTypeData, []byte("hello"), fixed); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.WriteFrame(&wire, reflex.FrameTypePaddingCtrl, reflex.PaddingControl(300)); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.WriteFrame(&wire, reflex.FrameTypeUsage, []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	want := reflex.Overhead{Payload: 5, Padding: 95, Cover: uint64(len(reflex.PaddingControl(300)))}
+	if got := client.Stats().Overhead; got != want {
+		t.Fatalf("written %+v, want %+v", got, want)
+	}
+
+	// The reader drops the padding.
+	for i := 0; i < 3; i++ {
+		if _, err := server.ReadFrame(&wire); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := server.Stats().Overhead; got.Payload != 5 || got.Padding != 0 || got.Cover != want.Cover {
+		t.Fatalf("read %+v", got)
+	}
+
+	// What was taken is not taken again.
+	if got := client.TakeOverhead(); got != want {
+		t.Fatalf("took %+v, want %+v", got, want)
+	}
+	if err := client.WriteFrame(&wire, reflex.FrameTypeData, []byte("more")); err != nil {
+		t.Fatal(err)
+	}
+	if got := client.TakeOverhead(); got != (reflex.Overhead{Payload: 4}) {
+		t.Fatalf("took %+v after taking", got)
+	}
+
+	// A payload larger than the profile's packets is sent, and counted,
+	// whole: 41 frames of 98 bytes of it and the length of each.
+	large := bytes.Repeat([]byte("x"), 4000)
+	if err := reflex.WriteFrameWithMorphing(client, &wire, reflex.FrameTypeData, large, fixed); err != nil {
+		t.Fatal(err)
+	}
+	if got := client.TakeOverhead(); got != (reflex.Overhead{Payload: 4000, Padding: 41*100 - 4000}) {
+		t.Fatalf("took %+v for a large payload", got)
+	}
+}
+
+func TestReflexOverheadPerUser(t *testing.T) {
+	u := uuid.New()
+	handler, err := inbound.New(context.Background(), &reflex.InboundConfig{
+		Clients:      []*reflex.User{{Id: u.String()}},
+		DrainTimeout: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer handler.(common.Closable).Close()
+	addr := serveReflexReplyPort(t, handler, "pong")
+
+	c, err := dialReflexClient(t, addr, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.WriteFrame(reflex.FrameTypeData, []byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	f, err := c.ReadFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(f.Payload) != "pong" {
+		t.Fatalf("reply %q", f.Payload)
+	}
+	// The reply is padded to the server's default profile.
+	want := reflex.Overhead{Payload: uint64(len("ping") + len("pong"))}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got := handler.(*inbound.Handler).Overhead(u.String())
+		if got.Payload == want.Payload && got.Padding > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("overhead %+v, want %+v", got, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
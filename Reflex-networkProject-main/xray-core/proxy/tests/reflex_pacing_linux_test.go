@@ -0,0 +1,77 @@
+//go:build linux
+
+package tests
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/xtls/xray-core/proxy/reflex"
+	"golang.org/x/sys/unix"
+)
+
+func TestReflexPacingOverTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			_, _ = io.Copy(io.Discard, conn)
+			conn.Close()
+		}
+	}()
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	s, profile := pacedReflexSession(t, 10*time.Millisecond)
+	for i := 0; i < 3; i++ {
+		if err := reflex.WriteFrameWithMorphing(s, conn, reflex.FrameTypeData, []byte("paced"), profile); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkReflexPacingRate(t, conn, 1000*uint64(time.Second/(10*time.Millisecond)))
+	// Unshaped frames lift the rate.
+	if err := reflex.WriteFrameWithMorphing(s, conn, reflex.FrameTypeData, []byte("unshaped"), nil); err != nil {
+		t.Fatal(err)
+	}
+	checkReflexPacingRate(t, conn, ^uint64(0))
+}
+
+// checkReflexPacingRate checks the SO_MAX_PACING_RATE of conn, a TCP
+// connection, against a rate the size of a padded frame may round.
+func checkReflexPacingRate(t *testing.T, conn net.Conn, want uint64) {
+	t.Helper()
+	raw, err := conn.(*net.TCPConn).SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rate uint64
+	var gerr error
+	if err := raw.Control(func(fd uintptr) {
+		var v int
+		v, gerr = unix.GetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_MAX_PACING_RATE)
+		rate = uint64(uint32(v))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if gerr != nil {
+		t.Fatal(gerr)
+	}
+	if want == ^uint64(0) {
+		if rate != uint64(^uint32(0)) {
+			t.Fatalf("pacing rate %d was not lifted", rate)
+		}
+		return
+	}
+	if rate < want || rate > want*2 {
+		t.Fatalf("pacing rate %d, expected about %d", rate, want)
+	}
+}
@@ -0,0 +1,62 @@
+package tests
+
+import (
+	"crypto/rand"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/xtls/xray-core/proxy/reflex"
+)
+
+// pacedReflexSession returns a paced session and a profile with a fixed gap.
+func pacedReflexSession(t *testing.T, gap time.Duration) (*reflex.Session, *reflex.TrafficProfile) {
+	t.Helper()
+	key := make([]byte, 32)
+	_, _ = rand.Read(key)
+	s, err := reflex.NewClientSession(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetPacing(true)
+	return s, &reflex.TrafficProfile{
+		Name:        "paced",
+		PacketSizes: []reflex.PacketSizeDist{{Size: 1000, Weight: 1}},
+		Delays:      []reflex.DelayDist{{Delay: gap, Weight: 1}},
+	}
+}
+
+func TestReflexPacingKeepsSlots(t *testing.T) {
+	const gap = 30 * time.Millisecond
+	s, profile := pacedReflexSession(t, gap)
+
+	start := time.Now()
+	if err := reflex.WriteFrameWithMorphing(s, io.Discard, reflex.FrameTypeData, []byte("first"), profile); err != nil {
+		t.Fatal(err)
+	}
+	// The gap comes before the next frame, not after the one written.
+	if took := time.Since(start); took >= gap {
+		t.Fatalf("the first frame took %v", took)
+	}
+	for i := 0; i < 3; i++ {
+		if err := reflex.WriteFrameWithMorphing(s, io.Discard, reflex.FrameTypeData, []byte("next"), profile); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if took := time.Since(start); took < 3*gap {
+		t.Fatalf("four frames took %v, less than three gaps", took)
+	}
+
+	// A frame that is late does not let the next one follow right away.
+	time.Sleep(2 * gap)
+	if err := reflex.WriteFrameWithMorphing(s, io.Discard, reflex.FrameTypeData, []byte("late"), profile); err != nil {
+		t.Fatal(err)
+	}
+	late := time.Now()
+	if err := reflex.WriteFrameWithMorphing(s, io.Discard, reflex.FrameTypeData, []byte("after"), profile); err != nil {
+		t.Fatal(err)
+	}
+	if took := time.Since(late); took < gap-time.Millisecond {
+		t.Fatalf("the frame after a late one came %v after it", took)
+	}
+}
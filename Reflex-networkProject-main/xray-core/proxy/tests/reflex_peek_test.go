@@ -0,0 +1,97 @@
+package tests
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/xtls/xray-core/common"
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/proxy/reflex/inbound"
+)
+
+// splitConn writes its first byte on its own and the rest after a pause, a
+// first segment as small as it gets.
+type splitConn struct {
+	net.Conn
+	split bool
+}
+
+func (c *splitConn) Write(b []byte) (int, error) {
+	if c.split || len(b) < 2 {
+		return c.Conn.Write(b)
+	}
+	c.split = true
+	if _, err := c.Conn.Write(b[:1]); err != nil {
+		return 0, err
+	}
+	time.Sleep(50 * time.Millisecond)
+	n, err := c.Conn.Write(b[1:])
+	return n + 1, err
+}
+
+func TestReflexShortFirstSegments(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				if _, err := conn.Read(make([]byte, 64)); err == nil {
+					_, _ = conn.Write([]byte("cover"))
+				}
+			}()
+		}
+	}()
+
+	u := uuid.New()
+	handler, err := inbound.New(context.Background(), &reflex.InboundConfig{
+		Clients:  []*reflex.User{{Id: u.String()}},
+		Fallback: &reflex.Fallback{Dest: uint32(ln.Addr().(*net.TCPAddr).Port)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer handler.(common.Closable).Close()
+	addr := serveReflexReplyPort(t, handler, "pong")
+
+	// A request shorter than any handshake is the cover site's right away,
+	// not once the handshake timeout is up.
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Write([]byte("GET /\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	got := make([]byte, 5)
+	if _, err := io.ReadFull(conn, got); err != nil || string(got) != "cover" {
+		t.Fatalf("short request got %q, %v", got, err)
+	}
+
+	// A handshake whose first segment is a single byte is still one.
+	raw, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+	_ = raw.SetDeadline(time.Now().Add(5 * time.Second))
+	c, err := reflex.ClientHandshake(&splitConn{Conn: raw}, &reflex.ClientOptions{UserID: u})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pingReflexSession(t, c)
+}
@@ -0,0 +1,440 @@
+package tests
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/xtls/xray-core/common"
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/proxy/reflex/inbound"
+	"github.com/xtls/xray-core/transport/internet/stat"
+)
+
+func TestReflexPolicyEngineEvaluate(t *testing.T) {
+	engine := reflex.NewPolicyEngine(nil)
+	engine.SetRule("free", &reflex.PolicyRule{
+		Profiles:     []string{"zoom"},
+		MaxBandwidth: 1000,
+		Features:     []string{"streams"},
+	})
+
+	grant := engine.Evaluate("free", 0, &reflex.PolicyReq{
+		Profile:   "youtube",
+		Bandwidth: 5000,
+		Features:  []string{"streams", "udp"},
+	})
+	if grant.Profile != "zoom" {
+		t.Fatalf("disallowed profile must fall back to rule default, got %q", grant.Profile)
+	}
+	if grant.Bandwidth != 1000 {
+		t.Fatalf("bandwidth must be capped, got %d", grant.Bandwidth)
+	}
+	if !grant.HasFeature("streams") || grant.HasFeature("udp") {
+		t.Fatalf("unexpected features %v", grant.Features)
+	}
+
+	// Users without a named rule get the permissive inbound rule.
+	grant = engine.Evaluate("", 0, &reflex.PolicyReq{Profile: "youtube"})
+	if grant.Profile != "youtube" || grant.Bandwidth != 0 {
+		t.Fatalf("unexpected default grant %+v", grant)
+	}
+	grant = engine.Evaluate("", 0, &reflex.PolicyReq{Profile: "no-such-profile"})
+	if grant.Profile != reflex.DefaultProfileName {
+		t.Fatalf("unknown profile must fall back to %q, got %q", reflex.DefaultProfileName, grant.Profile)
+	}
+}
+
+func TestReflexHandshakeReturnsPolicyGrant(t *testing.T) {
+	handler, userID := newReflexTestHandlerWithClient(t)
+
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	go func() {
+		_ = handler.Process(context.Background(), xnet.Network_TCP, stat.Connection(serverConn), nil)
+	}()
+
+	var pub [32]byte
+	_, _ = rand.Read(pub[:])
+	if _, err := clientConn.Write(buildReflexMagicHandshakeWithKey(userID, time.Now().Unix(), pub)); err != nil {
+		t.Fatal(err)
+	}
+	_ = clientConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	resp := readReflexHandshakeResponse(t, bufio.NewReader(clientConn))
+
+	grant, err := reflex.ParsePolicyGrant(resp.PolicyGrant)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if grant.Profile != reflex.DefaultProfileName {
+		t.Fatalf("expected default profile grant, got %+v", grant)
+	}
+}
+
+func TestReflexRateLimiter(t *testing.T) {
+	if reflex.NewRateLimiter(0) != nil {
+		t.Fatal("zero rate must mean unlimited")
+	}
+	limiter := reflex.NewRateLimiter(10000)
+	start := time.Now()
+	limiter.Wait(10000) // the initial burst
+	limiter.Wait(2000)
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Fatalf("limiter did not wait, elapsed %v", elapsed)
+	}
+}
+
+func TestReflexPolicyEngineFromConfig(t *testing.T) {
+	engine, err := reflex.NewPolicyEngineFromConfig([]*reflex.PolicyConfig{
+		{Level: 1, Profiles: []string{"youtube"}, SessionTtl: 60},
+		{Name: "lab", Destinations: []string{"example.com:443", "10.0.0.0/8"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	grant := engine.Evaluate("", 1, &reflex.PolicyReq{})
+	if grant.Profile != "youtube" || grant.SessionLifetime() != time.Minute {
+		t.Fatalf("level rule not applied: %+v", grant)
+	}
+	// A named rule wins over the level.
+	grant = engine.Evaluate("lab", 1, &reflex.PolicyReq{})
+	if grant.TTL != 0 {
+		t.Fatalf("named rule not applied: %+v", grant)
+	}
+	for _, c := range []struct {
+		host    string
+		port    uint16
+		allowed bool
+	}{
+		{"example.com", 443, true},
+		{"www.example.com", 443, true},
+		{"example.com", 80, false},
+		{"badexample.com", 443, false},
+		{"10.1.2.3", 22, true},
+		{"192.168.1.1", 22, false},
+	} {
+		if got := grant.AllowsDestination(c.host, c.port); got != c.allowed {
+			t.Errorf("AllowsDestination(%s, %d) = %v", c.host, c.port, got)
+		}
+	}
+
+	if _, err := reflex.NewPolicyEngineFromConfig([]*reflex.PolicyConfig{{Profiles: []string{"nope"}}}); err == nil {
+		t.Fatal("unknown profile must be rejected")
+	}
+	if _, err := reflex.NewPolicyEngineFromConfig([]*reflex.PolicyConfig{{Destinations: []string{"10.0.0.0/99"}}}); err == nil {
+		t.Fatal("invalid CIDR must be rejected")
+	}
+}
+
+func TestReflexPolicyRenewal(t *testing.T) {
+	handler, userID := newReflexTestHandlerWithClient(t)
+	session, conn, reader := dialReflexSession(t, handler, userID, nil)
+
+	if err := reflex.RequestPolicy(session, conn, &reflex.PolicyReq{Profile: "zoom", Bandwidth: 2048}); err != nil {
+		t.Fatal(err)
+	}
+	f, err := session.ReadFrame(reader)
+	if err != nil {
+		t.Fatalf("failed to read renewed grant: %v", err)
+	}
+	if f.Type != reflex.FrameTypePolicyGrant {
+		t.Fatalf("expected POLICY_GRANT frame, got type %d", f.Type)
+	}
+	grant, err := reflex.ParsePolicyGrant(f.Payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if grant.Profile != "zoom" || grant.Bandwidth != 2048 {
+		t.Fatalf("unexpected renewed grant %+v", grant)
+	}
+}
+
+func TestReflexProfileScheduler(t *testing.T) {
+	start := time.Now()
+	schedule := reflex.NewProfileScheduler([]reflex.ProfileSwitch{
+		{Profile: "zoom", AfterBytes: 1000},
+		{Profile: "youtube", AfterSeconds: 60},
+		{Profile: "http2-api", OnThrottle: true},
+	}, start)
+
+	if _, ok := schedule.Observe(999, start); ok {
+		t.Fatal("no trigger should fire yet")
+	}
+	if name, ok := schedule.Observe(1, start); !ok || name != "zoom" {
+		t.Fatalf("byte trigger: %q %v", name, ok)
+	}
+	if _, ok := schedule.Observe(5000, start); ok {
+		t.Fatal("a switch must fire only once")
+	}
+	if name, ok := schedule.Observe(0, start.Add(time.Minute)); !ok || name != "youtube" {
+		t.Fatalf("time trigger: %q %v", name, ok)
+	}
+	if name, ok := schedule.Throttled(); !ok || name != "http2-api" {
+		t.Fatalf("throttle trigger: %q %v", name, ok)
+	}
+	if schedule.Allows("no-such-profile") || !schedule.Allows("zoom") {
+		t.Fatal("Allows must follow the schedule")
+	}
+}
+
+func TestReflexSessionProfileSwitch(t *testing.T) {
+	userID := uuid.New()
+	handler, err := inbound.New(context.Background(), &reflex.InboundConfig{
+		Clients: []*reflex.User{{Id: userID.String()}},
+		Policies: []*reflex.PolicyConfig{{
+			Switches: []*reflex.ProfileSwitchConfig{{Profile: "zoom", AfterBytes: 4}},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := &reflex.PolicyReq{Version: reflex.PolicyVersion}
+	session, conn, reader, _ := dialReflexSessionWithPolicy(t, handler, userID, newReflexReplyDispatcher("pong"), req.Marshal())
+
+	if err := session.WriteFrame(conn, reflex.FrameTypeData, []byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	var switched string
+	for switched == "" {
+		f, err := session.ReadFrame(reader)
+		if err != nil {
+			t.Fatalf("no profile switch received: %v", err)
+		}
+		if f.Type == reflex.FrameTypeProfileSwitch {
+			switched = string(f.Payload)
+		}
+	}
+	if switched != "zoom" {
+		t.Fatalf("switched to %q", switched)
+	}
+}
+
+func TestReflexClientFollowsBuiltinProfileSwitch(t *testing.T) {
+	userID := uuid.New()
+	handler, err := inbound.New(context.Background(), &reflex.InboundConfig{
+		Clients: []*reflex.User{{Id: userID.String()}},
+		Policies: []*reflex.PolicyConfig{{
+			Switches: []*reflex.ProfileSwitchConfig{{Profile: "zoom", AfterBytes: 4}},
+		}},
+		DrainTimeout: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer handler.(common.Closable).Close()
+	addr := serveReflexReplyPort(t, handler, "pong")
+	c, err := dialReflexClientWith(t, addr, &reflex.ClientOptions{
+		UserID: userID,
+		Policy: &reflex.PolicyReq{Version: reflex.PolicyVersion},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A built-in profile is never offered: the client shapes with its own
+	// copy of it once the server switches.
+	for i := 0; c.Profile != "zoom"; i++ {
+		if i == 5 {
+			t.Fatalf("no switch to zoom, profile %q", c.Profile)
+		}
+		if err := c.WriteFrame(reflex.FrameTypeData, []byte("ping")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.ReadFrame(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	shape := c.Shape()
+	if shape == nil || shape.Name != reflex.Profiles["zoom"].Name {
+		t.Fatalf("client shapes with %+v after switching to zoom", shape)
+	}
+	if shape == reflex.Profiles["zoom"] {
+		t.Fatal("client shapes with the shared built-in profile")
+	}
+}
+
+func TestReflexPolicyDefaultsAndDenyByDefault(t *testing.T) {
+	engine, err := reflex.NewPolicyEngineFromConfig([]*reflex.PolicyConfig{
+		{Name: "guest", Profiles: []string{"zoom"}, MaxBandwidth: 100},
+		{Name: "full"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	user := reflex.PolicySubject{Policy: "full"}
+
+	engine.DefaultPolicy = "guest"
+	grant, err := engine.Decide(ctx, user, &reflex.PolicyReq{})
+	if err != nil || grant.Profile != "zoom" || grant.Bandwidth != 100 {
+		t.Fatalf("empty request must get the default policy: %+v %v", grant, err)
+	}
+	grant, err = engine.Decide(ctx, user, &reflex.PolicyReq{Profile: "youtube"})
+	if err != nil || grant.Profile != "youtube" {
+		t.Fatalf("non-empty request must use the user's rule: %+v %v", grant, err)
+	}
+
+	engine.DefaultPolicy = ""
+	engine.DenyByDefault = true
+	if _, err := engine.Decide(ctx, user, &reflex.PolicyReq{}); err == nil {
+		t.Fatal("empty request must be denied")
+	}
+	if _, err := engine.Decide(ctx, user, &reflex.PolicyReq{Profile: "no-such-profile"}); err == nil {
+		t.Fatal("unknown profile must be denied")
+	}
+	if _, err := engine.Decide(ctx, reflex.PolicySubject{Policy: "guest"}, &reflex.PolicyReq{Profile: "youtube"}); err == nil {
+		t.Fatal("disallowed profile must be denied")
+	}
+}
+
+func TestReflexDenyByDefaultRejectsMalformedRequest(t *testing.T) {
+	userID := uuid.New()
+	handler, err := inbound.New(context.Background(), &reflex.InboundConfig{
+		Clients:       []*reflex.User{{Id: userID.String()}},
+		DenyByDefault: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	go func() {
+		_ = handler.Process(context.Background(), xnet.Network_TCP, stat.Connection(serverConn), nil)
+	}()
+	// The test handshake carries the non-JSON policy request "policy".
+	go func() { _, _ = clientConn.Write(buildReflexMagicHandshake(userID, time.Now().Unix())) }()
+
+	_ = clientConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	statusLine, err := bufio.NewReader(clientConn).ReadString('\n')
+	if err != nil || !strings.Contains(statusLine, "403") {
+		t.Fatalf("expected 403, got %q (%v)", statusLine, err)
+	}
+
+	if _, err := inbound.New(context.Background(), &reflex.InboundConfig{DefaultPolicy: "missing"}); err == nil {
+		t.Fatal("undefined default policy must be rejected")
+	}
+}
+
+func TestReflexPolicyVersionNegotiation(t *testing.T) {
+	userID := uuid.New()
+	handler, err := inbound.New(context.Background(), &reflex.InboundConfig{
+		Clients: []*reflex.User{{Id: userID.String()}},
+		Policies: []*reflex.PolicyConfig{{
+			Switches: []*reflex.ProfileSwitchConfig{{Profile: "zoom", AfterSeconds: 60}},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		requested, negotiated uint8
+		switches              int
+	}{
+		{0, reflex.PolicyVersion1, 0}, // unversioned clients speak version 1
+		{reflex.PolicyVersion2, reflex.PolicyVersion2, 1},
+		{reflex.PolicyVersion + 1, reflex.PolicyVersion, 1},
+	} {
+		req := &reflex.PolicyReq{Version: c.requested, Profile: "youtube"}
+		session, _, _, resp := dialReflexSessionWithPolicy(t, handler, userID, nil, req.Marshal())
+		grant, err := reflex.ParsePolicyGrant(resp.PolicyGrant)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if grant.Version != c.negotiated || len(grant.Switches) != c.switches {
+			t.Errorf("requested v%d: got v%d with %d switches", c.requested, grant.Version, len(grant.Switches))
+		}
+		if err := grant.Enforceable(); err != nil {
+			t.Errorf("requested v%d: %v", c.requested, err)
+		}
+		session.SetPolicyVersion(grant.Version)
+		if session.Stats().PolicyVersion != c.negotiated {
+			t.Errorf("session stats do not show the negotiated version")
+		}
+	}
+
+	if err := (&reflex.PolicyGrant{Version: reflex.PolicyVersion + 1, Profile: "zoom"}).Enforceable(); err == nil {
+		t.Fatal("grant from a newer version must not be enforceable")
+	}
+	if err := (&reflex.PolicyGrant{Version: reflex.PolicyVersion, Profile: "no-such-profile"}).Enforceable(); err == nil {
+		t.Fatal("grant with an unknown profile must not be enforceable")
+	}
+}
+
+func TestReflexPolicyAudit(t *testing.T) {
+	engine := reflex.NewPolicyEngine(nil)
+	engine.SetRule("free", &reflex.PolicyRule{Profiles: []string{"zoom"}, MaxBandwidth: 1000})
+	engine.DenyByDefault = true
+	subject := reflex.PolicySubject{UserID: "u", Policy: "free"}
+
+	req := &reflex.PolicyReq{Profile: "zoom", Bandwidth: 5000}
+	grant, err := engine.Decide(context.Background(), subject, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if grant.Rule != "policy:free" {
+		t.Fatalf("unexpected matched rule %q", grant.Rule)
+	}
+	if strings.Contains(string(grant.Marshal()), "policy:free") {
+		t.Fatal("matched rule must not be sent to the client")
+	}
+	audit := &reflex.PolicyAudit{Subject: subject, Request: req, Grant: grant}
+	if s := audit.String(); !strings.Contains(s, "decision=grant rule=policy:free") || !strings.Contains(s, `"bandwidth":1000`) {
+		t.Fatalf("unexpected grant record %q", s)
+	}
+
+	req = &reflex.PolicyReq{Profile: "youtube"}
+	_, err = engine.Decide(context.Background(), subject, req)
+	if err == nil {
+		t.Fatal("disallowed profile must be denied")
+	}
+	audit = &reflex.PolicyAudit{Subject: subject, Request: req, Err: err}
+	if s := audit.String(); !strings.Contains(s, "decision=deny") || !strings.Contains(s, `"profile":"youtube"`) || !strings.Contains(s, "policy:free") {
+		t.Fatalf("unexpected deny record %q", s)
+	}
+}
+
+func TestReflexRenewalKeepsSessionLifetime(t *testing.T) {
+	userID := uuid.New()
+	handler, err := inbound.New(context.Background(), &reflex.InboundConfig{
+		Clients:      []*reflex.User{{Id: userID.String()}},
+		Policies:     []*reflex.PolicyConfig{{SessionTtl: 2}},
+		DrainTimeout: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer handler.(common.Closable).Close()
+	c, err := dialReflexClient(t, serveReflexReplyPort(t, handler, "pong"), userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	start := time.Now()
+
+	// A renewal just before the session is up does not extend it.
+	time.Sleep(1500 * time.Millisecond)
+	if err := c.RenewPolicy(&reflex.PolicyReq{Version: reflex.PolicyVersion}); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		if _, err := c.ReadFrame(); err != nil {
+			break
+		}
+	}
+	if c.CloseReason != reflex.CloseReasonExpired {
+		t.Fatalf("session ended with %q", c.CloseReason)
+	}
+	if d := time.Since(start); d > 2700*time.Millisecond {
+		t.Fatalf("session ended after %v, its lifetime restarted with the renewal", d)
+	}
+}
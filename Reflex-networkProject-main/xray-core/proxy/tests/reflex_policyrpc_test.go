@@ -0,0 +1,90 @@
+package tests
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+
+	"google.golang.org/grpc"
+
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/proxy/reflex/policyrpc"
+)
+
+type testPolicyService struct {
+	policyrpc.UnimplementedPolicyServiceServer
+	calls atomic.Int32
+}
+
+func (s *testPolicyService) Evaluate(ctx context.Context, req *policyrpc.EvaluateRequest) (*policyrpc.EvaluateResponse, error) {
+	s.calls.Add(1)
+	if req.Policy == "banned" {
+		return &policyrpc.EvaluateResponse{Deny: true}, nil
+	}
+	return &policyrpc.EvaluateResponse{Profile: "zoom", Bandwidth: 4096}, nil
+}
+
+func TestReflexRemotePolicyDecider(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	service := &testPolicyService{}
+	policyrpc.RegisterPolicyServiceServer(server, service)
+	go server.Serve(ln)
+	defer server.Stop()
+
+	decider, err := policyrpc.Dial(ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer decider.Close()
+
+	ctx := context.Background()
+	subject := reflex.PolicySubject{UserID: "u1"}
+	for i := 0; i < 3; i++ {
+		grant, err := decider.Decide(ctx, subject, &reflex.PolicyReq{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if grant.Profile != "zoom" || grant.Bandwidth != 4096 {
+			t.Fatalf("unexpected grant %+v", grant)
+		}
+	}
+	if n := service.calls.Load(); n != 1 {
+		t.Fatalf("decisions must be cached, service called %d times", n)
+	}
+
+	if _, err := decider.Decide(ctx, reflex.PolicySubject{UserID: "u2", Policy: "banned"}, &reflex.PolicyReq{}); err == nil {
+		t.Fatal("denial must be reported as an error")
+	}
+}
+
+func TestReflexRemotePolicyFailOpen(t *testing.T) {
+	// Nothing listens on this address once the listener is closed.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	decider, err := policyrpc.Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer decider.Close()
+	decider.Fallback = reflex.NewPolicyEngine(nil)
+
+	ctx := context.Background()
+	if _, err := decider.Decide(ctx, reflex.PolicySubject{}, &reflex.PolicyReq{}); err == nil {
+		t.Fatal("unreachable service must fail closed by default")
+	}
+	decider.FailOpen = true
+	grant, err := decider.Decide(ctx, reflex.PolicySubject{}, &reflex.PolicyReq{})
+	if err != nil || grant.Profile != reflex.DefaultProfileName {
+		t.Fatalf("fail-open must use the fallback: %+v %v", grant, err)
+	}
+}
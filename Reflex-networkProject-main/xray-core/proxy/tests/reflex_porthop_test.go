@@ -0,0 +1,186 @@
+package tests
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/xtls/xray-core/common"
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/proxy/reflex/inbound"
+	"github.com/xtls/xray-core/proxy/reflex/outbound"
+	"github.com/xtls/xray-core/transport/internet/stat"
+)
+
+func TestReflexPortHopper(t *testing.T) {
+	hopper, err := reflex.NewPortHopper([]byte("secret"), 20000, 100, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer, _ := reflex.NewPortHopper([]byte("secret"), 20000, 100, time.Minute)
+
+	now := time.Now()
+	seen := make(map[uint16]bool)
+	for i := 0; i < 10; i++ {
+		at := now.Add(time.Duration(i) * time.Minute)
+		port := hopper.Port(at)
+		if port < 20000 || port >= 20100 {
+			t.Fatalf("port %d outside the range", port)
+		}
+		if peer.Port(at) != port {
+			t.Fatal("peers with the same secret must agree on the port")
+		}
+		if !hopper.Active(port, at) || !hopper.Active(port, at.Add(time.Minute)) {
+			t.Fatalf("port %d must stay active within the window", port)
+		}
+		seen[port] = true
+	}
+	if len(seen) < 2 {
+		t.Fatal("port never hopped")
+	}
+
+	if _, err := reflex.NewPortHopper(nil, 20000, 100, 0); err == nil {
+		t.Fatal("missing secret must be rejected")
+	}
+	if _, err := reflex.NewPortHopper([]byte("secret"), 65500, 100, 0); err == nil {
+		t.Fatal("range past port 65535 must be rejected")
+	}
+}
+
+func TestReflexPortHoppingRefusesInactivePort(t *testing.T) {
+	for _, active := range []bool{true, false} {
+		// Reserve a port, then serve it with a hop range that does or does
+		// not contain it.
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		port := uint32(ln.Addr().(*net.TCPAddr).Port)
+		ln.Close()
+		base := port
+		if !active {
+			base = port + 1
+		}
+
+		userID := uuid.New()
+		handler, err := inbound.New(context.Background(), &reflex.InboundConfig{
+			Clients:     []*reflex.User{{Id: userID.String()}},
+			PortHopping: &reflex.PortHopping{Secret: "secret", BasePort: base, PortCount: 1},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr := serveReflexPortAt(t, handler, "127.0.0.1:"+strconv.Itoa(int(port)))
+
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+		_, err = reflex.ClientHandshake(conn, &reflex.ClientOptions{UserID: userID})
+		conn.Close()
+		if active && err != nil {
+			t.Fatalf("handshake on the active port failed: %v", err)
+		}
+		if !active && err == nil {
+			t.Fatal("handshake on an inactive port must fail")
+		}
+	}
+}
+
+// listenReflexPortRange listens on count consecutive ports of 127.0.0.1.
+func listenReflexPortRange(t *testing.T, count int) []net.Listener {
+	t.Helper()
+	for try := 0; try < 20; try++ {
+		first, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		base := first.Addr().(*net.TCPAddr).Port
+		lns := []net.Listener{first}
+		for i := 1; i < count; i++ {
+			ln, err := net.Listen("tcp", "127.0.0.1:"+strconv.Itoa(base+i))
+			if err != nil {
+				break
+			}
+			lns = append(lns, ln)
+		}
+		if len(lns) < count {
+			for _, ln := range lns {
+				ln.Close()
+			}
+			continue
+		}
+		for _, ln := range lns {
+			t.Cleanup(func() { _ = ln.Close() })
+		}
+		return lns
+	}
+	t.Fatal("no free port range")
+	return nil
+}
+
+func TestReflexOutboundPortHopping(t *testing.T) {
+	// The server hops over ports the test could listen on.
+	lns := listenReflexPortRange(t, 4)
+	hopping := &reflex.PortHopping{
+		Secret:    "a long enough secret",
+		BasePort:  uint32(lns[0].Addr().(*net.TCPAddr).Port),
+		PortCount: uint32(len(lns)),
+		Interval:  1,
+	}
+	userID := uuid.New()
+	handler, err := inbound.New(context.Background(), &reflex.InboundConfig{
+		Clients:      []*reflex.User{{Id: userID.String()}},
+		PortHopping:  hopping,
+		DrainTimeout: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer handler.(common.Closable).Close()
+	dispatcher := newReflexReplyDispatcher("pong")
+	for _, ln := range lns {
+		go func() {
+			for {
+				conn, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				go handler.Process(context.Background(), xnet.Network_TCP, stat.Connection(conn), dispatcher)
+			}
+		}()
+	}
+	hopper, err := hopping.Hopper()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ob, err := outbound.New(context.Background(), &reflex.OutboundConfig{Address: "127.0.0.1", Id: userID.String(), PortHopping: hopping})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dialer := &countingDialer{}
+	pingReflexOutbound(t, ob, dialer)
+	first := dialer.port.Load()
+	if first < hopping.BasePort || first >= hopping.BasePort+hopping.PortCount {
+		t.Fatalf("dialed port %d outside the range", first)
+	}
+	// Wait for the hop to another port, and reach the server on it.
+	deadline := time.Now().Add(15 * time.Second)
+	for uint32(hopper.Port(time.Now())) == first {
+		if time.Now().After(deadline) {
+			t.Fatal("the port never hopped")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	pingReflexOutbound(t, ob, dialer)
+	if got := dialer.port.Load(); got == first {
+		t.Fatalf("dialed port %d again after the hop", got)
+	}
+}
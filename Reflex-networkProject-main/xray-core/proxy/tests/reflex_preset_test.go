@@ -0,0 +1,83 @@
+package tests
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/xtls/xray-core/common"
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/proxy/reflex/inbound"
+)
+
+func TestReflexBuiltinPresets(t *testing.T) {
+	for name, p := range reflex.Presets {
+		if p.Name != name {
+			t.Errorf("preset %s is registered as %s", p.Name, name)
+		}
+		if err := p.Validate(); err != nil {
+			t.Errorf("built-in preset %s: %v", name, err)
+		}
+	}
+}
+
+func TestReflexLoadPresets(t *testing.T) {
+	presets, err := reflex.LoadPresets(strings.NewReader(`[
+		{"name": "dorm", "profiles": ["zoom"], "carrier": "tcp", "fragment": {"minSize": 4, "maxSize": 16, "maxDelay": 5}, "handshakeTimeout": 8}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dorm := presets["dorm"]
+	if dorm == nil || dorm.HandshakeTimeout != 8 || dorm.Fragment.Config().MaxDelay != 5*time.Millisecond {
+		t.Fatalf("unexpected presets %+v", presets)
+	}
+
+	for _, bundle := range []string{
+		`[{"name": "x", "profiles": ["no-such-profile"], "carrier": "tcp"}]`,
+		`[{"name": "x", "profiles": ["zoom"], "carrier": "carrier-pigeon"}]`,
+		`[{"name": "x", "profiles": ["zoom"], "carrier": "tcp", "fragment": {"minSize": 0}}]`,
+		`[{"name": "x", "profiles": ["zoom"], "carrier": "tcp"}, {"name": "x", "profiles": ["zoom"], "carrier": "quic"}]`,
+		`{"name": "x"}`,
+	} {
+		if _, err := reflex.LoadPresets(strings.NewReader(bundle)); err == nil {
+			t.Errorf("bundle %s must be refused", bundle)
+		}
+	}
+}
+
+func TestReflexPresetClientOptions(t *testing.T) {
+	userID := uuid.New()
+	handler, err := inbound.New(context.Background(), &reflex.InboundConfig{
+		Clients:      []*reflex.User{{Id: userID.String()}},
+		DrainTimeout: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer handler.(common.Closable).Close()
+	addr := serveReflexReplyPort(t, handler, "pong")
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	rec := &writeRecorder{Conn: conn}
+	c, err := reflex.ClientHandshake(rec, reflex.Presets["national-whitelist"].ClientOptions(userID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Profile != "http2-api" || !c.Grant.HasFeature(reflex.FeatureTLSRecords) {
+		t.Fatalf("preset was not applied: %+v", c.Grant)
+	}
+	if len(rec.writes) < 3 {
+		t.Fatalf("handshake was not fragmented: %d writes", len(rec.writes))
+	}
+	pingReflexSession(t, c)
+}
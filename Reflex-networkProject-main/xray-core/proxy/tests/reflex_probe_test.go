@@ -0,0 +1,92 @@
+package tests
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/xtls/xray-core/common"
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/proxy/reflex/inbound"
+	"github.com/xtls/xray-core/proxy/reflex/probe"
+)
+
+func serveProbeSite(t *testing.T, body string) string {
+	t.Helper()
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(site.Close)
+	return site.Listener.Addr().String()
+}
+
+func runReflexProbes(t *testing.T, server, decoy string, names ...string) map[string]probe.Result {
+	t.Helper()
+	all, err := probe.Probes("example.com", uuid.Nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var probes []probe.Probe
+	for _, p := range all {
+		for _, name := range names {
+			if p.Name == name {
+				probes = append(probes, p)
+			}
+		}
+	}
+	if len(probes) != len(names) {
+		t.Fatalf("missing built-in probes among %q", names)
+	}
+	results, err := probe.Run(&probe.Options{Server: server, Decoy: decoy, Timeout: time.Second}, probes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byName := make(map[string]probe.Result)
+	for _, r := range results {
+		byName[r.Probe] = r
+	}
+	return byName
+}
+
+func TestReflexProbeComparesResponses(t *testing.T) {
+	decoy := serveProbeSite(t, "welcome")
+	// The Date header changes between the two answers and must not count.
+	for name, r := range runReflexProbes(t, decoy, decoy, "http-get", "random-long") {
+		if r.Distinguishable() {
+			t.Errorf("%s: a site is distinguishable from itself: %s", name, r.Difference)
+		}
+	}
+
+	other := serveProbeSite(t, "a different site")
+	r := runReflexProbes(t, other, decoy, "http-get")["http-get"]
+	if r.Difference != "headers differ: Content-Length" {
+		t.Fatalf("unexpected difference %q", r.Difference)
+	}
+}
+
+func TestReflexProbeFallbackIsIndistinguishable(t *testing.T) {
+	decoy := serveProbeSite(t, "welcome")
+	_, port, _ := net.SplitHostPort(decoy)
+	dest, _ := net.LookupPort("tcp", port)
+	handler, err := inbound.New(context.Background(), &reflex.InboundConfig{
+		Clients:      []*reflex.User{{Id: uuid.New().String()}},
+		Fallback:     &reflex.Fallback{Dest: uint32(dest)},
+		DrainTimeout: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer handler.(common.Closable).Close()
+	addr := serveReflexReplyPort(t, handler, "pong")
+
+	for name, r := range runReflexProbes(t, addr, decoy, "random-long", "tls-client-hello") {
+		if r.Distinguishable() {
+			t.Errorf("%s: server is distinguishable from the decoy: %s", name, r.Difference)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xtls/xray-core/proxy/reflex/pt"
+)
+
+func TestReflexPTArgs(t *testing.T) {
+	args, err := pt.ParseArgs(`id=abc;psk=a\=b\;c;id=def`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if psk, _ := args.Get("psk"); psk != "a=b;c" || len(args["id"]) != 2 {
+		t.Fatalf("parsed %v", args)
+	}
+	if _, err := pt.ParseArgs("novalue"); err == nil {
+		t.Fatal("an argument without a value was accepted")
+	}
+	options, err := pt.ParseServerOptions("reflex:id=abc;reflex:fallback=80;obfs4:iat-mode=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fb, _ := options["reflex"].Get("fallback"); fb != "80" || len(options["obfs4"]) != 1 {
+		t.Fatalf("parsed %v", options)
+	}
+}
+
+func ptEnv(vars map[string]string) func(string) string {
+	return func(key string) string { return vars[key] }
+}
+
+func TestReflexPTEnvErrors(t *testing.T) {
+	var out bytes.Buffer
+	if _, err := pt.ReadEnv(ptEnv(map[string]string{"TOR_PT_MANAGED_TRANSPORT_VER": "2"}), pt.NewOutput(&out)); err == nil || out.String() != "VERSION-ERROR no-version\n" {
+		t.Fatalf("unsupported version: %v, %q", err, out.String())
+	}
+	out.Reset()
+	_, err := pt.ReadEnv(ptEnv(map[string]string{
+		"TOR_PT_MANAGED_TRANSPORT_VER": "1",
+		"TOR_PT_SERVER_TRANSPORTS":     "reflex",
+	}), pt.NewOutput(&out))
+	if err == nil || !strings.Contains(out.String(), "ENV-ERROR") {
+		t.Fatalf("server without ORPort: %v, %q", err, out.String())
+	}
+}
+
+// socks5ConnectWithArgs connects through a PT client the way Tor does,
+// with the bridge arguments as credentials.
+func socks5ConnectWithArgs(t *testing.T, proxy, args, target string) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("tcp", proxy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	reply := make([]byte, 2)
+	_, _ = conn.Write([]byte{5, 1, 2})
+	if _, err := io.ReadFull(conn, reply); err != nil || reply[1] != 2 {
+		t.Fatalf("method: %v, %v", reply, err)
+	}
+	auth := append([]byte{1, byte(len(args))}, args...)
+	_, _ = conn.Write(append(auth, 1, 0))
+	if _, err := io.ReadFull(conn, reply); err != nil || reply[1] != 0 {
+		t.Fatalf("credentials: %v, %v", reply, err)
+	}
+	host, portStr, _ := net.SplitHostPort(target)
+	port, _ := strconv.Atoi(portStr)
+	req := append([]byte{5, 1, 0, 1}, net.ParseIP(host).To4()...)
+	req = binary.BigEndian.AppendUint16(req, uint16(port))
+	_, _ = conn.Write(req)
+	resp := make([]byte, 10)
+	if _, err := io.ReadFull(conn, resp); err != nil || resp[1] != 0 {
+		t.Fatalf("connect: %v, %v", resp, err)
+	}
+	return conn
+}
+
+func TestReflexPTClientServer(t *testing.T) {
+	// The ORPort answers what it is sent.
+	orPort, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer orPort.Close()
+	go func() {
+		for {
+			conn, err := orPort.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				b := make([]byte, 1024)
+				n, _ := conn.Read(b)
+				_, _ = conn.Write(append([]byte("pong "), b[:n]...))
+			}()
+		}
+	}()
+
+	state := t.TempDir()
+	var serverOut bytes.Buffer
+	serverEnv, err := pt.ReadEnv(ptEnv(map[string]string{
+		"TOR_PT_MANAGED_TRANSPORT_VER": "1",
+		"TOR_PT_SERVER_TRANSPORTS":     "reflex",
+		"TOR_PT_SERVER_BINDADDR":       "reflex-127.0.0.1:0",
+		"TOR_PT_ORPORT":                orPort.Addr().String(),
+		"TOR_PT_STATE_LOCATION":        state,
+	}), pt.NewOutput(&serverOut))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := pt.StartServer(context.Background(), serverEnv, pt.NewOutput(&serverOut))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	lines := strings.Split(strings.TrimSpace(serverOut.String()), "\n")
+	if len(lines) != 3 || lines[0] != "VERSION 1" || !strings.HasPrefix(lines[1], "SMETHOD reflex 127.0.0.1:") || lines[2] != "SMETHODS DONE" {
+		t.Fatalf("server output %q", lines)
+	}
+	bridgeAddr := strings.Fields(lines[1])[2]
+
+	// The bridge line names the ID the server made up.
+	b, err := os.ReadFile(filepath.Join(state, "reflex_bridgeline.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := strings.Fields(string(b))
+	if len(fields) != 4 || fields[1] != "reflex" || fields[2] != bridgeAddr || !strings.HasPrefix(fields[3], "id=") {
+		t.Fatalf("bridge line %q", b)
+	}
+
+	var clientOut bytes.Buffer
+	clientEnv, err := pt.ReadEnv(ptEnv(map[string]string{
+		"TOR_PT_MANAGED_TRANSPORT_VER": "1",
+		"TOR_PT_CLIENT_TRANSPORTS":     "obfs4,reflex",
+	}), pt.NewOutput(&clientOut))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := pt.StartClient(clientEnv, pt.NewOutput(&clientOut))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	lines = strings.Split(strings.TrimSpace(clientOut.String()), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[1], "CMETHOD reflex socks5 127.0.0.1:") || lines[2] != "CMETHODS DONE" {
+		t.Fatalf("client output %q", lines)
+	}
+
+	conn := socks5ConnectWithArgs(t, strings.Fields(lines[1])[3], fields[3], bridgeAddr)
+	defer conn.Close()
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	reply := make([]byte, len("pong hello"))
+	if _, err := io.ReadFull(conn, reply); err != nil || string(reply) != "pong hello" {
+		t.Fatalf("reply %q, %v", reply, err)
+	}
+}
@@ -0,0 +1,106 @@
+package tests
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+
+	"github.com/xtls/xray-core/proxy/reflex"
+)
+
+func TestReflexRekeyKeepsReplayWindow(t *testing.T) {
+	key := make([]byte, 32)
+	_, _ = rand.Read(key)
+	client, err := reflex.NewClientSession(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := reflex.NewServerSession(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetKeyCommitment(true)
+	server.SetKeyCommitment(true)
+
+	// Frames sealed before the rekey are still in flight when the first one
+	// sealed after it is written.
+	var wire bytes.Buffer
+	var records [][]byte
+	write := func(payload string) {
+		start := wire.Len()
+		if err := client.WriteFrame(&wire, reflex.FrameTypeData, []byte(payload)); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, append([]byte(nil), wire.Bytes()[start:]...))
+	}
+	write("old-1")
+	write("old-2")
+	if err := client.Rekey(); err != nil {
+		t.Fatal(err)
+	}
+	write("new-1")
+	write("new-2")
+	if w, _ := client.Epochs(); w != 1 {
+		t.Fatalf("client writes in epoch %d after rekeying", w)
+	}
+	if n := client.Stats().FramesWritten; n != 4 {
+		t.Fatalf("client reports %d frames written across the rekey, want 4", n)
+	}
+
+	for _, want := range []string{"old-1", "old-2", "new-1"} {
+		f, err := server.ReadFrame(&wire)
+		if err != nil {
+			t.Fatalf("reading %s: %v", want, err)
+		}
+		if string(f.Payload) != want {
+			t.Fatalf("expected %q, got %q", want, f.Payload)
+		}
+	}
+	if _, r := server.Epochs(); r != 1 {
+		t.Fatalf("server reads in epoch %d after the rekey", r)
+	}
+
+	// The rest of the session moves to another process mid-epoch.
+	restored, err := reflex.RestoreSession(server.State())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f, err := restored.ReadFrame(&wire); err != nil || string(f.Payload) != "new-2" {
+		t.Fatalf("restored session read %v, %v", f, err)
+	}
+
+	// Neither epoch's frames can be replayed.
+	for i, rec := range records {
+		if _, err := restored.ReadFrame(bytes.NewReader(rec)); err == nil {
+			t.Fatalf("record %d was replayed", i)
+		}
+	}
+
+	// The server writes in epoch 0 still; each direction rekeys by itself.
+	if err := restored.WriteFrame(&wire, reflex.FrameTypeData, []byte("reply")); err != nil {
+		t.Fatal(err)
+	}
+	if f, err := client.ReadFrame(&wire); err != nil || string(f.Payload) != "reply" {
+		t.Fatalf("client read %v, %v", f, err)
+	}
+}
+
+func TestReflexRekeyLiveSession(t *testing.T) {
+	handler, userID := newReflexTestHandlerWithClient(t)
+	addr := serveReflexReplyPort(t, handler, "pong")
+
+	c, err := dialReflexClient(t, addr, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pingReflexSession(t, c)
+	for i := 0; i < 3; i++ {
+		if err := c.Session.Rekey(); err != nil {
+			t.Fatal(err)
+		}
+		pingReflexSession(t, c)
+	}
+	if w, _ := c.Session.Epochs(); w != 3 {
+		t.Fatalf("expected epoch 3, got %d", w)
+	}
+}
@@ -0,0 +1,221 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/xtls/xray-core/common/buf"
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/features/routing"
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/transport"
+	"github.com/xtls/xray-core/transport/pipe"
+)
+
+// shoutDispatcher is a routing.Dispatcher whose links answer every chunk in
+// upper case and then, unasked, push "push" after a while, like a server on a
+// long-lived connection that speaks on its own. Port 9 is refused.
+type shoutDispatcher struct {
+	links atomic.Int32
+}
+
+func (d *shoutDispatcher) Type() interface{} { return routing.DispatcherType() }
+func (d *shoutDispatcher) Start() error      { return nil }
+func (d *shoutDispatcher) Close() error      { return nil }
+
+func (d *shoutDispatcher) Dispatch(ctx context.Context, dest xnet.Destination) (*transport.Link, error) {
+	if dest.Port == 9 {
+		return nil, errors.New("refused")
+	}
+	d.links.Add(1)
+	upReader, upWriter := pipe.New(pipe.WithoutSizeLimit())
+	downReader, downWriter := pipe.New(pipe.WithoutSizeLimit())
+	go func() {
+		defer downWriter.Close()
+		for {
+			mb, err := upReader.ReadMultiBuffer()
+			if !mb.IsEmpty() {
+				got := make([]byte, mb.Len())
+				mb.Copy(got)
+				buf.ReleaseMulti(mb)
+				_ = downWriter.WriteMultiBuffer(buf.MergeBytes(nil, bytes.ToUpper(got)))
+				go func() {
+					time.Sleep(50 * time.Millisecond)
+					_ = downWriter.WriteMultiBuffer(buf.MergeBytes(nil, []byte("push")))
+				}()
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return &transport.Link{Reader: downReader, Writer: upWriter}, nil
+}
+
+func (d *shoutDispatcher) DispatchLink(ctx context.Context, dest xnet.Destination, link *transport.Link) error {
+	return errors.New("not implemented")
+}
+
+func TestReflexFullDuplexRelay(t *testing.T) {
+	handler, userID := newReflexTestHandlerWithClient(t)
+	dispatcher := &shoutDispatcher{}
+	c, err := dialReflexClient(t, serveReflexDispatcher(t, handler, dispatcher), userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// Replies come padded to the server's default profile, which the
+	// client strips.
+	expect := func(want string) {
+		t.Helper()
+		f, err := c.ReadFrame()
+		if err != nil {
+			t.Fatalf("waiting for %q: %v", want, err)
+		}
+		if f.Type != reflex.FrameTypeData || string(f.Payload) != want {
+			t.Fatalf("frame %d %q, want %q", f.Type, f.Payload, want)
+		}
+	}
+	if err := c.WriteFrame(reflex.FrameTypeData, []byte("one")); err != nil {
+		t.Fatal(err)
+	}
+	expect("ONE")
+	// Pushed by the destination with nothing sent to prompt it.
+	expect("push")
+	if err := c.WriteFrame(reflex.FrameTypeData, []byte("two")); err != nil {
+		t.Fatal(err)
+	}
+	expect("TWO")
+	expect("push")
+	if n := dispatcher.links.Load(); n != 1 {
+		t.Fatalf("%d links dispatched, want the one for the whole session", n)
+	}
+
+	// A reply larger than the profile's packets comes whole, over several
+	// frames.
+	large := bytes.Repeat([]byte("reflex"), 700)
+	if err := c.WriteFrame(reflex.FrameTypeData, large); err != nil {
+		t.Fatal(err)
+	}
+	var got []byte
+	for len(got) < len(large) {
+		f, err := c.ReadFrame()
+		if err != nil {
+			t.Fatalf("after %d bytes: %v", len(got), err)
+		}
+		if f.Type != reflex.FrameTypeData {
+			t.Fatalf("frame %d", f.Type)
+		}
+		got = append(got, f.Payload...)
+	}
+	if !bytes.Equal(got, bytes.ToUpper(large)) {
+		t.Fatalf("large reply came back as %d bytes, %q...", len(got), got[:min(len(got), 32)])
+	}
+}
+
+// floodDispatcher is a routing.Dispatcher whose links push n bytes down as
+// fast as they can while they take whatever comes up, and report on drained
+// once n bytes have come up.
+type floodDispatcher struct {
+	n       int
+	drained chan struct{}
+}
+
+func (d *floodDispatcher) Type() interface{} { return routing.DispatcherType() }
+func (d *floodDispatcher) Start() error      { return nil }
+func (d *floodDispatcher) Close() error      { return nil }
+
+func (d *floodDispatcher) Dispatch(ctx context.Context, dest xnet.Destination) (*transport.Link, error) {
+	upReader, upWriter := pipe.New(pipe.WithoutSizeLimit())
+	downReader, downWriter := pipe.New(pipe.WithoutSizeLimit())
+	go func() {
+		for sent := 0; sent < d.n; sent += 1024 {
+			if downWriter.WriteMultiBuffer(buf.MergeBytes(nil, make([]byte, 1024))) != nil {
+				return
+			}
+		}
+	}()
+	go func() {
+		got := 0
+		for {
+			mb, err := upReader.ReadMultiBuffer()
+			got += int(mb.Len())
+			buf.ReleaseMulti(mb)
+			if got >= d.n {
+				close(d.drained)
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return &transport.Link{Reader: downReader, Writer: upWriter}, nil
+}
+
+func (d *floodDispatcher) DispatchLink(ctx context.Context, dest xnet.Destination, link *transport.Link) error {
+	return errors.New("not implemented")
+}
+
+func TestReflexBandwidthPerDirection(t *testing.T) {
+	const rate = 32 << 10
+	handler, userID := newReflexTestHandlerWithClient(t)
+	// Four seconds' worth each way: with the first second's burst, three
+	// seconds for each direction alone, seven if they shared one limit.
+	// The downlink also takes the profile's delays.
+	dispatcher := &floodDispatcher{n: 4 * rate, drained: make(chan struct{})}
+	conn, err := net.Dial("tcp", serveReflexDispatcher(t, handler, dispatcher))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(15 * time.Second))
+	c, err := reflex.ClientHandshake(conn, &reflex.ClientOptions{
+		UserID: userID,
+		Secret: userID[:],
+		Policy: &reflex.PolicyReq{Bandwidth: rate},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if c.Grant.Bandwidth != rate {
+		t.Fatalf("unexpected grant %+v", c.Grant)
+	}
+
+	start := time.Now()
+	wrote := make(chan error, 1)
+	go func() {
+		for sent := 0; sent < dispatcher.n; sent += 1024 {
+			if err := c.WriteFrame(reflex.FrameTypeData, make([]byte, 1024)); err != nil {
+				wrote <- err
+				return
+			}
+		}
+		wrote <- nil
+	}()
+	for got := 0; got < dispatcher.n; {
+		f, err := c.ReadFrame()
+		if err != nil {
+			t.Fatalf("after %d bytes down: %v", got, err)
+		}
+		got += len(f.Payload)
+	}
+	if err := <-wrote; err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-dispatcher.drained:
+	case <-time.After(5 * time.Second):
+		t.Fatal("uplink never reached the destination")
+	}
+	if d := time.Since(start); d > 6*time.Second {
+		t.Fatalf("both directions took %v, as if they shared one limit", d)
+	}
+}
@@ -0,0 +1,184 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/xtls/xray-core/common"
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/common/protocol"
+	"github.com/xtls/xray-core/features/routing"
+	"github.com/xtls/xray-core/proxy"
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/proxy/reflex/inbound"
+	"github.com/xtls/xray-core/transport/internet/stat"
+)
+
+func serveReflexReplyPort(t *testing.T, handler proxy.Inbound, reply string) string {
+	t.Helper()
+	return serveReflexDispatcher(t, handler, newReflexReplyDispatcher(reply))
+}
+
+// serveReflexDispatcher is serveReflexReplyPort with a dispatcher the test
+// can inspect or one of its own.
+func serveReflexDispatcher(t *testing.T, handler proxy.Inbound, dispatcher routing.Dispatcher) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go handler.Process(context.Background(), xnet.Network_TCP, stat.Connection(conn), dispatcher)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func dialReflexClient(t *testing.T, addr string, userID uuid.UUID) (*reflex.ClientConn, error) {
+	t.Helper()
+	return dialReflexClientWith(t, addr, &reflex.ClientOptions{UserID: userID})
+}
+
+// dialReflexClientWith is dialReflexClient with options of the test's own.
+func dialReflexClientWith(t *testing.T, addr string, opts *reflex.ClientOptions) (*reflex.ClientConn, error) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return reflex.ClientHandshake(conn, opts)
+}
+
+// pingReflexSession exchanges a data frame, which also makes sure the server
+// is serving the session.
+func pingReflexSession(t *testing.T, c *reflex.ClientConn) {
+	t.Helper()
+	if err := c.WriteFrame(reflex.FrameTypeData, []byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	f, err := c.ReadFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(f.Payload) != "pong" {
+		t.Fatalf("unexpected reply %q", f.Payload)
+	}
+}
+
+// expectReflexTerminated reads until the server ends the session and checks
+// the reason it gave.
+func expectReflexTerminated(t *testing.T, c *reflex.ClientConn, reason string) {
+	t.Helper()
+	if f, err := c.ReadFrame(); err == nil {
+		t.Fatalf("session was not terminated, got frame %d", f.Type)
+	}
+	if c.CloseReason != reason {
+		t.Fatalf("expected close reason %q, got %q", reason, c.CloseReason)
+	}
+}
+
+func TestReflexReloadKeepsSessions(t *testing.T) {
+	oldUser, keptUser, newUser := uuid.New(), uuid.New(), uuid.New()
+	handler, err := inbound.New(context.Background(), &reflex.InboundConfig{
+		Clients:      []*reflex.User{{Id: oldUser.String()}, {Id: keptUser.String()}},
+		DrainTimeout: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer handler.(common.Closable).Close()
+	addr := serveReflexReplyPort(t, handler, "pong")
+
+	c, err := dialReflexClient(t, addr, keptUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	removed, err := dialReflexClient(t, addr, oldUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pingReflexSession(t, c)
+	pingReflexSession(t, removed)
+
+	h := handler.(*inbound.Handler)
+	// An invalid config is refused as a whole.
+	if err := h.Reload(context.Background(), &reflex.InboundConfig{
+		Clients:       []*reflex.User{{Id: keptUser.String()}, {Id: newUser.String()}},
+		DefaultPolicy: "missing",
+	}); err == nil {
+		t.Fatal("reload with an undefined default policy must fail")
+	}
+	if h.GetUser(context.Background(), oldUser.String()) == nil {
+		t.Fatal("failed reload changed the clients")
+	}
+
+	if err := h.Reload(context.Background(), &reflex.InboundConfig{
+		Clients: []*reflex.User{{Id: keptUser.String()}, {Id: newUser.String()}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	expectReflexTerminated(t, removed, reflex.CloseReasonUserRemoved)
+	// Sessions of users that are still configured keep working.
+	pingReflexSession(t, c)
+
+	if _, err := dialReflexClient(t, addr, oldUser); !errors.Is(err, reflex.ErrHandshakeRejected) {
+		t.Fatalf("removed user must be rejected, got %v", err)
+	}
+	if _, err := dialReflexClient(t, addr, newUser); err != nil {
+		t.Fatalf("added user must be accepted: %v", err)
+	}
+}
+
+func TestReflexUserManager(t *testing.T) {
+	handler, userID := newReflexTestHandlerWithClient(t)
+	um := handler.(proxy.UserManager)
+	ctx := context.Background()
+	addr := serveReflexReplyPort(t, handler, "pong")
+
+	added := uuid.New()
+	if err := um.AddUser(ctx, &protocol.MemoryUser{Account: &inbound.MemoryAccount{Id: added.String()}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := um.AddUser(ctx, &protocol.MemoryUser{Account: &inbound.MemoryAccount{Id: added.String()}}); err == nil {
+		t.Fatal("duplicate user must be refused")
+	}
+	if n := um.GetUsersCount(ctx); n != 2 {
+		t.Fatalf("expected 2 users, got %d", n)
+	}
+	if _, err := dialReflexClient(t, addr, added); err != nil {
+		t.Fatalf("added user must be accepted: %v", err)
+	}
+	c, err := dialReflexClient(t, addr, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pingReflexSession(t, c)
+
+	if err := um.RemoveUser(ctx, userID.String()); err != nil {
+		t.Fatal(err)
+	}
+	expectReflexTerminated(t, c, reflex.CloseReasonUserRemoved)
+	if err := um.RemoveUser(ctx, userID.String()); err == nil {
+		t.Fatal("removing an unknown user must fail")
+	}
+	if _, err := dialReflexClient(t, addr, userID); !errors.Is(err, reflex.ErrHandshakeRejected) {
+		t.Fatalf("removed user must be rejected, got %v", err)
+	}
+	if users := um.GetUsers(ctx); len(users) != 1 || users[0].Email != added.String() {
+		t.Fatalf("unexpected users %v", users)
+	}
+}
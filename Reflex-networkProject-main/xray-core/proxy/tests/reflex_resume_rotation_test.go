@@ -0,0 +1,120 @@
+package tests
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/xtls/xray-core/common"
+	feature_inbound "github.com/xtls/xray-core/features/inbound"
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/proxy/reflex/command"
+	"github.com/xtls/xray-core/proxy/reflex/inbound"
+)
+
+func TestReflexResumptionKeyRotation(t *testing.T) {
+	s, err := reflex.NewClientSession(bytes.Repeat([]byte{3}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, base := s.Resumption()
+	if !bytes.Equal(s.ResumptionKey(0, 1000), base) {
+		t.Fatal("without rotation the key is not the resumption key")
+	}
+	rotation := time.Minute
+	if !bytes.Equal(s.ResumptionKey(rotation, 1200), s.ResumptionKey(rotation, 1259)) {
+		t.Fatal("the key changed within a period")
+	}
+	if bytes.Equal(s.ResumptionKey(rotation, 1259), s.ResumptionKey(rotation, 1260)) {
+		t.Fatal("the key did not rotate")
+	}
+	if bytes.Equal(s.ResumptionKey(rotation, 1200), base) {
+		t.Fatal("a rotated key is the resumption key")
+	}
+}
+
+// attachReflexRaw sends a resumption request for id signed with key and
+// returns the status line of the answer, or "" if the connection was taken.
+func attachReflexRaw(t *testing.T, addr string, id [16]byte, ts int64, key []byte) string {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(time.Second))
+	req := &reflex.ResumeRequest{SessionID: id, Timestamp: ts}
+	_, _ = rand.Read(req.Nonce[:])
+	if _, err := conn.Write(reflex.MarshalResume(req, key)); err != nil {
+		t.Fatal(err)
+	}
+	status, _ := bufio.NewReader(conn).ReadString('\n')
+	return status
+}
+
+func TestReflexResumeRotationAndRevocation(t *testing.T) {
+	u := uuid.New()
+	handler, err := inbound.New(context.Background(), &reflex.InboundConfig{
+		Clients:        []*reflex.User{{Id: u.String()}},
+		ResumeRotation: 60,
+		DrainTimeout:   1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer handler.(common.Closable).Close()
+	addr := serveReflexReplyPort(t, handler, "pong")
+
+	c, err := dialReflexClient(t, addr, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Grant.ResumeRotation != 60 {
+		t.Fatalf("granted rotation %d", c.Grant.ResumeRotation)
+	}
+	pingReflexSession(t, c)
+
+	// The client resumes with the key of the current period.
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if err := c.Resume(conn); err != nil {
+		t.Fatal(err)
+	}
+	pingReflexSession(t, c)
+
+	// Neither the unrotated key nor that of an earlier period is accepted.
+	id, base := c.Session.Resumption()
+	now := time.Now().Unix()
+	stolen := c.Session.ResumptionKey(time.Minute, now-120)
+	for name, key := range map[string][]byte{"unrotated": base, "expired": stolen} {
+		if status := attachReflexRaw(t, addr, id, now, key); !strings.Contains(status, "403") {
+			t.Fatalf("%s key: %q", name, status)
+		}
+	}
+	pingReflexSession(t, c)
+
+	manager := &fakeInboundManager{handlers: map[string]feature_inbound.Handler{}}
+	_ = manager.AddHandler(context.Background(), &fakeInboundHandler{tag: "reflex-in", in: handler})
+	resp, err := command.NewService(manager).RevokeResumption(context.Background(), &command.RevokeResumptionRequest{Email: u.String()})
+	if err != nil || resp.Sessions != 1 {
+		t.Fatalf("revoke: %v, %v", resp, err)
+	}
+	// A revoked session keeps its connection but cannot be resumed, even
+	// with the right key.
+	current := c.Session.ResumptionKey(time.Minute, now)
+	if status := attachReflexRaw(t, addr, id, now, current); !strings.Contains(status, "403") {
+		t.Fatalf("revoked session resumed: %q", status)
+	}
+	pingReflexSession(t, c)
+}
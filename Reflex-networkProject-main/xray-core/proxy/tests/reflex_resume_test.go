@@ -0,0 +1,219 @@
+package tests
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/xtls/xray-core/common/buf"
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/features/routing"
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/transport"
+	"github.com/xtls/xray-core/transport/pipe"
+)
+
+// pathForwarder forwards connections to addr. cut drops the client side of
+// every forwarded connection, as a client that moved to another network
+// sees it, while the server side stays open.
+func pathForwarder(t *testing.T, addr string) (string, func()) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	var clients, servers []net.Conn
+	t.Cleanup(func() {
+		_ = ln.Close()
+		mu.Lock()
+		defer mu.Unlock()
+		for _, conn := range servers {
+			_ = conn.Close()
+		}
+	})
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			upstream, err := net.Dial("tcp", addr)
+			if err != nil {
+				_ = conn.Close()
+				continue
+			}
+			mu.Lock()
+			clients = append(clients, conn)
+			servers = append(servers, upstream)
+			mu.Unlock()
+			go func() { _, _ = io.Copy(upstream, conn) }()
+			go func() { _, _ = io.Copy(conn, upstream) }()
+		}
+	}()
+	return ln.Addr().String(), func() {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, conn := range clients {
+			_ = conn.(*net.TCPConn).SetLinger(0)
+			_ = conn.Close()
+		}
+		clients = nil
+	}
+}
+
+func TestReflexResume(t *testing.T) {
+	handler, userID := newReflexTestHandlerWithClient(t)
+	addr := serveReflexReplyPort(t, handler, "pong")
+
+	c, err := dialReflexClient(t, addr, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pingReflexSession(t, c)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if err := c.Resume(conn); err != nil {
+		t.Fatal(err)
+	}
+	pingReflexSession(t, c)
+	pingReflexSession(t, c)
+
+	// A request with the right id but the wrong key is answered like a
+	// stranger, and the session goes on.
+	id, _ := c.Session.Resumption()
+	forged, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer forged.Close()
+	_ = forged.SetDeadline(time.Now().Add(5 * time.Second))
+	req := &reflex.ResumeRequest{SessionID: id, Timestamp: time.Now().Unix()}
+	if _, err := forged.Write(reflex.MarshalResume(req, make([]byte, 32))); err != nil {
+		t.Fatal(err)
+	}
+	status, err := bufio.NewReader(forged).ReadString('\n')
+	if err != nil || !strings.HasPrefix(status, "HTTP/1.1 403") {
+		t.Fatalf("forged resumption got %q, %v", status, err)
+	}
+	pingReflexSession(t, c)
+
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Resume(conn); err == nil {
+		t.Fatal("a closed session was resumed")
+	}
+}
+
+// tallyDispatcher is a routing.Dispatcher whose links answer every chunk
+// with how many bytes the link carried so far, so a reply tells whether it
+// came over the same link as the chunks before.
+type tallyDispatcher struct {
+	links atomic.Int32
+}
+
+func (d *tallyDispatcher) Type() interface{} { return routing.DispatcherType() }
+func (d *tallyDispatcher) Start() error      { return nil }
+func (d *tallyDispatcher) Close() error      { return nil }
+
+func (d *tallyDispatcher) Dispatch(ctx context.Context, dest xnet.Destination) (*transport.Link, error) {
+	d.links.Add(1)
+	upReader, upWriter := pipe.New(pipe.WithoutSizeLimit())
+	downReader, downWriter := pipe.New(pipe.WithoutSizeLimit())
+	go func() {
+		defer downWriter.Close()
+		total := 0
+		for {
+			mb, err := upReader.ReadMultiBuffer()
+			if !mb.IsEmpty() {
+				total += int(mb.Len())
+				buf.ReleaseMulti(mb)
+				_ = downWriter.WriteMultiBuffer(buf.MergeBytes(nil, []byte(strconv.Itoa(total))))
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return &transport.Link{Reader: downReader, Writer: upWriter}, nil
+}
+
+func (d *tallyDispatcher) DispatchLink(ctx context.Context, dest xnet.Destination, link *transport.Link) error {
+	return errors.New("not implemented")
+}
+
+func TestReflexResumeKeepsRelays(t *testing.T) {
+	handler, userID := newReflexTestHandlerWithClient(t)
+	dispatcher := &tallyDispatcher{}
+	addr := serveReflexDispatcher(t, handler, dispatcher)
+	c, err := dialReflexClient(t, addr, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	send := func(data, want string) {
+		t.Helper()
+		if err := c.WriteFrame(reflex.FrameTypeData, []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+		f, err := c.ReadFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(f.Payload) != want {
+			t.Fatalf("sent %q, got %q, want %q", data, f.Payload, want)
+		}
+	}
+	send("ab", "2")
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if err := c.Resume(conn); err != nil {
+		t.Fatal(err)
+	}
+	// The destination goes on where it was, over the link it had.
+	send("cde", "5")
+	send("f", "6")
+	if n := dispatcher.links.Load(); n != 1 {
+		t.Fatalf("%d links dispatched, want the one from before the resumption", n)
+	}
+}
+
+func TestReflexMigrate(t *testing.T) {
+	handler, userID := newReflexTestHandlerWithClient(t)
+	addr := serveReflexReplyPort(t, handler, "pong")
+	forwarded, cut := pathForwarder(t, addr)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	dial := reflex.NewTunnelDialer(forwarded, &reflex.ClientOptions{UserID: userID, Migrate: true})
+	c, err := dial(ctx, "example.com:80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	pingReflexSession(t, c)
+
+	cut()
+	time.Sleep(50 * time.Millisecond)
+	pingReflexSession(t, c)
+	pingReflexSession(t, c)
+}
@@ -0,0 +1,65 @@
+package tests
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/common/session"
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/proxy/reflex/inbound"
+	"github.com/xtls/xray-core/transport"
+)
+
+func TestReflexContextWithGrant(t *testing.T) {
+	base := session.ContextWithContent(context.Background(), &session.Content{Protocol: "http"})
+	ctx := reflex.ContextWithGrant(base, &reflex.PolicyGrant{Tier: "premium", Tags: []string{"a", "b"}})
+
+	content := session.ContentFromContext(ctx)
+	if content.Protocol != "http" {
+		t.Fatal("existing content must be kept")
+	}
+	if content.Attribute(reflex.AttributeTier) != "premium" || content.Attribute(reflex.AttributeTags) != "a,b" {
+		t.Fatalf("unexpected attributes %v", content.Attributes)
+	}
+	if session.ContentFromContext(base).Attributes != nil {
+		t.Fatal("original content must not be modified")
+	}
+}
+
+// tierDispatcher records the tier attribute of every dispatched link.
+type tierDispatcher struct {
+	*reflexReplyDispatcher
+	tiers chan string
+}
+
+func (d *tierDispatcher) Dispatch(ctx context.Context, dest xnet.Destination) (*transport.Link, error) {
+	if c := session.ContentFromContext(ctx); c != nil {
+		d.tiers <- c.Attribute(reflex.AttributeTier)
+	} else {
+		d.tiers <- ""
+	}
+	return d.reflexReplyDispatcher.Dispatch(ctx, dest)
+}
+
+func TestReflexSessionTierReachesRouting(t *testing.T) {
+	userID := uuid.New()
+	handler, err := inbound.New(context.Background(), &reflex.InboundConfig{
+		Clients:  []*reflex.User{{Id: userID.String(), Policy: "gold"}},
+		Policies: []*reflex.PolicyConfig{{Name: "gold", Tier: "premium"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dispatcher := &tierDispatcher{newReflexReplyDispatcher("pong"), make(chan string, 1)}
+	client, conn, _ := dialReflexSession(t, handler, userID, dispatcher)
+
+	if err := client.WriteFrame(conn, reflex.FrameTypeData, []byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	if tier := <-dispatcher.tiers; tier != "premium" {
+		t.Fatalf("dispatch context carried tier %q", tier)
+	}
+}
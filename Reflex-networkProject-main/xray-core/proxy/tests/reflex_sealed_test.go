@@ -0,0 +1,187 @@
+package tests
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/xtls/xray-core/common"
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/proxy/reflex/inbound"
+)
+
+func TestReflexSealedHandshake(t *testing.T) {
+	private, public, err := reflex.GenerateServerKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pinned, err := reflex.ParseServerPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := uuid.New()
+	fronts := []*reflex.CoverFront{{Host: "cdn.example.com", Path: "/upload"}}
+	handler, err := inbound.New(context.Background(), &reflex.InboundConfig{
+		Clients:      []*reflex.User{{Id: u.String()}},
+		ServerKey:    private,
+		CoverFronts:  fronts,
+		RetryCookie:  true,
+		DrainTimeout: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer handler.(common.Closable).Close()
+	addr := serveReflexReplyPort(t, handler, "pong")
+
+	handshake := func(opts *reflex.ClientOptions) ([]byte, error) {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = conn.Close() })
+		_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+		rec := &writeRecorder{Conn: conn}
+		c, err := reflex.ClientHandshake(rec, opts)
+		if err != nil {
+			return rec.writes[0], err
+		}
+		pingReflexSession(t, c)
+		return rec.writes[0], nil
+	}
+
+	for _, fronted := range []bool{false, true} {
+		opts := &reflex.ClientOptions{UserID: u, ServerKey: pinned}
+		if fronted {
+			opts.Fronts = fronts
+		}
+		// The cookie a server asks for is checked before the handshake is
+		// opened, and echoed sealed all the same.
+		_, err := handshake(opts)
+		var retry *reflex.RetryError
+		if !errors.As(err, &retry) {
+			t.Fatalf("fronted %v: expected a retry, got %v", fronted, err)
+		}
+		opts.Cookie = retry.Cookie
+		wire, err := handshake(opts)
+		if err != nil {
+			t.Fatalf("fronted %v: %v", fronted, err)
+		}
+		if bytes.Contains(wire, u[:]) || bytes.Contains(wire, []byte(u.String())) {
+			t.Errorf("fronted %v: the user ID was sent in the clear", fronted)
+		}
+		if !fronted && binary.BigEndian.Uint32(wire) != reflex.SealedMagic {
+			t.Errorf("handshake was not sealed: %x", wire[:4])
+		}
+	}
+
+	// A handshake sealed to another server does not open here.
+	_, other, err := reflex.GenerateServerKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPinned, _ := reflex.ParseServerPublicKey(other)
+	wrong := &reflex.ClientOptions{UserID: u, ServerKey: otherPinned}
+	_, err = handshake(wrong)
+	var retry *reflex.RetryError
+	if !errors.As(err, &retry) {
+		t.Fatalf("expected a retry, got %v", err)
+	}
+	wrong.Cookie = retry.Cookie
+	if _, err := handshake(wrong); err == nil {
+		t.Fatal("handshake sealed to another server was accepted")
+	}
+}
+
+func TestReflexSealedHandshakeWithoutServerKey(t *testing.T) {
+	_, public, err := reflex.GenerateServerKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pinned, _ := reflex.ParseServerPublicKey(public)
+	handler, userID := newReflexTestHandlerWithClient(t)
+	addr := serveReflexReplyPort(t, handler, "pong")
+
+	// A server without an identity key has nothing to open the handshake
+	// with, and leaves it to the fallback.
+	if _, err := dialReflexClientWith(t, addr, &reflex.ClientOptions{UserID: userID, ServerKey: pinned}); err == nil {
+		t.Fatal("sealed handshake accepted by a server without an identity key")
+	}
+}
+
+func TestReflexRequireSealed(t *testing.T) {
+	if _, err := inbound.New(context.Background(), &reflex.InboundConfig{RequireSealed: true}); err == nil {
+		t.Fatal("require_sealed accepted without a server key to open handshakes with")
+	}
+
+	private, public, err := reflex.GenerateServerKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pinned, err := reflex.ParseServerPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := uuid.New()
+	psk := bytes.Repeat([]byte{0x42}, 32)
+	fronts := []*reflex.CoverFront{{Host: "cdn.example.com", Path: "/upload"}}
+	handler, err := inbound.New(context.Background(), &reflex.InboundConfig{
+		Clients:       []*reflex.User{{Id: u.String(), Psk: base64.StdEncoding.EncodeToString(psk)}},
+		ServerKey:     private,
+		CoverFronts:   fronts,
+		RequireSealed: true,
+		DrainTimeout:  1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer handler.(common.Closable).Close()
+	addr := serveReflexReplyPort(t, handler, "pong")
+
+	// Sealed handshakes are served, whether fronted or not.
+	for _, opts := range []*reflex.ClientOptions{
+		{UserID: u, ServerKey: pinned},
+		{UserID: u, ServerKey: pinned, Fronts: fronts},
+	} {
+		c, err := dialReflexClientWith(t, addr, opts)
+		if err != nil {
+			t.Fatalf("sealed handshake refused: %v", err)
+		}
+		pingReflexSession(t, c)
+	}
+
+	// Those that carry the user ID in the clear are not, even from a
+	// known user.
+	for _, opts := range []*reflex.ClientOptions{
+		{UserID: u},
+		{UserID: u, Fronts: fronts},
+	} {
+		if _, err := dialReflexClientWith(t, addr, opts); err == nil {
+			t.Fatalf("plain handshake accepted, fronted %v", opts.Fronts != nil)
+		}
+	}
+	hs := &reflex.PSKHandshake{Timestamp: time.Now().Unix()}
+	copy(hs.UserID[:], u[:])
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write(reflex.MarshalPSKHandshake(hs, psk)); err != nil {
+		t.Fatal(err)
+	}
+	statusLine, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil || !strings.Contains(statusLine, "403") {
+		t.Fatalf("expected 403 for a PSK handshake, got %q (%v)", statusLine, err)
+	}
+}
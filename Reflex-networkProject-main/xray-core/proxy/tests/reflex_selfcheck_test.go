@@ -0,0 +1,76 @@
+package tests
+
+import (
+	"encoding/base64"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/proxy/reflex/inbound"
+)
+
+func TestReflexSelfCheckHealthyConfig(t *testing.T) {
+	cover, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cover.Close()
+
+	warnings := inbound.SelfCheck(&reflex.InboundConfig{
+		Clients:  []*reflex.User{{Id: uuid.New().String()}},
+		Fallback: &reflex.Fallback{Dest: uint32(cover.Addr().(*net.TCPAddr).Port)},
+	})
+	if len(warnings) != 0 {
+		t.Fatalf("unexpected warnings %q", warnings)
+	}
+}
+
+func TestReflexSelfCheckWarnings(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedPort := uint32(ln.Addr().(*net.TCPAddr).Port)
+	ln.Close()
+
+	psk := base64.StdEncoding.EncodeToString([]byte("0123456789abcdef"))
+	warnings := inbound.SelfCheck(&reflex.InboundConfig{
+		Clients: []*reflex.User{
+			{Id: strings.ToUpper(uuid.New().String()), Psk: psk},
+			{Id: uuid.New().String(), Psk: psk, Policy: "missing"},
+		},
+		Fallback:    &reflex.Fallback{Dest: closedPort},
+		PortHopping: &reflex.PortHopping{Secret: "short", BasePort: 20000, PortCount: 10},
+	})
+	for _, want := range []string{"is unreachable", "only matches when written as", "share a psk", `undefined policy "missing"`, "port hopping secret"} {
+		found := false
+		for _, w := range warnings {
+			found = found || strings.Contains(w, want)
+		}
+		if !found {
+			t.Errorf("missing warning %q in %q", want, warnings)
+		}
+	}
+}
+
+func TestReflexTrafficProfileValidate(t *testing.T) {
+	for name, p := range reflex.Profiles {
+		if err := p.Validate(); err != nil {
+			t.Errorf("built-in profile %s: %v", name, err)
+		}
+	}
+	bad := []*reflex.TrafficProfile{
+		{Name: "empty"},
+		{Name: "huge", PacketSizes: []reflex.PacketSizeDist{{Size: 1 << 20, Weight: 1}}},
+		{Name: "weightless", PacketSizes: []reflex.PacketSizeDist{{Size: 100}}},
+		{Name: "negative", PacketSizes: []reflex.PacketSizeDist{{Size: 100, Weight: 1}}, Delays: []reflex.DelayDist{{Delay: -1, Weight: 1}}},
+	}
+	for _, p := range bad {
+		if err := p.Validate(); err == nil {
+			t.Errorf("profile %s must be invalid", p.Name)
+		}
+	}
+}
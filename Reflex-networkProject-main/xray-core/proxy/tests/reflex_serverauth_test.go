@@ -0,0 +1,75 @@
+package tests
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/xtls/xray-core/common"
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/proxy/reflex/inbound"
+)
+
+func TestReflexServerAuthentication(t *testing.T) {
+	private, public, err := reflex.GenerateServerKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pinned, err := reflex.ParseServerPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := uuid.New()
+	serve := func(serverKey string) string {
+		handler, err := inbound.New(context.Background(), &reflex.InboundConfig{
+			Clients:   []*reflex.User{{Id: u.String()}},
+			ServerKey: serverKey,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = handler.(common.Closable).Close() })
+		return serveReflexReplyPort(t, handler, "pong")
+	}
+	server := serve(private)
+
+	c, err := dialReflexClientWith(t, server, &reflex.ClientOptions{UserID: u, ServerKey: pinned})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pingReflexSession(t, c)
+	c.Close()
+
+	// A client that pins no key accepts the server all the same.
+	c, err = dialReflexClient(t, server, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+
+	// The user's UUID is all it takes to stand up a server that completes
+	// the handshake: one in the middle, without the identity key, or with
+	// another, is refused. Such a server cannot open the sealed handshake
+	// either, so it never gets to answer.
+	otherPrivate, _, err := reflex.GenerateServerKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, impostor := range []string{serve(""), serve(otherPrivate)} {
+		if _, err := dialReflexClientWith(t, impostor, &reflex.ClientOptions{UserID: u, ServerKey: pinned}); err == nil {
+			t.Error("handshake with an impostor succeeded")
+		}
+	}
+}
+
+func TestReflexServerKeyConfig(t *testing.T) {
+	for _, key := range []string{"not base64!", "c2hvcnQ="} {
+		if _, err := inbound.New(context.Background(), &reflex.InboundConfig{ServerKey: key}); err == nil {
+			t.Errorf("accepted server key %q", key)
+		}
+		if _, err := reflex.ParseServerPublicKey(key); err == nil {
+			t.Errorf("accepted server public key %q", key)
+		}
+	}
+}
@@ -66,3 +66,145 @@ func TestReflexSessionReplayRejected(t *testing.T) {
 		t.Fatalf("expected replay error, got: %v", err)
 	}
 }
+
+func TestReflexSessionHooks(t *testing.T) {
+	key := make([]byte, 32)
+	sess, err := reflex.NewSession(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var written, read, control []uint8
+	sess.SetHooks(reflex.SessionHooks{
+		OnFrameWrite: func(frameType uint8, payload []byte) { written = append(written, frameType) },
+		OnFrameRead:  func(frame *reflex.Frame) { read = append(read, frame.Type) },
+		OnControl:    func(frame *reflex.Frame) { control = append(control, frame.Type) },
+	})
+
+	var buf bytes.Buffer
+	_ = sess.WriteFrame(&buf, reflex.FrameTypeData, []byte("data"))
+	_ = sess.WriteFrame(&buf, reflex.FrameTypePaddingCtrl, []byte{0x01, 0x00})
+	for i := 0; i < 2; i++ {
+		if _, err := sess.ReadFrame(&buf); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(written) != 2 || len(read) != 2 {
+		t.Fatalf("expected 2 write and 2 read events, got %d and %d", len(written), len(read))
+	}
+	if len(control) != 1 || control[0] != reflex.FrameTypePaddingCtrl {
+		t.Fatalf("expected one PADDING_CTRL control event, got %v", control)
+	}
+
+	// Removing hooks must stop further callbacks.
+	sess.SetHooks(reflex.SessionHooks{})
+	_ = sess.WriteFrame(&buf, reflex.FrameTypeData, []byte("x"))
+	if len(written) != 2 {
+		t.Fatalf("hook called after removal")
+	}
+}
+
+func TestReflexPlaintextSession(t *testing.T) {
+	sess, err := reflex.NewPlaintextSession()
+	if !reflex.InsecureBuild {
+		if err == nil {
+			t.Fatal("plaintext session must be refused without the reflex_insecure tag")
+		}
+		return
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	payload := []byte("visible on the wire")
+	if err := sess.WriteFrame(&buf, reflex.FrameTypeData, payload); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(buf.Bytes(), payload) {
+		t.Fatal("expected payload to be readable in plaintext mode")
+	}
+	frame, err := sess.ReadFrame(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(frame.Payload, payload) {
+		t.Fatalf("payload mismatch: got %q", frame.Payload)
+	}
+}
+
+func TestReflexStreamSessionsAreIsolated(t *testing.T) {
+	key := make([]byte, 32)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	client, _ := reflex.NewSession(key)
+	server, _ := reflex.NewSession(key)
+
+	clientStream1, err := client.StreamSession(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverStream1, _ := server.StreamSession(1)
+	serverStream2, _ := server.StreamSession(2)
+
+	var buf bytes.Buffer
+	_ = clientStream1.WriteFrame(&buf, reflex.FrameTypeData, []byte("stream one"))
+	wire := append([]byte(nil), buf.Bytes()...)
+
+	frame, err := serverStream1.ReadFrame(&buf)
+	if err != nil {
+		t.Fatalf("same stream ID must interoperate: %v", err)
+	}
+	if string(frame.Payload) != "stream one" {
+		t.Fatalf("payload mismatch: got %q", frame.Payload)
+	}
+
+	if _, err := serverStream2.ReadFrame(bytes.NewReader(wire)); err == nil {
+		t.Fatal("frame from stream 1 must not open under stream 2 key")
+	}
+	if _, err := server.ReadFrame(bytes.NewReader(wire)); err == nil {
+		t.Fatal("frame from stream 1 must not open under the session key")
+	}
+}
+
+func TestReflexSessionNoncePrefix(t *testing.T) {
+	key := make([]byte, 32)
+	client, _ := reflex.NewClientSession(key)
+	server, _ := reflex.NewServerSession(key)
+
+	var c2s, s2c bytes.Buffer
+	_ = client.WriteFrame(&c2s, reflex.FrameTypeData, []byte("up"))
+	_ = server.WriteFrame(&s2c, reflex.FrameTypeData, []byte("down"))
+
+	// Both sides start at counter 0 under the same key, but the nonces differ.
+	if bytes.Equal(c2s.Bytes()[2:14], s2c.Bytes()[2:14]) {
+		t.Fatal("client and server nonces must differ")
+	}
+	if c2s.Bytes()[2] != reflex.DirectionClient || s2c.Bytes()[2] != reflex.DirectionServer {
+		t.Fatal("expected direction byte at nonce offset 0")
+	}
+
+	upWire := append([]byte(nil), c2s.Bytes()...)
+	if _, err := server.ReadFrame(&c2s); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.ReadFrame(&s2c); err != nil {
+		t.Fatal(err)
+	}
+
+	// The client must not accept its own frame reflected back.
+	if _, err := client.ReadFrame(bytes.NewReader(upWire)); err == nil {
+		t.Fatal("reflected frame should be rejected")
+	}
+
+	// A frame from another client session (different salt) cannot be spliced in.
+	other, _ := reflex.NewClientSession(key)
+	var discarded, spliced bytes.Buffer
+	_ = other.WriteFrame(&discarded, reflex.FrameTypeData, []byte("a")) // counter 0 would be a replay anyway
+	_ = other.WriteFrame(&spliced, reflex.FrameTypeData, []byte("b"))
+	if _, err := server.ReadFrame(&spliced); err == nil || err.Error() != "reflex: nonce prefix mismatch" {
+		t.Fatalf("expected nonce prefix mismatch, got %v", err)
+	}
+}
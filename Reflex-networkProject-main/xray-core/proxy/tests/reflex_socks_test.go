@@ -0,0 +1,86 @@
+package tests
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/xtls/xray-core/proxy/reflex"
+)
+
+func TestReflexSOCKS5FrontEnd(t *testing.T) {
+	handler, userID := newReflexTestHandlerWithClient(t)
+	addr := serveReflexReplyPort(t, handler, "pong")
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	asked := make(chan string, 2)
+	dial := reflex.NewTunnelDialer(addr, &reflex.ClientOptions{UserID: userID})
+	srv := &reflex.SOCKS5Server{Dial: func(ctx context.Context, address string) (*reflex.ClientConn, error) {
+		asked <- address
+		return dial(ctx, address)
+	}}
+	done := make(chan error, 1)
+	go func() { done <- srv.Serve(ln) }()
+
+	// request opens a SOCKS5 connection and sends a request with the given
+	// command for example.com:443, returning the reply code.
+	request := func(cmd byte) (net.Conn, byte) {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+		if _, err := conn.Write([]byte{5, 1, 0}); err != nil {
+			t.Fatal(err)
+		}
+		method := make([]byte, 2)
+		if _, err := io.ReadFull(conn, method); err != nil || method[1] != 0 {
+			t.Fatalf("method selection %v: %v", method, err)
+		}
+		req := append([]byte{5, cmd, 0, 3, byte(len("example.com"))}, "example.com"...)
+		if _, err := conn.Write(append(req, 1, 187)); err != nil {
+			t.Fatal(err)
+		}
+		reply := make([]byte, 10)
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			t.Fatal(err)
+		}
+		return conn, reply[1]
+	}
+
+	conn, status := request(1)
+	if status != 0 {
+		t.Fatalf("CONNECT answered with %d", status)
+	}
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, 64)
+	n, err := io.ReadAtLeast(conn, b, len("pong"))
+	if err != nil || string(b[:n]) != "pong" {
+		t.Fatalf("tunnel answered %q: %v", b[:n], err)
+	}
+	conn.Close()
+	if a := <-asked; a != "example.com:443" {
+		t.Fatalf("dialer asked for %s", a)
+	}
+
+	// UDP ASSOCIATE is not supported.
+	conn, status = request(3)
+	conn.Close()
+	if status != 7 {
+		t.Fatalf("UDP ASSOCIATE answered with %d", status)
+	}
+
+	if err := srv.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Serve returned %v after Close", err)
+	}
+}
@@ -0,0 +1,113 @@
+package tests
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/xtls/xray-core/common"
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/proxy/reflex/inbound"
+)
+
+func TestReflexReplayStateSurvivesRestart(t *testing.T) {
+	userID := uuid.New()
+	cfg := &reflex.InboundConfig{
+		Clients:   []*reflex.User{{Id: userID.String()}},
+		StateFile: filepath.Join(t.TempDir(), "state.json"),
+	}
+	var pub [32]byte
+	_, _ = rand.Read(pub[:])
+	hs := buildReflexMagicHandshakeFull(userID, time.Now().Unix(), pub, nil, nil)
+
+	status := func() int {
+		t.Helper()
+		handler, err := inbound.New(context.Background(), cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer handler.(common.Closable).Close()
+		conn, err := net.Dial("tcp", serveReflexPort(t, handler))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+		if _, err := conn.Write(hs); err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode
+	}
+
+	if code := status(); code != http.StatusOK {
+		t.Fatalf("first handshake failed with %d", code)
+	}
+	// The restarted server still remembers the nonce.
+	if code := status(); code != http.StatusForbidden {
+		t.Fatalf("replay after restart must be refused, got %d", code)
+	}
+}
+
+func TestReflexSaveLoadState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	now := time.Now()
+
+	if err := reflex.LoadState(path, reflex.NewReplayCache(time.Minute, 16), nil, now); err != nil {
+		t.Fatalf("missing state file must not be an error: %v", err)
+	}
+
+	replay := reflex.NewReplayCache(time.Minute, 16)
+	fresh, stale := [16]byte{1}, [16]byte{2}
+	replay.Check(stale, now.Add(-2*time.Minute))
+	replay.Check(fresh, now)
+	logins := reflex.NewLoginTracker(time.Hour, 1, reflex.LoginBlock)
+	logins.Observe("alice", "10.0.0.1", now)
+	logins.Observe("alice", "10.0.0.2", now)
+	if err := reflex.SaveState(path, replay, logins, now); err != nil {
+		t.Fatal(err)
+	}
+
+	replay = reflex.NewReplayCache(time.Minute, 16)
+	logins = reflex.NewLoginTracker(time.Hour, 1, reflex.LoginBlock)
+	if err := reflex.LoadState(path, replay, logins, now); err != nil {
+		t.Fatal(err)
+	}
+	if replay.Check(fresh, now) {
+		t.Fatal("restored nonce must be a replay")
+	}
+	if !replay.Check(stale, now) {
+		t.Fatal("expired nonce must not be restored")
+	}
+	if logins.Observe("alice", "10.0.0.1", now) {
+		t.Fatal("login block must survive a restart")
+	}
+}
+
+func TestReflexReplayCacheBounded(t *testing.T) {
+	replay := reflex.NewReplayCache(time.Minute, 2)
+	now := time.Now()
+	a, b, c := [16]byte{1}, [16]byte{2}, [16]byte{3}
+	for i, nonce := range [][16]byte{a, b, c} {
+		if !replay.Check(nonce, now.Add(time.Duration(i)*time.Second)) {
+			t.Fatalf("nonce %d must be fresh", i)
+		}
+	}
+	// Past its size the cache forgets the oldest nonce first.
+	if replay.Check(c, now.Add(3*time.Second)) || replay.Check(b, now.Add(3*time.Second)) {
+		t.Fatal("the newest nonces must still be replays")
+	}
+	if !replay.Check(a, now.Add(3*time.Second)) {
+		t.Fatal("the oldest nonce must have been evicted")
+	}
+}
@@ -0,0 +1,104 @@
+package tests
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/xtls/xray-core/common"
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/proxy/reflex/inbound"
+)
+
+func TestReflexSplitStrategy(t *testing.T) {
+	s, err := reflex.NewStrategy("split", map[string]string{"min": "10", "max": "10", "maxDelay": "0", "writes": "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() { _, _ = io.Copy(io.Discard, server) }()
+	rec := &writeRecorder{Conn: client}
+	conn := reflex.NewStrategyConn(rec, s)
+
+	if _, err := conn.Write(make([]byte, 35)); err != nil {
+		t.Fatal(err)
+	}
+	if len(rec.writes) != 4 {
+		t.Fatalf("first write left as %d segments, want 4", len(rec.writes))
+	}
+	if _, err := conn.Write(make([]byte, 35)); err != nil {
+		t.Fatal(err)
+	}
+	if len(rec.writes) != 5 {
+		t.Fatalf("second write was split although only the first should be")
+	}
+
+	if _, err := reflex.NewStrategy("split", map[string]string{"max": "ten"}); err == nil {
+		t.Fatal("bad argument accepted")
+	}
+	if _, err := reflex.NewStrategy("no-such-strategy", nil); err == nil {
+		t.Fatal("unknown strategy accepted")
+	}
+}
+
+// countingStrategy counts what passes through it.
+type countingStrategy struct {
+	writes, reads atomic.Int64
+}
+
+func (s *countingStrategy) Write(w io.Writer, b []byte) error {
+	s.writes.Add(1)
+	_, err := w.Write(b)
+	return err
+}
+
+func (s *countingStrategy) Read([]byte) { s.reads.Add(1) }
+
+func TestReflexStrategyOnSession(t *testing.T) {
+	serverSide := &countingStrategy{}
+	reflex.RegisterStrategy("test-counting", func(map[string]string) (reflex.Strategy, error) {
+		return serverSide, nil
+	})
+	userID := uuid.New()
+	handler, err := inbound.New(context.Background(), &reflex.InboundConfig{
+		Clients:      []*reflex.User{{Id: userID.String()}},
+		Strategy:     &reflex.WireStrategy{Name: "test-counting"},
+		DrainTimeout: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer handler.(common.Closable).Close()
+	addr := serveReflexReplyPort(t, handler, "pong")
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	split, err := reflex.NewStrategy("split", map[string]string{"writes": "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := reflex.ClientHandshake(conn, &reflex.ClientOptions{UserID: userID, Strategy: split})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pingReflexSession(t, c)
+	if serverSide.reads.Load() == 0 || serverSide.writes.Load() == 0 {
+		t.Fatalf("server strategy saw %d reads and %d writes", serverSide.reads.Load(), serverSide.writes.Load())
+	}
+
+	if _, err := inbound.New(context.Background(), &reflex.InboundConfig{
+		Strategy: &reflex.WireStrategy{Name: "no-such-strategy"},
+	}); err == nil {
+		t.Fatal("inbound accepted an unknown strategy")
+	}
+}
@@ -0,0 +1,87 @@
+package tests
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/xtls/xray-core/common"
+	"github.com/xtls/xray-core/common/log"
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/common/session"
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/proxy/reflex/inbound"
+	"github.com/xtls/xray-core/transport"
+	"github.com/xtls/xray-core/transport/internet/stat"
+)
+
+// ctxDispatcher passes on the context of every dispatch.
+type ctxDispatcher struct {
+	*reflexReplyDispatcher
+	ctxs chan context.Context
+}
+
+func (d *ctxDispatcher) Dispatch(ctx context.Context, dest xnet.Destination) (*transport.Link, error) {
+	d.ctxs <- ctx
+	return d.reflexReplyDispatcher.Dispatch(ctx, dest)
+}
+
+func TestReflexInboundTagAndEmail(t *testing.T) {
+	userID := uuid.New()
+	handler, err := inbound.New(context.Background(), &reflex.InboundConfig{
+		Clients:      []*reflex.User{{Id: userID.String(), Email: "alice@example.com"}},
+		DrainTimeout: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer handler.(common.Closable).Close()
+	h := handler.(*inbound.Handler)
+	if h.Tag() != "" {
+		t.Fatalf("tag %q known before any connection", h.Tag())
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	dispatcher := &ctxDispatcher{reflexReplyDispatcher: newReflexReplyDispatcher("pong"), ctxs: make(chan context.Context, 4)}
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		ctx := session.ContextWithInbound(context.Background(), &session.Inbound{Tag: "reflex-in"})
+		_ = handler.Process(ctx, xnet.Network_TCP, stat.Connection(conn), dispatcher)
+	}()
+
+	c, err := dialReflexClient(t, ln.Addr().String(), userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pingReflexSession(t, c)
+
+	var ctx context.Context
+	select {
+	case ctx = <-dispatcher.ctxs:
+	case <-time.After(5 * time.Second):
+		t.Fatal("nothing was dispatched")
+	}
+	in := session.InboundFromContext(ctx)
+	if in == nil || in.Tag != "reflex-in" || in.Name != "reflex" || in.User == nil || in.User.Email != "alice@example.com" {
+		t.Fatalf("dispatched with inbound metadata %+v", in)
+	}
+	if access := log.AccessMessageFromContext(ctx); access == nil || access.Email != "alice@example.com" {
+		t.Fatalf("dispatched with access message %+v", access)
+	}
+	if h.Tag() != "reflex-in" {
+		t.Fatalf("handler reports tag %q", h.Tag())
+	}
+	if h.GetUser(context.Background(), "alice@example.com") == nil {
+		t.Fatal("user is not known by its email")
+	}
+}
@@ -0,0 +1,122 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/xtls/xray-core/common"
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/proxy/reflex/inbound"
+)
+
+func TestReflexTimestampEcho(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 32)
+	client, _ := reflex.NewClientSession(key)
+	server, _ := reflex.NewServerSession(key)
+	client.SetTimestamps(true)
+	server.SetTimestamps(true)
+
+	// The first DATA frame is preceded by a stamp, which asks for nothing.
+	var up, down bytes.Buffer
+	if err := client.WriteFrame(&up, reflex.FrameTypeData, []byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	f, err := server.ReadFrame(&up)
+	if err != nil || f.Type != reflex.FrameTypeTimingCtrl {
+		t.Fatalf("expected a stamp, got %v, %v", f, err)
+	}
+	profile := &reflex.TrafficProfile{Name: "test"}
+	profile.SetNextDelay(time.Second)
+	reflex.ApplyControlFrame(profile, f.Type, f.Payload)
+	if d := profile.GetDelay(); d != time.Second {
+		t.Fatalf("a stamp changed the next delay to %v", d)
+	}
+	if f, err = server.ReadFrame(&up); err != nil || string(f.Payload) != "ping" {
+		t.Fatalf("expected the data, got %v, %v", f, err)
+	}
+	if q := server.PathQuality(); q.Samples != 1 || q.RTT != 0 {
+		t.Fatalf("after one stamp: %+v", q)
+	}
+
+	// The answer echoes it; the time the server held it is not counted.
+	time.Sleep(50 * time.Millisecond)
+	if err := server.WriteFrame(&down, reflex.FrameTypeData, []byte("pong")); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		if f, err = client.ReadFrame(&down); err != nil {
+			t.Fatal(err)
+		}
+		if f.Type == reflex.FrameTypeData {
+			break
+		}
+	}
+	q := client.Stats().Path
+	if q.Samples != 1 || q.RTT >= 40*time.Millisecond || q.MinRTT != q.RTT {
+		t.Fatalf("after the echo: %+v", q)
+	}
+
+	// A stamp is due once per interval, and control frames always carry one.
+	if err := client.WriteFrame(&up, reflex.FrameTypeData, []byte("again")); err != nil {
+		t.Fatal(err)
+	}
+	if f, _ = server.ReadFrame(&up); f.Type != reflex.FrameTypeData {
+		t.Fatalf("a stamp was sent before its interval, got frame %d", f.Type)
+	}
+	if err := client.WriteFrame(&up, reflex.FrameTypePaddingCtrl, reflex.PaddingControl(900)); err != nil {
+		t.Fatal(err)
+	}
+	if f, _ = server.ReadFrame(&up); len(f.Payload) <= 2 {
+		t.Fatalf("control frame is not stamped: %x", f.Payload)
+	}
+	reflex.ApplyControlFrame(profile, f.Type, f.Payload)
+	if size := profile.GetPacketSize(); size != 900 {
+		t.Fatalf("stamped control frame was not applied: %d", size)
+	}
+	if server.PathQuality().Samples != 2 {
+		t.Fatal("the stamped control frame was not sampled")
+	}
+
+	// Sessions that do not stamp send their frames as before.
+	plain, _ := reflex.NewClientSession(key)
+	var b bytes.Buffer
+	_ = plain.WriteFrame(&b, reflex.FrameTypeData, []byte("ping"))
+	peer, _ := reflex.NewServerSession(key)
+	if f, _ := peer.ReadFrame(&b); f.Type != reflex.FrameTypeData || b.Len() != 0 {
+		t.Fatal("a session that does not stamp sent a stamp")
+	}
+}
+
+func TestReflexTimestampsGranted(t *testing.T) {
+	u := uuid.New()
+	handler, err := inbound.New(context.Background(), &reflex.InboundConfig{
+		Clients:      []*reflex.User{{Id: u.String()}},
+		DrainTimeout: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer handler.(common.Closable).Close()
+	addr := serveReflexReplyPort(t, handler, "pong")
+
+	c, err := dialReflexClientWith(t, addr, &reflex.ClientOptions{
+		UserID: u,
+		Policy: &reflex.PolicyReq{Features: []string{reflex.FeatureTimestamps}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.Grant.HasFeature(reflex.FeatureTimestamps) {
+		t.Fatalf("timestamps not granted: %+v", c.Grant)
+	}
+	pingReflexSession(t, c)
+	time.Sleep(reflex.TimestampInterval)
+	pingReflexSession(t, c)
+	if q := c.Stats().Path; q.Samples == 0 || q.RTT <= 0 {
+		t.Fatalf("no path estimates: %+v", q)
+	}
+}
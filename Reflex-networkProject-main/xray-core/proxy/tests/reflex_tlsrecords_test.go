@@ -0,0 +1,137 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/xtls/xray-core/common"
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/proxy/reflex/frame"
+	"github.com/xtls/xray-core/proxy/reflex/inbound"
+)
+
+// writeRecorder keeps every Write made on the connection.
+type writeRecorder struct {
+	net.Conn
+	writes [][]byte
+}
+
+func (c *writeRecorder) Write(b []byte) (int, error) {
+	c.writes = append(c.writes, append([]byte(nil), b...))
+	return c.Conn.Write(b)
+}
+
+func TestReflexTLSRecordsSplitLargeData(t *testing.T) {
+	key := make([]byte, 32)
+	_, _ = rand.Read(key)
+	client, err := reflex.NewClientSession(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := reflex.NewServerSession(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetTLSRecords(true)
+	server.SetTLSRecords(true)
+
+	payload := make([]byte, 40000)
+	_, _ = rand.Read(payload)
+	var wire bytes.Buffer
+	if err := client.WriteFrame(&wire, reflex.FrameTypeData, payload); err != nil {
+		t.Fatal(err)
+	}
+
+	records := 0
+	for rest := wire.Bytes(); len(rest) > 0; records++ {
+		if len(rest) < frame.TLSHeaderSize || rest[0] != 0x17 || rest[1] != 0x03 || rest[2] != 0x03 {
+			t.Fatalf("record %d does not look like TLS application data: % x", records, rest[:min(len(rest), 5)])
+		}
+		n := int(binary.BigEndian.Uint16(rest[3:]))
+		if n > frame.MaxTLSRecordSize {
+			t.Fatalf("record %d is %d bytes, over the TLS limit", records, n)
+		}
+		rest = rest[frame.TLSHeaderSize+n:]
+	}
+	if records != 3 {
+		t.Fatalf("expected the payload in 3 records, got %d", records)
+	}
+
+	var got []byte
+	for wire.Len() > 0 {
+		f, err := server.ReadFrame(&wire)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, f.Payload...)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Fatal("payload was not reassembled")
+	}
+
+	if err := client.WriteFrame(&wire, reflex.FrameTypePaddingCtrl, payload); err == nil {
+		t.Fatal("oversized control frame must be refused")
+	}
+}
+
+func TestReflexTLSRecordsRejectPlainFrames(t *testing.T) {
+	key := make([]byte, 32)
+	_, _ = rand.Read(key)
+	client, _ := reflex.NewClientSession(key)
+	server, _ := reflex.NewServerSession(key)
+	server.SetTLSRecords(true)
+
+	var wire bytes.Buffer
+	if err := client.WriteFrame(&wire, reflex.FrameTypeData, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.ReadFrame(&wire); err == nil {
+		t.Fatal("frame without a TLS record header was accepted")
+	}
+}
+
+func TestReflexTLSRecordsNegotiated(t *testing.T) {
+	userID := uuid.New()
+	handler, err := inbound.New(context.Background(), &reflex.InboundConfig{
+		Clients:      []*reflex.User{{Id: userID.String()}},
+		DrainTimeout: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer handler.(common.Closable).Close()
+	addr := serveReflexReplyPort(t, handler, "pong")
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	rec := &writeRecorder{Conn: conn}
+	c, err := reflex.ClientHandshake(rec, &reflex.ClientOptions{
+		UserID: userID,
+		Policy: &reflex.PolicyReq{Features: []string{reflex.FeatureTLSRecords}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.Grant.HasFeature(reflex.FeatureTLSRecords) {
+		t.Fatal("tls-records was not granted")
+	}
+	pingReflexSession(t, c)
+
+	if len(rec.writes) != 2 {
+		t.Fatalf("expected the handshake and one frame, got %d writes", len(rec.writes))
+	}
+	if f := rec.writes[1]; !bytes.HasPrefix(f, []byte{0x17, 0x03, 0x03}) {
+		t.Fatalf("frame was not sent as a TLS record: % x", f[:5])
+	}
+}
@@ -0,0 +1,91 @@
+package tests
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/xtls/xray-core/common"
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/proxy/reflex/inbound"
+)
+
+func readReflexTranscript(t *testing.T, b []byte) []reflex.TranscriptEntry {
+	t.Helper()
+	var entries []reflex.TranscriptEntry
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		var e reflex.TranscriptEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("transcript line %q: %v", scanner.Text(), err)
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func TestReflexSessionTranscript(t *testing.T) {
+	userID := uuid.New()
+	dir := t.TempDir()
+	handler, err := inbound.New(context.Background(), &reflex.InboundConfig{
+		Clients:       []*reflex.User{{Id: userID.String()}},
+		TranscriptDir: dir,
+		DrainTimeout:  1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := serveReflexReplyPort(t, handler, "pong")
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	var clientTranscript bytes.Buffer
+	c, err := reflex.ClientHandshake(conn, &reflex.ClientOptions{UserID: userID, Transcript: &clientTranscript})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pingReflexSession(t, c)
+	_ = conn.Close()
+	if err := handler.(common.Closable).Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.jsonl"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("found transcripts %v: %v", files, err)
+	}
+	raw, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(raw, []byte("ping")) || bytes.Contains(raw, []byte("pong")) {
+		t.Fatal("transcript holds payload")
+	}
+	server := readReflexTranscript(t, raw)
+	if len(server) < 4 || server[0].Event != reflex.TranscriptStart || server[len(server)-1].Event != reflex.TranscriptEnd {
+		t.Fatalf("server transcript %+v", server)
+	}
+	if e := server[1]; e.Dir != reflex.TranscriptRecv || e.Type != "DATA" || e.Size != 4 {
+		t.Fatalf("first frame recorded as %+v", e)
+	}
+	// The reply is padded, as PADDED_DATA.
+	if e := server[2]; e.Dir != reflex.TranscriptSent || e.Type != "PADDED_DATA" || e.AtMs < server[1].AtMs {
+		t.Fatalf("reply recorded as %+v", e)
+	}
+
+	client := readReflexTranscript(t, clientTranscript.Bytes())
+	if len(client) != 3 || client[1].Dir != reflex.TranscriptSent || client[2].Dir != reflex.TranscriptRecv {
+		t.Fatalf("client transcript %+v", client)
+	}
+}
@@ -0,0 +1,177 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/xtls/xray-core/common"
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/proxy/reflex/inbound"
+)
+
+func TestReflexTransportTelemetry(t *testing.T) {
+	tt := reflex.NewTransportTelemetry()
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		tt.Record("198.51.100.0/24", reflex.TransportMagic, reflex.TransportFailed, start)
+	}
+	tt.Record("198.51.100.0/24", reflex.TransportFronted, reflex.TransportCompleted, start.Add(time.Minute))
+	tt.Record("198.51.100.0/24", reflex.TransportMagic, reflex.TransportCompleted, start.Add(time.Hour))
+	tt.Record("198.51.100.0/24", reflex.TransportMagic, reflex.TransportRejected, start.Add(time.Hour))
+	tt.Record("203.0.113.0/24", reflex.TransportMagic, reflex.TransportCompleted, start.Add(time.Hour+time.Second))
+	tt.Record("203.0.113.0/24", reflex.TransportMagic, reflex.TransportReset, start.Add(time.Hour+time.Second))
+
+	now := start.Add(time.Hour + time.Minute)
+	networks := tt.Networks(now)
+	if len(networks) != 2 || networks[0].Network != "203.0.113.0/24" {
+		t.Fatalf("networks: %+v", networks)
+	}
+	blocked := networks[1]
+	if len(blocked.Windows) != 2 || !blocked.Windows[1].Start.Equal(start.Add(time.Hour)) {
+		t.Fatalf("windows: %+v", blocked.Windows)
+	}
+	magic := blocked.Transports[reflex.TransportMagic]
+	if magic.Attempts() != 5 || magic.SuccessRate() != 0.25 {
+		t.Fatalf("magic: %+v, rate %v", magic, magic.SuccessRate())
+	}
+	if rate := blocked.Transports[reflex.TransportFronted].SuccessRate(); rate != 1 {
+		t.Fatalf("fronted rate %v", rate)
+	}
+	if rate := networks[0].Transports[reflex.TransportMagic].SuccessRate(); rate != 0 {
+		t.Fatalf("a reset handshake counted as a success: %v", rate)
+	}
+	totals := tt.Totals(now)
+	if magic := totals[reflex.TransportMagic]; magic.Completed != 2 || magic.Reset != 1 || magic.Failed != 3 {
+		t.Fatalf("totals: %+v", totals)
+	}
+	b, _ := json.Marshal(totals[reflex.TransportFronted])
+	if !strings.Contains(string(b), `"attempts":1`) || !strings.Contains(string(b), `"successRate":1`) {
+		t.Fatalf("JSON: %s", b)
+	}
+
+	// Windows older than a day are dropped, and networks left without any.
+	later := start.Add(reflex.TransportStatsWindows * reflex.TransportStatsWindow)
+	if networks := tt.Networks(later); len(networks) != 2 || len(networks[1].Windows) != 1 {
+		t.Fatalf("a day later: %+v", networks)
+	}
+	tt.Record("192.0.2.0/24", reflex.TransportPSK, reflex.TransportCompleted, later.Add(time.Hour))
+	if networks := tt.Networks(later.Add(time.Hour)); len(networks) != 1 || networks[0].Network != "192.0.2.0/24" {
+		t.Fatalf("after expiry: %+v", networks)
+	}
+}
+
+func TestReflexTransportStats(t *testing.T) {
+	u := uuid.New()
+	handler, err := inbound.New(context.Background(), &reflex.InboundConfig{
+		Clients:      []*reflex.User{{Id: u.String()}},
+		DrainTimeout: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer handler.(common.Closable).Close()
+	addr := serveReflexReplyPort(t, handler, "pong")
+
+	// Completed.
+	c, err := dialReflexClient(t, addr, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pingReflexSession(t, c)
+	// Rejected: an unknown user.
+	if _, err := dialReflexClient(t, addr, uuid.New()); err == nil {
+		t.Fatal("an unknown user completed the handshake")
+	}
+	// Failed: cut off before its end.
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs := buildReflexMagicHandshake(u, time.Now().Unix())
+	_, _ = conn.Write(hs[:len(hs)-1])
+	_ = conn.Close()
+	// Reset right after the handshake.
+	conn, err = net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := reflex.ClientHandshake(conn, &reflex.ClientOptions{UserID: u}); err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.(*net.TCPConn).SetLinger(0)
+	_ = conn.Close()
+
+	want := reflex.TransportOutcomes{Completed: 2, Rejected: 1, Failed: 1, Reset: 1}
+	for i := 0; ; i++ {
+		stats := handler.(*inbound.Handler).TransportStats()
+		if len(stats) == 1 && stats[0].Transports[reflex.TransportMagic] == want {
+			if stats[0].Network != "127.0.0.0/24" {
+				t.Fatalf("network %q", stats[0].Network)
+			}
+			break
+		}
+		if i == 100 {
+			t.Fatalf("transport stats: %+v", stats)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestReflexTransportMetrics(t *testing.T) {
+	u := uuid.New()
+	handler, err := inbound.New(context.Background(), &reflex.InboundConfig{
+		Clients:      []*reflex.User{{Id: u.String()}},
+		DrainTimeout: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer handler.(common.Closable).Close()
+	addr := serveReflexReplyPort(t, handler, "pong")
+
+	c, err := dialReflexClient(t, addr, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pingReflexSession(t, c)
+	pingReflexSession(t, c)
+	_ = c.Close()
+	if _, err := dialReflexClient(t, addr, uuid.New()); err == nil {
+		t.Fatal("an unknown user completed the handshake")
+	}
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs := buildReflexMagicHandshake(u, time.Now().Unix())
+	_, _ = conn.Write(hs[:len(hs)-1])
+	_ = conn.Close()
+
+	for i := 0; ; i++ {
+		m := handler.(*inbound.Handler).TransportMetrics()[reflex.TransportMagic]
+		if m.Sessions == 1 && m.Failures["cut off"] == 1 {
+			if m.Handshakes != 1 || m.MeanHandshake <= 0 || m.MaxHandshake != m.MeanHandshake {
+				t.Fatalf("handshakes: %+v", m)
+			}
+			if m.Failures["unknown user"] != 1 || len(m.Failures) != 2 {
+				t.Fatalf("failures: %+v", m.Failures)
+			}
+			// Two pings and their pongs.
+			if m.Bytes != 16 || m.Throughput <= 0 {
+				t.Fatalf("throughput: %+v", m)
+			}
+			break
+		}
+		if i == 100 {
+			t.Fatalf("transport metrics: %+v", m)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
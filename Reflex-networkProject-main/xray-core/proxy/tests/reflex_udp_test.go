@@ -0,0 +1,193 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xtls/xray-core/common"
+	"github.com/xtls/xray-core/common/buf"
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/common/session"
+	"github.com/xtls/xray-core/features/routing"
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/proxy/reflex/outbound"
+	"github.com/xtls/xray-core/transport"
+	"github.com/xtls/xray-core/transport/pipe"
+)
+
+// datagramDispatcher is a routing.Dispatcher whose links answer each
+// datagram with "re:" and the datagram, all those read at once in one
+// MultiBuffer. It records the destinations it was asked for.
+type datagramDispatcher struct {
+	mu    sync.Mutex
+	dests []xnet.Destination
+}
+
+func (d *datagramDispatcher) Type() interface{} { return routing.DispatcherType() }
+func (d *datagramDispatcher) Start() error      { return nil }
+func (d *datagramDispatcher) Close() error      { return nil }
+
+func (d *datagramDispatcher) Dispatch(ctx context.Context, dest xnet.Destination) (*transport.Link, error) {
+	d.mu.Lock()
+	d.dests = append(d.dests, dest)
+	d.mu.Unlock()
+	upReader, upWriter := pipe.New(pipe.WithoutSizeLimit())
+	downReader, downWriter := pipe.New(pipe.WithoutSizeLimit())
+	go func() {
+		defer downWriter.Close()
+		for {
+			mb, err := upReader.ReadMultiBuffer()
+			var replies buf.MultiBuffer
+			for _, b := range mb {
+				reply := buf.New()
+				reply.WriteString("re:")
+				reply.Write(b.Bytes())
+				replies = append(replies, reply)
+			}
+			buf.ReleaseMulti(mb)
+			if !replies.IsEmpty() {
+				_ = downWriter.WriteMultiBuffer(replies)
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return &transport.Link{Reader: downReader, Writer: upWriter}, nil
+}
+
+func (d *datagramDispatcher) DispatchLink(ctx context.Context, dest xnet.Destination, link *transport.Link) error {
+	return errors.New("not implemented")
+}
+
+func (d *datagramDispatcher) destinations() []xnet.Destination {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]xnet.Destination(nil), d.dests...)
+}
+
+func TestReflexDatagrams(t *testing.T) {
+	handler, userID := newReflexTestHandlerWithClient(t)
+	dispatcher := &datagramDispatcher{}
+	addr := serveReflexDispatcher(t, handler, dispatcher)
+
+	c, err := dialReflexClientWith(t, addr, &reflex.ClientOptions{UserID: userID, UDP: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	sent := []struct{ address, data string }{
+		{"1.1.1.1:53", "query-a"},
+		{"1.1.1.1:53", "query-b"},
+		{"8.8.8.8:53", "query-c"},
+	}
+	for _, s := range sent {
+		if err := c.WriteDatagram(s.address, []byte(s.data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Each datagram comes back on its own, without the padding of its
+	// frame, saying where it came from.
+	got := make(map[string]string)
+	for range sent {
+		f, err := c.ReadFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		dg, err := reflex.ParseDatagram(f)
+		if err != nil {
+			t.Fatalf("frame %d: %v", f.Type, err)
+		}
+		got[string(dg.Data)] = dg.Destination.String()
+	}
+	for _, s := range sent {
+		if from := got["re:"+s.data]; from != s.address {
+			t.Errorf("reply to %q from %q, want %q (got %v)", s.data, from, s.address, got)
+		}
+	}
+
+	// A link per destination, both UDP.
+	dests := dispatcher.destinations()
+	if len(dests) != 2 {
+		t.Fatalf("dispatched %v, want a link per destination", dests)
+	}
+	for _, dest := range dests {
+		if dest.Network != xnet.Network_UDP {
+			t.Errorf("dispatched %v, want UDP", dest)
+		}
+	}
+
+	if err := c.WriteDatagram("1.1.1.1:53", make([]byte, c.Session.MaxPayload())); err == nil {
+		t.Error("wrote a datagram larger than a frame")
+	}
+}
+
+func TestReflexDatagramsNotGranted(t *testing.T) {
+	handler, userID := newReflexTestHandlerWithClient(t)
+	c, err := dialReflexClient(t, serveReflexDispatcher(t, handler, &datagramDispatcher{}), userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.WriteDatagram("1.1.1.1:53", []byte("query")); err == nil {
+		t.Fatal("wrote a datagram without " + reflex.FeatureUDP)
+	}
+}
+
+func TestReflexOutboundUDP(t *testing.T) {
+	handler, userID := newReflexTestHandlerWithClient(t)
+	defer handler.(common.Closable).Close()
+	dispatcher := &datagramDispatcher{}
+	server, err := xnet.ParseDestination("tcp:" + serveReflexDispatcher(t, handler, dispatcher))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Mux does not apply to UDP, which has a session of its own.
+	ob, err := outbound.New(context.Background(), &reflex.OutboundConfig{Address: "127.0.0.1", Port: uint32(server.Port), Id: userID.String(), Mux: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ob.Close()
+	upReader, upWriter := pipe.New(pipe.WithoutSizeLimit())
+	downReader, downWriter := pipe.New(pipe.WithoutSizeLimit())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	target := xnet.UDPDestination(xnet.ParseAddress("9.9.9.9"), 53)
+	ctx = session.ContextWithOutbounds(ctx, []*session.Outbound{{Target: target}})
+	done := make(chan error, 1)
+	go func() {
+		done <- ob.Process(ctx, &transport.Link{Reader: upReader, Writer: downWriter}, &countingDialer{})
+	}()
+
+	b := buf.New()
+	b.WriteString("query")
+	if err := upWriter.WriteMultiBuffer(buf.MultiBuffer{b}); err != nil {
+		t.Fatal(err)
+	}
+	mb, err := downReader.ReadMultiBuffer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer buf.ReleaseMulti(mb)
+	if len(mb) != 1 || mb[0].String() != "re:query" {
+		t.Fatalf("downlink %q, want the datagram's reply alone", mb.String())
+	}
+	if from := mb[0].UDP; from == nil || *from != target {
+		t.Fatalf("reply from %v, want %v", from, target)
+	}
+	if dests := dispatcher.destinations(); len(dests) != 1 || dests[0] != target {
+		t.Fatalf("dispatched %v, want %v", dests, target)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Process did not return once its context ended")
+	}
+}
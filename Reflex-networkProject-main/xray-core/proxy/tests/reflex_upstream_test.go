@@ -0,0 +1,133 @@
+package tests
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/xtls/xray-core/proxy/reflex"
+)
+
+// forward connects conn to addr in both directions.
+func forward(conn net.Conn, addr string) {
+	upstream, err := net.Dial("tcp", addr)
+	if err != nil {
+		_ = conn.Close()
+		return
+	}
+	go func() { _, _ = io.Copy(upstream, conn); _ = upstream.Close() }()
+	_, _ = io.Copy(conn, upstream)
+	_ = conn.Close()
+}
+
+// serveConnectProxy runs an HTTP proxy that only takes CONNECT requests with
+// the credentials user:secret.
+func serveConnectProxy(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect || r.Header.Get("Proxy-Authorization") != "Basic dXNlcjpzZWNyZXQ=" {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		_, _ = io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+		forward(conn, r.Host)
+	})}
+	go srv.Serve(ln)
+	t.Cleanup(func() { _ = srv.Close() })
+	return ln.Addr().String()
+}
+
+// serveSOCKS5Proxy runs a SOCKS5 proxy that takes CONNECT requests for IPv4
+// addresses with the credentials user:secret.
+func serveSOCKS5Proxy(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				b := make([]byte, 512)
+				// Greeting, then username/password authentication.
+				if _, err := io.ReadFull(conn, b[:2]); err != nil {
+					conn.Close()
+					return
+				}
+				_, _ = io.ReadFull(conn, b[:int(b[1])])
+				_, _ = conn.Write([]byte{5, 2})
+				_, _ = io.ReadFull(conn, b[:2])
+				n := int(b[1])
+				_, _ = io.ReadFull(conn, b[:n+1])
+				user := string(b[:n])
+				n = int(b[n])
+				_, _ = io.ReadFull(conn, b[:n])
+				password := string(b[:n])
+				if user != "user" || password != "secret" {
+					_, _ = conn.Write([]byte{1, 1})
+					conn.Close()
+					return
+				}
+				_, _ = conn.Write([]byte{1, 0})
+				if _, err := io.ReadFull(conn, b[:10]); err != nil || b[3] != 1 {
+					conn.Close()
+					return
+				}
+				addr := (&net.TCPAddr{IP: net.IP(b[4:8]), Port: int(b[8])<<8 | int(b[9])}).String()
+				_, _ = conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+				forward(conn, addr)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestReflexUpstreamProxy(t *testing.T) {
+	handler, userID := newReflexTestHandlerWithClient(t)
+	addr := serveReflexReplyPort(t, handler, "pong")
+	httpProxy, socksProxy := serveConnectProxy(t), serveSOCKS5Proxy(t)
+
+	for _, c := range []struct {
+		proxy string
+		ok    bool
+	}{
+		{"http://user:secret@" + httpProxy, true},
+		{"socks5://user:secret@" + socksProxy, true},
+		{"http://user:wrong@" + httpProxy, false},
+		{"socks5://user:wrong@" + socksProxy, false},
+		{"ftp://" + httpProxy, false},
+	} {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		dial := reflex.NewTunnelDialer(addr, &reflex.ClientOptions{UserID: userID, UpstreamProxy: c.proxy})
+		conn, err := dial(ctx, "example.com:443")
+		cancel()
+		if !c.ok {
+			if err == nil {
+				conn.Close()
+				t.Fatalf("session through %s established", c.proxy)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("through %s: %v", c.proxy, err)
+		}
+		pingReflexSession(t, conn)
+		conn.Close()
+	}
+}
@@ -0,0 +1,79 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/xtls/xray-core/common"
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/proxy/reflex/inbound"
+)
+
+func TestReflexUsageReports(t *testing.T) {
+	u := uuid.New()
+	expiry := time.Now().Add(time.Hour).Unix()
+	handler, err := inbound.New(context.Background(), &reflex.InboundConfig{
+		Clients:       []*reflex.User{{Id: u.String(), Quota: 1000, Expiry: expiry}},
+		UsageInterval: 1,
+		DrainTimeout:  1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer handler.(common.Closable).Close()
+	addr := serveReflexReplyPort(t, handler, "pong")
+
+	reports := make(chan *reflex.UsageReport, 8)
+	c, err := dialReflexClientWith(t, addr, &reflex.ClientOptions{
+		UserID:       u,
+		UsageReports: true,
+		OnUsage:      func(r *reflex.UsageReport) { reports <- r },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The first report comes at the start of the session.
+	pingReflexSession(t, c)
+	r, ok := c.Usage()
+	if !ok || r.Used != 0 || r.Quota != 1000 || r.Remaining != 1000 || r.Expiry != expiry {
+		t.Fatalf("first report: %+v", r)
+	}
+	<-reports
+
+	// Later ones come with traffic, at most once per interval.
+	pingReflexSession(t, c)
+	select {
+	case r := <-reports:
+		t.Fatalf("report within the interval: %+v", r)
+	default:
+	}
+	time.Sleep(time.Second)
+	pingReflexSession(t, c)
+	pingReflexSession(t, c)
+	used := handler.(*inbound.Handler).Usage(u.String())
+	if r, _ := c.Usage(); r.Used == 0 || r.Used > used || r.Remaining != 1000-r.Used {
+		t.Fatalf("report after traffic: %+v, %d used", r, used)
+	}
+
+	// Once the quota is used up, the session ends, before the answer to
+	// what used it up.
+	if err := c.WriteFrame(reflex.FrameTypeData, bytes.Repeat([]byte{'x'}, 1000)); err != nil {
+		t.Fatal(err)
+	}
+	expectReflexTerminated(t, c, reflex.CloseReasonQuota)
+
+	// Until the usage is reset.
+	handler.(*inbound.Handler).ResetUsage(u.String())
+	c, err = dialReflexClient(t, addr, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pingReflexSession(t, c)
+	if _, ok := c.Usage(); ok {
+		t.Fatal("a client that did not ask got a usage report")
+	}
+}
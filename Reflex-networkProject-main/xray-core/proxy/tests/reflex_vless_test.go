@@ -0,0 +1,171 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/common/protocol"
+	"github.com/xtls/xray-core/common/serial"
+	"github.com/xtls/xray-core/common/uuid"
+	"github.com/xtls/xray-core/core"
+	feature_inbound "github.com/xtls/xray-core/features/inbound"
+	"github.com/xtls/xray-core/features/routing"
+	"github.com/xtls/xray-core/proxy"
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/proxy/reflex/inbound"
+	"github.com/xtls/xray-core/proxy/vless"
+	"github.com/xtls/xray-core/transport/internet/stat"
+)
+
+// fakeVLESSInbound stands in for a VLESS inbound: it has users and answers
+// whatever it is handed with the request it read.
+type fakeVLESSInbound struct {
+	users []*protocol.MemoryUser
+}
+
+func (f *fakeVLESSInbound) Network() []xnet.Network { return []xnet.Network{xnet.Network_TCP} }
+
+func (f *fakeVLESSInbound) Process(ctx context.Context, network xnet.Network, conn stat.Connection, dispatcher routing.Dispatcher) error {
+	defer conn.Close()
+	buf := make([]byte, 64)
+	n, err := io.ReadAtLeast(conn, buf, 1+16+1)
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write(append([]byte("vless:"), buf[:n]...))
+	return err
+}
+
+func (f *fakeVLESSInbound) AddUser(context.Context, *protocol.MemoryUser) error  { return nil }
+func (f *fakeVLESSInbound) RemoveUser(context.Context, string) error             { return nil }
+func (f *fakeVLESSInbound) GetUser(context.Context, string) *protocol.MemoryUser { return nil }
+func (f *fakeVLESSInbound) GetUsers(context.Context) []*protocol.MemoryUser      { return f.users }
+func (f *fakeVLESSInbound) GetUsersCount(context.Context) int64                  { return int64(len(f.users)) }
+
+type fakeInboundHandler struct {
+	tag string
+	in  proxy.Inbound
+}
+
+func (f *fakeInboundHandler) Start() error                           { return nil }
+func (f *fakeInboundHandler) Close() error                           { return nil }
+func (f *fakeInboundHandler) Tag() string                            { return f.tag }
+func (f *fakeInboundHandler) ReceiverSettings() *serial.TypedMessage { return nil }
+func (f *fakeInboundHandler) ProxySettings() *serial.TypedMessage    { return nil }
+func (f *fakeInboundHandler) GetInbound() proxy.Inbound              { return f.in }
+
+type fakeInboundManager struct {
+	handlers map[string]feature_inbound.Handler
+}
+
+func (m *fakeInboundManager) Type() interface{} { return feature_inbound.ManagerType() }
+func (m *fakeInboundManager) Start() error      { return nil }
+func (m *fakeInboundManager) Close() error      { return nil }
+
+func (m *fakeInboundManager) GetHandler(ctx context.Context, tag string) (feature_inbound.Handler, error) {
+	if h, ok := m.handlers[tag]; ok {
+		return h, nil
+	}
+	return nil, errors.New("no such inbound")
+}
+
+func (m *fakeInboundManager) AddHandler(ctx context.Context, h feature_inbound.Handler) error {
+	m.handlers[h.Tag()] = h
+	return nil
+}
+
+func (m *fakeInboundManager) RemoveHandler(ctx context.Context, tag string) error {
+	delete(m.handlers, tag)
+	return nil
+}
+
+func (m *fakeInboundManager) ListHandlers(context.Context) []feature_inbound.Handler {
+	var handlers []feature_inbound.Handler
+	for _, h := range m.handlers {
+		handlers = append(handlers, h)
+	}
+	return handlers
+}
+
+func TestReflexServesVLESSClients(t *testing.T) {
+	id := protocol.NewID(uuid.New())
+	legacy := &fakeVLESSInbound{users: []*protocol.MemoryUser{{Email: "legacy", Account: &vless.MemoryAccount{ID: id}}}}
+
+	instance, err := core.New(&core.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager := &fakeInboundManager{handlers: map[string]feature_inbound.Handler{}}
+	_ = manager.AddHandler(context.Background(), &fakeInboundHandler{tag: "vless-in", in: legacy})
+	if err := instance.AddFeature(manager); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ctx = context.WithValue(ctx, core.XrayKey(1), instance)
+
+	cover, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cover.Close()
+	go func() {
+		for {
+			conn, err := cover.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(io.Discard, io.LimitReader(conn, 1))
+				_, _ = conn.Write([]byte("cover"))
+			}()
+		}
+	}()
+	handler, err := inbound.New(ctx, &reflex.InboundConfig{
+		VlessInbound: "vless-in",
+		Fallback:     &reflex.Fallback{Dest: uint32(cover.Addr().(*net.TCPAddr).Port)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exchange := func(request []byte) []byte {
+		t.Helper()
+		client, server := net.Pipe()
+		defer client.Close()
+		go func() {
+			_ = handler.Process(ctx, xnet.Network_TCP, stat.Connection(server), nil)
+		}()
+		go func() { _, _ = client.Write(request) }()
+		_ = client.SetReadDeadline(time.Now().Add(5 * time.Second))
+		buf := make([]byte, 128)
+		n, _ := io.ReadAtLeast(client, buf, 5)
+		return buf[:n]
+	}
+
+	// A VLESS request of a VLESS user is handed over, bytes already read included.
+	request := append(append([]byte{0}, id.Bytes()...), 0, 1, 0x01, 0xbb, 1, 127, 0, 0, 1)
+	if got := exchange(request); !bytes.Equal(got, append([]byte("vless:"), request...)) {
+		t.Fatalf("VLESS client got %q", got)
+	}
+
+	// An unknown ID is not, and is answered like any probe.
+	other := uuid.New()
+	probe := append(append([]byte{0}, other.Bytes()...), make([]byte, 64)...)
+	if got := exchange(probe); string(got) != "cover" {
+		t.Fatalf("unknown VLESS user got %q", got)
+	}
+}
+
+func TestReflexVLESSInboundNeedsInstance(t *testing.T) {
+	if _, err := inbound.New(context.Background(), &reflex.InboundConfig{VlessInbound: "vless-in"}); err == nil {
+		t.Fatal("a VLESS inbound was configured without an instance")
+	}
+}
@@ -0,0 +1,87 @@
+package tests
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/xtls/xray-core/proxy/reflex"
+)
+
+// largestReadReader records the largest buffer it was asked to fill.
+type largestReadReader struct {
+	r       io.Reader
+	largest int
+}
+
+func (r *largestReadReader) Read(p []byte) (int, error) {
+	r.largest = max(r.largest, len(p))
+	return r.r.Read(p)
+}
+
+func TestReflexWriteFrameFrom(t *testing.T) {
+	key := make([]byte, 32)
+	_, _ = rand.Read(key)
+	client, err := reflex.NewClientSession(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := reflex.NewServerSession(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload := make([]byte, 3<<20)
+	_, _ = rand.Read(payload)
+	src := &largestReadReader{r: bytes.NewReader(payload)}
+	var wire bytes.Buffer
+	n, err := client.WriteFrameFrom(&wire, src, int64(len(payload)))
+	if err != nil || n != int64(len(payload)) {
+		t.Fatalf("wrote %d bytes, %v", n, err)
+	}
+	if src.largest > client.MaxPayload() {
+		t.Fatalf("read %d bytes at once, more than a frame", src.largest)
+	}
+
+	var got []byte
+	frames := 0
+	for wire.Len() > 0 {
+		f, err := server.ReadFrame(&wire)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f.Type != reflex.FrameTypeData {
+			t.Fatalf("unexpected frame type %d", f.Type)
+		}
+		got = append(got, f.Payload...)
+		frames++
+	}
+	if !bytes.Equal(got, payload) {
+		t.Fatal("payload was not reassembled")
+	}
+	if want := (len(payload) + client.MaxPayload() - 1) / client.MaxPayload(); frames != want {
+		t.Fatalf("expected %d frames, got %d", want, frames)
+	}
+
+	// A reader that ends early is reported; what it had is still sent.
+	n, err = client.WriteFrameFrom(&wire, bytes.NewReader(payload[:1000]), 2000)
+	if !errors.Is(err, io.ErrUnexpectedEOF) || n != 1000 {
+		t.Fatalf("short reader: wrote %d bytes, %v", n, err)
+	}
+	// A negative length writes until the reader ends.
+	n, err = client.WriteFrameFrom(&wire, bytes.NewReader(payload[:1000]), -1)
+	if err != nil || n != 1000 {
+		t.Fatalf("unbounded write: wrote %d bytes, %v", n, err)
+	}
+	for i := 0; i < 2; i++ {
+		f, err := server.ReadFrame(&wire)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(f.Payload, payload[:1000]) {
+			t.Fatalf("frame %d carries the wrong bytes", i)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package tests
+
+import (
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/xtls/xray-core/proxy/reflex"
+)
+
+func TestReflexWriteTimeout(t *testing.T) {
+	key := make([]byte, 32)
+	_, _ = rand.Read(key)
+	s, err := reflex.NewServerSession(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetWriteTimeout(50 * time.Millisecond)
+
+	// Nobody reads the other end: the write must give up.
+	conn, peer := net.Pipe()
+	defer conn.Close()
+	defer peer.Close()
+	start := time.Now()
+	profile := reflex.Profiles["http2-api"]
+	err = reflex.WriteFrameWithMorphing(s, conn, reflex.FrameTypeData, []byte("stalled"), profile)
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected a deadline error, got %v", err)
+	}
+	if took := time.Since(start); took > time.Second {
+		t.Fatalf("the write was given up after %v", took)
+	}
+
+	// The deadline does not outlive the frame it was set for.
+	time.Sleep(100 * time.Millisecond)
+	go func() { _, _ = io.Copy(io.Discard, peer) }()
+	if err := s.WriteFrame(conn, reflex.FrameTypeData, []byte("read")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReflexWriteTimeoutGranted(t *testing.T) {
+	engine := reflex.NewPolicyEngine(nil)
+	engine.SetRule("slow", &reflex.PolicyRule{WriteTimeout: 5})
+	if got := engine.Evaluate("slow", 0, &reflex.PolicyReq{}).FrameWriteTimeout(); got != 5*time.Second {
+		t.Fatalf("granted write timeout %v", got)
+	}
+	if got := engine.Evaluate("", 0, &reflex.PolicyReq{}).FrameWriteTimeout(); got != reflex.DefaultWriteTimeout {
+		t.Fatalf("default write timeout %v", got)
+	}
+}